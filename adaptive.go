@@ -0,0 +1,126 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"time"
+)
+
+const (
+	// DefaultBufferSize is the initial task buffer size when adaptive buffers are enabled
+	// and no task buffer size is configured for the node.
+	DefaultBufferSize = 64
+	// DefaultBufferMaxBytes is the default memory budget for all task buffers in a stream.
+	DefaultBufferMaxBytes = 64 << 20
+)
+
+// bufferController adapts the size of task buffers based on their occupancy.
+// Buffers that are mostly full are grown as long as the estimated size of all
+// task buffers in the stream fits the memory budget, and buffers that are mostly
+// empty are shrunk. The controller is configured under <stream>.buffers:
+// adaptive enables the controller, maxbytes sets the memory budget, min and max
+// bound the buffer sizes and interval sets how often the buffers are sampled.
+type bufferController struct {
+	stream   *Stream
+	min      int
+	max      int
+	maxBytes int64
+	interval time.Duration
+	donech   chan struct{}
+	stopped  chan struct{}
+}
+
+func newBufferController(s *Stream) (bc *bufferController) {
+	bc = &bufferController{}
+	bc.stream = s
+	bc.min = s.config.Get(s.name, "buffers", "min").Int(1)
+	bc.max = s.config.Get(s.name, "buffers", "max").Int(4096)
+	bc.maxBytes = s.config.Get(s.name, "buffers", "maxbytes").Int64(DefaultBufferMaxBytes)
+	bc.interval = s.config.Get(s.name, "buffers", "interval").Duration(time.Second)
+	bc.donech = make(chan struct{})
+	bc.stopped = make(chan struct{})
+	return bc
+}
+
+func (bc *bufferController) start() {
+	go func() {
+		defer close(bc.stopped)
+
+		ticker := time.NewTicker(bc.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-bc.donech:
+				return
+			case <-ticker.C:
+				bc.adapt()
+			}
+		}
+	}()
+}
+
+func (bc *bufferController) stop() {
+	close(bc.donech)
+	<-bc.stopped
+}
+
+type bufferSample struct {
+	tasks  *tasks
+	idx    int
+	length int
+	size   int
+}
+
+// adapt samples the task buffers occupancy and resizes them
+func (bc *bufferController) adapt() {
+	var samples []bufferSample
+	var total int64
+
+	for _, st := range bc.stream.tasks {
+		st.RLock()
+		for idx, buffer := range st.buffers {
+			samples = append(samples, bufferSample{st, idx, len(buffer), cap(buffer)})
+			total += int64(cap(buffer)) * st.avgRecordSize()
+		}
+		st.RUnlock()
+	}
+
+	for _, sample := range samples {
+		size := sample.size
+		recordSize := sample.tasks.avgRecordSize()
+
+		switch {
+		case sample.length*10 >= size*9 && size*2 <= bc.max:
+			grow := int64(size) * recordSize
+			if total+grow > bc.maxBytes {
+				continue
+			}
+			total += grow
+			size *= 2
+
+		case sample.length*10 <= size && size/2 >= bc.min:
+			total -= int64(size/2) * recordSize
+			size /= 2
+
+		default:
+			continue
+		}
+
+		sample.tasks.resize(sample.idx, size)
+	}
+}
@@ -0,0 +1,191 @@
+package aggregate
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sort"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+// Emission policies
+const (
+	// EmitOnUpdate forwards the window aggregate on every update
+	EmitOnUpdate = "update"
+	// EmitOnClose forwards only the final window aggregate when the window closes
+	EmitOnClose = "close"
+	// EmitPeriodic forwards the updated window aggregates on every interval
+	// of stream time and when the window closes
+	EmitPeriodic = "periodic"
+)
+
+const (
+	// DefaultWindow is the default aggregation window size
+	DefaultWindow = time.Minute
+)
+
+var (
+	errLateRecord    = streams.Errorf(streams.CodeRecord, "record for closed window")
+	errInvalidPolicy = streams.Errorf(streams.CodeConfig, "invalid emission policy")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Windowed)(nil)
+var _ streams.Processor = (*Windowed)(nil)
+
+// Aggregator adds the value to the aggregate, which is nil for a new window
+type Aggregator func(aggregate, value streams.Encoder) (result streams.Encoder)
+
+// Windowed aggregates record values per key within tumbling windows of stream
+// time, the highest record time seen. A window closes once the stream time
+// reaches its end, records for closed windows are dropped with an error.
+// Aggregates are forwarded with the record key and topic and the window start
+// time according to the emission policy set with <stream>.<node>.emit.policy,
+// with the <stream>.<node>.emit.interval for the periodic policy.
+// The window size can be set with <stream>.<node>.window.
+type Windowed struct {
+	size       time.Duration
+	policy     string
+	interval   time.Duration
+	aggregator Aggregator
+	windows    map[windowKey]*window
+	streamTime time.Time
+	emitted    time.Time
+}
+
+type windowKey struct {
+	key   string
+	start int64
+}
+
+type window struct {
+	topic string
+	key   []byte
+	start time.Time
+	value streams.Encoder
+	dirty bool
+}
+
+// WindowedSupplier for a Windowed aggregation with the given window size and emission policy
+func WindowedSupplier(size time.Duration, policy string, aggregator Aggregator) streams.ProcessorSupplier {
+	return func() (processor streams.Processor) {
+		return &Windowed{size: size, policy: policy, aggregator: aggregator}
+	}
+}
+
+// Init the aggregation
+func (w *Windowed) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	w.size = config.Get("window").Duration(w.size)
+	w.policy = config.Get("emit", "policy").String(w.policy)
+	w.interval = config.Get("emit", "interval").Duration(w.size)
+
+	if w.size <= 0 {
+		w.size = DefaultWindow
+	}
+
+	switch w.policy {
+	case "":
+		w.policy = EmitOnUpdate
+	case EmitOnUpdate, EmitOnClose, EmitPeriodic:
+	default:
+		return errInvalidPolicy
+	}
+
+	w.windows = make(map[windowKey]*window)
+	return nil
+}
+
+// Process adds the record to its window aggregate and emits the aggregates
+// according to the emission policy
+func (w *Windowed) Process(pc streams.ProcessorContext, record streams.Record) {
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	start := record.Time.Truncate(w.size)
+	if !start.Add(w.size).After(w.streamTime) {
+		pc.Error(errLateRecord, record)
+		return
+	}
+
+	wk := windowKey{key: string(key), start: start.UnixNano()}
+	win, exists := w.windows[wk]
+	if !exists {
+		win = &window{topic: record.Topic, key: append([]byte(nil), key...), start: start}
+		w.windows[wk] = win
+	}
+
+	win.value = w.aggregator(win.value, record.Value)
+	win.dirty = true
+
+	if w.policy == EmitOnUpdate {
+		w.emit(pc, win)
+	}
+
+	if record.Time.After(w.streamTime) {
+		w.streamTime = record.Time
+	}
+	w.advance(pc)
+}
+
+// advance emits the periodic aggregates and closes the windows ended by the stream time
+func (w *Windowed) advance(pc streams.ProcessorContext) {
+	if w.emitted.IsZero() {
+		w.emitted = w.streamTime
+	}
+
+	periodic := w.policy == EmitPeriodic && w.streamTime.Sub(w.emitted) >= w.interval
+	if periodic {
+		w.emitted = w.streamTime
+	}
+
+	var emit []*window
+	for wk, win := range w.windows {
+		closed := !win.start.Add(w.size).After(w.streamTime)
+		if closed {
+			delete(w.windows, wk)
+		}
+
+		if win.dirty && (periodic || (closed && w.policy != EmitOnUpdate)) {
+			emit = append(emit, win)
+		}
+	}
+
+	sort.Slice(emit, func(i, j int) bool {
+		if emit[i].start.Equal(emit[j].start) {
+			return string(emit[i].key) < string(emit[j].key)
+		}
+		return emit[i].start.Before(emit[j].start)
+	})
+
+	for _, win := range emit {
+		w.emit(pc, win)
+	}
+}
+
+// emit forwards the window aggregate
+func (w *Windowed) emit(pc streams.ProcessorContext, win *window) {
+	win.dirty = false
+	record := streams.NewRecord(win.topic, streams.ByteEncoder(win.key), win.value, win.start, nil)
+	if err := pc.Forward(record); err != nil {
+		pc.Error(err, record)
+	}
+}
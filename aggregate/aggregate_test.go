@@ -0,0 +1,81 @@
+package aggregate
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func count(aggregate, value streams.Encoder) streams.Encoder {
+	if aggregate == nil {
+		return streams.StringEncoder("1")
+	}
+	b, _ := aggregate.Encode()
+	n, _ := strconv.Atoi(string(b))
+	return streams.StringEncoder(strconv.Itoa(n + 1))
+}
+
+func TestWindowedEmission(t *testing.T) {
+	base := time.Unix(0, 0)
+	at := func(seconds int) time.Time {
+		return base.Add(time.Duration(seconds) * time.Second)
+	}
+
+	cases := []struct {
+		policy   string
+		expected []string
+	}{
+		{EmitOnUpdate, []string{"a:1", "a:2", "a:3", "a:4", "a:1"}},
+		{EmitOnClose, []string{"a:4"}},
+		{EmitPeriodic, []string{"a:3", "a:4"}},
+	}
+
+	for _, c := range cases {
+		config := streams.NewConfig(nil)
+		config.Set("20s", "stream", "agg", "emit", "interval")
+
+		pc := &mock.Context{Data: mock.ContextData{
+			Active: true, StreamName: "stream", NodeName: "agg", Config: config}}
+		agg := WindowedSupplier(time.Minute, c.policy, count)()
+		assert.NoError(t, agg.(streams.Initializer).Init(pc))
+
+		for _, ts := range []int{0, 10, 30, 50, 60} {
+			agg.Process(pc, streams.NewRecord("topic", streams.StringEncoder("a"), nil, at(ts), nil))
+		}
+
+		// late records are dropped
+		agg.Process(pc, streams.NewRecord("topic", streams.StringEncoder("a"), nil, at(5), nil))
+		assert.Equal(t, 1, pc.Data.ErrorCount, c.policy)
+
+		var emitted []string
+		for _, record := range pc.Data.Forwarded {
+			k, _ := record.EncodeKey()
+			v, _ := record.EncodeValue()
+			emitted = append(emitted, string(k)+":"+string(v))
+		}
+		assert.Equal(t, c.expected, emitted, c.policy)
+	}
+
+	pc := &mock.Context{Data: mock.ContextData{Config: streams.NewConfig(nil)}}
+	assert.Error(t, WindowedSupplier(time.Minute, "invalid", count)().(streams.Initializer).Init(pc))
+}
@@ -0,0 +1,389 @@
+package aggregate
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+import (
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+const (
+	// DefaultInterval is the default interval of stream time between summaries
+	DefaultInterval = time.Minute
+	// DefaultPrecision is the default HyperLogLog precision
+	DefaultPrecision = 14
+	// DefaultK is the default number of top items
+	DefaultK = 10
+	// DefaultDepth is the default Count-Min sketch depth
+	DefaultDepth = 4
+	// DefaultWidth is the default Count-Min sketch width
+	DefaultWidth = 2048
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Distinct)(nil)
+var _ streams.Initializer = (*TopK)(nil)
+
+// Distinct estimates the number of distinct record values per key with
+// HyperLogLog sketches persisted in a named store. Every interval of stream
+// time set with <stream>.<node>.interval the updated sketches are stored and
+// a summary record is forwarded per updated key, with the record topic and
+// key and the distinct count as decimal value. The sketch precision can be set
+// with <stream>.<node>.precision. Sketches are restored from the store on
+// their first access, updates since the last summary are lost on failures.
+type Distinct struct {
+	store      string
+	interval   time.Duration
+	precision  uint8
+	db         streams.Store
+	sketches   map[string]*distinctSketch
+	streamTime time.Time
+	emitted    time.Time
+}
+
+type distinctSketch struct {
+	topic string
+	hll   *HyperLogLog
+	dirty bool
+}
+
+// DistinctSupplier for Distinct counts persisted in the named store with the given summary interval
+func DistinctSupplier(store string, interval time.Duration) streams.ProcessorSupplier {
+	return func() (processor streams.Processor) {
+		return &Distinct{store: store, interval: interval}
+	}
+}
+
+// Init the aggregation
+func (d *Distinct) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	d.interval = config.Get("interval").Duration(d.interval)
+	d.precision = uint8(config.Get("precision").Int(DefaultPrecision))
+
+	if d.interval <= 0 {
+		d.interval = DefaultInterval
+	}
+
+	d.sketches = make(map[string]*distinctSketch)
+	d.db, err = pc.Store(d.store)
+	return err
+}
+
+// Process adds the record value to the key sketch
+func (d *Distinct) Process(pc streams.ProcessorContext, record streams.Record) {
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	sketch, err := d.sketch(key)
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	sketch.hll.Add(value)
+	sketch.topic = record.Topic
+	sketch.dirty = true
+
+	if d.emitted.IsZero() {
+		d.emitted = record.Time
+	}
+	if record.Time.After(d.streamTime) {
+		d.streamTime = record.Time
+	}
+
+	if d.streamTime.Sub(d.emitted) >= d.interval {
+		d.emitted = d.streamTime
+		d.summarize(pc)
+	}
+}
+
+// sketch returns the key sketch, restoring it from the store if needed
+func (d *Distinct) sketch(key []byte) (sketch *distinctSketch, err error) {
+	if sketch = d.sketches[string(key)]; sketch != nil {
+		return sketch, nil
+	}
+
+	sketch = &distinctSketch{hll: NewHyperLogLog(d.precision)}
+	data, err := d.db.Get(key)
+	switch err {
+	case nil:
+		if err = sketch.hll.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+	case streams.ErrKeyNotFound:
+	default:
+		return nil, err
+	}
+
+	d.sketches[string(key)] = sketch
+	return sketch, nil
+}
+
+// summarize stores the updated sketches and forwards their counts
+func (d *Distinct) summarize(pc streams.ProcessorContext) {
+	keys := make([]string, 0, len(d.sketches))
+	for key, sketch := range d.sketches {
+		if sketch.dirty {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		sketch := d.sketches[key]
+		sketch.dirty = false
+
+		data, _ := sketch.hll.MarshalBinary()
+		if err := d.db.Set([]byte(key), data); err != nil {
+			pc.Error(err)
+			continue
+		}
+
+		count := strconv.FormatUint(sketch.hll.Count(), 10)
+		record := streams.NewRecord(sketch.topic, streams.StringEncoder(key),
+			streams.StringEncoder(count), d.streamTime, nil)
+		if err := pc.Forward(record); err != nil {
+			pc.Error(err, record)
+		}
+	}
+}
+
+// TopKItem is a item of a top-k summary and its estimated count
+type TopKItem struct {
+	Item  string `json:"item"`
+	Count uint64 `json:"count"`
+}
+
+// TopK tracks the most frequent record keys, or values with the
+// <stream>.<node>.by config set to value, using a Count-Min sketch and a heap
+// of the top items. The state is persisted in a named store under the node
+// name and task id. Every interval of stream time set with
+// <stream>.<node>.interval the state is stored and a summary is forwarded
+// with the node name as key and a json array of TopKItem ordered by count as
+// value. The number of items and the sketch dimensions can be set with
+// <stream>.<node>.k, <stream>.<node>.depth and <stream>.<node>.width.
+type TopK struct {
+	store      string
+	k          int
+	interval   time.Duration
+	byValue    bool
+	name       string
+	stateKey   []byte
+	db         streams.Store
+	cms        *CountMin
+	top        topItems
+	topic      string
+	dirty      bool
+	streamTime time.Time
+	emitted    time.Time
+}
+
+// TopKSupplier for TopK summaries of k items persisted in the named store with the given summary interval
+func TopKSupplier(store string, k int, interval time.Duration) streams.ProcessorSupplier {
+	return func() (processor streams.Processor) {
+		return &TopK{store: store, k: k, interval: interval}
+	}
+}
+
+// Init the aggregation, restoring its state from the store
+func (t *TopK) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	t.k = config.Get("k").Int(t.k)
+	t.interval = config.Get("interval").Duration(t.interval)
+	t.byValue = config.Get("by").String("key") == "value"
+
+	if t.k <= 0 {
+		t.k = DefaultK
+	}
+	if t.interval <= 0 {
+		t.interval = DefaultInterval
+	}
+
+	t.name = pc.NodeName()
+	t.stateKey = []byte(t.name + "/" + strconv.Itoa(pc.TaskID()))
+	t.cms = NewCountMin(config.Get("depth").Int(DefaultDepth), config.Get("width").Int(DefaultWidth))
+	t.top.index = make(map[string]int)
+
+	if t.db, err = pc.Store(t.store); err != nil {
+		return err
+	}
+
+	data, err := t.db.Get(t.stateKey)
+	switch err {
+	case nil:
+		return t.restore(data)
+	case streams.ErrKeyNotFound:
+		return nil
+	}
+	return err
+}
+
+// Process counts the record item
+func (t *TopK) Process(pc streams.ProcessorContext, record streams.Record) {
+	item, err := record.EncodeKey()
+	if t.byValue {
+		item, err = record.EncodeValue()
+	}
+
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	t.add(string(item), t.cms.Add(item, 1))
+	t.topic = record.Topic
+	t.dirty = true
+
+	if t.emitted.IsZero() {
+		t.emitted = record.Time
+	}
+	if record.Time.After(t.streamTime) {
+		t.streamTime = record.Time
+	}
+
+	if t.streamTime.Sub(t.emitted) >= t.interval {
+		t.emitted = t.streamTime
+		t.summarize(pc)
+	}
+}
+
+// add the item estimate to the top items
+func (t *TopK) add(item string, count uint64) {
+	if idx, ok := t.top.index[item]; ok {
+		t.top.items[idx].Count = count
+		heap.Fix(&t.top, idx)
+		return
+	}
+
+	if t.top.Len() < t.k {
+		heap.Push(&t.top, TopKItem{Item: item, Count: count})
+		return
+	}
+
+	if count > t.top.items[0].Count {
+		heap.Pop(&t.top)
+		heap.Push(&t.top, TopKItem{Item: item, Count: count})
+	}
+}
+
+// Top returns the current top items ordered by count
+func (t *TopK) Top() (items []TopKItem) {
+	items = append(items, t.top.items...)
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count == items[j].Count {
+			return items[i].Item < items[j].Item
+		}
+		return items[i].Count > items[j].Count
+	})
+	return items
+}
+
+// summarize stores the state and forwards the top items
+func (t *TopK) summarize(pc streams.ProcessorContext) {
+	if !t.dirty {
+		return
+	}
+	t.dirty = false
+
+	if err := t.db.Set(t.stateKey, t.state()); err != nil {
+		pc.Error(err)
+	}
+
+	summary, err := json.Marshal(t.Top())
+	if err != nil {
+		pc.Error(err)
+		return
+	}
+
+	record := streams.NewRecord(t.topic, streams.StringEncoder(t.name),
+		streams.ByteEncoder(summary), t.streamTime, nil)
+	if err := pc.Forward(record); err != nil {
+		pc.Error(err, record)
+	}
+}
+
+// state encodes the sketch and top items
+func (t *TopK) state() (data []byte) {
+	cms, _ := t.cms.MarshalBinary()
+	data = make([]byte, 4, 4+len(cms))
+	binary.BigEndian.PutUint32(data, uint32(len(cms)))
+	data = append(data, cms...)
+
+	items, _ := json.Marshal(t.top.items)
+	return append(data, items...)
+}
+
+// restore the sketch and top items
+func (t *TopK) restore(data []byte) (err error) {
+	if len(data) < 4 || uint64(len(data)-4) < uint64(binary.BigEndian.Uint32(data)) {
+		return errMalformedSketch
+	}
+
+	size := int(binary.BigEndian.Uint32(data))
+	if err = t.cms.UnmarshalBinary(data[4 : 4+size]); err != nil {
+		return err
+	}
+
+	var items []TopKItem
+	if err = json.Unmarshal(data[4+size:], &items); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		t.add(item.Item, item.Count)
+	}
+	return nil
+}
+
+// topItems is a min heap of the top items by count
+type topItems struct {
+	items []TopKItem
+	index map[string]int
+}
+
+func (t topItems) Len() int           { return len(t.items) }
+func (t topItems) Less(i, j int) bool { return t.items[i].Count < t.items[j].Count }
+func (t topItems) Swap(i, j int) {
+	t.items[i], t.items[j] = t.items[j], t.items[i]
+	t.index[t.items[i].Item] = i
+	t.index[t.items[j].Item] = j
+}
+func (t *topItems) Push(x interface{}) {
+	item := x.(TopKItem)
+	t.index[item.Item] = len(t.items)
+	t.items = append(t.items, item)
+}
+func (t *topItems) Pop() (x interface{}) {
+	item := t.items[len(t.items)-1]
+	t.items = t.items[:len(t.items)-1]
+	delete(t.index, item.Item)
+	return item
+}
@@ -0,0 +1,222 @@
+package aggregate
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+const (
+	// DefaultAccuracy is the default Histogram relative accuracy
+	DefaultAccuracy = 0.01
+)
+
+// DefaultPercentiles are the default percentiles of a Percentiles summary
+var DefaultPercentiles = []float64{50, 90, 99}
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Percentiles)(nil)
+var _ streams.Processor = (*Percentiles)(nil)
+
+// Percentiles estimates the percentiles of numeric record values, such as
+// latencies or sizes, per key within tumbling windows of stream time using
+// mergeable Histogram sketches. When a window closes its sketch is merged
+// into the sketch of the same window in a named store, under the key returned
+// by WindowKey, and a summary is forwarded with the record key and topic and
+// the window start time. The summary value is a json object with the count,
+// min, max, mean and the percentiles named as p<percentile>, e.g. p99.
+// Records for a closed window within the <stream>.<node>.grace period are
+// merged into the stored window sketch and an updated summary is forwarded,
+// later records are dropped with an error.
+// The window size, sketch accuracy and percentiles can be set with
+// <stream>.<node>.window, <stream>.<node>.accuracy and <stream>.<node>.percentiles.
+type Percentiles struct {
+	store       string
+	size        time.Duration
+	grace       time.Duration
+	accuracy    float64
+	percentiles []float64
+	db          streams.Store
+	windows     map[windowKey]*histogramWindow
+	streamTime  time.Time
+}
+
+type histogramWindow struct {
+	topic string
+	key   []byte
+	start time.Time
+	hist  *Histogram
+}
+
+// PercentilesSupplier for Percentiles persisted in the named store with the given window size
+func PercentilesSupplier(store string, size time.Duration) streams.ProcessorSupplier {
+	return func() (processor streams.Processor) {
+		return &Percentiles{store: store, size: size}
+	}
+}
+
+// WindowKey returns the store key of the window sketch for the record key and window start
+func WindowKey(key []byte, start time.Time) (wkey []byte) {
+	wkey = make([]byte, len(key)+8)
+	copy(wkey, key)
+	binary.BigEndian.PutUint64(wkey[len(key):], uint64(start.UnixNano()))
+	return wkey
+}
+
+// Init the aggregation
+func (p *Percentiles) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	p.size = config.Get("window").Duration(p.size)
+	p.grace = config.Get("grace").Duration(0)
+	p.accuracy = config.Get("accuracy").Float64(DefaultAccuracy)
+
+	if p.size <= 0 {
+		p.size = DefaultWindow
+	}
+
+	p.percentiles = DefaultPercentiles
+	if percentiles := config.Get("percentiles").Array(); percentiles != nil {
+		p.percentiles = make([]float64, len(percentiles))
+		for x, percentile := range percentiles {
+			p.percentiles[x] = percentile.Float64(0)
+		}
+	}
+
+	p.windows = make(map[windowKey]*histogramWindow)
+	p.db, err = pc.Store(p.store)
+	return err
+}
+
+// Process adds the record value to its window sketch and emits the summaries
+// of the closed windows
+func (p *Percentiles) Process(pc streams.ProcessorContext, record streams.Record) {
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(string(value)), 64)
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	start := record.Time.Truncate(p.size)
+	if !start.Add(p.size + p.grace).After(p.streamTime) {
+		pc.Error(errLateRecord, record)
+		return
+	}
+
+	wk := windowKey{key: string(key), start: start.UnixNano()}
+	win, exists := p.windows[wk]
+	if !exists {
+		win = &histogramWindow{topic: record.Topic, key: append([]byte(nil), key...),
+			start: start, hist: NewHistogram(p.accuracy)}
+		p.windows[wk] = win
+	}
+	win.hist.Add(number)
+
+	if record.Time.After(p.streamTime) {
+		p.streamTime = record.Time
+	}
+	p.advance(pc)
+}
+
+// advance closes the windows ended by the stream time
+func (p *Percentiles) advance(pc streams.ProcessorContext) {
+	var closed []*histogramWindow
+	for wk, win := range p.windows {
+		if !win.start.Add(p.size).After(p.streamTime) {
+			delete(p.windows, wk)
+			closed = append(closed, win)
+		}
+	}
+
+	sort.Slice(closed, func(i, j int) bool {
+		if closed[i].start.Equal(closed[j].start) {
+			return string(closed[i].key) < string(closed[j].key)
+		}
+		return closed[i].start.Before(closed[j].start)
+	})
+
+	for _, win := range closed {
+		p.close(pc, win)
+	}
+}
+
+// close merges the window sketch into the stored one and forwards its summary
+func (p *Percentiles) close(pc streams.ProcessorContext, win *histogramWindow) {
+	wkey := WindowKey(win.key, win.start)
+
+	data, err := p.db.Get(wkey)
+	switch err {
+	case nil:
+		stored := &Histogram{}
+		if err = stored.UnmarshalBinary(data); err == nil {
+			err = win.hist.Merge(stored)
+		}
+	case streams.ErrKeyNotFound:
+		err = nil
+	}
+	if err != nil {
+		pc.Error(err)
+		return
+	}
+
+	data, _ = win.hist.MarshalBinary()
+	if err = p.db.Set(wkey, data); err != nil {
+		pc.Error(err)
+		return
+	}
+
+	summary := map[string]float64{
+		"count": float64(win.hist.Count()),
+		"min":   win.hist.Min(),
+		"max":   win.hist.Max(),
+		"mean":  win.hist.Mean(),
+	}
+	for _, percentile := range p.percentiles {
+		name := "p" + strconv.FormatFloat(percentile, 'f', -1, 64)
+		summary[name] = win.hist.Quantile(percentile / 100)
+	}
+
+	value, err := json.Marshal(summary)
+	if err != nil {
+		pc.Error(err)
+		return
+	}
+
+	record := streams.NewRecord(win.topic, streams.ByteEncoder(win.key),
+		streams.ByteEncoder(value), win.start, nil)
+	if err = pc.Forward(record); err != nil {
+		pc.Error(err, record)
+	}
+}
@@ -0,0 +1,346 @@
+package aggregate
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"sort"
+
+	"github.com/brunotm/streams"
+	"github.com/dgryski/go-wyhash"
+)
+
+var errMalformedSketch = streams.Errorf(streams.CodeSerialization, "malformed sketch")
+
+// HyperLogLog estimates the number of distinct items added to it
+// with a standard error of 1.04/sqrt(2^precision).
+type HyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+// NewHyperLogLog creates a HyperLogLog with the given precision between 4 and 18
+func NewHyperLogLog(precision uint8) (h *HyperLogLog) {
+	if precision < 4 {
+		precision = 4
+	}
+	if precision > 18 {
+		precision = 18
+	}
+	return &HyperLogLog{precision: precision, registers: make([]uint8, 1<<precision)}
+}
+
+// Add the item
+func (h *HyperLogLog) Add(item []byte) {
+	hash := wyhash.Hash(item, 0)
+	idx := hash >> (64 - h.precision)
+	rank := uint8(bits.LeadingZeros64(hash<<h.precision|1<<(h.precision-1))) + 1
+
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// Count returns the estimated number of distinct items
+func (h *HyperLogLog) Count() (count uint64) {
+	m := float64(len(h.registers))
+
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+
+	// Small range correction with linear counting
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(estimate + 0.5)
+}
+
+// Merge the other HyperLogLog of the same precision into h
+func (h *HyperLogLog) Merge(other *HyperLogLog) (err error) {
+	if h.precision != other.precision {
+		return errMalformedSketch
+	}
+
+	for x, r := range other.registers {
+		if r > h.registers[x] {
+			h.registers[x] = r
+		}
+	}
+	return nil
+}
+
+// MarshalBinary encodes the HyperLogLog
+func (h *HyperLogLog) MarshalBinary() (data []byte, err error) {
+	return append([]byte{h.precision}, h.registers...), nil
+}
+
+// UnmarshalBinary decodes the HyperLogLog
+func (h *HyperLogLog) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < 1 || data[0] < 4 || data[0] > 18 || len(data)-1 != 1<<data[0] {
+		return errMalformedSketch
+	}
+
+	h.precision = data[0]
+	h.registers = append([]uint8(nil), data[1:]...)
+	return nil
+}
+
+// CountMin is a Count-Min sketch estimating the frequency of items,
+// never underestimating their counts.
+type CountMin struct {
+	depth    int
+	width    int
+	counters []uint64
+}
+
+// NewCountMin creates a Count-Min sketch with the given depth and width
+func NewCountMin(depth, width int) (c *CountMin) {
+	if depth <= 0 {
+		depth = 1
+	}
+	if width <= 0 {
+		width = 1
+	}
+	return &CountMin{depth: depth, width: width, counters: make([]uint64, depth*width)}
+}
+
+// Add the count for the item, returning its estimated count
+func (c *CountMin) Add(item []byte, count uint64) (estimate uint64) {
+	estimate = math.MaxUint64
+	for row := 0; row < c.depth; row++ {
+		idx := row*c.width + int(wyhash.Hash(item, uint64(row))%uint64(c.width))
+		c.counters[idx] += count
+		if c.counters[idx] < estimate {
+			estimate = c.counters[idx]
+		}
+	}
+	return estimate
+}
+
+// Count returns the estimated count of the item
+func (c *CountMin) Count(item []byte) (estimate uint64) {
+	estimate = math.MaxUint64
+	for row := 0; row < c.depth; row++ {
+		idx := row*c.width + int(wyhash.Hash(item, uint64(row))%uint64(c.width))
+		if c.counters[idx] < estimate {
+			estimate = c.counters[idx]
+		}
+	}
+	return estimate
+}
+
+// MarshalBinary encodes the Count-Min sketch
+func (c *CountMin) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 8+8*len(c.counters))
+	binary.BigEndian.PutUint32(data, uint32(c.depth))
+	binary.BigEndian.PutUint32(data[4:], uint32(c.width))
+	for x, counter := range c.counters {
+		binary.BigEndian.PutUint64(data[8+8*x:], counter)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes the Count-Min sketch
+func (c *CountMin) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < 8 {
+		return errMalformedSketch
+	}
+
+	depth := int(binary.BigEndian.Uint32(data))
+	width := int(binary.BigEndian.Uint32(data[4:]))
+	if depth <= 0 || width <= 0 || len(data)-8 != 8*depth*width {
+		return errMalformedSketch
+	}
+
+	c.depth, c.width = depth, width
+	c.counters = make([]uint64, depth*width)
+	for x := range c.counters {
+		c.counters[x] = binary.BigEndian.Uint64(data[8+8*x:])
+	}
+	return nil
+}
+
+// Histogram is a mergeable quantile sketch of non negative values with log
+// sized buckets, estimating any quantile within the given relative accuracy.
+type Histogram struct {
+	accuracy float64
+	gamma    float64
+	buckets  map[int32]uint64
+	zeros    uint64
+	count    uint64
+	min      float64
+	max      float64
+	sum      float64
+}
+
+// NewHistogram creates a Histogram with the given relative accuracy, e.g. 0.01
+func NewHistogram(accuracy float64) (h *Histogram) {
+	if accuracy <= 0 || accuracy >= 1 {
+		accuracy = 0.01
+	}
+
+	h = &Histogram{accuracy: accuracy}
+	h.gamma = (1 + accuracy) / (1 - accuracy)
+	h.buckets = make(map[int32]uint64)
+	return h
+}
+
+// Add the value, negative values are added as zero
+func (h *Histogram) Add(value float64) {
+	if value < 0 || math.IsNaN(value) {
+		value = 0
+	}
+
+	if h.count == 0 || value < h.min {
+		h.min = value
+	}
+	if h.count == 0 || value > h.max {
+		h.max = value
+	}
+	h.count++
+	h.sum += value
+
+	if value == 0 {
+		h.zeros++
+		return
+	}
+	h.buckets[int32(math.Ceil(math.Log(value)/math.Log(h.gamma)))]++
+}
+
+// Count returns the number of added values
+func (h *Histogram) Count() (count uint64) {
+	return h.count
+}
+
+// Min returns the minimum added value
+func (h *Histogram) Min() (min float64) {
+	return h.min
+}
+
+// Max returns the maximum added value
+func (h *Histogram) Max() (max float64) {
+	return h.max
+}
+
+// Mean returns the mean of the added values
+func (h *Histogram) Mean() (mean float64) {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / float64(h.count)
+}
+
+// Quantile returns the estimated value at the quantile q between 0 and 1
+func (h *Histogram) Quantile(q float64) (value float64) {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := uint64(q*float64(h.count-1)) + 1
+	if rank <= h.zeros {
+		return 0
+	}
+
+	indexes := make([]int, 0, len(h.buckets))
+	for idx := range h.buckets {
+		indexes = append(indexes, int(idx))
+	}
+	sort.Ints(indexes)
+
+	seen := h.zeros
+	for _, idx := range indexes {
+		if seen += h.buckets[int32(idx)]; seen >= rank {
+			value = 2 * math.Pow(h.gamma, float64(idx)) / (h.gamma + 1)
+			return math.Min(math.Max(value, h.min), h.max)
+		}
+	}
+	return h.max
+}
+
+// Merge the other Histogram of the same accuracy into h
+func (h *Histogram) Merge(other *Histogram) (err error) {
+	if h.accuracy != other.accuracy {
+		return errMalformedSketch
+	}
+
+	if other.count == 0 {
+		return nil
+	}
+
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if h.count == 0 || other.max > h.max {
+		h.max = other.max
+	}
+
+	h.count += other.count
+	h.sum += other.sum
+	h.zeros += other.zeros
+	for idx, count := range other.buckets {
+		h.buckets[idx] += count
+	}
+	return nil
+}
+
+// MarshalBinary encodes the Histogram
+func (h *Histogram) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 48, 48+12*len(h.buckets))
+	binary.BigEndian.PutUint64(data, math.Float64bits(h.accuracy))
+	binary.BigEndian.PutUint64(data[8:], h.zeros)
+	binary.BigEndian.PutUint64(data[16:], h.count)
+	binary.BigEndian.PutUint64(data[24:], math.Float64bits(h.min))
+	binary.BigEndian.PutUint64(data[32:], math.Float64bits(h.max))
+	binary.BigEndian.PutUint64(data[40:], math.Float64bits(h.sum))
+
+	var bucket [12]byte
+	for idx, count := range h.buckets {
+		binary.BigEndian.PutUint32(bucket[:], uint32(idx))
+		binary.BigEndian.PutUint64(bucket[4:], count)
+		data = append(data, bucket[:]...)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes the Histogram
+func (h *Histogram) UnmarshalBinary(data []byte) (err error) {
+	if len(data) < 48 || (len(data)-48)%12 != 0 {
+		return errMalformedSketch
+	}
+
+	*h = *NewHistogram(math.Float64frombits(binary.BigEndian.Uint64(data)))
+	h.zeros = binary.BigEndian.Uint64(data[8:])
+	h.count = binary.BigEndian.Uint64(data[16:])
+	h.min = math.Float64frombits(binary.BigEndian.Uint64(data[24:]))
+	h.max = math.Float64frombits(binary.BigEndian.Uint64(data[32:]))
+	h.sum = math.Float64frombits(binary.BigEndian.Uint64(data[40:]))
+
+	for data = data[48:]; len(data) > 0; data = data[12:] {
+		h.buckets[int32(binary.BigEndian.Uint32(data))] = binary.BigEndian.Uint64(data[4:])
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package aggregate
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/brunotm/streams/store/sharded"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	h := NewHyperLogLog(DefaultPrecision)
+	other := NewHyperLogLog(DefaultPrecision)
+
+	for x := 0; x < 100000; x++ {
+		h.Add([]byte(strconv.Itoa(x)))
+		h.Add([]byte(strconv.Itoa(x)))
+		other.Add([]byte(strconv.Itoa(x + 50000)))
+	}
+	assert.InDelta(t, 100000, float64(h.Count()), 3000)
+
+	assert.NoError(t, h.Merge(other))
+	assert.InDelta(t, 150000, float64(h.Count()), 4500)
+	assert.Error(t, h.Merge(NewHyperLogLog(4)))
+
+	data, err := h.MarshalBinary()
+	assert.NoError(t, err)
+	restored := &HyperLogLog{}
+	assert.NoError(t, restored.UnmarshalBinary(data))
+	assert.Equal(t, h.Count(), restored.Count())
+	assert.Error(t, restored.UnmarshalBinary(data[1:]))
+
+	small := NewHyperLogLog(DefaultPrecision)
+	for x := 0; x < 10; x++ {
+		small.Add([]byte(strconv.Itoa(x)))
+	}
+	assert.Equal(t, uint64(10), small.Count())
+}
+
+func TestCountMin(t *testing.T) {
+	c := NewCountMin(DefaultDepth, 256)
+	for x := 0; x < 1000; x++ {
+		c.Add([]byte(strconv.Itoa(x%100)), 1)
+	}
+	assert.Equal(t, uint64(20), c.Add([]byte("hot"), 20))
+
+	for x := 0; x < 100; x++ {
+		assert.True(t, c.Count([]byte(strconv.Itoa(x))) >= 10)
+	}
+
+	data, err := c.MarshalBinary()
+	assert.NoError(t, err)
+	restored := &CountMin{}
+	assert.NoError(t, restored.UnmarshalBinary(data))
+	assert.Equal(t, c.Count([]byte("hot")), restored.Count([]byte("hot")))
+	assert.Error(t, restored.UnmarshalBinary(data[:len(data)-1]))
+}
+
+func TestApproximate(t *testing.T) {
+	db := sharded.Supplier()
+	assert.NoError(t, db.(streams.Initializer).Init(&mock.Context{}))
+
+	config := streams.NewConfig(nil)
+	config.Set(2, "stream.top.k")
+	pc := &mock.Context{Data: mock.ContextData{
+		Active:     true,
+		StreamName: "stream",
+		NodeName:   "top",
+		Config:     config,
+		Store:      db,
+	}}
+
+	top := TopKSupplier("top", 0, time.Second)()
+	assert.NoError(t, top.(streams.Initializer).Init(pc))
+	distinct := DistinctSupplier("distinct", time.Second)()
+	assert.NoError(t, distinct.(streams.Initializer).Init(pc))
+
+	now := time.Now()
+	for x := 0; x < 100; x++ {
+		key := "c"
+		switch {
+		case x%2 == 0:
+			key = "a"
+		case x%3 == 0:
+			key = "b"
+		}
+
+		ts := now.Add(time.Duration(x) * time.Millisecond)
+		if x == 99 {
+			ts = now.Add(time.Second)
+		}
+
+		record := streams.NewRecord("test", streams.StringEncoder(key),
+			streams.StringEncoder(strconv.Itoa(x%30)), ts, nil)
+		top.Process(pc, record)
+		distinct.Process(pc, record)
+	}
+
+	assert.Equal(t, 4, pc.Data.ForwardCount)
+	summary, _ := pc.Data.Forwarded[0].EncodeValue()
+	assert.Equal(t, `[{"item":"a","count":50},{"item":"c","count":33}]`, string(summary))
+
+	var counts []string
+	for _, record := range pc.Data.Forwarded[1:] {
+		key, _ := record.EncodeKey()
+		value, _ := record.EncodeValue()
+		counts = append(counts, string(key)+":"+string(value))
+	}
+	assert.Equal(t, []string{"a:15", "b:5", "c:10"}, counts)
+
+	// the top items are restored from the store
+	restored := TopKSupplier("top", 0, time.Second)()
+	assert.NoError(t, restored.(streams.Initializer).Init(pc))
+	assert.Equal(t, top.(*TopK).Top(), restored.(*TopK).Top())
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(DefaultAccuracy)
+	other := NewHistogram(DefaultAccuracy)
+
+	for x := 1; x <= 1000; x++ {
+		h.Add(float64(x))
+		other.Add(float64(x + 1000))
+	}
+	h.Add(0)
+
+	assert.Equal(t, uint64(1001), h.Count())
+	assert.Equal(t, float64(0), h.Min())
+	assert.Equal(t, float64(1000), h.Max())
+	assert.InEpsilon(t, 500, h.Quantile(0.5), DefaultAccuracy)
+	assert.InEpsilon(t, 990, h.Quantile(0.99), DefaultAccuracy)
+	assert.Equal(t, float64(0), h.Quantile(0))
+	assert.Equal(t, float64(1000), h.Quantile(1))
+
+	assert.NoError(t, h.Merge(other))
+	assert.Equal(t, uint64(2001), h.Count())
+	assert.InEpsilon(t, 1000, h.Quantile(0.5), DefaultAccuracy)
+	assert.Error(t, h.Merge(NewHistogram(0.05)))
+
+	data, err := h.MarshalBinary()
+	assert.NoError(t, err)
+	restored := &Histogram{}
+	assert.NoError(t, restored.UnmarshalBinary(data))
+	assert.Equal(t, h, restored)
+	assert.Error(t, restored.UnmarshalBinary(data[:50]))
+}
+
+func TestPercentiles(t *testing.T) {
+	db := sharded.Supplier()
+	assert.NoError(t, db.(streams.Initializer).Init(&mock.Context{}))
+
+	config := streams.NewConfig(nil)
+	config.Set([]interface{}{50, 99.9}, "stream.latency.percentiles")
+	config.Set("1s", "stream.latency.grace")
+	pc := &mock.Context{Data: mock.ContextData{
+		Active:     true,
+		StreamName: "stream",
+		NodeName:   "latency",
+		Config:     config,
+		Store:      db,
+	}}
+
+	p := PercentilesSupplier("latency", time.Second)()
+	assert.NoError(t, p.(streams.Initializer).Init(pc))
+
+	now := time.Now().Truncate(time.Second)
+	process := func(key string, value int, ts time.Time) {
+		p.Process(pc, streams.NewRecord("test", streams.StringEncoder(key),
+			streams.StringEncoder(strconv.Itoa(value)), ts, nil))
+	}
+
+	for x := 1; x <= 100; x++ {
+		process("a", x, now.Add(time.Duration(x)*time.Millisecond))
+	}
+	process("b", 1, now)
+	assert.Equal(t, 0, pc.Data.ForwardCount)
+
+	// closes the first window
+	process("a", 1, now.Add(time.Second))
+	assert.Equal(t, 2, pc.Data.ForwardCount)
+
+	key, _ := pc.Data.Forwarded[0].EncodeKey()
+	value, _ := pc.Data.Forwarded[0].EncodeValue()
+	assert.Equal(t, "a", string(key))
+	assert.Equal(t, now, pc.Data.Forwarded[0].Time)
+
+	var summary map[string]float64
+	assert.NoError(t, json.Unmarshal(value, &summary))
+	assert.Equal(t, float64(100), summary["count"])
+	assert.Equal(t, float64(1), summary["min"])
+	assert.Equal(t, float64(100), summary["max"])
+	assert.InEpsilon(t, 50, summary["p50"], DefaultAccuracy)
+	assert.InEpsilon(t, 99, summary["p99.9"], DefaultAccuracy)
+
+	// records within the grace period are merged into the stored window
+	process("a", 1000, now.Add(500*time.Millisecond))
+	process("a", 1, now.Add(1500*time.Millisecond))
+	assert.Equal(t, 3, pc.Data.ForwardCount)
+	value, _ = pc.Data.Forwarded[2].EncodeValue()
+	assert.NoError(t, json.Unmarshal(value, &summary))
+	assert.Equal(t, float64(101), summary["count"])
+	assert.Equal(t, float64(1000), summary["max"])
+
+	data, err := db.Get(WindowKey([]byte("a"), now))
+	assert.NoError(t, err)
+	stored := &Histogram{}
+	assert.NoError(t, stored.UnmarshalBinary(data))
+	assert.Equal(t, uint64(101), stored.Count())
+
+	// records after the grace period are dropped
+	process("a", 1, now.Add(3*time.Second))
+	process("a", 1, now.Add(500*time.Millisecond))
+	assert.Equal(t, 1, pc.Data.ErrorCount)
+
+	process("a", 1, now.Add(3*time.Second))
+	p.Process(pc, streams.NewRecord("test", streams.StringEncoder("a"),
+		streams.StringEncoder("invalid"), now.Add(3*time.Second), nil))
+	assert.Equal(t, 2, pc.Data.ErrorCount)
+}
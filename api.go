@@ -16,6 +16,11 @@ package streams
    limitations under the License.
 */
 
+import (
+	"context"
+	"time"
+)
+
 // Initializer interface. Any Processor or Store that must be initialized before
 // running tasks in the the stream must implement this interface.
 type Initializer interface {
@@ -28,27 +33,90 @@ type Closer interface {
 	Close() (err error)
 }
 
+// Checker interface. Any Source, Processor, Sink or Store that depends on
+// external resources can implement this interface to verify its configuration
+// and connectivity (e.g. brokers reachable, paths writable) on Stream.DryRun.
+type Checker interface {
+	Check() (err error)
+}
+
+// Readier interface. Any Source, Processor, Sink or Store that sources depend
+// on can implement this interface to report when it is ready, such as sinks
+// connected or tables materialized, delaying the activation of the dependent
+// sources so the first records aren't processed against empty reference state.
+type Readier interface {
+	Ready() (ready bool)
+}
+
+// Concurrent interface. Sinks bound by slow external writes can implement this
+// interface to process records with the given number of workers pulling from a
+// bounded queue of the given size, instead of inline on the predecessor task.
+// Concurrent sinks must be safe for concurrent use and process records unordered.
+type Concurrent interface {
+	Concurrency() (workers, queue int)
+}
+
+// Instrumented interface. Any Processor, Sink or Store can implement this
+// interface to add its own metrics to Stream.Metrics, which are labeled with
+// the stream and node names. Only the node instance of processors with tasks
+// is measured, so task instances should share their metrics.
+type Instrumented interface {
+	Metrics() (metrics []Metric)
+}
+
 // ProcessorContext is a execution context within a stream. Provides stream,
 // task and processor information, routing of records to children processors,
 // access to configured stores and contextual logging.
 type ProcessorContext interface {
 	// NodeName returns the current node name.
 	NodeName() (name string)
+	// TaskID returns the current task id. Each task of a node runs
+	// with its own processor instance and context.
+	TaskID() (id int)
 	// StreamName returns the stream name.
 	StreamName() (name string)
 	// Config returns the stream app configuration.
 	Config() (config Config)
 	// IsActive returns if this context is active and can forward records to the stream.
 	IsActive() (active bool)
+	// Context returns the context of the current task, canceled when a source
+	// task is stopped or a processor task is closed, and within Processor.Process()
+	// the context of the record, with the <stream>.<node>.deadline if configured.
+	Context() (ctx context.Context)
+	// StreamTime returns the highest record time processed by the context,
+	// or forwarded by a source context, zero if none was timed.
+	StreamTime() (ts time.Time)
+	// Watermark returns the event time watermark of the stream, the time up to
+	// which records are expected to have been received from all sources.
+	// Records older than the watermark are late.
+	Watermark() (ts time.Time)
 	// Store returns the store with the given name
 	Store(name string) (store Store, err error)
+	// Cache returns the local cache of the current task, which is cleared
+	// when the node is rescaled or closed.
+	Cache() (cache *Cache)
+	// Resource returns the shared resource with the given name from the
+	// stream resource pool. Resources are released when the stream is closed.
+	Resource(name string) (resource interface{}, err error)
 	// Forward the record to the downstream processors. Can be called multiple times
 	// within Processor.Process() in order to send correlated or windowed records.
 	Forward(record Record) (err error)
 	// ForwardTo is like forward, but it forwards the record only to the given node
 	ForwardTo(to string, record Record) (err error)
+	// Broadcast is like forward, but it sends the record to every task of the
+	// downstream processors, for records that all task instances must observe.
+	Broadcast(record Record) (err error)
 	// Error emits a error event to be handled by the Stream.
 	Error(err error, records ...Record)
+	// Heartbeat reports a successful poll of the upstream system by a source,
+	// even if no records were received, so that idle sources are not
+	// considered stale by the poll staleness threshold.
+	Heartbeat()
+	// Schedule the punctuator to be called every interval of wall clock or
+	// stream time, returning a function to cancel it. Punctuators are never
+	// called concurrently with Processor.Process() on the same context and can
+	// forward records, such as periodic aggregates or flushed buffers.
+	Schedule(interval time.Duration, typ PunctuationType, fn Punctuator) (cancel func())
 }
 
 // Processor of records in a Stream. Both processors and sinks must implement
@@ -63,6 +131,19 @@ type Source interface {
 	Consume(pc ProcessorContext)
 }
 
+// PartitionedSource is a Source whose input is divided in partitions, like
+// Kafka topic partitions, Kinesis shards or files. When a partitioned source
+// is scaled each task receives a distinct set of partitions to consume.
+type PartitionedSource interface {
+	Source
+	// Partitions returns the source partitions. It is called once on the
+	// first source instance when the stream starts.
+	Partitions() (partitions []string, err error)
+	// Assign the partitions this source instance must consume. Assign is called
+	// before Consume and again whenever the source tasks are rebalanced.
+	Assign(partitions []string) (err error)
+}
+
 // ProcessorSupplier instantiates Processors used to create a Stream topology,
 // recreate them or clone a Stream.
 // If further configuration is needed, the processor must implement the Initializer
@@ -83,4 +164,4 @@ func (f ProcessorFunc) Process(pc ProcessorContext, record Record) {
 // If further configuration is needed, the source must implement the Initializer
 // interface in order to initialize itself before the Stream start and
 // access configuration parameters through the provided context.
-type SourceSupplier func() Store
+type SourceSupplier func() Source
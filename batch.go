@@ -0,0 +1,68 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Batch shares a single source acknowledgment among records, for sources
+// that commit one offset or delete one batch of messages per N records
+// instead of per record. The batch ack is called once all records added to
+// the batch and their copies are processed and the batch is sealed.
+type Batch struct {
+	ack *acker
+}
+
+// NewBatch creates a batch acknowledged with the given function
+func NewBatch(ack func() error) (b *Batch) {
+	return &Batch{ack: newAcker(ack)}
+}
+
+// Add the record to the batch, returning a copy of the record
+// acknowledged with the batch instead of its own acknowledgment.
+// Records must be added before the batch is sealed.
+func (b *Batch) Add(record Record) (batched Record) {
+	if b.ack != nil {
+		b.ack.retain(1)
+	}
+	record.ack = b.ack
+	return record
+}
+
+// Seal the batch once all of its records are added, releasing the batch
+// reference. Sealed batches are acknowledged once all of their records are
+// processed, or immediately if empty.
+func (b *Batch) Seal() (err error) {
+	if b.ack != nil {
+		return b.ack.release()
+	}
+	return nil
+}
+
+// AckBatch releases the references of the records retained with Retain, as
+// calling Ack for each record, returning the acknowledgment errors as Errors.
+// Records sharing a source Batch acknowledge the batch once.
+func AckBatch(records []Record) (err error) {
+	var errs Errors
+	for x := range records {
+		if err = records[x].Ack(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatch(t *testing.T) {
+	var acks int
+	batch := NewBatch(func() error {
+		acks++
+		return nil
+	})
+
+	now := time.Now()
+	var records []Record
+	for _, key := range []string{"a", "b", "c"} {
+		records = append(records, batch.Add(NewRecord("topic", StringEncoder(key), StringEncoder("v"), now, nil)))
+	}
+
+	// records processed before the batch is sealed do not ack the batch
+	assert.NoError(t, records[0].Ack())
+	assert.Equal(t, 0, acks)
+
+	assert.NoError(t, batch.Seal())
+	assert.Equal(t, 0, acks)
+
+	// retained records delay the batch ack
+	records[1].Retain()
+	assert.NoError(t, AckBatch(records[1:]))
+	assert.Equal(t, 0, acks)
+
+	assert.NoError(t, AckBatch(records[1:2]))
+	assert.Equal(t, 1, acks)
+
+	// empty batches are acknowledged once sealed
+	empty := NewBatch(func() error { return errors.New("ack failed") })
+	assert.EqualError(t, empty.Seal(), "ack failed")
+	assert.NoError(t, NewBatch(nil).Seal())
+}
+
+func TestAckBatch(t *testing.T) {
+	errAck := errors.New("ack failed")
+	now := time.Now()
+
+	records := []Record{
+		NewRecord("topic", nil, StringEncoder("a"), now, func() error { return errAck }),
+		NewRecord("topic", nil, StringEncoder("b"), now, func() error { return nil }),
+		NewRecord("topic", nil, StringEncoder("c"), now, func() error { return errAck }),
+	}
+
+	err := AckBatch(records)
+	assert.Len(t, err, 2)
+	assert.True(t, errors.Is(err, errAck))
+	assert.NoError(t, AckBatch(nil))
+}
@@ -0,0 +1,160 @@
+package bridge
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+
+	"github.com/brunotm/streams"
+	"github.com/golang/snappy"
+)
+
+// Protocol headers and paths
+const (
+	headerSink      = "X-Bridge-Sink"
+	headerSequence  = "X-Bridge-Sequence"
+	headerCommitted = "X-Bridge-Committed"
+	encodingSnappy  = "snappy"
+	pathBatch       = "/batch"
+	pathResume      = "/resume"
+)
+
+var (
+	errInvalidBatch = streams.Errorf(streams.CodeSerialization, "invalid bridge batch")
+	errInvalidCA    = streams.Errorf(streams.CodeConfig, "invalid bridge ca certificates")
+	errTLS          = streams.Errorf(streams.CodeConfig, "bridge tls requires cert, key and ca")
+	errInsecure     = streams.Errorf(streams.CodeConfig, "bridge requires mutual tls on non loopback addresses")
+)
+
+// tlsConfig loads the tls configuration from the <stream>.<node>.tls config
+// subtree with the cert, key and ca file paths. The ca verifies the peer
+// certificates, servers with a ca require and verify client certificates.
+// It returns nil if no certificate or ca is configured.
+func tlsConfig(config streams.Config, server bool) (c *tls.Config, err error) {
+	cert := config.Get("tls", "cert").String("")
+	key := config.Get("tls", "key").String("")
+	ca := config.Get("tls", "ca").String("")
+
+	if cert == "" && ca == "" {
+		return nil, nil
+	}
+
+	c = &tls.Config{MinVersion: tls.VersionTLS12}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{pair}
+	}
+
+	if ca != "" {
+		data, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errInvalidCA
+		}
+
+		if server {
+			c.ClientCAs = pool
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			c.RootCAs = pool
+		}
+	}
+
+	return c, nil
+}
+
+// checkSecurity requires the tls cert, key and ca to be set together for
+// mutual tls, and mutual tls for serving on non loopback addresses
+func checkSecurity(config streams.Config, addr string) (err error) {
+	cert := config.Get("tls", "cert").String("")
+	key := config.Get("tls", "key").String("")
+	ca := config.Get("tls", "ca").String("")
+
+	if cert != "" || key != "" || ca != "" {
+		if cert == "" || key == "" || ca == "" {
+			return errTLS
+		}
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return errInsecure
+	}
+	return nil
+}
+
+// encodeBatch encodes the records in the streams wire format as uvarint
+// length prefixed entries, compressed with snappy if compress is set.
+func encodeBatch(records []streams.Record, compress bool) (data []byte, err error) {
+	var tmp [binary.MaxVarintLen64]byte
+	for _, record := range records {
+		entry, err := streams.MarshalRecord(record)
+		if err != nil {
+			return nil, err
+		}
+
+		data = append(data, tmp[:binary.PutUvarint(tmp[:], uint64(len(entry)))]...)
+		data = append(data, entry...)
+	}
+
+	if compress {
+		return snappy.Encode(nil, data), nil
+	}
+	return data, nil
+}
+
+// decodeBatch decodes the records of a batch, added to the given Batch
+func decodeBatch(data []byte, compressed bool, batch *streams.Batch) (records []streams.Record, err error) {
+	if compressed {
+		if data, err = snappy.Decode(nil, data); err != nil {
+			return nil, err
+		}
+	}
+
+	for len(data) > 0 {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < size {
+			return nil, errInvalidBatch
+		}
+
+		record, err := streams.UnmarshalRecord(data[n:n+int(size)], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, batch.Add(record))
+		data = data[n+int(size):]
+	}
+
+	return records, nil
+}
@@ -0,0 +1,238 @@
+package bridge
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCerts writes a ca and server and client certificates signed by it to dir
+func writeCerts(t *testing.T, dir string) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+
+	write := func(name, typ string, der []byte) {
+		data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), data, 0600))
+	}
+	write("ca.pem", "CERTIFICATE", caDER)
+
+	for x, name := range []string{"server", "client"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+
+		cert := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(x + 2)),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+		assert.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		assert.NoError(t, err)
+
+		write(name+".pem", "CERTIFICATE", der)
+		write(name+"-key.pem", "EC PRIVATE KEY", keyDER)
+	}
+}
+
+func TestBridge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeCerts(t, dir)
+
+	var mtx sync.Mutex
+	var received []string
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	// receiving stream
+	config := streams.NewConfig(nil)
+	config.Set("127.0.0.1:0", "in.bridge.addr")
+	config.Set(filepath.Join(dir, "server.pem"), "in.bridge.tls.cert")
+	config.Set(filepath.Join(dir, "server-key.pem"), "in.bridge.tls.key")
+	config.Set(filepath.Join(dir, "ca.pem"), "in.bridge.tls.ca")
+
+	source := &Source{}
+	in := streams.NewBuilder("in", config)
+	assert.NoError(t, in.AddSource("bridge", func() streams.Source { return source }))
+	assert.NoError(t, in.AddSinkFunc("sink", func(pc streams.ProcessorContext, record streams.Record) {
+		key, _ := record.EncodeKey()
+		value, _ := record.EncodeValue()
+		mtx.Lock()
+		received = append(received, record.Topic+":"+string(key)+":"+string(value))
+		mtx.Unlock()
+		wg.Done()
+	}, "bridge"))
+
+	inStream, err := in.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, inStream.Start())
+	url := "https://" + source.Addr().String()
+
+	// sending stream
+	config.Set(url, "out.bridge.url")
+	config.Set("bridge", "out.bridge.id")
+	config.Set(filepath.Join(dir, "client.pem"), "out.bridge.tls.cert")
+	config.Set(filepath.Join(dir, "client-key.pem"), "out.bridge.tls.key")
+	config.Set(filepath.Join(dir, "ca.pem"), "out.bridge.tls.ca")
+	config.Set(2, "out.bridge.batch.size")
+	config.Set("10ms", "out.bridge.batch.interval")
+
+	var acked sync.WaitGroup
+	acked.Add(3)
+	ack := func() error {
+		acked.Done()
+		return nil
+	}
+
+	now := time.Now()
+	out := streams.NewBuilder("out", config)
+	assert.NoError(t, out.AddSource("source", func() streams.Source {
+		return &mock.Source{Records: []streams.Record{
+			streams.NewRecord("a", streams.StringEncoder("1"), streams.StringEncoder("x"), now, ack),
+			streams.NewRecord("b", nil, streams.StringEncoder("y"), now, ack),
+			streams.NewRecord("c", streams.StringEncoder("3"), nil, now, ack),
+		}}
+	}))
+	assert.NoError(t, out.AddSink("bridge", SinkSupplier, "source"))
+
+	outStream, err := out.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, outStream.Start())
+
+	wg.Wait()
+	acked.Wait()
+	assert.NoError(t, outStream.Close())
+	assert.Equal(t, []string{"a:1:x", "b::y", "c:3:"}, received)
+
+	// retried batches already committed are not forwarded again
+	pc := &mock.Context{Data: mock.ContextData{Active: true, StreamName: "out", NodeName: "bridge", Config: config}}
+	sink := &Sink{}
+	assert.NoError(t, sink.Init(pc))
+	assert.Equal(t, uint64(2), sink.sequence)
+
+	sink.sequence--
+	assert.NoError(t, sink.deliver([]streams.Record{
+		streams.NewRecord("d", nil, streams.StringEncoder("z"), now, nil)}, 1))
+	assert.NoError(t, sink.Close())
+	assert.Equal(t, 0, pc.Data.ErrorCount)
+
+	// clients without a certificate are rejected
+	config.Set("", "out.bridge.tls.cert")
+	assert.Error(t, SinkSupplier().(streams.Initializer).Init(pc))
+
+	// sinks retrying batches to an unreachable source close promptly
+	config.Set(filepath.Join(dir, "client.pem"), "out.bridge.tls.cert")
+	stalled := &Sink{}
+	assert.NoError(t, stalled.Init(pc))
+	assert.NoError(t, inStream.Close())
+
+	processed := make(chan struct{})
+	go func() {
+		stalled.Process(pc, streams.NewRecord("e", nil, streams.StringEncoder("v"), now, nil))
+		stalled.Process(pc, streams.NewRecord("f", nil, streams.StringEncoder("w"), now, nil))
+		close(processed)
+	}()
+
+	closed := make(chan error, 1)
+	time.AfterFunc(50*time.Millisecond, func() { closed <- stalled.Close() })
+	select {
+	case err = <-closed:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("sink close blocked by the batch being delivered")
+	}
+	<-processed
+
+	mtx.Lock()
+	assert.Len(t, received, 3)
+	mtx.Unlock()
+}
+
+func TestSourceSecurity(t *testing.T) {
+	config := streams.NewConfig(nil)
+	pc := &mock.Context{Data: mock.ContextData{Active: true, StreamName: "in", NodeName: "bridge", Config: config}}
+
+	// plain http is only served on loopback addresses
+	for _, addr := range []string{":0", "0.0.0.0:0", "10.0.0.1:0"} {
+		config.Set(addr, "in.bridge.addr")
+		assert.Equal(t, errInsecure, SourceSupplier().(streams.Initializer).Init(pc), addr)
+	}
+
+	// tls requires the cert, key and ca for mutual tls
+	config.Set("127.0.0.1:0", "in.bridge.addr")
+	config.Set("server.pem", "in.bridge.tls.cert")
+	config.Set("server-key.pem", "in.bridge.tls.key")
+	assert.Equal(t, errTLS, SourceSupplier().(streams.Initializer).Init(pc))
+
+	// batches are bounded in size
+	config.Set(nil, "in.bridge.tls")
+	config.Set(16, "in.bridge.max.batch")
+	source := &Source{}
+	assert.NoError(t, source.Init(pc))
+	assert.True(t, source.Addr().(*net.TCPAddr).IP.IsLoopback())
+	go source.Consume(pc)
+	defer source.Close()
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+source.Addr().String()+pathBatch,
+		strings.NewReader(strings.Repeat("x", 32)))
+	assert.NoError(t, err)
+	req.Header.Set(headerSink, "sink")
+	req.Header.Set(headerSequence, "1")
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
@@ -0,0 +1,273 @@
+package bridge
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+const (
+	// DefaultBatchSize is the default maximum number of records per batch
+	DefaultBatchSize = 100
+	// DefaultBatchInterval is the default maximum time records wait for a batch
+	DefaultBatchInterval = 100 * time.Millisecond
+	// DefaultBackoff is the default time between batch delivery retries
+	DefaultBackoff = time.Second
+)
+
+var (
+	errSinkClosed = streams.Errorf(streams.CodeState, "bridge sink closed")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Sink)(nil)
+var _ streams.Processor = (*Sink)(nil)
+var _ streams.Closer = (*Sink)(nil)
+
+// Sink sends records in batches to a bridge Source of another stream over
+// http, chaining topologies across hosts. Batches are delivered in order and
+// retried until acknowledged by the Source, which happens once their records
+// are processed by the Source stream, and only then the batch records are
+// acknowledged in the Sink stream. Batches are numbered per sink id, and the
+// Source skips batches already committed, so retries are not duplicated.
+// The sink resumes from the last batch committed by the Source on start.
+// It is configured through the <stream>.<node> config subtree with the keys:
+//
+//	url:            base url of the bridge Source, e.g. https://host:8443
+//	id:             sink id, defaults to <stream>/<node>/<task>
+//	batch.size:     maximum number of records per batch, defaults to DefaultBatchSize
+//	batch.interval: maximum time records wait for a batch, defaults to DefaultBatchInterval
+//	compression:    snappy (default) or none
+//	timeout:        timeout of batch requests, defaults to 30s
+//	backoff:        time between delivery retries, defaults to DefaultBackoff
+//	tls.cert:       client certificate file for mutual tls
+//	tls.key:        client key file for mutual tls
+//	tls.ca:         ca certificates file verifying the Source certificate
+type Sink struct {
+	closed   int32
+	mtx      sync.Mutex
+	sending  sync.Mutex
+	url      string
+	id       string
+	size     int
+	interval time.Duration
+	compress bool
+	backoff  time.Duration
+	client   *http.Client
+	pc       streams.ProcessorContext
+	batch    []streams.Record
+	sequence uint64
+	closech  chan struct{}
+	donech   chan struct{}
+}
+
+// SinkSupplier for bridge sinks
+func SinkSupplier() (processor streams.Processor) {
+	return &Sink{}
+}
+
+// Init the sink and resume from the last batch committed by the Source
+func (s *Sink) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	s.url = config.Get("url").String("")
+	s.id = config.Get("id").String(fmt.Sprintf("%s/%s/%d", pc.StreamName(), pc.NodeName(), pc.TaskID()))
+	s.size = config.Get("batch", "size").Int(DefaultBatchSize)
+	s.interval = config.Get("batch", "interval").Duration(DefaultBatchInterval)
+	s.compress = config.Get("compression").String(encodingSnappy) == encodingSnappy
+	s.backoff = config.Get("backoff").Duration(DefaultBackoff)
+
+	if s.url == "" {
+		return streams.Errorf(streams.CodeConfig, "bridge sink url not set")
+	}
+
+	tc, err := tlsConfig(config, false)
+	if err != nil {
+		return err
+	}
+
+	s.client = &http.Client{
+		Timeout:   config.Get("timeout").Duration(30 * time.Second),
+		Transport: &http.Transport{TLSClientConfig: tc, Proxy: http.ProxyFromEnvironment},
+	}
+
+	if s.sequence, err = s.resume(); err != nil {
+		return err
+	}
+
+	s.pc = pc
+	s.closech = make(chan struct{})
+	s.donech = make(chan struct{})
+	go s.run()
+	return nil
+}
+
+// Process adds the record to the current batch, delivering it when full
+func (s *Sink) Process(pc streams.ProcessorContext, record streams.Record) {
+	s.mtx.Lock()
+	record.Retain()
+	s.batch = append(s.batch, record)
+	full := len(s.batch) >= s.size
+	s.mtx.Unlock()
+
+	if full {
+		s.flush(0)
+	}
+}
+
+// Close the sink, stopping the retries of the batch being delivered
+// and delivering the current batch once
+func (s *Sink) Close() (err error) {
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		return nil
+	}
+	close(s.closech)
+	<-s.donech
+
+	return s.flush(1)
+}
+
+// run delivers the current batch every interval
+func (s *Sink) run() {
+	defer close(s.donech)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closech:
+			return
+		case <-ticker.C:
+			s.flush(0)
+		}
+	}
+}
+
+// flush takes the current batch and delivers it with the given maximum number
+// of attempts, or until the sink is closed if zero. Batches are delivered one
+// at a time and in order, without holding the batch lock while retrying.
+// Batches failing delivery are put back in front of the current batch.
+func (s *Sink) flush(attempts int) (err error) {
+	s.sending.Lock()
+	defer s.sending.Unlock()
+
+	s.mtx.Lock()
+	batch := s.batch
+	s.batch = nil
+	s.mtx.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err = s.deliver(batch, attempts); err != nil {
+		s.mtx.Lock()
+		s.batch = append(batch, s.batch...)
+		s.mtx.Unlock()
+	}
+	return err
+}
+
+// deliver the batch with the given maximum number of attempts, or until
+// the sink is closed if zero, and acknowledge its records.
+// It must be called with the sending lock held.
+func (s *Sink) deliver(batch []streams.Record, attempts int) (err error) {
+	data, err := encodeBatch(batch, s.compress)
+	if err != nil {
+		s.pc.Error(err, batch...)
+		return err
+	}
+
+	s.sequence++
+	for attempt := 1; ; attempt++ {
+		if err = s.send(data); err == nil {
+			break
+		}
+		s.pc.Error(streams.Classify(err, streams.Transient))
+
+		if attempts > 0 && attempt >= attempts {
+			s.sequence--
+			return err
+		}
+
+		select {
+		case <-s.closech:
+			if attempts == 0 {
+				s.sequence--
+				return errSinkClosed
+			}
+		case <-time.After(s.backoff):
+		}
+	}
+
+	for _, record := range batch {
+		if err := record.Ack(); err != nil {
+			s.pc.Error(err, record)
+		}
+	}
+	return nil
+}
+
+// send the batch data with the current sequence
+func (s *Sink) send(data []byte) (err error) {
+	req, err := http.NewRequest(http.MethodPost, s.url+pathBatch, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(headerSink, s.id)
+	req.Header.Set(headerSequence, strconv.FormatUint(s.sequence, 10))
+	if s.compress {
+		req.Header.Set("Content-Encoding", encodingSnappy)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("bridge batch %d: %s: %s", s.sequence, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// resume returns the last batch sequence committed by the Source for the sink
+func (s *Sink) resume() (sequence uint64, err error) {
+	resp, err := s.client.Get(s.url + pathResume + "?sink=" + url.QueryEscape(s.id))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("bridge resume: %s", resp.Status)
+	}
+	return strconv.ParseUint(resp.Header.Get(headerCommitted), 10, 64)
+}
@@ -0,0 +1,259 @@
+package bridge
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/brunotm/streams"
+)
+
+const (
+	// DefaultMaxBatchBytes is the default maximum size of received batches
+	DefaultMaxBatchBytes = 64 << 20
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Source)(nil)
+var _ streams.Source = (*Source)(nil)
+var _ streams.Closer = (*Source)(nil)
+
+// Source receives record batches from bridge Sinks of other streams over http.
+// Batches are acknowledged to the Sink once all of their records are processed
+// by the stream, and their sequence committed per sink id as its resume token.
+// Batches with a sequence already committed are acknowledged without being
+// forwarded again. Resume tokens are kept in the named store, or in memory if
+// not set, which only deduplicates retries within the Source lifetime.
+// Sinks are authenticated with mutual tls, which is required to listen on
+// non loopback addresses. It is configured through the <stream>.<node> config subtree with the keys:
+//
+//	addr:      listen address, e.g. :8443, defaults to 127.0.0.1:0
+//	store:     name of the store for the resume tokens
+//	max.batch: maximum size in bytes of received batches, defaults to DefaultMaxBatchBytes
+//	tls.cert:  server certificate file
+//	tls.key:   server key file
+//	tls.ca:    ca certificates file, requiring and verifying client certificates
+type Source struct {
+	mtx       sync.Mutex
+	pc        streams.ProcessorContext
+	listener  net.Listener
+	server    *http.Server
+	store     streams.Store
+	maxBatch  int64
+	committed map[string]uint64
+	sinks     map[string]*sync.Mutex
+}
+
+// SourceSupplier for bridge sources
+func SourceSupplier() (source streams.Source) {
+	return &Source{}
+}
+
+// Init the source and listen on the configured address
+func (s *Source) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	addr := config.Get("addr").String("127.0.0.1:0")
+	s.maxBatch = int64(config.Get("max", "batch").Int(DefaultMaxBatchBytes))
+
+	if err = checkSecurity(config, addr); err != nil {
+		return err
+	}
+
+	if name := config.Get("store").String(""); name != "" {
+		if s.store, err = pc.Store(name); err != nil {
+			return err
+		}
+	}
+
+	tc, err := tlsConfig(config, true)
+	if err != nil {
+		return err
+	}
+
+	if s.listener, err = net.Listen("tcp", addr); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(pathBatch, s.handleBatch)
+	mux.HandleFunc(pathResume, s.handleResume)
+	s.server = &http.Server{Handler: mux, TLSConfig: tc}
+
+	s.committed = make(map[string]uint64)
+	s.sinks = make(map[string]*sync.Mutex)
+	return nil
+}
+
+// Addr returns the address the source is listening on
+func (s *Source) Addr() (addr net.Addr) {
+	return s.listener.Addr()
+}
+
+// Process is a no-op for sources
+func (s *Source) Process(pc streams.ProcessorContext, record streams.Record) {}
+
+// Consume serves the bridge Sinks until the source is closed
+func (s *Source) Consume(pc streams.ProcessorContext) {
+	s.mtx.Lock()
+	s.pc = pc
+	s.mtx.Unlock()
+
+	var err error
+	if s.server.TLSConfig != nil {
+		err = s.server.ServeTLS(s.listener, "", "")
+	} else {
+		err = s.server.Serve(s.listener)
+	}
+
+	if err != nil && err != http.ErrServerClosed {
+		pc.Error(err)
+	}
+}
+
+// Close the source, waiting for the batches being processed
+func (s *Source) Close() (err error) {
+	return s.server.Shutdown(context.Background())
+}
+
+// handleBatch forwards the batch records and replies once they are processed
+func (s *Source) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sink := r.Header.Get(headerSink)
+	sequence, err := strconv.ParseUint(r.Header.Get(headerSequence), 10, 64)
+	if sink == "" || err != nil {
+		http.Error(w, errInvalidBatch.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mtx.Lock()
+	pc := s.pc
+	lock, exists := s.sinks[sink]
+	if !exists {
+		lock = &sync.Mutex{}
+		s.sinks[sink] = lock
+	}
+	s.mtx.Unlock()
+
+	if pc == nil || !pc.IsActive() {
+		http.Error(w, "bridge source not consuming", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Batches of the same sink are processed sequentially
+	lock.Lock()
+	defer lock.Unlock()
+
+	committed, err := s.load(sink)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if sequence <= committed {
+		w.Header().Set(headerCommitted, strconv.FormatUint(committed, 10))
+		return
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBatch))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	done := make(chan struct{})
+	batch := streams.NewBatch(func() error {
+		close(done)
+		return nil
+	})
+
+	records, err := decodeBatch(data, r.Header.Get("Content-Encoding") == encodingSnappy, batch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pc.Heartbeat()
+
+	if len(records) > 0 {
+		for _, record := range records {
+			if err = pc.Forward(record); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		batch.Seal()
+
+		select {
+		case <-done:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if err = s.commit(sink, sequence); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(headerCommitted, strconv.FormatUint(sequence, 10))
+}
+
+// handleResume replies with the last batch sequence committed for the sink
+func (s *Source) handleResume(w http.ResponseWriter, r *http.Request) {
+	committed, err := s.load(r.URL.Query().Get("sink"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(headerCommitted, strconv.FormatUint(committed, 10))
+}
+
+// load the committed sequence of the sink
+func (s *Source) load(sink string) (sequence uint64, err error) {
+	if s.store == nil {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		return s.committed[sink], nil
+	}
+
+	value, err := s.store.Get([]byte(sink))
+	switch err {
+	case nil:
+		return strconv.ParseUint(string(value), 10, 64)
+	case streams.ErrKeyNotFound:
+		return 0, nil
+	}
+	return 0, err
+}
+
+// commit the sequence of the sink
+func (s *Source) commit(sink string, sequence uint64) (err error) {
+	if s.store == nil {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		s.committed[sink] = sequence
+		return nil
+	}
+	return s.store.Set([]byte(sink), []byte(strconv.FormatUint(sequence, 10)))
+}
@@ -0,0 +1,523 @@
+package bridge
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+// Store protocol headers, path and operations
+const (
+	headerStore = "X-Bridge-Store"
+	headerOp    = "X-Bridge-Op"
+	pathStore   = "/store"
+	opGet       = "get"
+	opSet       = "set"
+	opDelete    = "delete"
+	opRange     = "range"
+)
+
+var (
+	errInvalidRanges  = streams.Errorf(streams.CodeConfig, "invalid bridge store key ranges")
+	errInvalidRequest = streams.Errorf(streams.CodeSerialization, "invalid bridge store request")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Store)(nil)
+var _ streams.Closer = (*Store)(nil)
+var _ streams.Remover = (*Store)(nil)
+var _ streams.Store = (*Store)(nil)
+
+// Store shards a state store by key ranges across the instances of a stream
+// deployed without a partitioned source. Each instance keeps the keys of its
+// own ranges in a local store, and serves them to its peers over http, while
+// reads and writes of keys owned by other instances are routed to their owner.
+// Range and RangePrefix iterate the ranges of every instance in key order.
+// It is configured through the <stream>.<store> config subtree with the keys:
+//
+//	instance: name of this instance
+//	addr:     listen address serving the local ranges to peers, defaults to
+//	          127.0.0.1:0. Non loopback addresses require mutual tls
+//	ranges:   list of key ranges with the instance owning them and its url,
+//	          covering all keys as [from, to), with an empty from or to for
+//	          the first and last ranges, e.g.
+//	          [{instance: a, url: https://a:8444, to: m},
+//	           {instance: b, url: https://b:8444, from: m}]
+//	timeout:  timeout of peer requests, defaults to 30s
+//	tls.cert: certificate file, for serving and mutual tls with peers
+//	tls.key:  key file
+//	tls.ca:   ca certificates file verifying the peers certificates
+//
+// The tls cert, key and ca must be set together, requiring the peers to
+// present certificates signed by the ca.
+type Store struct {
+	name     string
+	supplier streams.StoreSupplier
+	local    streams.Store
+	ranges   []keyRange
+	client   *http.Client
+	listener net.Listener
+	server   *http.Server
+}
+
+// keyRange is a range of keys [from, to) owned by an instance
+type keyRange struct {
+	instance string
+	url      string
+	from     []byte
+	to       []byte
+	local    bool
+}
+
+// contains returns if the key is within the range
+func (r keyRange) contains(key []byte) (ok bool) {
+	return bytes.Compare(key, r.from) >= 0 && (r.to == nil || bytes.Compare(key, r.to) < 0)
+}
+
+// clamp returns the intersection of the range with [from, to),
+// and false if they do not intersect
+func (r keyRange) clamp(from, to []byte) (lo, hi []byte, ok bool) {
+	lo, hi = r.from, r.to
+	if from != nil && bytes.Compare(from, lo) > 0 {
+		lo = from
+	}
+	if to != nil && (hi == nil || bytes.Compare(to, hi) < 0) {
+		hi = to
+	}
+	return lo, hi, hi == nil || bytes.Compare(lo, hi) < 0
+}
+
+// StoreSupplier for stores sharded by key ranges across instances,
+// keeping the local ranges in stores from the given supplier
+func StoreSupplier(supplier streams.StoreSupplier) streams.StoreSupplier {
+	return func() (store streams.Store) {
+		return &Store{supplier: supplier}
+	}
+}
+
+// Init the local store and serve the local ranges to the peers
+func (s *Store) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	s.name = pc.NodeName()
+
+	if s.ranges, err = parseRanges(config.Get("instance").String(""), config.Get("ranges").Array()); err != nil {
+		return err
+	}
+
+	addr := config.Get("addr").String("127.0.0.1:0")
+	if err = checkSecurity(config, addr); err != nil {
+		return err
+	}
+
+	s.local = s.supplier()
+	if initializer, ok := s.local.(streams.Initializer); ok {
+		if err = initializer.Init(pc); err != nil {
+			return err
+		}
+	}
+
+	ctc, err := tlsConfig(config, false)
+	if err != nil {
+		return err
+	}
+
+	s.client = &http.Client{
+		Timeout:   config.Get("timeout").Duration(30 * time.Second),
+		Transport: &http.Transport{TLSClientConfig: ctc, Proxy: http.ProxyFromEnvironment},
+	}
+
+	stc, err := tlsConfig(config, true)
+	if err != nil {
+		return err
+	}
+
+	if s.listener, err = net.Listen("tcp", addr); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(pathStore, s.handle)
+	s.server = &http.Server{Handler: mux, TLSConfig: stc}
+
+	go func() {
+		var err error
+		if stc != nil {
+			err = s.server.ServeTLS(s.listener, "", "")
+		} else {
+			err = s.server.Serve(s.listener)
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			pc.Error(err)
+		}
+	}()
+
+	return nil
+}
+
+// parseRanges parses the key ranges config, which must cover all keys
+// without overlapping
+func parseRanges(instance string, config []streams.Config) (ranges []keyRange, err error) {
+	for _, c := range config {
+		r := keyRange{
+			instance: c.Get("instance").String(""),
+			url:      strings.TrimSuffix(c.Get("url").String(""), "/"),
+		}
+
+		if from := c.Get("from").String(""); from != "" {
+			r.from = []byte(from)
+		}
+		if to := c.Get("to").String(""); to != "" {
+			r.to = []byte(to)
+		}
+
+		r.local = r.instance == instance
+		if r.instance == "" || (!r.local && r.url == "") {
+			return nil, errInvalidRanges
+		}
+		ranges = append(ranges, r)
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return bytes.Compare(ranges[i].from, ranges[j].from) < 0 })
+
+	for x, r := range ranges {
+		switch {
+		case x == 0 && r.from != nil:
+			return nil, errInvalidRanges
+		case x > 0 && !bytes.Equal(r.from, ranges[x-1].to):
+			return nil, errInvalidRanges
+		case x == len(ranges)-1 && r.to != nil:
+			return nil, errInvalidRanges
+		}
+	}
+
+	if len(ranges) == 0 {
+		return nil, errInvalidRanges
+	}
+	return ranges, nil
+}
+
+// owner returns the range containing the key
+func (s *Store) owner(key []byte) (r keyRange) {
+	idx := sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i].to == nil || bytes.Compare(key, s.ranges[i].to) < 0
+	})
+	return s.ranges[idx]
+}
+
+// Addr returns the address the store is serving its peers on
+func (s *Store) Addr() (addr net.Addr) {
+	return s.listener.Addr()
+}
+
+// Name returns this store name.
+func (s *Store) Name() (name string) {
+	return s.name
+}
+
+// Process stores or deletes any forwarded record to the store in the owning instance.
+// Records with empty values deletes the given key from the store.
+func (s *Store) Process(pc streams.ProcessorContext, record streams.Record) {
+	if !record.IsValid() || record.Key == nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "invalid record to store"), record)
+		return
+	}
+
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record key: %w", err), record)
+		return
+	}
+
+	if record.Value == nil {
+		if err = s.Delete(key); err != nil {
+			pc.Error(err, record)
+		}
+		return
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record value: %w", err), record)
+		return
+	}
+
+	if err = s.Set(key, value); err != nil {
+		pc.Error(err, record)
+	}
+}
+
+// Get value for the given key from its owner
+func (s *Store) Get(key []byte) (value []byte, err error) {
+	r := s.owner(key)
+	if r.local {
+		return s.local.Get(key)
+	}
+	return s.request(r, opGet, key)
+}
+
+// Set value for the given key in its owner
+func (s *Store) Set(key, value []byte) (err error) {
+	r := s.owner(key)
+	if r.local {
+		return s.local.Set(key, value)
+	}
+	_, err = s.request(r, opSet, key, value)
+	return err
+}
+
+// Delete value for the given key in its owner
+func (s *Store) Delete(key []byte) (err error) {
+	r := s.owner(key)
+	if r.local {
+		return s.local.Delete(key)
+	}
+	_, err = s.request(r, opDelete, key)
+	return err
+}
+
+// Range iterates the ranges of all instances within the given key range in
+// key order, applying the callback for the key value pairs. Returning a error
+// causes the iteration to stop. Remote ranges are fetched before iterated.
+// A nil from or to sets the iterator to the begining or end of Store.
+func (s *Store) Range(from, to []byte, cb func(key, value []byte) error) (err error) {
+	for _, r := range s.ranges {
+		lo, hi, ok := r.clamp(from, to)
+		if !ok {
+			continue
+		}
+
+		if r.local {
+			err = s.local.Range(lo, hi, cb)
+		} else {
+			err = s.remoteRange(r, lo, hi, cb)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RangePrefix iterates the ranges of all instances over a key prefix applying
+// the callback for the key value pairs. Returning a error causes the iteration to stop.
+func (s *Store) RangePrefix(prefix []byte, cb func(key, value []byte) error) (err error) {
+	return s.Range(prefix, prefixEnd(prefix), cb)
+}
+
+// Close the store and its peer server
+func (s *Store) Close() (err error) {
+	if s.server != nil {
+		if err = s.server.Shutdown(context.Background()); err != nil {
+			return err
+		}
+	}
+
+	if closer, ok := s.local.(streams.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Remove closes the store and erases the local ranges
+func (s *Store) Remove() (err error) {
+	if remover, ok := s.local.(streams.Remover); ok {
+		if s.server != nil {
+			if err = s.server.Shutdown(context.Background()); err != nil {
+				return err
+			}
+		}
+		return remover.Remove()
+	}
+	return s.Close()
+}
+
+// remoteRange fetches the key range from the remote owner and applies the callback
+func (s *Store) remoteRange(r keyRange, from, to []byte, cb func(key, value []byte) error) (err error) {
+	data, err := s.request(r, opRange, from, to)
+	if err != nil {
+		return err
+	}
+
+	fields, err := decodeFields(data)
+	if err != nil || len(fields)%2 != 0 {
+		return errInvalidRequest
+	}
+
+	for x := 0; x < len(fields); x += 2 {
+		if err = cb(fields[x], fields[x+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// request the operation over the given fields from the range owner
+func (s *Store) request(r keyRange, op string, fields ...[]byte) (data []byte, err error) {
+	req, err := http.NewRequest(http.MethodPost, r.url+pathStore, bytes.NewReader(encodeFields(fields...)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(headerStore, s.name)
+	req.Header.Set(headerOp, op)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, streams.Errorf(streams.CodeStore, "bridge store %s instance %s: %w", s.name, r.instance, err)
+	}
+	defer resp.Body.Close()
+
+	if data, err = ioutil.ReadAll(resp.Body); err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return data, nil
+	case http.StatusNotFound:
+		return nil, streams.ErrKeyNotFound
+	default:
+		return nil, streams.Errorf(streams.CodeStore, "bridge store %s instance %s: %s",
+			s.name, r.instance, strings.TrimSpace(string(data)))
+	}
+}
+
+// handle the store operations of peers on the local ranges
+func (s *Store) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fields, err := decodeFields(data)
+	if err != nil || len(fields) == 0 || r.Header.Get(headerStore) != s.name {
+		http.Error(w, errInvalidRequest.Error(), http.StatusBadRequest)
+		return
+	}
+
+	op := r.Header.Get(headerOp)
+	if op != opRange && !s.owner(fields[0]).local {
+		http.Error(w, "key not owned by this instance", http.StatusMisdirectedRequest)
+		return
+	}
+
+	switch {
+	case op == opGet:
+		var value []byte
+		if value, err = s.local.Get(fields[0]); err == nil {
+			w.Write(value)
+		}
+	case op == opSet && len(fields) == 2:
+		err = s.local.Set(fields[0], fields[1])
+	case op == opDelete:
+		err = s.local.Delete(fields[0])
+	case op == opRange && len(fields) == 2:
+		var entries [][]byte
+		err = s.localRange(fields[0], fields[1], func(key, value []byte) error {
+			entries = append(entries, append([]byte(nil), key...), append([]byte(nil), value...))
+			return nil
+		})
+		if err == nil {
+			w.Write(encodeFields(entries...))
+		}
+	default:
+		http.Error(w, errInvalidRequest.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case err == streams.ErrKeyNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// localRange iterates the local ranges within the given key range
+func (s *Store) localRange(from, to []byte, cb func(key, value []byte) error) (err error) {
+	for _, r := range s.ranges {
+		if !r.local {
+			continue
+		}
+
+		if lo, hi, ok := r.clamp(from, to); ok {
+			if err = s.local.Range(lo, hi, cb); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// prefixEnd returns the first key after all keys with the prefix,
+// or nil if there is none
+func prefixEnd(prefix []byte) (end []byte) {
+	for x := len(prefix) - 1; x >= 0; x-- {
+		if prefix[x] < 0xff {
+			end = append([]byte(nil), prefix[:x+1]...)
+			end[x]++
+			return end
+		}
+	}
+	return nil
+}
+
+// encodeFields encodes the fields as uvarint length prefixed entries,
+// with empty fields decoded as nil
+func encodeFields(fields ...[]byte) (data []byte) {
+	var tmp [binary.MaxVarintLen64]byte
+	for _, field := range fields {
+		data = append(data, tmp[:binary.PutUvarint(tmp[:], uint64(len(field)))]...)
+		data = append(data, field...)
+	}
+	return data
+}
+
+// decodeFields decodes the uvarint length prefixed fields
+func decodeFields(data []byte) (fields [][]byte, err error) {
+	for len(data) > 0 {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < size {
+			return nil, errInvalidRequest
+		}
+
+		var field []byte
+		if size > 0 {
+			field = data[n : n+int(size)]
+		}
+		fields = append(fields, field)
+		data = data[n+int(size):]
+	}
+	return fields, nil
+}
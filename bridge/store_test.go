@@ -0,0 +1,209 @@
+package bridge
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/brunotm/streams/store"
+	"github.com/brunotm/streams/store/sharded"
+	"github.com/stretchr/testify/assert"
+)
+
+// freeAddr returns a free local address
+func freeAddr(t *testing.T) (addr string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestStore(t *testing.T) {
+	config := streams.NewConfig(nil)
+	config.Set("a", "stream.store.instance")
+	config.Set([]interface{}{map[string]interface{}{"instance": "a"}}, "stream.store.ranges")
+	store.TestStore(t, StoreSupplier(sharded.Supplier),
+		&mock.Context{Data: mock.ContextData{StreamName: "stream", NodeName: "store", Config: config}})
+}
+
+func TestStoreRouting(t *testing.T) {
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	ranges := []interface{}{
+		map[string]interface{}{"instance": "b", "url": "http://" + addrs[1], "from": "m"},
+		map[string]interface{}{"instance": "a", "url": "http://" + addrs[0], "to": "m"},
+	}
+
+	var stores []*Store
+	for x, instance := range []string{"a", "b"} {
+		config := streams.NewConfig(nil)
+		config.Set(instance, "stream.store.instance")
+		config.Set(addrs[x], "stream.store.addr")
+		config.Set(ranges, "stream.store.ranges")
+
+		s := StoreSupplier(sharded.Supplier)().(*Store)
+		assert.NoError(t, s.Init(&mock.Context{Data: mock.ContextData{StreamName: "stream", NodeName: "store", Config: config}}))
+		defer s.Close()
+		stores = append(stores, s)
+	}
+	a, b := stores[0], stores[1]
+
+	// writes are routed to the owner of the key range
+	for _, key := range []string{"apple", "mango", "zebra", "melon"} {
+		assert.NoError(t, a.Set([]byte(key), []byte("v:"+key)))
+	}
+
+	value, err := b.local.Get([]byte("zebra"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v:zebra", string(value))
+	_, err = a.local.Get([]byte("zebra"))
+	assert.Equal(t, streams.ErrKeyNotFound, err)
+
+	value, err = b.Get([]byte("apple"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v:apple", string(value))
+
+	// ranges iterate all instances in key order
+	var keys []string
+	assert.NoError(t, b.Range(nil, nil, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	}))
+	assert.Equal(t, []string{"apple", "mango", "melon", "zebra"}, keys)
+
+	keys = nil
+	assert.NoError(t, a.RangePrefix([]byte("m"), func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	}))
+	assert.Equal(t, []string{"mango", "melon"}, keys)
+
+	keys = nil
+	assert.NoError(t, a.Range([]byte("b"), []byte("n"), func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	}))
+	assert.Equal(t, []string{"mango", "melon"}, keys)
+
+	assert.NoError(t, a.Delete([]byte("zebra")))
+	_, err = a.Get([]byte("zebra"))
+	assert.Equal(t, streams.ErrKeyNotFound, err)
+
+	// peers are unreachable once closed
+	assert.NoError(t, b.Close())
+	_, err = a.Get([]byte("mango"))
+	assert.Equal(t, streams.CodeStore, streams.CodeOf(err))
+}
+
+func TestStoreRanges(t *testing.T) {
+	for _, ranges := range [][]interface{}{
+		nil,
+		{map[string]interface{}{"instance": "a", "to": "m"}},
+		{map[string]interface{}{"instance": "a", "from": "m"}},
+		{map[string]interface{}{"instance": "a", "to": "m"}, map[string]interface{}{"instance": "b", "from": "m"}},
+		{map[string]interface{}{"instance": "a", "to": "m"}, map[string]interface{}{"instance": "a", "from": "n"}},
+	} {
+		config := streams.NewConfig(nil)
+		config.Set("a", "stream.store.instance")
+		config.Set(ranges, "stream.store.ranges")
+
+		err := StoreSupplier(sharded.Supplier)().(*Store).Init(
+			&mock.Context{Data: mock.ContextData{StreamName: "stream", NodeName: "store", Config: config}})
+		assert.Equal(t, errInvalidRanges, err, ranges)
+	}
+}
+
+func TestStoreSecurity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge-store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeCerts(t, dir)
+
+	start := func(addr string, certs map[string]interface{}) (s *Store, err error) {
+		config := streams.NewConfig(nil)
+		config.Set("a", "stream.store.instance")
+		config.Set([]interface{}{map[string]interface{}{"instance": "a"}}, "stream.store.ranges")
+		if addr != "" {
+			config.Set(addr, "stream.store.addr")
+		}
+		if certs != nil {
+			config.Set(certs, "stream.store.tls")
+		}
+
+		s = StoreSupplier(sharded.Supplier)().(*Store)
+		return s, s.Init(&mock.Context{Data: mock.ContextData{StreamName: "stream", NodeName: "store", Config: config}})
+	}
+
+	// plain http is only served on loopback addresses
+	for _, addr := range []string{":0", "0.0.0.0:0", "[::]:0", "10.0.0.1:0"} {
+		_, err = start(addr, nil)
+		assert.Equal(t, errInsecure, err, addr)
+	}
+
+	s, err := start("", nil)
+	assert.NoError(t, err)
+	assert.True(t, s.listener.Addr().(*net.TCPAddr).IP.IsLoopback())
+	assert.NoError(t, s.Close())
+
+	// tls requires the cert, key and ca for mutual tls
+	for _, config := range []map[string]interface{}{
+		{"cert": filepath.Join(dir, "server.pem"), "key": filepath.Join(dir, "server-key.pem")},
+		{"ca": filepath.Join(dir, "ca.pem")},
+	} {
+		_, err = start(":0", config)
+		assert.Equal(t, errTLS, err, config)
+	}
+
+	s, err = start(":0", map[string]interface{}{
+		"cert": filepath.Join(dir, "server.pem"),
+		"key":  filepath.Join(dir, "server-key.pem"),
+		"ca":   filepath.Join(dir, "ca.pem"),
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "ca.pem"))
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	assert.True(t, pool.AppendCertsFromPEM(data))
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem"))
+	assert.NoError(t, err)
+
+	url := fmt.Sprintf("https://127.0.0.1:%d/", s.listener.Addr().(*net.TCPAddr).Port)
+
+	// peers without a client certificate are refused
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	_, err = client.Get(url)
+	assert.Error(t, err)
+
+	client = &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}}}
+	resp, err := client.Get(url)
+	assert.NoError(t, err)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,346 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"github.com/brunotm/streams/types"
+)
+
+// Builder builds a Stream by adding sources, processors, sinks and stores
+// to its topology.
+type Builder struct {
+	name     string
+	config   Config
+	topology *topology
+	handler  ErrorHandler
+	progress RestoreProgress
+	mws      middlewares
+	pool     *Pool
+
+	deadLetters  string
+	durable      []durableEdge
+	transformers map[string]Transformer
+	routes       map[string]map[string]string
+	splits       map[string][]Split
+	versions     map[string]storeVersion
+	dropped      map[string]bool
+	tenants      map[string]TenantExtractor
+	dependencies map[string][]string
+	guarantee    ProcessingGuarantee
+}
+
+// NewBuilder creates a Builder for a Stream with the given name and configuration.
+func NewBuilder(name string, config Config) (b *Builder) {
+	b = &Builder{}
+	b.name = name
+	b.config = config
+	b.topology = newTopology()
+	b.mws.nodes = make(map[string][]ProcessorMiddleware)
+	b.transformers = make(map[string]Transformer)
+	b.routes = make(map[string]map[string]string)
+	b.splits = make(map[string][]Split)
+	b.versions = make(map[string]storeVersion)
+	b.dropped = make(map[string]bool)
+	b.tenants = make(map[string]TenantExtractor)
+	b.dependencies = make(map[string][]string)
+	return b
+}
+
+// AddSource adds a source to the topology
+func (b *Builder) AddSource(name string, supplier SourceSupplier) (err error) {
+	return b.topology.addSource(name, supplier)
+}
+
+// AddProcessor adds a stream processor to the topology
+func (b *Builder) AddProcessor(name string, supplier ProcessorSupplier, predecessors ...string) (err error) {
+	return b.topology.addProcessor(name, supplier, predecessors...)
+}
+
+// AddProcessorFunc adds a stream processor function to the topology.
+// Processor functions are considered stateless and can be fused with adjacent
+// processor functions when the Stream is built with the <stream>.fusion config.
+func (b *Builder) AddProcessorFunc(name string, pf ProcessorFunc, predecessors ...string) (err error) {
+	return b.topology.addProcessorFunc(name, pf, predecessors...)
+}
+
+// AddSink adds a sink processor to the topology
+func (b *Builder) AddSink(name string, supplier ProcessorSupplier, predecessors ...string) (err error) {
+	return b.topology.addSink(name, supplier, predecessors...)
+}
+
+// AddSinkFunc adds a sink processor function to the topology
+func (b *Builder) AddSinkFunc(name string, pf ProcessorFunc, predecessors ...string) (err error) {
+	return b.topology.addSinkFunc(name, pf, predecessors...)
+}
+
+// AddDeadLetterSink adds a sink receiving the records of errors emitted by the
+// other stream components, including processor panics, as records with a
+// DeadLetter value. Records failing in the dead letter sink are not redelivered.
+func (b *Builder) AddDeadLetterSink(name string, supplier ProcessorSupplier) (err error) {
+	return b.topology.addDeadLetterSink(name, supplier)
+}
+
+// AddStore adds a state store to the topology
+func (b *Builder) AddStore(name string, supplier StoreSupplier) (err error) {
+	return b.topology.addStore(name, supplier)
+}
+
+// AddTransformer adds a named record transformer. Transformers are applied to
+// records inbound to processors and sinks before Process, and to records outbound
+// from any node on Forward, as configured in the <stream>.<node>.transform.inbound
+// and <stream>.<node>.transform.outbound config arrays.
+func (b *Builder) AddTransformer(name string, transformer Transformer) (err error) {
+	if name == "" {
+		return errEmptyName
+	}
+
+	if _, exists := b.transformers[name]; exists {
+		return errTransformerExists
+	}
+
+	b.transformers[name] = transformer
+	return nil
+}
+
+// Route dispatches the records forwarded by the parent to the children by
+// topic glob pattern, or regular expression with the RegexpRoutePrefix.
+// The children must be successors of the parent, which forwards all records
+// to the successors not routed. Records not matching any route are dropped.
+// A routing node named <parent>-router is inserted between the parent and
+// the children at Build time, when overlapping glob patterns are rejected.
+func (b *Builder) Route(parent string, routes map[string]string) (err error) {
+	if _, exists := b.routes[parent]; exists || len(routes) == 0 {
+		return errInvalidTopology
+	}
+
+	b.routes[parent] = make(map[string]string, len(routes))
+	for pattern, child := range routes {
+		b.routes[parent][pattern] = child
+	}
+	return nil
+}
+
+// Split forwards the records of the parent to the split children, for A/B
+// splits and the progressive rollout of new branches. Records are forwarded to
+// the child of the first split with a matching condition, or to a child chosen
+// by the split weights, adjustable with Stream.SetSplitWeights. Records with a
+// key take the same branch while the weights are unchanged. The children must
+// be successors of the parent not routed with Route, and the parent forwards
+// all records to the successors not split. A splitting node named
+// <parent>-splitter is inserted between the parent and the children at Build time.
+func (b *Builder) Split(parent string, splits ...Split) (err error) {
+	if _, exists := b.splits[parent]; exists || len(splits) == 0 {
+		return errInvalidTopology
+	}
+
+	children := make(map[string]bool, len(splits))
+	for _, split := range splits {
+		if split.To == "" || children[split.To] {
+			return errInvalidTopology
+		}
+		children[split.To] = true
+	}
+
+	b.splits[parent] = append([]Split(nil), splits...)
+	return nil
+}
+
+// Tenants sets the extractor of the tenant of the records forwarded by the
+// named source. Records of each tenant are routed by their tenant and key,
+// throttled by the <stream>.tenants.quota.<tenant> rate and dropped by the
+// sources once the tenant emits more errors than <stream>.tenants.errors.limit
+// within <stream>.tenants.errors.window, until resumed with Stream.ResumeTenant.
+// Processors isolate the state of each tenant with NewTenantStore.
+func (b *Builder) Tenants(source string, extractor TenantExtractor) {
+	b.tenants[source] = extractor
+}
+
+// DependsOn delays the activation of the named source until the named nodes
+// and stores are initialized and, if they implement the Readier interface, ready.
+// The dependencies are checked at <stream>.readiness.interval up to the
+// <stream>.readiness.timeout, after which the source emits ErrNotReady.
+func (b *Builder) DependsOn(source string, dependencies ...string) {
+	b.dependencies[source] = append(b.dependencies[source], dependencies...)
+}
+
+// Use adds middleware wrapping the Process calls of all processors and sinks.
+// Middleware is applied in the order it is added, the first being the outermost.
+func (b *Builder) Use(middleware ...ProcessorMiddleware) {
+	b.mws.all = append(b.mws.all, middleware...)
+}
+
+// UseFor adds middleware wrapping the Process calls of the named processor or sink.
+// Node middleware is applied after the middleware added with Use.
+// Nodes with middleware are never fused.
+func (b *Builder) UseFor(name string, middleware ...ProcessorMiddleware) {
+	b.mws.nodes[name] = append(b.mws.nodes[name], middleware...)
+}
+
+// Pool sets the pool of shared resources available to the stream
+// components through ProcessorContext.Resource.
+func (b *Builder) Pool(pool *Pool) {
+	b.pool = pool
+}
+
+// DeadLetters sets the store to which the records of errors emitted by the
+// stream components are written as json encoded DeadLetter entries, in the
+// order of their failure, for inspection and replay with Stream.Replay.
+func (b *Builder) DeadLetters(store string) {
+	b.deadLetters = store
+}
+
+// ErrorHandler sets the handler for errors emitted by the stream components,
+// choosing the action for their records. Use ErrorHandlerFunc for handlers only
+// notified of errors, and Policies for per node and category policies.
+func (b *Builder) ErrorHandler(handler ErrorHandler) {
+	b.handler = handler
+}
+
+// RestoreProgress sets the function to report the progress of store restorations
+func (b *Builder) RestoreProgress(progress RestoreProgress) {
+	b.progress = progress
+}
+
+// Validate the stream name and topology without building the Stream.
+func (b *Builder) Validate() (err error) {
+	if b.name == "" {
+		return errEmptyName
+	}
+
+	if err = b.topology.validate(); err != nil {
+		return err
+	}
+
+	for parent, routes := range b.routes {
+		if err = b.topology.validateRoute(parent, routes); err != nil {
+			return err
+		}
+	}
+
+	for parent, splits := range b.splits {
+		if err = b.topology.validateSplit(parent, splits, b.routes[parent]); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := b.topology.stores[b.deadLetters]; b.deadLetters != "" && !ok {
+		return ErrStoreNotFound
+	}
+
+	for store := range b.versions {
+		if _, ok := b.topology.stores[store]; !ok {
+			return ErrStoreNotFound
+		}
+	}
+
+	if err = b.topology.validateDurable(b.durable); err != nil {
+		return err
+	}
+
+	for source := range b.tenants {
+		if node := b.topology.getNode(source); node == nil || node.typ != types.Source {
+			return ErrNodeNotFound
+		}
+	}
+
+	for source, dependencies := range b.dependencies {
+		if node := b.topology.getNode(source); node == nil || node.typ != types.Source {
+			return ErrNodeNotFound
+		}
+
+		for _, name := range dependencies {
+			if name == source {
+				return errInvalidTopology
+			}
+			if b.topology.getNode(name) == nil && b.topology.stores[name] == nil {
+				return ErrNodeNotFound
+			}
+		}
+	}
+
+	return nil
+}
+
+// Build validates the topology and creates the Stream.
+// When enabled with the <stream>.fusion config, linear chains of stateless
+// processors are fused into a single node named after the first processor in
+// the chain, and the other processors are no longer addressable by name for
+// taps, pauses, metrics or ForwardTo. Processors with any <stream>.<node>
+// configuration, node middleware, durable edges, routes or splits are never fused.
+func (b *Builder) Build() (stream *Stream, err error) {
+	if err = b.Validate(); err != nil {
+		return nil, err
+	}
+
+	top, err := b.topology.clone()
+	if err != nil {
+		return nil, err
+	}
+
+	for parent, routes := range b.routes {
+		top.route(parent, routes)
+	}
+
+	splitters := make(map[string]*splitter, len(b.splits))
+	for parent, splits := range b.splits {
+		splitters[parent] = newSplitter(splits)
+		top.split(parent, splitters[parent])
+	}
+
+	if b.config.Get(b.name, "fusion").Bool(false) {
+		top.fuse(func(node *Node) bool {
+			return b.config.IsSet(b.name, node.name) ||
+				len(b.mws.nodes[node.name]) > 0 ||
+				len(b.routes[node.name]) > 0 ||
+				len(b.splits[node.name]) > 0 ||
+				b.isDurable(node.name)
+		})
+	}
+
+	if err = top.validateDurable(b.durable); err != nil {
+		return nil, err
+	}
+
+	if err = top.transforms(b.name, b.config, b.transformers); err != nil {
+		return nil, err
+	}
+
+	for source, extractor := range b.tenants {
+		top.getNode(source).tenant = extractor
+	}
+
+	stream = &Stream{}
+	stream.name = b.name
+	stream.config = b.config
+	stream.topology = top
+	stream.handler = b.handler
+	stream.progress = b.progress
+	stream.mws = b.mws
+	stream.resources.pool = b.pool
+	stream.deadLetters.store = b.deadLetters
+	stream.deadLetters.sink = top.deadLetters
+	stream.durable = b.durable
+	stream.versions = b.versions
+	stream.dependencies = b.dependencies
+	stream.guarantee = b.guarantee
+	stream.splitters = splitters
+	if len(b.tenants) > 0 {
+		stream.tenants = newTenants(b.config.Get(b.name, "tenants"))
+	}
+	stream.donech = make(chan struct{})
+	return stream, nil
+}
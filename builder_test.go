@@ -0,0 +1,196 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func passthrough(pc ProcessorContext, record Record) {
+	pc.Forward(record)
+}
+
+func TestBuilderFusion(t *testing.T) {
+	config := NewConfig(nil)
+	config.Set(true, "stream.fusion")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(10)))
+	assert.NoError(t, b.AddProcessorFunc("filter", passthrough, "source"))
+	assert.NoError(t, b.AddProcessorFunc("map", passthrough, "filter"))
+	assert.NoError(t, b.AddProcessorFunc("enrich", passthrough, "map"))
+
+	received := make(chan Record, 10)
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		received <- record
+	}, "enrich"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.Len(t, stream.topology.nodes, 3)
+
+	fused := stream.topology.getNode("filter")
+	assert.Equal(t, []string{"filter", "map", "enrich"}, fused.Fused())
+	assert.Equal(t, "sink", fused.successors[0].name)
+
+	// the builder topology remains untouched
+	assert.Len(t, b.topology.nodes, 5)
+
+	assert.NoError(t, stream.Start())
+	for x := 0; x < 10; x++ {
+		select {
+		case record := <-received:
+			v, _ := record.EncodeValue()
+			assert.Equal(t, strconv.Itoa(x), string(v))
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for records")
+		}
+	}
+	assert.NoError(t, stream.Close())
+}
+
+func TestBuilderFusionContext(t *testing.T) {
+	config := NewConfig(nil)
+	config.Set(true, "stream.fusion")
+
+	var mtx sync.Mutex
+	var names []string
+	named := func(forward func(pc ProcessorContext, record Record)) ProcessorFunc {
+		return func(pc ProcessorContext, record Record) {
+			mtx.Lock()
+			names = append(names, pc.NodeName())
+			mtx.Unlock()
+			forward(pc, record)
+		}
+	}
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(1)))
+	assert.NoError(t, b.AddProcessorFunc("filter", named(func(pc ProcessorContext, record Record) {
+		assert.Equal(t, ErrNodeNotFound, pc.ForwardTo("enrich", record))
+		pc.ForwardTo("map", record)
+	}), "source"))
+	assert.NoError(t, b.AddProcessorFunc("map", named(func(pc ProcessorContext, record Record) {
+		pc.Forward(record)
+	}), "filter"))
+	assert.NoError(t, b.AddProcessorFunc("enrich", named(func(pc ProcessorContext, record Record) {
+		pc.ForwardTo("sink", record)
+	}), "map"))
+
+	received := make(chan Record, 1)
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		received <- record
+	}, "enrich"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.Len(t, stream.topology.nodes, 3)
+
+	// fused processors see their own names and forward
+	// to their successors within the chain
+	assert.NoError(t, stream.Start())
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for records")
+	}
+	assert.NoError(t, stream.Close())
+
+	mtx.Lock()
+	assert.Equal(t, []string{"filter", "map", "enrich"}, names)
+	mtx.Unlock()
+}
+
+func TestBuilderFusionDisabled(t *testing.T) {
+	config := NewConfig(nil)
+	config.Set(true, "stream.fusion")
+	config.Set(1, "stream.map.tasks.count")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(10)))
+	assert.NoError(t, b.AddProcessorFunc("filter", passthrough, "source"))
+	assert.NoError(t, b.AddProcessorFunc("map", passthrough, "filter"))
+	assert.NoError(t, b.AddSinkFunc("sink", passthrough, "map"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.Len(t, stream.topology.nodes, 4)
+
+	config.Set(nil, "stream.fusion")
+	config.Set(nil, "stream.map")
+	stream, err = b.Build()
+	assert.NoError(t, err)
+	assert.Len(t, stream.topology.nodes, 4)
+}
+
+func TestBuilderFusionGuard(t *testing.T) {
+	config := NewConfig(nil)
+	config.Set(true, "stream.fusion")
+	config.Set(3, "stream.filter.guard.key")
+
+	var errs []error
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	now := time.Now()
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{records: []Record{
+			NewRecord("test", StringEncoder("oversized"), StringEncoder("v"), now, nil),
+			NewRecord("test", StringEncoder("k"), StringEncoder("v"), now, nil),
+		}}
+	}))
+	assert.NoError(t, b.AddProcessorFunc("map", passthrough, "source"))
+	assert.NoError(t, b.AddProcessorFunc("filter", passthrough, "map"))
+
+	var received []string
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		defer wg.Done()
+		key, _ := record.EncodeKey()
+		mtx.Lock()
+		received = append(received, string(key))
+		mtx.Unlock()
+	}, "filter"))
+
+	b.ErrorHandler(ErrorHandlerFunc(func(e Error) {
+		defer wg.Done()
+		mtx.Lock()
+		errs = append(errs, e.Error)
+		mtx.Unlock()
+	}))
+
+	// the guarded node is not fused into its predecessor
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.Len(t, stream.topology.nodes, 4)
+	assert.Nil(t, stream.topology.getNode("map").Fused())
+
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+	assert.NoError(t, stream.Close())
+
+	assert.Equal(t, []string{"k"}, received)
+	assert.Len(t, errs, 1)
+	assert.True(t, errors.Is(errs[0], ErrKeyTooLarge))
+}
@@ -0,0 +1,137 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize is the default maximum number of entries of a processor cache
+const DefaultCacheSize = 1024
+
+// Cache is a processor local least recently used cache with expiring entries,
+// for memoizing expensive lookups like record enrichment. Each task of a node
+// has its own cache, which is cleared when the node is rescaled or closed, as
+// the keys assigned to each task change. The cache is configured through the
+// <stream>.<node>.cache.size and <stream>.<node>.cache.ttl config, where
+// a zero ttl keeps entries until evicted.
+type Cache struct {
+	mtx     sync.Mutex
+	size    int
+	ttl     time.Duration
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// cacheEntry is a cached value and its expiration time
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// NewCache creates a cache with the given maximum number of entries and ttl
+func NewCache(size int, ttl time.Duration) (c *Cache) {
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+
+	c = &Cache{}
+	c.size = size
+	c.ttl = ttl
+	c.lru = list.New()
+	c.entries = make(map[string]*list.Element)
+	return c
+}
+
+// Get the value for the given key
+func (c *Cache) Get(key string) (value interface{}, ok bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return entry.value, true
+}
+
+// Set the value for the given key, evicting the least recently
+// used entry if the cache is full
+func (c *Cache) Set(key string, value interface{}) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = time.Now().Add(c.ttl)
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.value = value
+		entry.expires = expires
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value, expires: expires})
+	if c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Delete the given key
+func (c *Cache) Delete(key string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// Len returns the number of cached entries, including expired ones not yet evicted
+func (c *Cache) Len() (entries int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.lru.Len()
+}
+
+// Clear removes all cached entries
+func (c *Cache) Clear() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
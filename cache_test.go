@@ -0,0 +1,95 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	c := NewCache(2, 0)
+	c.Set("a", 1)
+	c.Set("b", 2)
+
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	// b is the least recently used
+	c.Set("c", 3)
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+
+	c.Delete("a")
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+
+	c.Clear()
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestCacheTTL(t *testing.T) {
+	c := NewCache(0, 10*time.Millisecond)
+	c.Set("a", 1)
+
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	time.Sleep(20 * time.Millisecond)
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	caches := make(chan *Cache, 4)
+
+	config := NewConfig(nil)
+	config.Set(2, "stream.sink.tasks.count")
+	config.Set(10, "stream.sink.cache.size")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(0)))
+	assert.NoError(t, b.AddSinkFunc("sink", passthrough, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	sink := stream.topology.getNode("sink")
+	for _, pc := range stream.tasks[sink].contexts {
+		for x := 0; x < 20; x++ {
+			pc.Cache().Set(strconv.Itoa(x), x)
+		}
+		assert.Equal(t, 10, pc.Cache().Len())
+		caches <- pc.Cache()
+	}
+
+	assert.NoError(t, stream.Scale("sink", 3))
+	assert.Equal(t, 0, (<-caches).Len())
+	assert.Equal(t, 0, (<-caches).Len())
+
+	pc := stream.tasks[sink].contexts[2]
+	pc.Cache().Set("a", 1)
+	assert.NoError(t, stream.Close())
+	assert.Equal(t, 0, pc.Cache().Len())
+}
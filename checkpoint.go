@@ -0,0 +1,197 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"time"
+)
+
+// OffsetStore persists the progress of a source per partition, such as
+// file offsets, sequence numbers or resume tokens.
+type OffsetStore interface {
+	// Offset returns the last committed offset for the partition,
+	// or ErrKeyNotFound if none was committed.
+	Offset(partition string) (offset []byte, err error)
+	// Commit the offset for the partition.
+	Commit(partition string, offset []byte) (err error)
+}
+
+// storeOffsets is a OffsetStore over a streams Store
+type storeOffsets struct {
+	store  Store
+	prefix string
+}
+
+// NewOffsetStore creates a OffsetStore persisting the offsets of the named
+// source in the given store, keyed by source and partition.
+func NewOffsetStore(store Store, source string) (offsets OffsetStore) {
+	return &storeOffsets{store: store, prefix: source + "/"}
+}
+
+// Offset returns the last committed offset for the partition
+func (s *storeOffsets) Offset(partition string) (offset []byte, err error) {
+	return s.store.Get([]byte(s.prefix + partition))
+}
+
+// Commit the offset for the partition
+func (s *storeOffsets) Commit(partition string, offset []byte) (err error) {
+	return s.store.Set([]byte(s.prefix+partition), offset)
+}
+
+// CheckpointedSource tracks the offsets of the records created by a source
+// and commits to a OffsetStore the latest offset of each partition for which
+// all previous records were acknowledged, so a restarted source resumes
+// without losing records still in flight.
+// With a zero interval offsets are committed on ack, otherwise the acknowledged
+// offsets are flushed on every interval and when closed.
+type CheckpointedSource struct {
+	mtx        sync.Mutex
+	offsets    OffsetStore
+	partitions map[string]*checkpoint
+	done       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// checkpoint holds the in flight offsets of a partition in creation order
+type checkpoint struct {
+	pending []*pendingOffset
+	acked   []byte
+	dirty   bool
+}
+
+type pendingOffset struct {
+	offset []byte
+	acked  bool
+}
+
+// NewCheckpointedSource creates a CheckpointedSource committing to the
+// given OffsetStore on ack, or on every interval if greater than 0.
+func NewCheckpointedSource(offsets OffsetStore, interval time.Duration) (c *CheckpointedSource) {
+	c = &CheckpointedSource{}
+	c.offsets = offsets
+	c.partitions = make(map[string]*checkpoint)
+
+	if interval > 0 {
+		c.done = make(chan struct{})
+		c.wg.Add(1)
+		go c.flusher(interval)
+	}
+
+	return c
+}
+
+// Offset returns the last committed offset for the partition,
+// or ErrKeyNotFound if none was committed.
+func (c *CheckpointedSource) Offset(partition string) (offset []byte, err error) {
+	return c.offsets.Offset(partition)
+}
+
+// Track the offset of a record created from the given partition. The returned
+// functions must be used as the record ack and nack functions in NewRecordNack.
+// Nacked records complete their offset as acknowledged ones, as their
+// redelivery or dead lettering is owned by the source, so they do not block
+// the commit of the later offsets of the partition.
+func (c *CheckpointedSource) Track(partition string, offset []byte) (ack func() error, nack func(err error) error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	cp, exists := c.partitions[partition]
+	if !exists {
+		cp = &checkpoint{}
+		c.partitions[partition] = cp
+	}
+
+	pending := &pendingOffset{offset: offset}
+	cp.pending = append(cp.pending, pending)
+
+	ack = func() (err error) {
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+
+		pending.acked = true
+		for len(cp.pending) > 0 && cp.pending[0].acked {
+			cp.acked = cp.pending[0].offset
+			cp.dirty = true
+			cp.pending[0] = nil
+			cp.pending = cp.pending[1:]
+		}
+
+		if c.done == nil {
+			return c.commit(partition, cp)
+		}
+		return nil
+	}
+
+	nack = func(error) (err error) {
+		return ack()
+	}
+	return ack, nack
+}
+
+// Flush commits the acknowledged offsets of all partitions
+func (c *CheckpointedSource) Flush() (err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for partition, cp := range c.partitions {
+		if cerr := c.commit(partition, cp); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Close stops the periodic flush and commits the acknowledged offsets
+func (c *CheckpointedSource) Close() (err error) {
+	if c.done != nil {
+		close(c.done)
+		c.wg.Wait()
+	}
+	return c.Flush()
+}
+
+// commit the acknowledged partition offset if not yet committed
+func (c *CheckpointedSource) commit(partition string, cp *checkpoint) (err error) {
+	if !cp.dirty {
+		return nil
+	}
+
+	if err = c.offsets.Commit(partition, cp.acked); err != nil {
+		return err
+	}
+	cp.dirty = false
+	return nil
+}
+
+// flusher periodically commits the acknowledged offsets until closed
+func (c *CheckpointedSource) flusher(interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			// errors are retried on the next flush
+			_ = c.Flush()
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memStore is a map backed store
+type memStore struct {
+	nopStore
+	mtx  sync.Mutex
+	data map[string][]byte
+}
+
+func (m *memStore) Get(key []byte) ([]byte, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	value, exists := m.data[string(key)]
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+	return value, nil
+}
+
+func (m *memStore) Set(key, value []byte) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.data[string(key)] = value
+	return nil
+}
+
+func (m *memStore) Delete(key []byte) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	delete(m.data, string(key))
+	return nil
+}
+
+func (m *memStore) Range(from, to []byte, cb func(key, value []byte) error) error {
+	m.mtx.Lock()
+	keys := make([]string, 0, len(m.data))
+	for key := range m.data {
+		keys = append(keys, key)
+	}
+	m.mtx.Unlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		m.mtx.Lock()
+		value, exists := m.data[key]
+		m.mtx.Unlock()
+		if !exists {
+			continue
+		}
+		if err := cb([]byte(key), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestCheckpointedSource(t *testing.T) {
+	store := &memStore{data: make(map[string][]byte)}
+	offsets := NewOffsetStore(store, "source")
+	c := NewCheckpointedSource(offsets, 0)
+
+	_, err := c.Offset("p0")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	ack1, _ := c.Track("p0", []byte("1"))
+	ack2, _ := c.Track("p0", []byte("2"))
+	ack3, _ := c.Track("p0", []byte("3"))
+	other, _ := c.Track("p1", []byte("1"))
+
+	// offsets are not committed while previous records are in flight
+	assert.NoError(t, ack2())
+	_, err = c.Offset("p0")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.NoError(t, ack1())
+	offset, err := c.Offset("p0")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(offset))
+
+	assert.NoError(t, ack3())
+	assert.NoError(t, other())
+	offset, _ = c.Offset("p0")
+	assert.Equal(t, "3", string(offset))
+	assert.Equal(t, "1", string(store.data["source/p1"]))
+
+	// nacked records complete their offsets
+	ack, nack := c.Track("p0", []byte("4"))
+	nacked := NewRecordNack("topic", nil, nil, time.Now(), ack, nack)
+	ack, nack = c.Track("p0", []byte("5"))
+	acked := NewRecordNack("topic", nil, nil, time.Now(), ack, nack)
+
+	assert.NoError(t, acked.Ack())
+	assert.NoError(t, nacked.Nack(errors.New("failed")))
+	offset, _ = c.Offset("p0")
+	assert.Equal(t, "5", string(offset))
+	assert.NoError(t, c.Close())
+}
+
+func TestCheckpointedSourcePeriodic(t *testing.T) {
+	store := &memStore{data: make(map[string][]byte)}
+	c := NewCheckpointedSource(NewOffsetStore(store, "source"), time.Hour)
+
+	ack, _ := c.Track("p0", []byte("1"))
+	assert.NoError(t, ack())
+	_, err := c.Offset("p0")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// acknowledged offsets are flushed on close
+	assert.NoError(t, c.Close())
+	offset, err := c.Offset("p0")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(offset))
+}
@@ -0,0 +1,91 @@
+package main
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/brunotm/streams"
+)
+
+var (
+	errAPITLS      = errors.New("management api tls requires cert, key and ca")
+	errInvalidCA   = errors.New("invalid management api ca certificates")
+	errInsecureAPI = errors.New("management api requires mutual tls on non loopback addresses, or -insecure")
+)
+
+// api returns the management api handler for the managed streams:
+//
+//	GET  /metrics:               metrics of all streams in the prometheus text format
+//	     /streams/...:           stream management, as in streams.StreamsHandler
+//	POST /promote?stream=<name>: promotes a standby stream
+func api(m *streams.Streams) (handler http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/promote", streams.PromoteHandler(m))
+	mux.Handle("/metrics", streams.MetricsHandler(m))
+	mux.Handle("/streams", streams.StreamsHandler(m))
+	mux.Handle("/streams/", streams.StreamsHandler(m))
+	return mux
+}
+
+// apiTLS returns the mutual tls configuration of the management api,
+// or nil for plain http, which is only served on loopback addresses
+// unless insecure is set
+func apiTLS(spec Spec, insecure bool) (c *tls.Config, err error) {
+	if spec.TLS == nil {
+		host, _, err := net.SplitHostPort(spec.Addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if ip := net.ParseIP(host); !insecure && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return nil, errInsecureAPI
+		}
+		return nil, nil
+	}
+
+	if spec.TLS.Cert == "" || spec.TLS.Key == "" || spec.TLS.CA == "" {
+		return nil, errAPITLS
+	}
+
+	pair, err := tls.LoadX509KeyPair(spec.TLS.Cert, spec.TLS.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(spec.TLS.CA)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errInvalidCA
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/bridge"
+	"github.com/brunotm/streams/format"
+	"github.com/brunotm/streams/jsonfield"
+	"github.com/brunotm/streams/jsonroute"
+	"github.com/brunotm/streams/outbox"
+	"github.com/brunotm/streams/pii"
+	natssink "github.com/brunotm/streams/processor/sink/nats"
+	natssource "github.com/brunotm/streams/processor/source/nats"
+	"github.com/brunotm/streams/rekey"
+	"github.com/brunotm/streams/reorder"
+	"github.com/brunotm/streams/serde"
+	"github.com/brunotm/streams/split"
+	"github.com/brunotm/streams/store/badger"
+	"github.com/brunotm/streams/store/changelog"
+	"github.com/brunotm/streams/store/leveldb"
+	"github.com/brunotm/streams/store/moss"
+	"github.com/brunotm/streams/store/sharded"
+	"github.com/brunotm/streams/store/ttl"
+	"github.com/brunotm/streams/udf"
+)
+
+// The bundled connectors configured only through the stream config,
+// available to the declarative topologies by type name.
+// The changelog and ttl stores wrap the in memory sharded store.
+var (
+	sources = map[string]streams.SourceSupplier{
+		"bridge": bridge.SourceSupplier,
+		"nats":   natssource.Supplier,
+		"outbox": outbox.Supplier,
+	}
+
+	processors = map[string]streams.ProcessorSupplier{
+		"format":    format.Supplier,
+		"jsonfield": jsonfield.Supplier,
+		"jsonroute": jsonroute.Supplier,
+		"rekey":     rekey.Supplier,
+		"reorder":   reorder.Supplier,
+		"split":     split.SplitSupplier,
+		"collect":   split.CollectSupplier,
+		"udf":       udf.Supplier,
+		"pii":       pii.Supplier(),
+		"transcode": serde.TranscoderSupplier(nil),
+	}
+
+	sinks = map[string]streams.ProcessorSupplier{
+		"bridge": bridge.SinkSupplier,
+		"nats":   natssink.Supplier,
+	}
+
+	stores = map[string]streams.StoreSupplier{
+		"badger":    badger.Supplier,
+		"changelog": changelog.Supplier(sharded.Supplier),
+		"leveldb":   leveldb.Supplier,
+		"moss":      moss.Supplier,
+		"sharded":   sharded.Supplier,
+		"ttl":       ttl.Supplier(sharded.Supplier, ttl.DefaultTTL),
+	}
+)
@@ -0,0 +1,251 @@
+package main
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+// daemon runs the streams of the spec file, rebuilding them when the spec
+// changes, as when updated through a mounted ConfigMap.
+type daemon struct {
+	mtx     sync.RWMutex
+	path    string
+	data    []byte
+	m       *streams.Streams
+	names   []string
+	handler http.Handler
+	standby bool
+	leader  bool
+	ready   bool
+}
+
+// newDaemon creates a daemon for the spec file. Streams start in standby
+// until promoted when the spec enables leader election.
+func newDaemon(path string, spec Spec) (d *daemon) {
+	d = &daemon{path: path}
+	d.standby = spec.Kubernetes != nil && spec.Kubernetes.Lease != ""
+	d.handler = http.NotFoundHandler()
+	return d
+}
+
+// reload the spec file if changed, replacing the running streams.
+// The running streams are kept if the new spec cannot be built or would
+// lose the state of stores not dropped by the spec, and the management api
+// address is not changed by reloads.
+func (d *daemon) reload() (err error) {
+	data, err := ioutil.ReadFile(d.path)
+	if err != nil {
+		return err
+	}
+
+	d.mtx.RLock()
+	prev := d.data
+	d.mtx.RUnlock()
+	if bytes.Equal(data, prev) {
+		return nil
+	}
+
+	var spec Spec
+	if err = json.Unmarshal(data, &spec); err != nil {
+		return err
+	}
+
+	// Specs losing the state of running streams are refused
+	if prev != nil {
+		var old Spec
+		if err = json.Unmarshal(prev, &old); err != nil {
+			return err
+		}
+
+		if err = upgrade(old, spec); err != nil {
+			return err
+		}
+	}
+
+	m, err := d.build(spec)
+	if err != nil {
+		return err
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.ready = false
+	if d.m != nil {
+		log.Printf("streamsd: reloading spec %s", d.path)
+		err = d.m.Close()
+		d.m, d.names, d.handler = nil, nil, http.NotFoundHandler()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = d.run(m, spec); err != nil {
+		return err
+	}
+
+	// Failed starts are retried on the next reload
+	d.data = data
+	return nil
+}
+
+// build the streams of the spec, starting them in standby if needed
+func (d *daemon) build(spec Spec) (m *streams.Streams, err error) {
+	if d.standby {
+		if spec.Config == nil {
+			spec.Config = make(map[string]interface{})
+		}
+
+		config := streams.NewConfig(spec.Config)
+		for _, ss := range spec.Streams {
+			config.Set(true, ss.Name, "standby")
+		}
+	}
+
+	return build(spec)
+}
+
+// run starts the streams, promoting them if the daemon is the leader.
+// It must be called with the daemon lock held.
+func (d *daemon) run(m *streams.Streams, spec Spec) (err error) {
+	d.names = make([]string, 0, len(spec.Streams))
+	for _, ss := range spec.Streams {
+		d.names = append(d.names, ss.Name)
+	}
+	if err = m.Start(); err != nil {
+		// Streams failing to start are not closed as their tasks are not initialized
+		d.names = nil
+		return err
+	}
+
+	d.m = m
+	d.handler = api(m)
+
+	if d.standby && d.leader {
+		if err = d.promoteAll(); err != nil {
+			return err
+		}
+	}
+
+	d.ready = true
+	return nil
+}
+
+// promote the standby streams once the daemon is elected leader
+func (d *daemon) promote() (err error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.leader = true
+	if d.m == nil {
+		return nil
+	}
+	return d.promoteAll()
+}
+
+// promoteAll promotes the standby streams. It must be called with the daemon lock held.
+func (d *daemon) promoteAll() (err error) {
+	for _, name := range d.names {
+		if stream := d.m.Get(name); stream.Standby() {
+			if err = stream.Promote(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// watch reloads the spec file every interval until done is closed.
+// ConfigMap volumes are updated by the kubelet without notifying the
+// process, so the spec file is polled for changes.
+func (d *daemon) watch(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := d.reload(); err != nil {
+				log.Printf("streamsd: reloading spec %s: %s", d.path, err)
+			}
+		}
+	}
+}
+
+// close the running streams
+func (d *daemon) close() (err error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.ready = false
+	if d.m == nil {
+		return nil
+	}
+	return d.m.Close()
+}
+
+// ServeHTTP serves the liveness and readiness probes and the management api:
+//
+//	GET /healthz: liveness, always succeeds while the daemon is serving
+//	GET /readyz:  readiness, succeeds once the streams are started, replying
+//	              with the state of each stream as json, active or standby
+func (d *daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/healthz":
+		w.Write([]byte("ok"))
+		return
+	case "/readyz":
+		d.readyz(w)
+		return
+	}
+
+	d.mtx.RLock()
+	handler := d.handler
+	d.mtx.RUnlock()
+	handler.ServeHTTP(w, r)
+}
+
+// readyz replies with the state of the streams
+func (d *daemon) readyz(w http.ResponseWriter) {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	states := make(map[string]string, len(d.names))
+	for _, name := range d.names {
+		states[name] = "active"
+		if d.m.Get(name).Standby() {
+			states[name] = "standby"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !d.ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(states)
+}
@@ -0,0 +1,103 @@
+package main
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDaemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streamsd")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// bridge endpoint resuming the sinks from the start
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Bridge-Committed", "0")
+	}))
+	defer bridge.Close()
+
+	path := filepath.Join(dir, "streams.json")
+	spec := strings.Replace(testSpec, `"addr": ":9090",`, `"addr": ":9090", "kubernetes": {"lease": "streams"},`, 1)
+	spec = strings.Replace(spec, `"orders": {"format"`, `"orders": {"out": {"url": "`+bridge.URL+`"}, "canary": {"url": "`+bridge.URL+`"}, "format"`, 1)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(spec), 0644))
+
+	s, err := load(path)
+	assert.NoError(t, err)
+	d := newDaemon(path, s)
+	defer d.close()
+
+	probe := func(path string) (int, map[string]string) {
+		w := httptest.NewRecorder()
+		d.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		states := make(map[string]string)
+		json.Unmarshal(w.Body.Bytes(), &states)
+		return w.Code, states
+	}
+
+	code, _ := probe("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	// streams start in standby until the daemon is elected
+	assert.NoError(t, d.reload())
+	code, states := probe("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"orders": "standby"}, states)
+
+	assert.NoError(t, d.promote())
+	_, states = probe("/readyz")
+	assert.Equal(t, map[string]string{"orders": "active"}, states)
+
+	// unchanged specs are not reloaded
+	stream := d.m.Get("orders")
+	assert.NoError(t, d.reload())
+	assert.Equal(t, stream, d.m.Get("orders"))
+
+	// invalid specs keep the running streams
+	assert.NoError(t, ioutil.WriteFile(path, []byte(strings.Replace(spec, `"bridge"`, `"kafka"`, 1)), 0644))
+	assert.Error(t, d.reload())
+	assert.Equal(t, stream, d.m.Get("orders"))
+
+	// specs removing stores without dropping them keep the running streams
+	spec = strings.Replace(spec, `"name": "orders"`, `"name": "payments"`, 1)
+	spec = strings.Replace(spec, `"orders": {"out"`, `"payments": {"out"`, 1)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(spec), 0644))
+	assert.EqualError(t, d.reload(), "orders: store dlq removed without a migration plan")
+	assert.Equal(t, stream, d.m.Get("orders"))
+
+	// changed specs replace the running streams, promoted as the daemon is the leader
+	spec = strings.Replace(spec, `"addr": ":9090",`, `"addr": ":9090", "drop": ["orders.dlq"],`, 1)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(spec), 0644))
+	assert.NoError(t, d.reload())
+	code, states = probe("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"payments": "active"}, states)
+
+	code, _ = probe("/metrics")
+	assert.Equal(t, http.StatusOK, code)
+}
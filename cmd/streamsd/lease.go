@@ -0,0 +1,282 @@
+package main
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// In-cluster service account files
+const (
+	serviceAccountToken     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCA        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+var (
+	errNotInCluster = errors.New("kubernetes api not set and not running in a cluster")
+	errLeaseHeld    = errors.New("lease held by another identity")
+)
+
+// Kubernetes configures the optional Kubernetes integration of the daemon
+type Kubernetes struct {
+	// Lease is the name of the coordination.k8s.io lease for leader election.
+	// When set, streams start in standby and are promoted once the daemon
+	// holds the lease, and the daemon exits if it loses the lease.
+	Lease string `json:"lease"`
+	// Namespace of the lease, defaults to the pod namespace
+	Namespace string `json:"namespace"`
+	// Identity of the daemon as lease holder, defaults to the hostname
+	Identity string `json:"identity"`
+	// Duration of the lease, defaults to 15s
+	Duration string `json:"duration"`
+	// Retry period for acquiring and renewing the lease, defaults to 2s
+	Retry string `json:"retry"`
+	// API is the api server url, defaults to the in-cluster api server
+	API string `json:"api"`
+}
+
+// elector acquires and renews a kubernetes lease through the api server
+type elector struct {
+	client    *http.Client
+	api       string
+	token     string
+	namespace string
+	name      string
+	identity  string
+	duration  time.Duration
+	retry     time.Duration
+}
+
+// newElector creates an elector for the lease, filling the unset fields with
+// the in-cluster defaults. The in-cluster service account token is read on
+// every request, as it is rotated by the kubelet.
+func newElector(k Kubernetes) (e *elector, err error) {
+	e = &elector{name: k.Lease, namespace: k.Namespace, identity: k.Identity, api: k.API}
+	e.client = &http.Client{Timeout: 10 * time.Second}
+
+	if e.duration, err = parseDuration(k.Duration, 15*time.Second); err != nil {
+		return nil, err
+	}
+	if e.retry, err = parseDuration(k.Retry, 2*time.Second); err != nil {
+		return nil, err
+	}
+
+	if e.identity == "" {
+		if e.identity, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.api == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errNotInCluster
+		}
+		e.api = "https://" + net.JoinHostPort(host, port)
+		e.token = serviceAccountToken
+
+		ca, err := ioutil.ReadFile(serviceAccountCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+		e.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+
+	if e.namespace == "" {
+		namespace, err := ioutil.ReadFile(serviceAccountNamespace)
+		if err != nil {
+			return nil, err
+		}
+		e.namespace = strings.TrimSpace(string(namespace))
+	}
+
+	return e, nil
+}
+
+// run acquires and renews the lease every retry period until done is closed.
+// The elected function is called once the lease is acquired, and the lost
+// function if it could not be renewed within two thirds of the lease duration.
+func (e *elector) run(done <-chan struct{}, elected, lost func()) {
+	ticker := time.NewTicker(e.retry)
+	defer ticker.Stop()
+
+	var leader bool
+	var renewed time.Time
+
+	for {
+		err := e.acquire()
+		switch {
+		case err == nil:
+			renewed = time.Now()
+			if !leader {
+				leader = true
+				elected()
+			}
+		case leader && time.Since(renewed) > e.duration*2/3:
+			lost()
+			return
+		case err != errLeaseHeld:
+			log.Printf("streamsd: lease %s/%s: %s", e.namespace, e.name, err)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lease is a coordination.k8s.io/v1 Lease
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// acquire creates, renews or takes over the expired lease. Concurrent updates
+// are rejected by the api server through the lease resource version.
+func (e *elector) acquire() (err error) {
+	path := "/apis/coordination.k8s.io/v1/namespaces/" + e.namespace + "/leases"
+	now := time.Now().UTC().Format(microTime)
+
+	var l lease
+	status, err := e.do(http.MethodGet, path+"/"+e.name, nil, &l)
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case http.StatusNotFound:
+		l = lease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+		l.Metadata = leaseMetadata{Name: e.name, Namespace: e.namespace}
+		l.Spec = leaseSpec{
+			HolderIdentity:       e.identity,
+			LeaseDurationSeconds: int(e.duration / time.Second),
+			AcquireTime:          now,
+			RenewTime:            now,
+		}
+		status, err = e.do(http.MethodPost, path, l, nil)
+
+	case http.StatusOK:
+		if l.Spec.HolderIdentity != e.identity {
+			renew, _ := time.Parse(time.RFC3339Nano, l.Spec.RenewTime)
+			duration := time.Duration(l.Spec.LeaseDurationSeconds) * time.Second
+			if l.Spec.HolderIdentity != "" && time.Now().Before(renew.Add(duration)) {
+				return errLeaseHeld
+			}
+
+			l.Spec.HolderIdentity = e.identity
+			l.Spec.AcquireTime = now
+			l.Spec.LeaseTransitions++
+		}
+		l.Spec.LeaseDurationSeconds = int(e.duration / time.Second)
+		l.Spec.RenewTime = now
+		status, err = e.do(http.MethodPut, path+"/"+e.name, l, nil)
+
+	default:
+		return fmt.Errorf("getting lease: unexpected status %d", status)
+	}
+
+	switch {
+	case err != nil:
+		return err
+	case status == http.StatusConflict:
+		return errLeaseHeld
+	case status != http.StatusOK && status != http.StatusCreated:
+		return fmt.Errorf("updating lease: unexpected status %d", status)
+	}
+	return nil
+}
+
+// do the api request, encoding the body and decoding successful responses into result
+func (e *elector) do(method, path string, body, result interface{}) (status int, err error) {
+	var data []byte
+	if body != nil {
+		if data, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+
+	req, err := http.NewRequest(method, e.api+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	if e.token != "" {
+		token, err := ioutil.ReadFile(e.token)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if result != nil && resp.StatusCode == http.StatusOK {
+		if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return 0, err
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// parseDuration parses the duration, returning def if empty
+func parseDuration(s string, def time.Duration) (d time.Duration, err error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,113 @@
+package main
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// leaseServer is a fake api server for coordination.k8s.io leases
+type leaseServer struct {
+	mtx     sync.Mutex
+	leases  map[string]lease
+	version int
+}
+
+func (s *leaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	switch r.Method {
+	case http.MethodGet:
+		l, ok := s.leases[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(l)
+
+	case http.MethodPost, http.MethodPut:
+		var l lease
+		json.NewDecoder(r.Body).Decode(&l)
+
+		current, exists := s.leases[l.Metadata.Name]
+		if (r.Method == http.MethodPost && exists) ||
+			(r.Method == http.MethodPut && current.Metadata.ResourceVersion != l.Metadata.ResourceVersion) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		s.version++
+		l.Metadata.ResourceVersion = string(rune('0' + s.version))
+		s.leases[l.Metadata.Name] = l
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+	}
+}
+
+func (s *leaseServer) holder(name string) (identity string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.leases[name].Spec.HolderIdentity
+}
+
+func TestElector(t *testing.T) {
+	ls := &leaseServer{leases: make(map[string]lease)}
+	server := httptest.NewServer(ls)
+	defer server.Close()
+
+	newTestElector := func(identity string) *elector {
+		e, err := newElector(Kubernetes{Lease: "streams", Namespace: "default",
+			Identity: identity, Duration: "1s", Retry: "20ms", API: server.URL})
+		assert.NoError(t, err)
+		return e
+	}
+
+	first, second := newTestElector("first"), newTestElector("second")
+	assert.NoError(t, first.acquire())
+	assert.NoError(t, first.acquire())
+	assert.Equal(t, errLeaseHeld, second.acquire())
+	assert.Equal(t, "first", ls.holder("streams"))
+
+	// the second takes over once the lease of the stopped first expires
+	elected := make(chan string, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go second.run(done, func() { elected <- "second" }, func() {})
+
+	select {
+	case identity := <-elected:
+		assert.Equal(t, "second", identity)
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for election")
+	}
+	assert.Equal(t, "second", ls.holder("streams"))
+
+	ls.mtx.Lock()
+	defer ls.mtx.Unlock()
+	assert.Equal(t, 1, ls.leases["streams"].Spec.LeaseTransitions)
+}
@@ -0,0 +1,116 @@
+package main
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// streamsd runs the streams declared in a json spec file with the bundled
+// connectors, serving the management api, metrics and health probes until
+// interrupted. The management api is served with mutual tls if configured,
+// or plain http only on loopback addresses unless run with -insecure. The spec is reloaded on SIGHUP and, when the watch interval is
+// set, whenever the spec file changes, as when mounted from a ConfigMap.
+func main() {
+	path := flag.String("spec", "streams.json", "json spec file of the streams")
+	addr := flag.String("addr", "", "management api listen address, overrides the spec addr")
+	watch := flag.Duration("watch", 0, "interval for reloading the spec file on changes, 0 disables")
+	insecure := flag.Bool("insecure", false, "serve the management api without tls on non loopback addresses")
+	flag.Parse()
+
+	spec, err := load(*path)
+	if err != nil {
+		log.Fatalf("streamsd: loading spec: %s", err)
+	}
+
+	if *addr != "" {
+		spec.Addr = *addr
+	}
+
+	if spec.Addr == "" {
+		spec.Addr = "127.0.0.1:8080"
+	}
+
+	tc, err := apiTLS(spec, *insecure)
+	if err != nil {
+		log.Fatalf("streamsd: management api: %s", err)
+	}
+
+	d := newDaemon(*path, spec)
+	if err = d.reload(); err != nil {
+		log.Fatalf("streamsd: starting streams: %s", err)
+	}
+
+	server := &http.Server{Addr: spec.Addr, Handler: d, TLSConfig: tc}
+	go func() {
+		var err error
+		if tc != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("streamsd: serving management api: %s", err)
+		}
+	}()
+
+	done := make(chan struct{})
+	if *watch > 0 {
+		go d.watch(*watch, done)
+	}
+
+	if d.standby {
+		e, err := newElector(*spec.Kubernetes)
+		if err != nil {
+			log.Fatalf("streamsd: leader election: %s", err)
+		}
+
+		go e.run(done, func() {
+			log.Printf("streamsd: elected leader as %s", e.identity)
+			if err := d.promote(); err != nil {
+				log.Printf("streamsd: promoting streams: %s", err)
+			}
+		}, func() {
+			// Promoted streams cannot return to standby
+			d.close()
+			log.Fatalf("streamsd: lost lease %s/%s", e.namespace, e.name)
+		})
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if err = d.reload(); err != nil {
+			log.Printf("streamsd: reloading spec %s: %s", *path, err)
+		}
+	}
+
+	close(done)
+	server.Close()
+	if err = d.close(); err != nil {
+		log.Fatalf("streamsd: closing streams: %s", err)
+	}
+}
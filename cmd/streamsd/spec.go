@@ -0,0 +1,265 @@
+package main
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/sql"
+)
+
+// Node kinds
+const (
+	kindSource    = "source"
+	kindProcessor = "processor"
+	kindSink      = "sink"
+	kindStore     = "store"
+	kindQuery     = "query"
+)
+
+var (
+	errUnknownKind = errors.New("unknown node kind")
+	errUnknownType = errors.New("unknown connector type")
+)
+
+// Spec is the declarative specification of the streams run by the daemon
+type Spec struct {
+	// Addr is the listen address of the management api, defaults to 127.0.0.1:8080
+	Addr string `json:"addr"`
+	// TLS serves the management api with mutual tls, which is required
+	// for non loopback addresses unless the daemon runs with -insecure
+	TLS *TLS `json:"tls"`
+	// Config is the configuration of all streams, with the <stream>.<node> keys
+	Config map[string]interface{} `json:"config"`
+	// Streams are the stream topologies
+	Streams []StreamSpec `json:"streams"`
+	// Kubernetes enables the optional Kubernetes integration
+	Kubernetes *Kubernetes `json:"kubernetes"`
+	// Drop are the <stream>.<store> stores of the previous spec whose state is
+	// discarded, allowing them to be removed or renamed on reloads
+	Drop []string `json:"drop"`
+}
+
+// TLS is the mutual tls configuration of the management api
+type TLS struct {
+	// Cert is the server certificate file
+	Cert string `json:"cert"`
+	// Key is the server key file
+	Key string `json:"key"`
+	// CA is the ca certificates file verifying the client certificates
+	CA string `json:"ca"`
+}
+
+// StreamSpec is the topology of a stream
+type StreamSpec struct {
+	Name        string     `json:"name"`
+	DeadLetters string     `json:"dead_letters"`
+	Nodes       []NodeSpec `json:"nodes"`
+	// Splits are the weighted or conditional splits of the records of a parent
+	Splits map[string][]SplitSpec `json:"splits"`
+}
+
+// SplitSpec is a split branch to a child of its parent. Records with a topic
+// matching the topic glob pattern are forwarded to the child, and the others
+// by the split weights, adjustable through the management api.
+type SplitSpec struct {
+	To     string  `json:"to"`
+	Weight float64 `json:"weight"`
+	Topic  string  `json:"topic"`
+}
+
+// NodeSpec is a node of a stream topology. The type names a bundled connector
+// for sources, processors, sinks and stores, while query nodes run the sql query.
+// Nodes are configured through the <stream>.<node> config subtree.
+type NodeSpec struct {
+	Name  string   `json:"name"`
+	Kind  string   `json:"kind"`
+	Type  string   `json:"type"`
+	Query string   `json:"query"`
+	From  []string `json:"from"`
+}
+
+// load the spec from the json file
+func load(path string) (spec Spec, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return spec, err
+	}
+
+	if err = json.Unmarshal(data, &spec); err != nil {
+		return spec, err
+	}
+	return spec, nil
+}
+
+// build the streams of the spec, adding them to a new manager
+func build(spec Spec) (m *streams.Streams, err error) {
+	bs, err := builders(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	m = streams.NewStreams(nil)
+	for x, b := range bs {
+		if _, err = m.Add(b); err != nil {
+			return nil, fmt.Errorf("%s: %s", spec.Streams[x].Name, err)
+		}
+	}
+
+	return m, nil
+}
+
+// builders creates the stream builders of the spec, in spec order
+func builders(spec Spec) (bs []*streams.Builder, err error) {
+	config := streams.NewConfig(spec.Config)
+
+	for _, ss := range spec.Streams {
+		b := streams.NewBuilder(ss.Name, config)
+		for _, ns := range ss.Nodes {
+			if err = addNode(b, ns); err != nil {
+				return nil, fmt.Errorf("%s.%s: %s", ss.Name, ns.Name, err)
+			}
+		}
+
+		for parent, specs := range ss.Splits {
+			if err = b.Split(parent, splits(specs)...); err != nil {
+				return nil, fmt.Errorf("%s.%s: %s", ss.Name, parent, err)
+			}
+		}
+
+		b.ErrorHandler(streams.ErrorHandlerFunc(logError))
+		if ss.DeadLetters != "" {
+			b.DeadLetters(ss.DeadLetters)
+		}
+		drop(b, ss.Name, spec.Drop)
+
+		bs = append(bs, b)
+	}
+
+	return bs, nil
+}
+
+// upgrade checks if the streams of the spec can replace the streams of the
+// previous spec in-place, refusing to lose the state of stores not dropped.
+// Streams removed from the spec are compared with an empty topology.
+func upgrade(prev, spec Spec) (err error) {
+	old, err := builders(prev)
+	if err != nil {
+		return err
+	}
+
+	bs, err := builders(spec)
+	if err != nil {
+		return err
+	}
+
+	for x, ob := range old {
+		name := prev.Streams[x].Name
+		b := streams.NewBuilder(name, streams.NewConfig(nil))
+		drop(b, name, spec.Drop)
+
+		for y := range spec.Streams {
+			if spec.Streams[y].Name == name {
+				b = bs[y]
+			}
+		}
+
+		if err = streams.Diff(ob, b).Err(); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// drop declares the stream stores of the <stream>.<store> names as dropped
+func drop(b *streams.Builder, stream string, names []string) {
+	for _, name := range names {
+		if strings.HasPrefix(name, stream+".") {
+			b.DropStore(strings.TrimPrefix(name, stream+"."))
+		}
+	}
+}
+
+// splits creates the split branches of the specs
+func splits(specs []SplitSpec) (splits []streams.Split) {
+	for _, spec := range specs {
+		split := streams.Split{To: spec.To, Weight: spec.Weight}
+		if pattern := spec.Topic; pattern != "" {
+			split.Condition = func(record streams.Record) bool {
+				matched, _ := path.Match(pattern, record.Topic)
+				return matched
+			}
+		}
+		splits = append(splits, split)
+	}
+	return splits
+}
+
+// addNode adds the node to the builder from the bundled connectors
+func addNode(b *streams.Builder, ns NodeSpec) (err error) {
+	switch ns.Kind {
+	case kindSource:
+		supplier, ok := sources[ns.Type]
+		if !ok {
+			return errUnknownType
+		}
+		return b.AddSource(ns.Name, supplier)
+
+	case kindProcessor:
+		supplier, ok := processors[ns.Type]
+		if !ok {
+			return errUnknownType
+		}
+		return b.AddProcessor(ns.Name, supplier, ns.From...)
+
+	case kindSink:
+		supplier, ok := sinks[ns.Type]
+		if !ok {
+			return errUnknownType
+		}
+		return b.AddSink(ns.Name, supplier, ns.From...)
+
+	case kindStore:
+		supplier, ok := stores[ns.Type]
+		if !ok {
+			return errUnknownType
+		}
+		return b.AddStore(ns.Name, supplier)
+
+	case kindQuery:
+		query, err := sql.Parse(ns.Query)
+		if err != nil {
+			return err
+		}
+		return query.Build(b, ns.Name, ns.From...)
+	}
+
+	return errUnknownKind
+}
+
+// logError logs the errors emitted by the stream components
+func logError(e streams.Error) {
+	log.Printf("streamsd: %s: %s", e.Node.Name(), e.Error)
+}
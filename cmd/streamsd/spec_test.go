@@ -0,0 +1,114 @@
+package main
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSpec = `{
+	"addr": ":9090",
+	"config": {"orders": {"format": {"template": "{{.Key}}"}}},
+	"streams": [{
+		"name": "orders",
+		"dead_letters": "dlq",
+		"nodes": [
+			{"name": "dlq", "kind": "store", "type": "sharded"},
+			{"name": "in", "kind": "source", "type": "bridge"},
+			{"name": "paid", "kind": "query", "query": "SELECT id FROM orders WHERE status = 'paid'", "from": ["in"]},
+			{"name": "format", "kind": "processor", "type": "format", "from": ["paid"]},
+			{"name": "out", "kind": "sink", "type": "bridge", "from": ["format"]},
+			{"name": "canary", "kind": "sink", "type": "bridge", "from": ["format"]}
+		],
+		"splits": {"format": [{"to": "out", "weight": 95}, {"to": "canary", "weight": 5}]}
+	}]
+}`
+
+func TestBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "streamsd")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "streams.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(testSpec), 0644))
+
+	spec, err := load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, ":9090", spec.Addr)
+
+	m, err := build(spec)
+	assert.NoError(t, err)
+	assert.NotNil(t, m.Get("orders"))
+
+	w := httptest.NewRecorder()
+	api(m).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(),
+		`streams_node_records_processed_total{node="format",stream="orders"} 0 `))
+
+	w = httptest.NewRecorder()
+	api(m).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/streams/orders/splits?parent=format", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"out":95,"canary":5}`, w.Body.String())
+
+	for name := range stores {
+		spec.Streams[0].Nodes[0].Type = name
+		_, err = build(spec)
+		assert.NoError(t, err, name)
+	}
+
+	spec.Streams[0].Nodes[1].Type = "kafka"
+	_, err = build(spec)
+	assert.EqualError(t, err, "orders.in: "+errUnknownType.Error())
+
+	spec.Streams[0].Nodes[1].Kind = "table"
+	_, err = build(spec)
+	assert.EqualError(t, err, "orders.in: "+errUnknownKind.Error())
+}
+
+func TestAPITLS(t *testing.T) {
+	// plain http is only served on loopback addresses unless insecure
+	for _, addr := range []string{"127.0.0.1:8080", "localhost:8080", "[::1]:8080"} {
+		tc, err := apiTLS(Spec{Addr: addr}, false)
+		assert.NoError(t, err, addr)
+		assert.Nil(t, tc, addr)
+	}
+
+	for _, addr := range []string{":8080", "0.0.0.0:8080", "10.0.0.1:8080"} {
+		_, err := apiTLS(Spec{Addr: addr}, false)
+		assert.Equal(t, errInsecureAPI, err, addr)
+
+		tc, err := apiTLS(Spec{Addr: addr}, true)
+		assert.NoError(t, err, addr)
+		assert.Nil(t, tc, addr)
+	}
+
+	// tls requires the cert, key and ca for mutual tls
+	_, err := apiTLS(Spec{Addr: ":8080", TLS: &TLS{Cert: "server.pem", Key: "server-key.pem"}}, false)
+	assert.Equal(t, errAPITLS, err)
+
+	_, err = apiTLS(Spec{Addr: ":8080", TLS: &TLS{Cert: "missing.pem", Key: "missing-key.pem", CA: "ca.pem"}}, false)
+	assert.Error(t, err)
+}
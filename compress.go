@@ -0,0 +1,191 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// Compression identifies the compression of persisted data: changelog entries,
+// including the compacted segments restored as the store state, durable edge
+// logs and leveldb values. The identifier is stored with the data, so data
+// written with any registered compression can be read regardless of the
+// compression currently configured.
+type Compression byte
+
+// Built-in compressions. Zstd is provided by the github.com/brunotm/streams/zstd
+// module, registered when imported, as its pure Go implementation requires
+// newer Go versions than this module.
+const (
+	CompressionNone   Compression = 0
+	CompressionSnappy Compression = 1
+	CompressionZstd   Compression = 2
+	CompressionGzip   Compression = 3
+)
+
+var (
+	// ErrCompressionNotFound is returned when the compression is not registered
+	ErrCompressionNotFound = Errorf(CodeConfig, "compression not found")
+
+	errCompressionName = Errorf(CodeConfig, "compression name cannot be empty")
+	errCompressionNone = Errorf(CodeConfig, "compression none cannot be replaced")
+)
+
+// Compressor compresses and decompresses data, appending the result to dst.
+// Compressors must be safe for concurrent use.
+type Compressor interface {
+	Compress(dst, src []byte) (data []byte, err error)
+	Decompress(dst, src []byte) (data []byte, err error)
+}
+
+// compressors registry
+var compressors = struct {
+	sync.RWMutex
+	names map[string]Compression
+	byID  map[Compression]Compressor
+}{
+	names: map[string]Compression{"none": CompressionNone, "snappy": CompressionSnappy, "gzip": CompressionGzip},
+	byID:  map[Compression]Compressor{CompressionSnappy: snappyCompressor{}, CompressionGzip: gzipCompressor{}},
+}
+
+// RegisterCompressor registers the compressor with the given identifier and
+// name, replacing any compressor with the same identifier or name.
+// Identifiers must be stable, as they are persisted with the compressed data.
+func RegisterCompressor(id Compression, name string, compressor Compressor) (err error) {
+	if name == "" {
+		return errCompressionName
+	}
+
+	if id == CompressionNone || name == "none" {
+		return errCompressionNone
+	}
+
+	compressors.Lock()
+	defer compressors.Unlock()
+
+	for n, c := range compressors.names {
+		if c == id {
+			delete(compressors.names, n)
+		}
+	}
+
+	compressors.names[name] = id
+	compressors.byID[id] = compressor
+	return nil
+}
+
+// CompressionOf returns the compression registered with the given name.
+// An empty name is CompressionNone.
+func CompressionOf(name string) (c Compression, err error) {
+	if name == "" {
+		return CompressionNone, nil
+	}
+
+	compressors.RLock()
+	defer compressors.RUnlock()
+
+	c, ok := compressors.names[name]
+	if !ok {
+		return 0, ErrCompressionNotFound
+	}
+	return c, nil
+}
+
+// Compress appends the data compressed with the compression to dst
+func (c Compression) Compress(dst, src []byte) (data []byte, err error) {
+	if c == CompressionNone {
+		return append(dst, src...), nil
+	}
+
+	compressor, err := c.compressor()
+	if err != nil {
+		return nil, err
+	}
+	return compressor.Compress(dst, src)
+}
+
+// Decompress appends the data decompressed with the compression to dst
+func (c Compression) Decompress(dst, src []byte) (data []byte, err error) {
+	if c == CompressionNone {
+		return append(dst, src...), nil
+	}
+
+	compressor, err := c.compressor()
+	if err != nil {
+		return nil, err
+	}
+	return compressor.Decompress(dst, src)
+}
+
+func (c Compression) compressor() (compressor Compressor, err error) {
+	compressors.RLock()
+	defer compressors.RUnlock()
+
+	compressor, ok := compressors.byID[c]
+	if !ok {
+		return nil, ErrCompressionNotFound
+	}
+	return compressor, nil
+}
+
+// snappyCompressor is the built-in snappy compressor
+type snappyCompressor struct{}
+
+func (snappyCompressor) Compress(dst, src []byte) (data []byte, err error) {
+	return append(dst, snappy.Encode(nil, src)...), nil
+}
+
+func (snappyCompressor) Decompress(dst, src []byte) (data []byte, err error) {
+	data, err = snappy.Decode(nil, src)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, data...), nil
+}
+
+// gzipCompressor is the built-in gzip compressor
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(dst, src []byte) (data []byte, err error) {
+	buf := bytes.NewBuffer(dst)
+	w := gzip.NewWriter(buf)
+	if _, err = w.Write(src); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(dst, src []byte) (data []byte, err error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if data, err = ioutil.ReadAll(r); err != nil {
+		return nil, err
+	}
+	return append(dst, data...), nil
+}
@@ -0,0 +1,96 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flateCompressor compresses with deflate
+type flateCompressor struct{}
+
+func (flateCompressor) Compress(dst, src []byte) (data []byte, err error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(src); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return append(dst, buf.Bytes()...), nil
+}
+
+func (flateCompressor) Decompress(dst, src []byte) (data []byte, err error) {
+	if data, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(src))); err != nil {
+		return nil, err
+	}
+	return append(dst, data...), nil
+}
+
+func TestCompression(t *testing.T) {
+	value := bytes.Repeat([]byte("value"), 100)
+
+	for _, name := range []string{"", "none", "snappy", "gzip"} {
+		c, err := CompressionOf(name)
+		assert.NoError(t, err)
+
+		data, err := c.Compress([]byte("prefix"), value)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("prefix"), data[:6])
+
+		data, err = c.Decompress(nil, data[6:])
+		assert.NoError(t, err)
+		assert.Equal(t, value, data)
+	}
+
+	_, err := CompressionOf("flate")
+	assert.Equal(t, ErrCompressionNotFound, err)
+
+	_, err = Compression(100).Compress(nil, value)
+	assert.Equal(t, ErrCompressionNotFound, err)
+
+	assert.Equal(t, errCompressionName, RegisterCompressor(100, "", flateCompressor{}))
+	assert.Equal(t, errCompressionNone, RegisterCompressor(CompressionNone, "flate", flateCompressor{}))
+	assert.Equal(t, errCompressionNone, RegisterCompressor(100, "none", flateCompressor{}))
+
+	assert.NoError(t, RegisterCompressor(100, "flate", flateCompressor{}))
+	c, err := CompressionOf("flate")
+	assert.NoError(t, err)
+	assert.Equal(t, Compression(100), c)
+
+	data, err := c.Compress(nil, value)
+	assert.NoError(t, err)
+	assert.True(t, len(data) < len(value))
+
+	data, err = c.Decompress(nil, data)
+	assert.NoError(t, err)
+	assert.Equal(t, value, data)
+
+	// the name of a replaced identifier is unregistered
+	assert.NoError(t, RegisterCompressor(100, "deflate", flateCompressor{}))
+	_, err = CompressionOf("flate")
+	assert.Equal(t, ErrCompressionNotFound, err)
+}
@@ -17,6 +17,7 @@ package streams
 */
 
 import (
+	"encoding/json"
 	"strconv"
 	"strings"
 	"time"
@@ -225,6 +226,11 @@ func (c Config) Map() (value map[string]Config) {
 	return value
 }
 
+// MarshalJSON encodes the config items as json
+func (c Config) MarshalJSON() (data []byte, err error) {
+	return json.Marshal(c.data)
+}
+
 // Set the value for the given path
 func (c Config) Set(value interface{}, path ...string) {
 	if len(path) == 1 {
@@ -249,11 +255,13 @@ func search(source interface{}, path []string) (data interface{}) {
 
 		case []interface{}:
 			idx, err := strconv.ParseInt(key, 10, 64)
-			if err != nil || int(idx) > len(tmp) {
+			if err != nil || idx < 0 || int(idx) >= len(tmp) {
 				return nil
 			}
 			data = tmp[idx]
 
+		default:
+			return nil
 		}
 	}
 
@@ -1,7 +1,10 @@
 package streams
 
 import (
+	"context"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/brunotm/streams/types"
 )
@@ -10,14 +13,33 @@ import (
 // task and processor information, routing of records to children processors,
 // access to configured stores and contextual logging.
 type processorContext struct {
-	active int32
-	stream *Stream
-	node   *Node
+	pushed    int64
+	processed int64
+	eventTime int64 // highest record time in unix nanoseconds
+	eventSeen int64 // time of the last timed record forwarded by sources
+	started   int64 // start of the record being processed in unix nanoseconds
+	drain     int64
+	active    int32
+	task      int
+	stream    *Stream
+	node      *Node
+	processor Processor
+	handler   ProcessorFunc
+	cache     *Cache
+	cacheOnce sync.Once
+	ctx       context.Context
+	cancel    context.CancelFunc
+	punct     punctuations
+
+	// restartMtx guards the processor and handler of supervised
+	// nodes, replaced by the supervisor after a panic
+	restartMtx sync.RWMutex
 }
 
 func newContext(s *Stream) (pc *processorContext) {
 	pc = &processorContext{}
 	pc.stream = s
+	pc.ctx, pc.cancel = context.WithCancel(s.context())
 	return pc
 }
 
@@ -26,6 +48,11 @@ func (pc *processorContext) NodeName() (name string) {
 	return pc.node.name
 }
 
+// TaskID returns the current task id.
+func (pc *processorContext) TaskID() (id int) {
+	return pc.task
+}
+
 // StreamName returns the stream name.
 func (pc *processorContext) StreamName() (name string) {
 	return pc.stream.name
@@ -36,6 +63,21 @@ func (pc *processorContext) Config() (config Config) {
 	return pc.stream.config
 }
 
+// Context returns the context of the current task
+func (pc *processorContext) Context() (ctx context.Context) {
+	if pc.ctx == nil {
+		return context.Background()
+	}
+	return pc.ctx
+}
+
+// stop cancels the context of the current task
+func (pc *processorContext) stop() {
+	if pc.cancel != nil {
+		pc.cancel()
+	}
+}
+
 // IsActive returns if this context is active and can forward records to the stream.
 func (pc *processorContext) IsActive() (active bool) {
 	return atomic.LoadInt32(&pc.active) > 0
@@ -43,19 +85,57 @@ func (pc *processorContext) IsActive() (active bool) {
 
 // Store returns the store for the given name
 func (pc *processorContext) Store(name string) (store Store, err error) {
-	node, exists := pc.stream.topology.stores[name]
-	if !exists {
-		return nil, ErrStoreNotFound
+	node, err := pc.stream.store(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stores mutations are committed by checkpoints with ExactlyOnce
+	if pc.stream.coordinator != nil {
+		return pc.stream.coordinator.store(name, node.processor.(Store)), nil
 	}
 
 	return node.processor.(Store), nil
 }
 
+// Cache returns the cache of this context, created on the first call.
+func (pc *processorContext) Cache() (cache *Cache) {
+	pc.cacheOnce.Do(func() {
+		config := pc.stream.config.Get(pc.stream.name, pc.node.name, "cache")
+		pc.cache = NewCache(config.Get("size").Int(DefaultCacheSize), config.Get("ttl").Duration(0))
+	})
+	return pc.cache
+}
+
+// invalidate the context cache if it was created
+func (pc *processorContext) invalidate() {
+	pc.cacheOnce.Do(func() {})
+	if pc.cache != nil {
+		pc.cache.Clear()
+	}
+}
+
+// Resource returns the shared resource with the given name.
+func (pc *processorContext) Resource(name string) (resource interface{}, err error) {
+	return pc.stream.resources.get(name)
+}
+
 // Error emits a error event to be handled by the Stream.
 func (pc *processorContext) Error(err error, records ...Record) {
-	if pc.stream.handler != nil {
-		pc.stream.handler(Error{pc.node, err, records})
+	e := pc.event(err, records, 0)
+	action, _ := pc.stream.handleError(e)
+	pc.stream.apply(action, e)
+}
+
+// event records the error of the given processing attempt of the records
+func (pc *processorContext) event(err error, records []Record, attempt int) (e Error) {
+	atomic.AddInt64(&pc.node.metrics.errors, 1)
+	e = Error{Node: pc.node, Error: err, Record: records, Category: CategoryOf(err),
+		Tenant: tenantOf(records), Attempt: attempt}
+	if pc.stream.tenants != nil {
+		pc.stream.tenants.fail(e.Tenant, time.Now())
 	}
+	return e
 }
 
 // Forward the record to the downstream processors. Can be called multiple times
@@ -66,8 +146,19 @@ func (pc *processorContext) Forward(record Record) (err error) {
 		return ErrInvalidForward
 	}
 
-	pc.stream.tasks.forwardFrom(pc.node, record)
-	return nil
+	return pc.admit(record, pc.node.forward)
+}
+
+// Broadcast is like forward, but it sends the record to every task of the
+// downstream processors, for records that all task instances must observe.
+// Broadcasts are delivered sequentially regardless of the node fan-out.
+func (pc *processorContext) Broadcast(record Record) (err error) {
+
+	if !pc.IsActive() || (len(pc.node.successors) == 0 || pc.node.typ == types.Sink) {
+		return ErrInvalidForward
+	}
+
+	return pc.admit(record, pc.node.broadcast)
 }
 
 // ForwardTo is like forward, but it forwards the record only to the given node
@@ -77,7 +168,243 @@ func (pc *processorContext) ForwardTo(to string, record Record) (err error) {
 		return ErrInvalidForward
 	}
 
-	return pc.stream.tasks.forwardTo(to, record)
+	node := pc.stream.topology.getNode(to)
+	if node == nil || node.typ == types.Source {
+		return ErrNodeNotFound
+	}
+
+	return pc.admit(record, func(record Record) {
+		if record.ack != nil {
+			record.ack.retain(1)
+		}
+		node.receive(record)
+	})
+}
+
+// admit the record forwarded by the node, delivering it with the given
+// function once transformed and prioritized. Records of tenants not admitted
+// are dropped. Records forwarded by sources are admitted after the source is
+// resumed, within the stream memory budget and after checkpoints in progress,
+// are throttled by their topic quotas and tracked for duplicates, and have
+// the first reference held by the source released once delivered.
+func (pc *processorContext) admit(record Record, deliver func(Record)) (err error) {
+	source := pc.node.typ == types.Source
+
+	// Paused sources block before being admitted
+	if source {
+		pc.waitResume()
+	}
+
+	var admitted bool
+	if record, admitted = pc.tenant(record); !admitted {
+		return pc.drop(record)
+	}
+
+	// Sources are admitted within the stream memory budget
+	if source && !pc.stream.limits.admit() {
+		return pc.shedRecord(record)
+	}
+
+	// Sources wait for checkpoints in progress
+	if source {
+		pc.stream.coordinator.enter()
+		defer pc.stream.coordinator.exit()
+
+		now := time.Now()
+		pc.observeTime(record.Time, now)
+		pc.stream.inflight.track(record.ack, now)
+		if pc.node.liveness != nil {
+			pc.node.liveness.observe(now)
+		}
+		if pc.node.ackDeadline != nil {
+			pc.node.ackDeadline.watch(pc, record)
+		}
+	}
+
+	// Sources are throttled by their topic quotas
+	if pc.node.quotas != nil {
+		pc.node.quotas.wait(record.Topic)
+	}
+
+	// Sources track redelivered records within the duplicates ttl
+	if pc.node.duplicates != nil && pc.node.duplicates.observe(record) {
+		atomic.AddInt64(&pc.node.metrics.duplicates, 1)
+	}
+
+	if transformed, ok := pc.transform(pc.node.outbound, record); ok {
+		// Sources have their forwarded records tapped
+		if source {
+			pc.node.tap(transformed)
+		}
+		deliver(pc.node.prioritize(transformed))
+		atomic.AddInt64(&pc.node.metrics.forwarded, 1)
+	}
+
+	// Sources hold the first reference of the records they create
+	if source && record.ack != nil {
+		if err = record.ack.release(); err != nil {
+			pc.Error(err, record)
+		}
+	}
+
+	return nil
+}
+
+// tenant assigns the tenant to the records forwarded by sources with a tenant
+// extractor. It returns false if the records of the tenant are not admitted.
+func (pc *processorContext) tenant(record Record) (tenanted Record, ok bool) {
+	if pc.node.tenant == nil {
+		return record, true
+	}
+
+	if record.Tenant == "" {
+		record = record.WithTenant(pc.node.tenant(record))
+	}
+	return record, pc.stream.tenants.admit(record.Tenant)
+}
+
+// drop the record of a tenant not admitted, releasing the source reference
+func (pc *processorContext) drop(record Record) (err error) {
+	atomic.AddInt64(&pc.node.metrics.dropped, 1)
+	if record.ack != nil {
+		if err = record.ack.release(); err != nil {
+			pc.Error(err, record)
+		}
+	}
+	return nil
+}
+
+// process the record with the context processor, incrementing the context
+// activation during the call and decrementing its activation afterwards.
+// The record reference retained for this node is released after processing.
+func (pc *processorContext) process(record Record) {
+	pc.waitResume()
+	start := time.Now()
+	atomic.StoreInt64(&pc.started, start.UnixNano())
+	pc.activate()
+	pc.observeTime(record.Time, time.Time{})
+	scheduled := pc.punct.lock()
+	if transformed, ok := pc.transform(pc.node.inbound, record); ok && pc.guard(transformed) {
+		pc.node.tap(transformed)
+		pc.handle(transformed)
+	}
+	if scheduled {
+		pc.advance(record)
+		pc.punct.run.Unlock()
+	}
+	pc.deactivate()
+
+	now := time.Now()
+	atomic.StoreInt64(&pc.started, 0)
+	atomic.AddInt64(&pc.node.metrics.duration, int64(now.Sub(start)))
+	pc.node.metrics.latency.observe(now.Sub(start))
+	atomic.AddInt64(&pc.node.metrics.processed, 1)
+	if pc.node.typ == types.Sink {
+		pc.checkBudget(record, now)
+	}
+
+	if record.ack != nil {
+		if err := record.ack.release(); err != nil {
+			pc.Error(err, record)
+		}
+	}
+}
+
+// handle the record with the node processor, processing it again while the
+// emitted errors are retried, unless the stream is stopping, when the records
+// of the errors are dead lettered instead.
+// Processor panics are recovered and emitted as PanicErrors, and the
+// panicking processor is restarted if the node is supervised.
+func (pc *processorContext) handle(record Record) {
+	if pc.stream == nil || pc.stream.handler == nil {
+		if pc.call(pc, record) != nil {
+			pc.supervise()
+		}
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		rc := &retryContext{processorContext: pc, attempt: attempt}
+		if pc.call(rc, record) != nil {
+			pc.supervise()
+		}
+		if !rc.retry {
+			return
+		}
+
+		timer := time.NewTimer(rc.backoff)
+		select {
+		case <-timer.C:
+		case <-pc.stream.stop.stopping():
+			timer.Stop()
+			for _, e := range rc.errors {
+				pc.stream.deadLetters.write(pc.stream, e)
+			}
+			return
+		}
+	}
+}
+
+// call the node processor with the given context, within the node deadline
+// if configured, returning the PanicError if the processor panics
+func (pc *processorContext) call(ctx ProcessorContext, record Record) (failure error) {
+	defer func() {
+		if r := recover(); r != nil {
+			failure = recoverPanic(ctx, record, r)
+		}
+	}()
+
+	if pc.node.deadline > 0 {
+		dc := &deadlineContext{ProcessorContext: ctx}
+		var cancel context.CancelFunc
+		dc.ctx, cancel = context.WithTimeout(pc.Context(), pc.node.deadline)
+		defer cancel()
+		ctx = dc
+	}
+
+	if pc.node.supervisor != nil {
+		pc.restartMtx.RLock()
+		defer pc.restartMtx.RUnlock()
+	}
+
+	if pc.handler != nil {
+		pc.handler(ctx, record)
+		return nil
+	}
+	pc.processor.Process(ctx, record)
+	return nil
+}
+
+// deadlineContext is the context given to the processor while processing a
+// record with a deadline
+type deadlineContext struct {
+	ProcessorContext
+	ctx context.Context
+}
+
+// Context returns the context of the record, canceled at its deadline
+func (dc *deadlineContext) Context() (ctx context.Context) {
+	return dc.ctx
+}
+
+// current returns the context processor
+func (pc *processorContext) current() (processor Processor) {
+	pc.restartMtx.RLock()
+	defer pc.restartMtx.RUnlock()
+	return pc.processor
+}
+
+// guard returns if the record passes the node guards, emitting the violation otherwise
+func (pc *processorContext) guard(record Record) (ok bool) {
+	if pc.node.guard == nil {
+		return true
+	}
+
+	if err := pc.node.guard.check(pc.node.name, record); err != nil {
+		pc.Error(err, record)
+		return false
+	}
+	return true
 }
 
 // activate increments this context activation count
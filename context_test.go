@@ -0,0 +1,132 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// contextSource forwards a record and waits for its context to be canceled
+type contextSource struct {
+	done chan struct{}
+}
+
+func (s *contextSource) Process(pc ProcessorContext, record Record) {}
+
+func (s *contextSource) Consume(pc ProcessorContext) {
+	pc.Forward(NewRecord("", StringEncoder("k"), StringEncoder("v"), time.Now(), nil))
+	<-pc.Context().Done()
+	close(s.done)
+}
+
+func TestProcessorContext(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	config := NewConfig(nil)
+	config.Set("1m", "stream.sink.deadline")
+
+	var sinkCtx, recordCtx context.Context
+	source := &contextSource{done: make(chan struct{})}
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source { return source }))
+	assert.NoError(t, b.AddSink("sink", func() Processor {
+		return &initProcessor{
+			init: func(pc ProcessorContext) { sinkCtx = pc.Context() },
+			process: func(pc ProcessorContext, record Record) {
+				defer wg.Done()
+				recordCtx = pc.Context()
+			},
+		}
+	}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+
+	// records are processed within the node deadline
+	deadline, ok := recordCtx.Deadline()
+	assert.True(t, ok)
+	assert.True(t, time.Until(deadline) <= time.Minute)
+	assert.Error(t, recordCtx.Err())
+
+	_, ok = sinkCtx.Deadline()
+	assert.False(t, ok)
+	assert.NoError(t, sinkCtx.Err())
+
+	// sources and processors are canceled once closed
+	assert.NoError(t, stream.Close())
+	select {
+	case <-source.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("source context not canceled")
+	}
+	assert.Error(t, sinkCtx.Err())
+}
+
+// initProcessor calls the given functions on init and process
+type initProcessor struct {
+	init    func(pc ProcessorContext)
+	process func(pc ProcessorContext, record Record)
+}
+
+func (p *initProcessor) Init(pc ProcessorContext) (err error) {
+	p.init(pc)
+	return nil
+}
+
+func (p *initProcessor) Process(pc ProcessorContext, record Record) {
+	p.process(pc, record)
+}
+
+func TestProcessorContextForwardTo(t *testing.T) {
+	acks := make(chan string, 3)
+	received := make(chan string, 3)
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: ackedRecords(3, acks), to: "sinkB"}
+	}))
+	assert.NoError(t, b.AddSinkFunc("sinkA", func(pc ProcessorContext, record Record) {
+		received <- "sinkA"
+	}, "source"))
+	assert.NoError(t, b.AddSinkFunc("sinkB", func(pc ProcessorContext, record Record) {
+		received <- "sinkB"
+	}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	defer stream.Close()
+
+	// records forwarded by sources to a node are acknowledged once processed
+	for x := 0; x < 3; x++ {
+		select {
+		case <-acks:
+		case <-time.After(5 * time.Second):
+			t.Fatal("record not acknowledged")
+		}
+		assert.Equal(t, "sinkB", <-received)
+	}
+}
@@ -0,0 +1,346 @@
+package delay
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+const (
+	// DefaultDelay is the default record delay
+	DefaultDelay = time.Minute
+	// DefaultInterval is the default interval for scanning due records
+	DefaultInterval = time.Second
+)
+
+// Key prefixes of the delayed records and of their key index
+const (
+	duePrefix   = 'd'
+	indexPrefix = 'k'
+)
+
+var (
+	errMalformed = streams.Errorf(streams.CodeSerialization, "malformed delayed record")
+
+	// sequence disambiguates records held with the same due time
+	sequence = uint64(time.Now().UnixNano())
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Hold)(nil)
+var _ streams.Processor = (*Hold)(nil)
+var _ streams.Initializer = (*Release)(nil)
+var _ streams.Closer = (*Release)(nil)
+var _ streams.Source = (*Release)(nil)
+
+// Hold writes records to a store to be forwarded by a Release source once the
+// delay configured with <stream>.<node>.delay elapses, for cooling-off periods,
+// delayed retries and undo windows. A durable store keeps the held records
+// across restarts. Records with a key and no value are undo records, cancelling
+// the records with the same key still held.
+type Hold struct {
+	store string
+	delay time.Duration
+	db    streams.Store
+}
+
+// HoldSupplier for a Hold processor over the named store with the given delay
+func HoldSupplier(store string, delay time.Duration) streams.ProcessorSupplier {
+	return func() (processor streams.Processor) {
+		return &Hold{store: store, delay: delay}
+	}
+}
+
+// Init the processor
+func (h *Hold) Init(pc streams.ProcessorContext) (err error) {
+	h.delay = pc.Config().Get(pc.StreamName(), pc.NodeName(), "delay").Duration(h.delay)
+	if h.delay <= 0 {
+		h.delay = DefaultDelay
+	}
+
+	h.db, err = pc.Store(h.store)
+	return err
+}
+
+// Process holds the record, or cancels the held records of undo records
+func (h *Hold) Process(pc streams.ProcessorContext, record streams.Record) {
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	if record.Value == nil && key != nil {
+		if err = cancel(h.db, key); err != nil {
+			pc.Error(err, record)
+		}
+		return
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	due := make([]byte, 17)
+	due[0] = duePrefix
+	binary.BigEndian.PutUint64(due[1:], uint64(record.Time.Add(h.delay).UnixNano()))
+	binary.BigEndian.PutUint64(due[9:], atomic.AddUint64(&sequence, 1))
+
+	if err = h.db.Set(due, encode(record.Topic, key, value, record.Time)); err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	if key != nil {
+		if err = h.db.Set(index(key, due), []byte{}); err != nil {
+			pc.Error(err, record)
+		}
+	}
+}
+
+// Release is a source that scans the store of a Hold processor on a interval,
+// forwarding the records whose delay elapsed with their original topic, key,
+// value and time. Released records are deleted from the store once processed.
+// The interval can be set with the <stream>.<node>.interval config.
+type Release struct {
+	mtx      sync.Mutex
+	store    string
+	interval time.Duration
+	db       streams.Store
+	done     chan struct{}
+
+	// released records not yet processed
+	imtx     sync.Mutex
+	inflight map[string]bool
+}
+
+// ReleaseSupplier for a Release source over the named store with the given scan interval
+func ReleaseSupplier(store string, interval time.Duration) streams.SourceSupplier {
+	return func() (source streams.Source) {
+		return &Release{store: store, interval: interval}
+	}
+}
+
+// Init the source
+func (r *Release) Init(pc streams.ProcessorContext) (err error) {
+	r.interval = pc.Config().Get(pc.StreamName(), pc.NodeName(), "interval").Duration(r.interval)
+	if r.interval <= 0 {
+		r.interval = DefaultInterval
+	}
+
+	if r.db, err = pc.Store(r.store); err != nil {
+		return err
+	}
+
+	r.inflight = make(map[string]bool)
+	r.done = make(chan struct{})
+	return nil
+}
+
+// Close stops the source after any in progress scan
+func (r *Release) Close() (err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	close(r.done)
+	return nil
+}
+
+// Process is a no-op for sources
+func (r *Release) Process(pc streams.ProcessorContext, record streams.Record) {}
+
+// Consume scans the store for due records on every interval until closed
+func (r *Release) Consume(pc streams.ProcessorContext) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			r.release(pc, now)
+		}
+	}
+}
+
+// release forwards the due records not in flight unless the source is closed
+func (r *Release) release(pc streams.ProcessorContext, now time.Time) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+
+	to := make([]byte, 9)
+	to[0] = duePrefix
+	binary.BigEndian.PutUint64(to[1:], uint64(now.UnixNano())+1)
+
+	type due struct{ key, value []byte }
+	var records []due
+
+	r.imtx.Lock()
+	err := r.db.Range([]byte{duePrefix}, to, func(key, value []byte) error {
+		if !r.inflight[string(key)] {
+			r.inflight[string(key)] = true
+			records = append(records, due{append([]byte(nil), key...), append([]byte(nil), value...)})
+		}
+		return nil
+	})
+	r.imtx.Unlock()
+
+	if err != nil {
+		pc.Error(err)
+		return
+	}
+
+	for _, d := range records {
+		topic, key, value, ts, err := decode(d.value)
+		if err != nil {
+			pc.Error(err)
+			continue
+		}
+
+		dueKey := d.key
+		record := streams.NewRecord(topic, encoder(key), encoder(value), ts, func() error {
+			return r.ack(dueKey, key)
+		})
+
+		if err = pc.Forward(record); err != nil {
+			pc.Error(err, record)
+		}
+	}
+}
+
+// ack deletes the processed record from the store
+func (r *Release) ack(due, key []byte) (err error) {
+	defer func() {
+		r.imtx.Lock()
+		delete(r.inflight, string(due))
+		r.imtx.Unlock()
+	}()
+
+	if key != nil {
+		if err = r.db.Delete(index(key, due)); err != nil {
+			return err
+		}
+	}
+	return r.db.Delete(due)
+}
+
+// cancel the held records with the given key
+func cancel(db streams.Store, key []byte) (err error) {
+	prefix := append([]byte{indexPrefix}, key...)
+	prefix = append(prefix, 0)
+
+	var indexes [][]byte
+	err = db.RangePrefix(prefix, func(k, v []byte) error {
+		indexes = append(indexes, append([]byte(nil), k...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range indexes {
+		if err = db.Delete(idx[len(prefix):]); err != nil {
+			return err
+		}
+		if err = db.Delete(idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// index returns the index key of the held record
+func index(key, due []byte) (idx []byte) {
+	idx = make([]byte, 0, len(key)+len(due)+2)
+	idx = append(idx, indexPrefix)
+	idx = append(idx, key...)
+	idx = append(idx, 0)
+	return append(idx, due...)
+}
+
+// encode the held record
+func encode(topic string, key, value []byte, ts time.Time) (data []byte) {
+	data = make([]byte, 8, 8+len(topic)+len(key)+len(value)+3*binary.MaxVarintLen64)
+	binary.BigEndian.PutUint64(data, uint64(ts.UnixNano()))
+	data = appendBytes(data, []byte(topic), true)
+	data = appendBytes(data, key, key != nil)
+	return appendBytes(data, value, value != nil)
+}
+
+// decode the held record
+func decode(data []byte) (topic string, key, value []byte, ts time.Time, err error) {
+	if len(data) < 8 {
+		return "", nil, nil, ts, errMalformed
+	}
+	ts = time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+	data = data[8:]
+
+	var t []byte
+	if t, data, err = readBytes(data); err != nil {
+		return "", nil, nil, ts, err
+	}
+	if key, data, err = readBytes(data); err != nil {
+		return "", nil, nil, ts, err
+	}
+	if value, _, err = readBytes(data); err != nil {
+		return "", nil, nil, ts, err
+	}
+	return string(t), key, value, ts, nil
+}
+
+// appendBytes appends the length prefixed bytes, where a zero length is nil
+func appendBytes(data, b []byte, set bool) (encoded []byte) {
+	var buf [binary.MaxVarintLen64]byte
+	if !set {
+		return append(data, 0)
+	}
+	data = append(data, buf[:binary.PutUvarint(buf[:], uint64(len(b))+1)]...)
+	return append(data, b...)
+}
+
+// readBytes reads length prefixed bytes
+func readBytes(data []byte) (b, rest []byte, err error) {
+	l, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n)+1 < l {
+		return nil, nil, errMalformed
+	}
+	if l == 0 {
+		return nil, data[n:], nil
+	}
+	return data[n : n+int(l)-1], data[n+int(l)-1:], nil
+}
+
+// encoder returns the encoder for the bytes, nil if not set
+func encoder(b []byte) (e streams.Encoder) {
+	if b == nil {
+		return nil
+	}
+	return streams.ByteEncoder(b)
+}
@@ -0,0 +1,115 @@
+package delay
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/brunotm/streams/store/sharded"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelay(t *testing.T) {
+	var mtx sync.Mutex
+	var released []string
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	now := time.Now()
+	held := make(chan struct{}, 4)
+	records := []streams.Record{
+		streams.NewRecord("test", streams.StringEncoder("a"), streams.StringEncoder("1"), now, nil),
+		streams.NewRecord("test", streams.StringEncoder("b"), streams.StringEncoder("2"), now, nil),
+		streams.NewRecord("test", nil, streams.StringEncoder("3"), now, nil),
+		streams.NewRecord("test", streams.StringEncoder("b"), nil, now, nil),
+	}
+
+	config := streams.NewConfig(nil)
+	config.Set("10ms", "stream.release.interval")
+
+	b := streams.NewBuilder("stream", config)
+	assert.NoError(t, b.AddStore("held", sharded.Supplier))
+	assert.NoError(t, b.AddSource("source", func() streams.Source {
+		return &mock.Source{Records: records}
+	}))
+	assert.NoError(t, b.AddSink("hold", HoldSupplier("held", 100*time.Millisecond), "source"))
+	b.UseFor("hold", func(next streams.ProcessorFunc) streams.ProcessorFunc {
+		return func(pc streams.ProcessorContext, record streams.Record) {
+			next(pc, record)
+			held <- struct{}{}
+		}
+	})
+	assert.NoError(t, b.AddSource("release", ReleaseSupplier("held", 0)))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc streams.ProcessorContext, record streams.Record) {
+		value, _ := record.EncodeValue()
+		mtx.Lock()
+		released = append(released, string(value))
+		mtx.Unlock()
+		assert.Equal(t, now.UnixNano(), record.Time.UnixNano())
+		wg.Done()
+	}, "release"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	for x := 0; x < len(records); x++ {
+		<-held
+	}
+
+	// nothing is released before the delay elapses
+	mtx.Lock()
+	assert.Len(t, released, 0)
+	mtx.Unlock()
+
+	wg.Wait()
+	assert.ElementsMatch(t, []string{"1", "3"}, released)
+
+	// released records are deleted once processed
+	db, err := stream.Store("held")
+	assert.NoError(t, err)
+	entries := func() (entries int) {
+		db.Range(nil, nil, func(key, value []byte) error {
+			entries++
+			return nil
+		})
+		return entries
+	}
+	for x := 0; x < 100 && entries() > 0; x++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, entries())
+
+	assert.NoError(t, stream.Close())
+}
+
+func TestEncoding(t *testing.T) {
+	ts := time.Unix(0, 42)
+	topic, key, value, decoded, err := decode(encode("topic", nil, []byte{}, ts))
+	assert.NoError(t, err)
+	assert.Equal(t, "topic", topic)
+	assert.Nil(t, key)
+	assert.Equal(t, []byte{}, value)
+	assert.Equal(t, ts, decoded)
+
+	_, _, _, _, err = decode([]byte{0, 0, 0, 0, 0, 0, 0, 1, 5})
+	assert.Equal(t, errMalformed, err)
+}
@@ -0,0 +1,210 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/brunotm/streams/types"
+)
+
+// TopologyDiff is the difference between the topology of a running stream and
+// the topology replacing it, used to check if an in-place upgrade is safe.
+type TopologyDiff struct {
+	// Added are the nodes and stores only in the new topology
+	Added []string
+	// Removed are the nodes and stores only in the old topology
+	Removed []string
+	// Renamed maps the old to the new names of removed nodes and stores
+	// matching an added one with the same type and neighbours
+	Renamed map[string]string
+	// Changed are the nodes with a different type or predecessors
+	Changed []string
+	// Stores is the compatibility of the old topology stores, in name order
+	Stores []StoreDiff
+}
+
+// StoreDiff is the compatibility of a store state with the new topology
+type StoreDiff struct {
+	// Name of the store in the old topology
+	Name string
+	// From and To are the declared schema versions in the old and new topology
+	From, To int
+	// Removed is true if the store is not in the new topology under the same name
+	Removed bool
+	// Dropped is true if the new topology discards the store state
+	Dropped bool
+	// Compatible is true if the new topology can use or safely discard the store state
+	Compatible bool
+}
+
+// DropStore declares the state of the named store of the topology being replaced
+// as discarded on upgrades, the migration plan for removing or renaming a store.
+func (b *Builder) DropStore(name string) {
+	b.dropped[name] = true
+}
+
+// Diff compares the topologies of the old and new builders, reporting the
+// added, removed, renamed and changed nodes and the compatibility of the old
+// stores with the new topology.
+func Diff(old, new *Builder) (diff TopologyDiff) {
+	diff.Renamed = make(map[string]string)
+
+	for _, node := range old.topology.nodes {
+		if n := new.topology.getNode(node.name); n == nil {
+			diff.Removed = append(diff.Removed, node.name)
+		} else if n.typ != node.typ || !sameNames(n.predecessors, node.predecessors) {
+			diff.Changed = append(diff.Changed, node.name)
+		}
+	}
+
+	for _, node := range new.topology.nodes {
+		if old.topology.getNode(node.name) == nil {
+			diff.Added = append(diff.Added, node.name)
+		}
+	}
+
+	for name := range old.topology.stores {
+		if _, ok := new.topology.stores[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+
+	for name := range new.topology.stores {
+		if _, ok := old.topology.stores[name]; !ok {
+			diff.Added = append(diff.Added, name)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	diff.renamed(old.topology, new.topology)
+
+	for name := range old.topology.stores {
+		store := StoreDiff{Name: name}
+		store.From = old.versions[name].version
+		store.Dropped = new.dropped[name]
+
+		if _, ok := new.topology.stores[name]; !ok {
+			store.Removed = true
+		}
+
+		declared, ok := new.versions[name]
+		switch {
+		case store.Removed || store.Dropped:
+			store.Compatible = store.Dropped
+		case !ok:
+			store.Compatible = store.From == 0
+		default:
+			store.To = declared.version
+			store.Compatible = store.To == store.From ||
+				(store.To > store.From && declared.migrator != nil)
+		}
+
+		diff.Stores = append(diff.Stores, store)
+	}
+
+	sort.Slice(diff.Stores, func(i, j int) bool { return diff.Stores[i].Name < diff.Stores[j].Name })
+	return diff
+}
+
+// renamed pairs the removed and added nodes or stores with the same type,
+// predecessors and successors when neither matches any other
+func (d *TopologyDiff) renamed(old, new *topology) {
+	matches := make(map[string][]string)
+	for _, name := range d.Removed {
+		for _, added := range d.Added {
+			if sameNode(lookup(old, name), lookup(new, added)) {
+				matches[name] = append(matches[name], added)
+				matches[added] = append(matches[added], name)
+			}
+		}
+	}
+
+	for _, name := range d.Removed {
+		if len(matches[name]) == 1 && len(matches[matches[name][0]]) == 1 {
+			d.Renamed[name] = matches[name][0]
+		}
+	}
+}
+
+// Err returns the unsafe changes of the upgrade as Errors, or nil if the
+// new topology can replace the old one in-place. Removing or renaming a store
+// without dropping it, and changing its schema version without a migration
+// are unsafe, as its state would be lost or misread.
+func (d TopologyDiff) Err() (err error) {
+	var errs Errors
+	for _, store := range d.Stores {
+		if store.Compatible {
+			continue
+		}
+
+		switch {
+		case store.Removed && d.Renamed[store.Name] != "":
+			errs = append(errs, fmt.Errorf("store %s renamed to %s without a migration plan",
+				store.Name, d.Renamed[store.Name]))
+		case store.Removed:
+			errs = append(errs, fmt.Errorf("store %s removed without a migration plan", store.Name))
+		case store.To < store.From:
+			errs = append(errs, fmt.Errorf("store %s downgraded from version %d to %d",
+				store.Name, store.From, store.To))
+		default:
+			errs = append(errs, fmt.Errorf("store %s upgraded from version %d to %d without a migrator",
+				store.Name, store.From, store.To))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// lookup returns the node or store with the given name
+func lookup(t *topology, name string) (node *Node) {
+	if node = t.getNode(name); node != nil {
+		return node
+	}
+	return t.stores[name]
+}
+
+// sameNode returns if the nodes have the same type and neighbours
+func sameNode(a, b *Node) (ok bool) {
+	return a.typ == b.typ && (a.typ == types.Store ||
+		sameNames(a.predecessors, b.predecessors) && sameNames(a.successors, b.successors))
+}
+
+// sameNames returns if the nodes have the same names regardless of order
+func sameNames(a, b []*Node) (ok bool) {
+	if len(a) != len(b) {
+		return false
+	}
+
+	names := make(map[string]int, len(a))
+	for _, node := range a {
+		names[node.name]++
+	}
+
+	for _, node := range b {
+		if names[node.name] == 0 {
+			return false
+		}
+		names[node.name]--
+	}
+	return true
+}
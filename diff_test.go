@@ -0,0 +1,82 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	sink := func(pc ProcessorContext, record Record) {}
+	migrator := MigratorFunc(func(from, to int, store Store) error { return nil })
+	store := func() Store { return &memStore{data: map[string][]byte{}} }
+
+	old := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, old.AddStore("counts", store))
+	assert.NoError(t, old.AddStore("totals", store))
+	assert.NoError(t, old.AddSource("source", testSourceSupplier(0)))
+	assert.NoError(t, old.AddProcessorFunc("count", sink, "source"))
+	assert.NoError(t, old.AddSinkFunc("sink", sink, "count"))
+	old.StoreVersion("counts", 1, migrator)
+
+	// renaming a processor and adding a store is safe
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddStore("counts", store))
+	assert.NoError(t, b.AddStore("totals", store))
+	assert.NoError(t, b.AddStore("averages", store))
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(0)))
+	assert.NoError(t, b.AddProcessorFunc("counter", sink, "source"))
+	assert.NoError(t, b.AddSinkFunc("sink", sink, "counter"))
+	b.StoreVersion("counts", 2, migrator)
+
+	diff := Diff(old, b)
+	assert.Equal(t, []string{"averages", "counter"}, diff.Added)
+	assert.Equal(t, []string{"count"}, diff.Removed)
+	assert.Equal(t, map[string]string{"count": "counter"}, diff.Renamed)
+	assert.Equal(t, []string{"sink"}, diff.Changed)
+	assert.Equal(t, []StoreDiff{
+		{Name: "counts", From: 1, To: 2, Compatible: true},
+		{Name: "totals", Compatible: true},
+	}, diff.Stores)
+	assert.NoError(t, diff.Err())
+
+	// removing, renaming or downgrading stores is unsafe
+	b = NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddStore("sums", store))
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(0)))
+	assert.NoError(t, b.AddProcessorFunc("count", sink, "source"))
+	assert.NoError(t, b.AddSinkFunc("sink", sink, "count"))
+
+	diff = Diff(old, b)
+	assert.Equal(t, []string{"sums"}, diff.Added)
+	assert.Equal(t, []string{"counts", "totals"}, diff.Removed)
+	assert.Len(t, diff.Renamed, 0)
+	assert.EqualError(t, diff.Err(), "store counts removed without a migration plan; "+
+		"store totals removed without a migration plan")
+
+	assert.NoError(t, b.AddStore("counts", store))
+	diff = Diff(old, b)
+	assert.Equal(t, map[string]string{"totals": "sums"}, diff.Renamed)
+	assert.EqualError(t, diff.Err(), "store counts downgraded from version 1 to 0; "+
+		"store totals renamed to sums without a migration plan")
+
+	b.DropStore("totals")
+	b.StoreVersion("counts", 1, nil)
+	assert.NoError(t, Diff(old, b).Err())
+}
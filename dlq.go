@@ -0,0 +1,279 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/brunotm/streams/types"
+)
+
+// Headers marking the records replayed from dead letters with their replay
+// count and the node and error of their last failure
+const (
+	HeaderReplayCount = "X-Replay-Count"
+	HeaderReplayNode  = "X-Replay-Node"
+	HeaderReplayError = "X-Replay-Error"
+)
+
+var (
+	errStreamNotStarted = Errorf(CodeState, "stream not started")
+)
+
+// DeadLetter is a record that failed processing, as written to a dead letter store
+type DeadLetter struct {
+	Node     string    `json:"node"`
+	Error    string    `json:"error"`
+	Category string    `json:"category"`
+	Failed   time.Time `json:"failed"`
+	Topic    string    `json:"topic"`
+	Key      []byte    `json:"key,omitempty"`
+	Value    []byte    `json:"value,omitempty"`
+	Time     time.Time `json:"time"`
+	Tenant   string    `json:"tenant,omitempty"`
+	Record   []byte    `json:"record,omitempty"` // Failed record in the wire format
+}
+
+// ReplayFilter selects the dead letters to replay. Empty fields match all dead letters.
+type ReplayFilter struct {
+	Node  string    // Node where the record failed
+	Error string    // Substring of the error message
+	Since time.Time // Failed at or after
+	Until time.Time // Failed before
+}
+
+// match returns if the dead letter matches the filter
+func (f ReplayFilter) match(dl DeadLetter) (ok bool) {
+	return (f.Node == "" || f.Node == dl.Node) &&
+		(f.Error == "" || strings.Contains(dl.Error, f.Error)) &&
+		(f.Since.IsZero() || !dl.Failed.Before(f.Since)) &&
+		(f.Until.IsZero() || dl.Failed.Before(f.Until))
+}
+
+// Encode the dead letter as json, for dead letters delivered as record values
+func (dl DeadLetter) Encode() (data []byte, err error) {
+	return json.Marshal(dl)
+}
+
+// record returns the failed record of the dead letter marked with the replay
+// headers, rebuilt from its fields for dead letters written without the record
+// wire format
+func (dl DeadLetter) record() (record Record, err error) {
+	if dl.Record != nil {
+		if record, err = UnmarshalRecord(dl.Record, nil); err != nil {
+			return record, err
+		}
+	} else {
+		var key, value Encoder
+		if dl.Key != nil {
+			key = ByteEncoder(dl.Key)
+		}
+		if dl.Value != nil {
+			value = ByteEncoder(dl.Value)
+		}
+		record = NewRecord(dl.Topic, key, value, dl.Time, nil)
+	}
+
+	count, _ := strconv.Atoi(record.Headers[HeaderReplayCount])
+	return record.WithHeader(HeaderReplayCount, strconv.Itoa(count+1)).
+		WithHeader(HeaderReplayNode, dl.Node).
+		WithHeader(HeaderReplayError, dl.Error), nil
+}
+
+// newDeadLetter creates the dead letter of the failed record
+func newDeadLetter(e Error, record Record, failed time.Time) (dl DeadLetter) {
+	dl = DeadLetter{Category: e.Category.String(), Failed: failed, Topic: record.Topic,
+		Time: record.Time, Tenant: record.Tenant}
+	if e.Node != nil {
+		dl.Node = e.Node.name
+	}
+	if e.Error != nil {
+		dl.Error = e.Error.Error()
+	}
+	dl.Key, _ = record.EncodeKey()
+	dl.Value, _ = record.EncodeValue()
+	dl.Record, _ = MarshalRecord(record)
+	return dl
+}
+
+// deadLetters writes the records of emitted errors to a dead letter store
+// and delivers them to a dead letter sink
+type deadLetters struct {
+	store string
+	sink  *Node
+	seq   uint64
+}
+
+// write the records of the error to the dead letter store and sink.
+// Errors of the dead letter store and sink themselves are not written,
+// and failures writing to the store are emitted to the stream ErrorHandler.
+func (d *deadLetters) write(s *Stream, e Error) {
+	if len(e.Record) == 0 {
+		return
+	}
+
+	failed := time.Now()
+	d.deliver(e, failed)
+
+	if d.store == "" || (e.Node != nil && e.Node.name == d.store) {
+		return
+	}
+
+	node, err := s.store(d.store)
+	if err != nil {
+		s.handleError(Error{Error: err, Category: CategoryOf(err)})
+		return
+	}
+	store := node.processor.(Store)
+
+	for _, record := range e.Record {
+		value, err := json.Marshal(newDeadLetter(e, record, failed))
+		if err == nil {
+			key := make([]byte, 16)
+			binary.BigEndian.PutUint64(key, uint64(failed.UnixNano()))
+			binary.BigEndian.PutUint64(key[8:], atomic.AddUint64(&d.seq, 1))
+			err = store.Set(key, value)
+		}
+
+		if err != nil {
+			s.handleError(Error{Node: node, Error: err, Category: CategoryOf(err)})
+		}
+	}
+}
+
+// deliver the records of the error to the dead letter sink with their
+// DeadLetter as value. Dead letters share the failed record acknowledgment,
+// so the record source is acknowledged once the dead letter is processed.
+func (d *deadLetters) deliver(e Error, failed time.Time) {
+	if d.sink == nil || d.sink.pc == nil || e.Node == d.sink {
+		return
+	}
+
+	for _, record := range e.Record {
+		dl := newDeadLetter(e, record, failed)
+		dead := record
+		dead.Value = dl
+		dead.enc = &encoding{key: record.Key, value: dl}
+
+		if dead.ack != nil {
+			dead.ack.retain(1)
+		}
+		d.sink.receive(dead)
+	}
+}
+
+// Replay re-injects the dead letters of the named store matching the filter
+// at the given processor or sink of the running stream, removing them from
+// the store. Replayed records are marked with the HeaderReplayCount,
+// HeaderReplayNode and HeaderReplayError headers. Records failing again
+// are written as new dead letters.
+func (s *Stream) Replay(store, at string, filter ReplayFilter) (replayed int, err error) {
+	node := s.topology.getNode(at)
+	if node == nil || node.typ == types.Source {
+		return 0, ErrNodeNotFound
+	}
+
+	if node.pc == nil {
+		return 0, errStreamNotStarted
+	}
+
+	sn, err := s.store(store)
+	if err != nil {
+		return 0, err
+	}
+	db := sn.processor.(Store)
+
+	var keys [][]byte
+	var letters []DeadLetter
+	err = db.Range(nil, nil, func(key, value []byte) error {
+		var dl DeadLetter
+		if err := json.Unmarshal(value, &dl); err != nil {
+			return err
+		}
+
+		if filter.match(dl) {
+			keys = append(keys, append([]byte(nil), key...))
+			letters = append(letters, dl)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for x, dl := range letters {
+		record, err := dl.record()
+		if err != nil {
+			return replayed, err
+		}
+
+		node.receive(record)
+		if err = db.Delete(keys[x]); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+// ReplayHandler returns a http.Handler replaying dead letters with Stream.Replay
+// on POST requests. The store, target node and filter are given in the store,
+// at, node, error, since and until query parameters, with since and until as
+// RFC3339 times. It replies with the number of replayed records as json, and
+// the error with a 500 status if the replay failed after replaying records.
+func ReplayHandler(s *Stream) (handler http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		filter := ReplayFilter{Node: query.Get("node"), Error: query.Get("error")}
+
+		var err error
+		for param, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if v := query.Get(param); v != "" {
+				if *t, err = time.Parse(time.RFC3339, v); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		replayed, err := s.Replay(query.Get("store"), query.Get("at"), filter)
+		if err != nil && replayed == 0 {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result := map[string]interface{}{"replayed": replayed}
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			result["error"] = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+}
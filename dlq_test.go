@@ -0,0 +1,268 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterReplay(t *testing.T) {
+	var mtx sync.Mutex
+	var processed []string
+	var headers map[string]string
+	var failing int32 = 1
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	store := &memStore{data: make(map[string][]byte)}
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddStore("dlq", func() Store { return store }))
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: keyedRecords("a", "b", "c")}
+	}))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		key, _ := record.EncodeKey()
+		if string(key) == "b" && atomic.LoadInt32(&failing) == 1 {
+			pc.Error(errors.New("failed"), record)
+		} else {
+			mtx.Lock()
+			processed = append(processed, string(key))
+			if string(key) == "b" {
+				headers = record.Headers
+			}
+			mtx.Unlock()
+		}
+		wg.Done()
+	}, "source"))
+
+	b.DeadLetters("missing")
+	_, err := b.Build()
+	assert.Equal(t, ErrStoreNotFound, err)
+
+	b.DeadLetters("dlq")
+	stream, err := b.Build()
+	assert.NoError(t, err)
+
+	_, err = stream.Replay("dlq", "sink", ReplayFilter{})
+	assert.Equal(t, errStreamNotStarted, err)
+
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+	assert.Equal(t, []string{"a", "c"}, processed)
+
+	assert.Len(t, store.data, 1)
+	for _, value := range store.data {
+		var dl DeadLetter
+		assert.NoError(t, json.Unmarshal(value, &dl))
+		assert.Equal(t, "sink", dl.Node)
+		assert.Equal(t, "failed", dl.Error)
+		assert.Equal(t, "b", string(dl.Key))
+		assert.Equal(t, "1", string(dl.Value))
+	}
+
+	// filtered out dead letters are kept
+	replayed, err := stream.Replay("dlq", "sink", ReplayFilter{Node: "other"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, replayed)
+
+	_, err = stream.Replay("dlq", "source", ReplayFilter{})
+	assert.Equal(t, ErrNodeNotFound, err)
+
+	atomic.StoreInt32(&failing, 0)
+	wg.Add(1)
+
+	server := httptest.NewServer(ReplayHandler(stream))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"?store=dlq&at=sink&node=sink&error=fail", "", nil)
+	assert.NoError(t, err)
+	var result map[string]int
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	resp.Body.Close()
+	assert.Equal(t, 1, result["replayed"])
+
+	wg.Wait()
+	assert.Equal(t, []string{"a", "c", "b"}, processed)
+	assert.Equal(t, map[string]string{HeaderReplayCount: "1", HeaderReplayNode: "sink", HeaderReplayError: "failed"}, headers)
+	assert.Len(t, store.data, 0)
+
+	resp, err = http.Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	assert.NoError(t, stream.Close())
+}
+
+func TestDeadLetterSink(t *testing.T) {
+	var mtx sync.Mutex
+	var letters []DeadLetter
+	var processed []string
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	var acked int32
+	ack := func() error {
+		atomic.AddInt32(&acked, 1)
+		return nil
+	}
+
+	var records []Record
+	for _, key := range []string{"a", "b", "c"} {
+		records = append(records, NewRecord("test", StringEncoder(key), StringEncoder("value"), time.Now(), ack))
+	}
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{records: records}
+	}))
+	assert.NoError(t, b.AddProcessorFunc("work", func(pc ProcessorContext, record Record) {
+		key, _ := record.EncodeKey()
+		switch string(key) {
+		case "b":
+			pc.Error(errors.New("failed"), record)
+		case "c":
+			panic("boom")
+		default:
+			pc.Forward(record)
+		}
+	}, "source"))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		key, _ := record.EncodeKey()
+		mtx.Lock()
+		processed = append(processed, string(key))
+		mtx.Unlock()
+		wg.Done()
+	}, "work"))
+	assert.NoError(t, b.AddDeadLetterSink("dlq", func() Processor {
+		return ProcessorFunc(func(pc ProcessorContext, record Record) {
+			mtx.Lock()
+			letters = append(letters, record.Value.(DeadLetter))
+			mtx.Unlock()
+			wg.Done()
+		})
+	}))
+	assert.Equal(t, errInvalidTopology, b.AddDeadLetterSink("other", nil))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+	assert.NoError(t, stream.Close())
+
+	// dead letters are acknowledged once processed by the dead letter sink
+	for x := 0; x < 100 && atomic.LoadInt32(&acked) < 3; x++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&acked))
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.Equal(t, []string{"a"}, processed)
+	assert.Len(t, letters, 2)
+	assert.Equal(t, "work", letters[0].Node)
+	assert.Equal(t, "failed", letters[0].Error)
+	assert.Equal(t, []byte("b"), letters[0].Key)
+	assert.Equal(t, "panic: boom", letters[1].Error)
+	assert.Equal(t, []byte("c"), letters[1].Key)
+	assert.False(t, letters[1].Failed.IsZero())
+}
+
+// failingStore fails sets if failing, and deletes after the first
+type failingStore struct {
+	memStore
+	failing int32
+	deletes int32
+}
+
+func (f *failingStore) Set(key, value []byte) error {
+	if atomic.LoadInt32(&f.failing) == 1 {
+		return errors.New("set failed")
+	}
+	return f.memStore.Set(key, value)
+}
+
+func (f *failingStore) Delete(key []byte) error {
+	if atomic.AddInt32(&f.deletes, 1) > 1 {
+		return errors.New("delete failed")
+	}
+	return f.memStore.Delete(key)
+}
+
+func TestDeadLetterStoreErrors(t *testing.T) {
+	store := &failingStore{memStore: memStore{data: make(map[string][]byte)}, failing: 1}
+	errs := make(chan Error, 10)
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddStore("dlq", func() Store { return store }))
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: keyedRecords("a", "b")}
+	}))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		pc.Error(errors.New("failed"), record)
+	}, "source"))
+	b.ErrorHandler(ErrorHandlerFunc(func(e Error) {
+		if e.Node != nil && e.Node.name == "dlq" {
+			errs <- e
+		}
+	}))
+	b.DeadLetters("dlq")
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	defer stream.Close()
+
+	// failed dead letter writes are emitted
+	for x := 0; x < 2; x++ {
+		select {
+		case e := <-errs:
+			assert.EqualError(t, e.Error, "set failed")
+		case <-time.After(5 * time.Second):
+			t.Fatal("dead letter write error not emitted")
+		}
+	}
+
+	// partially failed replays reply with the error
+	atomic.StoreInt32(&store.failing, 0)
+	for _, key := range []string{"1", "2"} {
+		value, _ := json.Marshal(DeadLetter{Node: "sink", Topic: "test", Key: []byte(key)})
+		assert.NoError(t, store.Set([]byte(key), value))
+	}
+
+	server := httptest.NewServer(ReplayHandler(stream))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"?store=dlq&at=sink", "", nil)
+	assert.NoError(t, err)
+	var result map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, float64(1), result["replayed"])
+	assert.Equal(t, "delete failed", result["error"])
+}
@@ -0,0 +1,66 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+)
+
+// DryRun initializes all stores, sources, processors and sinks, runs the
+// Check of those implementing the Checker interface and closes them in reverse
+// order without consuming or forwarding any records. All initialization,
+// check and close errors are returned as Errors.
+// A Stream must not be started after a DryRun, a new one must be built instead.
+func (s *Stream) DryRun() (err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var errs Errors
+	var initialized []*Node
+
+	nodes := append(s.topology.storeNodes(), s.topology.nodes...)
+
+	for _, node := range nodes {
+		if err = node.init(newContext(s)); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s init: %s", node.typ, node.name, err))
+			continue
+		}
+		initialized = append(initialized, node)
+	}
+
+	for _, node := range initialized {
+		if checker, ok := node.processor.(Checker); ok {
+			if err = checker.Check(); err != nil {
+				errs = append(errs, fmt.Errorf("%s %s check: %s", node.typ, node.name, err))
+			}
+		}
+	}
+
+	for x := len(initialized) - 1; x >= 0; x-- {
+		node := initialized[x]
+		if closer, ok := node.processor.(Closer); ok {
+			if err = closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("%s %s close: %s", node.typ, node.name, err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
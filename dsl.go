@@ -0,0 +1,168 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"strconv"
+)
+
+// Pipeline is a fluent api over a Builder for transformation pipelines,
+// compiling each operation into a named processor function node after the
+// previous node of the pipeline. The first error is kept and returned by To,
+// ToFunc or Err, and all further operations are ignored.
+//
+//	err := b.Source("orders", supplier).
+//		Filter("paid", isPaid).
+//		Map("total", toTotal).
+//		To("sink", sinkSupplier)
+type Pipeline struct {
+	b    *Builder
+	node string
+	err  error
+}
+
+// Mapper maps a record to a new record. Records created with NewRecord share
+// the acknowledgment of the mapped record.
+type Mapper func(record Record) (result Record, err error)
+
+// FlatMapper maps a record to zero or more records. Records created with
+// NewRecord share the acknowledgment of the mapped record.
+type FlatMapper func(record Record) (results []Record, err error)
+
+// Predicate returns if the record matches
+type Predicate func(record Record) (ok bool)
+
+// Source adds a source to the topology and starts a pipeline from it
+func (b *Builder) Source(name string, supplier SourceSupplier) (p *Pipeline) {
+	return &Pipeline{b: b, node: name, err: b.AddSource(name, supplier)}
+}
+
+// From starts a pipeline from the named node of the topology
+func (b *Builder) From(name string) (p *Pipeline) {
+	p = &Pipeline{b: b, node: name}
+	if b.topology.getNode(name) == nil {
+		p.err = ErrNodeNotFound
+	}
+	return p
+}
+
+// Filter forwards the records matching the predicate
+func (p *Pipeline) Filter(name string, predicate Predicate) (next *Pipeline) {
+	return p.then(name, func(pc ProcessorContext, record Record) {
+		if predicate(record) {
+			pc.Forward(record)
+		}
+	})
+}
+
+// Map forwards the records mapped by the mapper
+func (p *Pipeline) Map(name string, mapper Mapper) (next *Pipeline) {
+	return p.then(name, func(pc ProcessorContext, record Record) {
+		result, err := mapper(record)
+		if err != nil {
+			pc.Error(err, record)
+			return
+		}
+		pc.Forward(inherit(result, record))
+	})
+}
+
+// FlatMap forwards the records mapped by the flat mapper
+func (p *Pipeline) FlatMap(name string, mapper FlatMapper) (next *Pipeline) {
+	return p.then(name, func(pc ProcessorContext, record Record) {
+		results, err := mapper(record)
+		if err != nil {
+			pc.Error(err, record)
+			return
+		}
+
+		for _, result := range results {
+			pc.Forward(inherit(result, record))
+		}
+	})
+}
+
+// Branch splits the pipeline in one branch per predicate, each receiving the
+// records for which its predicate is the first to match. Records matching no
+// predicate are dropped. Branches are named <name>-<index>.
+func (p *Pipeline) Branch(name string, predicates ...Predicate) (branches []*Pipeline) {
+	branches = make([]*Pipeline, len(predicates))
+	for x := range predicates {
+		idx := x
+		branches[x] = p.then(name+"-"+strconv.Itoa(x), func(pc ProcessorContext, record Record) {
+			for y := 0; y < len(predicates); y++ {
+				if predicates[y](record) {
+					if y == idx {
+						pc.Forward(record)
+					}
+					return
+				}
+			}
+		})
+	}
+	return branches
+}
+
+// Process adds a processor to the pipeline
+func (p *Pipeline) Process(name string, supplier ProcessorSupplier) (next *Pipeline) {
+	if p.err != nil {
+		return p
+	}
+	return &Pipeline{b: p.b, node: name, err: p.b.AddProcessor(name, supplier, p.node)}
+}
+
+// To ends the pipeline in a sink
+func (p *Pipeline) To(name string, supplier ProcessorSupplier) (err error) {
+	if p.err != nil {
+		return p.err
+	}
+	return p.b.AddSink(name, supplier, p.node)
+}
+
+// ToFunc ends the pipeline in a sink function
+func (p *Pipeline) ToFunc(name string, pf ProcessorFunc) (err error) {
+	if p.err != nil {
+		return p.err
+	}
+	return p.b.AddSinkFunc(name, pf, p.node)
+}
+
+// Name returns the name of the last node of the pipeline
+func (p *Pipeline) Name() (name string) {
+	return p.node
+}
+
+// Err returns the first error of the pipeline operations
+func (p *Pipeline) Err() (err error) {
+	return p.err
+}
+
+// then adds the processor function after the last node of the pipeline
+func (p *Pipeline) then(name string, pf ProcessorFunc) (next *Pipeline) {
+	if p.err != nil {
+		return p
+	}
+	return &Pipeline{b: p.b, node: name, err: p.b.AddProcessorFunc(name, pf, p.node)}
+}
+
+// inherit the acknowledgment of the record if the result has none
+func inherit(result, record Record) (inherited Record) {
+	if result.ack == nil {
+		result.ack = record.ack
+	}
+	return result
+}
@@ -0,0 +1,101 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeline(t *testing.T) {
+	var mtx sync.Mutex
+	var even, odd []string
+	done := make(chan struct{}, 20)
+
+	collect := func(values *[]string) ProcessorFunc {
+		return func(pc ProcessorContext, record Record) {
+			v, _ := record.EncodeValue()
+			mtx.Lock()
+			*values = append(*values, string(v))
+			mtx.Unlock()
+			done <- struct{}{}
+		}
+	}
+
+	b := NewBuilder("stream", NewConfig(nil))
+	branches := b.Source("source", testSourceSupplier(10)).
+		Filter("filter", func(record Record) bool {
+			v, _ := record.EncodeValue()
+			return string(v) != "0"
+		}).
+		Map("map", func(record Record) (Record, error) {
+			v, _ := record.EncodeValue()
+			n, _ := strconv.Atoi(string(v))
+			return NewRecord(record.Topic, nil, StringEncoder(strconv.Itoa(n*10)), record.Time, nil), nil
+		}).
+		FlatMap("split", func(record Record) ([]Record, error) {
+			return []Record{record, record}, nil
+		}).
+		Branch("branch",
+			func(record Record) bool {
+				v, _ := record.EncodeValue()
+				n, _ := strconv.Atoi(string(v))
+				return n%20 == 0
+			},
+			func(record Record) bool { return true })
+
+	assert.Len(t, branches, 2)
+	assert.Equal(t, "branch-0", branches[0].Name())
+	assert.NoError(t, branches[0].ToFunc("even", collect(&even)))
+	assert.NoError(t, branches[1].ToFunc("odd", collect(&odd)))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	for x := 0; x < 18; x++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for records")
+		}
+	}
+	assert.NoError(t, stream.Close())
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.Equal(t, []string{"20", "20", "40", "40", "60", "60", "80", "80"}, even)
+	assert.Equal(t, []string{"10", "10", "30", "30", "50", "50", "70", "70", "90", "90"}, odd)
+}
+
+func TestPipelineErrors(t *testing.T) {
+	b := NewBuilder("stream", NewConfig(nil))
+	p := b.From("missing").Map("map", func(record Record) (Record, error) {
+		return record, nil
+	})
+	assert.Equal(t, ErrNodeNotFound, p.Err())
+	assert.Equal(t, ErrNodeNotFound, p.ToFunc("sink", passthrough))
+	assert.Nil(t, b.topology.getNode("map"))
+
+	p = b.Source("source", testSourceSupplier(1))
+	assert.NoError(t, p.Err())
+	assert.Error(t, b.Source("source", testSourceSupplier(1)).Err())
+}
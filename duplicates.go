@@ -0,0 +1,70 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/dgryski/go-wyhash"
+)
+
+const (
+	// DefaultDuplicatesSize is the default number of record ids tracked
+	// per source for the detection of duplicate deliveries
+	DefaultDuplicatesSize = 100000
+)
+
+// duplicates detects records delivered again by a source within a ttl,
+// tracking the ids of the recently forwarded records in a Cache.
+type duplicates struct {
+	seen *Cache
+}
+
+func newDuplicates(size int, ttl time.Duration) (d *duplicates) {
+	if size <= 0 {
+		size = DefaultDuplicatesSize
+	}
+	return &duplicates{seen: NewCache(size, ttl)}
+}
+
+// observe returns if the record was seen within the ttl, tracking it otherwise
+func (d *duplicates) observe(record Record) (duplicate bool) {
+	id := string(recordID(record))
+	if _, duplicate = d.seen.Get(id); duplicate {
+		return true
+	}
+	d.seen.Set(id, nil)
+	return false
+}
+
+// recordID returns the id of the record content hashed over its topic, key and value
+func recordID(record Record) (id []byte) {
+	key, _ := record.EncodeKey()
+	value, _ := record.EncodeValue()
+
+	data := make([]byte, 0, len(record.Topic)+len(key)+len(value)+2)
+	data = append(data, record.Topic...)
+	data = append(data, 0)
+	data = append(data, key...)
+	data = append(data, 0)
+	data = append(data, value...)
+
+	id = make([]byte, 8)
+	binary.BigEndian.PutUint64(id, wyhash.Hash(data, 0))
+	return id
+}
@@ -0,0 +1,75 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuplicateMetrics(t *testing.T) {
+	// records forwarded, broadcast or forwarded to a node are tracked alike
+	for _, source := range []testSource{{}, {broadcast: true}, {to: "sink"}} {
+		source := source
+		var wg sync.WaitGroup
+		wg.Add(4)
+
+		config := NewConfig(nil)
+		config.Set("1m", "stream.source.duplicates.ttl")
+
+		b := NewBuilder("stream", config)
+		assert.NoError(t, b.AddSource("source", func() Source {
+			source.next = redeliveredRecords("a", "b", "a", "a")
+			return &source
+		}))
+		assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+			wg.Done()
+		}, "source"))
+
+		stream, err := b.Build()
+		assert.NoError(t, err)
+		assert.NoError(t, stream.Start())
+		wg.Wait()
+
+		values := make(map[string]float64)
+		for _, metric := range stream.Metrics() {
+			values[metric.Labels["node"]+"/"+metric.Name] = metric.Value
+		}
+		assert.Equal(t, float64(2), values["source/streams_node_duplicates_total"])
+		assert.Equal(t, 0.5, values["source/streams_node_duplicates_ratio"])
+
+		_, tracked := values["sink/streams_node_duplicates_total"]
+		assert.False(t, tracked)
+		assert.NoError(t, stream.Close())
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	d := newDuplicates(0, 20*time.Millisecond)
+	record := NewRecord("test", StringEncoder("a"), StringEncoder("1"), time.Now(), nil)
+
+	assert.False(t, d.observe(record))
+	assert.True(t, d.observe(record))
+	assert.False(t, d.observe(NewRecord("other", StringEncoder("a"), StringEncoder("1"), time.Now(), nil)))
+	assert.False(t, d.observe(NewRecord("test", StringEncoder("a"), StringEncoder("2"), time.Now(), nil)))
+
+	time.Sleep(40 * time.Millisecond)
+	assert.False(t, d.observe(record))
+}
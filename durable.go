@@ -0,0 +1,477 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// walCompressed marks log records compressed with the following compression,
+// distinct from the record wire format version of uncompressed records
+const walCompressed byte = 1 << 7
+
+var (
+	errDurablePath = Errorf(CodeConfig, "durable edges require the <stream>.durable.path config")
+	errDurableEdge = Errorf(CodeTopology, "durable edge target is not a successor of its source")
+)
+
+// durableEdge is a edge whose records are persisted before delivery
+type durableEdge struct {
+	from string
+	to   string
+}
+
+// Durable marks the edge between the from node and its to successor as durable.
+// Records crossing durable edges are appended to a write ahead log within the
+// <stream>.durable.path directory before their delivery and removed once
+// processed by the successor and its downstream nodes, so records in flight
+// are not lost on crashes. Records left in the log are delivered again to the
+// successor when the stream starts, giving at least once delivery across the
+// edge. Log writes are synced to disk with the <stream>.durable.sync config,
+// and the log segment size can be set with <stream>.durable.segment.
+// Records are compressed in the log with the <stream>.durable.compression
+// config, none (default), snappy, gzip or any compression registered with
+// RegisterCompressor, such as zstd from the github.com/brunotm/streams/zstd module.
+func (b *Builder) Durable(from, to string) {
+	b.durable = append(b.durable, durableEdge{from: from, to: to})
+}
+
+// isDurable returns if the node is part of a durable edge
+func (b *Builder) isDurable(name string) (ok bool) {
+	for _, edge := range b.durable {
+		if edge.from == name || edge.to == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDurable validates the durable edges of the topology
+func (t *topology) validateDurable(edges []durableEdge) (err error) {
+	for _, edge := range edges {
+		from := t.getNode(edge.from)
+		if from == nil {
+			return ErrNodeNotFound
+		}
+
+		var found bool
+		for _, successor := range from.successors {
+			found = found || successor.name == edge.to
+		}
+		if !found {
+			return errDurableEdge
+		}
+	}
+	return nil
+}
+
+// openDurable opens the write ahead logs of the durable edges and delivers
+// the records left in the logs to the edge successors
+func (s *Stream) openDurable() (err error) {
+	if len(s.durable) == 0 {
+		return nil
+	}
+
+	path := s.config.Get(s.name, "durable", "path").String("")
+	if path == "" {
+		return errDurablePath
+	}
+	sync := s.config.Get(s.name, "durable", "sync").Bool(false)
+	segmentSize := s.config.Get(s.name, "durable", "segment").Int64(DefaultSegmentSize)
+	compression, err := CompressionOf(s.config.Get(s.name, "durable", "compression").String(""))
+	if err != nil {
+		return err
+	}
+
+	for _, edge := range s.durable {
+		from := s.topology.getNode(edge.from)
+		to := s.topology.getNode(edge.to)
+
+		w, pending, err := openWAL(filepath.Join(path, edge.from, edge.to), segmentSize, sync, compression)
+		if err != nil {
+			return err
+		}
+
+		if from.durable == nil {
+			from.durable = make(map[*Node]*wal)
+		}
+		from.durable[to] = w
+
+		for _, entry := range pending {
+			entry.record.ack = w.acker(entry.seg, entry.seq, nil)
+			to.receive(entry.record)
+		}
+	}
+
+	return nil
+}
+
+// closeDurable closes the write ahead logs of the durable edges
+func (s *Stream) closeDurable() (err error) {
+	for _, edge := range s.durable {
+		from := s.topology.getNode(edge.from)
+		if w := from.durable[s.topology.getNode(edge.to)]; w != nil {
+			if e := w.close(); e != nil && err == nil {
+				err = e
+			}
+		}
+		from.durable = nil
+	}
+	return err
+}
+
+// edge returns the record to be delivered to the successor with the given
+// index, persisting it first if the edge to the successor is durable.
+// Records that cannot be persisted are delivered anyway with an error emitted.
+func (n *Node) edge(idx int, record Record) (result Record) {
+	w := n.durable[n.successors[idx]]
+	if w == nil {
+		return record
+	}
+
+	seg, seq, err := w.append(record)
+	if err != nil {
+		n.pc.Error(err, record)
+		return record
+	}
+
+	record.ack = w.acker(seg, seq, record.ack)
+	return record
+}
+
+// wal is the write ahead log of a durable edge. Records are appended to
+// segment files with a sequence number, and their completion to the segment
+// ack file. Segments are removed once all of its records are completed.
+// Compressed records are prefixed with walCompressed and their compression.
+type wal struct {
+	mtx         sync.Mutex
+	dir         string
+	segmentSize int64
+	sync        bool
+	compression Compression
+	sequence    uint64
+	seq         uint64
+	segments    []*walSegment
+}
+
+// walSegment is a log segment and its ack file
+type walSegment struct {
+	path    string
+	file    *os.File
+	acks    *os.File
+	size    int64
+	pending int
+}
+
+// walEntry is a record pending in the log
+type walEntry struct {
+	seg    *walSegment
+	seq    uint64
+	record Record
+}
+
+// openWAL opens the log in dir returning its pending records in order.
+// New records are appended with the given compression.
+func openWAL(dir string, segmentSize int64, sync bool, compression Compression) (w *wal, pending []walEntry, err error) {
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return nil, nil, err
+	}
+
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+
+	w = &wal{dir: dir, segmentSize: segmentSize, sync: sync, compression: compression}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var names []string
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".wal") {
+			names = append(names, strings.TrimSuffix(file.Name(), ".wal"))
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var sequence uint64
+		if _, err = fmt.Sscanf(name, "%d", &sequence); err != nil {
+			return nil, nil, err
+		}
+		if sequence >= w.sequence {
+			w.sequence = sequence + 1
+		}
+
+		seg, entries, last, err := w.recover(filepath.Join(dir, name))
+		if err != nil {
+			return nil, nil, err
+		}
+		if last >= w.seq {
+			w.seq = last + 1
+		}
+
+		if seg.pending == 0 {
+			if err = seg.remove(); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		w.segments = append(w.segments, seg)
+		pending = append(pending, entries...)
+	}
+
+	return w, pending, nil
+}
+
+// recover reads the pending records of the segment at path.
+// A partially written record at the end of the segment is discarded.
+func (w *wal) recover(path string) (seg *walSegment, pending []walEntry, last uint64, err error) {
+	data, err := ioutil.ReadFile(path + ".wal")
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	acked, err := ioutil.ReadFile(path + ".ack")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, 0, err
+	}
+
+	done := make(map[uint64]bool)
+	for ; len(acked) >= 8; acked = acked[8:] {
+		done[binary.BigEndian.Uint64(acked)] = true
+	}
+
+	seg = &walSegment{path: path}
+	for len(data) >= 12 {
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || len(data)-4 < size {
+			break
+		}
+
+		seq := binary.BigEndian.Uint64(data[4:])
+		if seq > last {
+			last = seq
+		}
+
+		if !done[seq] {
+			record, err := unmarshalWAL(data[12 : 4+size])
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			pending = append(pending, walEntry{seg: seg, seq: seq, record: record})
+			seg.pending++
+		}
+		data = data[4+size:]
+	}
+
+	if seg.file, err = os.OpenFile(path+".wal", os.O_RDONLY, 0640); err != nil {
+		return nil, nil, 0, err
+	}
+	if seg.acks, err = os.OpenFile(path+".ack", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640); err != nil {
+		seg.file.Close()
+		return nil, nil, 0, err
+	}
+
+	// Recovered segments are full, new records go to new segments
+	seg.size = w.segmentSize
+	return seg, pending, last, nil
+}
+
+// append the record to the log
+func (w *wal) append(record Record) (seg *walSegment, seq uint64, err error) {
+	buf, err := MarshalRecord(record)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if w.compression != CompressionNone {
+		if buf, err = w.compression.Compress([]byte{walCompressed, byte(w.compression)}, buf); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if len(w.segments) > 0 {
+		seg = w.segments[len(w.segments)-1]
+	}
+
+	if seg == nil || seg.size >= w.segmentSize {
+		if seg, err = w.newSegment(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	seq = w.seq
+	entry := make([]byte, 12, 12+len(buf))
+	binary.BigEndian.PutUint32(entry, uint32(8+len(buf)))
+	binary.BigEndian.PutUint64(entry[4:], seq)
+	entry = append(entry, buf...)
+
+	if _, err = seg.file.Write(entry); err != nil {
+		return nil, 0, err
+	}
+
+	if w.sync {
+		if err = seg.file.Sync(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	w.seq++
+	seg.size += int64(len(entry))
+	seg.pending++
+	return seg, seq, nil
+}
+
+// unmarshalWAL decodes a log record, decompressing it if compressed
+func unmarshalWAL(data []byte) (record Record, err error) {
+	if len(data) > 1 && data[0] == walCompressed {
+		if data, err = Compression(data[1]).Decompress(nil, data[2:]); err != nil {
+			return record, err
+		}
+	}
+	return UnmarshalRecord(data, nil)
+}
+
+// acker returns a record acker completing the log record and releasing the
+// parent acker, or rejecting it if the record is nacked downstream
+func (w *wal) acker(seg *walSegment, seq uint64, parent *acker) (a *acker) {
+	complete := func(done func() error) (err error) {
+		err = w.complete(seg, seq)
+		if parent != nil {
+			if e := done(); e != nil && err == nil {
+				err = e
+			}
+		}
+		return err
+	}
+
+	return &acker{refs: 1,
+		ack:  func() (err error) { return complete(parent.release) },
+		nack: func(nerr error) (err error) { return complete(func() error { return parent.reject(nerr) }) },
+	}
+}
+
+// complete the log record, removing its segment if all of its records are
+// completed and it is not the segment being written
+func (w *wal) complete(seg *walSegment, seq uint64) (err error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if seg.file == nil {
+		return nil
+	}
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], seq)
+	if _, err = seg.acks.Write(buf[:]); err != nil {
+		return err
+	}
+
+	seg.pending--
+	if seg.pending > 0 || (seg == w.segments[len(w.segments)-1] && seg.size < w.segmentSize) {
+		return nil
+	}
+
+	for x := range w.segments {
+		if w.segments[x] == seg {
+			w.segments = append(w.segments[:x], w.segments[x+1:]...)
+			break
+		}
+	}
+	return seg.remove()
+}
+
+// close the log, removing the segments without pending records
+func (w *wal) close() (err error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	for _, seg := range w.segments {
+		var e error
+		if seg.pending == 0 {
+			e = seg.remove()
+		} else {
+			e = seg.close()
+		}
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+
+	w.segments = nil
+	return err
+}
+
+func (w *wal) newSegment() (seg *walSegment, err error) {
+	seg = &walSegment{}
+	seg.path = filepath.Join(w.dir, fmt.Sprintf("%020d", w.sequence))
+
+	if seg.file, err = os.OpenFile(seg.path+".wal", os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0640); err != nil {
+		return nil, err
+	}
+	if seg.acks, err = os.OpenFile(seg.path+".ack", os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0640); err != nil {
+		seg.file.Close()
+		return nil, err
+	}
+
+	w.sequence++
+	w.segments = append(w.segments, seg)
+	return seg, nil
+}
+
+// close the segment files
+func (s *walSegment) close() (err error) {
+	if s.file == nil {
+		return nil
+	}
+
+	err = s.file.Close()
+	if e := s.acks.Close(); e != nil && err == nil {
+		err = e
+	}
+	s.file = nil
+	return err
+}
+
+// remove the segment files
+func (s *walSegment) remove() (err error) {
+	if err = s.close(); err != nil {
+		return err
+	}
+
+	if err = os.Remove(s.path + ".wal"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = os.Remove(s.path + ".ack"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDurableEdge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "durable")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := NewConfig(nil)
+	config.Set(dir, "stream.durable.path")
+
+	build := func(sink ProcessorFunc) (stream *Stream, err error) {
+		b := NewBuilder("stream", config)
+		assert.NoError(t, b.AddSource("source", func() Source {
+			return &testSource{next: keyedRecords("a", "b", "c")}
+		}))
+		assert.NoError(t, b.AddProcessorFunc("process", func(pc ProcessorContext, record Record) {
+			pc.Forward(record)
+		}, "source"))
+		assert.NoError(t, b.AddSinkFunc("sink", sink, "process"))
+		b.Durable("process", "sink")
+		return b.Build()
+	}
+
+	// the record b is never completed, as if the process crashed
+	var mtx sync.Mutex
+	var processed []string
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	stream, err := build(func(pc ProcessorContext, record Record) {
+		key, _ := record.EncodeKey()
+		if string(key) == "b" {
+			record.Retain()
+		}
+		wg.Done()
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+	assert.NoError(t, stream.Close())
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "process", "sink", "*.wal"))
+	assert.Len(t, segments, 1)
+
+	// the pending record is delivered again on start
+	wg.Add(4)
+	stream, err = build(func(pc ProcessorContext, record Record) {
+		key, _ := record.EncodeKey()
+		mtx.Lock()
+		processed = append(processed, string(key))
+		mtx.Unlock()
+		wg.Done()
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+	assert.NoError(t, stream.Close())
+
+	assert.Equal(t, []string{"b", "a", "b", "c"}, processed)
+	segments, _ = filepath.Glob(filepath.Join(dir, "process", "sink", "*"))
+	assert.Len(t, segments, 0)
+}
+
+func TestDurableEdgeValidation(t *testing.T) {
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() Source { return &testSource{} }))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {}, "source"))
+
+	b.Durable("sink", "source")
+	_, err := b.Build()
+	assert.Equal(t, errDurableEdge, err)
+
+	b.durable = nil
+	b.Durable("source", "sink")
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.Equal(t, errDurablePath, stream.Start())
+}
+
+func TestDurableWALCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "durable")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	w, pending, err := openWAL(dir, DefaultSegmentSize, false, CompressionSnappy)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 0)
+
+	for _, key := range []string{"a", "b"} {
+		_, _, err = w.append(NewRecord("topic", StringEncoder(key), StringEncoder("value"), time.Now(), nil))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.close())
+
+	// compressed records are recovered by logs with other compressions
+	w, pending, err = openWAL(dir, DefaultSegmentSize, false, CompressionNone)
+	assert.NoError(t, err)
+	_, _, err = w.append(NewRecord("topic", StringEncoder("c"), StringEncoder("value"), time.Now(), nil))
+	assert.NoError(t, err)
+	assert.NoError(t, w.close())
+
+	w, pending, err = openWAL(dir, DefaultSegmentSize, false, CompressionSnappy)
+	assert.NoError(t, err)
+	defer w.close()
+
+	var keys []string
+	for _, entry := range pending {
+		key, err := entry.record.EncodeKey()
+		assert.NoError(t, err)
+		value, err := entry.record.EncodeValue()
+		assert.NoError(t, err)
+		assert.Equal(t, "value", string(value))
+		keys = append(keys, string(key))
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+}
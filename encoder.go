@@ -16,6 +16,10 @@ package streams
    limitations under the License.
 */
 
+import (
+	"encoding/json"
+)
+
 // Encoder is a simple interface for any type that can be encoded as an array of bytes
 // in order to be sent as the key or value of a Record.
 type Encoder interface {
@@ -39,3 +43,31 @@ type StringEncoder string
 func (s StringEncoder) Encode() ([]byte, error) {
 	return []byte(s), nil
 }
+
+// JSONEncoder implements the Encoder interface for arbitrary Go values
+// so that they can be used as the Key or Value in a Record encoded as json.
+type JSONEncoder struct {
+	value interface{}
+}
+
+// NewJSONEncoder creates a JSONEncoder for the given value
+func NewJSONEncoder(value interface{}) (e *JSONEncoder) {
+	return &JSONEncoder{value: value}
+}
+
+// Encode serializes the encoder value as json
+func (e *JSONEncoder) Encode() ([]byte, error) {
+	return json.Marshal(e.value)
+}
+
+// DecodeJSON decodes the json data into a Config, allowing the fields of json
+// records to be read by their dot separated paths with typed defaults:
+//
+//	order, err := streams.DecodeJSON(value)
+//	amount := order.Get("items.0.amount").Float64(0)
+func DecodeJSON(data []byte) (value Config, err error) {
+	if err = json.Unmarshal(data, &value.data); err != nil {
+		return Config{}, err
+	}
+	return value, nil
+}
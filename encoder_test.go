@@ -0,0 +1,68 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONEncoder(t *testing.T) {
+	type item struct {
+		SKU    string  `json:"sku"`
+		Amount float64 `json:"amount"`
+	}
+
+	order := map[string]interface{}{
+		"id":    42,
+		"paid":  true,
+		"items": []item{{"a", 1.5}, {"b", 2}},
+	}
+
+	record := NewRecord("orders", nil, NewJSONEncoder(order), time.Now(), nil)
+	data, err := record.EncodeValue()
+	assert.NoError(t, err)
+
+	value, err := DecodeJSON(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, value.Get("id").Int(0))
+	assert.Equal(t, true, value.Get("paid").Bool(false))
+	assert.Equal(t, "b", value.Get("items.1.sku").String(""))
+	assert.Equal(t, 1.5, value.Get("items.0.amount").Float64(0))
+	assert.Len(t, value.Get("items").Array(), 2)
+
+	// missing fields, indexes and fields of scalars return the defaults
+	assert.Equal(t, "none", value.Get("customer.name").String("none"))
+	assert.Equal(t, "none", value.Get("items.2.sku").String("none"))
+	assert.Equal(t, "none", value.Get("id.name").String("none"))
+
+	// decoded values can be encoded back
+	value.Set(false, "paid")
+	data, err = NewJSONEncoder(value).Encode()
+	assert.NoError(t, err)
+	value, err = DecodeJSON(data)
+	assert.NoError(t, err)
+	assert.Equal(t, false, value.Get("paid").Bool(true))
+
+	_, err = DecodeJSON([]byte("{"))
+	assert.Error(t, err)
+
+	_, err = NewJSONEncoder(make(chan int)).Encode()
+	assert.Error(t, err)
+}
@@ -0,0 +1,186 @@
+package envelope
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+
+	"github.com/brunotm/streams"
+)
+
+const (
+	version    = 1
+	dataKeyLen = 32
+)
+
+var (
+	// ErrMasterKeyNotFound is returned by key providers for unknown master keys
+	ErrMasterKeyNotFound = streams.Errorf(streams.CodeConfig, "master key not found")
+
+	errInvalidEnvelope = streams.Errorf(streams.CodeSerialization, "invalid envelope")
+	errInvalidKeySize  = streams.Errorf(streams.CodeConfig, "master keys must have 16, 24 or 32 bytes")
+)
+
+// KeyProvider generates and decrypts the data keys of envelopes. Providers
+// are usually backed by a key management service holding the master keys,
+// which never leave the service. Providers must be safe for concurrent use.
+type KeyProvider interface {
+	// GenerateKey returns a new 32 byte data key in plaintext and encrypted
+	// with the given master key
+	GenerateKey(masterKey string) (plaintext, encrypted []byte, err error)
+	// DecryptKey decrypts a data key encrypted with the given master key
+	DecryptKey(masterKey string, encrypted []byte) (plaintext []byte, err error)
+}
+
+// LocalProvider is a KeyProvider with master keys held in memory,
+// for development, testing or keys loaded from a secret store.
+type LocalProvider struct {
+	keys map[string]cipher.AEAD
+}
+
+// NewLocalProvider creates a LocalProvider with the given AES master keys by id
+func NewLocalProvider(keys map[string][]byte) (p *LocalProvider, err error) {
+	p = &LocalProvider{keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if p.keys[id], err = newAEAD(key); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// GenerateKey returns a new data key encrypted with the master key
+func (p *LocalProvider) GenerateKey(masterKey string) (plaintext, encrypted []byte, err error) {
+	aead, ok := p.keys[masterKey]
+	if !ok {
+		return nil, nil, ErrMasterKeyNotFound
+	}
+
+	plaintext = make([]byte, dataKeyLen)
+	if _, err = io.ReadFull(rand.Reader, plaintext); err != nil {
+		return nil, nil, err
+	}
+
+	if encrypted, err = seal(aead, plaintext); err != nil {
+		return nil, nil, err
+	}
+	return plaintext, encrypted, nil
+}
+
+// DecryptKey decrypts the data key encrypted with the master key
+func (p *LocalProvider) DecryptKey(masterKey string, encrypted []byte) (plaintext []byte, err error) {
+	aead, ok := p.keys[masterKey]
+	if !ok {
+		return nil, ErrMasterKeyNotFound
+	}
+	return open(aead, encrypted)
+}
+
+// envelope is data encrypted with a data key, carrying the data key
+// encrypted with the master key. Envelopes are encoded as:
+//
+//	version(1) | master key id length(2) | master key id |
+//	encrypted data key length(2) | encrypted data key | nonce | ciphertext
+type envelope struct {
+	masterKey  string
+	dataKey    []byte
+	ciphertext []byte
+}
+
+// encode the envelope
+func (e envelope) encode() (data []byte) {
+	data = make([]byte, 0, 5+len(e.masterKey)+len(e.dataKey)+len(e.ciphertext))
+	data = append(data, version)
+	data = appendField(data, []byte(e.masterKey))
+	data = appendField(data, e.dataKey)
+	return append(data, e.ciphertext...)
+}
+
+// decode the envelope
+func decode(data []byte) (e envelope, err error) {
+	if len(data) == 0 || data[0] != version {
+		return e, errInvalidEnvelope
+	}
+
+	masterKey, rest, err := readField(data[1:])
+	if err != nil {
+		return e, err
+	}
+
+	if e.dataKey, e.ciphertext, err = readField(rest); err != nil {
+		return e, err
+	}
+
+	e.masterKey = string(masterKey)
+	return e, nil
+}
+
+// appendField appends the length prefixed field
+func appendField(data, field []byte) (result []byte) {
+	var size [2]byte
+	binary.BigEndian.PutUint16(size[:], uint16(len(field)))
+	return append(append(data, size[:]...), field...)
+}
+
+// readField reads a length prefixed field
+func readField(data []byte) (field, rest []byte, err error) {
+	if len(data) < 2 {
+		return nil, nil, errInvalidEnvelope
+	}
+
+	size := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+size {
+		return nil, nil, errInvalidEnvelope
+	}
+	return data[2 : 2+size], data[2+size:], nil
+}
+
+// newAEAD creates an AES-GCM cipher with the key
+func newAEAD(key []byte) (aead cipher.AEAD, err error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, errInvalidKeySize
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal the plaintext with a random nonce prepended to the ciphertext
+func seal(aead cipher.AEAD, plaintext []byte) (ciphertext []byte, err error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open the ciphertext sealed with seal
+func open(aead cipher.AEAD, ciphertext []byte) (plaintext []byte, err error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errInvalidEnvelope
+	}
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+}
@@ -0,0 +1,132 @@
+package envelope
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/jsonpath"
+	"github.com/brunotm/streams/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newContext(fields ...interface{}) (pc *mock.Context) {
+	config := streams.NewConfig(nil)
+	config.Set("orders", "stream.envelope.key")
+	if fields != nil {
+		config.Set(fields, "stream", "envelope", "fields")
+	}
+
+	return &mock.Context{Data: mock.ContextData{
+		Active:     true,
+		StreamName: "stream",
+		NodeName:   "envelope",
+		Config:     config,
+	}}
+}
+
+func fieldEnvelope(t *testing.T, value []byte, field string) (e envelope) {
+	encoded, ok, err := jsonpath.MustCompile(field).Lookup(value)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	data, err := base64.StdEncoding.DecodeString(encoded.(string))
+	assert.NoError(t, err)
+	e, err = decode(data)
+	assert.NoError(t, err)
+	return e
+}
+
+func TestEnvelope(t *testing.T) {
+	provider, err := NewLocalProvider(map[string][]byte{"orders": bytes.Repeat([]byte{1}, 32)})
+	assert.NoError(t, err)
+
+	_, err = NewLocalProvider(map[string][]byte{"short": []byte("key")})
+	assert.Equal(t, errInvalidKeySize, err)
+
+	pc := newContext()
+	encrypt, decrypt := EncryptSupplier(provider)(), DecryptSupplier(provider)()
+	assert.NoError(t, encrypt.(streams.Initializer).Init(pc))
+	assert.NoError(t, decrypt.(streams.Initializer).Init(pc))
+
+	value := `{"card":"4111","amount":10}`
+	encrypt.Process(pc, streams.NewRecord("test", streams.StringEncoder("id"), streams.StringEncoder(value), time.Now(), nil))
+	assert.Equal(t, 1, pc.Data.ForwardCount)
+
+	sealed, _ := pc.Data.Forwarded[0].EncodeValue()
+	assert.False(t, bytes.Contains(sealed, []byte("4111")))
+	key, _ := pc.Data.Forwarded[0].EncodeKey()
+	assert.Equal(t, "id", string(key))
+
+	decrypt.Process(pc, pc.Data.Forwarded[0])
+	assert.Equal(t, 2, pc.Data.ForwardCount)
+	opened, _ := pc.Data.Forwarded[1].EncodeValue()
+	assert.Equal(t, value, string(opened))
+
+	// tampered envelopes fail
+	sealed[len(sealed)-1] ^= 1
+	decrypt.Process(pc, streams.NewRecord("test", nil, streams.ByteEncoder(sealed), time.Now(), nil))
+	assert.Equal(t, 1, pc.Data.ErrorCount)
+
+	// unknown master keys fail
+	pc.Data.Config.Set("payments", "stream.envelope.key")
+	encrypt = EncryptSupplier(provider)()
+	assert.NoError(t, encrypt.(streams.Initializer).Init(pc))
+	encrypt.Process(pc, streams.NewRecord("test", nil, streams.StringEncoder(value), time.Now(), nil))
+	assert.Equal(t, 2, pc.Data.ErrorCount)
+}
+
+func TestEnvelopeFields(t *testing.T) {
+	provider, err := NewLocalProvider(map[string][]byte{"orders": bytes.Repeat([]byte{1}, 16)})
+	assert.NoError(t, err)
+
+	pc := newContext("$.card", "$.user.ssn", "$.missing")
+	encrypt, decrypt := EncryptSupplier(provider)(), DecryptSupplier(provider)()
+	assert.NoError(t, encrypt.(streams.Initializer).Init(pc))
+	assert.NoError(t, decrypt.(streams.Initializer).Init(pc))
+
+	value := `{"card":"4111","user":{"name":"joe","ssn":{"n":123}},"amount":10}`
+	encrypt.Process(pc, streams.NewRecord("test", nil, streams.StringEncoder(value), time.Now(), nil))
+	encrypt.Process(pc, streams.NewRecord("test", nil, streams.StringEncoder(value), time.Now(), nil))
+	assert.Equal(t, 2, pc.Data.ForwardCount)
+
+	sealed, _ := pc.Data.Forwarded[0].EncodeValue()
+	assert.False(t, bytes.Contains(sealed, []byte("4111")))
+	assert.False(t, bytes.Contains(sealed, []byte("123")))
+	assert.True(t, bytes.Contains(sealed, []byte(`"name":"joe"`)))
+
+	// data keys are reused until rotated
+	other, _ := pc.Data.Forwarded[1].EncodeValue()
+	first, second := fieldEnvelope(t, sealed, "$.card"), fieldEnvelope(t, other, "$.card")
+	assert.Equal(t, "orders", first.masterKey)
+	assert.Equal(t, first.dataKey, second.dataKey)
+	assert.NotEqual(t, first.ciphertext, second.ciphertext)
+
+	decrypt.Process(pc, pc.Data.Forwarded[0])
+	assert.Equal(t, 3, pc.Data.ForwardCount)
+	opened, _ := pc.Data.Forwarded[2].EncodeValue()
+	assert.JSONEq(t, value, string(opened))
+	assert.Equal(t, 1, pc.Cache().Len())
+
+	// plaintext fields fail decryption
+	decrypt.Process(pc, streams.NewRecord("test", nil, streams.StringEncoder(value), time.Now(), nil))
+	assert.Equal(t, 1, pc.Data.ErrorCount)
+}
@@ -0,0 +1,287 @@
+package envelope
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/jsonpath"
+)
+
+// DefaultRotation is the default lifetime of the data keys used for encryption
+const DefaultRotation = time.Hour
+
+var (
+	errNoMasterKey  = streams.Errorf(streams.CodeConfig, "envelope master key not set")
+	errNotEncrypted = streams.Errorf(streams.CodeRecord, "field is not an encrypted envelope")
+	errNotDocument  = streams.Errorf(streams.CodeSerialization, "record value is not a json document")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Encrypt)(nil)
+var _ streams.Processor = (*Encrypt)(nil)
+var _ streams.Initializer = (*Decrypt)(nil)
+var _ streams.Processor = (*Decrypt)(nil)
+
+// Encrypt encrypts the record values, or the given fields of json record
+// values, into envelopes with data keys from the KeyProvider, so they are
+// protected through the stream durable edges, overflows and store changelogs
+// until decrypted by a Decrypt processor. Whole values are replaced by the
+// binary envelope, and fields by the base64 encoded envelope of their json
+// value. Record keys are not encrypted as they route the records.
+// It is configured through the <stream>.<node> config subtree with the keys:
+//
+//	key:    id of the master key in the KeyProvider
+//	fields: array of json paths of the fields to encrypt
+//	rotate: lifetime of data keys, defaults to DefaultRotation
+type Encrypt struct {
+	provider  KeyProvider
+	masterKey string
+	fields    []*jsonpath.Path
+	rotate    time.Duration
+	aead      cipher.AEAD
+	dataKey   []byte
+	created   time.Time
+}
+
+// EncryptSupplier returns a supplier for Encrypt processors with the given KeyProvider
+func EncryptSupplier(provider KeyProvider) (supplier streams.ProcessorSupplier) {
+	return func() streams.Processor {
+		return &Encrypt{provider: provider}
+	}
+}
+
+// Init the processor with its configuration
+func (e *Encrypt) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	e.rotate = config.Get("rotate").Duration(DefaultRotation)
+
+	if e.masterKey = config.Get("key").String(""); e.masterKey == "" {
+		return errNoMasterKey
+	}
+
+	e.fields, err = compileFields(config)
+	return err
+}
+
+// Process encrypts the record value or fields and forwards the record
+func (e *Encrypt) Process(pc streams.ProcessorContext, record streams.Record) {
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	if e.aead == nil || time.Since(e.created) >= e.rotate {
+		if err = e.generate(); err != nil {
+			pc.Error(err, record)
+			return
+		}
+	}
+
+	if e.fields == nil {
+		if value, err = e.seal(value); err != nil {
+			pc.Error(err, record)
+			return
+		}
+		record.Value = streams.ByteEncoder(value)
+		pc.Forward(record)
+		return
+	}
+
+	document, err := jsonpath.Decode(value)
+	if err != nil {
+		pc.Error(errNotDocument, record)
+		return
+	}
+
+	for _, path := range e.fields {
+		field, ok := path.Get(document)
+		if !ok {
+			continue
+		}
+
+		data, err := json.Marshal(field)
+		if err != nil {
+			pc.Error(err, record)
+			return
+		}
+
+		if data, err = e.seal(data); err != nil {
+			pc.Error(err, record)
+			return
+		}
+		path.Set(document, base64.StdEncoding.EncodeToString(data))
+	}
+
+	if value, err = json.Marshal(document); err != nil {
+		pc.Error(err, record)
+		return
+	}
+	record.Value = streams.ByteEncoder(value)
+	pc.Forward(record)
+}
+
+// generate a new data key
+func (e *Encrypt) generate() (err error) {
+	plaintext, encrypted, err := e.provider.GenerateKey(e.masterKey)
+	if err != nil {
+		return err
+	}
+
+	if e.aead, err = newAEAD(plaintext); err != nil {
+		return err
+	}
+	e.dataKey = encrypted
+	e.created = time.Now()
+	return nil
+}
+
+// seal the plaintext into an encoded envelope
+func (e *Encrypt) seal(plaintext []byte) (data []byte, err error) {
+	ciphertext, err := seal(e.aead, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return envelope{masterKey: e.masterKey, dataKey: e.dataKey, ciphertext: ciphertext}.encode(), nil
+}
+
+// Decrypt decrypts the record values, or the given fields of json record
+// values, encrypted by an Encrypt processor. Decrypted data keys are kept in
+// the task cache, configured through the <stream>.<node>.cache config.
+// It is configured through the <stream>.<node> config subtree with the keys:
+//
+//	fields: array of json paths of the fields to decrypt
+type Decrypt struct {
+	provider KeyProvider
+	fields   []*jsonpath.Path
+}
+
+// DecryptSupplier returns a supplier for Decrypt processors with the given KeyProvider
+func DecryptSupplier(provider KeyProvider) (supplier streams.ProcessorSupplier) {
+	return func() streams.Processor {
+		return &Decrypt{provider: provider}
+	}
+}
+
+// Init the processor with its configuration
+func (d *Decrypt) Init(pc streams.ProcessorContext) (err error) {
+	d.fields, err = compileFields(pc.Config().Get(pc.StreamName(), pc.NodeName()))
+	return err
+}
+
+// Process decrypts the record value or fields and forwards the record
+func (d *Decrypt) Process(pc streams.ProcessorContext, record streams.Record) {
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	if d.fields == nil {
+		if value, err = d.open(pc, value); err != nil {
+			pc.Error(err, record)
+			return
+		}
+		record.Value = streams.ByteEncoder(value)
+		pc.Forward(record)
+		return
+	}
+
+	document, err := jsonpath.Decode(value)
+	if err != nil {
+		pc.Error(errNotDocument, record)
+		return
+	}
+
+	for _, path := range d.fields {
+		field, ok := path.Get(document)
+		if !ok {
+			continue
+		}
+
+		encoded, ok := field.(string)
+		if !ok {
+			pc.Error(errNotEncrypted, record)
+			return
+		}
+
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			pc.Error(errNotEncrypted, record)
+			return
+		}
+
+		if data, err = d.open(pc, data); err != nil {
+			pc.Error(err, record)
+			return
+		}
+
+		if field, err = jsonpath.Decode(data); err != nil {
+			pc.Error(err, record)
+			return
+		}
+		path.Set(document, field)
+	}
+
+	if value, err = json.Marshal(document); err != nil {
+		pc.Error(err, record)
+		return
+	}
+	record.Value = streams.ByteEncoder(value)
+	pc.Forward(record)
+}
+
+// open the encoded envelope. Decrypted data keys are kept in the task cache.
+func (d *Decrypt) open(pc streams.ProcessorContext, data []byte) (plaintext []byte, err error) {
+	e, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	id := "envelope/" + e.masterKey + "/" + string(e.dataKey)
+	aead, ok := pc.Cache().Get(id)
+	if !ok {
+		key, err := d.provider.DecryptKey(e.masterKey, e.dataKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if aead, err = newAEAD(key); err != nil {
+			return nil, err
+		}
+		pc.Cache().Set(id, aead)
+	}
+
+	return open(aead.(cipher.AEAD), e.ciphertext)
+}
+
+// compileFields compiles the json paths of the fields config
+func compileFields(config streams.Config) (fields []*jsonpath.Path, err error) {
+	for _, field := range config.Get("fields").Array() {
+		path, err := jsonpath.Compile(field.String(""))
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, path)
+	}
+	return fields, nil
+}
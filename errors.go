@@ -0,0 +1,168 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Category classifies errors emitted by stream components, allowing error
+// handlers to choose an action such as retrying, skipping or dead lettering.
+type Category int
+
+// Error categories
+const (
+	// Unclassified errors
+	Unclassified Category = iota
+	// Transient errors may succeed if retried, e.g. timeouts or unavailable services
+	Transient
+	// Permanent errors will not succeed if retried, e.g. rejected requests
+	Permanent
+	// Poison errors are caused by the record itself, e.g. malformed payloads
+	Poison
+	// Resource errors are caused by exhausted resources, e.g. full disks or quotas
+	Resource
+)
+
+// String returns the category name
+func (c Category) String() (name string) {
+	switch c {
+	case Transient:
+		return "transient"
+	case Permanent:
+		return "permanent"
+	case Poison:
+		return "poison"
+	case Resource:
+		return "resource"
+	}
+	return "unclassified"
+}
+
+// classified is a error with a category
+type classified struct {
+	err      error
+	category Category
+}
+
+func (c *classified) Error() string      { return c.err.Error() }
+func (c *classified) Unwrap() error      { return c.err }
+func (c *classified) Category() Category { return c.category }
+
+// Classify attaches the category to the error. A nil error is returned as nil.
+func Classify(err error, category Category) (classifiedErr error) {
+	if err == nil {
+		return nil
+	}
+	return &classified{err: err, category: category}
+}
+
+// CategoryOf returns the category of the error, looking through wrapped
+// errors for the first one with a category.
+func CategoryOf(err error) (category Category) {
+	for err != nil {
+		if c, ok := err.(interface{ Category() Category }); ok {
+			return c.Category()
+		}
+
+		u, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return Unclassified
+		}
+		err = u.Unwrap()
+	}
+	return Unclassified
+}
+
+// Code identifies the component or operation failing with a streams error,
+// allowing callers to distinguish serialization, store and topology errors.
+// Codes are error values matching the errors with the same code with errors.Is.
+type Code int
+
+// Error codes
+const (
+	// CodeUnknown errors have no code
+	CodeUnknown Code = iota
+	// CodeSerialization errors encoding or decoding records, keys and values
+	CodeSerialization
+	// CodeStore errors are failures of state stores
+	CodeStore
+	// CodeTopology errors are invalid topology definitions or references
+	CodeTopology
+	// CodeConfig errors are invalid configurations
+	CodeConfig
+	// CodeState errors are operations invalid in the current stream state
+	CodeState
+	// CodeRecord errors are records rejected by stream components
+	CodeRecord
+)
+
+// String returns the code name
+func (c Code) String() (name string) {
+	switch c {
+	case CodeSerialization:
+		return "serialization"
+	case CodeStore:
+		return "store"
+	case CodeTopology:
+		return "topology"
+	case CodeConfig:
+		return "config"
+	case CodeState:
+		return "state"
+	case CodeRecord:
+		return "record"
+	}
+	return "unknown"
+}
+
+// Error returns the code description, making codes targets for errors.Is
+func (c Code) Error() string {
+	return c.String() + " error"
+}
+
+// CodedError is a error with a Code, matching the code with errors.Is
+// and found with errors.As through wrapping errors
+type CodedError struct {
+	Code Code
+	Err  error
+}
+
+func (e *CodedError) Error() string { return e.Err.Error() }
+func (e *CodedError) Unwrap() error { return e.Err }
+
+// Is reports whether the target is the error code
+func (e *CodedError) Is(target error) bool {
+	code, ok := target.(Code)
+	return ok && code == e.Code
+}
+
+// Errorf formats the error as fmt.Errorf, wrapping the %w operand, with the code
+func Errorf(code Code, format string, args ...interface{}) (err error) {
+	return &CodedError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// CodeOf returns the code of the error, looking through wrapped
+// errors for the first one with a code.
+func CodeOf(err error) (code Code) {
+	var coded *CodedError
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+	return CodeUnknown
+}
@@ -0,0 +1,84 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCategoryOf(t *testing.T) {
+	base := errors.New("timeout")
+	assert.Nil(t, Classify(nil, Transient))
+	assert.Equal(t, Unclassified, CategoryOf(nil))
+	assert.Equal(t, Unclassified, CategoryOf(base))
+
+	err := Classify(base, Transient)
+	assert.Equal(t, "timeout", err.Error())
+	assert.Equal(t, Transient, CategoryOf(err))
+	assert.Equal(t, "transient", CategoryOf(err).String())
+
+	// categories are found through wrapped errors
+	wrapped := fmt.Errorf("sink: %w", Classify(base, Poison))
+	assert.Equal(t, Poison, CategoryOf(wrapped))
+	assert.True(t, errors.Is(wrapped, base))
+
+	// the outermost category wins
+	assert.Equal(t, Resource, CategoryOf(Classify(Classify(base, Permanent), Resource)))
+
+	var received Error
+	pc := &processorContext{node: &Node{}, stream: &Stream{handler: ErrorHandlerFunc(func(e Error) { received = e })}}
+	pc.Error(Classify(base, Permanent))
+	assert.Equal(t, Permanent, received.Category)
+}
+
+func TestCodeOf(t *testing.T) {
+	base := errors.New("malformed")
+	err := Errorf(CodeSerialization, "decode: %w", base)
+	assert.Equal(t, "decode: malformed", err.Error())
+	assert.Equal(t, CodeSerialization, CodeOf(err))
+	assert.Equal(t, "serialization", CodeOf(err).String())
+	assert.Equal(t, CodeUnknown, CodeOf(base))
+	assert.Equal(t, CodeUnknown, CodeOf(nil))
+
+	// codes and wrapped errors are matched through wrapping errors
+	wrapped := fmt.Errorf("sink: %w", err)
+	assert.True(t, errors.Is(wrapped, CodeSerialization))
+	assert.True(t, errors.Is(wrapped, base))
+	assert.False(t, errors.Is(wrapped, CodeStore))
+
+	var coded *CodedError
+	assert.True(t, errors.As(wrapped, &coded))
+	assert.Equal(t, CodeSerialization, coded.Code)
+
+	// package errors keep their identity and code
+	assert.True(t, errors.Is(ErrNodeNotFound, CodeTopology))
+	assert.True(t, errors.Is(ErrKeyNotFound, CodeStore))
+	assert.True(t, errors.Is(ErrInvalidRecord, CodeSerialization))
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() Source { return &testSource{} }))
+	assert.NoError(t, b.AddSinkFunc("a", func(pc ProcessorContext, record Record) {}, "source"))
+	assert.NoError(t, b.AddSinkFunc("b", func(pc ProcessorContext, record Record) {}, "source"))
+	assert.NoError(t, b.Route("source", map[string]string{"orders.*": "a", "orders.eu": "b"}))
+	err = b.Validate()
+	assert.True(t, errors.Is(err, CodeTopology))
+	assert.True(t, errors.Is(err, errRouteOverlap))
+}
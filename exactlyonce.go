@@ -0,0 +1,765 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProcessingGuarantee is the processing guarantee of a stream
+type ProcessingGuarantee int
+
+const (
+	// AtLeastOnce processes every record at least once. Store mutations are
+	// applied as they happen, and records in flight during a failure are
+	// processed again once their sources resume.
+	AtLeastOnce ProcessingGuarantee = iota
+	// ExactlyOnce applies the effects of every record on the stream stores
+	// exactly once. Store mutations are buffered and committed atomically by a
+	// checkpoint coordinator, along with the source offsets kept in stores.
+	ExactlyOnce
+)
+
+const (
+	// DefaultCheckpointInterval is the default interval between checkpoints
+	// of streams with the ExactlyOnce guarantee
+	DefaultCheckpointInterval = time.Second
+)
+
+var (
+	errInvalidCheckpoint = Errorf(CodeStore, "invalid checkpoint")
+)
+
+// Guarantee sets the processing guarantee of the stream, AtLeastOnce by default.
+// With ExactlyOnce, the mutations of the stores accessed through the processor
+// context are buffered, visible only to the stream processors, and committed
+// atomically on every <stream>.checkpoint.interval and when the stream closes.
+// Windowed and TTL stores keep their interfaces, with the expiration of values
+// set with a ttl buffered along with them. Checkpoints are postponed while
+// nodes are paused.
+// Sources must keep their offsets in a stream store with NewOffsetStore,
+// committing them on ack, so a restarted stream resumes from the offsets of
+// the last checkpoint with the stores holding the effects of exactly the
+// records before them. Effects on external systems are not covered.
+func (b *Builder) Guarantee(guarantee ProcessingGuarantee) {
+	b.guarantee = guarantee
+}
+
+// coordinator commits the mutations of the stream stores atomically on every
+// checkpoint. Checkpoints stop the sources, wait for the records in flight to
+// be processed, and write the buffered mutations to the checkpoint file before
+// applying them to the stores. A checkpoint file left by a failure is applied
+// again when the stream starts.
+type coordinator struct {
+	mtx     sync.Mutex
+	run     sync.Mutex
+	gate    sync.Mutex
+	opened  *sync.Cond
+	closed  bool
+	entered int
+	stream  *Stream
+	path    string
+	stores  map[string]*txView
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// txView is the transactional view of a store node, buffering the mutations
+// of the innermost store underlying the node store
+type txView struct {
+	store Store
+	view  Store
+	tx    *txStore
+}
+
+// newCoordinator creates the checkpoint coordinator of the stream, applying
+// any checkpoint left by a failure. Checkpoints are kept within the
+// <stream>.state.dir directory.
+func newCoordinator(s *Stream) (c *coordinator, err error) {
+	statePath, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	statePath = s.config.Get(s.name, "state", "dir").String(filepath.Join(statePath, "state"))
+	dir := filepath.Join(statePath, "checkpoints")
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	c = &coordinator{}
+	c.stream = s
+	c.path = filepath.Join(dir, s.name)
+	c.stores = make(map[string]*txView)
+	c.opened = sync.NewCond(&c.gate)
+
+	if err = c.recover(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// start checkpointing at the <stream>.checkpoint.interval
+func (c *coordinator) start() {
+	interval := c.stream.config.Get(c.stream.name, "checkpoint", "interval").
+		Duration(DefaultCheckpointInterval)
+
+	c.done = make(chan struct{})
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-ticker.C:
+				if err := c.checkpoint(); err != nil {
+					c.stream.handleError(Error{Error: err, Category: CategoryOf(err)})
+				}
+			}
+		}
+	}()
+}
+
+// close stops checkpointing and commits the remaining mutations
+func (c *coordinator) close() (err error) {
+	c.abort()
+	return c.checkpoint()
+}
+
+// abort stops checkpointing, discarding the mutations not yet committed
+func (c *coordinator) abort() {
+	if c.done != nil {
+		close(c.done)
+		c.wg.Wait()
+		c.done = nil
+	}
+}
+
+// enter is called by sources before forwarding records, waiting
+// for any checkpoint in progress
+func (c *coordinator) enter() {
+	if c != nil {
+		c.gate.Lock()
+		for c.closed {
+			c.opened.Wait()
+		}
+		c.entered++
+		c.gate.Unlock()
+	}
+}
+
+// exit is called by sources once done forwarding records
+func (c *coordinator) exit() {
+	if c != nil {
+		c.gate.Lock()
+		c.entered--
+		c.gate.Unlock()
+	}
+}
+
+// setClosed closes the gate of the sources, or opens it
+// releasing the sources waiting to enter
+func (c *coordinator) setClosed(closed bool) {
+	c.gate.Lock()
+	defer c.gate.Unlock()
+
+	c.closed = closed
+	if !closed {
+		c.opened.Broadcast()
+	}
+}
+
+// store returns the transactional view of the named store node
+func (c *coordinator) store(name string, store Store) (tx Store) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	v, exists := c.stores[name]
+	if !exists || v.store != store {
+		v = &txView{store: store}
+		v.view, v.tx = layer(store)
+		c.stores[name] = v
+	}
+	return v.view
+}
+
+// layer returns the transactional view of the store, layering the stores
+// implemented over a underlying store over the view of their underlying
+// store, along with the transaction of the innermost store
+func layer(store Store) (view Store, tx *txStore) {
+	if layered, ok := store.(Layered); ok {
+		view, tx = layer(layered.Underlying())
+		return layered.Layer(view), tx
+	}
+
+	tx = newTxStore(store)
+	if _, ok := store.(TTLStore); ok {
+		return txTTLStore{tx}, tx
+	}
+	return tx, tx
+}
+
+// underlying returns the innermost store underlying the given store
+func underlying(store Store) (inner Store) {
+	for {
+		layered, ok := store.(Layered)
+		if !ok {
+			return store
+		}
+		store = layered.Underlying()
+	}
+}
+
+// checkpoint commits the buffered mutations of all stores once the
+// records forwarded by the sources are processed. Checkpoints are
+// postponed while nodes are paused, unless the stream is stopping.
+func (c *coordinator) checkpoint() (err error) {
+	c.run.Lock()
+	defer c.run.Unlock()
+
+	c.setClosed(true)
+	defer c.setClosed(false)
+
+	if !c.quiesce() {
+		return nil
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var buf []byte
+	names := make([]string, 0, len(c.stores))
+	for name := range c.stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		buf = c.stores[name].tx.encode(buf, name)
+	}
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if err = writeCheckpoint(c.path, buf); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err = c.stores[name].tx.commit(); err != nil {
+			return err
+		}
+	}
+	return os.Remove(c.path)
+}
+
+// quiesce waits for the sources to leave the gate and for the records they
+// forwarded to be processed by the node tasks, fanout and worker queues and
+// rescale handoffs. Records are queued downstream while being processed, so
+// the stream is quiescent once all queues are found idle with no records
+// queued meanwhile. It returns false if nodes are paused while the stream
+// is not stopping, as their records may not be processed until resumed.
+func (c *coordinator) quiesce() (ok bool) {
+	for ; ; time.Sleep(time.Millisecond) {
+		if c.paused() {
+			return false
+		}
+
+		c.gate.Lock()
+		entered := c.entered
+		c.gate.Unlock()
+		if entered > 0 {
+			continue
+		}
+
+		queued := c.queued()
+		if c.idle() && c.queued() == queued {
+			return true
+		}
+	}
+}
+
+// paused returns if any node is paused while the stream is not stopping
+func (c *coordinator) paused() (paused bool) {
+	if c.stream.stop.stopped() {
+		return false
+	}
+
+	for _, node := range c.stream.topology.nodes {
+		if node.pause.isPaused() {
+			return true
+		}
+	}
+	return false
+}
+
+// queued returns the number of records queued so far to the stream queues
+func (c *coordinator) queued() (queued int64) {
+	c.queues(func(q, processed *int64) {
+		queued += atomic.LoadInt64(q)
+	})
+	return queued
+}
+
+// idle returns if all records queued to the stream queues were processed
+func (c *coordinator) idle() (idle bool) {
+	idle = true
+	c.queues(func(queued, processed *int64) {
+		idle = idle && atomic.LoadInt64(processed) >= atomic.LoadInt64(queued)
+	})
+	return idle
+}
+
+// queues applies the callback for the counts of records queued to and
+// processed by the node tasks, fanout and worker queues and rescale handoffs
+func (c *coordinator) queues(cb func(queued, processed *int64)) {
+	for _, node := range c.stream.topology.nodes {
+		if f := node.fanout; f != nil {
+			cb(&f.dispatched, &f.processed)
+		}
+		if w := node.workers; w != nil {
+			cb(&w.queued, &w.processed)
+		}
+	}
+
+	for _, t := range c.stream.tasks {
+		t.RLock()
+		for _, pc := range t.contexts {
+			cb(&pc.pushed, &pc.processed)
+		}
+		if h := t.handoff; h != nil {
+			cb(&h.held, &h.released)
+		}
+		t.RUnlock()
+	}
+}
+
+// recover applies the checkpoint left by a failure, if any
+func (c *coordinator) recover() (err error) {
+	data, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for len(data) > 0 {
+		var name, key, value, expires []byte
+		var op byte
+
+		if name, data, err = readBytes(data); err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return errInvalidCheckpoint
+		}
+		op, data = data[0], data[1:]
+		if key, data, err = readBytes(data); err != nil {
+			return err
+		}
+		if value, data, err = readBytes(data); err != nil {
+			return err
+		}
+
+		w := txWrite{value: value, deleted: op == txDelete, ttl: op == txSetWithTTL}
+		if w.ttl {
+			if expires, data, err = readBytes(data); err != nil {
+				return err
+			}
+			if len(expires) != 8 {
+				return errInvalidCheckpoint
+			}
+			if nanos := int64(binary.BigEndian.Uint64(expires)); nanos != 0 {
+				w.expires = time.Unix(0, nanos)
+			}
+		}
+
+		node, err := c.stream.store(string(name))
+		if err != nil {
+			return err
+		}
+
+		if err = w.apply(underlying(node.processor.(Store)), key); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(c.path)
+}
+
+// writeCheckpoint durably writes the checkpoint to path
+func writeCheckpoint(path string, buf []byte) (err error) {
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(buf); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// appendBytes appends the length prefixed data to buf
+func appendBytes(buf, data []byte) []byte {
+	var n [binary.MaxVarintLen64]byte
+	buf = append(buf, n[:binary.PutUvarint(n[:], uint64(len(data)))]...)
+	return append(buf, data...)
+}
+
+// readBytes reads the length prefixed data from buf
+func readBytes(buf []byte) (data, rest []byte, err error) {
+	size, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < size {
+		return nil, nil, errInvalidCheckpoint
+	}
+	return buf[n : n+int(size)], buf[n+int(size):], nil
+}
+
+// transactional store operations
+const (
+	txSet        byte = 1
+	txDelete     byte = 2
+	txSetWithTTL byte = 3
+)
+
+// txWrite is a buffered store mutation. Values set with a ttl expire at
+// the expiration time, if set.
+type txWrite struct {
+	value   []byte
+	deleted bool
+	ttl     bool
+	expires time.Time
+}
+
+// expired returns if the value set with a ttl is expired at the given time
+func (w txWrite) expired(now time.Time) (expired bool) {
+	return w.ttl && !w.expires.IsZero() && !now.Before(w.expires)
+}
+
+// apply the mutation of the given key to the store. Values set with
+// a ttl and expired are deleted.
+func (w txWrite) apply(store Store, key []byte) (err error) {
+	switch {
+	case w.deleted:
+		return store.Delete(key)
+	case !w.ttl:
+		return store.Set(key, w.value)
+	}
+
+	ttl := time.Duration(0)
+	if !w.expires.IsZero() {
+		if ttl = time.Until(w.expires); ttl <= 0 {
+			return store.Delete(key)
+		}
+	}
+
+	if ts, ok := store.(TTLStore); ok {
+		return ts.SetWithTTL(key, w.value, ttl)
+	}
+	return store.Set(key, w.value)
+}
+
+// txStore buffers the mutations of a store until committed. Reads observe
+// the buffered mutations over the committed store state.
+type txStore struct {
+	Store
+	mtx    sync.RWMutex
+	writes map[string]txWrite
+}
+
+// newTxStore creates a transactional view of the given store
+func newTxStore(store Store) (t *txStore) {
+	return &txStore{Store: store, writes: make(map[string]txWrite)}
+}
+
+// Process store or deletes any forwarded record to the store.
+// Records with empty values deletes the given key from the store.
+func (t *txStore) Process(pc ProcessorContext, record Record) {
+	if !record.IsValid() || record.Key == nil {
+		pc.Error(Errorf(CodeSerialization, "invalid record to store"), record)
+		return
+	}
+
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(Errorf(CodeSerialization, "error serializing record key: %w", err), record)
+		return
+	}
+
+	if record.Value == nil {
+		if err = t.Delete(key); err != nil {
+			pc.Error(err, record)
+		}
+		return
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(Errorf(CodeSerialization, "error serializing record value: %w", err), record)
+		return
+	}
+
+	if err = t.Set(key, value); err != nil {
+		pc.Error(err, record)
+	}
+}
+
+// Get value for the given key.
+func (t *txStore) Get(key []byte) (value []byte, err error) {
+	t.mtx.RLock()
+	w, ok := t.writes[string(key)]
+	t.mtx.RUnlock()
+
+	if !ok {
+		return t.Store.Get(key)
+	}
+
+	if w.deleted || w.expired(time.Now()) {
+		return nil, ErrKeyNotFound
+	}
+	return w.value, nil
+}
+
+// Set value for the given key.
+func (t *txStore) Set(key, value []byte) (err error) {
+	t.mtx.Lock()
+	t.writes[string(key)] = txWrite{value: append([]byte(nil), value...)}
+	t.mtx.Unlock()
+	return nil
+}
+
+// Delete value for the given key.
+func (t *txStore) Delete(key []byte) (err error) {
+	t.mtx.Lock()
+	t.writes[string(key)] = txWrite{deleted: true}
+	t.mtx.Unlock()
+	return nil
+}
+
+// Range iterates the store within the given key range applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+func (t *txStore) Range(from, to []byte, cb func(key, value []byte) error) (err error) {
+	keys, writes := t.pending(func(key string) bool {
+		return (from == nil || key >= string(from)) && (to == nil || key < string(to))
+	})
+
+	return t.merge(keys, writes, func(cb func(key, value []byte) error) error {
+		return t.Store.Range(from, to, cb)
+	}, cb)
+}
+
+// RangePrefix iterates the store over a key prefix applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+func (t *txStore) RangePrefix(prefix []byte, cb func(key, value []byte) error) (err error) {
+	keys, writes := t.pending(func(key string) bool {
+		return bytes.HasPrefix([]byte(key), prefix)
+	})
+
+	return t.merge(keys, writes, func(cb func(key, value []byte) error) error {
+		return t.Store.RangePrefix(prefix, cb)
+	}, cb)
+}
+
+// pending returns the sorted keys and mutations matching the given function,
+// with the expired values as deletes
+func (t *txStore) pending(match func(key string) bool) (keys []string, writes map[string]txWrite) {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	now := time.Now()
+	writes = make(map[string]txWrite)
+	for key, w := range t.writes {
+		if match(key) {
+			if w.expired(now) {
+				w = txWrite{deleted: true}
+			}
+			keys = append(keys, key)
+			writes[key] = w
+		}
+	}
+	sort.Strings(keys)
+	return keys, writes
+}
+
+// merge applies the callback over the committed key value pairs of the
+// iteration merged in order with the given pending mutations
+func (t *txStore) merge(keys []string, writes map[string]txWrite,
+	iterate func(cb func(key, value []byte) error) error, cb func(key, value []byte) error) (err error) {
+
+	var idx int
+	emit := func(limit string, inclusive bool) error {
+		for ; idx < len(keys) && (keys[idx] < limit || inclusive); idx++ {
+			if w := writes[keys[idx]]; !w.deleted {
+				if err := cb([]byte(keys[idx]), w.value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	err = iterate(func(key, value []byte) error {
+		if err := emit(string(key), false); err != nil {
+			return err
+		}
+
+		// Keys with pending mutations are replaced by them
+		if idx < len(keys) && keys[idx] == string(key) {
+			w := writes[keys[idx]]
+			idx++
+			if w.deleted {
+				return nil
+			}
+			return cb(key, w.value)
+		}
+		return cb(key, value)
+	})
+
+	if err != nil {
+		return err
+	}
+	return emit("", true)
+}
+
+// encode appends the pending mutations of the named store to buf
+func (t *txStore) encode(buf []byte, name string) []byte {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	for key, w := range t.writes {
+		buf = appendBytes(buf, []byte(name))
+		switch {
+		case w.deleted:
+			buf = append(buf, txDelete)
+		case w.ttl:
+			buf = append(buf, txSetWithTTL)
+		default:
+			buf = append(buf, txSet)
+		}
+		buf = appendBytes(buf, []byte(key))
+		buf = appendBytes(buf, w.value)
+
+		if w.ttl {
+			var expires [8]byte
+			if !w.expires.IsZero() {
+				binary.BigEndian.PutUint64(expires[:], uint64(w.expires.UnixNano()))
+			}
+			buf = appendBytes(buf, expires[:])
+		}
+	}
+	return buf
+}
+
+// commit applies the pending mutations to the store
+func (t *txStore) commit() (err error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for key, w := range t.writes {
+		if err = w.apply(t.Store, []byte(key)); err != nil {
+			return err
+		}
+		delete(t.writes, key)
+	}
+	return nil
+}
+
+// txTTLStore is the transactional view of a TTLStore
+type txTTLStore struct {
+	*txStore
+}
+
+// SetWithTTL sets the value for the given key expiring after the given ttl.
+// The expiration time is kept with the buffered mutation, so values
+// committed after their expiration are deleted instead.
+func (t txTTLStore) SetWithTTL(key, value []byte, ttl time.Duration) (err error) {
+	w := txWrite{value: append([]byte(nil), value...), ttl: true}
+	if ttl > 0 {
+		w.expires = time.Now().Add(ttl)
+	}
+
+	t.mtx.Lock()
+	t.writes[string(key)] = w
+	t.mtx.Unlock()
+	return nil
+}
+
+// Expire applies the callback for the keys expired at the given time and
+// their last value, deleting them afterwards. Buffered values are expired
+// within the transaction, and the keys of the store expire unless replaced
+// by buffered mutations.
+func (t txTTLStore) Expire(now time.Time, cb func(key, value []byte) error) (err error) {
+	var keys []string
+	values := make(map[string][]byte)
+
+	t.mtx.RLock()
+	for key, w := range t.writes {
+		if w.expired(now) {
+			keys = append(keys, key)
+			values[key] = w.value
+		}
+	}
+	t.mtx.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err = cb([]byte(key), values[key]); err != nil {
+			return err
+		}
+		if err = t.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+
+	return t.Store.(TTLStore).Expire(now, func(key, value []byte) error {
+		t.mtx.RLock()
+		_, buffered := t.writes[string(key)]
+		t.mtx.RUnlock()
+
+		if buffered {
+			return nil
+		}
+		return cb(key, value)
+	})
+}
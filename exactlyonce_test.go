@@ -0,0 +1,302 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ttlStore is a memStore expiring all keys set with a ttl
+type ttlStore struct {
+	memStore
+	ttls map[string]time.Duration
+}
+
+func (s *ttlStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	s.ttls[string(key)] = ttl
+	return s.Set(key, value)
+}
+
+func (s *ttlStore) Expire(now time.Time, cb func(key, value []byte) error) error {
+	for key := range s.ttls {
+		if err := cb([]byte(key), s.data[key]); err != nil {
+			return err
+		}
+		delete(s.ttls, key)
+		delete(s.data, key)
+	}
+	return nil
+}
+
+func TestTxStore(t *testing.T) {
+	store := &memStore{data: map[string][]byte{"a": []byte("1"), "c": []byte("3")}}
+	tx := newTxStore(store)
+
+	assert.NoError(t, tx.Set([]byte("a"), []byte("9")))
+	assert.NoError(t, tx.Set([]byte("b"), []byte("2")))
+	assert.NoError(t, tx.Delete([]byte("c")))
+	assert.NoError(t, tx.Set([]byte("d"), []byte("4")))
+
+	value, err := tx.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("9"), value)
+
+	_, err = tx.Get([]byte("c"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	var pairs []string
+	assert.NoError(t, tx.Range(nil, nil, func(key, value []byte) error {
+		pairs = append(pairs, string(key)+"="+string(value))
+		return nil
+	}))
+	assert.Equal(t, []string{"a=9", "b=2", "d=4"}, pairs)
+
+	// mutations are applied to the store once committed
+	assert.Equal(t, []byte("1"), store.data["a"])
+	assert.NoError(t, tx.commit())
+	assert.Equal(t, map[string][]byte{"a": []byte("9"), "b": []byte("2"), "d": []byte("4")}, store.data)
+	assert.Len(t, tx.writes, 0)
+}
+
+func TestExactlyOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := NewConfig(nil)
+	config.Set(dir, "stream", "state", "dir")
+	config.Set("1h", "stream", "checkpoint", "interval")
+
+	counts := &memStore{data: make(map[string][]byte)}
+	processed := make(chan struct{}, 10)
+
+	b := NewBuilder("stream", config)
+	b.Guarantee(ExactlyOnce)
+	assert.NoError(t, b.AddStore("counts", func() Store { return counts }))
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(10)))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		store, _ := pc.Store("counts")
+		value, _ := record.EncodeValue()
+		store.Set(value, value)
+		processed <- struct{}{}
+	}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	for x := 0; x < 10; x++ {
+		<-processed
+	}
+
+	// mutations are not visible outside the stream until checkpointed
+	store, err := stream.Store("counts")
+	assert.NoError(t, err)
+	_, err = store.Get([]byte("0"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.NoError(t, stream.coordinator.checkpoint())
+	value, err := store.Get([]byte("9"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("9"), value)
+	assert.NoError(t, stream.Close())
+
+	_, err = os.Stat(filepath.Join(dir, "checkpoints", "stream"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExactlyOnceRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := NewConfig(nil)
+	config.Set(dir, "stream", "state", "dir")
+
+	// a checkpoint written but not applied before a failure
+	tx := newTxStore(&memStore{data: make(map[string][]byte)})
+	assert.NoError(t, tx.Set([]byte("a"), []byte("1")))
+	assert.NoError(t, tx.Delete([]byte("b")))
+	assert.NoError(t, txTTLStore{tx}.SetWithTTL([]byte("c"), []byte("3"), time.Hour))
+	assert.NoError(t, txTTLStore{tx}.SetWithTTL([]byte("d"), []byte("4"), time.Nanosecond))
+	path := filepath.Join(dir, "checkpoints", "stream")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0750))
+	assert.NoError(t, writeCheckpoint(path, tx.encode(nil, "counts")))
+
+	counts := &memStore{data: map[string][]byte{"b": []byte("2")}}
+	b := NewBuilder("stream", config)
+	b.Guarantee(ExactlyOnce)
+	assert.NoError(t, b.AddStore("counts", func() Store { return counts }))
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(0)))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	assert.Equal(t, map[string][]byte{"a": []byte("1"), "c": []byte("3")}, counts.data)
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, stream.Close())
+}
+
+func TestTxTTLStore(t *testing.T) {
+	store := &ttlStore{
+		memStore: memStore{data: map[string][]byte{"a": []byte("1"), "b": []byte("2")}},
+		ttls:     map[string]time.Duration{"a": time.Hour, "b": time.Hour},
+	}
+
+	view, tx := layer(store)
+	ts, ok := view.(TTLStore)
+	assert.True(t, ok)
+
+	assert.NoError(t, ts.SetWithTTL([]byte("c"), []byte("3"), time.Hour))
+	assert.NoError(t, ts.SetWithTTL([]byte("d"), []byte("4"), time.Nanosecond))
+	assert.NoError(t, ts.Set([]byte("b"), []byte("9")))
+	time.Sleep(time.Millisecond)
+
+	// expired values are not visible
+	_, err := ts.Get([]byte("d"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	var pairs []string
+	assert.NoError(t, ts.Range(nil, nil, func(key, value []byte) error {
+		pairs = append(pairs, string(key)+"="+string(value))
+		return nil
+	}))
+	assert.Equal(t, []string{"a=1", "b=9", "c=3"}, pairs)
+
+	// keys replaced by buffered mutations do not expire
+	expired := make(map[string]string)
+	assert.NoError(t, ts.Expire(time.Now(), func(key, value []byte) error {
+		expired[string(key)] = string(value)
+		return nil
+	}))
+	assert.Equal(t, map[string]string{"a": "1", "d": "4"}, expired)
+
+	assert.NoError(t, tx.commit())
+	assert.Equal(t, map[string][]byte{"b": []byte("9"), "c": []byte("3")}, store.data)
+	assert.True(t, store.ttls["c"] > 0 && store.ttls["c"] <= time.Hour)
+}
+
+func TestExactlyOnceWorkers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := NewConfig(nil)
+	config.Set(dir, "stream", "state", "dir")
+	config.Set("1h", "stream", "checkpoint", "interval")
+	config.Set(2, "stream", "sink", "concurrency", "workers")
+	config.Set(10, "stream", "sink", "concurrency", "queue")
+
+	counts := &memStore{data: make(map[string][]byte)}
+	received := make(chan struct{}, 10)
+	release := make(chan struct{})
+
+	b := NewBuilder("stream", config)
+	b.Guarantee(ExactlyOnce)
+	assert.NoError(t, b.AddStore("counts", func() Store { return counts }))
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(10)))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		received <- struct{}{}
+		<-release
+		store, _ := pc.Store("counts")
+		value, _ := record.EncodeValue()
+		store.Set(value, value)
+	}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	// checkpoints wait for the records queued to the sink workers
+	<-received
+	checkpointed := make(chan error)
+	go func() { checkpointed <- stream.coordinator.checkpoint() }()
+
+	select {
+	case <-checkpointed:
+		t.Fatal("checkpoint before the records were processed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.NoError(t, <-checkpointed)
+
+	counts.mtx.Lock()
+	assert.Len(t, counts.data, 10)
+	counts.mtx.Unlock()
+	assert.NoError(t, stream.Close())
+}
+
+func TestExactlyOncePaused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := NewConfig(nil)
+	config.Set(dir, "stream", "state", "dir")
+	config.Set("10ms", "stream", "checkpoint", "interval")
+	config.Set(1, "stream", "sink", "tasks", "count")
+	config.Set(1, "stream", "sink", "tasks", "buffer")
+
+	counts := &memStore{data: make(map[string][]byte)}
+
+	b := NewBuilder("stream", config)
+	b.Guarantee(ExactlyOnce)
+	assert.NoError(t, b.AddStore("counts", func() Store { return counts }))
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(100)))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		store, _ := pc.Store("counts")
+		value, _ := record.EncodeValue()
+		store.Set(value, value)
+	}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Pause("sink"))
+	assert.NoError(t, stream.Start())
+	time.Sleep(50 * time.Millisecond)
+
+	// checkpoints are postponed while the source is blocked on a paused node
+	checkpointed := make(chan error)
+	go func() { checkpointed <- stream.coordinator.checkpoint() }()
+
+	select {
+	case err = <-checkpointed:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("checkpoint blocked by a paused node")
+	}
+
+	closed := make(chan error)
+	go func() { closed <- stream.Close() }()
+
+	select {
+	case err = <-closed:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("close blocked by a paused node")
+	}
+}
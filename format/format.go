@@ -0,0 +1,153 @@
+package format
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/jsonpath"
+)
+
+var (
+	errInvalidConfig = streams.Errorf(streams.CodeConfig, "invalid formatter config")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Formatter)(nil)
+var _ streams.Processor = (*Formatter)(nil)
+
+// Data is the record data available to the formatter templates
+type Data struct {
+	Topic string      // Record topic
+	Key   string      // Record key
+	Value interface{} // Record value decoded from json, or the raw value if not json
+	Raw   string      // Record raw value
+	Time  time.Time   // Record time
+}
+
+// Formatter formats the record values with a Go text template executed over
+// the record Data, for text or json output to sinks such as files, webhooks
+// and alerting. Formatted records keep their topic, key, time and acknowledgment.
+// Besides the text/template builtins, templates can use the functions:
+//
+//	json:  encodes the argument as json, e.g. {{json .Value}}
+//	path:  selects the value at the JSONPath, e.g. {{path "$.user.name" .Value}}
+//	upper: converts the string to upper case
+//	lower: converts the string to lower case
+//	trim:  trims the leading and trailing spaces of the string
+//
+// It is configured through the <stream>.<node> config subtree with the keys:
+//
+//	template: inline template text
+//	file:     path of the template file, used instead of template
+//	json:     validates and compacts the output as json, defaults to false
+type Formatter struct {
+	template *template.Template
+	json     bool
+}
+
+// Supplier for the formatter processor
+func Supplier() (processor streams.Processor) {
+	return &Formatter{}
+}
+
+// Funcs returns the functions available to the formatter templates
+func Funcs() (funcs template.FuncMap) {
+	return template.FuncMap{
+		"json": func(v interface{}) (s string, err error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"path": func(expr string, document interface{}) (value interface{}, err error) {
+			path, err := jsonpath.Compile(expr)
+			if err != nil {
+				return nil, err
+			}
+			value, _ = path.Get(document)
+			return value, nil
+		},
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"trim":  strings.TrimSpace,
+	}
+}
+
+// Init the processor
+func (f *Formatter) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	f.json = config.Get("json").Bool(false)
+
+	text := config.Get("template").String("")
+	if file := config.Get("file").String(""); file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		text = string(data)
+	}
+
+	if text == "" {
+		return errInvalidConfig
+	}
+
+	f.template, err = template.New(pc.NodeName()).Option("missingkey=zero").Funcs(Funcs()).Parse(text)
+	return err
+}
+
+// Process formats and forwards the record
+func (f *Formatter) Process(pc streams.ProcessorContext, record streams.Record) {
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	data := Data{Topic: record.Topic, Key: string(key), Raw: string(value), Time: record.Time}
+	if data.Value, err = jsonpath.Decode(value); err != nil {
+		data.Value = data.Raw
+	}
+
+	var out bytes.Buffer
+	if err = f.template.Execute(&out, data); err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	if f.json {
+		var compact bytes.Buffer
+		if err = json.Compact(&compact, out.Bytes()); err != nil {
+			pc.Error(err, record)
+			return
+		}
+		out = compact
+	}
+
+	record.Value = streams.ByteEncoder(out.Bytes())
+	pc.Forward(record)
+}
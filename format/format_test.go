@@ -0,0 +1,70 @@
+package format
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatter(t *testing.T) {
+	config := streams.NewConfig(nil)
+	config.Set(`{"text": {{json (printf "%s %s is %v" .Topic (upper .Key) .Value.level)}},
+		"user": {{json (path "$.user.name" .Value)}}, "time": {{.Time.Unix}}}`, "stream", "alert", "template")
+	config.Set(true, "stream.alert.json")
+
+	pc := &mock.Context{Data: mock.ContextData{
+		Active:     true,
+		StreamName: "stream",
+		NodeName:   "alert",
+		Config:     config,
+	}}
+
+	f := Supplier()
+	assert.NoError(t, f.(streams.Initializer).Init(pc))
+
+	ts := time.Unix(1000, 0)
+	f.Process(pc, streams.NewRecord("disk", streams.StringEncoder("host1"),
+		streams.StringEncoder(`{"level":"critical","user":{"name":"ops"}}`), ts, nil))
+	f.Process(pc, streams.NewRecord("disk", streams.StringEncoder("host1"),
+		streams.StringEncoder(`not json`), ts, nil))
+
+	assert.Equal(t, 1, pc.Data.ForwardCount)
+	assert.Equal(t, 1, pc.Data.ErrorCount)
+
+	key, _ := pc.Data.Forwarded[0].EncodeKey()
+	value, _ := pc.Data.Forwarded[0].EncodeValue()
+	assert.Equal(t, "host1", string(key))
+	assert.Equal(t, `{"text":"disk HOST1 is critical","user":"ops","time":1000}`, string(value))
+
+	text := streams.NewConfig(nil)
+	text.Set("{{.Raw}} at {{.Time.UTC.Format \"15:04\"}}", "stream.alert.template")
+	pc.Data.Config = text
+
+	f = Supplier()
+	assert.NoError(t, f.(streams.Initializer).Init(pc))
+	f.Process(pc, streams.NewRecord("disk", nil, streams.StringEncoder(`not json`), ts, nil))
+	value, _ = pc.Data.Forwarded[1].EncodeValue()
+	assert.Equal(t, "not json at 00:16", string(value))
+
+	pc.Data.Config = streams.NewConfig(nil)
+	assert.Error(t, Supplier().(streams.Initializer).Init(pc))
+}
@@ -0,0 +1,89 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// fusedProcessor runs a linear chain of stateless processors within a single
+// node invocation. Records forwarded by a processor in the chain are handed
+// directly to the next one, the last processor forwards to the node successors.
+type fusedProcessor struct {
+	names      []string
+	processors []Processor
+}
+
+// Process the record through the fused processor chain
+func (f *fusedProcessor) Process(pc ProcessorContext, record Record) {
+	f.process(0, pc, record)
+}
+
+func (f *fusedProcessor) process(idx int, pc ProcessorContext, record Record) {
+	if idx == len(f.processors)-1 {
+		f.processors[idx].Process(&fusedTailContext{pc, f.names[idx]}, record)
+		return
+	}
+
+	f.processors[idx].Process(&fusedContext{pc, f, idx}, record)
+}
+
+// fusedContext is the context given to the processors within a fused chain
+type fusedContext struct {
+	ProcessorContext
+	fused *fusedProcessor
+	idx   int
+}
+
+// NodeName returns the name of the fused processor.
+func (fc *fusedContext) NodeName() (name string) {
+	return fc.fused.names[fc.idx]
+}
+
+// Forward the record to the next processor in the chain.
+func (fc *fusedContext) Forward(record Record) (err error) {
+	if !fc.IsActive() {
+		return ErrInvalidForward
+	}
+
+	fc.fused.process(fc.idx+1, fc.ProcessorContext, record)
+	return nil
+}
+
+// ForwardTo the next processor in the chain when it is the given node,
+// or to the given node in the stream topology.
+func (fc *fusedContext) ForwardTo(to string, record Record) (err error) {
+	if to == fc.fused.names[fc.idx+1] {
+		return fc.Forward(record)
+	}
+
+	return fc.ProcessorContext.ForwardTo(to, record)
+}
+
+// Broadcast the record to the next processor in the chain, which runs
+// within the same task.
+func (fc *fusedContext) Broadcast(record Record) (err error) {
+	return fc.Forward(record)
+}
+
+// fusedTailContext is the context given to the last processor of a fused
+// chain, which forwards to the node successors.
+type fusedTailContext struct {
+	ProcessorContext
+	name string
+}
+
+// NodeName returns the name of the fused processor.
+func (fc *fusedTailContext) NodeName() (name string) {
+	return fc.name
+}
@@ -1,16 +1,19 @@
 module github.com/brunotm/streams
 
-go 1.12
+go 1.13
 
 require (
 	github.com/couchbase/ghistogram v0.0.1-0.20170308220240-d910dd063dd6 // indirect
 	github.com/couchbase/moss v0.0.0-20190305134348-ea630cf109a3
+	github.com/dgraph-io/badger v1.6.0
 	github.com/dgryski/go-jump v0.0.0-20170409065014-e1f439676b57
 	github.com/dgryski/go-wyhash v0.0.0-20190311210714-ffed7bd65e77
 	github.com/edsrzf/mmap-go v1.0.0 // indirect
+	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db
+	github.com/nats-io/nats.go v1.11.0
 	github.com/spf13/cast v1.3.0
 	github.com/stretchr/objx v0.1.1 // indirect
 	github.com/stretchr/testify v1.3.0
 	github.com/syndtr/goleveldb v1.0.0
-	golang.org/x/sys v0.0.0-20190318195719-6c81ef8f67ca // indirect
+	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68 // indirect
 )
@@ -0,0 +1,105 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"path"
+)
+
+var (
+	// ErrRecordTooLarge is emitted for records exceeding the node record size guard
+	ErrRecordTooLarge = Errorf(CodeRecord, "record too large")
+	// ErrKeyTooLarge is emitted for records exceeding the node key size guard
+	ErrKeyTooLarge = Errorf(CodeRecord, "record key too large")
+	// ErrTopicNotAllowed is emitted for records with topics not allowed by the node guard
+	ErrTopicNotAllowed = Errorf(CodeRecord, "record topic not allowed")
+)
+
+// guard rejects records inbound to a node that exceed the configured record
+// or key sizes, or whose topics don't match any of the allowed glob patterns.
+// It is configured in the <stream>.<node>.guard config subtree with the keys
+// size, key and topics.
+type guard struct {
+	size   int
+	key    int
+	topics []string
+}
+
+// newGuard creates a guard from the config, or returns nil if no guards are set
+func newGuard(config Config) (g *guard, err error) {
+	g = &guard{}
+	g.size = config.Get("size").Int(0)
+	g.key = config.Get("key").Int(0)
+
+	for _, topic := range config.Get("topics").Array() {
+		pattern := topic.String("")
+		if _, err = path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+		g.topics = append(g.topics, pattern)
+	}
+
+	if g.size <= 0 && g.key <= 0 && g.topics == nil {
+		return nil, nil
+	}
+	return g, nil
+}
+
+// check returns a poison error describing the first guard violated by the record
+func (g *guard) check(node string, record Record) (err error) {
+	if g.topics != nil && !g.allowed(record.Topic) {
+		return Classify(&guardError{node: node, err: ErrTopicNotAllowed,
+			detail: fmt.Sprintf("topic %q", record.Topic)}, Poison)
+	}
+
+	key, _ := record.EncodeKey()
+	if g.key > 0 && len(key) > g.key {
+		return Classify(&guardError{node: node, err: ErrKeyTooLarge,
+			detail: fmt.Sprintf("%d bytes exceeds %d", len(key), g.key)}, Poison)
+	}
+
+	if g.size > 0 {
+		value, _ := record.EncodeValue()
+		if size := len(record.Topic) + len(key) + len(value); size > g.size {
+			return Classify(&guardError{node: node, err: ErrRecordTooLarge,
+				detail: fmt.Sprintf("%d bytes exceeds %d", size, g.size)}, Poison)
+		}
+	}
+
+	return nil
+}
+
+// allowed returns if the topic matches any of the allowed patterns
+func (g *guard) allowed(topic string) (ok bool) {
+	for _, pattern := range g.topics {
+		if ok, _ = path.Match(pattern, topic); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// guardError describes a guard violation
+type guardError struct {
+	node   string
+	detail string
+	err    error
+}
+
+func (e *guardError) Error() string { return fmt.Sprintf("%s: %s: %s", e.node, e.err, e.detail) }
+func (e *guardError) Unwrap() error { return e.err }
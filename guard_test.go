@@ -0,0 +1,88 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuard(t *testing.T) {
+	var mtx sync.Mutex
+	var processed []string
+	var errs []error
+	var wg sync.WaitGroup
+	wg.Add(5)
+
+	config := NewConfig(nil)
+	config.Set(12, "stream.sink.guard.size")
+	config.Set(3, "stream.sink.guard.key")
+	config.Set([]interface{}{"orders.*", "users"}, "stream.sink.guard.topics")
+
+	now := time.Now()
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{records: []Record{
+			NewRecord("orders.eu", StringEncoder("k"), StringEncoder("v"), now, nil),
+			NewRecord("payments", StringEncoder("k"), StringEncoder("v"), now, nil),
+			NewRecord("users", StringEncoder("long"), StringEncoder("v"), now, nil),
+			NewRecord("users", StringEncoder("k"), StringEncoder("large value"), now, nil),
+			NewRecord("users", StringEncoder("k"), StringEncoder("value"), now, nil),
+		}}
+	}))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		defer wg.Done()
+		key, _ := record.EncodeKey()
+		mtx.Lock()
+		processed = append(processed, record.Topic+":"+string(key))
+		mtx.Unlock()
+	}, "source"))
+
+	b.ErrorHandler(ErrorHandlerFunc(func(e Error) {
+		defer wg.Done()
+		assert.Equal(t, Poison, e.Category)
+		assert.Len(t, e.Record, 1)
+		mtx.Lock()
+		errs = append(errs, e.Error)
+		mtx.Unlock()
+	}))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+	assert.NoError(t, stream.Close())
+
+	assert.Equal(t, []string{"orders.eu:k", "users:k"}, processed)
+	assert.Len(t, errs, 3)
+	for x, expected := range []error{ErrTopicNotAllowed, ErrKeyTooLarge, ErrRecordTooLarge} {
+		assert.Equal(t, expected, errs[x].(interface{ Unwrap() error }).Unwrap().(*guardError).err)
+	}
+	assert.EqualError(t, errs[2], "sink: record too large: 17 bytes exceeds 12")
+}
+
+func TestGuardInvalidTopic(t *testing.T) {
+	_, err := newGuard(NewConfig(map[string]interface{}{"topics": []interface{}{"["}}))
+	assert.Error(t, err)
+
+	g, err := newGuard(NewConfig(nil))
+	assert.NoError(t, err)
+	assert.Nil(t, g)
+}
@@ -0,0 +1,137 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/dgryski/go-jump"
+)
+
+// handoff cooperatively moves the keys reassigned to other tasks by a rescale.
+// Records of a moved key are held until the task previously assigned to it has
+// processed all records pushed to it before the rescale, and are then pushed
+// in order to the new task, preserving the per key ordering. Records of the
+// keys not moved by the rescale are delivered as usual.
+type handoff struct {
+	held      int64
+	released  int64
+	mtx       sync.Mutex
+	prev      int
+	drained   []bool
+	pending   [][]Record
+	remaining int
+	done      chan struct{}
+}
+
+// newHandoff creates the handoff of the keys of the given task contexts
+// for a rescale, or returns nil if there are no records to drain.
+// Must be called with the tasks lock held, before the tasks are rescaled.
+func newHandoff(contexts []*processorContext, scale int) (h *handoff) {
+	if len(contexts) == 0 || scale == 0 {
+		return nil
+	}
+
+	h = &handoff{}
+	h.prev = len(contexts)
+	h.drained = make([]bool, len(contexts))
+	h.pending = make([][]Record, len(contexts))
+	h.done = make(chan struct{})
+
+	for idx, pc := range contexts {
+		target := atomic.LoadInt64(&pc.pushed)
+		atomic.StoreInt64(&pc.drain, target)
+
+		// The task may have drained before the target was set
+		if atomic.LoadInt64(&pc.processed) >= target &&
+			atomic.CompareAndSwapInt64(&pc.drain, target, 0) {
+			h.drained[idx] = true
+			continue
+		}
+		h.remaining++
+	}
+
+	if h.remaining == 0 {
+		return nil
+	}
+	return h
+}
+
+// hold the record if its key moved from a task which is not yet drained.
+// Must be called with the tasks read lock held.
+func (h *handoff) hold(idx int32, record Record) (held bool) {
+	select {
+	case <-h.done:
+		return false
+	default:
+	}
+
+	prev := jump.Hash(record.id, h.prev)
+	if prev == idx {
+		return false
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if h.drained[prev] {
+		return false
+	}
+
+	h.pending[prev] = append(h.pending[prev], record)
+	atomic.AddInt64(&h.held, 1)
+	return true
+}
+
+// drained counts a record processed by the task, and completes the task
+// handoff once it has processed all the records pushed before the rescale
+func (pc *processorContext) drained() {
+	processed := atomic.AddInt64(&pc.processed, 1)
+
+	if target := atomic.LoadInt64(&pc.drain); target > 0 && processed >= target &&
+		atomic.CompareAndSwapInt64(&pc.drain, target, 0) {
+		// The tasks lock may be held by a delivery blocked on this task
+		go pc.node.tasks.drained(pc.task)
+	}
+}
+
+// drained pushes the records held for the keys moved from the task
+// with the given index to their new tasks
+func (t *tasks) drained(idx int) {
+	t.RLock()
+	defer t.RUnlock()
+
+	h := t.handoff
+	if h == nil {
+		return
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	h.drained[idx] = true
+	for _, record := range h.pending[idx] {
+		t.push(jump.Hash(record.id, len(t.buffers)), record)
+		atomic.AddInt64(&h.released, 1)
+	}
+	h.pending[idx] = nil
+
+	if h.remaining--; h.remaining == 0 {
+		close(h.done)
+	}
+}
@@ -0,0 +1,90 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"time"
+)
+
+// Idempotent returns a ProcessorMiddleware skipping records already processed
+// by the node, for sinks without native idempotence receiving records delivered
+// again by at least once sources. Records are identified by a hash of their
+// topic, key and value, and marked as processed per node in the named store
+// once processed without errors or panics. Marks expire after the given ttl if
+// the store is a TTLStore, and are kept otherwise.
+func Idempotent(store string, ttl time.Duration) (middleware ProcessorMiddleware) {
+	return func(next ProcessorFunc) ProcessorFunc {
+		return func(pc ProcessorContext, record Record) {
+			st, err := pc.Store(store)
+			if err != nil {
+				pc.Error(err, record)
+				next(pc, record)
+				return
+			}
+
+			key := append([]byte(pc.NodeName()+"/"), recordID(record)...)
+			_, err = st.Get(key)
+			switch err {
+			case nil:
+				return
+			case ErrKeyNotFound:
+			default:
+				pc.Error(err, record)
+			}
+
+			ic := &idempotentContext{ProcessorContext: pc}
+			ic.process(next, record)
+			if ic.failed {
+				return
+			}
+
+			if ts, ok := st.(TTLStore); ok && ttl > 0 {
+				err = ts.SetWithTTL(key, []byte{1}, ttl)
+			} else {
+				err = st.Set(key, []byte{1})
+			}
+
+			if err != nil {
+				pc.Error(err, record)
+			}
+		}
+	}
+}
+
+// idempotentContext records if errors were emitted while processing a record
+type idempotentContext struct {
+	ProcessorContext
+	failed bool
+}
+
+// Error emits a error event to be handled by the Stream.
+func (ic *idempotentContext) Error(err error, records ...Record) {
+	ic.failed = true
+	ic.ProcessorContext.Error(err, records...)
+}
+
+// process the record, re-panicking after marking the record as failed
+func (ic *idempotentContext) process(next ProcessorFunc, record Record) {
+	defer func() {
+		if r := recover(); r != nil {
+			ic.failed = true
+			panic(fmt.Sprint(r))
+		}
+	}()
+	next(ic, record)
+}
@@ -0,0 +1,100 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ttlMemStore is a memStore recording the ttl of its keys
+type ttlMemStore struct {
+	memStore
+	ttls map[string]time.Duration
+}
+
+func (m *ttlMemStore) SetWithTTL(key, value []byte, ttl time.Duration) error {
+	m.mtx.Lock()
+	m.ttls[string(key)] = ttl
+	m.mtx.Unlock()
+	return m.Set(key, value)
+}
+
+func (m *ttlMemStore) Expire(now time.Time, callback func(key, value []byte) error) error {
+	return nil
+}
+
+func TestIdempotent(t *testing.T) {
+	var mtx sync.Mutex
+	var written []string
+	var errs []Error
+	var wg sync.WaitGroup
+	wg.Add(6)
+
+	store := &ttlMemStore{memStore: memStore{data: make(map[string][]byte)}, ttls: make(map[string]time.Duration)}
+	failed := false
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddStore("processed", func() Store { return store }))
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: redeliveredRecords("a", "b", "a", "b", "c", "a")}
+	}))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		key, _ := record.EncodeKey()
+		mtx.Lock()
+		fail := string(key) == "b" && !failed
+		failed = failed || fail
+		if !fail {
+			written = append(written, string(key))
+		}
+		mtx.Unlock()
+
+		// the first write of b fails and is processed again when redelivered
+		if fail {
+			pc.Error(errors.New("write failed"), record)
+		}
+	}, "source"))
+
+	b.UseFor("sink", func(next ProcessorFunc) ProcessorFunc {
+		return func(pc ProcessorContext, record Record) {
+			defer wg.Done()
+			next(pc, record)
+		}
+	}, Idempotent("processed", time.Hour))
+	b.ErrorHandler(ErrorHandlerFunc(func(e Error) {
+		mtx.Lock()
+		errs = append(errs, e)
+		mtx.Unlock()
+	}))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+	assert.NoError(t, stream.Close())
+
+	assert.Equal(t, []string{"a", "b", "c"}, written)
+	assert.Len(t, errs, 1)
+	assert.Len(t, store.ttls, 3)
+	for _, ttl := range store.ttls {
+		assert.Equal(t, time.Hour, ttl)
+	}
+}
@@ -0,0 +1,176 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brunotm/streams/types"
+)
+
+// InFlight reports the records in flight in a stream, telling apart a stuck
+// stream, with tasks processing the same record for long or an old
+// unacknowledged record, from a slow one, with queues steadily draining.
+// Durations are encoded as nanoseconds in json.
+type InFlight struct {
+	// Records is the number of source records forwarded and not yet
+	// acknowledged. Only records created with an ack function are tracked,
+	// and records sharing a Batch are tracked once.
+	Records int `json:"records"`
+	// Oldest is the age of the oldest unacknowledged source record
+	Oldest time.Duration `json:"oldest"`
+	// Tasks are the queues and current processing of the node tasks,
+	// in topology and task order
+	Tasks []TaskInFlight `json:"tasks"`
+}
+
+// TaskInFlight summarizes the queue contents and current processing of a node task
+type TaskInFlight struct {
+	Node       string        `json:"node"`
+	Task       int           `json:"task"`
+	Queued     int64         `json:"queued"`     // records pushed to the task not yet processed
+	Buffered   int           `json:"buffered"`   // records in the task buffer
+	Capacity   int           `json:"capacity"`   // capacity of the task buffer
+	Priority   int           `json:"priority"`   // records in the task priority queue
+	Fair       int           `json:"fair"`       // records in the task fair queue
+	Spilled    int           `json:"spilled"`    // records spilled to the task overflow
+	Processing time.Duration `json:"processing"` // time processing the current record, zero if idle
+}
+
+// inflight tracks the unacknowledged source records by their acknowledgment
+type inflight struct {
+	mtx     sync.Mutex
+	records map[*acker]time.Time
+}
+
+// track the record acknowledgment forwarded by a source at the given time,
+// once for acknowledgments shared by records
+func (f *inflight) track(a *acker, now time.Time) {
+	if a == nil || a.tracker.Load() != nil {
+		return
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.records == nil {
+		f.records = make(map[*acker]time.Time)
+	}
+	if _, exists := f.records[a]; !exists {
+		f.records[a] = now
+		a.tracker.Store(f)
+	}
+}
+
+// untrack the acknowledged record
+func (f *inflight) untrack(a *acker) {
+	f.mtx.Lock()
+	delete(f.records, a)
+	f.mtx.Unlock()
+}
+
+// oldest returns the number of tracked records and the age of the oldest
+func (f *inflight) oldest(now time.Time) (count int, age time.Duration) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	for _, since := range f.records {
+		if d := now.Sub(since); d > age {
+			age = d
+		}
+	}
+	return len(f.records), age
+}
+
+// inFlight returns the queues and current processing of the tasks
+func (t *tasks) inFlight(now time.Time) (tasks []TaskInFlight) {
+	t.RLock()
+	defer t.RUnlock()
+
+	for x := range t.buffers {
+		pc := t.contexts[x]
+		task := TaskInFlight{
+			Node:     t.node.name,
+			Task:     x,
+			Queued:   atomic.LoadInt64(&pc.pushed) - atomic.LoadInt64(&pc.processed),
+			Buffered: len(t.buffers[x]),
+			Capacity: cap(t.buffers[x]),
+			Priority: t.queues[x].len(),
+		}
+
+		if t.fairs[x] != nil {
+			task.Fair = t.fairs[x].len()
+		}
+		if t.overflows[x] != nil {
+			task.Spilled = t.overflows[x].len()
+		}
+		if started := atomic.LoadInt64(&pc.started); started > 0 {
+			task.Processing = now.Sub(time.Unix(0, started))
+		}
+
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// InFlight returns the records in flight in the stream
+func (s *Stream) InFlight() (f InFlight) {
+	now := time.Now()
+	f.Records, f.Oldest = s.inflight.oldest(now)
+
+	for _, node := range s.topology.nodes {
+		if node.typ != types.Source && node.tasks != nil {
+			f.Tasks = append(f.Tasks, node.tasks.inFlight(now)...)
+		}
+	}
+	return f
+}
+
+// InFlightHandler returns a http handler replying with the records in flight
+// in the stream as json, with the tasks of the node given by the node query
+// parameter if set.
+func InFlightHandler(s *Stream) (handler http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		f := s.InFlight()
+		if node := r.URL.Query().Get("node"); node != "" {
+			if s.topology.getNode(node) == nil {
+				http.Error(w, ErrNodeNotFound.Error(), http.StatusNotFound)
+				return
+			}
+
+			var tasks []TaskInFlight
+			for _, task := range f.Tasks {
+				if task.Node == node {
+					tasks = append(tasks, task)
+				}
+			}
+			f.Tasks = tasks
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(f)
+	})
+}
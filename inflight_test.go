@@ -0,0 +1,97 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamInFlight(t *testing.T) {
+	var acked sync.WaitGroup
+	acked.Add(3)
+
+	source := &testSource{}
+	for x := 0; x < 3; x++ {
+		source.records = append(source.records, NewRecord("", StringEncoder("k"), StringEncoder("v"), time.Now(),
+			func() error { acked.Done(); return nil }))
+	}
+
+	config := NewConfig(nil)
+	config.Set(1, "stream.sink.tasks.count")
+	config.Set(4, "stream.sink.tasks.buffer")
+
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source { return source }))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		started <- struct{}{}
+		<-release
+	}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	<-started
+
+	// the task is stuck processing the first record
+	f := stream.InFlight()
+	for x := 0; x < 1000 && (len(f.Tasks) == 0 || f.Tasks[0].Queued < 3); x++ {
+		time.Sleep(time.Millisecond)
+		f = stream.InFlight()
+	}
+	assert.Equal(t, 3, f.Records)
+	assert.True(t, f.Oldest > 0)
+	assert.Len(t, f.Tasks, 1)
+	assert.Equal(t, "sink", f.Tasks[0].Node)
+	assert.Equal(t, int64(3), f.Tasks[0].Queued)
+	assert.Equal(t, 2, f.Tasks[0].Buffered)
+	assert.Equal(t, 4, f.Tasks[0].Capacity)
+	assert.True(t, f.Tasks[0].Processing > 0)
+
+	server := httptest.NewServer(InFlightHandler(stream))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?node=sink")
+	assert.NoError(t, err)
+	var reply InFlight
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+	resp.Body.Close()
+	assert.Equal(t, 3, reply.Records)
+	assert.Len(t, reply.Tasks, 1)
+
+	resp, err = http.Get(server.URL + "?node=none")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	close(release)
+	acked.Wait()
+
+	f = stream.InFlight()
+	assert.Equal(t, 0, f.Records)
+	assert.Equal(t, time.Duration(0), f.Oldest)
+	assert.NoError(t, stream.Close())
+}
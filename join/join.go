@@ -0,0 +1,156 @@
+package join
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+const (
+	// DefaultWindow is the default join window
+	DefaultWindow = time.Minute
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*WindowTable)(nil)
+var _ streams.Processor = (*WindowTable)(nil)
+
+// ValueJoiner joins the values of the left and right records
+type ValueJoiner func(left, right streams.Encoder) (joined streams.Encoder)
+
+// WindowTable joins the records of a left stream with the latest record of the
+// same key from a right stream within the join window, falling back to the
+// value of the key in a table store on a window miss, for enrichment with
+// late-arriving reference data.
+// Records with the right topic are the right stream, any other is the left stream.
+// Joined records keep the left record key, time and acknowledgment.
+// Left records without a match are dropped, or forwarded unjoined with the
+// <stream>.<node>.outer config. The window can be set with <stream>.<node>.window.
+type WindowTable struct {
+	right   string
+	table   string
+	window  time.Duration
+	outer   bool
+	joiner  ValueJoiner
+	store   streams.Store
+	windows map[string][]streams.Record
+	swept   time.Time
+}
+
+// WindowTableSupplier for a WindowTable join of the right topic records within
+// the given window, falling back to the given table store.
+func WindowTableSupplier(right string, window time.Duration, table string, joiner ValueJoiner) streams.ProcessorSupplier {
+	return func() (processor streams.Processor) {
+		return &WindowTable{right: right, window: window, table: table, joiner: joiner}
+	}
+}
+
+// Init the join
+func (w *WindowTable) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	w.window = config.Get("window").Duration(w.window)
+	w.outer = config.Get("outer").Bool(false)
+
+	if w.window <= 0 {
+		w.window = DefaultWindow
+	}
+
+	if w.store, err = pc.Store(w.table); err != nil {
+		return err
+	}
+
+	w.windows = make(map[string][]streams.Record)
+	return nil
+}
+
+// Process joins the left records and buffers the right records within the window
+func (w *WindowTable) Process(pc streams.ProcessorContext, record streams.Record) {
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	w.sweep(record.Time)
+
+	if record.Topic == w.right {
+		w.windows[string(key)] = append(w.windows[string(key)], record)
+		return
+	}
+
+	if right, ok := w.match(string(key), record.Time); ok {
+		record.Value = w.joiner(record.Value, right.Value)
+		pc.Forward(record)
+		return
+	}
+
+	value, err := w.store.Get(key)
+	switch err {
+	case nil:
+		record.Value = w.joiner(record.Value, streams.ByteEncoder(value))
+		pc.Forward(record)
+	case streams.ErrKeyNotFound:
+		if w.outer {
+			pc.Forward(record)
+		}
+	default:
+		pc.Error(err, record)
+	}
+}
+
+// match returns the latest right record for the key within the window of the given time
+func (w *WindowTable) match(key string, ts time.Time) (right streams.Record, ok bool) {
+	records := w.windows[key]
+	for x := len(records) - 1; x >= 0; x-- {
+		if within(records[x].Time, ts, w.window) {
+			return records[x], true
+		}
+	}
+	return right, false
+}
+
+// sweep evicts the right records outside the window once per window
+func (w *WindowTable) sweep(now time.Time) {
+	if now.Sub(w.swept) < w.window {
+		return
+	}
+	w.swept = now
+
+	for key, records := range w.windows {
+		var x int
+		for x < len(records) && records[x].Time.Before(now.Add(-w.window)) {
+			x++
+		}
+
+		if x == len(records) {
+			delete(w.windows, key)
+			continue
+		}
+		w.windows[key] = records[x:]
+	}
+}
+
+// within returns if the times are at most window apart
+func within(a, b time.Time, window time.Duration) (ok bool) {
+	d := a.Sub(b)
+	if d < 0 {
+		d = -d
+	}
+	return d <= window
+}
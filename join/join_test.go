@@ -0,0 +1,128 @@
+package join
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/brunotm/streams/store/sharded"
+	"github.com/stretchr/testify/assert"
+)
+
+func concat(left, right streams.Encoder) streams.Encoder {
+	l, _ := left.Encode()
+	r, _ := right.Encode()
+	return streams.StringEncoder(string(l) + "+" + string(r))
+}
+
+func TestWindowTable(t *testing.T) {
+	table := sharded.Supplier()
+	pc := &mock.Context{Data: mock.ContextData{Active: true, Store: table}}
+	assert.NoError(t, table.(streams.Initializer).Init(pc))
+	assert.NoError(t, table.Set([]byte("b"), []byte("table-b")))
+
+	join := WindowTableSupplier("right", time.Minute, "table", concat)()
+	assert.NoError(t, join.(streams.Initializer).Init(pc))
+
+	now := time.Now()
+	record := func(topic, key, value string, ts time.Time) streams.Record {
+		return streams.NewRecord(topic, streams.StringEncoder(key), streams.StringEncoder(value), ts, nil)
+	}
+
+	join.Process(pc, record("right", "a", "right-a0", now.Add(-2*time.Minute)))
+	join.Process(pc, record("right", "a", "right-a1", now))
+
+	// window match with the latest right record
+	join.Process(pc, record("left", "a", "left-a", now))
+	// table fallback
+	join.Process(pc, record("left", "b", "left-b", now))
+	// no match
+	join.Process(pc, record("left", "c", "left-c", now))
+	// out of window falls back to the table
+	join.Process(pc, record("left", "a", "late-a", now.Add(2*time.Minute)))
+
+	var values []string
+	for _, record := range pc.Data.Forwarded {
+		v, _ := record.EncodeValue()
+		values = append(values, string(v))
+	}
+	assert.Equal(t, []string{"left-a+right-a1", "left-b+table-b"}, values)
+}
+
+func TestStream(t *testing.T) {
+	store := sharded.Supplier()
+	pc := &mock.Context{Data: mock.ContextData{Active: true, Store: store}}
+	assert.NoError(t, store.(streams.Initializer).Init(pc))
+
+	join := StreamSupplier("right", time.Minute, "buffer", concat)()
+	assert.NoError(t, join.(streams.Initializer).Init(pc))
+
+	now := time.Now()
+	record := func(topic, key, value string, ts time.Time) streams.Record {
+		return streams.NewRecord(topic, streams.StringEncoder(key), streams.StringEncoder(value), ts, nil)
+	}
+
+	join.Process(pc, record("left", "a", "left-a0", now))
+	join.Process(pc, record("right", "a", "right-a0", now.Add(30*time.Second)))
+	join.Process(pc, record("right", "b", "right-b0", now.Add(30*time.Second)))
+	join.Process(pc, record("left", "a", "left-a1", now.Add(40*time.Second)))
+	// outside the window of left-a0
+	join.Process(pc, record("right", "a", "right-a1", now.Add(90*time.Second)))
+
+	var values []string
+	for _, record := range pc.Data.Forwarded {
+		v, _ := record.EncodeValue()
+		values = append(values, string(v))
+	}
+	assert.Equal(t, []string{"left-a0+right-a0", "left-a1+right-a0", "left-a1+right-a1"}, values)
+	assert.Equal(t, now.Add(40*time.Second), pc.Data.Forwarded[1].Time)
+
+	// records out of the window are swept from the store
+	join.Process(pc, record("left", "c", "left-c", now.Add(10*time.Minute)))
+	var buffered int
+	assert.NoError(t, store.Range(nil, nil, func(k, v []byte) error {
+		buffered++
+		return nil
+	}))
+	assert.Equal(t, 1, buffered)
+}
+
+func TestTable(t *testing.T) {
+	table := sharded.Supplier()
+	config := streams.NewConfig(nil)
+	config.Set(true, "stream.join.outer")
+	pc := &mock.Context{Data: mock.ContextData{Active: true, StreamName: "stream", NodeName: "join", Config: config, Store: table}}
+	assert.NoError(t, table.(streams.Initializer).Init(pc))
+	assert.NoError(t, table.Set([]byte("a"), []byte("table-a")))
+
+	join := TableSupplier("table", concat)()
+	assert.NoError(t, join.(streams.Initializer).Init(pc))
+
+	now := time.Now()
+	join.Process(pc, streams.NewRecord("left", streams.StringEncoder("a"), streams.StringEncoder("left-a"), now, nil))
+	join.Process(pc, streams.NewRecord("left", streams.StringEncoder("b"), streams.StringEncoder("left-b"), now, nil))
+
+	var values []string
+	for _, record := range pc.Data.Forwarded {
+		v, _ := record.EncodeValue()
+		values = append(values, string(v))
+	}
+	assert.Equal(t, []string{"left-a+table-a", "left-b"}, values)
+}
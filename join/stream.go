@@ -0,0 +1,260 @@
+package join
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+// Join sides of the buffered records
+const (
+	sideLeft  byte = 'l'
+	sideRight byte = 'r'
+)
+
+var (
+	errInvalidKey = streams.Errorf(streams.CodeRecord, "invalid join key")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Stream)(nil)
+var _ streams.Processor = (*Stream)(nil)
+var _ streams.Initializer = (*Table)(nil)
+var _ streams.Processor = (*Table)(nil)
+
+// Stream joins the records of a left and right stream with the same key and
+// times at most the join window apart, buffering the records of both sides in
+// a store until the stream time, the highest record time seen, passes their
+// time plus the window. Each record is joined with all the buffered records of
+// the other side within the window, so every matching pair is forwarded once.
+// Records with the right topic are the right stream, any other is the left stream.
+// Joined records have the key of both records and the latest of their times.
+// The window can be set with <stream>.<node>.window.
+type Stream struct {
+	right      string
+	name       string
+	window     time.Duration
+	joiner     ValueJoiner
+	store      streams.Store
+	seq        uint64
+	streamTime time.Time
+	swept      time.Time
+}
+
+// StreamSupplier for a Stream join of the right topic records with the left
+// records within the given window, buffered in the named store.
+func StreamSupplier(right string, window time.Duration, store string, joiner ValueJoiner) streams.ProcessorSupplier {
+	return func() (processor streams.Processor) {
+		return &Stream{right: right, window: window, name: store, joiner: joiner}
+	}
+}
+
+// Init the join
+func (s *Stream) Init(pc streams.ProcessorContext) (err error) {
+	s.window = pc.Config().Get(pc.StreamName(), pc.NodeName(), "window").Duration(s.window)
+	if s.window <= 0 {
+		s.window = DefaultWindow
+	}
+
+	s.store, err = pc.Store(s.name)
+	return err
+}
+
+// Process buffers the record and joins it with the other side records within the window
+func (s *Stream) Process(pc streams.ProcessorContext, record streams.Record) {
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	if record.Time.After(s.streamTime) {
+		s.streamTime = record.Time
+	}
+
+	if err = s.sweep(); err != nil {
+		pc.Error(err)
+	}
+
+	// Records already out of the window of the stream time can't be joined
+	if record.Time.Add(s.window).Before(s.streamTime) {
+		return
+	}
+
+	side, other := sideLeft, sideRight
+	if record.Topic == s.right {
+		side, other = sideRight, sideLeft
+	}
+
+	s.seq++
+	if err = s.store.Set(encodeKey(side, key, record.Time, s.seq), value); err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	from := encodeKey(other, key, record.Time.Add(-s.window), 0)
+	to := encodeKey(other, key, record.Time.Add(s.window+time.Nanosecond), 0)
+
+	var joined []streams.Record
+	err = s.store.Range(from, to, func(k, v []byte) error {
+		_, _, ts, err := decodeKey(k)
+		if err != nil {
+			return err
+		}
+
+		left, right := record.Value, streams.Encoder(streams.ByteEncoder(append([]byte(nil), v...)))
+		if side == sideRight {
+			left, right = right, left
+		}
+
+		result := record
+		result.Value = s.joiner(left, right)
+		if ts.After(result.Time) {
+			result.Time = ts
+		}
+		joined = append(joined, result)
+		return nil
+	})
+
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	for _, result := range joined {
+		pc.Forward(result)
+	}
+}
+
+// sweep deletes the buffered records out of the window of the stream time, once per window
+func (s *Stream) sweep() (err error) {
+	if s.streamTime.Sub(s.swept) < s.window {
+		return nil
+	}
+	s.swept = s.streamTime
+	deadline := s.streamTime.Add(-s.window)
+
+	var expired [][]byte
+	for _, side := range []byte{sideLeft, sideRight} {
+		err = s.store.RangePrefix([]byte{side}, func(k, v []byte) error {
+			_, _, ts, err := decodeKey(k)
+			if err != nil {
+				return err
+			}
+
+			if ts.Before(deadline) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, k := range expired {
+		if err = s.store.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeKey encodes the buffered record key as the side, key length, key, time
+// with the sign bit flipped to sort negative times first, and sequence
+func encodeKey(side byte, key []byte, ts time.Time, seq uint64) (jkey []byte) {
+	jkey = make([]byte, 1+4+len(key)+16)
+	jkey[0] = side
+	binary.BigEndian.PutUint32(jkey[1:], uint32(len(key)))
+	copy(jkey[5:], key)
+	binary.BigEndian.PutUint64(jkey[5+len(key):], uint64(ts.UnixNano())^(1<<63))
+	binary.BigEndian.PutUint64(jkey[13+len(key):], seq)
+	return jkey
+}
+
+// decodeKey decodes the side, key and time of the buffered record key
+func decodeKey(jkey []byte) (side byte, key []byte, ts time.Time, err error) {
+	if len(jkey) < 21 {
+		return 0, nil, ts, errInvalidKey
+	}
+
+	size := int(binary.BigEndian.Uint32(jkey[1:]))
+	if len(jkey) != 21+size {
+		return 0, nil, ts, errInvalidKey
+	}
+
+	nanos := int64(binary.BigEndian.Uint64(jkey[5+size:]) ^ (1 << 63))
+	return jkey[0], jkey[5 : 5+size], time.Unix(0, nanos), nil
+}
+
+// Table joins the records of a stream with the latest value of their key in
+// a table store, e.g. maintained by a table.Table processor.
+// Joined records keep the record key, time and acknowledgment.
+// Records without a match are dropped, or forwarded unjoined with the
+// <stream>.<node>.outer config.
+type Table struct {
+	table  string
+	outer  bool
+	joiner ValueJoiner
+	store  streams.Store
+}
+
+// TableSupplier for a Table join with the named table store
+func TableSupplier(table string, joiner ValueJoiner) streams.ProcessorSupplier {
+	return func() (processor streams.Processor) {
+		return &Table{table: table, joiner: joiner}
+	}
+}
+
+// Init the join
+func (t *Table) Init(pc streams.ProcessorContext) (err error) {
+	t.outer = pc.Config().Get(pc.StreamName(), pc.NodeName(), "outer").Bool(false)
+	t.store, err = pc.Store(t.table)
+	return err
+}
+
+// Process joins the record with the table value of its key
+func (t *Table) Process(pc streams.ProcessorContext, record streams.Record) {
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	value, err := t.store.Get(key)
+	switch err {
+	case nil:
+		record.Value = t.joiner(record.Value, streams.ByteEncoder(value))
+		pc.Forward(record)
+	case streams.ErrKeyNotFound:
+		if t.outer {
+			pc.Forward(record)
+		}
+	default:
+		pc.Error(err, record)
+	}
+}
@@ -0,0 +1,230 @@
+package jsonfield
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/brunotm/streams"
+)
+
+const (
+	// DefaultMask is the default value of masked fields
+	DefaultMask = "***"
+	// DefaultSeparator is the default separator of field paths
+	DefaultSeparator = "."
+)
+
+var (
+	errNotObject    = streams.Errorf(streams.CodeSerialization, "record value is not a json object")
+	errKeyNotScalar = streams.Errorf(streams.CodeRecord, "key field is not a scalar value")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Fields)(nil)
+var _ streams.Processor = (*Fields)(nil)
+
+// Fields transforms the fields of json object record values, configured
+// through the <stream>.<node> config subtree with the following keys:
+//
+//	key:       field path extracted as the new record key
+//	rename:    map of field paths to their new paths
+//	drop:      array of field paths to remove
+//	mask:      array of field paths whose values are masked
+//	maskwith:  value of masked fields, defaults to DefaultMask
+//	flatten:   flatten nested objects into top level fields
+//	separator: separator of field paths and flattened fields, defaults to DefaultSeparator
+//
+// Field paths address nested objects by joining their field names with the
+// separator. Transformations are applied in the order above, with field paths
+// referring to the fields before flattening. Records whose values are not json
+// objects are reported as errors and dropped.
+type Fields struct {
+	key       []string
+	rename    map[string][]string
+	drop      [][]string
+	mask      [][]string
+	maskWith  string
+	flatten   bool
+	separator string
+}
+
+// Supplier for the json field processor
+func Supplier() (processor streams.Processor) {
+	return &Fields{}
+}
+
+// Init the processor with its configuration
+func (f *Fields) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	f.separator = config.Get("separator").String(DefaultSeparator)
+	f.maskWith = config.Get("maskwith").String(DefaultMask)
+	f.flatten = config.Get("flatten").Bool(false)
+
+	if key := config.Get("key").String(""); key != "" {
+		f.key = f.path(key)
+	}
+
+	f.rename = make(map[string][]string)
+	for from, to := range config.Get("rename").Map() {
+		f.rename[from] = f.path(to.String(from))
+	}
+
+	for _, field := range config.Get("drop").Array() {
+		f.drop = append(f.drop, f.path(field.String("")))
+	}
+
+	for _, field := range config.Get("mask").Array() {
+		f.mask = append(f.mask, f.path(field.String("")))
+	}
+
+	return nil
+}
+
+// Process transforms the record value fields and forwards the record
+func (f *Fields) Process(pc streams.ProcessorContext, record streams.Record) {
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	var object map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	if err = decoder.Decode(&object); err != nil || object == nil {
+		pc.Error(errNotObject, record)
+		return
+	}
+
+	if f.key != nil {
+		if field, ok := get(object, f.key); ok {
+			key, err := scalar(field)
+			if err != nil {
+				pc.Error(err, record)
+				return
+			}
+			record.Key = streams.StringEncoder(key)
+		}
+	}
+
+	for from, to := range f.rename {
+		if field, ok := remove(object, f.path(from)); ok {
+			put(object, to, field)
+		}
+	}
+
+	for _, path := range f.drop {
+		remove(object, path)
+	}
+
+	for _, path := range f.mask {
+		if _, ok := get(object, path); ok {
+			put(object, path, f.maskWith)
+		}
+	}
+
+	if f.flatten {
+		flat := make(map[string]interface{})
+		f.flat(flat, "", object)
+		object = flat
+	}
+
+	if value, err = json.Marshal(object); err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	record.Value = streams.ByteEncoder(value)
+	pc.Forward(record)
+}
+
+// path splits the field path with the separator
+func (f *Fields) path(field string) (path []string) {
+	return strings.Split(field, f.separator)
+}
+
+// flat adds the fields of the object to the flat object prefixing their names
+func (f *Fields) flat(flat map[string]interface{}, prefix string, object map[string]interface{}) {
+	for name, field := range object {
+		if prefix != "" {
+			name = prefix + f.separator + name
+		}
+
+		if nested, ok := field.(map[string]interface{}); ok && len(nested) > 0 {
+			f.flat(flat, name, nested)
+			continue
+		}
+		flat[name] = field
+	}
+}
+
+// get the field at the path
+func get(object map[string]interface{}, path []string) (field interface{}, ok bool) {
+	for x := 0; x < len(path)-1; x++ {
+		if object, ok = object[path[x]].(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+
+	field, ok = object[path[len(path)-1]]
+	return field, ok
+}
+
+// put the field at the path creating any needed nested object
+func put(object map[string]interface{}, path []string, field interface{}) {
+	for x := 0; x < len(path)-1; x++ {
+		nested, ok := object[path[x]].(map[string]interface{})
+		if !ok {
+			nested = make(map[string]interface{})
+			object[path[x]] = nested
+		}
+		object = nested
+	}
+
+	object[path[len(path)-1]] = field
+}
+
+// remove the field at the path
+func remove(object map[string]interface{}, path []string) (field interface{}, ok bool) {
+	for x := 0; x < len(path)-1; x++ {
+		if object, ok = object[path[x]].(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+
+	if field, ok = object[path[len(path)-1]]; ok {
+		delete(object, path[len(path)-1])
+	}
+	return field, ok
+}
+
+// scalar returns the string form of a scalar json value
+func scalar(field interface{}) (value string, err error) {
+	switch v := field.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", errKeyNotScalar
+}
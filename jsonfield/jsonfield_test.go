@@ -0,0 +1,62 @@
+package jsonfield
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFields(t *testing.T) {
+	config := streams.NewConfig(nil)
+	config.Set("user.id", "stream.fields.key")
+	config.Set(map[string]interface{}{"user.name": "name"}, "stream", "fields", "rename")
+	config.Set([]interface{}{"debug"}, "stream", "fields", "drop")
+	config.Set([]interface{}{"user.card"}, "stream", "fields", "mask")
+	config.Set(true, "stream.fields.flatten")
+
+	pc := &mock.Context{Data: mock.ContextData{
+		Active:     true,
+		StreamName: "stream",
+		NodeName:   "fields",
+		Config:     config,
+	}}
+
+	f := Supplier()
+	assert.NoError(t, f.(streams.Initializer).Init(pc))
+
+	value := `{"user":{"id":12345678,"name":"joe","card":"4111"},"debug":true,"meta":{}}`
+	f.Process(pc, streams.NewRecord("test", nil, streams.StringEncoder(value), time.Now(), nil))
+
+	assert.Equal(t, 1, pc.Data.ForwardCount)
+	record := pc.Data.Forwarded[0]
+
+	key, _ := record.EncodeKey()
+	assert.Equal(t, "12345678", string(key))
+
+	value2, _ := record.EncodeValue()
+	assert.JSONEq(t, `{"user.id":12345678,"user.card":"***","name":"joe","meta":{}}`, string(value2))
+
+	// values not json objects are dropped
+	f.Process(pc, streams.NewRecord("test", nil, streams.StringEncoder(`[1]`), time.Now(), nil))
+	assert.Equal(t, 1, pc.Data.ErrorCount)
+	assert.Equal(t, 1, pc.Data.ForwardCount)
+}
@@ -0,0 +1,219 @@
+package jsonpath
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/brunotm/streams"
+)
+
+var errInvalidPath = streams.Errorf(streams.CodeConfig, "invalid json path")
+
+// Path is a compiled JSONPath expression selecting a single value of a json
+// document. Supported are the root $, child members .name or ['name'] and
+// array indexes [index], where negative indexes count from the array end.
+// The leading $ can be omitted, as in a.b[0].
+type Path struct {
+	expr  string
+	steps []step
+}
+
+// step of a path, either a member name or an array index
+type step struct {
+	name  string
+	index int
+	array bool
+}
+
+// Compile the JSONPath expression
+func Compile(expr string) (path *Path, err error) {
+	path = &Path{expr: expr}
+	rest := strings.TrimSpace(expr)
+	rooted := strings.HasPrefix(rest, "$")
+	rest = strings.TrimPrefix(rest, "$")
+
+	for first := !rooted; rest != ""; first = false {
+		switch {
+		case rest[0] == '.':
+			rest = rest[1:]
+			fallthrough
+
+		case first && rest[0] != '[':
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, errInvalidPath
+			}
+			path.steps = append(path.steps, step{name: rest[:end]})
+			rest = rest[end:]
+
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest, "']")
+			if end < 2 {
+				return nil, errInvalidPath
+			}
+			path.steps = append(path.steps, step{name: rest[2:end]})
+			rest = rest[end+2:]
+
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, errInvalidPath
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, errInvalidPath
+			}
+			path.steps = append(path.steps, step{index: index, array: true})
+			rest = rest[end+1:]
+
+		default:
+			return nil, errInvalidPath
+		}
+	}
+
+	return path, nil
+}
+
+// MustCompile is like Compile but panics if the expression is invalid
+func MustCompile(expr string) (path *Path) {
+	path, err := Compile(expr)
+	if err != nil {
+		panic(err.Error() + ": " + expr)
+	}
+	return path
+}
+
+// String returns the path expression
+func (p *Path) String() (expr string) {
+	return p.expr
+}
+
+// Get the value selected by the path from a decoded json document
+func (p *Path) Get(document interface{}) (value interface{}, ok bool) {
+	value = document
+
+	for _, s := range p.steps {
+		if !s.array {
+			object, isObject := value.(map[string]interface{})
+			if !isObject {
+				return nil, false
+			}
+			if value, ok = object[s.name]; !ok {
+				return nil, false
+			}
+			continue
+		}
+
+		array, isArray := value.([]interface{})
+		if !isArray {
+			return nil, false
+		}
+
+		index := s.index
+		if index < 0 {
+			index += len(array)
+		}
+		if index < 0 || index >= len(array) {
+			return nil, false
+		}
+		value = array[index]
+	}
+
+	return value, true
+}
+
+// Set replaces the value selected by the path in a decoded json document.
+// It returns false if the path does not select an existing value.
+func (p *Path) Set(document, value interface{}) (ok bool) {
+	if len(p.steps) == 0 {
+		return false
+	}
+
+	parent, ok := (&Path{steps: p.steps[:len(p.steps)-1]}).Get(document)
+	if !ok {
+		return false
+	}
+
+	last := p.steps[len(p.steps)-1]
+	if !last.array {
+		object, isObject := parent.(map[string]interface{})
+		if !isObject {
+			return false
+		}
+		if _, ok = object[last.name]; ok {
+			object[last.name] = value
+		}
+		return ok
+	}
+
+	array, isArray := parent.([]interface{})
+	if !isArray {
+		return false
+	}
+
+	index := last.index
+	if index < 0 {
+		index += len(array)
+	}
+	if index < 0 || index >= len(array) {
+		return false
+	}
+	array[index] = value
+	return true
+}
+
+// Lookup decodes the json document and returns the value selected by the path
+func (p *Path) Lookup(data []byte) (value interface{}, ok bool, err error) {
+	document, err := Decode(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, ok = p.Get(document)
+	return value, ok, nil
+}
+
+// Decode the json document preserving numbers as json.Number
+func Decode(data []byte) (document interface{}, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&document)
+	return document, err
+}
+
+// Scalar returns the string form of a scalar json value. Strings are
+// returned unquoted, while objects, arrays and null are not scalars.
+func Scalar(value interface{}) (s string, ok bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+	return "", false
+}
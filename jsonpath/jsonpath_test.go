@@ -0,0 +1,73 @@
+package jsonpath
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+
+	"github.com/brunotm/streams"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPath(t *testing.T) {
+	document := []byte(`{"a":{"b":[{"c":1},{"c":"x"}],"d e":true},"n":12345678}`)
+
+	for expr, expected := range map[string]string{
+		"$.a.b[0].c":    "1",
+		"a.b[-1].c":     "x",
+		"$['a']['d e']": "true",
+		"$.n":           "12345678",
+	} {
+		value, ok, err := MustCompile(expr).Lookup(document)
+		assert.NoError(t, err)
+		assert.True(t, ok, expr)
+
+		s, ok := Scalar(value)
+		assert.True(t, ok, expr)
+		assert.Equal(t, expected, s, expr)
+	}
+
+	for _, expr := range []string{"$.a.x", "$.a.b[2]", "$.n.a", "$.a[0]"} {
+		_, ok, err := MustCompile(expr).Lookup(document)
+		assert.NoError(t, err)
+		assert.False(t, ok, expr)
+	}
+
+	value, ok, _ := MustCompile("$").Lookup(document)
+	assert.True(t, ok)
+	_, ok = Scalar(value)
+	assert.False(t, ok)
+
+	for _, expr := range []string{"$.", "$[x]", "$['a'", "$..a", "$a"} {
+		_, err := Compile(expr)
+		assert.Equal(t, streams.CodeConfig, streams.CodeOf(err), expr)
+	}
+}
+
+func TestPathSet(t *testing.T) {
+	document, err := Decode([]byte(`{"a":{"b":[{"c":1},{"c":"x"}]}}`))
+	assert.NoError(t, err)
+
+	assert.True(t, MustCompile("$.a.b[-1].c").Set(document, "y"))
+	assert.True(t, MustCompile("$.a.b[0]").Set(document, true))
+	assert.False(t, MustCompile("$.a.x").Set(document, 1))
+	assert.False(t, MustCompile("$.a.b[2]").Set(document, 1))
+	assert.False(t, MustCompile("$").Set(document, 1))
+
+	value, _ := MustCompile("$.a.b").Get(document)
+	assert.Equal(t, []interface{}{true, map[string]interface{}{"c": "y"}}, value)
+}
@@ -0,0 +1,159 @@
+package jsonroute
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"regexp"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/jsonpath"
+)
+
+var errInvalidRoute = streams.Errorf(streams.CodeConfig, "invalid route")
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Router)(nil)
+var _ streams.Processor = (*Router)(nil)
+
+// Router forwards records to named successors by evaluating JSONPath
+// expressions against the json record values. Routes are configured in the
+// <stream>.<node>.routes array, where each route has the keys:
+//
+//	path:    JSONPath expression evaluated against the record value
+//	equals:  the route matches if the selected scalar value equals this value
+//	matches: the route matches if the selected scalar value matches this regexp
+//	to:      name of the successor receiving the matched records
+//
+// Routes without equals or matches match if the path selects any value.
+// Routes are evaluated in order and records are forwarded to the first matched
+// route, or to all matched routes with the <stream>.<node>.all config.
+// Unmatched records are forwarded to the <stream>.<node>.default successor
+// if set, or dropped otherwise.
+type Router struct {
+	routes   []route
+	all      bool
+	fallback string
+}
+
+// route of the router
+type route struct {
+	path    *jsonpath.Path
+	equals  *string
+	matches *regexp.Regexp
+	to      string
+}
+
+// Supplier for the router
+func Supplier() (processor streams.Processor) {
+	return &Router{}
+}
+
+// Init the router routes from its configuration
+func (r *Router) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	r.all = config.Get("all").Bool(false)
+	r.fallback = config.Get("default").String("")
+
+	for _, c := range config.Get("routes").Array() {
+		var rt route
+		if rt.to = c.Get("to").String(""); rt.to == "" {
+			return errInvalidRoute
+		}
+
+		if rt.path, err = jsonpath.Compile(c.Get("path").String("")); err != nil {
+			return err
+		}
+
+		if c.IsSet("equals") {
+			equals := c.Get("equals").String("")
+			rt.equals = &equals
+		}
+
+		if c.IsSet("matches") {
+			if rt.matches, err = regexp.Compile(c.Get("matches").String("")); err != nil {
+				return err
+			}
+		}
+
+		r.routes = append(r.routes, rt)
+	}
+
+	if len(r.routes) == 0 {
+		return errInvalidRoute
+	}
+	return nil
+}
+
+// Process forwards the record to the matched routes
+func (r *Router) Process(pc streams.ProcessorContext, record streams.Record) {
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	document, err := jsonpath.Decode(value)
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	var matched bool
+	for x := range r.routes {
+		if !r.routes[x].match(document) {
+			continue
+		}
+
+		matched = true
+		if err = pc.ForwardTo(r.routes[x].to, record); err != nil {
+			pc.Error(err, record)
+		}
+
+		if !r.all {
+			return
+		}
+	}
+
+	if !matched && r.fallback != "" {
+		if err = pc.ForwardTo(r.fallback, record); err != nil {
+			pc.Error(err, record)
+		}
+	}
+}
+
+// match the route against the document
+func (rt *route) match(document interface{}) (ok bool) {
+	value, ok := rt.path.Get(document)
+	if !ok {
+		return false
+	}
+
+	if rt.equals == nil && rt.matches == nil {
+		return true
+	}
+
+	s, ok := jsonpath.Scalar(value)
+	if !ok {
+		return false
+	}
+
+	if rt.equals != nil && s != *rt.equals {
+		return false
+	}
+
+	return rt.matches == nil || rt.matches.MatchString(s)
+}
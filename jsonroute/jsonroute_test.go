@@ -0,0 +1,68 @@
+package jsonroute
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter(t *testing.T) {
+	config := streams.NewConfig(nil)
+	config.Set([]interface{}{
+		map[string]interface{}{"path": "$.type", "equals": "order", "to": "orders"},
+		map[string]interface{}{"path": "$.customer.tier", "matches": "^(gold|platinum)$", "to": "vip"},
+		map[string]interface{}{"path": "$.flags[0]", "to": "flagged"},
+	}, "stream", "router", "routes")
+	config.Set("other", "stream.router.default")
+
+	pc := &mock.Context{Data: mock.ContextData{
+		Active:     true,
+		StreamName: "stream",
+		NodeName:   "router",
+		Config:     config,
+	}}
+
+	r := Supplier()
+	assert.NoError(t, r.(streams.Initializer).Init(pc))
+
+	for _, value := range []string{
+		`{"type":"order","customer":{"tier":"gold"}}`,
+		`{"type":"refund","customer":{"tier":"platinum"}}`,
+		`{"type":"refund","flags":["x"]}`,
+		`{"type":"refund","customer":{"tier":"silver"},"flags":[]}`,
+	} {
+		r.Process(pc, streams.NewRecord("test", nil, streams.StringEncoder(value), time.Now(), nil))
+	}
+	assert.Equal(t, []string{"orders", "vip", "flagged", "other"}, pc.Data.ForwardedTo)
+
+	// forward to all matched routes
+	config.Set(true, "stream.router.all")
+	pc.Data.ForwardedTo = nil
+	r = Supplier()
+	assert.NoError(t, r.(streams.Initializer).Init(pc))
+	r.Process(pc, streams.NewRecord("test", nil,
+		streams.StringEncoder(`{"type":"order","customer":{"tier":"gold"}}`), time.Now(), nil))
+	assert.Equal(t, []string{"orders", "vip"}, pc.Data.ForwardedTo)
+
+	r.Process(pc, streams.NewRecord("test", nil, streams.StringEncoder(`{`), time.Now(), nil))
+	assert.Equal(t, 1, pc.Data.ErrorCount)
+}
@@ -0,0 +1,95 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyViolation is emitted when a record completes processing in a sink later
+// than the sink latency budget configured in <stream>.<sink>.latency.budget.
+// The latency is measured from the record time to the sink processing completion.
+type LatencyViolation struct {
+	Sink    string        // Sink where the budget was breached
+	Budget  time.Duration // Configured latency budget
+	Latency time.Duration // Record end-to-end latency
+	Slowest string        // Node with the highest mean processing time on the path to the sink
+}
+
+func (l *LatencyViolation) Error() string {
+	return fmt.Sprintf("sink %s latency %s exceeds budget %s, slowest node: %s",
+		l.Sink, l.Latency, l.Budget, l.Slowest)
+}
+
+// checkBudget emits a LatencyViolation if the record latency exceeds the node budget
+func (pc *processorContext) checkBudget(record Record, now time.Time) {
+	if pc.node.budget <= 0 || record.Time.IsZero() {
+		return
+	}
+
+	latency := now.Sub(record.Time)
+	if latency <= pc.node.budget {
+		return
+	}
+
+	atomic.AddInt64(&pc.node.metrics.violations, 1)
+	pc.Error(&LatencyViolation{
+		Sink:    pc.node.name,
+		Budget:  pc.node.budget,
+		Latency: latency,
+		Slowest: pc.node.slowest().name,
+	}, record)
+}
+
+// slowest returns the node with the highest mean processing time
+// among the node and all of its upstream nodes
+func (n *Node) slowest() (slowest *Node) {
+	var max float64
+	visited := map[*Node]bool{n: true}
+	queue := []*Node{n}
+	slowest = n
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		if mean := node.metrics.mean(); mean > max {
+			max = mean
+			slowest = node
+		}
+
+		for _, predecessor := range node.predecessors {
+			if !visited[predecessor] {
+				visited[predecessor] = true
+				queue = append(queue, predecessor)
+			}
+		}
+	}
+
+	return slowest
+}
+
+// mean returns the mean processing time in nanoseconds
+func (m *nodeMetrics) mean() (mean float64) {
+	processed := atomic.LoadInt64(&m.processed)
+	if processed == 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&m.duration)) / float64(processed)
+}
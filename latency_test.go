@@ -0,0 +1,79 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyBudget(t *testing.T) {
+	var mtx sync.Mutex
+	var violations []*LatencyViolation
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	config := NewConfig(nil)
+	config.Set("20ms", "stream.sink.latency.budget")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: keyedRecords("a", "b", "c")}
+	}))
+	assert.NoError(t, b.AddProcessor("slow", func() Processor {
+		return ProcessorFunc(func(pc ProcessorContext, record Record) {
+			key, _ := record.EncodeKey()
+			if string(key) == "b" {
+				time.Sleep(50 * time.Millisecond)
+			}
+			pc.Forward(record)
+		})
+	}, "source"))
+	assert.NoError(t, b.AddProcessorFunc("fast", passthrough, "slow"))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		wg.Done()
+	}, "fast"))
+	b.ErrorHandler(ErrorHandlerFunc(func(e Error) {
+		mtx.Lock()
+		violations = append(violations, e.Error.(*LatencyViolation))
+		mtx.Unlock()
+	}))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+	assert.NoError(t, stream.Close())
+
+	// records are created after the previous one is processed
+	assert.Len(t, violations, 1)
+	for _, v := range violations {
+		assert.Equal(t, "sink", v.Sink)
+		assert.Equal(t, "slow", v.Slowest)
+		assert.Equal(t, 20*time.Millisecond, v.Budget)
+	}
+
+	for _, m := range stream.Metrics() {
+		if m.Name == "streams_node_latency_violations_total" {
+			assert.Equal(t, "sink", m.Labels["node"])
+			assert.Equal(t, float64(1), m.Value)
+		}
+	}
+}
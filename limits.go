@@ -0,0 +1,177 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+)
+
+// Load shedding policies of the stream limits
+const (
+	// ShedBlock blocks sources until the buffered bytes are within the budget
+	ShedBlock = "block"
+	// ShedDrop drops the records forwarded by sources while the budget is exceeded
+	ShedDrop = "drop"
+)
+
+var (
+	// ErrBudgetExceeded is returned when scaling beyond the stream task budget,
+	// and emitted as a resource error for the records dropped by sources while
+	// the stream memory budget is exceeded.
+	ErrBudgetExceeded = Errorf(CodeState, "stream budget exceeded")
+
+	errInvalidShedPolicy = Errorf(CodeConfig, "invalid shed policy")
+)
+
+// limits enforces the memory and goroutine budgets of a stream.
+// The memory budget bounds the bytes of the records buffered in the node tasks,
+// and is enforced at the sources according to the shed policy, as blocking
+// or dropping records between nodes could deadlock the topology.
+// The goroutine budget bounds the number of tasks of all nodes, refusing
+// scale-ups beyond it. It is configured in the <stream>.limits config
+// subtree with the keys bytes, tasks and policy.
+type limits struct {
+	mtx      sync.Mutex
+	space    *sync.Cond
+	maxBytes int64
+	maxTasks int
+	policy   string
+	bytes    int64
+	tasks    int
+	shed     int64
+}
+
+// newLimits creates the stream limits from the config, or returns nil if no limits are set
+func newLimits(config Config) (l *limits, err error) {
+	l = &limits{}
+	l.space = sync.NewCond(&l.mtx)
+	l.maxBytes = config.Get("bytes").Int64(0)
+	l.maxTasks = config.Get("tasks").Int(0)
+
+	l.policy = config.Get("policy").String(ShedBlock)
+	if l.policy != ShedBlock && l.policy != ShedDrop {
+		return nil, errInvalidShedPolicy
+	}
+
+	if l.maxBytes <= 0 && l.maxTasks <= 0 {
+		return nil, nil
+	}
+	return l, nil
+}
+
+// admit returns if a record can be forwarded by a source. Under the block
+// policy it waits until the buffered bytes are within the budget.
+func (l *limits) admit() (ok bool) {
+	if l == nil || l.maxBytes <= 0 {
+		return true
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.policy == ShedDrop {
+		if l.bytes >= l.maxBytes {
+			l.shed++
+			return false
+		}
+		return true
+	}
+
+	for l.bytes >= l.maxBytes {
+		l.space.Wait()
+	}
+	return true
+}
+
+// hold the bytes of a record buffered in a task
+func (l *limits) hold(record Record) {
+	if l == nil || l.maxBytes <= 0 {
+		return
+	}
+
+	l.mtx.Lock()
+	l.bytes += recordSize(record)
+	l.mtx.Unlock()
+}
+
+// release the bytes of a record taken from a task buffer
+func (l *limits) release(record Record) {
+	if l == nil || l.maxBytes <= 0 {
+		return
+	}
+
+	l.mtx.Lock()
+	l.bytes -= recordSize(record)
+	if l.bytes < l.maxBytes {
+		l.space.Broadcast()
+	}
+	l.mtx.Unlock()
+}
+
+// acquireTasks reserves the given number of tasks within the budget
+func (l *limits) acquireTasks(n int) (err error) {
+	if l == nil {
+		return nil
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.maxTasks > 0 && l.tasks+n > l.maxTasks {
+		return ErrBudgetExceeded
+	}
+	l.tasks += n
+	return nil
+}
+
+// releaseTasks returns the given number of tasks to the budget
+func (l *limits) releaseTasks(n int) {
+	if l == nil {
+		return
+	}
+
+	l.mtx.Lock()
+	l.tasks -= n
+	l.mtx.Unlock()
+}
+
+// usage returns the buffered bytes, running tasks and records shed
+func (l *limits) usage() (bytes int64, tasks int, shed int64) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.bytes, l.tasks, l.shed
+}
+
+// shedRecord drops the record forwarded by a source over the stream budget,
+// emitting it as a resource error and releasing the source reference
+func (pc *processorContext) shedRecord(record Record) (err error) {
+	pc.Error(Classify(ErrBudgetExceeded, Resource), record)
+
+	if record.ack != nil {
+		if err = record.ack.release(); err != nil {
+			pc.Error(err, record)
+		}
+	}
+	return nil
+}
+
+// recordSize returns the size in bytes of the record topic, key and value
+func recordSize(record Record) (size int64) {
+	key, _ := record.EncodeKey()
+	value, _ := record.EncodeValue()
+	return int64(len(record.Topic) + len(key) + len(value))
+}
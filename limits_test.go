@@ -0,0 +1,135 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitsMemory(t *testing.T) {
+	config := NewConfig(nil)
+	config.Set(10, "bytes")
+	config.Set(ShedDrop, "policy")
+
+	l, err := newLimits(config)
+	assert.NoError(t, err)
+
+	record := NewRecord("topic", StringEncoder("k"), StringEncoder("value"), time.Now(), nil)
+	assert.True(t, l.admit())
+	l.hold(record)
+	assert.False(t, l.admit())
+
+	bytes, _, shed := l.usage()
+	assert.Equal(t, int64(11), bytes)
+	assert.Equal(t, int64(1), shed)
+
+	l.release(record)
+	assert.True(t, l.admit())
+
+	// the block policy waits for buffered records to be released
+	config.Set(ShedBlock, "policy")
+	l, err = newLimits(config)
+	assert.NoError(t, err)
+	l.hold(record)
+
+	admitted := make(chan struct{})
+	go func() {
+		l.admit()
+		close(admitted)
+	}()
+
+	select {
+	case <-admitted:
+		t.Fatal("admitted over the memory budget")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	l.release(record)
+	<-admitted
+
+	config.Set("invalid", "policy")
+	_, err = newLimits(config)
+	assert.Equal(t, errInvalidShedPolicy, err)
+}
+
+func TestLimitsTasks(t *testing.T) {
+	config := NewConfig(nil)
+	config.Set(3, "stream.limits.tasks")
+	config.Set(2, "stream.sink.tasks.count")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(0)))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	// a source consumer and two sink tasks
+	assert.Equal(t, ErrBudgetExceeded, stream.Scale("sink", 3))
+	assert.Equal(t, 2, stream.tasks[stream.topology.getNode("sink")].scale())
+
+	assert.NoError(t, stream.Scale("sink", 1))
+	assert.NoError(t, stream.Scale("source", 2))
+
+	_, tasks, _ := stream.limits.usage()
+	assert.Equal(t, 3, tasks)
+	assert.NoError(t, stream.Close())
+
+	_, tasks, _ = stream.limits.usage()
+	assert.Equal(t, 0, tasks)
+}
+
+// taskLimitedProcessor fails to initialize tasks beyond its limit
+type taskLimitedProcessor struct {
+	limit int
+}
+
+func (p taskLimitedProcessor) Process(pc ProcessorContext, record Record) {}
+
+func (p taskLimitedProcessor) Init(pc ProcessorContext) (err error) {
+	if pc.TaskID() >= p.limit {
+		return errors.New("task limit")
+	}
+	return nil
+}
+
+func TestLimitsTasksInstanceError(t *testing.T) {
+	config := NewConfig(nil)
+	config.Set(5, "stream.limits.tasks")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(0)))
+	assert.NoError(t, b.AddSink("sink", func() Processor { return taskLimitedProcessor{limit: 2} }, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	// tasks added before the failure are kept, and the budget
+	// of the remaining ones released
+	assert.EqualError(t, stream.Scale("sink", 4), "task limit")
+	assert.Equal(t, 2, stream.tasks[stream.topology.getNode("sink")].scale())
+
+	_, tasks, _ := stream.limits.usage()
+	assert.Equal(t, 3, tasks)
+	assert.NoError(t, stream.Close())
+}
@@ -0,0 +1,171 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultStalenessInterval is the default interval of the source staleness checks
+	DefaultStalenessInterval = time.Second
+)
+
+// StaleSource is emitted when a source has not forwarded records or reported
+// a successful poll within the thresholds configured in the
+// <stream>.<source>.staleness.records and <stream>.<source>.staleness.poll
+// durations, detecting silent upstream outages. It is emitted once until the
+// source forwards records or polls again.
+type StaleSource struct {
+	Source    string        // Stale source
+	Poll      bool          // Whether the poll or records threshold was breached
+	Threshold time.Duration // Configured staleness threshold
+	Since     time.Duration // Time since the last record or poll
+}
+
+func (s *StaleSource) Error() string {
+	what := "records"
+	if s.Poll {
+		what = "successful polls"
+	}
+	return fmt.Sprintf("source %s stale: no %s for %s, threshold %s",
+		s.Source, what, s.Since, s.Threshold)
+}
+
+// liveness tracks the last record forwarded and the last successful poll of a
+// source, in unix nanoseconds, and the stale state of each.
+type liveness struct {
+	record     int64
+	poll       int64
+	staleRecs  int32
+	stalePoll  int32
+	records    time.Duration
+	polls      time.Duration
+	thresholds bool
+}
+
+func newLiveness(records, polls time.Duration) (l *liveness) {
+	return &liveness{records: records, polls: polls, thresholds: records > 0 || polls > 0}
+}
+
+// observe a record forwarded by the source, which implies a successful poll.
+// Sources are observed as of their start.
+func (l *liveness) observe(now time.Time) {
+	atomic.StoreInt64(&l.record, now.UnixNano())
+	atomic.StoreInt64(&l.poll, now.UnixNano())
+}
+
+// heartbeat observes a successful poll of the source
+func (l *liveness) heartbeat(now time.Time) {
+	atomic.StoreInt64(&l.poll, now.UnixNano())
+}
+
+// check returns the staleness errors of the source at the given time,
+// once per transition to stale. Sources not yet started are never stale.
+func (l *liveness) check(source string, now time.Time) (errs []error) {
+	check := func(last *int64, stale *int32, threshold time.Duration, poll bool) {
+		if threshold <= 0 || atomic.LoadInt64(last) == 0 {
+			return
+		}
+
+		since := now.Sub(time.Unix(0, atomic.LoadInt64(last)))
+		if since <= threshold {
+			atomic.StoreInt32(stale, 0)
+			return
+		}
+
+		if atomic.CompareAndSwapInt32(stale, 0, 1) {
+			errs = append(errs, &StaleSource{Source: source, Poll: poll, Threshold: threshold, Since: since})
+		}
+	}
+
+	check(&l.record, &l.staleRecs, l.records, false)
+	check(&l.poll, &l.stalePoll, l.polls, true)
+	return errs
+}
+
+// stale returns if the source is currently stale
+func (l *liveness) stale() (stale bool) {
+	return atomic.LoadInt32(&l.staleRecs) > 0 || atomic.LoadInt32(&l.stalePoll) > 0
+}
+
+// Heartbeat reports a successful poll of the upstream system by a source,
+// even if no records were received, so that idle sources are not
+// considered stale by the poll staleness threshold.
+func (pc *processorContext) Heartbeat() {
+	if pc.node.liveness != nil {
+		pc.node.liveness.heartbeat(time.Now())
+	}
+}
+
+// stalenessMonitor periodically checks the staleness of the stream sources
+type stalenessMonitor struct {
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+// startStalenessMonitor checks the sources with staleness thresholds every
+// <stream>.staleness.interval, emitting StaleSource errors to the stream handler.
+// It returns nil if no source has staleness thresholds.
+func startStalenessMonitor(s *Stream) (m *stalenessMonitor) {
+	var sources []*Node
+	for _, node := range s.topology.roots {
+		if node.liveness != nil && node.liveness.thresholds {
+			sources = append(sources, node)
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil
+	}
+
+	m = &stalenessMonitor{done: make(chan struct{})}
+	interval := s.config.Get(s.name, "staleness", "interval").Duration(DefaultStalenessInterval)
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.done:
+				return
+			case now := <-ticker.C:
+				for _, node := range sources {
+					for _, err := range node.liveness.check(node.name, now) {
+						atomic.AddInt64(&node.metrics.errors, 1)
+						s.handleError(Error{Node: node, Error: err, Category: CategoryOf(err)})
+					}
+				}
+			}
+		}
+	}()
+
+	return m
+}
+
+// stop checking the sources staleness
+func (m *stalenessMonitor) stop() {
+	close(m.done)
+	m.wg.Wait()
+}
@@ -0,0 +1,92 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLivenessCheck(t *testing.T) {
+	l := newLiveness(time.Minute, 10*time.Second)
+	now := time.Unix(1000, 0)
+
+	// sources not yet started are never stale
+	assert.Len(t, l.check("source", now.Add(time.Hour)), 0)
+
+	l.observe(now)
+	assert.Len(t, l.check("source", now.Add(10*time.Second)), 0)
+
+	// idle sources polling successfully are only stale by records
+	l.heartbeat(now.Add(50 * time.Second))
+	assert.Len(t, l.check("source", now.Add(55*time.Second)), 0)
+
+	errs := l.check("source", now.Add(65*time.Second))
+	assert.Len(t, errs, 2)
+	assert.Equal(t, &StaleSource{Source: "source", Threshold: time.Minute, Since: 65 * time.Second}, errs[0])
+	assert.Equal(t, &StaleSource{Source: "source", Poll: true, Threshold: 10 * time.Second,
+		Since: 15 * time.Second}, errs[1])
+	assert.True(t, l.stale())
+
+	// staleness is emitted once until the source recovers
+	assert.Len(t, l.check("source", now.Add(70*time.Second)), 0)
+
+	l.heartbeat(now.Add(70 * time.Second))
+	assert.Len(t, l.check("source", now.Add(75*time.Second)), 0)
+	assert.True(t, l.stale())
+
+	l.observe(now.Add(75 * time.Second))
+	assert.Len(t, l.check("source", now.Add(80*time.Second)), 0)
+	assert.False(t, l.stale())
+}
+
+func TestStaleSource(t *testing.T) {
+	config := NewConfig(nil)
+	config.Set("10ms", "stream.staleness.interval")
+	config.Set("30ms", "stream.source.staleness.records")
+
+	errs := make(chan Error, 10)
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: keyedRecords("a")}
+	}))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {}, "source"))
+	b.ErrorHandler(ErrorHandlerFunc(func(e Error) { errs <- e }))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	e := <-errs
+	assert.Equal(t, "source", e.Node.Name())
+	assert.Equal(t, "source", e.Error.(*StaleSource).Source)
+	assert.False(t, e.Error.(*StaleSource).Poll)
+
+	metrics := make(map[string]float64)
+	for _, m := range stream.Metrics() {
+		if m.Labels["node"] == "source" {
+			metrics[m.Name] = m.Value
+		}
+	}
+	assert.Equal(t, float64(1), metrics["streams_source_stale"])
+	assert.True(t, metrics["streams_source_last_record_timestamp_seconds"] > 0)
+
+	assert.NoError(t, stream.Close())
+	assert.Len(t, errs, 0)
+}
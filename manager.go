@@ -0,0 +1,106 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+)
+
+var (
+	// ErrStreamNotFound is returned when the requested stream
+	// doesn't exists in the manager.
+	ErrStreamNotFound = Errorf(CodeState, "stream not found")
+
+	errStreamExists = Errorf(CodeState, "stream already exists")
+)
+
+// Streams manages multiple streams running in the same process,
+// which share the resources of the manager pool.
+type Streams struct {
+	mtx     sync.Mutex
+	pool    *Pool
+	streams map[string]*Stream
+}
+
+// NewStreams creates a stream manager with the given resource pool.
+// A new pool is created if pool is nil.
+func NewStreams(pool *Pool) (m *Streams) {
+	if pool == nil {
+		pool = NewPool()
+	}
+
+	m = &Streams{}
+	m.pool = pool
+	m.streams = make(map[string]*Stream)
+	return m
+}
+
+// Pool returns the manager resource pool
+func (m *Streams) Pool() (pool *Pool) {
+	return m.pool
+}
+
+// Add builds the stream from the builder and adds it to the manager
+func (m *Streams) Add(b *Builder) (stream *Stream, err error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if _, exists := m.streams[b.name]; exists {
+		return nil, errStreamExists
+	}
+
+	b.Pool(m.pool)
+	if stream, err = b.Build(); err != nil {
+		return nil, err
+	}
+
+	m.streams[stream.name] = stream
+	return stream, nil
+}
+
+// Get the stream with the given name, or nil if not found
+func (m *Streams) Get(name string) (stream *Stream) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.streams[name]
+}
+
+// Start all managed streams
+func (m *Streams) Start() (err error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for _, stream := range m.streams {
+		if err = stream.Start(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close all managed streams, releasing their shared resources
+func (m *Streams) Close() (err error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for _, stream := range m.streams {
+		if e := stream.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// client is a shared resource counting its closes
+type client struct {
+	closed *int32
+}
+
+func (c *client) Close() error {
+	atomic.AddInt32(c.closed, 1)
+	return nil
+}
+
+// resourceSink acquires the client resource on Init
+type resourceSink struct {
+	clients chan interface{}
+}
+
+func (r *resourceSink) Init(pc ProcessorContext) (err error) {
+	c, err := pc.Resource("client")
+	r.clients <- c
+	return err
+}
+
+func (r *resourceSink) Process(pc ProcessorContext, record Record) {}
+
+func TestStreamsSharedResources(t *testing.T) {
+	var created, closed int32
+	clients := make(chan interface{}, 2)
+
+	m := NewStreams(nil)
+	assert.NoError(t, m.Pool().Register("client", func() (interface{}, error) {
+		atomic.AddInt32(&created, 1)
+		return &client{closed: &closed}, nil
+	}))
+	assert.Error(t, m.Pool().Register("client", nil))
+
+	for _, name := range []string{"a", "b"} {
+		b := NewBuilder(name, NewConfig(nil))
+		assert.NoError(t, b.AddSource("source", testSourceSupplier(0)))
+		assert.NoError(t, b.AddSink("sink", func() Processor {
+			return &resourceSink{clients: clients}
+		}, "source"))
+		_, err := m.Add(b)
+		assert.NoError(t, err)
+	}
+
+	_, err := m.Add(NewBuilder("a", NewConfig(nil)))
+	assert.Error(t, err)
+
+	assert.NoError(t, m.Start())
+	assert.Equal(t, <-clients, <-clients)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&created))
+
+	_, err = m.Get("a").resources.get("unknown")
+	assert.Equal(t, ErrResourceNotFound, err)
+
+	assert.NoError(t, m.Get("a").Close())
+	assert.Equal(t, int32(0), atomic.LoadInt32(&closed))
+	assert.NoError(t, m.Get("b").Close())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&closed))
+}
@@ -0,0 +1,398 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencySamples is the number of recent processing latencies kept per node
+const latencySamples = 1024
+
+// latencyQuantiles are the reported processing latency quantiles
+var latencyQuantiles = []float64{0.5, 0.9, 0.99}
+
+// MetricType is the type of a metric
+type MetricType int
+
+// Metric types
+const (
+	// Counter metrics are monotonically increasing totals
+	Counter MetricType = iota
+	// Gauge metrics are point in time values
+	Gauge
+)
+
+// Metric is a measure of a stream component
+type Metric struct {
+	Name   string
+	Type   MetricType
+	Labels map[string]string
+	Value  float64
+	Time   time.Time
+}
+
+// nodeMetrics are the record counters of a node
+type nodeMetrics struct {
+	processed  int64
+	forwarded  int64
+	errors     int64
+	duration   int64 // total processing time in nanoseconds
+	violations int64 // latency budget violations
+	duplicates int64 // records redelivered by a source
+	dropped    int64 // records of isolated tenants dropped by a source
+	restarts   int64 // processor instances restarted by the node supervisor
+	latency    latencies
+}
+
+// latencies keeps the most recent processing latencies of a node
+type latencies struct {
+	mtx     sync.Mutex
+	samples [latencySamples]time.Duration
+	count   int
+}
+
+// observe the processing latency
+func (l *latencies) observe(d time.Duration) {
+	l.mtx.Lock()
+	l.samples[l.count%latencySamples] = d
+	l.count++
+	l.mtx.Unlock()
+}
+
+// quantiles returns the given quantiles of the recent latencies,
+// or nil if none were observed
+func (l *latencies) quantiles(qs []float64) (values []time.Duration) {
+	l.mtx.Lock()
+	n := l.count
+	if n > latencySamples {
+		n = latencySamples
+	}
+	samples := make([]time.Duration, n)
+	copy(samples, l.samples[:n])
+	l.mtx.Unlock()
+
+	if n == 0 {
+		return nil
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	values = make([]time.Duration, len(qs))
+	for x, q := range qs {
+		values[x] = samples[int(q*float64(n-1))]
+	}
+	return values
+}
+
+// Metrics returns the current metrics of the stream nodes:
+//
+//	streams_node_records_processed_total:  records processed by the node
+//	streams_node_records_forwarded_total:  records forwarded by the node
+//	streams_node_errors_total:             errors emitted by the node
+//	streams_node_processing_seconds_total: time spent processing records
+//	streams_node_latency_violations_total: records exceeding the sink latency budget
+//	streams_node_duplicates_total:         records redelivered by the source within the duplicates ttl
+//	streams_node_duplicates_ratio:         ratio of redelivered to forwarded source records
+//	streams_node_tenant_dropped_total:     records of isolated tenants dropped by the source
+//	streams_node_restarts_total:           processor instances restarted after panics
+//	streams_source_last_record_timestamp_seconds: time of the last record forwarded by the source
+//	streams_source_last_poll_timestamp_seconds:   time of the last successful poll of the source
+//	streams_source_stale:                  1 if the source breached its staleness thresholds
+//	streams_node_processing_latency_seconds: quantiles of the recent processing latencies
+//	streams_node_tasks:                    current node tasks
+//	streams_node_buffered_records:         records buffered in the node tasks
+//
+// Streams with limits report their budget usage, labeled with the stream name:
+//
+//	streams_stream_buffered_bytes:         bytes of the records buffered in the node tasks
+//	streams_stream_tasks:                  current tasks of all nodes
+//	streams_stream_shed_total:             records dropped by the sources over the memory budget
+//
+// Streams with timed records report their event time watermark:
+//
+//	streams_stream_watermark_timestamp_seconds: event time watermark of the stream
+//
+// All metrics are labeled with the stream and node names, followed by the
+// metrics of Instrumented processors. Instrumented stores, as those embedding
+// StoreMetrics, are labeled with the stream and store names.
+func (s *Stream) Metrics() (metrics []Metric) {
+	now := time.Now()
+
+	for _, node := range s.topology.nodes {
+		labels := map[string]string{"stream": s.name, "node": node.name}
+		metric := func(name string, typ MetricType, value float64) {
+			metrics = append(metrics, Metric{Name: name, Type: typ, Labels: labels, Value: value, Time: now})
+		}
+
+		metric("streams_node_records_processed_total", Counter,
+			float64(atomic.LoadInt64(&node.metrics.processed)))
+		metric("streams_node_records_forwarded_total", Counter,
+			float64(atomic.LoadInt64(&node.metrics.forwarded)))
+		metric("streams_node_errors_total", Counter,
+			float64(atomic.LoadInt64(&node.metrics.errors)))
+		metric("streams_node_processing_seconds_total", Counter,
+			time.Duration(atomic.LoadInt64(&node.metrics.duration)).Seconds())
+
+		if node.budget > 0 {
+			metric("streams_node_latency_violations_total", Counter,
+				float64(atomic.LoadInt64(&node.metrics.violations)))
+		}
+
+		if node.duplicates != nil {
+			duplicates := float64(atomic.LoadInt64(&node.metrics.duplicates))
+			metric("streams_node_duplicates_total", Counter, duplicates)
+
+			var ratio float64
+			if forwarded := atomic.LoadInt64(&node.metrics.forwarded); forwarded > 0 {
+				ratio = duplicates / float64(forwarded)
+			}
+			metric("streams_node_duplicates_ratio", Gauge, ratio)
+		}
+
+		if node.tenant != nil {
+			metric("streams_node_tenant_dropped_total", Counter,
+				float64(atomic.LoadInt64(&node.metrics.dropped)))
+		}
+
+		if node.supervisor != nil {
+			metric("streams_node_restarts_total", Counter,
+				float64(atomic.LoadInt64(&node.metrics.restarts)))
+		}
+
+		if l := node.liveness; l != nil {
+			metric("streams_source_last_record_timestamp_seconds", Gauge,
+				float64(atomic.LoadInt64(&l.record))/float64(time.Second))
+			metric("streams_source_last_poll_timestamp_seconds", Gauge,
+				float64(atomic.LoadInt64(&l.poll))/float64(time.Second))
+
+			if l.thresholds {
+				var stale float64
+				if l.stale() {
+					stale = 1
+				}
+				metric("streams_source_stale", Gauge, stale)
+			}
+		}
+
+		for x, value := range node.metrics.latency.quantiles(latencyQuantiles) {
+			quantile := make(map[string]string, len(labels)+1)
+			for name, value := range labels {
+				quantile[name] = value
+			}
+			quantile["quantile"] = strconv.FormatFloat(latencyQuantiles[x], 'f', -1, 64)
+			metrics = append(metrics, Metric{Name: "streams_node_processing_latency_seconds",
+				Type: Gauge, Labels: quantile, Value: value.Seconds(), Time: now})
+		}
+
+		if t := node.tasks; t != nil {
+			metric("streams_node_tasks", Gauge, float64(t.scale()))
+			metric("streams_node_buffered_records", Gauge, float64(t.buffered()))
+		}
+
+		if node.pc != nil {
+			metrics = append(metrics, instrumented(node.pc.current(), labels, now)...)
+		}
+	}
+
+	if s.limits != nil {
+		bytes, tasks, shed := s.limits.usage()
+		labels := map[string]string{"stream": s.name}
+		metrics = append(metrics,
+			Metric{Name: "streams_stream_buffered_bytes", Type: Gauge, Labels: labels, Value: float64(bytes), Time: now},
+			Metric{Name: "streams_stream_tasks", Type: Gauge, Labels: labels, Value: float64(tasks), Time: now},
+			Metric{Name: "streams_stream_shed_total", Type: Counter, Labels: labels, Value: float64(shed), Time: now})
+	}
+
+	if watermark := s.Watermark(); !watermark.IsZero() {
+		metrics = append(metrics, Metric{Name: "streams_stream_watermark_timestamp_seconds", Type: Gauge,
+			Labels: map[string]string{"stream": s.name}, Value: float64(watermark.UnixNano()) / float64(time.Second), Time: now})
+	}
+
+	for _, node := range s.topology.storeNodes() {
+		labels := map[string]string{"stream": s.name, "store": node.name}
+		metrics = append(metrics, instrumented(node.processor, labels, now)...)
+	}
+
+	return metrics
+}
+
+// instrumented returns the metrics of instrumented processors and stores
+// with the given labels added
+func instrumented(processor interface{}, labels map[string]string, now time.Time) (metrics []Metric) {
+	i, ok := processor.(Instrumented)
+	if !ok {
+		return nil
+	}
+
+	metrics = i.Metrics()
+	for x := range metrics {
+		merged := make(map[string]string, len(labels)+len(metrics[x].Labels))
+		for name, value := range metrics[x].Labels {
+			merged[name] = value
+		}
+		for name, value := range labels {
+			merged[name] = value
+		}
+		metrics[x].Labels = merged
+
+		if metrics[x].Time.IsZero() {
+			metrics[x].Time = now
+		}
+	}
+	return metrics
+}
+
+// buffered returns the number of records buffered in the tasks
+func (t *tasks) buffered() (records int) {
+	t.RLock()
+	defer t.RUnlock()
+
+	for x := range t.buffers {
+		records += len(t.buffers[x])
+		if t.fairs[x] != nil {
+			records += t.fairs[x].len()
+		}
+	}
+	return records
+}
+
+// StoreOp is a store operation measured by StoreMetrics
+type StoreOp int
+
+// Store operations
+const (
+	StoreGet StoreOp = iota
+	StoreSet
+	StoreDelete
+	StoreRange
+)
+
+var storeOps = [...]string{"get", "set", "delete", "range"}
+
+// String returns the operation name
+func (op StoreOp) String() (name string) {
+	return storeOps[op]
+}
+
+// StoreMetrics measures the operations of a store. Stores embedding it
+// implement the Instrumented interface, observing each operation with
+// Observe deferred with the operation named error result:
+//
+//	func (d *DB) Get(key []byte) (value []byte, err error) {
+//		defer d.Observe(streams.StoreGet, time.Now(), &err)
+//		...
+//	}
+type StoreMetrics struct {
+	ops [len(storeOps)]struct {
+		count    int64
+		errors   int64
+		duration int64
+	}
+}
+
+// Observe the store operation started at the given time. ErrKeyNotFound
+// results are not counted as errors.
+func (m *StoreMetrics) Observe(op StoreOp, start time.Time, err *error) {
+	atomic.AddInt64(&m.ops[op].count, 1)
+	atomic.AddInt64(&m.ops[op].duration, int64(time.Since(start)))
+	if err != nil && *err != nil && *err != ErrKeyNotFound {
+		atomic.AddInt64(&m.ops[op].errors, 1)
+	}
+}
+
+// Metrics returns the store operation metrics labeled with the operation:
+//
+//	streams_store_operations_total:        store operations
+//	streams_store_errors_total:            store operations failed
+//	streams_store_operation_seconds_total: time spent in store operations
+func (m *StoreMetrics) Metrics() (metrics []Metric) {
+	for op := range m.ops {
+		labels := map[string]string{"op": storeOps[op]}
+		metrics = append(metrics,
+			Metric{Name: "streams_store_operations_total", Type: Counter, Labels: labels,
+				Value: float64(atomic.LoadInt64(&m.ops[op].count))},
+			Metric{Name: "streams_store_errors_total", Type: Counter, Labels: labels,
+				Value: float64(atomic.LoadInt64(&m.ops[op].errors))},
+			Metric{Name: "streams_store_operation_seconds_total", Type: Counter, Labels: labels,
+				Value: time.Duration(atomic.LoadInt64(&m.ops[op].duration)).Seconds()})
+	}
+	return metrics
+}
+
+// Metrics returns the metrics of all managed streams
+func (m *Streams) Metrics() (metrics []Metric) {
+	m.mtx.Lock()
+	streams := make([]*Stream, 0, len(m.streams))
+	for _, stream := range m.streams {
+		streams = append(streams, stream)
+	}
+	m.mtx.Unlock()
+
+	sort.Slice(streams, func(i, j int) bool { return streams[i].name < streams[j].name })
+	for _, stream := range streams {
+		metrics = append(metrics, stream.Metrics()...)
+	}
+	return metrics
+}
+
+// MetricsHandler returns a http.Handler serving the metrics of the managed
+// streams in the prometheus text format
+func MetricsHandler(m *Streams) (handler http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w, m.Metrics())
+	})
+}
+
+// WriteMetrics writes the metrics in the prometheus text format,
+// grouped by metric name
+func WriteMetrics(w io.Writer, metrics []Metric) {
+	var names []string
+	families := make(map[string][]Metric)
+	for _, metric := range metrics {
+		if _, exists := families[metric.Name]; !exists {
+			names = append(names, metric.Name)
+		}
+		families[metric.Name] = append(families[metric.Name], metric)
+	}
+
+	for _, name := range names {
+		typ := "gauge"
+		if families[name][0].Type == Counter {
+			typ = "counter"
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+
+		for _, metric := range families[name] {
+			labels := make([]string, 0, len(metric.Labels))
+			for _, label := range sortedKeys(metric.Labels) {
+				labels = append(labels, fmt.Sprintf("%s=%q", label, metric.Labels[label]))
+			}
+
+			fmt.Fprintf(w, "%s{%s} %v %d\n", metric.Name, strings.Join(labels, ","),
+				metric.Value, metric.Time.UnixNano()/1e6)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// measuredStore is a memStore measuring its operations
+type measuredStore struct {
+	StoreMetrics
+	memStore
+}
+
+func (s *measuredStore) Get(key []byte) (value []byte, err error) {
+	defer s.Observe(StoreGet, time.Now(), &err)
+	return s.memStore.Get(key)
+}
+
+func (s *measuredStore) Set(key, value []byte) (err error) {
+	defer s.Observe(StoreSet, time.Now(), &err)
+	return s.memStore.Set(key, value)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(10)
+
+	store := &measuredStore{memStore: memStore{data: make(map[string][]byte)}}
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddStore("store", func() Store { return store }))
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(10)))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		s, _ := pc.Store("store")
+		value, _ := record.EncodeValue()
+		s.Get(value)
+		s.Set(value, value)
+		wg.Done()
+	}, "source"))
+
+	m := NewStreams(nil)
+	stream, err := m.Add(b)
+	assert.NoError(t, err)
+	assert.NoError(t, m.Start())
+	wg.Wait()
+	defer m.Close()
+
+	metrics := make(map[string]float64)
+	for x := 0; x < 100 && metrics[`sink/0.5`] == 0; x++ {
+		time.Sleep(time.Millisecond)
+		for _, metric := range stream.Metrics() {
+			if metric.Name != "streams_store_errors_total" && metric.Name != "streams_store_operation_seconds_total" {
+				metrics[metric.Labels["node"]+metric.Labels["store"]+"/"+metric.Labels["quantile"]+metric.Labels["op"]] = metric.Value
+			}
+		}
+	}
+	assert.True(t, metrics["sink/0.5"] > 0)
+	assert.True(t, metrics["sink/0.99"] >= metrics["sink/0.5"])
+	assert.Equal(t, float64(20), metrics["store/get"]+metrics["store/set"])
+
+	w := httptest.NewRecorder()
+	MetricsHandler(m).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+
+	assert.Equal(t, 1, strings.Count(body, "# TYPE streams_node_records_processed_total counter\n"))
+	assert.Equal(t, 1, strings.Count(body, "# TYPE streams_node_processing_latency_seconds gauge\n"))
+	assert.True(t, strings.Contains(body,
+		`streams_store_operations_total{op="get",store="store",stream="stream"} 10 `))
+	assert.True(t, strings.Contains(body,
+		`streams_store_errors_total{op="get",store="store",stream="stream"} 0 `))
+	assert.True(t, strings.Contains(body,
+		`streams_node_processing_latency_seconds{node="sink",quantile="0.9",stream="stream"} `))
+}
@@ -0,0 +1,50 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"github.com/brunotm/streams/types"
+)
+
+// ProcessorMiddleware wraps the Process calls of processors and sinks.
+// A middleware must call next to continue processing the record, and is
+// the hook point for tracing, metrics, validation and panic recovery
+// without modifying individual processors.
+type ProcessorMiddleware func(next ProcessorFunc) ProcessorFunc
+
+// middlewares holds the stream wide and per node middleware chains
+type middlewares struct {
+	all   []ProcessorMiddleware
+	nodes map[string][]ProcessorMiddleware
+}
+
+// handler returns the processor Process wrapped by the stream wide middleware
+// followed by the node middleware. The first middleware is the outermost.
+func (m *middlewares) handler(node *Node, processor Processor) (handler ProcessorFunc) {
+	handler = processor.Process
+
+	if node.typ != types.Processor && node.typ != types.Sink {
+		return handler
+	}
+
+	chain := append(append([]ProcessorMiddleware(nil), m.all...), m.nodes[node.name]...)
+	for x := len(chain) - 1; x >= 0; x-- {
+		handler = chain[x](handler)
+	}
+
+	return handler
+}
@@ -0,0 +1,69 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuilderMiddleware(t *testing.T) {
+	var mtx sync.Mutex
+	var calls []string
+
+	trace := func(name string) ProcessorMiddleware {
+		return func(next ProcessorFunc) ProcessorFunc {
+			return func(pc ProcessorContext, record Record) {
+				mtx.Lock()
+				calls = append(calls, name+":"+pc.NodeName())
+				mtx.Unlock()
+				next(pc, record)
+			}
+		}
+	}
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(1)))
+	assert.NoError(t, b.AddProcessorFunc("map", passthrough, "source"))
+
+	received := make(chan Record, 1)
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		received <- record
+	}, "map"))
+
+	b.Use(trace("outer"), trace("inner"))
+	b.UseFor("sink", trace("node"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for records")
+	}
+	assert.NoError(t, stream.Close())
+
+	assert.Equal(t, []string{
+		"outer:map", "inner:map",
+		"outer:sink", "inner:sink", "node:sink",
+	}, calls)
+}
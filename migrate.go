@@ -0,0 +1,99 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"strconv"
+)
+
+var (
+	errStoreDowngrade = Errorf(CodeStore, "store version is newer than the declared version")
+)
+
+// versionKey is the store key holding the store schema version
+var versionKey = []byte("\x00\x00streams/version")
+
+// Migrator migrates the data of a store between schema versions,
+// e.g. when changing the value format of an aggregation.
+type Migrator interface {
+	Migrate(from, to int, store Store) (err error)
+}
+
+// MigratorFunc is a function Migrator
+type MigratorFunc func(from, to int, store Store) (err error)
+
+// Migrate the store data
+func (f MigratorFunc) Migrate(from, to int, store Store) (err error) {
+	return f(from, to, store)
+}
+
+// storeVersion is the declared schema version and migrator of a store
+type storeVersion struct {
+	version  int
+	migrator Migrator
+}
+
+// StoreVersion declares the schema version of the named store and the migrator
+// upgrading its data from older versions. Migrations run when the Stream starts,
+// after the stores are restored. The version is kept in the store itself, and
+// stores without a version, including new ones, are migrated from version 0.
+func (b *Builder) StoreVersion(store string, version int, migrator Migrator) {
+	b.versions[store] = storeVersion{version: version, migrator: migrator}
+}
+
+// migrateStores migrates the versioned stores to their declared versions
+func (s *Stream) migrateStores() (err error) {
+	for name, declared := range s.versions {
+		node, err := s.store(name)
+		if err != nil {
+			return err
+		}
+
+		if err = migrateStore(node.processor.(Store), declared); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// migrateStore migrates the store from its current version, if older
+func migrateStore(store Store, declared storeVersion) (err error) {
+	var current int
+	value, err := store.Get(versionKey)
+	switch err {
+	case nil:
+		if current, err = strconv.Atoi(string(value)); err != nil {
+			return err
+		}
+	case ErrKeyNotFound:
+	default:
+		return err
+	}
+
+	switch {
+	case current == declared.version:
+		return nil
+	case current > declared.version:
+		return errStoreDowngrade
+	}
+
+	if err = declared.migrator.Migrate(current, declared.version, store); err != nil {
+		return err
+	}
+	return store.Set(versionKey, []byte(strconv.Itoa(declared.version)))
+}
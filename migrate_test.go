@@ -0,0 +1,75 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreVersion(t *testing.T) {
+	store := &memStore{data: map[string][]byte{"a": []byte("1")}}
+	var migrations [][2]int
+
+	build := func(version int) (stream *Stream) {
+		b := NewBuilder("stream", NewConfig(nil))
+		assert.NoError(t, b.AddStore("store", func() Store { return store }))
+		assert.NoError(t, b.AddSource("source", testSourceSupplier(0)))
+		assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {}, "source"))
+		b.StoreVersion("store", version, MigratorFunc(func(from, to int, store Store) error {
+			migrations = append(migrations, [2]int{from, to})
+			value, err := store.Get([]byte("a"))
+			if err != nil {
+				return err
+			}
+			return store.Set([]byte("a"), append(value, '0'))
+		}))
+
+		stream, err := b.Build()
+		assert.NoError(t, err)
+		return stream
+	}
+
+	// unversioned stores are migrated from version 0
+	stream := build(2)
+	assert.NoError(t, stream.Start())
+	assert.NoError(t, stream.Close())
+	assert.Equal(t, [][2]int{{0, 2}}, migrations)
+	assert.Equal(t, "10", string(store.data["a"]))
+	assert.Equal(t, "2", string(store.data[string(versionKey)]))
+
+	// stores at the declared version are not migrated
+	stream = build(2)
+	assert.NoError(t, stream.Start())
+	assert.NoError(t, stream.Close())
+	assert.Len(t, migrations, 1)
+
+	stream = build(3)
+	assert.NoError(t, stream.Start())
+	assert.NoError(t, stream.Close())
+	assert.Equal(t, [][2]int{{0, 2}, {2, 3}}, migrations)
+	assert.Equal(t, "100", string(store.data["a"]))
+
+	// stores are never downgraded
+	stream = build(1)
+	assert.EqualError(t, stream.Start(), "store: "+errStoreDowngrade.Error())
+
+	b := NewBuilder("stream", NewConfig(nil))
+	b.StoreVersion("missing", 1, nil)
+	assert.Equal(t, ErrStoreNotFound, b.Validate())
+}
@@ -17,7 +17,8 @@ package mock
 */
 
 import (
-	"errors"
+	"context"
+	"time"
 
 	"github.com/brunotm/streams"
 )
@@ -29,12 +30,31 @@ var _ streams.ProcessorContext = (*Context)(nil)
 type ContextData struct {
 	Active         bool
 	NodeName       string
+	TaskID         int
 	StreamName     string
 	Config         streams.Config
+	Context        context.Context
+	StreamTime     time.Time
+	Watermark      time.Time
 	Store          streams.Store
+	Resources      map[string]interface{}
+	Cache          *streams.Cache
 	ErrorCount     int
 	ForwardCount   int
 	ForwardToCount int
+	BroadcastCount int
+	HeartbeatCount int
+	Forwarded      []streams.Record
+	ForwardedTo    []string
+	Punctuators    []Punctuator
+}
+
+// Punctuator is a punctuator scheduled in the mock context
+type Punctuator struct {
+	Interval time.Duration
+	Type     streams.PunctuationType
+	Fn       streams.Punctuator
+	Canceled bool
 }
 
 // Context mock
@@ -47,6 +67,11 @@ func (c *Context) NodeName() (name string) {
 	return c.Data.NodeName
 }
 
+// TaskID returns the current task id.
+func (c *Context) TaskID() (id int) {
+	return c.Data.TaskID
+}
+
 // StreamName returns the stream name.
 func (c *Context) StreamName() (name string) {
 	return c.Data.StreamName
@@ -62,29 +87,77 @@ func (c *Context) IsActive() (active bool) {
 	return c.Data.Active
 }
 
+// Context returns the context, or a background context if not set
+func (c *Context) Context() (ctx context.Context) {
+	if c.Data.Context == nil {
+		return context.Background()
+	}
+	return c.Data.Context
+}
+
+// StreamTime returns the stream time
+func (c *Context) StreamTime() (ts time.Time) {
+	return c.Data.StreamTime
+}
+
+// Watermark returns the stream watermark
+func (c *Context) Watermark() (ts time.Time) {
+	return c.Data.Watermark
+}
+
 // Store returns the store with the given name
 func (c *Context) Store(name string) (store streams.Store, err error) {
 	return c.Data.Store, nil
 }
 
+// Cache returns the context cache, created on the first call
+func (c *Context) Cache() (cache *streams.Cache) {
+	if c.Data.Cache == nil {
+		c.Data.Cache = streams.NewCache(streams.DefaultCacheSize, 0)
+	}
+	return c.Data.Cache
+}
+
+// Resource returns the shared resource with the given name
+func (c *Context) Resource(name string) (resource interface{}, err error) {
+	resource, ok := c.Data.Resources[name]
+	if !ok {
+		return nil, streams.ErrResourceNotFound
+	}
+	return resource, nil
+}
+
 // Forward the record to the downstream processors. Can be called multiple times
 // within Processor.Process() in order to send correlated or windowed records.
 func (c *Context) Forward(record streams.Record) (err error) {
 	if !c.Data.Active {
-		return errors.New("invalid forward")
+		return streams.ErrInvalidForward
 	}
 
 	c.Data.ForwardCount++
+	c.Data.Forwarded = append(c.Data.Forwarded, record)
 	return nil
 }
 
 // ForwardTo is like forward, but it forwards the record only to the given node
 func (c *Context) ForwardTo(to string, record streams.Record) (err error) {
 	if !c.Data.Active {
-		return errors.New("invalid forward")
+		return streams.ErrInvalidForward
 	}
 
 	c.Data.ForwardToCount++
+	c.Data.ForwardedTo = append(c.Data.ForwardedTo, to)
+	return nil
+}
+
+// Broadcast is like forward, but it sends the record to every task of the
+// downstream processors, for records that all task instances must observe.
+func (c *Context) Broadcast(record streams.Record) (err error) {
+	if !c.Data.Active {
+		return streams.ErrInvalidForward
+	}
+
+	c.Data.BroadcastCount++
 	return nil
 }
 
@@ -92,3 +165,24 @@ func (c *Context) ForwardTo(to string, record streams.Record) (err error) {
 func (c *Context) Error(err error, records ...streams.Record) {
 	c.Data.ErrorCount++
 }
+
+// Heartbeat reports a successful poll of the upstream system by a source.
+func (c *Context) Heartbeat() {
+	c.Data.HeartbeatCount++
+}
+
+// Schedule records the punctuator, to be called with Punctuate
+func (c *Context) Schedule(interval time.Duration, typ streams.PunctuationType, fn streams.Punctuator) (cancel func()) {
+	idx := len(c.Data.Punctuators)
+	c.Data.Punctuators = append(c.Data.Punctuators, Punctuator{Interval: interval, Type: typ, Fn: fn})
+	return func() { c.Data.Punctuators[idx].Canceled = true }
+}
+
+// Punctuate calls the scheduled punctuators of the given type that were not canceled
+func (c *Context) Punctuate(typ streams.PunctuationType, ts time.Time) {
+	for _, p := range c.Data.Punctuators {
+		if p.Type == typ && !p.Canceled {
+			p.Fn(ts)
+		}
+	}
+}
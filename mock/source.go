@@ -0,0 +1,39 @@
+package mock
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"github.com/brunotm/streams"
+)
+
+// make sure we implement the Source interface
+var _ streams.Source = (*Source)(nil)
+
+// Source mock forwarding the given records once consumed
+type Source struct {
+	Records []streams.Record
+}
+
+// Process is a no-op
+func (s *Source) Process(pc streams.ProcessorContext, record streams.Record) {}
+
+// Consume forwards the source records
+func (s *Source) Consume(pc streams.ProcessorContext) {
+	for _, record := range s.Records {
+		pc.Forward(record)
+	}
+}
@@ -0,0 +1,115 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"time"
+)
+
+var (
+	// ErrAckDeadline is the error of records not acknowledged or nacked
+	// within the ack deadline of their source
+	ErrAckDeadline = Errorf(CodeRecord, "ack deadline exceeded")
+
+	errInvalidAckExpiry = Errorf(CodeConfig, "invalid ack expired action")
+)
+
+// NewRecordNack creates a new record as NewRecord, with a nack function called
+// instead of ack with the error of a Nack of the record or any of its copies,
+// or with ErrAckDeadline if not processed within its source ack deadline.
+// Sources redeliver or discard nacked records as supported by their upstream.
+func NewRecordNack(topic string, key, value Encoder, ts time.Time,
+	ack func() error, nack func(err error) error) (record Record) {
+
+	record = NewRecord(topic, key, value, ts, ack)
+	if record.ack != nil {
+		record.ack.nack = nack
+	}
+	return record
+}
+
+// Nack negatively acknowledges the record, notifying its source with the
+// given error instead of acknowledging it once processed. The source is
+// notified once, and references to the record released after a Nack are
+// ignored. Records of sources without a nack function are neither
+// acknowledged nor notified. Records sharing a source Batch nack the batch.
+func (r Record) Nack(err error) (nerr error) {
+	if r.ack != nil {
+		return r.ack.reject(err)
+	}
+	return nil
+}
+
+// ackDeadline bounds the time for the records forwarded by a source to be
+// acknowledged, set with <stream>.<source>.ack.deadline. Records not
+// acknowledged or nacked within the deadline are nacked with ErrAckDeadline,
+// or, if <stream>.<source>.ack.expired is deadletter, emitted as source errors
+// handled by the stream ErrorHandler and acknowledged once dead lettered.
+type ackDeadline struct {
+	timeout    time.Duration
+	deadLetter bool
+}
+
+func newAckDeadline(config Config) (d *ackDeadline, err error) {
+	timeout := config.Get("deadline").Duration(0)
+	if timeout <= 0 {
+		return nil, nil
+	}
+
+	d = &ackDeadline{timeout: timeout}
+	switch config.Get("expired").String("nack") {
+	case "nack":
+	case "deadletter":
+		d.deadLetter = true
+	default:
+		return nil, errInvalidAckExpiry
+	}
+	return d, nil
+}
+
+// watch the acknowledgment of the record forwarded by the source
+func (d *ackDeadline) watch(pc *processorContext, record Record) {
+	a := record.ack
+	if a == nil || a.expiry.Load() != nil {
+		return
+	}
+
+	a.expiry.Store(time.AfterFunc(d.timeout, func() {
+		if pc.stream.stop.stopped() {
+			return
+		}
+
+		if !d.deadLetter {
+			if err := a.reject(ErrAckDeadline); err != nil {
+				pc.Error(err, record)
+			}
+			return
+		}
+
+		// Acknowledge the source once the expired record is dead lettered
+		if !a.finish() {
+			return
+		}
+
+		dead := record
+		dead.ack = newAcker(a.ack)
+		pc.Error(ErrAckDeadline, dead)
+		if err := dead.ack.release(); err != nil {
+			pc.Error(err, record)
+		}
+	}))
+}
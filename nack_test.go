@@ -0,0 +1,152 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordNack(t *testing.T) {
+	var acks int
+	var nacks []error
+	errFailed := errors.New("failed")
+
+	record := NewRecordNack("topic", nil, StringEncoder("v"), time.Now(),
+		func() error { acks++; return nil },
+		func(err error) error { nacks = append(nacks, err); return nil })
+
+	// the source is nacked once and later releases are ignored
+	record.Retain()
+	assert.NoError(t, record.Nack(errFailed))
+	assert.NoError(t, record.Nack(errFailed))
+	assert.NoError(t, record.Ack())
+	assert.NoError(t, record.Ack())
+	assert.Equal(t, 0, acks)
+	assert.Equal(t, []error{errFailed}, nacks)
+
+	// records without a nack function are neither acked nor notified
+	record = NewRecord("topic", nil, StringEncoder("v"), time.Now(), func() error { acks++; return nil })
+	assert.NoError(t, record.Nack(errFailed))
+	assert.NoError(t, record.Ack())
+	assert.Equal(t, 0, acks)
+	assert.NoError(t, NewRecordNack("topic", nil, StringEncoder("v"), time.Now(), nil, nil).Nack(errFailed))
+}
+
+func TestAckDeadline(t *testing.T) {
+	nacked := make(chan error, 1)
+	var acked int32
+
+	source := &testSource{records: []Record{
+		NewRecordNack("topic", nil, StringEncoder("v"), time.Now(),
+			func() error { atomic.AddInt32(&acked, 1); return nil },
+			func(err error) error { nacked <- err; return nil }),
+	}}
+
+	config := NewConfig(nil)
+	config.Set("20ms", "stream.source.ack.deadline")
+
+	release := make(chan struct{})
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source { return source }))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) { <-release }, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	select {
+	case err = <-nacked:
+		assert.Equal(t, ErrAckDeadline, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("record not nacked")
+	}
+
+	// the record processed after the deadline is not acknowledged
+	close(release)
+	assert.NoError(t, stream.Close())
+	assert.Equal(t, int32(0), atomic.LoadInt32(&acked))
+	assert.Equal(t, 0, stream.InFlight().Records)
+}
+
+func TestAckDeadlineDeadLetter(t *testing.T) {
+	var mtx sync.Mutex
+	var letters []DeadLetter
+	acked := make(chan struct{}, 1)
+
+	source := &testSource{records: []Record{
+		NewRecordNack("topic", nil, StringEncoder("v"), time.Now(),
+			func() error { acked <- struct{}{}; return nil },
+			func(err error) error { t.Error("record nacked"); return nil }),
+	}}
+
+	config := NewConfig(nil)
+	config.Set("20ms", "stream.source.ack.deadline")
+	config.Set("deadletter", "stream.source.ack.expired")
+
+	release := make(chan struct{})
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source { return source }))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) { <-release }, "source"))
+	assert.NoError(t, b.AddDeadLetterSink("dlq", func() Processor {
+		return ProcessorFunc(func(pc ProcessorContext, record Record) {
+			mtx.Lock()
+			letters = append(letters, record.Value.(DeadLetter))
+			mtx.Unlock()
+		})
+	}))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	// the source is acknowledged once the expired record is dead lettered
+	select {
+	case <-acked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("record not acknowledged")
+	}
+
+	mtx.Lock()
+	assert.Len(t, letters, 1)
+	assert.Equal(t, "source", letters[0].Node)
+	assert.Equal(t, ErrAckDeadline.Error(), letters[0].Error)
+	mtx.Unlock()
+
+	close(release)
+	assert.NoError(t, stream.Close())
+	assert.Len(t, acked, 0)
+}
+
+func TestAckDeadlineConfig(t *testing.T) {
+	config := NewConfig(nil)
+	config.Set("1s", "stream.source.ack.deadline")
+	config.Set("retry", "stream.source.ack.expired")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(1)))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.Equal(t, errInvalidAckExpiry, stream.Start())
+}
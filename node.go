@@ -16,7 +16,14 @@ package streams
    limitations under the License.
 */
 
-import "github.com/brunotm/streams/types"
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brunotm/streams/types"
+	"github.com/dgryski/go-jump"
+)
 
 // Node of a topology. Can be a source, sink, or processor node.
 type Node struct {
@@ -27,6 +34,31 @@ type Node struct {
 	supplier     interface{}
 	successors   []*Node
 	predecessors []*Node
+	fanout       *fanout
+	workers      *workers
+	tasks        *tasks
+	stateless    bool
+	fused        []string
+	inbound      []Transformer
+	outbound     []Transformer
+	priorities   map[string]int
+	weights      map[string]int
+	quotas       *topicQuotas
+	metrics      nodeMetrics
+	taps         atomic.Value
+	budget       time.Duration
+	duplicates   *duplicates
+	tenant       TenantExtractor
+	guard        *guard
+	durable      map[*Node]*wal
+	liveness     *liveness
+	ackDeadline  *ackDeadline
+	watermark    *watermark
+	schedule     *scaleSchedule
+	supervisor   *supervisor
+	deadline     time.Duration
+	pause        pause
+	tapsMtx      sync.Mutex
 }
 
 // Name of node
@@ -49,28 +81,100 @@ func (n *Node) Type() (typ types.Type) {
 	return n.typ
 }
 
+// Fused returns the names of the processors fused into this node
+// in processing order, or nil if the node is not a fused node.
+func (n *Node) Fused() (names []string) {
+	return n.fused
+}
+
 // forward the record to node successors
 func (n *Node) forward(record Record) {
 
-	// For each successor, increment its context activation
-	// and process the current record decrementing its activation
-	// afterwards.
+	// Retain a record reference for each successor
+	if record.ack != nil {
+		record.ack.retain(len(n.successors))
+	}
+
+	// Dispatch to the fan-out workers if the node successors
+	// are to be processed concurrently
+	if n.fanout != nil {
+		n.fanout.dispatch(n, record)
+		return
+	}
+
+	for i := 0; i < len(n.successors); i++ {
+		n.successors[i].receive(n.edge(i, record))
+	}
+}
+
+// receive a record for processing. If the node has tasks the record is
+// delivered to the appropriate task, otherwise it is processed inline.
+func (n *Node) receive(record Record) {
+	if n.tasks != nil && n.tasks.deliver(record) {
+		return
+	}
+
+	if n.workers != nil {
+		atomic.AddInt64(&n.workers.queued, 1)
+		n.workers.queue <- record
+		return
+	}
+	n.pc.process(record)
+}
+
+// broadcast the record to every task of the node successors
+func (n *Node) broadcast(record Record) {
 	for i := 0; i < len(n.successors); i++ {
-		n.successors[i].pc.activate()
-		n.successors[i].processor.Process(n.successors[i].pc, record)
-		n.successors[i].pc.deactivate()
+		successor := n.successors[i]
+		if successor.tasks != nil && successor.tasks.broadcast(record) {
+			continue
+		}
+
+		if record.ack != nil {
+			record.ack.retain(1)
+		}
+		successor.pc.process(record)
+	}
+}
+
+// setFanout enables the concurrent processing of records by the node successors
+// using the given number of workers and buffer size per worker.
+// A number of workers lower than 2 keeps the sequential processing of successors.
+func (n *Node) setFanout(workers, buffer int) {
+	n.stopFanout()
+	if workers < 2 || len(n.successors) < 2 {
+		return
+	}
+	n.fanout = newFanout(workers, buffer)
+}
+
+// stopFanout stops the node fan-out workers if any,
+// waiting for all dispatched records to be processed.
+func (n *Node) stopFanout() {
+	if n.fanout != nil {
+		n.fanout.stop()
+		n.fanout = nil
 	}
+}
 
-	// n.pc.stream.topology.walk(
-	// 	n,
-	// 	false,
-	// 	func(sucessor *Node) (ok bool) {
-	// 		sucessor.pc.activate()
-	// 		sucessor.processor.Process(sucessor.pc, record)
-	// 		sucessor.pc.deactivate()
-	// 		return true
-	// 	})
+// setWorkers enables the concurrent processing of the records received by the
+// node using the given number of workers and queue size.
+// A number of workers lower than 2 keeps the inline processing of records.
+func (n *Node) setWorkers(workers, queue int) {
+	n.stopWorkers()
+	if workers < 2 {
+		return
+	}
+	n.workers = newWorkers(n, workers, queue)
+}
 
+// stopWorkers stops the node workers if any,
+// waiting for all queued records to be processed.
+func (n *Node) stopWorkers() {
+	if n.workers != nil {
+		n.workers.stop()
+		n.workers = nil
+	}
 }
 
 // initialize the node and processor with the given context
@@ -78,27 +182,145 @@ func (n *Node) init(pc *processorContext) (err error) {
 	n.pc = pc
 	n.pc.node = n
 
-	// Instatiate the node processor
+	if n.processor, err = n.newProcessor(); err != nil {
+		return err
+	}
+	n.pc.processor = n.processor
+	if pc.stream != nil {
+		n.pc.handler = pc.stream.mws.handler(n, n.processor)
+	}
+
+	// Initialize the processor with the node context
+	if initializer, ok := n.processor.(Initializer); ok {
+		if err = initializer.Init(pc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// instance creates and initializes a new processor instance
+// and context for the given task of the node.
+func (n *Node) instance(s *Stream, task int) (pc *processorContext, err error) {
+	pc = newContext(s)
+	pc.node = n
+	pc.task = task
+
+	if pc.processor, err = n.newProcessor(); err != nil {
+		return nil, err
+	}
+	pc.handler = s.mws.handler(n, pc.processor)
+
+	if initializer, ok := pc.processor.(Initializer); ok {
+		if err = initializer.Init(pc); err != nil {
+			return nil, err
+		}
+	}
+
+	return pc, nil
+}
+
+// newProcessor instantiates the node processor from its supplier
+func (n *Node) newProcessor() (processor Processor, err error) {
 	switch n.typ {
 	case types.Store:
-		n.processor = n.supplier.(StoreSupplier)()
+		return n.supplier.(StoreSupplier)(), nil
 
 	case types.Source:
-		n.processor = n.supplier.(SourceSupplier)()
+		return n.supplier.(SourceSupplier)(), nil
 
 	case types.Processor, types.Sink:
-		n.processor = n.supplier.(ProcessorSupplier)()
+		return n.supplier.(ProcessorSupplier)(), nil
 
 	default:
-		return errInvalidNodeType
+		return nil, errInvalidNodeType
 	}
+}
 
-	// Initialize the processor with the node context
-	if initializer, ok := n.processor.(Initializer); ok {
-		if err = initializer.Init(pc); err != nil {
-			return err
-		}
+// fanout is a bounded pool of workers that process records in the successors
+// of a node concurrently. Ordering of records with the same id is preserved for
+// each successor by always dispatching a given record id and successor pair
+// to the same worker.
+type fanout struct {
+	dispatched int64
+	processed  int64
+	wg         sync.WaitGroup
+	workers    []chan fanoutJob
+}
+
+type fanoutJob struct {
+	node   *Node
+	record Record
+}
+
+func newFanout(workers, buffer int) (f *fanout) {
+	f = &fanout{}
+	f.workers = make([]chan fanoutJob, workers)
+
+	for x := 0; x < workers; x++ {
+		worker := make(chan fanoutJob, buffer)
+		f.workers[x] = worker
+
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			for job := range worker {
+				job.node.receive(job.record)
+				atomic.AddInt64(&f.processed, 1)
+			}
+		}()
 	}
 
-	return nil
+	return f
+}
+
+// dispatch the record to the workers responsible for each successor
+func (f *fanout) dispatch(n *Node, record Record) {
+	for i := 0; i < len(n.successors); i++ {
+		idx := jump.Hash(record.id+uint64(i), len(f.workers))
+		atomic.AddInt64(&f.dispatched, 1)
+		f.workers[idx] <- fanoutJob{n.successors[i], n.edge(i, record)}
+	}
+}
+
+// stop the workers after all dispatched records are processed
+func (f *fanout) stop() {
+	for x := 0; x < len(f.workers); x++ {
+		close(f.workers[x])
+	}
+	f.wg.Wait()
+}
+
+// workers is a bounded pool of workers pulling the records received by
+// a node from a shared queue and processing them with the node context.
+type workers struct {
+	queued    int64
+	processed int64
+	wg        sync.WaitGroup
+	queue     chan Record
+}
+
+func newWorkers(n *Node, count, queue int) (w *workers) {
+	w = &workers{}
+	w.queue = make(chan Record, queue)
+
+	for x := 0; x < count; x++ {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			for record := range w.queue {
+				n.pc.process(record)
+				atomic.AddInt64(&w.processed, 1)
+			}
+		}()
+	}
+
+	return w
+}
+
+// stop the workers after all queued records are processed
+func (w *workers) stop() {
+	close(w.queue)
+	w.wg.Wait()
 }
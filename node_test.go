@@ -0,0 +1,131 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeFanoutOrdering(t *testing.T) {
+	var mtx sync.Mutex
+	seen := make(map[string][]int)
+
+	parent := &Node{name: "parent"}
+	for x := 0; x < 4; x++ {
+		name := "successor" + strconv.Itoa(x)
+		successor := &Node{name: name, pc: &processorContext{}}
+		successor.pc.node = successor
+		successor.pc.processor = ProcessorFunc(func(pc ProcessorContext, record Record) {
+			k, _ := record.EncodeKey()
+			v, _ := record.EncodeValue()
+			n, _ := strconv.Atoi(string(v))
+
+			mtx.Lock()
+			seen[name+string(k)] = append(seen[name+string(k)], n)
+			mtx.Unlock()
+		})
+		parent.successors = append(parent.successors, successor)
+	}
+
+	parent.setFanout(3, 8)
+	assert.NotNil(t, parent.fanout)
+
+	for x := 0; x < 100; x++ {
+		key := StringEncoder(strconv.Itoa(x % 5))
+		value := StringEncoder(strconv.Itoa(x))
+		parent.forward(NewRecord("topic", key, value, time.Now(), nil))
+	}
+	parent.stopFanout()
+	assert.Nil(t, parent.fanout)
+
+	assert.Len(t, seen, 20)
+	for name, values := range seen {
+		assert.Len(t, values, 20, name)
+		for x := 1; x < len(values); x++ {
+			assert.True(t, values[x-1] < values[x], name)
+		}
+	}
+}
+
+// concurrentSink blocks until all of its workers are processing records
+type concurrentSink struct {
+	workers int
+	mtx     sync.Mutex
+	active  int
+	max     int
+	opened  bool
+	release chan struct{}
+	done    sync.WaitGroup
+}
+
+func (s *concurrentSink) Concurrency() (workers, queue int) {
+	return s.workers, 16
+}
+
+func (s *concurrentSink) Process(pc ProcessorContext, record Record) {
+	defer s.done.Done()
+
+	s.mtx.Lock()
+	s.active++
+	if s.active > s.max {
+		s.max = s.active
+	}
+	if s.active == s.workers && !s.opened {
+		s.opened = true
+		close(s.release)
+	}
+	s.mtx.Unlock()
+
+	<-s.release
+
+	s.mtx.Lock()
+	s.active--
+	s.mtx.Unlock()
+}
+
+func TestNodeWorkers(t *testing.T) {
+	sink := &concurrentSink{workers: 4, release: make(chan struct{})}
+	sink.done.Add(20)
+
+	config := NewConfig(nil)
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(20)))
+	assert.NoError(t, b.AddSink("sink", func() Processor { return sink }, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	assert.NotNil(t, stream.topology.getNode("sink").workers)
+
+	sink.done.Wait()
+	assert.NoError(t, stream.Close())
+	assert.Nil(t, stream.topology.getNode("sink").workers)
+	assert.Equal(t, 4, sink.max)
+
+	// config overrides the sink concurrency
+	config.Set(1, "stream.sink.concurrency.workers")
+	stream, err = b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	assert.Nil(t, stream.topology.getNode("sink").workers)
+	assert.NoError(t, stream.Close())
+}
@@ -0,0 +1,250 @@
+package outbox
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+const (
+	// DefaultTable is the default outbox table name
+	DefaultTable = "outbox"
+	// DefaultBatchSize is the default number of rows consumed per transaction
+	DefaultBatchSize = 100
+	// DefaultInterval is the default polling interval when the outbox is empty
+	DefaultInterval = time.Second
+)
+
+var (
+	errInvalidDialect = streams.Errorf(streams.CodeConfig, "outbox dialect must be postgres or mysql")
+	errClosed         = streams.Errorf(streams.CodeState, "outbox source closed")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Source)(nil)
+var _ streams.Source = (*Source)(nil)
+var _ streams.Closer = (*Source)(nil)
+
+// Source polls a outbox table written transactionally by applications along
+// with their own changes, as an alternative to change data capture for databases
+// without logical replication. Each batch of unconsumed rows is locked, forwarded
+// and marked as consumed within a single transaction once all of its records are
+// processed by the stream, so batches are redelivered if the transaction is not
+// committed. Rows locked by other sources are skipped, allowing multiple sources
+// to consume the same table. The table must have the columns:
+//
+//	id          integer primary key, ordering the rows
+//	topic       text
+//	key         bytes or text, nullable
+//	value       bytes or text, nullable
+//	created_at  timestamp
+//	consumed_at timestamp, null while not consumed
+//
+// The database/sql driver must be registered by the application.
+// It is configured through the <stream>.<node> config subtree with the keys:
+//
+//	driver:     database/sql driver name
+//	dsn:        data source name
+//	dialect:    postgres or mysql, defaults to postgres
+//	table:      outbox table name, defaults to outbox
+//	batch.size: maximum number of rows per transaction
+//	interval:   polling interval when the outbox is empty
+type Source struct {
+	mtx      sync.Mutex
+	db       *sql.DB
+	size     int
+	interval time.Duration
+	selectq  string
+	updateq  string
+	args     func(n int) string
+	done     chan struct{}
+}
+
+// Supplier for outbox sources
+func Supplier() (source streams.Source) {
+	return &Source{}
+}
+
+// Init the source and open the database
+func (s *Source) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+
+	table := config.Get("table").String(DefaultTable)
+	s.size = config.Get("batch", "size").Int(DefaultBatchSize)
+	s.interval = config.Get("interval").Duration(DefaultInterval)
+
+	switch config.Get("dialect").String("postgres") {
+	case "postgres":
+		s.args = func(n int) string { return "$" + strconv.Itoa(n) }
+	case "mysql":
+		s.args = func(n int) string { return "?" }
+	default:
+		return errInvalidDialect
+	}
+
+	s.selectq = fmt.Sprintf(
+		"SELECT id, topic, key, value, created_at FROM %s WHERE consumed_at IS NULL "+
+			"ORDER BY id LIMIT %d FOR UPDATE SKIP LOCKED", table, s.size)
+	s.updateq = fmt.Sprintf("UPDATE %s SET consumed_at = %s WHERE id IN (", table, s.args(1))
+
+	if s.db, err = sql.Open(config.Get("driver").String(""), config.Get("dsn").String("")); err != nil {
+		return err
+	}
+
+	s.done = make(chan struct{})
+	return nil
+}
+
+// Process is a no-op for sources
+func (s *Source) Process(pc streams.ProcessorContext, record streams.Record) {}
+
+// Consume the outbox until the source is closed
+func (s *Source) Consume(pc streams.ProcessorContext) {
+	for {
+		n, err := s.consume(pc)
+		if err != nil && err != errClosed {
+			pc.Error(err)
+		}
+
+		// Empty polls keep the source alive for staleness checks
+		if err == nil {
+			pc.Heartbeat()
+		}
+
+		if n > 0 && err == nil {
+			continue
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// Close the source after the batch in progress and close the database
+func (s *Source) Close() (err error) {
+	close(s.done)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.db.Close()
+}
+
+// consume a batch of rows in a transaction, committed only after all of
+// the forwarded records are acknowledged
+func (s *Source) consume(pc streams.ProcessorContext) (n int, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	select {
+	case <-s.done:
+		return 0, errClosed
+	default:
+	}
+
+	acked := make(chan struct{})
+	batch := streams.NewBatch(func() error {
+		close(acked)
+		return nil
+	})
+
+	tx, err := s.db.BeginTx(pc.Context(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	ids, records, err := s.fetch(tx, batch)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 0, tx.Rollback()
+	}
+
+	for _, record := range records {
+		if err = pc.Forward(record); err != nil {
+			return 0, err
+		}
+	}
+	batch.Seal()
+
+	select {
+	case <-acked:
+	case <-s.done:
+		return 0, errClosed
+	case <-pc.Context().Done():
+		return 0, errClosed
+	}
+
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, time.Now())
+	placeholders := make([]string, len(ids))
+	for x, id := range ids {
+		placeholders[x] = s.args(x + 2)
+		args = append(args, id)
+	}
+
+	if _, err = tx.Exec(s.updateq+strings.Join(placeholders, ", ")+")", args...); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// fetch and lock the next batch of unconsumed rows
+func (s *Source) fetch(tx *sql.Tx, batch *streams.Batch) (ids []int64, records []streams.Record, err error) {
+	rows, err := tx.Query(s.selectq)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var topic string
+		var key, value []byte
+		var created time.Time
+
+		if err = rows.Scan(&id, &topic, &key, &value, &created); err != nil {
+			return nil, nil, err
+		}
+
+		ids = append(ids, id)
+		records = append(records, batch.Add(streams.NewRecord(topic,
+			streams.ByteEncoder(key), streams.ByteEncoder(value), created, nil)))
+	}
+
+	return ids, records, rows.Err()
+}
@@ -0,0 +1,193 @@
+package outbox
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/stretchr/testify/assert"
+)
+
+// outboxDriver is a database/sql driver over a in memory outbox table
+type outboxDriver struct {
+	mtx      sync.Mutex
+	rows     [][]driver.Value
+	consumed map[int64]bool
+	queries  []string
+}
+
+var testDriver = &outboxDriver{consumed: make(map[int64]bool)}
+
+func init() {
+	sql.Register("outboxtest", testDriver)
+}
+
+func (d *outboxDriver) Open(name string) (driver.Conn, error) { return &outboxConn{d: d}, nil }
+
+func (d *outboxDriver) count() (consumed int) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return len(d.consumed)
+}
+
+type outboxConn struct {
+	d       *outboxDriver
+	updates []int64
+}
+
+func (c *outboxConn) Prepare(query string) (driver.Stmt, error) { return &outboxStmt{c, query}, nil }
+func (c *outboxConn) Close() error                              { return nil }
+func (c *outboxConn) Begin() (driver.Tx, error)                 { return c, nil }
+
+func (c *outboxConn) Commit() error {
+	c.d.mtx.Lock()
+	defer c.d.mtx.Unlock()
+	for _, id := range c.updates {
+		c.d.consumed[id] = true
+	}
+	c.updates = nil
+	return nil
+}
+
+func (c *outboxConn) Rollback() error {
+	c.updates = nil
+	return nil
+}
+
+type outboxStmt struct {
+	c     *outboxConn
+	query string
+}
+
+func (s *outboxStmt) Close() error  { return nil }
+func (s *outboxStmt) NumInput() int { return -1 }
+
+func (s *outboxStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.c.d.mtx.Lock()
+	s.c.d.queries = append(s.c.d.queries, s.query)
+	s.c.d.mtx.Unlock()
+
+	for _, arg := range args[1:] {
+		s.c.updates = append(s.c.updates, arg.(int64))
+	}
+	return driver.RowsAffected(len(args) - 1), nil
+}
+
+func (s *outboxStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.c.d.mtx.Lock()
+	defer s.c.d.mtx.Unlock()
+	s.c.d.queries = append(s.c.d.queries, s.query)
+
+	var limit int
+	fmt.Sscanf(s.query[strings.Index(s.query, "LIMIT"):], "LIMIT %d", &limit)
+
+	rows := &outboxRows{}
+	for _, row := range s.c.d.rows {
+		if !s.c.d.consumed[row[0].(int64)] && len(rows.rows) < limit {
+			rows.rows = append(rows.rows, row)
+		}
+	}
+	return rows, nil
+}
+
+type outboxRows struct {
+	rows [][]driver.Value
+}
+
+func (r *outboxRows) Columns() []string {
+	return []string{"id", "topic", "key", "value", "created_at"}
+}
+
+func (r *outboxRows) Close() error { return nil }
+
+func (r *outboxRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSource(t *testing.T) {
+	now := time.Now()
+	testDriver.consumed = make(map[int64]bool)
+	testDriver.queries = nil
+	testDriver.rows = [][]driver.Value{
+		{int64(1), "orders", []byte("1"), []byte("created"), now},
+		{int64(2), "orders", []byte("1"), []byte("paid"), now},
+		{int64(3), "users", nil, []byte("updated"), now},
+	}
+
+	config := streams.NewConfig(nil)
+	config.Set("outboxtest", "stream.outbox.driver")
+	config.Set("events", "stream.outbox.table")
+	config.Set(2, "stream.outbox.batch.size")
+	config.Set("10ms", "stream.outbox.interval")
+
+	var mtx sync.Mutex
+	var received []string
+
+	b := streams.NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("outbox", Supplier))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc streams.ProcessorContext, record streams.Record) {
+		key, _ := record.EncodeKey()
+		value, _ := record.EncodeValue()
+		mtx.Lock()
+		received = append(received, record.Topic+":"+string(key)+":"+string(value))
+		mtx.Unlock()
+	}, "outbox"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	for x := 0; x < 200 && testDriver.count() < 3; x++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, stream.Close())
+
+	assert.Equal(t, 3, testDriver.count())
+	assert.Equal(t, []string{"orders:1:created", "orders:1:paid", "users::updated"}, received)
+
+	testDriver.mtx.Lock()
+	defer testDriver.mtx.Unlock()
+	assert.Equal(t, "SELECT id, topic, key, value, created_at FROM events WHERE consumed_at IS NULL "+
+		"ORDER BY id LIMIT 2 FOR UPDATE SKIP LOCKED", testDriver.queries[0])
+	assert.Equal(t, "UPDATE events SET consumed_at = $1 WHERE id IN ($2, $3)", testDriver.queries[1])
+}
+
+func TestSourceDialect(t *testing.T) {
+	config := streams.NewConfig(nil)
+	config.Set("oracle", "stream.outbox.dialect")
+
+	b := streams.NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("outbox", Supplier))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc streams.ProcessorContext, record streams.Record) {}, "outbox"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.Equal(t, errInvalidDialect, stream.Start())
+}
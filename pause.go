@@ -0,0 +1,131 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	errNodePaused    = Errorf(CodeState, "node already paused")
+	errNodeNotPaused = Errorf(CodeState, "node not paused")
+)
+
+// pause gates the processing of a node while paused. Processors and sinks
+// stop taking records from their tasks or predecessors, holding back their
+// upstream, and sources block when forwarding, outside of checkpoints.
+type pause struct {
+	paused  int32
+	mtx     sync.Mutex
+	resumed chan struct{}
+}
+
+// pause the node, returning false if already paused
+func (p *pause) pause() (ok bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.resumed != nil {
+		return false
+	}
+	p.resumed = make(chan struct{})
+	atomic.StoreInt32(&p.paused, 1)
+	return true
+}
+
+// resume the node, returning false if not paused
+func (p *pause) resume() (ok bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.resumed == nil {
+		return false
+	}
+	atomic.StoreInt32(&p.paused, 0)
+	close(p.resumed)
+	p.resumed = nil
+	return true
+}
+
+// isPaused returns if the node is paused
+func (p *pause) isPaused() (paused bool) {
+	return atomic.LoadInt32(&p.paused) == 1
+}
+
+// wait while the node is paused, until resumed or the stream is stopping
+func (p *pause) wait(stopping <-chan struct{}) {
+	if !p.isPaused() {
+		return
+	}
+
+	p.mtx.Lock()
+	resumed := p.resumed
+	p.mtx.Unlock()
+
+	if resumed == nil {
+		return
+	}
+
+	select {
+	case <-resumed:
+	case <-stopping:
+	}
+}
+
+// waitResume blocks the processing while the context node is paused
+func (pc *processorContext) waitResume() {
+	if pc.node.pause.isPaused() {
+		pc.node.pause.wait(pc.stream.stop.stopping())
+	}
+}
+
+// Pause the processing of the named node until resumed. Paused processors and
+// sinks stop processing records, applying backpressure to their predecessors
+// as their task queues fill, and paused sources block when forwarding records.
+// Paused nodes are released when the stream is closed and remain paused
+// when it is restarted.
+func (s *Stream) Pause(name string) (err error) {
+	node := s.topology.getNode(name)
+	if node == nil {
+		return ErrNodeNotFound
+	}
+
+	if !node.pause.pause() {
+		return errNodePaused
+	}
+	return nil
+}
+
+// Resume the processing of the named paused node
+func (s *Stream) Resume(name string) (err error) {
+	node := s.topology.getNode(name)
+	if node == nil {
+		return ErrNodeNotFound
+	}
+
+	if !node.pause.resume() {
+		return errNodeNotPaused
+	}
+	return nil
+}
+
+// Paused returns if the named node is paused
+func (s *Stream) Paused(name string) (paused bool) {
+	node := s.topology.getNode(name)
+	return node != nil && node.pause.isPaused()
+}
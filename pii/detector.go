@@ -0,0 +1,82 @@
+package pii
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"regexp"
+)
+
+// Detector finds personal data within strings
+type Detector struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// Validate optionally confirms matches, filtering false positives
+	Validate func(match string) (ok bool)
+}
+
+// Detectors is the library of builtin detectors by name:
+//
+//	email:      email addresses
+//	creditcard: payment card numbers of 13 to 19 digits, optionally
+//	            separated by spaces or dashes, passing the Luhn check
+//	ssn:        US social security numbers, as 123-45-6789
+//	nino:       UK national insurance numbers, as QQ123456C
+//	phone:      international phone numbers in the E.164 format, as +14155552671
+var Detectors = map[string]Detector{
+	"email": {
+		Name:    "email",
+		Pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	},
+	"creditcard": {
+		Name:     "creditcard",
+		Pattern:  regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`),
+		Validate: luhn,
+	},
+	"ssn": {
+		Name:    "ssn",
+		Pattern: regexp.MustCompile(`\b(?:00[1-9]|0[1-9]\d|[1-578]\d{2}|6[0-57-9]\d|66[0-57-9])-(?:0[1-9]|[1-9]\d)-(?:000[1-9]|00[1-9]\d|0[1-9]\d{2}|[1-9]\d{3})\b`),
+	},
+	"nino": {
+		Name:    "nino",
+		Pattern: regexp.MustCompile(`\b[A-CEGHJ-PR-TW-Z][A-CEGHJ-NPR-TW-Z]\d{6}[A-D]\b`),
+	},
+	"phone": {
+		Name:    "phone",
+		Pattern: regexp.MustCompile(`\+[1-9]\d{7,14}\b`),
+	},
+}
+
+// luhn returns if the digits of the match pass the Luhn checksum
+func luhn(match string) (ok bool) {
+	var sum, digits int
+	for x := len(match) - 1; x >= 0; x-- {
+		c := match[x]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		d := int(c - '0')
+		if digits%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
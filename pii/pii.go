@@ -0,0 +1,337 @@
+package pii
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/jsonpath"
+)
+
+// DefaultMask is the default replacement of masked matches
+const DefaultMask = "***"
+
+// Actions on detected personal data
+const (
+	// ActionMask replaces matches with the mask
+	ActionMask = "mask"
+	// ActionTokenize replaces matches with deterministic tokens of the form
+	// <detector>_<hex>, keyed by the secret, preserving joins and grouping
+	ActionTokenize = "tokenize"
+)
+
+var (
+	errNotDocument   = streams.Errorf(streams.CodeSerialization, "record value is not a json document")
+	errInvalidAction = streams.Errorf(streams.CodeConfig, "invalid pii action")
+	errNoSecret      = streams.Errorf(streams.CodeConfig, "pii tokenize action requires a secret")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Masker)(nil)
+var _ streams.Processor = (*Masker)(nil)
+var _ streams.Instrumented = (*Masker)(nil)
+
+// Masker detects personal data in the string values of json record values,
+// masking or tokenizing the matches. It is configured through the
+// <stream>.<node> config subtree with the keys:
+//
+//	detectors: array of detector names, defaults to all builtin Detectors
+//	patterns:  map of custom detector names to regular expressions
+//	action:    mask or tokenize, defaults to mask
+//	mask:      replacement of masked matches, defaults to DefaultMask
+//	secret:    key of the tokenize action
+//	fields:    array of json paths of the values to scan, defaults to all values
+//	topics:    map of topic names to policies overriding the detectors,
+//	           action, mask and fields keys for the records of the topic
+//
+// An empty detectors array disables the detection, as for topics without
+// personal data. Records whose values are not json are reported as errors.
+// Detections are counted per detector in the streams_pii_detections_total metric.
+type Masker struct {
+	defaults *policy
+	topics   map[string]*policy
+	secret   []byte
+	counts   *counters
+}
+
+// policy of detection for records
+type policy struct {
+	detectors []Detector
+	action    string
+	mask      string
+	fields    []*jsonpath.Path
+}
+
+// Supplier returns a supplier for Masker processors. Processors of the same
+// supplier share their detection counts.
+func Supplier() (supplier streams.ProcessorSupplier) {
+	counts := &counters{detections: make(map[string]*int64)}
+	return func() streams.Processor {
+		return &Masker{counts: counts}
+	}
+}
+
+// Init the processor with its configuration
+func (m *Masker) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	m.secret = []byte(config.Get("secret").String(""))
+
+	library := make(map[string]Detector, len(Detectors))
+	for name, detector := range Detectors {
+		library[name] = detector
+	}
+
+	for name, pattern := range config.Get("patterns").Map() {
+		re, err := regexp.Compile(pattern.String(""))
+		if err != nil {
+			return fmt.Errorf("pii pattern %s: %s", name, err)
+		}
+		library[name] = Detector{Name: name, Pattern: re}
+	}
+
+	if m.defaults, err = m.policy(config, library, nil); err != nil {
+		return err
+	}
+
+	m.topics = make(map[string]*policy)
+	for topic, tc := range config.Get("topics").Map() {
+		if m.topics[topic], err = m.policy(tc, library, m.defaults); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// policy creates the policy from the config, inheriting the unset keys from
+// the parent policy
+func (m *Masker) policy(config streams.Config, library map[string]Detector, parent *policy) (p *policy, err error) {
+	p = &policy{action: ActionMask, mask: DefaultMask}
+	if parent != nil {
+		*p = *parent
+	}
+
+	p.action = config.Get("action").String(p.action)
+	p.mask = config.Get("mask").String(p.mask)
+
+	switch p.action {
+	case ActionMask:
+	case ActionTokenize:
+		if len(m.secret) == 0 {
+			return nil, errNoSecret
+		}
+	default:
+		return nil, errInvalidAction
+	}
+
+	switch {
+	case config.IsSet("detectors"):
+		p.detectors = nil
+		for _, name := range config.Get("detectors").Array() {
+			detector, ok := library[name.String("")]
+			if !ok {
+				return nil, fmt.Errorf("unknown pii detector: %s", name.String(""))
+			}
+			p.detectors = append(p.detectors, detector)
+		}
+	case parent == nil:
+		names := make([]string, 0, len(library))
+		for name := range library {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			p.detectors = append(p.detectors, library[name])
+		}
+	}
+
+	if config.IsSet("fields") {
+		p.fields = nil
+		for _, field := range config.Get("fields").Array() {
+			path, err := jsonpath.Compile(field.String(""))
+			if err != nil {
+				return nil, err
+			}
+			p.fields = append(p.fields, path)
+		}
+	}
+
+	for _, detector := range p.detectors {
+		m.counts.counter(detector.Name)
+	}
+
+	return p, nil
+}
+
+// Process masks the personal data in the record value and forwards the record
+func (m *Masker) Process(pc streams.ProcessorContext, record streams.Record) {
+	p, ok := m.topics[record.Topic]
+	if !ok {
+		p = m.defaults
+	}
+
+	if len(p.detectors) == 0 {
+		pc.Forward(record)
+		return
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	document, err := jsonpath.Decode(value)
+	if err != nil {
+		pc.Error(errNotDocument, record)
+		return
+	}
+
+	var changed bool
+	if p.fields == nil {
+		document, changed = m.scan(p, document)
+	} else {
+		for _, path := range p.fields {
+			field, ok := path.Get(document)
+			if !ok {
+				continue
+			}
+
+			if field, ok = m.scan(p, field); ok {
+				changed = path.Set(document, field) || changed
+			}
+		}
+	}
+
+	// Records without personal data are forwarded untouched
+	if !changed {
+		pc.Forward(record)
+		return
+	}
+
+	if value, err = json.Marshal(document); err != nil {
+		pc.Error(err, record)
+		return
+	}
+	record.Value = streams.ByteEncoder(value)
+	pc.Forward(record)
+}
+
+// scan the json value for personal data, returning the value with the
+// matches replaced and if any was found
+func (m *Masker) scan(p *policy, value interface{}) (result interface{}, changed bool) {
+	switch v := value.(type) {
+	case string:
+		s := m.redact(p, v)
+		return s, s != v
+
+	case map[string]interface{}:
+		for name, field := range v {
+			if field, ok := m.scan(p, field); ok {
+				v[name] = field
+				changed = true
+			}
+		}
+
+	case []interface{}:
+		for x := range v {
+			if field, ok := m.scan(p, v[x]); ok {
+				v[x] = field
+				changed = true
+			}
+		}
+	}
+
+	return value, changed
+}
+
+// redact the matches of the policy detectors in the string
+func (m *Masker) redact(p *policy, s string) (result string) {
+	for _, detector := range p.detectors {
+		detector := detector
+		s = detector.Pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if detector.Validate != nil && !detector.Validate(match) {
+				return match
+			}
+
+			atomic.AddInt64(m.counts.counter(detector.Name), 1)
+			if p.action == ActionTokenize {
+				return m.token(detector.Name, match)
+			}
+			return p.mask
+		})
+	}
+	return s
+}
+
+// token returns the deterministic token of the match
+func (m *Masker) token(detector, match string) (token string) {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(detector))
+	mac.Write([]byte{0})
+	mac.Write([]byte(match))
+	return detector + "_" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// Metrics returns the detection counts per detector
+func (m *Masker) Metrics() (metrics []streams.Metric) {
+	return m.counts.metrics()
+}
+
+// counters are the detection counts per detector
+type counters struct {
+	mtx        sync.Mutex
+	detections map[string]*int64
+}
+
+// counter returns the counter of the detector, creating it if needed
+func (c *counters) counter(detector string) (count *int64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if count = c.detections[detector]; count == nil {
+		count = new(int64)
+		c.detections[detector] = count
+	}
+	return count
+}
+
+// metrics returns the detection counts as metrics
+func (c *counters) metrics() (metrics []streams.Metric) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for detector, count := range c.detections {
+		metrics = append(metrics, streams.Metric{
+			Name:   "streams_pii_detections_total",
+			Type:   streams.Counter,
+			Labels: map[string]string{"detector": detector},
+			Value:  float64(atomic.LoadInt64(count)),
+		})
+	}
+	return metrics
+}
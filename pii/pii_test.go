@@ -0,0 +1,133 @@
+package pii
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMasker(t *testing.T) {
+	config := streams.NewConfig(nil)
+	config.Set("secret", "stream.pii.secret")
+	config.Set([]interface{}{"email", "creditcard", "ssn", "employee"}, "stream", "pii", "detectors")
+	config.Set(map[string]interface{}{"employee": `E\d{6}`}, "stream", "pii", "patterns")
+	config.Set(map[string]interface{}{
+		"users":   map[string]interface{}{"action": "tokenize", "fields": []interface{}{"$.email"}},
+		"metrics": map[string]interface{}{"detectors": []interface{}{}},
+	}, "stream", "pii", "topics")
+
+	pc := &mock.Context{Data: mock.ContextData{
+		Active:     true,
+		StreamName: "stream",
+		NodeName:   "pii",
+		Config:     config,
+	}}
+
+	m := Supplier()()
+	assert.NoError(t, m.(streams.Initializer).Init(pc))
+
+	process := func(topic, value string) string {
+		m.Process(pc, streams.NewRecord(topic, nil, streams.StringEncoder(value), time.Now(), nil))
+		result, _ := pc.Data.Forwarded[len(pc.Data.Forwarded)-1].EncodeValue()
+		return string(result)
+	}
+
+	// all string values are masked by default, luhn invalid card numbers are kept
+	assert.JSONEq(t,
+		`{"note":"mail *** or call","cards":["***","4111 1111 1111 1112"],"id":{"ssn":"***","emp":"***"},"n":4111111111111111}`,
+		process("orders", `{"note":"mail joe@example.com or call","cards":["4111 1111 1111 1111","4111 1111 1111 1112"],"id":{"ssn":"123-45-6789","emp":"E123456"},"n":4111111111111111}`))
+
+	// topic policies tokenize only the given fields
+	users := process("users", `{"email":"joe@example.com","note":"joe@example.com"}`)
+	assert.False(t, strings.Contains(users, `"email":"joe@example.com"`))
+	assert.True(t, strings.Contains(users, `"email":"email_`))
+	assert.True(t, strings.Contains(users, `"note":"joe@example.com"`))
+	assert.Equal(t, users, process("users", `{"email":"joe@example.com","note":"joe@example.com"}`))
+
+	// topics without detectors are forwarded untouched
+	assert.Equal(t, `{"email":"joe@example.com"}`, process("metrics", `{"email":"joe@example.com"}`))
+
+	m.Process(pc, streams.NewRecord("orders", nil, streams.StringEncoder("joe@example.com"), time.Now(), nil))
+	assert.Equal(t, 1, pc.Data.ErrorCount)
+
+	counts := make(map[string]float64)
+	for _, metric := range m.(streams.Instrumented).Metrics() {
+		counts[metric.Labels["detector"]] = metric.Value
+	}
+	assert.Equal(t, map[string]float64{"email": 3, "creditcard": 1, "ssn": 1, "employee": 1}, counts)
+
+	for _, invalid := range []map[string]interface{}{
+		{"action": "drop"},
+		{"action": "tokenize"},
+		{"detectors": []interface{}{"passport"}},
+		{"patterns": map[string]interface{}{"bad": "("}},
+	} {
+		config := streams.NewConfig(map[string]interface{}{"stream": map[string]interface{}{"pii": invalid}})
+		pc := &mock.Context{Data: mock.ContextData{StreamName: "stream", NodeName: "pii", Config: config}}
+		assert.Error(t, Supplier()().(streams.Initializer).Init(pc))
+	}
+}
+
+func TestMaskerMetrics(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	b := streams.NewBuilder("stream", streams.NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() streams.Source {
+		return &valueSource{value: `{"email":"joe@example.com"}`}
+	}))
+	assert.NoError(t, b.AddProcessor("pii", Supplier(), "source"))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc streams.ProcessorContext, record streams.Record) {
+		wg.Done()
+	}, "pii"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+	defer stream.Close()
+
+	var found bool
+	for _, metric := range stream.Metrics() {
+		if metric.Name == "streams_pii_detections_total" && metric.Labels["detector"] == "email" {
+			found = true
+			assert.Equal(t, float64(1), metric.Value)
+			assert.Equal(t, "pii", metric.Labels["node"])
+			assert.Equal(t, "stream", metric.Labels["stream"])
+			assert.False(t, metric.Time.IsZero())
+		}
+	}
+	assert.True(t, found)
+}
+
+// valueSource forwards a single record with the value
+type valueSource struct {
+	value string
+}
+
+func (s *valueSource) Process(pc streams.ProcessorContext, record streams.Record) {}
+
+func (s *valueSource) Consume(pc streams.ProcessorContext) {
+	pc.Forward(streams.NewRecord("test", nil, streams.StringEncoder(s.value), time.Now(), nil))
+}
@@ -0,0 +1,276 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Action taken by the stream for the records of a error
+type Action int
+
+// Error actions
+const (
+	// ActionDeadLetter writes the records to the dead letter store and sink,
+	// if any, and skips them. It is the action of errors of streams without
+	// a ErrorHandler.
+	ActionDeadLetter Action = iota
+	// ActionSkip skips the records
+	ActionSkip
+	// ActionRetry processes the records again after the backoff. Only the
+	// records of errors emitted while processing them can be retried, others
+	// are dead lettered. Records forwarded before the error are forwarded again.
+	ActionRetry
+	// ActionStop stops consuming from the stream sources, which must be closed
+	ActionStop
+)
+
+// String returns the action name
+func (a Action) String() (name string) {
+	switch a {
+	case ActionSkip:
+		return "skip"
+	case ActionRetry:
+		return "retry"
+	case ActionStop:
+		return "stop"
+	}
+	return "deadletter"
+}
+
+// ErrorHandler handles the errors emitted by the stream components, choosing
+// the action taken for their records and, for ActionRetry, the backoff before
+// processing them again. ErrorHandlers must be safe for concurrent use.
+type ErrorHandler interface {
+	HandleError(e Error) (action Action, backoff time.Duration)
+}
+
+// ErrorHandlerFunc adapts a function as a ErrorHandler notified of
+// all errors, taking the ActionDeadLetter action.
+type ErrorHandlerFunc func(e Error)
+
+// HandleError calls the function, dead lettering the error records
+func (f ErrorHandlerFunc) HandleError(e Error) (action Action, backoff time.Duration) {
+	f(e)
+	return ActionDeadLetter, 0
+}
+
+// Policy for the errors of the stream components
+type Policy struct {
+	// Action taken for the records of the errors
+	Action Action
+	// Retries is the maximum number of retries of ActionRetry policies,
+	// after which the records are dead lettered
+	Retries int
+	// Backoff is the delay before the first retry, doubled on each retry
+	Backoff time.Duration
+	// MaxBackoff bounds the delay between retries, if set
+	MaxBackoff time.Duration
+}
+
+// Policies is a ErrorHandler applying the policy of the node emitting the
+// error, or of the error category, or the default policy, in that order.
+type Policies struct {
+	// Default policy, dead lettering the records if unset
+	Default Policy
+	// Nodes are the policies of the errors emitted by the named nodes
+	Nodes map[string]Policy
+	// Categories are the policies of the errors of a Category
+	Categories map[Category]Policy
+	// Notify, if set, is called for all errors before applying the policy
+	Notify func(e Error)
+}
+
+// HandleError applies the error policy
+func (p *Policies) HandleError(e Error) (action Action, backoff time.Duration) {
+	if p.Notify != nil {
+		p.Notify(e)
+	}
+
+	policy, ok := p.Default, false
+	if e.Node != nil {
+		policy, ok = p.Nodes[e.Node.name]
+	}
+	if !ok {
+		if policy, ok = p.Categories[e.Category]; !ok {
+			policy = p.Default
+		}
+	}
+
+	if policy.Action != ActionRetry {
+		return policy.Action, 0
+	}
+
+	if e.Attempt >= policy.Retries {
+		return ActionDeadLetter, 0
+	}
+
+	backoff = policy.Backoff
+	for x := 0; x < e.Attempt && (policy.MaxBackoff <= 0 || backoff < policy.MaxBackoff); x++ {
+		backoff *= 2
+	}
+	if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+	return ActionRetry, backoff
+}
+
+// handleError handles the error with the stream ErrorHandler, returning the
+// action for its records. Errors of streams without a ErrorHandler are dead
+// lettered, and ActionStop stops the stream sources.
+func (s *Stream) handleError(e Error) (action Action, backoff time.Duration) {
+	if s.handler == nil {
+		return ActionDeadLetter, 0
+	}
+
+	if action, backoff = s.handler.HandleError(e); action == ActionStop {
+		s.halt(e.Error)
+	}
+	return action, backoff
+}
+
+// apply the action to the records of the error
+func (s *Stream) apply(action Action, e Error) {
+	switch action {
+	case ActionDeadLetter, ActionRetry:
+		s.deadLetters.write(s, e)
+	}
+}
+
+// Err returns the error which stopped the stream with ActionStop, if any
+func (s *Stream) Err() (err error) {
+	s.stop.mtx.Lock()
+	defer s.stop.mtx.Unlock()
+	return s.stop.err
+}
+
+// stopper signals the stream is stopping, by a ActionStop or by its Close
+type stopper struct {
+	mtx    sync.Mutex
+	err    error
+	closed bool
+	done   chan struct{}
+}
+
+// reset the stopper for a starting stream
+func (st *stopper) reset() {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	st.err = nil
+	st.closed = false
+	st.done = make(chan struct{})
+}
+
+// signal the stream is stopping with the given error, or closing,
+// interrupting the retries in progress. It returns false if already signaled.
+func (st *stopper) signal(err error, closing bool) (ok bool) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	st.closed = st.closed || closing
+	if st.done == nil {
+		st.done = make(chan struct{})
+	}
+
+	select {
+	case <-st.done:
+		return false
+	default:
+	}
+
+	st.err = err
+	close(st.done)
+	return true
+}
+
+// stopping returns the channel closed once the stream is stopping
+func (st *stopper) stopping() (done <-chan struct{}) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	return st.done
+}
+
+// stopped returns if the stream is stopping
+func (st *stopper) stopped() (ok bool) {
+	select {
+	case <-st.stopping():
+		return true
+	default:
+		return false
+	}
+}
+
+// isClosed returns if the stream is closing
+func (st *stopper) isClosed() (ok bool) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	return st.closed
+}
+
+// halt stops consuming from the stream sources after a ActionStop,
+// in the background as the error can be emitted by the sources themselves.
+// Sources are left to the stream Close if it is already closing.
+func (s *Stream) halt(err error) {
+	if !s.stop.signal(err, false) {
+		return
+	}
+
+	go func() {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+
+		if s.stop.isClosed() {
+			return
+		}
+
+		s.stopGating()
+		for _, node := range s.topology.roots {
+			if err := s.tasks.setScale(node, 0); err != nil {
+				atomic.AddInt64(&node.metrics.errors, 1)
+			}
+		}
+	}()
+}
+
+// retryContext is the context given to the processor while processing a
+// record, retrying the processing if a emitted error is retried
+type retryContext struct {
+	*processorContext
+	attempt int
+	retry   bool
+	backoff time.Duration
+	errors  []Error
+}
+
+// Error emits a error event to be handled by the Stream, retrying the
+// record processing if the ErrorHandler chooses ActionRetry.
+func (rc *retryContext) Error(err error, records ...Record) {
+	e := rc.event(err, records, rc.attempt)
+	action, backoff := rc.stream.handleError(e)
+	if action != ActionRetry {
+		rc.stream.apply(action, e)
+		return
+	}
+
+	rc.retry = true
+	rc.errors = append(rc.errors, e)
+	if backoff > rc.backoff {
+		rc.backoff = backoff
+	}
+}
@@ -0,0 +1,142 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicies(t *testing.T) {
+	node := &Node{name: "sink"}
+	p := &Policies{
+		Default:    Policy{Action: ActionSkip},
+		Nodes:      map[string]Policy{"sink": {Action: ActionRetry, Retries: 3, Backoff: 10 * time.Millisecond, MaxBackoff: 25 * time.Millisecond}},
+		Categories: map[Category]Policy{Poison: {Action: ActionStop}},
+	}
+
+	var notified int
+	p.Notify = func(e Error) { notified++ }
+
+	for attempt, expected := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 25 * time.Millisecond} {
+		action, backoff := p.HandleError(Error{Node: node, Attempt: attempt})
+		assert.Equal(t, ActionRetry, action)
+		assert.Equal(t, expected, backoff)
+	}
+
+	// exhausted retries are dead lettered
+	action, _ := p.HandleError(Error{Node: node, Attempt: 3})
+	assert.Equal(t, ActionDeadLetter, action)
+
+	action, _ = p.HandleError(Error{Node: &Node{name: "other"}, Category: Poison})
+	assert.Equal(t, ActionStop, action)
+
+	action, _ = p.HandleError(Error{Category: Transient})
+	assert.Equal(t, ActionSkip, action)
+	assert.Equal(t, 6, notified)
+
+	action, _ = ErrorHandlerFunc(func(e Error) {}).HandleError(Error{})
+	assert.Equal(t, ActionDeadLetter, action)
+}
+
+func TestErrorHandlerRetry(t *testing.T) {
+	var mtx sync.Mutex
+	calls := map[string]int{}
+	attempts := map[string][]int{}
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	errFailed := errors.New("failed")
+	now := time.Now()
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{records: []Record{
+			NewRecord("", StringEncoder("a"), StringEncoder("v"), now, nil),
+			NewRecord("", StringEncoder("b"), StringEncoder("v"), now, nil),
+		}}
+	}))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		key, _ := record.EncodeKey()
+		mtx.Lock()
+		calls[string(key)]++
+		count := calls[string(key)]
+		mtx.Unlock()
+
+		// a succeeds on the third call, b always fails
+		if string(key) == "a" && count == 3 {
+			wg.Done()
+			return
+		}
+		pc.Error(errFailed, record)
+	}, "source"))
+
+	b.ErrorHandler(&Policies{
+		Default: Policy{Action: ActionRetry, Retries: 2, Backoff: time.Millisecond},
+		Notify: func(e Error) {
+			key, _ := e.Record[0].EncodeKey()
+			mtx.Lock()
+			attempts[string(key)] = append(attempts[string(key)], e.Attempt)
+			last := string(key) == "b" && e.Attempt == 2
+			mtx.Unlock()
+			if last {
+				wg.Done()
+			}
+		},
+	})
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+	assert.NoError(t, stream.Close())
+
+	assert.Equal(t, map[string]int{"a": 3, "b": 3}, calls)
+	assert.Equal(t, map[string][]int{"a": {0, 1}, "b": {0, 1, 2}}, attempts)
+	assert.NoError(t, stream.Err())
+}
+
+func TestErrorHandlerStop(t *testing.T) {
+	errFailed := errors.New("failed")
+	source := &testSource{done: make(chan struct{}), next: func(x int) (Record, bool) {
+		time.Sleep(time.Millisecond)
+		return NewRecord("", nil, StringEncoder("v"), time.Now(), nil), true
+	}}
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() Source { return source }))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		pc.Error(errFailed, record)
+	}, "source"))
+	b.ErrorHandler(&Policies{Nodes: map[string]Policy{"sink": {Action: ActionStop}}})
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	select {
+	case <-source.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("source not stopped")
+	}
+
+	assert.Equal(t, errFailed, stream.Err())
+	assert.NoError(t, stream.Close())
+}
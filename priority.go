@@ -0,0 +1,125 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// priorityQueue holds the records with a positive priority delivered to a task.
+// Records are served by descending priority, and in arrival order for records
+// with the same priority. Tasks always serve the priority queue before their
+// buffer, so ordering is only kept among records of the same priority.
+// The queue capacity is configured in <stream>.<node>.tasks.priority and
+// defaults to the task buffer size. Pushing to a full queue blocks until
+// the task serves it.
+type priorityQueue struct {
+	mtx      sync.Mutex
+	space    *sync.Cond
+	capacity int
+	seq      uint64
+	items    priorityItems
+	notify   chan struct{}
+}
+
+type priorityItem struct {
+	seq    uint64
+	record Record
+}
+
+func newPriorityQueue(capacity int) (pq *priorityQueue) {
+	if capacity <= 0 {
+		capacity = DefaultBufferSize
+	}
+
+	pq = &priorityQueue{}
+	pq.space = sync.NewCond(&pq.mtx)
+	pq.capacity = capacity
+	pq.notify = make(chan struct{}, 1)
+	return pq
+}
+
+// push the record and notify the task
+func (pq *priorityQueue) push(record Record) {
+	pq.mtx.Lock()
+	for len(pq.items) >= pq.capacity {
+		pq.space.Wait()
+	}
+
+	pq.seq++
+	heap.Push(&pq.items, priorityItem{seq: pq.seq, record: record})
+	pq.mtx.Unlock()
+
+	select {
+	case pq.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop the highest priority record. It returns false if the queue is empty.
+func (pq *priorityQueue) pop() (record Record, ok bool) {
+	pq.mtx.Lock()
+	defer pq.mtx.Unlock()
+
+	if len(pq.items) == 0 {
+		return record, false
+	}
+
+	pq.space.Signal()
+	return heap.Pop(&pq.items).(priorityItem).record, true
+}
+
+// len returns the number of records in the queue
+func (pq *priorityQueue) len() (n int) {
+	pq.mtx.Lock()
+	defer pq.mtx.Unlock()
+	return len(pq.items)
+}
+
+// priorityItems implements heap.Interface
+type priorityItems []priorityItem
+
+func (p priorityItems) Len() int { return len(p) }
+
+func (p priorityItems) Less(i, j int) bool {
+	if p[i].record.Priority == p[j].record.Priority {
+		return p[i].seq < p[j].seq
+	}
+	return p[i].record.Priority > p[j].record.Priority
+}
+
+func (p priorityItems) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+func (p *priorityItems) Push(x interface{}) { *p = append(*p, x.(priorityItem)) }
+
+func (p *priorityItems) Pop() interface{} {
+	old := *p
+	item := old[len(old)-1]
+	old[len(old)-1] = priorityItem{}
+	*p = old[:len(old)-1]
+	return item
+}
+
+// prioritize sets the priority configured for the record topic in the
+// <stream>.<node>.priority config map, unless the record already has a priority.
+func (n *Node) prioritize(record Record) (result Record) {
+	if record.Priority == 0 && n.priorities != nil {
+		record.Priority = n.priorities[record.Topic]
+	}
+	return record
+}
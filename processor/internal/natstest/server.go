@@ -0,0 +1,347 @@
+package natstest
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Msg is a message published to or stored by the server
+type Msg struct {
+	Subject string
+	Reply   string
+	Header  map[string]string
+	Data    []byte
+}
+
+// Server is a NATS server for tests supporting core subscriptions and
+// publishes, and a JetStream emulation of the stream S with the durable
+// pull consumer C over the messages published to the subjects prefixed
+// with js. Publishes to subjects prefixed with missing have no responders.
+type Server struct {
+	mtx      sync.Mutex
+	listener net.Listener
+	subs     map[*client]map[string]string // sid to subject
+	stored   []*Msg
+	next     int
+	acks     map[int]string
+}
+
+type client struct {
+	mtx sync.Mutex
+	nc  net.Conn
+}
+
+// NewServer starts a server listening on a local address
+func NewServer() (s *Server, err error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s = &Server{listener: listener, subs: map[*client]map[string]string{}, acks: map[int]string{}}
+	go func() {
+		for {
+			nc, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(&client{nc: nc})
+		}
+	}()
+	return s, nil
+}
+
+// URL of the server
+func (s *Server) URL() string {
+	return "nats://" + s.listener.Addr().String()
+}
+
+// Close the server and its client connections
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for c := range s.subs {
+		c.nc.Close()
+	}
+}
+
+// Store a JetStream message
+func (s *Server) Store(subject string, header map[string]string, data string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.stored = append(s.stored, &Msg{Subject: subject, Header: header, Data: []byte(data)})
+}
+
+// Stored returns the stored JetStream messages
+func (s *Server) Stored() (stored []Msg) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, m := range s.stored {
+		stored = append(stored, *m)
+	}
+	return stored
+}
+
+// Subscribed returns if a client is subscribed with the subject pattern
+func (s *Server) Subscribed(pattern string) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, subs := range s.subs {
+		for _, subject := range subs {
+			if subject == pattern {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Ack returns the ack of the JetStream message with the given sequence
+func (s *Server) Ack(seq int) string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.acks[seq]
+}
+
+func (s *Server) serve(c *client) {
+	defer c.nc.Close()
+	c.write(`INFO {"server_id":"test","proto":1,"headers":true,"max_payload":1048576}`+"\r\n", nil)
+
+	s.mtx.Lock()
+	s.subs[c] = map[string]string{}
+	s.mtx.Unlock()
+
+	defer func() {
+		s.mtx.Lock()
+		delete(s.subs, c)
+		s.mtx.Unlock()
+	}()
+
+	r := bufio.NewReader(c.nc)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			c.write("PONG\r\n", nil)
+		case "SUB":
+			s.mtx.Lock()
+			s.subs[c][args[len(args)-1]] = args[1]
+			s.mtx.Unlock()
+		case "UNSUB":
+			// Subscriptions unsubscribed after a number of messages
+			// are removed by the clients once delivered
+			if len(args) == 2 {
+				s.mtx.Lock()
+				delete(s.subs[c], args[1])
+				s.mtx.Unlock()
+			}
+		case "PUB", "HPUB":
+			total, _ := strconv.Atoi(args[len(args)-1])
+			data := make([]byte, total+2)
+			if _, err = io.ReadFull(r, data); err != nil {
+				return
+			}
+
+			m := &Msg{Subject: args[1], Data: data[:total]}
+			if args[0] == "HPUB" {
+				size, _ := strconv.Atoi(args[len(args)-2])
+				m.Header = decodeHeader(m.Data[:size])
+				m.Data = m.Data[size:]
+				args = args[:len(args)-1]
+			}
+			if len(args) == 4 {
+				m.Reply = args[2]
+			}
+			s.publish(m)
+		}
+	}
+}
+
+// nextRequest is a JetStream pull request
+type nextRequest struct {
+	Batch  int  `json:"batch"`
+	NoWait bool `json:"no_wait"`
+}
+
+// publish the message to the matching subscriptions, or handle it
+// as a JetStream api request, ack or publish
+func (s *Server) publish(m *Msg) {
+	switch {
+	case m.Subject == "$JS.API.INFO":
+		s.deliver(m.Reply, m.Reply, "", nil, "", []byte(`{"streams":1,"consumers":1}`))
+
+	case m.Subject == "$JS.API.CONSUMER.INFO.S.C":
+		s.deliver(m.Reply, m.Reply, "", nil, "",
+			[]byte(`{"stream_name":"S","name":"C","config":{"durable_name":"C","ack_policy":"explicit"}}`))
+
+	case m.Subject == "$JS.API.CONSUMER.MSG.NEXT.S.C":
+		var request nextRequest
+		json.Unmarshal(m.Data, &request)
+
+		s.mtx.Lock()
+		var delivered []*Msg
+		for batch := request.Batch; batch > 0 && s.next < len(s.stored); batch-- {
+			stored := *s.stored[s.next]
+			s.next++
+			stored.Reply = fmt.Sprintf("$JS.ACK.S.C.1.%d.%d.%d.0", s.next, s.next, time.Unix(1, 0).UnixNano())
+			delivered = append(delivered, &stored)
+		}
+		s.mtx.Unlock()
+
+		for _, d := range delivered {
+			s.deliver(m.Reply, d.Subject, d.Reply, d.Header, "", d.Data)
+		}
+
+		// Requests waiting for messages expire on the client
+		switch {
+		case len(delivered) == 0 && request.NoWait:
+			s.deliver(m.Reply, m.Reply, "", nil, "404 No Messages", nil)
+		case len(delivered) > 0 && len(delivered) < request.Batch:
+			s.deliver(m.Reply, m.Reply, "", nil, "408 Request Timeout", nil)
+		}
+
+	case strings.HasPrefix(m.Subject, "$JS.ACK."):
+		seq, _ := strconv.Atoi(strings.Split(m.Subject, ".")[5])
+		s.mtx.Lock()
+		s.acks[seq] = string(m.Data)
+		s.mtx.Unlock()
+
+	case strings.HasPrefix(m.Subject, "js.") && m.Reply != "":
+		s.Store(m.Subject, m.Header, string(m.Data))
+		s.mtx.Lock()
+		seq := len(s.stored)
+		s.mtx.Unlock()
+		s.deliver(m.Reply, m.Reply, "", nil, "", []byte(fmt.Sprintf(`{"stream":"S","seq":%d}`, seq)))
+
+	case strings.HasPrefix(m.Subject, "missing.") && m.Reply != "":
+		s.deliver(m.Reply, m.Reply, "", nil, "503", nil)
+
+	default:
+		s.deliver(m.Subject, m.Subject, m.Reply, m.Header, "", m.Data)
+	}
+}
+
+// deliver the message to the clients subscribed to the subject
+func (s *Server) deliver(to, subject, reply string, header map[string]string, status string, data []byte) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if reply != "" {
+		reply += " "
+	}
+
+	for c, subs := range s.subs {
+		for sid, pattern := range subs {
+			if !match(pattern, to) {
+				continue
+			}
+
+			if header == nil && status == "" {
+				c.write(fmt.Sprintf("MSG %s %s %s%d\r\n", subject, sid, reply, len(data)), data)
+				continue
+			}
+
+			hdr := encodeHeader(status, header)
+			c.write(fmt.Sprintf("HMSG %s %s %s%d %d\r\n", subject, sid, reply, len(hdr), len(hdr)+len(data)),
+				append(hdr, data...))
+		}
+	}
+}
+
+func (c *client) write(line string, data []byte) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.nc.Write([]byte(line))
+	if data != nil {
+		c.nc.Write(append(data, '\r', '\n'))
+	}
+}
+
+// encodeHeader encodes the status line and headers of a message
+func encodeHeader(status string, header map[string]string) (data []byte) {
+	var b bytes.Buffer
+	b.WriteString("NATS/1.0")
+	if status != "" {
+		b.WriteString(" " + status)
+	}
+	b.WriteString("\r\n")
+	for name, value := range header {
+		b.WriteString(name + ": " + value + "\r\n")
+	}
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// decodeHeader decodes the headers of a message, keeping the first value
+func decodeHeader(data []byte) (header map[string]string) {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	if _, err := r.ReadLine(); err != nil {
+		return nil
+	}
+
+	header = map[string]string{}
+	for {
+		line, err := r.ReadLine()
+		if err != nil || line == "" {
+			return header
+		}
+
+		if x := strings.IndexByte(line, ':'); x > 0 {
+			name := line[:x]
+			if _, ok := header[name]; !ok {
+				header[name] = strings.TrimSpace(line[x+1:])
+			}
+		}
+	}
+}
+
+// match the subject with the pattern with * and > wildcards
+func match(pattern, subject string) bool {
+	p, s := strings.Split(pattern, "."), strings.Split(subject, ".")
+	for x := range p {
+		switch {
+		case p[x] == ">":
+			return len(s) > x
+		case x >= len(s):
+			return false
+		case p[x] != "*" && p[x] != s[x]:
+			return false
+		}
+	}
+	return len(p) == len(s)
+}
@@ -0,0 +1,125 @@
+package nats
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/brunotm/streams/processor/internal/natstest"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSink(t *testing.T) {
+	server, err := natstest.NewServer()
+	assert.NoError(t, err)
+	defer server.Close()
+
+	config := streams.NewConfig(nil)
+	config.Set(server.URL(), "stream.sink.url")
+	config.Set("Nats-Msg-Id", "stream.sink.key")
+	config.Set(true, "stream.sink.jetstream")
+	config.Set("200ms", "stream.sink.timeout")
+
+	pc := &mock.Context{Data: mock.ContextData{Active: true, StreamName: "stream", NodeName: "sink", Config: config}}
+	sink := Supplier().(*Sink)
+	assert.NoError(t, sink.Init(pc))
+
+	// records are processed as by a stream, releasing their source reference
+	acked := make(chan string, 3)
+	process := func(topic, key string) {
+		record := streams.NewRecord(topic, streams.StringEncoder(key), streams.StringEncoder("v"), time.Now(),
+			func() error { acked <- topic; return nil })
+		sink.Process(pc, record)
+		assert.NoError(t, record.Ack())
+	}
+
+	// records are acknowledged once their publish is acknowledged,
+	// or failed with no responders for subjects without streams
+	process("js.a", "1")
+	process("missing.b", "2")
+
+	received := map[string]bool{}
+	for x := 0; x < 2; x++ {
+		select {
+		case topic := <-acked:
+			received[topic] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("record not acknowledged")
+		}
+	}
+	assert.Equal(t, map[string]bool{"js.a": true, "missing.b": true}, received)
+
+	stored := server.Stored()
+	assert.Len(t, stored, 1)
+	assert.Equal(t, "1", stored[0].Header["Nats-Msg-Id"])
+
+	// unacknowledged publishes expire
+	process("other.c", "3")
+	select {
+	case topic := <-acked:
+		assert.Equal(t, "other.c", topic)
+	case <-time.After(5 * time.Second):
+		t.Fatal("record not expired")
+	}
+	assert.NoError(t, sink.Close())
+
+	assert.Equal(t, 2, pc.Data.ErrorCount)
+}
+
+func TestSinkCore(t *testing.T) {
+	server, err := natstest.NewServer()
+	assert.NoError(t, err)
+	defer server.Close()
+
+	nc, err := nats.Connect(server.URL())
+	assert.NoError(t, err)
+	defer nc.Close()
+
+	ch := make(chan *nats.Msg, 1)
+	_, err = nc.ChanSubscribe("orders.*", ch)
+	assert.NoError(t, err)
+	assert.NoError(t, nc.Flush())
+
+	config := streams.NewConfig(nil)
+	config.Set(server.URL(), "stream.sink.url")
+	config.Set("Key", "stream.sink.key")
+
+	pc := &mock.Context{Data: mock.ContextData{Active: true, StreamName: "stream", NodeName: "sink", Config: config}}
+	sink := Supplier().(*Sink)
+	assert.NoError(t, sink.Init(pc))
+
+	record := streams.NewRecord("orders.eu", streams.StringEncoder("k"), streams.StringEncoder("v"), time.Now(), nil)
+	record.Headers = map[string]string{"Origin": "eu"}
+	sink.Process(pc, record)
+
+	select {
+	case m := <-ch:
+		assert.Equal(t, "orders.eu", m.Subject)
+		assert.Equal(t, "v", string(m.Data))
+		assert.Equal(t, "k", m.Header.Get("Key"))
+		assert.Equal(t, "eu", m.Header.Get("Origin"))
+	case <-time.After(5 * time.Second):
+		t.Fatal("record not published")
+	}
+
+	assert.NoError(t, sink.Close())
+	assert.Equal(t, 0, pc.Data.ErrorCount)
+}
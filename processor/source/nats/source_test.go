@@ -0,0 +1,113 @@
+package nats
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/brunotm/streams/processor/internal/natstest"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSource(t *testing.T) {
+	server, err := natstest.NewServer()
+	assert.NoError(t, err)
+	defer server.Close()
+
+	server.Store("js.a", map[string]string{"Key": "1"}, "x")
+	server.Store("js.b", nil, "y")
+	server.Store("js.c", nil, "z")
+
+	var mtx sync.Mutex
+	var received []string
+	var timestamps []time.Time
+	var wg sync.WaitGroup
+	wg.Add(4)
+
+	config := streams.NewConfig(nil)
+	config.Set(server.URL(), "stream.core.url")
+	config.Set("core.>", "stream.core.subject")
+	config.Set("Key", "stream.core.key")
+	config.Set(server.URL(), "stream.js.url")
+	config.Set("S", "stream.js.stream")
+	config.Set("C", "stream.js.consumer")
+	config.Set("Key", "stream.js.key")
+	config.Set(2, "stream.js.batch")
+	config.Set("50ms", "stream.js.wait")
+	config.Set("10ms", "stream.js.backoff")
+
+	b := streams.NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("core", Supplier))
+	assert.NoError(t, b.AddSource("js", Supplier))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc streams.ProcessorContext, record streams.Record) {
+		key, _ := record.EncodeKey()
+		value, _ := record.EncodeValue()
+
+		// records nacked downstream are redelivered by JetStream
+		if record.Topic == "js.c" {
+			record.Nack(nats.ErrTimeout)
+		}
+
+		mtx.Lock()
+		received = append(received, record.Topic+":"+string(key)+":"+string(value))
+		if record.Topic != "core.eu" {
+			timestamps = append(timestamps, record.Time)
+		}
+		mtx.Unlock()
+		wg.Done()
+	}, "core", "js"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	// wait for the core subscription
+	for x := 0; x < 1000 && !server.Subscribed("core.>"); x++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	nc, err := nats.Connect(server.URL())
+	assert.NoError(t, err)
+	assert.NoError(t, nc.PublishMsg(&nats.Msg{Subject: "core.eu", Header: nats.Header{"Key": {"k"}}, Data: []byte("v")}))
+	nc.Close()
+
+	wg.Wait()
+	for x := 0; x < 1000 && server.Ack(3) == ""; x++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.NoError(t, stream.Close())
+
+	mtx.Lock()
+	assert.ElementsMatch(t, []string{"core.eu:k:v", "js.a:1:x", "js.b::y", "js.c::z"}, received)
+	for _, ts := range timestamps {
+		assert.Equal(t, time.Unix(1, 0), ts)
+	}
+	mtx.Unlock()
+	assert.Equal(t, "+ACK", server.Ack(1))
+	assert.Equal(t, "+ACK", server.Ack(2))
+	assert.Equal(t, "-NAK", server.Ack(3))
+
+	// consumers are required with streams
+	config.Set("", "stream.js.consumer")
+	pc := &mock.Context{Data: mock.ContextData{StreamName: "stream", NodeName: "js", Config: config}}
+	assert.Error(t, Supplier().(streams.Initializer).Init(pc))
+}
@@ -0,0 +1,438 @@
+package protoconv
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/brunotm/streams"
+)
+
+var (
+	errMalformed    = streams.Errorf(streams.CodeSerialization, "malformed protobuf message")
+	errInvalidValue = streams.Errorf(streams.CodeSerialization, "invalid field value")
+)
+
+// wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Unmarshal the protobuf encoded message into a json compatible object
+// following the protobuf json mapping: 64 bit integers are strings, bytes are
+// base64 strings, enums are numbers and nested messages are objects.
+// Unknown fields are skipped.
+func (r *Registry) Unmarshal(message string, data []byte) (object map[string]interface{}, err error) {
+	desc, err := r.descriptor(message)
+	if err != nil {
+		return nil, err
+	}
+	return desc.unmarshal(data)
+}
+
+// Marshal the json compatible object into the protobuf encoded message.
+// Fields not in the message descriptor are ignored.
+func (r *Registry) Marshal(message string, object map[string]interface{}) (data []byte, err error) {
+	desc, err := r.descriptor(message)
+	if err != nil {
+		return nil, err
+	}
+	return desc.marshal(nil, object)
+}
+
+func (d *descriptor) unmarshal(data []byte) (object map[string]interface{}, err error) {
+	object = make(map[string]interface{})
+
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errMalformed
+		}
+		data = data[n:]
+
+		var bits uint64
+		var raw []byte
+		wire := int(tag & 7)
+
+		if data, bits, raw, err = read(wire, data); err != nil {
+			return nil, err
+		}
+
+		field := d.numbers[int(tag>>3)]
+		if field == nil {
+			continue
+		}
+
+		// Packed repeated scalars
+		if wire == wireBytes && field.Repeated && wireType(field.Type) != wireBytes {
+			for len(raw) > 0 {
+				if raw, bits, _, err = read(wireType(field.Type), raw); err != nil {
+					return nil, err
+				}
+
+				value, err := d.value(field, bits, nil)
+				if err != nil {
+					return nil, err
+				}
+				object[field.Name] = append(repeated(object[field.Name]), value)
+			}
+			continue
+		}
+
+		if wire != wireType(field.Type) {
+			return nil, errMalformed
+		}
+
+		value, err := d.value(field, bits, raw)
+		if err != nil {
+			return nil, err
+		}
+
+		if field.Repeated {
+			object[field.Name] = append(repeated(object[field.Name]), value)
+			continue
+		}
+		object[field.Name] = value
+	}
+
+	return object, nil
+}
+
+// read a value of the wire type returning the remaining data
+func read(wire int, data []byte) (rest []byte, bits uint64, raw []byte, err error) {
+	switch wire {
+	case wireVarint:
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, 0, nil, errMalformed
+		}
+		return data[n:], v, nil, nil
+
+	case wireFixed64:
+		if len(data) < 8 {
+			return nil, 0, nil, errMalformed
+		}
+		return data[8:], binary.LittleEndian.Uint64(data), nil, nil
+
+	case wireFixed32:
+		if len(data) < 4 {
+			return nil, 0, nil, errMalformed
+		}
+		return data[4:], uint64(binary.LittleEndian.Uint32(data)), nil, nil
+
+	case wireBytes:
+		l, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < l {
+			return nil, 0, nil, errMalformed
+		}
+		return data[n+int(l):], 0, data[n : n+int(l)], nil
+	}
+
+	return nil, 0, nil, errMalformed
+}
+
+// value decodes the field value from its wire bits or bytes
+func (d *descriptor) value(field *Field, bits uint64, raw []byte) (value interface{}, err error) {
+	switch field.Type {
+	case Double:
+		return math.Float64frombits(bits), nil
+	case Float:
+		return float64(math.Float32frombits(uint32(bits))), nil
+	case Int32, Enum:
+		return int64(int32(bits)), nil
+	case Sfixed32:
+		return int64(int32(uint32(bits))), nil
+	case Uint32, Fixed32:
+		return uint64(uint32(bits)), nil
+	case Sint32:
+		return int64(int32(uint32(bits)>>1) ^ -int32(bits&1)), nil
+	case Int64, Sfixed64:
+		return strconv.FormatInt(int64(bits), 10), nil
+	case Uint64, Fixed64:
+		return strconv.FormatUint(bits, 10), nil
+	case Sint64:
+		return strconv.FormatInt(int64(bits>>1)^-int64(bits&1), 10), nil
+	case Bool:
+		return bits != 0, nil
+	case String:
+		return string(raw), nil
+	case Bytes:
+		return base64.StdEncoding.EncodeToString(raw), nil
+	}
+
+	nested, err := d.registry.descriptor(field.Message)
+	if err != nil {
+		return nil, err
+	}
+	return nested.unmarshal(raw)
+}
+
+func (d *descriptor) marshal(data []byte, object map[string]interface{}) (encoded []byte, err error) {
+	numbers := make([]int, 0, len(d.numbers))
+	for number := range d.numbers {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	for _, number := range numbers {
+		field := d.numbers[number]
+		value, ok := object[field.Name]
+		if !ok || value == nil {
+			continue
+		}
+
+		if !field.Repeated {
+			if data, err = d.encode(data, field, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		values, ok := value.([]interface{})
+		if !ok {
+			return nil, errInvalidValue
+		}
+
+		// Pack repeated scalars
+		if wire := wireType(field.Type); wire != wireBytes {
+			var packed []byte
+			for _, value := range values {
+				if packed, err = d.scalar(packed, field, value); err != nil {
+					return nil, err
+				}
+			}
+			data = appendUvarint(data, uint64(field.Number)<<3|wireBytes)
+			data = appendUvarint(data, uint64(len(packed)))
+			data = append(data, packed...)
+			continue
+		}
+
+		for _, value := range values {
+			if data, err = d.encode(data, field, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// encode the field tag and value
+func (d *descriptor) encode(data []byte, field *Field, value interface{}) (encoded []byte, err error) {
+	wire := wireType(field.Type)
+	data = appendUvarint(data, uint64(field.Number)<<3|uint64(wire))
+
+	if wire != wireBytes {
+		return d.scalar(data, field, value)
+	}
+
+	var raw []byte
+	switch field.Type {
+	case String:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errInvalidValue
+		}
+		raw = []byte(s)
+
+	case Bytes:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errInvalidValue
+		}
+		if raw, err = base64.StdEncoding.DecodeString(s); err != nil {
+			if raw, err = base64.URLEncoding.DecodeString(s); err != nil {
+				return nil, errInvalidValue
+			}
+		}
+
+	default:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errInvalidValue
+		}
+
+		nested, err := d.registry.descriptor(field.Message)
+		if err != nil {
+			return nil, err
+		}
+
+		if raw, err = nested.marshal(nil, object); err != nil {
+			return nil, err
+		}
+	}
+
+	data = appendUvarint(data, uint64(len(raw)))
+	return append(data, raw...), nil
+}
+
+// scalar encodes the numeric or bool field value without its tag
+func (d *descriptor) scalar(data []byte, field *Field, value interface{}) (encoded []byte, err error) {
+	switch field.Type {
+	case Double, Float:
+		f, err := toFloat(value)
+		if err != nil {
+			return nil, err
+		}
+		if field.Type == Float {
+			return appendUint32(data, math.Float32bits(float32(f))), nil
+		}
+		return appendUint64(data, math.Float64bits(f)), nil
+
+	case Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, errInvalidValue
+		}
+		if b {
+			return append(data, 1), nil
+		}
+		return append(data, 0), nil
+
+	case Uint32, Uint64, Fixed32, Fixed64:
+		u, err := toUint(value)
+		if err != nil {
+			return nil, err
+		}
+		switch field.Type {
+		case Fixed32:
+			return appendUint32(data, uint32(u)), nil
+		case Fixed64:
+			return appendUint64(data, u), nil
+		case Uint32:
+			u = uint64(uint32(u))
+		}
+		return appendUvarint(data, u), nil
+	}
+
+	i, err := toInt(value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch field.Type {
+	case Sint32:
+		return appendUvarint(data, uint64(uint32(int32(i)<<1^int32(i)>>31))), nil
+	case Sint64:
+		return appendUvarint(data, uint64(i<<1^i>>63)), nil
+	case Sfixed32:
+		return appendUint32(data, uint32(int32(i))), nil
+	case Sfixed64:
+		return appendUint64(data, uint64(i)), nil
+	case Int32, Enum:
+		i = int64(int32(i))
+	}
+	return appendUvarint(data, uint64(i)), nil
+}
+
+// wireType returns the wire type of the field type
+func wireType(t Type) (wire int) {
+	switch t {
+	case Double, Fixed64, Sfixed64:
+		return wireFixed64
+	case Float, Fixed32, Sfixed32:
+		return wireFixed32
+	case String, Bytes, Message:
+		return wireBytes
+	}
+	return wireVarint
+}
+
+// repeated returns the current values of a repeated field
+func repeated(value interface{}) (values []interface{}) {
+	values, _ = value.([]interface{})
+	return values
+}
+
+func toFloat(value interface{}) (f float64, err error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(v, 64)
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	}
+	return 0, errInvalidValue
+}
+
+func toInt(value interface{}) (i int64, err error) {
+	switch v := value.(type) {
+	case json.Number:
+		return strconv.ParseInt(v.String(), 10, 64)
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case float64:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint64:
+		return int64(v), nil
+	case int:
+		return int64(v), nil
+	}
+	return 0, errInvalidValue
+}
+
+func toUint(value interface{}) (u uint64, err error) {
+	switch v := value.(type) {
+	case json.Number:
+		return strconv.ParseUint(v.String(), 10, 64)
+	case string:
+		return strconv.ParseUint(v, 10, 64)
+	case float64:
+		return uint64(v), nil
+	case uint64:
+		return v, nil
+	case int64:
+		return uint64(v), nil
+	case int:
+		return uint64(v), nil
+	}
+	return 0, errInvalidValue
+}
+
+func appendUvarint(data []byte, v uint64) (encoded []byte) {
+	var buf [binary.MaxVarintLen64]byte
+	return append(data, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendUint32(data []byte, v uint32) (encoded []byte) {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(data, buf[:]...)
+}
+
+func appendUint64(data []byte, v uint64) (encoded []byte) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(data, buf[:]...)
+}
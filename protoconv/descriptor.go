@@ -0,0 +1,133 @@
+package protoconv
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+
+	"github.com/brunotm/streams"
+)
+
+var (
+	// ErrMessageNotFound is returned when the message descriptor is not registered
+	ErrMessageNotFound = streams.Errorf(streams.CodeConfig, "message descriptor not found")
+
+	errInvalidDescriptor = streams.Errorf(streams.CodeConfig, "invalid message descriptor")
+)
+
+// Type of a message field
+type Type int
+
+// Field types
+const (
+	Double Type = iota + 1
+	Float
+	Int32
+	Int64
+	Uint32
+	Uint64
+	Sint32
+	Sint64
+	Fixed32
+	Fixed64
+	Sfixed32
+	Sfixed64
+	Bool
+	String
+	Bytes
+	Enum
+	Message
+)
+
+// Field of a message
+type Field struct {
+	Number   int    // Field number
+	Name     string // Field json name
+	Type     Type   // Field type
+	Repeated bool   // Repeated field
+	Message  string // Full name of the message type of Message fields
+}
+
+// Descriptor of a protobuf message
+type Descriptor struct {
+	Name   string // Full message name, e.g. package.Message
+	Fields []Field
+}
+
+// Registry of message descriptors, safe for concurrent use
+type Registry struct {
+	mtx      sync.RWMutex
+	messages map[string]*descriptor
+}
+
+// descriptor is a registered descriptor indexed by field number and name
+type descriptor struct {
+	name     string
+	numbers  map[int]*Field
+	names    map[string]*Field
+	registry *Registry
+}
+
+// NewRegistry creates an empty descriptor registry
+func NewRegistry() (r *Registry) {
+	r = &Registry{}
+	r.messages = make(map[string]*descriptor)
+	return r
+}
+
+// Register the message descriptor, replacing any descriptor with the same name
+func (r *Registry) Register(d Descriptor) (err error) {
+	if d.Name == "" {
+		return errInvalidDescriptor
+	}
+
+	desc := &descriptor{name: d.Name, registry: r}
+	desc.numbers = make(map[int]*Field, len(d.Fields))
+	desc.names = make(map[string]*Field, len(d.Fields))
+
+	for x := range d.Fields {
+		field := d.Fields[x]
+		if field.Number <= 0 || field.Name == "" || field.Type < Double || field.Type > Message ||
+			(field.Type == Message && field.Message == "") {
+			return errInvalidDescriptor
+		}
+
+		if desc.numbers[field.Number] != nil || desc.names[field.Name] != nil {
+			return errInvalidDescriptor
+		}
+
+		desc.numbers[field.Number] = &field
+		desc.names[field.Name] = &field
+	}
+
+	r.mtx.Lock()
+	r.messages[d.Name] = desc
+	r.mtx.Unlock()
+	return nil
+}
+
+// descriptor returns the registered descriptor for the message
+func (r *Registry) descriptor(name string) (desc *descriptor, err error) {
+	r.mtx.RLock()
+	desc, ok := r.messages[name]
+	r.mtx.RUnlock()
+
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	return desc, nil
+}
@@ -0,0 +1,118 @@
+package protoconv
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/brunotm/streams"
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*ToJSON)(nil)
+var _ streams.Initializer = (*FromJSON)(nil)
+
+// ToJSON converts protobuf encoded record values of a registered message to
+// json objects. The message can be set with the <stream>.<node>.message config.
+type ToJSON struct {
+	registry *Registry
+	message  string
+}
+
+// ToJSONSupplier for ToJSON converters of the given message
+func ToJSONSupplier(registry *Registry, message string) streams.ProcessorSupplier {
+	return func() (processor streams.Processor) {
+		return &ToJSON{registry: registry, message: message}
+	}
+}
+
+// Init the converter
+func (c *ToJSON) Init(pc streams.ProcessorContext) (err error) {
+	c.message = pc.Config().Get(pc.StreamName(), pc.NodeName(), "message").String(c.message)
+	_, err = c.registry.descriptor(c.message)
+	return err
+}
+
+// Process converts the record value and forwards the record
+func (c *ToJSON) Process(pc streams.ProcessorContext, record streams.Record) {
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	object, err := c.registry.Unmarshal(c.message, value)
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	if value, err = json.Marshal(object); err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	record.Value = streams.ByteEncoder(value)
+	pc.Forward(record)
+}
+
+// FromJSON converts json object record values to protobuf encoded values of a
+// registered message. The message can be set with the <stream>.<node>.message config.
+type FromJSON struct {
+	registry *Registry
+	message  string
+}
+
+// FromJSONSupplier for FromJSON converters of the given message
+func FromJSONSupplier(registry *Registry, message string) streams.ProcessorSupplier {
+	return func() (processor streams.Processor) {
+		return &FromJSON{registry: registry, message: message}
+	}
+}
+
+// Init the converter
+func (c *FromJSON) Init(pc streams.ProcessorContext) (err error) {
+	c.message = pc.Config().Get(pc.StreamName(), pc.NodeName(), "message").String(c.message)
+	_, err = c.registry.descriptor(c.message)
+	return err
+}
+
+// Process converts the record value and forwards the record
+func (c *FromJSON) Process(pc streams.ProcessorContext, record streams.Record) {
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	var object map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	if err = decoder.Decode(&object); err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	if value, err = c.registry.Marshal(c.message, object); err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	record.Value = streams.ByteEncoder(value)
+	pc.Forward(record)
+}
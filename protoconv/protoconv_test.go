@@ -0,0 +1,96 @@
+package protoconv
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func registry(t *testing.T) (r *Registry) {
+	r = NewRegistry()
+	assert.NoError(t, r.Register(Descriptor{Name: "test.User", Fields: []Field{
+		{Number: 1, Name: "id", Type: Int64},
+		{Number: 2, Name: "name", Type: String},
+		{Number: 3, Name: "scores", Type: Sint32, Repeated: true},
+		{Number: 4, Name: "address", Type: Message, Message: "test.Address"},
+		{Number: 5, Name: "active", Type: Bool},
+		{Number: 6, Name: "ratio", Type: Double},
+		{Number: 7, Name: "avatar", Type: Bytes},
+	}}))
+	assert.NoError(t, r.Register(Descriptor{Name: "test.Address", Fields: []Field{
+		{Number: 1, Name: "city", Type: String},
+	}}))
+	assert.Error(t, r.Register(Descriptor{Name: "test.Invalid", Fields: []Field{
+		{Number: 1, Name: "a", Type: String},
+		{Number: 1, Name: "b", Type: String},
+	}}))
+	return r
+}
+
+func TestCodec(t *testing.T) {
+	r := registry(t)
+
+	// id: 150, name: "testing", scores: [-1, 2] (packed)
+	data, _ := hex.DecodeString("089601120774657374696e671a020104")
+	object, err := r.Unmarshal("test.User", data)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"id":     "150",
+		"name":   "testing",
+		"scores": []interface{}{int64(-1), int64(2)},
+	}, object)
+
+	encoded, err := r.Marshal("test.User", object)
+	assert.NoError(t, err)
+	assert.Equal(t, data, encoded)
+
+	_, err = r.Unmarshal("test.User", data[:5])
+	assert.Equal(t, errMalformed, err)
+
+	_, err = r.Unmarshal("test.Unknown", data)
+	assert.Equal(t, ErrMessageNotFound, err)
+}
+
+func TestConverters(t *testing.T) {
+	r := registry(t)
+	pc := &mock.Context{Data: mock.ContextData{Active: true, Config: streams.NewConfig(nil)}}
+
+	from := FromJSONSupplier(r, "test.User")()
+	assert.NoError(t, from.(streams.Initializer).Init(pc))
+	to := ToJSONSupplier(r, "test.User")()
+	assert.NoError(t, to.(streams.Initializer).Init(pc))
+
+	value := `{"id":"9007199254740993","name":"joe","scores":[3,-4],"address":{"city":"Lisbon"},` +
+		`"active":true,"ratio":0.5,"avatar":"AQI=","unknown":1}`
+	from.Process(pc, streams.NewRecord("test", nil, streams.StringEncoder(value), time.Now(), nil))
+	assert.Equal(t, 1, pc.Data.ForwardCount)
+
+	to.Process(pc, pc.Data.Forwarded[0])
+	assert.Equal(t, 2, pc.Data.ForwardCount)
+
+	converted, _ := pc.Data.Forwarded[1].EncodeValue()
+	assert.JSONEq(t, `{"id":"9007199254740993","name":"joe","scores":[3,-4],"address":{"city":"Lisbon"},`+
+		`"active":true,"ratio":0.5,"avatar":"AQI="}`, string(converted))
+
+	assert.Equal(t, ErrMessageNotFound, ToJSONSupplier(r, "test.Unknown")().(streams.Initializer).Init(pc))
+}
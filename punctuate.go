@@ -0,0 +1,263 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PunctuationType is the notion of time driving a punctuator
+type PunctuationType int
+
+const (
+	// WallClockTime punctuators are called every interval of the system time
+	WallClockTime PunctuationType = iota
+	// StreamTime punctuators are called as the time of the records processed
+	// by the context advances by the interval. Stream time only advances
+	// when records are processed, and never goes backwards.
+	StreamTime
+)
+
+// String returns the punctuation type name
+func (t PunctuationType) String() string {
+	switch t {
+	case WallClockTime:
+		return "wallclock"
+	case StreamTime:
+		return "streamtime"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	errInvalidPunctuation = Errorf(CodeConfig, "invalid punctuation interval or type")
+)
+
+// Punctuator is called by a schedule with the current wall clock or stream time
+type Punctuator func(ts time.Time)
+
+// punctuation is a scheduled punctuator
+type punctuation struct {
+	typ      PunctuationType
+	interval time.Duration
+	fn       Punctuator
+	next     time.Time
+	done     chan struct{}
+	once     sync.Once
+}
+
+// cancel the punctuation
+func (p *punctuation) cancel() {
+	p.once.Do(func() { close(p.done) })
+}
+
+// canceled returns if the punctuation was canceled
+func (p *punctuation) canceled() (ok bool) {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// punctuations are the punctuators scheduled in a processor context.
+// Once any is scheduled, punctuators and the processing of records by the
+// context are serialized, so punctuators can safely access processor state.
+type punctuations struct {
+	run       sync.Mutex
+	mtx       sync.Mutex
+	scheduled int32
+	list      []*punctuation
+	time      time.Time
+}
+
+// lock serializes the caller with the punctuators of the context,
+// returning false without locking if none were scheduled
+func (ps *punctuations) lock() (locked bool) {
+	if atomic.LoadInt32(&ps.scheduled) == 0 {
+		return false
+	}
+	ps.run.Lock()
+	return true
+}
+
+// add the punctuation to the schedules
+func (ps *punctuations) add(p *punctuation) {
+	ps.mtx.Lock()
+	ps.list = append(ps.list, p)
+	ps.mtx.Unlock()
+	atomic.StoreInt32(&ps.scheduled, 1)
+}
+
+// remove the canceled punctuation from the schedules
+func (ps *punctuations) remove(p *punctuation) {
+	p.cancel()
+
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
+	for x := range ps.list {
+		if ps.list[x] == p {
+			ps.list = append(ps.list[:x], ps.list[x+1:]...)
+			return
+		}
+	}
+}
+
+// current returns the scheduled punctuations
+func (ps *punctuations) current() (list []*punctuation) {
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
+	return append(list, ps.list...)
+}
+
+// since returns the punctuations scheduled after the given ones
+func (ps *punctuations) since(scheduled []*punctuation) (list []*punctuation) {
+	previous := make(map[*punctuation]bool, len(scheduled))
+	for _, p := range scheduled {
+		previous[p] = true
+	}
+
+	for _, p := range ps.current() {
+		if !previous[p] {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// cancel the given punctuations
+func (ps *punctuations) cancel(list []*punctuation) {
+	for _, p := range list {
+		ps.remove(p)
+	}
+}
+
+// due advances the stream time to the given record time, returning the
+// stream time punctuations due and scheduling their next punctuation.
+// Punctuations missed by a jump in the stream time are skipped.
+func (ps *punctuations) due(ts time.Time) (due []*punctuation, now time.Time) {
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
+
+	if ts.IsZero() || !ts.After(ps.time) {
+		return nil, ps.time
+	}
+	ps.time = ts
+
+	for _, p := range ps.list {
+		if p.typ != StreamTime {
+			continue
+		}
+
+		if p.next.IsZero() {
+			p.next = ts.Add(p.interval)
+			continue
+		}
+
+		if ts.Before(p.next) {
+			continue
+		}
+
+		due = append(due, p)
+		p.next = p.next.Add(p.interval * (ts.Sub(p.next)/p.interval + 1))
+	}
+	return due, ts
+}
+
+// Schedule the punctuator to be called every interval of the given time type,
+// returning a function to cancel it. Punctuators are never called concurrently
+// with the processing of records by the context, and can forward records.
+// They are canceled when the context is stopped or the processor restarted.
+func (pc *processorContext) Schedule(interval time.Duration, typ PunctuationType, fn Punctuator) (cancel func()) {
+	p := &punctuation{typ: typ, interval: interval, fn: fn, done: make(chan struct{})}
+	cancel = func() { pc.punct.remove(p) }
+
+	if interval <= 0 || fn == nil || (typ != WallClockTime && typ != StreamTime) {
+		pc.Error(errInvalidPunctuation)
+		p.cancel()
+		return cancel
+	}
+
+	pc.punct.add(p)
+	if typ == WallClockTime {
+		go pc.tick(p)
+	}
+	return cancel
+}
+
+// tick calls the wall clock punctuation every interval until it is canceled
+// or the context stopped
+func (pc *processorContext) tick(p *punctuation) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-pc.Context().Done():
+			pc.punct.remove(p)
+			return
+		case now := <-ticker.C:
+			pc.punct.run.Lock()
+			if !p.canceled() {
+				pc.punctuate(p, now)
+			}
+			pc.punct.run.Unlock()
+		}
+	}
+}
+
+// advance the stream time of the context to the record time and call the
+// stream time punctuations due. Must be called with the punctuations locked.
+func (pc *processorContext) advance(record Record) {
+	due, now := pc.punct.due(record.Time)
+	for _, p := range due {
+		if !p.canceled() {
+			pc.punctuate(p, now)
+		}
+	}
+}
+
+// punctuate calls the punctuator within the active context, emitting
+// punctuator panics as PanicErrors and restarting supervised processors
+func (pc *processorContext) punctuate(p *punctuation, ts time.Time) {
+	pc.activate()
+	defer pc.deactivate()
+
+	if pc.callPunctuator(p, ts) != nil {
+		pc.supervise()
+	}
+}
+
+// callPunctuator calls the punctuator, returning the PanicError if it panics
+func (pc *processorContext) callPunctuator(p *punctuation, ts time.Time) (failure error) {
+	defer func() {
+		if r := recover(); r != nil {
+			failure = &PanicError{Value: r, Stack: debug.Stack()}
+			pc.Error(failure)
+		}
+	}()
+
+	p.fn(ts)
+	return nil
+}
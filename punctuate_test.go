@@ -0,0 +1,122 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPunctuations(t *testing.T) {
+	ps := &punctuations{}
+	p := &punctuation{typ: StreamTime, interval: time.Minute, done: make(chan struct{})}
+	ps.add(p)
+	assert.True(t, ps.lock())
+	ps.run.Unlock()
+
+	t0 := time.Unix(1000, 0)
+	for _, c := range []struct {
+		ts  time.Time
+		due int
+	}{
+		{t0, 0},
+		{t0.Add(59 * time.Second), 0},
+		{t0.Add(30 * time.Second), 0},
+		{t0.Add(time.Minute), 1},
+		{t0.Add(5 * time.Minute), 1},
+		{t0.Add(5*time.Minute + 59*time.Second), 0},
+		{time.Time{}, 0},
+		{t0.Add(6 * time.Minute), 1},
+	} {
+		due, now := ps.due(c.ts)
+		assert.Len(t, due, c.due, c.ts)
+		if c.due > 0 {
+			assert.Equal(t, c.ts, now)
+		}
+	}
+
+	ps.remove(p)
+	assert.True(t, p.canceled())
+	assert.Len(t, ps.current(), 0)
+}
+
+func TestStreamPunctuators(t *testing.T) {
+	var mtx sync.Mutex
+	var punctuated []time.Time
+	var ticks int
+
+	t0 := time.Unix(1000, 0)
+	source := &testSource{}
+	for _, offset := range []time.Duration{0, 30 * time.Second, 61 * time.Second, 200 * time.Second} {
+		source.records = append(source.records,
+			NewRecord("", StringEncoder("k"), StringEncoder("v"), t0.Add(offset), nil))
+	}
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() Source { return source }))
+	assert.NoError(t, b.AddProcessor("processor", func() Processor {
+		return &initProcessor{
+			init: func(pc ProcessorContext) {
+				pc.Schedule(time.Minute, StreamTime, func(ts time.Time) {
+					mtx.Lock()
+					punctuated = append(punctuated, ts)
+					mtx.Unlock()
+				})
+
+				var cancel func()
+				mtx.Lock()
+				defer mtx.Unlock()
+				cancel = pc.Schedule(time.Millisecond, WallClockTime, func(ts time.Time) {
+					assert.NoError(t, pc.Forward(NewRecord("", nil, StringEncoder("tick"), ts, nil)))
+					mtx.Lock()
+					defer mtx.Unlock()
+					cancel()
+				})
+			},
+			process: func(pc ProcessorContext, record Record) {},
+		}
+	}, "source"))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		mtx.Lock()
+		ticks++
+		mtx.Unlock()
+	}, "processor"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mtx.Lock()
+		done := ticks > 0 && len(punctuated) == 2
+		mtx.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.NoError(t, stream.Close())
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.Equal(t, []time.Time{t0.Add(61 * time.Second), t0.Add(200 * time.Second)}, punctuated)
+	assert.Equal(t, 1, ticks)
+}
@@ -0,0 +1,301 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPushInterval is the default interval for pushing metrics
+	DefaultPushInterval = 10 * time.Second
+)
+
+var (
+	errInvalidPusher = Errorf(CodeConfig, "invalid metrics pusher")
+)
+
+// MetricsPusher pushes the stream metrics to external systems
+type MetricsPusher interface {
+	Push(metrics []Metric) (err error)
+}
+
+// newMetricsPusher creates the metrics pusher configured with
+// <stream>.metrics.push.type and <stream>.metrics.push.address:
+//
+//	graphite: plaintext protocol to the address host:port
+//	emf:      CloudWatch embedded metric format lines to the address file, or stdout if empty
+//	otlp:     OTLP/HTTP JSON to the address url
+//
+// It returns nil if no pusher is configured.
+func newMetricsPusher(s *Stream) (pusher MetricsPusher, err error) {
+	config := s.config.Get(s.name, "metrics", "push")
+	address := config.Get("address").String("")
+
+	switch config.Get("type").String("") {
+	case "":
+		return nil, nil
+	case "graphite":
+		return &graphitePusher{address: address, prefix: config.Get("prefix").String("streams")}, nil
+	case "emf":
+		return &emfPusher{path: address, namespace: config.Get("namespace").String("streams")}, nil
+	case "otlp":
+		return &otlpPusher{url: address, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	}
+
+	return nil, errInvalidPusher
+}
+
+// metricsPush periodically pushes the stream metrics until stopped
+type metricsPush struct {
+	stream *Stream
+	pusher MetricsPusher
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// startMetricsPush starts pushing metrics on every <stream>.metrics.push.interval
+func startMetricsPush(s *Stream, pusher MetricsPusher) (mp *metricsPush) {
+	mp = &metricsPush{stream: s, pusher: pusher, done: make(chan struct{})}
+	interval := s.config.Get(s.name, "metrics", "push", "interval").Duration(DefaultPushInterval)
+
+	mp.wg.Add(1)
+	go func() {
+		defer mp.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-mp.done:
+				return
+			case <-ticker.C:
+				mp.push()
+			}
+		}
+	}()
+
+	return mp
+}
+
+// push the current metrics, emitting push errors to the stream error handler
+func (mp *metricsPush) push() {
+	if err := mp.pusher.Push(mp.stream.Metrics()); err != nil {
+		mp.stream.handleError(Error{Error: err, Category: CategoryOf(err)})
+	}
+}
+
+// stop pushing metrics, pushing the final metrics
+func (mp *metricsPush) stop() {
+	close(mp.done)
+	mp.wg.Wait()
+	mp.push()
+}
+
+// graphitePusher pushes metrics with the graphite plaintext protocol as
+// <prefix>.<stream>.<node>.<name> <value> <timestamp>
+type graphitePusher struct {
+	address string
+	prefix  string
+}
+
+func (g *graphitePusher) Push(metrics []Metric) (err error) {
+	conn, err := net.DialTimeout("tcp", g.address, 10*time.Second)
+	if err != nil {
+		return Classify(err, Transient)
+	}
+	defer conn.Close()
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		path := []string{g.prefix}
+		for _, label := range sortedKeys(m.Labels) {
+			path = append(path, graphiteSanitize(m.Labels[label]))
+		}
+		path = append(path, m.Name)
+
+		fmt.Fprintf(&buf, "%s %s %d\n", strings.Join(path, "."),
+			strconv.FormatFloat(m.Value, 'f', -1, 64), m.Time.Unix())
+	}
+
+	_, err = conn.Write(buf.Bytes())
+	return Classify(err, Transient)
+}
+
+func graphiteSanitize(s string) (sanitized string) {
+	return strings.NewReplacer(".", "_", " ", "_").Replace(s)
+}
+
+// emfPusher writes metrics as CloudWatch embedded metric format log lines,
+// one line per label set
+type emfPusher struct {
+	mtx       sync.Mutex
+	path      string
+	namespace string
+}
+
+func (e *emfPusher) Push(metrics []Metric) (err error) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	var w io.Writer = os.Stdout
+	if e.path != "" {
+		f, err := os.OpenFile(e.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return Classify(err, Resource)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	var order []string
+	groups := make(map[string][]Metric)
+	for _, m := range metrics {
+		key := labelsKey(m.Labels)
+		if _, exists := groups[key]; !exists {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], m)
+	}
+
+	enc := json.NewEncoder(w)
+	for _, key := range order {
+		group := groups[key]
+		dimensions := sortedKeys(group[0].Labels)
+
+		var names []map[string]string
+		line := make(map[string]interface{})
+		for label, value := range group[0].Labels {
+			line[label] = value
+		}
+		for _, m := range group {
+			names = append(names, map[string]string{"Name": m.Name})
+			line[m.Name] = m.Value
+		}
+
+		line["_aws"] = map[string]interface{}{
+			"Timestamp": group[0].Time.UnixNano() / int64(time.Millisecond),
+			"CloudWatchMetrics": []map[string]interface{}{{
+				"Namespace":  e.namespace,
+				"Dimensions": [][]string{dimensions},
+				"Metrics":    names,
+			}},
+		}
+
+		if err = enc.Encode(line); err != nil {
+			return Classify(err, Resource)
+		}
+	}
+
+	return nil
+}
+
+// otlpPusher pushes metrics to a OTLP/HTTP endpoint with the JSON encoding
+type otlpPusher struct {
+	url    string
+	client *http.Client
+}
+
+func (o *otlpPusher) Push(metrics []Metric) (err error) {
+	var stream string
+	var points []map[string]interface{}
+
+	for _, m := range metrics {
+		stream = m.Labels["stream"]
+
+		var attributes []map[string]interface{}
+		for _, label := range sortedKeys(m.Labels) {
+			attributes = append(attributes, map[string]interface{}{
+				"key": label, "value": map[string]string{"stringValue": m.Labels[label]}})
+		}
+
+		point := []map[string]interface{}{{
+			"attributes":   attributes,
+			"timeUnixNano": strconv.FormatInt(m.Time.UnixNano(), 10),
+			"asDouble":     m.Value,
+		}}
+
+		metric := map[string]interface{}{"name": m.Name}
+		if m.Type == Counter {
+			metric["sum"] = map[string]interface{}{
+				"dataPoints": point, "aggregationTemporality": 2, "isMonotonic": true}
+		} else {
+			metric["gauge"] = map[string]interface{}{"dataPoints": point}
+		}
+		points = append(points, metric)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceMetrics": []map[string]interface{}{{
+			"resource": map[string]interface{}{
+				"attributes": []map[string]interface{}{{
+					"key": "service.name", "value": map[string]string{"stringValue": stream}}},
+			},
+			"scopeMetrics": []map[string]interface{}{{
+				"scope":   map[string]string{"name": "streams"},
+				"metrics": points,
+			}},
+		}},
+	})
+	if err != nil {
+		return Classify(err, Permanent)
+	}
+
+	resp, err := o.client.Post(o.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return Classify(err, Transient)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		err = fmt.Errorf("otlp push: %s", resp.Status)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return Classify(err, Transient)
+		}
+		return Classify(err, Permanent)
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]string) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func labelsKey(labels map[string]string) (key string) {
+	var sb strings.Builder
+	for _, k := range sortedKeys(labels) {
+		sb.WriteString(k + "=" + labels[k] + ",")
+	}
+	return sb.String()
+}
@@ -0,0 +1,150 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testMetrics() []Metric {
+	labels := map[string]string{"stream": "stream", "node": "sink.1"}
+	now := time.Unix(100, 0)
+	return []Metric{
+		{Name: "streams_node_records_processed_total", Type: Counter, Labels: labels, Value: 10, Time: now},
+		{Name: "streams_node_tasks", Type: Gauge, Labels: labels, Value: 2, Time: now},
+	}
+}
+
+func TestStreamMetricsPush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "emf.log")
+
+	config := NewConfig(nil)
+	config.Set("emf", "stream", "metrics", "push", "type")
+	config.Set(path, "stream", "metrics", "push", "address")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(10)))
+
+	var wg sync.WaitGroup
+	wg.Add(10)
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		wg.Done()
+	}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+
+	// counters are updated after the records are processed
+	metrics := make(map[string]float64)
+	for x := 0; x < 100 && metrics["sink/streams_node_records_processed_total"] < 10; x++ {
+		time.Sleep(time.Millisecond)
+		for _, m := range stream.Metrics() {
+			metrics[m.Labels["node"]+"/"+m.Name] = m.Value
+		}
+	}
+	assert.NoError(t, stream.Close())
+
+	assert.Equal(t, float64(10), metrics["source/streams_node_records_forwarded_total"])
+	assert.Equal(t, float64(10), metrics["sink/streams_node_records_processed_total"])
+
+	// the final metrics are pushed on close
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	// one line per label set: the source, sink, sink latency quantiles
+	// and the stream watermark of the timed source records
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 6)
+
+	var line map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &line))
+	assert.Equal(t, "sink", line["node"])
+	assert.Equal(t, float64(10), line["streams_node_records_processed_total"])
+	assert.NotNil(t, line["_aws"])
+}
+
+func TestGraphitePusher(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+
+	pusher := &graphitePusher{address: ln.Addr().String(), prefix: "streams"}
+	assert.NoError(t, pusher.Push(testMetrics()))
+	assert.Equal(t, []string{
+		"streams.sink_1.stream.streams_node_records_processed_total 10 100",
+		"streams.sink_1.stream.streams_node_tasks 2 100",
+	}, <-received)
+}
+
+func TestOTLPPusher(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+
+	pusher := &otlpPusher{url: server.URL, client: server.Client()}
+	assert.NoError(t, pusher.Push(testMetrics()))
+
+	scope := body["resourceMetrics"].([]interface{})[0].(map[string]interface{})["scopeMetrics"]
+	metrics := scope.([]interface{})[0].(map[string]interface{})["metrics"].([]interface{})
+	assert.Len(t, metrics, 2)
+	assert.NotNil(t, metrics[0].(map[string]interface{})["sum"])
+	assert.NotNil(t, metrics[1].(map[string]interface{})["gauge"])
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	pusher = &otlpPusher{url: failing.URL, client: failing.Client()}
+	assert.Equal(t, Transient, CategoryOf(pusher.Push(testMetrics())))
+}
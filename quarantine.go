@@ -0,0 +1,178 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+const (
+	// DefaultQuarantineThreshold is the default number of consecutive
+	// failures of a record key before it is quarantined
+	DefaultQuarantineThreshold = 3
+)
+
+var (
+	// ErrQuarantined is emitted as a Poison error when a record key is quarantined
+	ErrQuarantined = Errorf(CodeRecord, "record key quarantined")
+)
+
+// Quarantine returns a ProcessorMiddleware detecting poison records. Processing
+// failures, either errors emitted or panics recovered while processing a record,
+// are counted per record key and reset on success. Once a key reaches the failure
+// threshold its records are no longer processed and are forwarded to the dlq node
+// instead, or dropped if empty, and a ErrQuarantined Poison error is emitted.
+// Failure counts are kept per node and key in the named store, or in memory if empty.
+// Records without a key are never quarantined.
+func Quarantine(threshold int, store, dlq string) (middleware ProcessorMiddleware) {
+	if threshold < 1 {
+		threshold = DefaultQuarantineThreshold
+	}
+
+	memory := &failureCounts{counts: make(map[string]int)}
+
+	return func(next ProcessorFunc) ProcessorFunc {
+		return func(pc ProcessorContext, record Record) {
+			key, err := record.EncodeKey()
+			if err != nil || len(key) == 0 {
+				next(pc, record)
+				return
+			}
+			node := append([]byte(pc.NodeName()+"/"), key...)
+
+			var counts failureStore = memory
+			if store != "" {
+				st, err := pc.Store(store)
+				if err != nil {
+					pc.Error(err, record)
+					next(pc, record)
+					return
+				}
+				counts = storeFailures{st}
+			}
+
+			failures, err := counts.get(node)
+			if err != nil {
+				pc.Error(err, record)
+			}
+
+			if failures >= threshold {
+				if dlq != "" {
+					if err = pc.ForwardTo(dlq, record); err != nil {
+						pc.Error(err, record)
+					}
+				}
+				return
+			}
+
+			qc := &quarantineContext{ProcessorContext: pc}
+			qc.process(next, record)
+
+			switch {
+			case !qc.failed && failures > 0:
+				err = counts.set(node, 0)
+			case qc.failed:
+				failures++
+				err = counts.set(node, failures)
+				if failures >= threshold {
+					pc.Error(Classify(Errorf(CodeRecord, "%s: %w", key, ErrQuarantined), Poison), record)
+				}
+			}
+
+			if err != nil {
+				pc.Error(err, record)
+			}
+		}
+	}
+}
+
+// quarantineContext records if errors were emitted while processing a record
+type quarantineContext struct {
+	ProcessorContext
+	failed bool
+}
+
+// Error emits a error event to be handled by the Stream.
+func (qc *quarantineContext) Error(err error, records ...Record) {
+	qc.failed = true
+	qc.ProcessorContext.Error(err, records...)
+}
+
+// process the record recovering from panics as failures
+func (qc *quarantineContext) process(next ProcessorFunc, record Record) {
+	defer func() {
+		if r := recover(); r != nil {
+			qc.Error(fmt.Errorf("panic: %v", r), record)
+		}
+	}()
+	next(qc, record)
+}
+
+// failureStore keeps the failure counts per key
+type failureStore interface {
+	get(key []byte) (count int, err error)
+	set(key []byte, count int) (err error)
+}
+
+// failureCounts keeps the failure counts in memory
+type failureCounts struct {
+	mtx    sync.Mutex
+	counts map[string]int
+}
+
+func (f *failureCounts) get(key []byte) (count int, err error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.counts[string(key)], nil
+}
+
+func (f *failureCounts) set(key []byte, count int) (err error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if count == 0 {
+		delete(f.counts, string(key))
+		return nil
+	}
+	f.counts[string(key)] = count
+	return nil
+}
+
+// storeFailures keeps the failure counts in a Store
+type storeFailures struct {
+	store Store
+}
+
+func (s storeFailures) get(key []byte) (count int, err error) {
+	value, err := s.store.Get(key)
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(value))
+}
+
+func (s storeFailures) set(key []byte, count int) (err error) {
+	if count == 0 {
+		return s.store.Delete(key)
+	}
+	return s.store.Set(key, []byte(strconv.Itoa(count)))
+}
@@ -0,0 +1,76 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuarantine(t *testing.T) {
+	var mtx sync.Mutex
+	var processed, quarantined []string
+	var errs []Error
+	var wg sync.WaitGroup
+	wg.Add(6)
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: keyedRecords("bad", "good", "bad", "good", "bad", "bad")}
+	}))
+	assert.NoError(t, b.AddProcessor("work", func() Processor {
+		return ProcessorFunc(func(pc ProcessorContext, record Record) {
+			defer wg.Done()
+			key, _ := record.EncodeKey()
+			if string(key) == "bad" {
+				panic("poison")
+			}
+			mtx.Lock()
+			processed = append(processed, string(key))
+			mtx.Unlock()
+		})
+	}, "source"))
+	assert.NoError(t, b.AddSinkFunc("dlq", func(pc ProcessorContext, record Record) {
+		defer wg.Done()
+		v, _ := record.EncodeValue()
+		mtx.Lock()
+		quarantined = append(quarantined, string(v))
+		mtx.Unlock()
+	}, "work"))
+
+	b.UseFor("work", Quarantine(2, "", "dlq"))
+	b.ErrorHandler(ErrorHandlerFunc(func(e Error) {
+		mtx.Lock()
+		errs = append(errs, e)
+		mtx.Unlock()
+	}))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+	assert.NoError(t, stream.Close())
+
+	assert.Equal(t, []string{"good", "good"}, processed)
+	assert.Equal(t, []string{"4", "5"}, quarantined)
+
+	// two recovered panics and the quarantine alert
+	assert.Len(t, errs, 3)
+	assert.Equal(t, Poison, errs[2].Category)
+}
@@ -0,0 +1,145 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// DefaultQueryLimit is the default maximum number of entries of store range queries
+const DefaultQueryLimit = 1000
+
+var (
+	errQueryLimit = Errorf(CodeState, "query limit reached")
+)
+
+// QueryEntry is a key value pair of a store range query
+type QueryEntry struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// QueryResult is the result of a store range query, truncated
+// if the range has more entries than the query limit
+type QueryResult struct {
+	Entries   []QueryEntry `json:"entries"`
+	Truncated bool         `json:"truncated"`
+}
+
+// query the replica of the named store, closing it once done
+// if created by a Replicator store
+func (s *Stream) query(name string, fn func(store ROStore) error) (err error) {
+	node, err := s.store(name)
+	if err != nil {
+		return err
+	}
+
+	replicator, ok := node.processor.(Replicator)
+	if !ok {
+		return fn(node.processor.(ROStore))
+	}
+
+	replica, err := replicator.Replica()
+	if err != nil {
+		return err
+	}
+
+	err = fn(replica)
+	if closer, ok := replica.(Closer); ok {
+		if e := closer.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// queryStore replies with the raw value of the key in the named store, or
+// with the entries within the from and to query parameters, or with the
+// prefix query parameter, up to the limit query parameter as json
+func queryStore(w http.ResponseWriter, r *http.Request, s *Stream, name string, key *string) {
+	if key != nil {
+		var value []byte
+		err := s.query(name, func(store ROStore) (err error) {
+			value, err = store.Get([]byte(*key))
+			return err
+		})
+
+		if err != nil {
+			queryError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(value)
+		return
+	}
+
+	params := r.URL.Query()
+	limit := DefaultQueryLimit
+	if l := params.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result := QueryResult{Entries: []QueryEntry{}}
+	collect := func(key, value []byte) (err error) {
+		if len(result.Entries) == limit {
+			result.Truncated = true
+			return errQueryLimit
+		}
+
+		result.Entries = append(result.Entries, QueryEntry{
+			Key: append([]byte(nil), key...), Value: append([]byte(nil), value...)})
+		return nil
+	}
+
+	err := s.query(name, func(store ROStore) (err error) {
+		if prefix, ok := params["prefix"]; ok {
+			return store.RangePrefix([]byte(prefix[0]), collect)
+		}
+
+		var from, to []byte
+		if f := params.Get("from"); f != "" {
+			from = []byte(f)
+		}
+		if t := params.Get("to"); t != "" {
+			to = []byte(t)
+		}
+		return store.Range(from, to, collect)
+	})
+
+	if err != nil && !errors.Is(err, errQueryLimit) {
+		queryError(w, err)
+		return
+	}
+	writeJSON(w, result)
+}
+
+// queryError replies with the error of a store query
+func queryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrStoreNotFound), errors.Is(err, ErrKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
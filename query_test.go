@@ -0,0 +1,141 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rangeStore is a memStore iterating within key ranges and prefixes
+type rangeStore struct {
+	memStore
+}
+
+func (s *rangeStore) Range(from, to []byte, cb func(key, value []byte) error) error {
+	return s.memStore.Range(nil, nil, func(key, value []byte) error {
+		if (from != nil && bytes.Compare(key, from) < 0) || (to != nil && bytes.Compare(key, to) >= 0) {
+			return nil
+		}
+		return cb(key, value)
+	})
+}
+
+func (s *rangeStore) RangePrefix(prefix []byte, cb func(key, value []byte) error) error {
+	return s.memStore.Range(nil, nil, func(key, value []byte) error {
+		if !bytes.HasPrefix(key, prefix) {
+			return nil
+		}
+		return cb(key, value)
+	})
+}
+
+// replicatedStore returns itself as a replica, counting the replicas closed
+type replicatedStore struct {
+	rangeStore
+	closed int32
+}
+
+func (s *replicatedStore) Replica() (ROStore, error) {
+	return &storeReplica{s}, nil
+}
+
+type storeReplica struct {
+	*replicatedStore
+}
+
+func (r *storeReplica) Close() error {
+	atomic.AddInt32(&r.closed, 1)
+	return nil
+}
+
+func TestQueryStore(t *testing.T) {
+	store := &replicatedStore{rangeStore: rangeStore{memStore{data: map[string][]byte{}}}}
+	for _, key := range []string{"a/1", "a/2", "b/1", "c/1"} {
+		store.data[key] = []byte("v" + key)
+	}
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddStore("store", func() Store { return store }))
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(0)))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {}, "source"))
+
+	m := NewStreams(nil)
+	_, err := m.Add(b)
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(StreamsHandler(m))
+	defer server.Close()
+
+	get := func(path string) (code int, body []byte) {
+		resp, err := http.Get(server.URL + path)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ = ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+
+	query := func(path string) (result QueryResult) {
+		code, body := get(path)
+		assert.Equal(t, http.StatusOK, code)
+		assert.NoError(t, json.Unmarshal(body, &result))
+		return result
+	}
+
+	keys := func(result QueryResult) (keys []string) {
+		for _, entry := range result.Entries {
+			keys = append(keys, string(entry.Key))
+		}
+		return keys
+	}
+
+	code, body := get("/streams/stream/stores/store/a/2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "va/2", string(body))
+
+	code, _ = get("/streams/stream/stores/store/none")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = get("/streams/stream/stores/none/a")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = get("/streams/stream/stores/store?limit=0")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	result := query("/streams/stream/stores/store")
+	assert.Equal(t, []string{"a/1", "a/2", "b/1", "c/1"}, keys(result))
+	assert.Equal(t, "vc/1", string(result.Entries[3].Value))
+	assert.False(t, result.Truncated)
+
+	result = query("/streams/stream/stores/store?from=a/2&to=c")
+	assert.Equal(t, []string{"a/2", "b/1"}, keys(result))
+
+	result = query("/streams/stream/stores/store?prefix=a/&limit=1")
+	assert.Equal(t, []string{"a/1"}, keys(result))
+	assert.True(t, result.Truncated)
+
+	result = query("/streams/stream/stores/store?prefix=d")
+	assert.Len(t, result.Entries, 0)
+
+	// replicas are closed after each query
+	assert.Equal(t, int32(6), atomic.LoadInt32(&store.closed))
+}
@@ -0,0 +1,195 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// defaultTopic is the topic key of the weights and quotas applied to
+// topics without their own configuration
+const defaultTopic = "*"
+
+// fairQueue holds the records delivered to a task in a bounded queue per topic
+// and serves the topics in weighted round robin, so a chatty topic cannot
+// starve the others sharing the task. Each turn serves up to the topic weight
+// records. Pushing to a full topic queue blocks until the task serves it.
+type fairQueue struct {
+	mtx      sync.Mutex
+	space    *sync.Cond
+	capacity int
+	weights  map[string]int
+	queues   map[string][]Record
+	active   []string
+	next     int
+	served   int
+	notify   chan struct{}
+}
+
+func newFairQueue(capacity int, weights map[string]int) (fq *fairQueue) {
+	if capacity <= 0 {
+		capacity = DefaultBufferSize
+	}
+
+	fq = &fairQueue{}
+	fq.space = sync.NewCond(&fq.mtx)
+	fq.capacity = capacity
+	fq.weights = weights
+	fq.queues = make(map[string][]Record)
+	fq.notify = make(chan struct{}, 1)
+	return fq
+}
+
+// len returns the number of records in the queue
+func (fq *fairQueue) len() (n int) {
+	fq.mtx.Lock()
+	defer fq.mtx.Unlock()
+
+	for _, queue := range fq.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// weight returns the weight of the topic, defaulting to the * topic weight or 1
+func (fq *fairQueue) weight(topic string) (weight int) {
+	weight, ok := fq.weights[topic]
+	if !ok {
+		weight = fq.weights[defaultTopic]
+	}
+
+	if weight < 1 {
+		weight = 1
+	}
+	return weight
+}
+
+// push the record to its topic queue and notify the task
+func (fq *fairQueue) push(record Record) {
+	fq.mtx.Lock()
+	for len(fq.queues[record.Topic]) >= fq.capacity {
+		fq.space.Wait()
+	}
+
+	queue := fq.queues[record.Topic]
+	if len(queue) == 0 {
+		fq.active = append(fq.active, record.Topic)
+	}
+	fq.queues[record.Topic] = append(queue, record)
+	fq.mtx.Unlock()
+
+	select {
+	case fq.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop the next record in weighted round robin. It returns false if the queue is empty.
+func (fq *fairQueue) pop() (record Record, ok bool) {
+	fq.mtx.Lock()
+	defer fq.mtx.Unlock()
+
+	if len(fq.active) == 0 {
+		return record, false
+	}
+
+	topic := fq.active[fq.next]
+	queue := fq.queues[topic]
+	record = queue[0]
+	queue[0] = Record{}
+	fq.served++
+
+	switch {
+	case len(queue) == 1:
+		delete(fq.queues, topic)
+		fq.active = append(fq.active[:fq.next], fq.active[fq.next+1:]...)
+		fq.served = 0
+	case fq.served >= fq.weight(topic):
+		fq.queues[topic] = queue[1:]
+		fq.next++
+		fq.served = 0
+	default:
+		fq.queues[topic] = queue[1:]
+	}
+
+	if fq.next >= len(fq.active) {
+		fq.next = 0
+	}
+
+	fq.space.Broadcast()
+	return record, true
+}
+
+// topicQuotas limits the rate of records per topic forwarded by a source
+// with a token bucket per topic
+type topicQuotas struct {
+	mtx     sync.Mutex
+	rates   map[string]float64
+	buckets map[string]*bucket
+}
+
+// bucket is a token bucket of a rate per second with a burst of one second of records
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTopicQuotas(rates map[string]float64) (q *topicQuotas) {
+	return &topicQuotas{rates: rates, buckets: make(map[string]*bucket)}
+}
+
+// wait until the topic is within its quota
+func (q *topicQuotas) wait(topic string) {
+	if delay := q.reserve(topic, time.Now()); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// reserve a record of the topic, returning the delay until it is within its quota
+func (q *topicQuotas) reserve(topic string, now time.Time) (delay time.Duration) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	b, exists := q.buckets[topic]
+	if !exists {
+		rate, ok := q.rates[topic]
+		if !ok {
+			rate = q.rates[defaultTopic]
+		}
+
+		if rate <= 0 {
+			return 0
+		}
+
+		burst := math.Max(rate, 1)
+		b = &bucket{rate: rate, burst: burst, tokens: burst, last: now}
+		q.buckets[topic] = b
+	}
+
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
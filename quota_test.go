@@ -0,0 +1,116 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFairQueue(t *testing.T) {
+	fq := newFairQueue(10, map[string]int{"chatty": 2})
+
+	for x := 0; x < 6; x++ {
+		fq.push(NewRecord("chatty", nil, nil, time.Now(), nil))
+	}
+	fq.push(NewRecord("quiet", nil, nil, time.Now(), nil))
+	fq.push(NewRecord("quiet", nil, nil, time.Now(), nil))
+	assert.Equal(t, 8, fq.len())
+
+	var topics []string
+	for {
+		record, ok := fq.pop()
+		if !ok {
+			break
+		}
+		topics = append(topics, record.Topic)
+	}
+
+	assert.Equal(t, []string{"chatty", "chatty", "quiet", "chatty", "chatty", "quiet", "chatty", "chatty"}, topics)
+
+	// pushes to a full topic queue block until served
+	fq = newFairQueue(1, nil)
+	fq.push(NewRecord("chatty", nil, nil, time.Now(), nil))
+	fq.push(NewRecord("quiet", nil, nil, time.Now(), nil))
+
+	pushed := make(chan struct{})
+	go func() {
+		fq.push(NewRecord("chatty", nil, nil, time.Now(), nil))
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("push to a full topic queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	record, _ := fq.pop()
+	assert.Equal(t, "chatty", record.Topic)
+	<-pushed
+	assert.Equal(t, 2, fq.len())
+}
+
+func TestTopicQuotas(t *testing.T) {
+	q := newTopicQuotas(map[string]float64{"chatty": 2, defaultTopic: 0.5})
+	now := time.Now()
+
+	assert.Equal(t, time.Duration(0), q.reserve("chatty", now))
+	assert.Equal(t, time.Duration(0), q.reserve("chatty", now))
+	assert.Equal(t, 500*time.Millisecond, q.reserve("chatty", now))
+	assert.Equal(t, time.Duration(0), q.reserve("chatty", now.Add(time.Second)))
+
+	assert.Equal(t, time.Duration(0), q.reserve("other", now))
+	assert.Equal(t, 2*time.Second, q.reserve("other", now))
+
+	assert.Equal(t, time.Duration(0), newTopicQuotas(nil).reserve("chatty", now))
+}
+
+func TestStreamTopicWeights(t *testing.T) {
+	var mtx sync.Mutex
+	var processed []string
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	config := NewConfig(nil)
+	config.Set(1, "stream.sink.tasks.count")
+	config.Set(map[string]interface{}{"test": 2}, "stream.sink.weights")
+	config.Set(map[string]interface{}{"test": 1000}, "stream.source.quota")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: keyedRecords("a", "b", "c")}
+	}))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		key, _ := record.EncodeKey()
+		mtx.Lock()
+		processed = append(processed, string(key))
+		mtx.Unlock()
+		wg.Done()
+	}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+	assert.NoError(t, stream.Close())
+
+	assert.Equal(t, []string{"a", "b", "c"}, processed)
+}
@@ -0,0 +1,116 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultReadinessInterval is the default interval between the checks
+	// of the dependencies of a gated source
+	DefaultReadinessInterval = 100 * time.Millisecond
+	// DefaultReadinessTimeout is the default time a gated source waits
+	// for its dependencies before giving up starting
+	DefaultReadinessTimeout = 5 * time.Minute
+)
+
+var (
+	// ErrNotReady is emitted by a gated source whose dependencies
+	// are not ready within the readiness timeout
+	ErrNotReady = Errorf(CodeState, "dependencies not ready")
+)
+
+// readiness gates the activation of sources until their dependencies are ready
+type readiness struct {
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+// gate starts the source once its dependencies are ready, checking them at the
+// <stream>.readiness.interval up to the <stream>.readiness.timeout.
+// Sources not ready within the timeout emit ErrNotReady and are not started.
+func (s *Stream) gate(source *Node, dependencies []string) {
+	if s.gating == nil {
+		s.gating = &readiness{done: make(chan struct{})}
+	}
+
+	interval := s.config.Get(s.name, "readiness", "interval").Duration(DefaultReadinessInterval)
+	timeout := s.config.Get(s.name, "readiness", "timeout").Duration(DefaultReadinessTimeout)
+	gating := s.gating
+
+	gating.wg.Add(1)
+	go func() {
+		defer gating.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		deadline := time.Now().Add(timeout)
+
+		for !s.ready(dependencies) {
+			if time.Now().After(deadline) {
+				source.pc.Error(ErrNotReady)
+				return
+			}
+
+			select {
+			case <-gating.done:
+				return
+			case <-ticker.C:
+			}
+		}
+
+		if err := s.startSource(source); err != nil {
+			source.pc.Error(err)
+		}
+	}()
+}
+
+// ready returns if all the named nodes and stores are initialized and ready.
+// Stores added at runtime are initialized and restored on their first check.
+func (s *Stream) ready(names []string) (ok bool) {
+	for _, name := range names {
+		var processor Processor
+
+		if node := s.topology.getNode(name); node != nil {
+			if processor = node.processor; processor == nil {
+				return false
+			}
+		} else {
+			node, err := s.store(name)
+			if err != nil {
+				return false
+			}
+			processor = node.processor
+		}
+
+		if readier, ok := processor.(Readier); ok && !readier.Ready() {
+			return false
+		}
+	}
+	return true
+}
+
+// stopGating stops waiting for the dependencies of gated sources
+func (s *Stream) stopGating() {
+	if s.gating != nil {
+		close(s.gating.done)
+		s.gating.wg.Wait()
+		s.gating = nil
+	}
+}
@@ -0,0 +1,95 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readySink is a sink counting the processed records once ready
+type readySink struct {
+	ready     *int32
+	processed *int32
+}
+
+func (s *readySink) Ready() bool { return atomic.LoadInt32(s.ready) > 0 }
+
+func (s *readySink) Process(pc ProcessorContext, record Record) {
+	atomic.AddInt32(s.processed, 1)
+}
+
+func TestReadinessGating(t *testing.T) {
+	var ready, processed int32
+
+	config := NewConfig(nil)
+	config.Set("5ms", "stream.readiness.interval")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(10)))
+	assert.NoError(t, b.AddSink("sink", func() Processor {
+		return &readySink{ready: &ready, processed: &processed}
+	}, "source"))
+
+	b.DependsOn("source", "missing")
+	assert.Equal(t, ErrNodeNotFound, b.Validate())
+	b.dependencies["source"] = nil
+	b.DependsOn("source", "sink")
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&processed))
+
+	atomic.StoreInt32(&ready, 1)
+	for atomic.LoadInt32(&processed) < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.NoError(t, stream.Close())
+}
+
+func TestReadinessTimeout(t *testing.T) {
+	var ready, processed int32
+	errs := make(chan Error, 1)
+
+	config := NewConfig(nil)
+	config.Set("5ms", "stream.readiness.interval")
+	config.Set("20ms", "stream.readiness.timeout")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(10)))
+	assert.NoError(t, b.AddSink("sink", func() Processor {
+		return &readySink{ready: &ready, processed: &processed}
+	}, "source"))
+	b.DependsOn("source", "sink")
+	b.ErrorHandler(ErrorHandlerFunc(func(e Error) { errs <- e }))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	e := <-errs
+	assert.Equal(t, ErrNotReady, e.Error)
+	assert.Equal(t, "source", e.Node.Name())
+	assert.Equal(t, int32(0), atomic.LoadInt32(&processed))
+	assert.NoError(t, stream.Close())
+}
@@ -0,0 +1,115 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sort"
+)
+
+var (
+	// ErrReadOnlyUnavailable is returned when requesting a store of a read only
+	// stream not implementing the ReadOnlyOpener interface.
+	ErrReadOnlyUnavailable = Errorf(CodeStore, "store read only view not available")
+)
+
+// ReadOnlyStream is a stream attached in read only mode to the state of a
+// stream running in another process, for interactive queries and backups.
+type ReadOnlyStream struct {
+	name   string
+	stores map[string]ROStore
+}
+
+// OpenReadOnly attaches to the state of the stream of the builder in read only
+// mode, opening a view of each store implementing the ReadOnlyOpener interface
+// with the builder configuration, as the stores of the stream owning the state
+// would be initialized. Sources, processors and sinks are neither initialized
+// nor started. The stream must be closed after use, closing the store views.
+func OpenReadOnly(b *Builder) (stream *ReadOnlyStream, err error) {
+	if err = b.Validate(); err != nil {
+		return nil, err
+	}
+
+	s := &Stream{name: b.name, config: b.config}
+	stream = &ReadOnlyStream{name: b.name}
+	stream.stores = make(map[string]ROStore, len(b.topology.stores))
+
+	for name, node := range b.topology.stores {
+		store := node.supplier.(StoreSupplier)()
+		opener, ok := store.(ReadOnlyOpener)
+		if !ok {
+			stream.stores[name] = nil
+			continue
+		}
+
+		pc := newContext(s)
+		pc.node = node
+		pc.processor = store
+
+		if stream.stores[name], err = opener.OpenReadOnly(pc); err != nil {
+			stream.Close()
+			return nil, err
+		}
+	}
+
+	return stream, nil
+}
+
+// Name returns the stream name
+func (s *ReadOnlyStream) Name() (name string) {
+	return s.name
+}
+
+// Stores returns the names of the stream stores in name order
+func (s *ReadOnlyStream) Stores() (names []string) {
+	for name := range s.stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Store returns the read only view of the store with the given name.
+// Stores not implementing the ReadOnlyOpener interface fail with
+// ErrReadOnlyUnavailable.
+func (s *ReadOnlyStream) Store(name string) (store ROStore, err error) {
+	store, ok := s.stores[name]
+	switch {
+	case !ok:
+		return nil, ErrStoreNotFound
+	case store == nil:
+		return nil, ErrReadOnlyUnavailable
+	}
+	return store, nil
+}
+
+// Close the store views, returning the errors of the views failing to close
+func (s *ReadOnlyStream) Close() (err error) {
+	var errs Errors
+	for name, store := range s.stores {
+		if closer, ok := store.(Closer); ok {
+			if err = closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		delete(s.stores, name)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
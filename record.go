@@ -17,6 +17,9 @@ package streams
 */
 
 import (
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgryski/go-wyhash"
@@ -24,38 +27,210 @@ import (
 
 // Record represents a single record within a stream
 type Record struct {
-	id    uint64       // ID is a internal ID calculated over the record Value
-	Topic string       // Topic to wich this Record is associated
-	Key   Encoder      // Record Key
-	Value Encoder      // Record Value
-	Time  time.Time    // Record time
-	ack   func() error // Ack Record source of its processing. Initially no-op.
+	id       uint64            // ID is a internal ID calculated over the record Value
+	Topic    string            // Topic to wich this Record is associated
+	Key      Encoder           // Record Key
+	Value    Encoder           // Record Value
+	Time     time.Time         // Record time
+	Priority int               // Record priority, higher priorities are processed first by node tasks
+	Tenant   string            // Tenant to which this Record belongs, set by the source tenant extractor
+	Headers  map[string]string // Record headers, shared among record copies and must be treated as read-only
+	ack      *acker            // Ack Record source of its processing. Initially no-op.
+	enc      *encoding         // Encoded key and value cache shared among record copies
+}
+
+// encoding caches the encoded key and value of a record created with NewRecord.
+// It is shared by all copies of the record as it flows through the topology.
+type encoding struct {
+	key        Encoder
+	keyOnce    sync.Once
+	keyBytes   []byte
+	keyErr     error
+	value      Encoder
+	valueOnce  sync.Once
+	valueBytes []byte
+	valueErr   error
+}
+
+// acker acknowledges the record source once all references to the record
+// within the stream are released. The source holds the first reference, and
+// every forward of the record retains one reference per receiving node which
+// is released after the node finishes processing the record.
+type acker struct {
+	refs    int32
+	done    int32
+	ack     func() error
+	nack    func(err error) error
+	tracker atomic.Value // *inflight tracking the record while unacknowledged
+	expiry  atomic.Value // *time.Timer of the source ack deadline
+}
+
+func newAcker(ack func() error) (a *acker) {
+	if ack == nil {
+		return nil
+	}
+	return &acker{refs: 1, ack: ack}
+}
+
+func (a *acker) retain(n int) {
+	atomic.AddInt32(&a.refs, int32(n))
+}
+
+func (a *acker) release() (err error) {
+	if atomic.AddInt32(&a.refs, -1) <= 0 && a.finish() {
+		return a.ack()
+	}
+	return nil
+}
+
+// reject the record, calling the nack function instead of ack
+// if the record was not yet acknowledged or rejected
+func (a *acker) reject(err error) (nerr error) {
+	if a.finish() && a.nack != nil {
+		return a.nack(err)
+	}
+	return nil
+}
+
+// finish the record acknowledgment, returning false if already finished
+func (a *acker) finish() (ok bool) {
+	if !atomic.CompareAndSwapInt32(&a.done, 0, 1) {
+		return false
+	}
+
+	if t, ok := a.tracker.Load().(*inflight); ok {
+		t.untrack(a)
+	}
+	if t, ok := a.expiry.Load().(*time.Timer); ok {
+		t.Stop()
+	}
+	return true
 }
 
 // NewRecord creates a new record. Key and ack are optional and can be set to nil.
+// The ack function is called once the record and all copies forwarded
+// within the stream are processed.
 func NewRecord(topic string, key, value Encoder, ts time.Time, ack func() error) (record Record) {
 	record.Topic = topic
 	record.Key = key
 	record.Value = value
 	record.Time = ts
-	record.ack = ack
+	record.ack = newAcker(ack)
+	record.enc = &encoding{key: key, value: value}
+	record.hash()
+	return record
+}
+
+// WithKey returns a copy of the record with the given key and its id
+// recomputed, so that it is routed to node tasks by the new key.
+// The copy shares the record acknowledgment.
+func (r Record) WithKey(key Encoder) (record Record) {
+	record = r
+	record.Key = key
+	record.enc = &encoding{key: key, value: r.Value}
+	record.hash()
+	return record
+}
+
+// WithTenant returns a copy of the record with the given tenant and its id
+// recomputed, so that records with the same key of distinct tenants are
+// routed to node tasks independently.
+// The copy shares the record acknowledgment.
+func (r Record) WithTenant(tenant string) (record Record) {
+	record = r
+	record.Tenant = tenant
+	record.hash()
+	return record
+}
+
+// WithHeader returns a copy of the record with the given header set.
+// The headers of the record are copied, leaving the original untouched.
+// The copy shares the record acknowledgment.
+func (r Record) WithHeader(name, value string) (record Record) {
+	record = r
+	record.Headers = make(map[string]string, len(r.Headers)+1)
+	for n, v := range r.Headers {
+		record.Headers[n] = v
+	}
+	record.Headers[name] = value
+	return record
+}
+
+// hash computes the record id over the encoded key or lately value,
+// seeded by the record tenant
+func (r *Record) hash() {
+	r.id = 0
+
+	var seed uint64
+	if r.Tenant != "" {
+		seed = wyhash.Hash([]byte(r.Tenant), 0)
+	}
 
 	switch {
-	case key != nil:
-		b, _ := record.Key.Encode()
-		record.id = wyhash.Hash(b, 0)
-	case value != nil:
-		b, _ := record.Value.Encode()
-		record.id = wyhash.Hash(b, 0)
+	case r.Key != nil:
+		b, _ := r.EncodeKey()
+		r.id = wyhash.Hash(b, seed)
+	case r.Value != nil:
+		b, _ := r.EncodeValue()
+		r.id = wyhash.Hash(b, seed)
 	}
+}
 
-	return record
+// EncodeKey returns the encoded record key.
+// For records created with NewRecord the key is encoded at most once and the
+// resulting bytes are shared among all copies of the record, which must be
+// treated as immutable and read-only. Assigning a new Key to a record copy
+// bypasses the cache for that copy.
+func (r Record) EncodeKey() (key []byte, err error) {
+	if r.Key == nil {
+		return nil, nil
+	}
+
+	if r.enc == nil || !sameEncoder(r.Key, r.enc.key) {
+		return r.Key.Encode()
+	}
+
+	r.enc.keyOnce.Do(func() {
+		r.enc.keyBytes, r.enc.keyErr = r.Key.Encode()
+	})
+	return r.enc.keyBytes, r.enc.keyErr
+}
+
+// EncodeValue returns the encoded record value.
+// For records created with NewRecord the value is encoded at most once and the
+// resulting bytes are shared among all copies of the record, which must be
+// treated as immutable and read-only. Assigning a new Value to a record copy
+// bypasses the cache for that copy.
+func (r Record) EncodeValue() (value []byte, err error) {
+	if r.Value == nil {
+		return nil, nil
+	}
+
+	if r.enc == nil || !sameEncoder(r.Value, r.enc.value) {
+		return r.Value.Encode()
+	}
+
+	r.enc.valueOnce.Do(func() {
+		r.enc.valueBytes, r.enc.valueErr = r.Value.Encode()
+	})
+	return r.enc.valueBytes, r.enc.valueErr
+}
+
+// Retain a reference to the record, delaying the acknowledgment of its source
+// until a matching Ack. Processors and sinks that complete the processing of a
+// record asynchronously must retain it within Process and call Ack when done.
+func (r Record) Retain() {
+	if r.ack != nil {
+		r.ack.retain(1)
+	}
 }
 
-// Ack acknowledge the record source of its processing
+// Ack releases a reference retained with Retain. The record source is
+// acknowledged once every node that received the record and its copies
+// finished processing it and all retained references are released.
 func (r Record) Ack() (err error) {
 	if r.ack != nil {
-		return r.ack()
+		return r.ack.release()
 	}
 	return nil
 }
@@ -64,3 +239,29 @@ func (r Record) Ack() (err error) {
 func (r Record) IsValid() (valid bool) {
 	return (r.Key != nil || r.Value != nil) && r.Topic != ""
 }
+
+// sameEncoder reports if a and b hold the same encoder instance.
+// Encoders of non comparable types are never considered the same.
+func sameEncoder(a, b Encoder) (same bool) {
+	if a == nil || b == nil {
+		return false
+	}
+
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Type() != vb.Type() {
+		return false
+	}
+
+	switch va.Kind() {
+	case reflect.Slice:
+		return va.Pointer() == vb.Pointer() && va.Len() == vb.Len()
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func:
+		return va.Pointer() == vb.Pointer()
+	}
+
+	if va.Type().Comparable() {
+		return a == b
+	}
+
+	return false
+}
@@ -0,0 +1,150 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingEncoder struct {
+	data  string
+	count *int32
+}
+
+func (c countingEncoder) Encode() ([]byte, error) {
+	atomic.AddInt32(c.count, 1)
+	return []byte(c.data), nil
+}
+
+func TestRecordEncodeCache(t *testing.T) {
+	var keyCount, valueCount int32
+	key := countingEncoder{"key", &keyCount}
+	value := countingEncoder{"value", &valueCount}
+
+	record := NewRecord("topic", key, value, time.Now(), nil)
+	assert.Equal(t, int32(1), keyCount, "key encoded for the record id")
+
+	// copies share the cache
+	copied := record
+	for x := 0; x < 10; x++ {
+		k, err := copied.EncodeKey()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("key"), k)
+
+		v, err := record.EncodeValue()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"), v)
+	}
+
+	assert.Equal(t, int32(1), keyCount, "key encode count")
+	assert.Equal(t, int32(1), valueCount, "value encode count")
+
+	// a new value bypasses the cache
+	copied.Value = StringEncoder("other")
+	v, err := copied.EncodeValue()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("other"), v)
+
+	v, err = record.EncodeValue()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), v)
+}
+
+func TestRecordAckFanout(t *testing.T) {
+	var processed int32
+	acks := make(chan string, 100)
+	retained := make(chan Record, 100)
+
+	config := NewConfig(nil)
+	config.Set(2, "stream.sinkB.tasks.count")
+	config.Set(2, "stream.map.fanout.workers")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: ackedRecords(50, acks)}
+	}))
+	assert.NoError(t, b.AddProcessorFunc("map", passthrough, "source"))
+	assert.NoError(t, b.AddSinkFunc("sinkA", func(pc ProcessorContext, record Record) {
+		atomic.AddInt32(&processed, 1)
+	}, "map"))
+	assert.NoError(t, b.AddSinkFunc("sinkB", func(pc ProcessorContext, record Record) {
+		atomic.AddInt32(&processed, 1)
+	}, "map"))
+	assert.NoError(t, b.AddSinkFunc("sinkC", func(pc ProcessorContext, record Record) {
+		record.Retain()
+		retained <- record
+	}, "map"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	// sinkC holds all records
+	var held []Record
+	for x := 0; x < 50; x++ {
+		held = append(held, <-retained)
+	}
+	select {
+	case <-acks:
+		t.Fatal("record acknowledged before all sinks are done")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	for _, record := range held {
+		assert.NoError(t, record.Ack())
+	}
+
+	seen := make(map[string]bool)
+	for x := 0; x < 50; x++ {
+		select {
+		case value := <-acks:
+			assert.False(t, seen[value], "duplicate ack")
+			seen[value] = true
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for acks")
+		}
+	}
+
+	assert.Equal(t, int32(100), atomic.LoadInt32(&processed))
+	assert.NoError(t, stream.Close())
+}
+
+func TestRecordWithKey(t *testing.T) {
+	var acked int
+	record := NewRecord("test", StringEncoder("a"), StringEncoder("value"), time.Now(),
+		func() error { acked++; return nil })
+
+	rekeyed := record.WithKey(StringEncoder("b"))
+	assert.Equal(t, NewRecord("test", StringEncoder("b"), nil, time.Now(), nil).id, rekeyed.id)
+	assert.NotEqual(t, record.id, rekeyed.id)
+
+	key, _ := rekeyed.EncodeKey()
+	assert.Equal(t, []byte("b"), key)
+	key, _ = record.EncodeKey()
+	assert.Equal(t, []byte("a"), key)
+
+	// the copy shares the record acknowledgment
+	rekeyed.Retain()
+	assert.NoError(t, record.Ack())
+	assert.Equal(t, 0, acked)
+	assert.NoError(t, rekeyed.Ack())
+	assert.Equal(t, 1, acked)
+}
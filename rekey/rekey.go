@@ -0,0 +1,132 @@
+package rekey
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"strings"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/jsonpath"
+)
+
+const (
+	// DefaultSeparator is the default separator of composite keys
+	DefaultSeparator = ":"
+
+	// Missing key actions
+	missingError = "error"
+	missingDrop  = "drop"
+	missingKeep  = "keep"
+)
+
+var (
+	// ErrKeyNotFound is emitted when the record value has no key field
+	ErrKeyNotFound = streams.Errorf(streams.CodeRecord, "key field not found")
+
+	errKeyNotScalar  = streams.Errorf(streams.CodeRecord, "key field is not a scalar value")
+	errInvalidConfig = streams.Errorf(streams.CodeConfig, "invalid rekey config")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Rekey)(nil)
+var _ streams.Processor = (*Rekey)(nil)
+
+// Rekey sets the key of records to the scalar values selected from their json
+// values, recomputing their routing hash so that downstream tasks, joins and
+// aggregations partition the records by the new key.
+// It is configured through the <stream>.<node> config subtree with the keys:
+//
+//	path:      JSONPath of the key field
+//	paths:     array of JSONPaths of the fields of a composite key, used instead of path
+//	separator: separator of composite key fields, defaults to DefaultSeparator
+//	missing:   action for records without the key fields, error (default), drop or keep
+type Rekey struct {
+	paths     []*jsonpath.Path
+	separator string
+	missing   string
+}
+
+// Supplier for the rekey processor
+func Supplier() (processor streams.Processor) {
+	return &Rekey{}
+}
+
+// Init the processor
+func (r *Rekey) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	r.separator = config.Get("separator").String(DefaultSeparator)
+
+	switch r.missing = config.Get("missing").String(missingError); r.missing {
+	case missingError, missingDrop, missingKeep:
+	default:
+		return errInvalidConfig
+	}
+
+	exprs := config.Get("paths").Array()
+	if len(exprs) == 0 && config.IsSet("path") {
+		exprs = append(exprs, config.Get("path"))
+	}
+
+	for _, expr := range exprs {
+		path, err := jsonpath.Compile(expr.String(""))
+		if err != nil {
+			return err
+		}
+		r.paths = append(r.paths, path)
+	}
+
+	if len(r.paths) == 0 {
+		return errInvalidConfig
+	}
+	return nil
+}
+
+// Process rekeys and forwards the record
+func (r *Rekey) Process(pc streams.ProcessorContext, record streams.Record) {
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	document, err := jsonpath.Decode(value)
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	fields := make([]string, len(r.paths))
+	for x, path := range r.paths {
+		field, ok := path.Get(document)
+		if !ok {
+			switch r.missing {
+			case missingKeep:
+				pc.Forward(record)
+			case missingError:
+				pc.Error(ErrKeyNotFound, record)
+			}
+			return
+		}
+
+		if fields[x], ok = jsonpath.Scalar(field); !ok {
+			pc.Error(errKeyNotScalar, record)
+			return
+		}
+	}
+
+	pc.Forward(record.WithKey(streams.StringEncoder(strings.Join(fields, r.separator))))
+}
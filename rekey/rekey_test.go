@@ -0,0 +1,54 @@
+package rekey
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRekey(t *testing.T) {
+	config := streams.NewConfig(nil)
+	config.Set([]interface{}{"$.tenant", "$.user.id"}, "stream", "rekey", "paths")
+	config.Set("drop", "stream.rekey.missing")
+
+	pc := &mock.Context{Data: mock.ContextData{
+		Active:     true,
+		StreamName: "stream",
+		NodeName:   "rekey",
+		Config:     config,
+	}}
+
+	r := Supplier()
+	assert.NoError(t, r.(streams.Initializer).Init(pc))
+
+	record := streams.NewRecord("test", streams.StringEncoder("old"),
+		streams.StringEncoder(`{"tenant":"acme","user":{"id":42}}`), time.Now(), nil)
+	r.Process(pc, record)
+	r.Process(pc, streams.NewRecord("test", nil, streams.StringEncoder(`{"tenant":"acme"}`), time.Now(), nil))
+	r.Process(pc, streams.NewRecord("test", nil, streams.StringEncoder(`{"tenant":[],"user":{"id":1}}`), time.Now(), nil))
+
+	assert.Equal(t, 1, pc.Data.ForwardCount)
+	assert.Equal(t, 1, pc.Data.ErrorCount)
+
+	key, _ := pc.Data.Forwarded[0].EncodeKey()
+	assert.Equal(t, "acme:42", string(key))
+}
@@ -0,0 +1,134 @@
+package reorder
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+const (
+	// DefaultLateness is the default allowed lateness of records
+	DefaultLateness = time.Second
+	// DefaultSize is the default maximum number of buffered records
+	DefaultSize = 10000
+)
+
+var (
+	// ErrLateRecord is emitted for records older than the last forwarded record
+	ErrLateRecord = streams.Errorf(streams.CodeRecord, "late record")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Sorter)(nil)
+var _ streams.Processor = (*Sorter)(nil)
+
+// Sorter is a bounded reordering buffer that forwards records sorted by their
+// time, for sinks that require in order data. Records are buffered until the
+// watermark, the highest record time seen minus the allowed lateness set with
+// <stream>.<node>.lateness, passes their time. The oldest records are forwarded
+// early once the buffer reaches the <stream>.<node>.size number of records.
+// Records older than the last forwarded record are dropped with ErrLateRecord,
+// or forwarded as they arrive with the <stream>.<node>.forwardlate config.
+// Buffered records are acknowledged once forwarded. Records still buffered
+// when the stream is closed are not acknowledged.
+type Sorter struct {
+	lateness    time.Duration
+	size        int
+	forwardLate bool
+	buffer      records
+	seq         uint64
+	watermark   time.Time
+	last        time.Time
+}
+
+// Supplier for the sorter
+func Supplier() (processor streams.Processor) {
+	return &Sorter{}
+}
+
+// Init the sorter
+func (s *Sorter) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	s.lateness = config.Get("lateness").Duration(DefaultLateness)
+	s.size = config.Get("size").Int(DefaultSize)
+	s.forwardLate = config.Get("forwardlate").Bool(false)
+
+	if s.size <= 0 {
+		s.size = DefaultSize
+	}
+	return nil
+}
+
+// Process buffers the record and forwards the records behind the watermark
+func (s *Sorter) Process(pc streams.ProcessorContext, record streams.Record) {
+	if record.Time.Before(s.last) {
+		if s.forwardLate {
+			pc.Forward(record)
+			return
+		}
+		pc.Error(ErrLateRecord, record)
+		return
+	}
+
+	if watermark := record.Time.Add(-s.lateness); watermark.After(s.watermark) {
+		s.watermark = watermark
+	}
+
+	record.Retain()
+	s.seq++
+	heap.Push(&s.buffer, entry{record, s.seq})
+
+	for s.buffer.Len() > 0 {
+		next := s.buffer[0].record
+		if s.buffer.Len() <= s.size && next.Time.After(s.watermark) {
+			break
+		}
+
+		heap.Pop(&s.buffer)
+		s.last = next.Time
+		pc.Forward(next)
+		next.Ack()
+	}
+}
+
+// entry of the buffer, sorted by record time and arrival order
+type entry struct {
+	record streams.Record
+	seq    uint64
+}
+
+// records is a min heap of buffered records
+type records []entry
+
+func (r records) Len() int { return len(r) }
+func (r records) Less(i, j int) bool {
+	if r[i].record.Time.Equal(r[j].record.Time) {
+		return r[i].seq < r[j].seq
+	}
+	return r[i].record.Time.Before(r[j].record.Time)
+}
+func (r records) Swap(i, j int)       { r[i], r[j] = r[j], r[i] }
+func (r *records) Push(x interface{}) { *r = append(*r, x.(entry)) }
+func (r *records) Pop() (x interface{}) {
+	old := *r
+	x = old[len(old)-1]
+	*r = old[:len(old)-1]
+	return x
+}
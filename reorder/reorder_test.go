@@ -0,0 +1,84 @@
+package reorder
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSorter(t *testing.T) {
+	config := streams.NewConfig(nil)
+	config.Set("2s", "stream.sorter.lateness")
+	config.Set(4, "stream.sorter.size")
+
+	pc := &mock.Context{Data: mock.ContextData{
+		Active:     true,
+		StreamName: "stream",
+		NodeName:   "sorter",
+		Config:     config,
+	}}
+
+	s := Supplier()
+	assert.NoError(t, s.(streams.Initializer).Init(pc))
+
+	var acked int
+	start := time.Unix(1000, 0)
+	process := func(seconds ...int) {
+		for _, sec := range seconds {
+			record := streams.NewRecord("test", nil, streams.StringEncoder(strconv.Itoa(sec)),
+				start.Add(time.Duration(sec)*time.Second), func() error {
+					acked++
+					return nil
+				})
+			s.Process(pc, record)
+			record.Ack()
+		}
+	}
+
+	forwarded := func() (values []string) {
+		for _, record := range pc.Data.Forwarded {
+			value, _ := record.EncodeValue()
+			values = append(values, string(value))
+		}
+		return values
+	}
+
+	// watermark at 1s
+	process(1, 3, 2)
+	assert.Equal(t, []string{"1"}, forwarded())
+
+	// watermark at 3s
+	process(5)
+	assert.Equal(t, []string{"1", "2", "3"}, forwarded())
+	assert.Equal(t, 3, acked)
+
+	// late records are dropped
+	process(2)
+	assert.Equal(t, 1, pc.Data.ErrorCount)
+	assert.Equal(t, 4, acked)
+
+	// the oldest records are forwarded once the buffer is full
+	process(7, 6, 6, 6, 6)
+	assert.Equal(t, []string{"1", "2", "3", "5", "6"}, forwarded())
+	assert.Equal(t, 6, acked)
+}
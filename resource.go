@@ -0,0 +1,165 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+)
+
+var (
+	// ErrResourceNotFound is returned when the requested resource
+	// is not registered in the resource pool.
+	ErrResourceNotFound = Errorf(CodeTopology, "resource not found")
+
+	errResourceExists = Errorf(CodeTopology, "resource already exists")
+)
+
+// ResourceFactory creates a shared resource like a HTTP client, a database
+// connection pool or a message broker client. Resources implementing the
+// Closer interface are closed once they are no longer referenced.
+type ResourceFactory func() (resource interface{}, err error)
+
+// Pool of named shared resources. Resources are created on their first
+// acquisition and reference counted, so streams running in the same process
+// share a single resource instance instead of creating their own.
+type Pool struct {
+	mtx       sync.Mutex
+	factories map[string]ResourceFactory
+	resources map[string]*pooled
+}
+
+// pooled is a created resource and its reference count
+type pooled struct {
+	resource interface{}
+	refs     int
+}
+
+// NewPool creates an empty resource pool
+func NewPool() (p *Pool) {
+	p = &Pool{}
+	p.factories = make(map[string]ResourceFactory)
+	p.resources = make(map[string]*pooled)
+	return p
+}
+
+// Register the factory for the named resource
+func (p *Pool) Register(name string, factory ResourceFactory) (err error) {
+	if name == "" {
+		return errEmptyName
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if _, exists := p.factories[name]; exists {
+		return errResourceExists
+	}
+
+	p.factories[name] = factory
+	return nil
+}
+
+// Acquire a reference to the named resource, creating it if needed.
+// Every successful Acquire must be matched by a Release.
+func (p *Pool) Acquire(name string) (resource interface{}, err error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if r, ok := p.resources[name]; ok {
+		r.refs++
+		return r.resource, nil
+	}
+
+	factory, ok := p.factories[name]
+	if !ok {
+		return nil, ErrResourceNotFound
+	}
+
+	if resource, err = factory(); err != nil {
+		return nil, err
+	}
+
+	p.resources[name] = &pooled{resource: resource, refs: 1}
+	return resource, nil
+}
+
+// Release a reference to the named resource, closing it
+// if it is no longer referenced.
+func (p *Pool) Release(name string) (err error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	r, ok := p.resources[name]
+	if !ok {
+		return ErrResourceNotFound
+	}
+
+	if r.refs--; r.refs > 0 {
+		return nil
+	}
+
+	delete(p.resources, name)
+	if closer, ok := r.resource.(Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// resources are the pool resources acquired by a stream
+type resources struct {
+	mtx      sync.Mutex
+	pool     *Pool
+	acquired map[string]interface{}
+}
+
+// get the named resource, acquiring it from the pool on the first access
+func (r *resources) get(name string) (resource interface{}, err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.pool == nil {
+		return nil, ErrResourceNotFound
+	}
+
+	if resource, ok := r.acquired[name]; ok {
+		return resource, nil
+	}
+
+	if resource, err = r.pool.Acquire(name); err != nil {
+		return nil, err
+	}
+
+	if r.acquired == nil {
+		r.acquired = make(map[string]interface{})
+	}
+	r.acquired[name] = resource
+	return resource, nil
+}
+
+// release all acquired resources
+func (r *resources) release() (err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for name := range r.acquired {
+		if e := r.pool.Release(name); e != nil && err == nil {
+			err = e
+		}
+	}
+	r.acquired = nil
+	return err
+}
@@ -0,0 +1,84 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"runtime"
+	"sync"
+)
+
+// RestoreProgress reports the number of entries restored so far
+// for the named store and if the restoration is done.
+type RestoreProgress func(store string, restored int64, done bool)
+
+// restoreStores restores the given stores implementing the Restorer interface
+// in parallel, limited to <stream>.restore.concurrency concurrent restorations.
+// The first restoration error is returned after all restorations finish.
+func (s *Stream) restoreStores(stores []*Node) (err error) {
+	concurrency := s.config.Get(s.name, "restore", "concurrency").Int(runtime.NumCPU())
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+	for _, node := range stores {
+		restorer, ok := node.processor.(Restorer)
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(name string, restorer Restorer) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if rerr := s.restoreStore(name, restorer); rerr != nil {
+				mtx.Lock()
+				if err == nil {
+					err = rerr
+				}
+				mtx.Unlock()
+			}
+		}(node.name, restorer)
+	}
+
+	wg.Wait()
+	return err
+}
+
+// restoreStore restores the named store reporting its progress
+func (s *Stream) restoreStore(name string, restorer Restorer) (err error) {
+	var restored int64
+	err = restorer.Restore(func(count int64) {
+		restored = count
+		if s.progress != nil {
+			s.progress(name, count, false)
+		}
+	})
+
+	if s.progress != nil {
+		s.progress(name, restored, true)
+	}
+
+	return err
+}
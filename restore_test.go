@@ -0,0 +1,100 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// nopStore is a store that holds no data
+type nopStore struct{}
+
+func (nopStore) Process(pc ProcessorContext, record Record)                    {}
+func (nopStore) Name() string                                                  { return "nop" }
+func (nopStore) Get(key []byte) ([]byte, error)                                { return nil, ErrKeyNotFound }
+func (nopStore) Range(from, to []byte, cb func(key, value []byte) error) error { return nil }
+func (nopStore) RangePrefix(prefix []byte, cb func(key, value []byte) error) error {
+	return nil
+}
+func (nopStore) Set(key, value []byte) error { return nil }
+func (nopStore) Delete(key []byte) error     { return nil }
+
+type restoringStore struct {
+	nopStore
+	running *int32
+	max     *int32
+}
+
+func (r *restoringStore) Restore(progress func(restored int64)) error {
+	current := atomic.AddInt32(r.running, 1)
+	for {
+		max := atomic.LoadInt32(r.max)
+		if current <= max || atomic.CompareAndSwapInt32(r.max, max, current) {
+			break
+		}
+	}
+
+	for x := int64(1); x <= 10; x++ {
+		time.Sleep(time.Millisecond)
+		progress(x)
+	}
+
+	atomic.AddInt32(r.running, -1)
+	return nil
+}
+
+func TestStreamRestoreStores(t *testing.T) {
+	var running, max int32
+	supplier := func() Store {
+		return &restoringStore{running: &running, max: &max}
+	}
+
+	config := NewConfig(nil)
+	config.Set(2, "stream.restore.concurrency")
+
+	b := NewBuilder("stream", config)
+	for x := 0; x < 6; x++ {
+		assert.NoError(t, b.AddStore("store"+strconv.Itoa(x), supplier))
+	}
+
+	var mtx sync.Mutex
+	done := make(map[string]int64)
+	b.RestoreProgress(func(store string, restored int64, finished bool) {
+		if finished {
+			mtx.Lock()
+			done[store] = restored
+			mtx.Unlock()
+		}
+	})
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	assert.Len(t, done, 6)
+	for store, restored := range done {
+		assert.Equal(t, int64(10), restored, store)
+	}
+	assert.True(t, max <= 2, "concurrency limit")
+	assert.NoError(t, stream.Close())
+}
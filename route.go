@@ -0,0 +1,272 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/brunotm/streams/types"
+)
+
+const (
+	// RegexpRoutePrefix marks a route pattern as a regular expression
+	RegexpRoutePrefix = "re:"
+)
+
+var (
+	errRouteOverlap = Errorf(CodeTopology, "overlapping route patterns")
+	errRouteChild   = Errorf(CodeTopology, "route child is not a successor of the parent")
+)
+
+// routerName returns the name of the routing node for the given parent
+func routerName(parent string) (name string) {
+	return parent + "-router"
+}
+
+// validateRoute validates the route patterns and children of the given parent
+func (t *topology) validateRoute(parent string, routes map[string]string) (err error) {
+	node := t.getNode(parent)
+	if node == nil {
+		return ErrNodeNotFound
+	}
+
+	if t.getNode(routerName(parent)) != nil {
+		return errInvalidTopology
+	}
+
+	var globs []string
+	regexps := make(map[string]bool)
+
+	for pattern, child := range routes {
+		var found bool
+		for _, successor := range node.successors {
+			found = found || successor.name == child
+		}
+
+		if !found {
+			return Errorf(CodeTopology, "%s: %w", child, errRouteChild)
+		}
+
+		if strings.HasPrefix(pattern, RegexpRoutePrefix) {
+			if _, err = regexp.Compile(strings.TrimPrefix(pattern, RegexpRoutePrefix)); err != nil {
+				return err
+			}
+			regexps[pattern] = true
+			continue
+		}
+
+		if _, err = path.Match(pattern, ""); err != nil {
+			return err
+		}
+		globs = append(globs, pattern)
+	}
+
+	sort.Strings(globs)
+	for x := 0; x < len(globs); x++ {
+		for y := x + 1; y < len(globs); y++ {
+			if globOverlap(globs[x], globs[y]) {
+				return Errorf(CodeTopology, "%s, %s: %w", globs[x], globs[y], errRouteOverlap)
+			}
+		}
+	}
+
+	return nil
+}
+
+// route inserts a routing node between the parent and the routed children
+func (t *topology) route(parent string, routes map[string]string) {
+	router := &Node{}
+	router.name = routerName(parent)
+	router.typ = types.Processor
+	router.supplier = ProcessorSupplier(func() Processor {
+		return newRouter(routes)
+	})
+
+	children := make(map[string]bool)
+	for _, child := range routes {
+		children[child] = true
+	}
+	t.insert(parent, router, children)
+}
+
+// insert the node between the parent and the given children,
+// keeping the topological order of nodes
+func (t *topology) insert(parent string, inserted *Node, children map[string]bool) {
+	node := t.getNode(parent)
+	inserted.predecessors = []*Node{node}
+
+	var successors []*Node
+	for _, successor := range node.successors {
+		if !children[successor.name] {
+			successors = append(successors, successor)
+			continue
+		}
+
+		inserted.successors = append(inserted.successors, successor)
+		for x := range successor.predecessors {
+			if successor.predecessors[x] == node {
+				successor.predecessors[x] = inserted
+			}
+		}
+	}
+	node.successors = append(successors, inserted)
+
+	for x := range t.nodes {
+		if t.nodes[x] == node {
+			t.nodes = append(t.nodes[:x+1], append([]*Node{inserted}, t.nodes[x+1:]...)...)
+			break
+		}
+	}
+}
+
+// router dispatches records to children by topic. Literal patterns are
+// matched first, then globs and regular expressions. Topic matches are cached.
+type router struct {
+	exact   map[string]string
+	globs   []routePattern
+	regexps []routePattern
+	cache   sync.Map
+}
+
+type routePattern struct {
+	pattern string
+	re      *regexp.Regexp
+	child   string
+}
+
+func newRouter(routes map[string]string) (r *router) {
+	r = &router{}
+	r.exact = make(map[string]string)
+
+	for pattern, child := range routes {
+		switch {
+		case strings.HasPrefix(pattern, RegexpRoutePrefix):
+			re := regexp.MustCompile(strings.TrimPrefix(pattern, RegexpRoutePrefix))
+			r.regexps = append(r.regexps, routePattern{pattern: pattern, re: re, child: child})
+		case !strings.ContainsAny(pattern, `*?[\`):
+			r.exact[pattern] = child
+		default:
+			r.globs = append(r.globs, routePattern{pattern: pattern, child: child})
+		}
+	}
+
+	sort.Slice(r.regexps, func(i, j int) bool { return r.regexps[i].pattern < r.regexps[j].pattern })
+	return r
+}
+
+// Process forwards the record to the child routed by the record topic.
+// Records not matching any route are dropped.
+func (r *router) Process(pc ProcessorContext, record Record) {
+	if child := r.match(record.Topic); child != "" {
+		if err := pc.ForwardTo(child, record); err != nil {
+			pc.Error(err, record)
+		}
+	}
+}
+
+// match returns the child for the topic, or an empty string if none
+func (r *router) match(topic string) (child string) {
+	if cached, ok := r.cache.Load(topic); ok {
+		return cached.(string)
+	}
+
+	child, ok := r.exact[topic]
+	if !ok {
+		for _, g := range r.globs {
+			if matched, _ := path.Match(g.pattern, topic); matched {
+				child = g.child
+				break
+			}
+		}
+	}
+
+	if child == "" {
+		for _, re := range r.regexps {
+			if re.re.MatchString(topic) {
+				child = re.child
+				break
+			}
+		}
+	}
+
+	r.cache.Store(topic, child)
+	return child
+}
+
+// globOverlap returns if there is any topic matched by both glob patterns.
+// Character classes are conservatively treated as single character wildcards.
+func globOverlap(a, b string) (overlap bool) {
+	pa, pb := globTokens(a), globTokens(b)
+	memo := make(map[[2]int]bool)
+	seen := make(map[[2]int]bool)
+
+	var match func(i, j int) bool
+	match = func(i, j int) bool {
+		k := [2]int{i, j}
+		if seen[k] {
+			return memo[k]
+		}
+		seen[k] = true
+
+		var ok bool
+		switch {
+		case i == len(pa) && j == len(pb):
+			ok = true
+		case i < len(pa) && pa[i] == "*":
+			ok = match(i+1, j) || (j < len(pb) && match(i, j+1))
+		case j < len(pb) && pb[j] == "*":
+			ok = match(i, j+1) || (i < len(pa) && match(i+1, j))
+		case i < len(pa) && j < len(pb):
+			ok = (pa[i] == "?" || pb[j] == "?" || pa[i] == pb[j]) && match(i+1, j+1)
+		}
+
+		memo[k] = ok
+		return ok
+	}
+
+	return match(0, 0)
+}
+
+// globTokens splits a glob pattern in single character tokens,
+// with "*" and "?" for wildcards
+func globTokens(pattern string) (tokens []string) {
+	for x := 0; x < len(pattern); x++ {
+		switch c := pattern[x]; c {
+		case '\\':
+			if x+1 < len(pattern) {
+				x++
+				tokens = append(tokens, "\\"+string(pattern[x]))
+			}
+		case '*':
+			tokens = append(tokens, "*")
+		case '?':
+			tokens = append(tokens, "?")
+		case '[':
+			if end := strings.IndexByte(pattern[x:], ']'); end > 0 {
+				x += end
+			}
+			tokens = append(tokens, "?")
+		default:
+			tokens = append(tokens, "\\"+string(c))
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,99 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobOverlap(t *testing.T) {
+	cases := []struct {
+		a, b    string
+		overlap bool
+	}{
+		{"orders", "orders", true},
+		{"orders.*", "orders.eu", true},
+		{"orders.*", "*.eu", true},
+		{"orders.?u", "orders.eu", true},
+		{"orders.eu", "orders.us", false},
+		{"orders.*", "payments.*", false},
+		{"*.eu", "*.us", false},
+		{"a*b", "*c", false},
+		{"[ab]x", "cx", true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.overlap, globOverlap(c.a, c.b), c.a+" "+c.b)
+		assert.Equal(t, c.overlap, globOverlap(c.b, c.a), c.b+" "+c.a)
+	}
+}
+
+func TestBuilderRoute(t *testing.T) {
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	received := make(map[string][]string)
+	// 4 routed records plus 5 records to the not routed successor
+	wg.Add(9)
+
+	sink := func(name string) ProcessorFunc {
+		return func(pc ProcessorContext, record Record) {
+			mtx.Lock()
+			received[name] = append(received[name], record.Topic)
+			mtx.Unlock()
+			wg.Done()
+		}
+	}
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: topicRecords("orders.eu", "orders.us", "payments", "audit.1", "other")}
+	}))
+	assert.NoError(t, b.AddSinkFunc("eu", sink("eu"), "source"))
+	assert.NoError(t, b.AddSinkFunc("orders", sink("orders"), "source"))
+	assert.NoError(t, b.AddSinkFunc("payments", sink("payments"), "source"))
+	assert.NoError(t, b.AddSinkFunc("audit", sink("audit"), "source"))
+	assert.NoError(t, b.AddSinkFunc("all", sink("all"), "source"))
+
+	assert.NoError(t, b.Route("source", map[string]string{"orders.eu": "eu", "orders.*": "orders"}))
+	_, err := b.Build()
+	assert.Error(t, err, "overlapping patterns")
+
+	b.routes = make(map[string]map[string]string)
+	assert.Error(t, b.Route("source", nil))
+	assert.NoError(t, b.Route("source", map[string]string{
+		"orders.e?":    "eu",
+		"orders.us":    "orders",
+		"payments":     "payments",
+		"re:^audit\\.": "audit",
+	}))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NotNil(t, stream.topology.getNode("source-router"))
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+	assert.NoError(t, stream.Close())
+
+	assert.Equal(t, []string{"orders.eu"}, received["eu"])
+	assert.Equal(t, []string{"orders.us"}, received["orders"])
+	assert.Equal(t, []string{"payments"}, received["payments"])
+	assert.Equal(t, []string{"audit.1"}, received["audit"])
+	assert.Len(t, received["all"], 5)
+}
@@ -0,0 +1,94 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultCloseTimeout is the default time to wait for a stream to close in Run.
+	DefaultCloseTimeout = 30 * time.Second
+)
+
+var (
+	// ErrCloseTimeout is returned when a stream fails to close within the configured timeout.
+	ErrCloseTimeout = Errorf(CodeState, "stream close timeout")
+)
+
+// Errors is a list of errors aggregated from multiple stream operations
+type Errors []error
+
+// Error returns the aggregated error messages
+func (e Errors) Error() (message string) {
+	messages := make([]string, len(e))
+	for x := range e {
+		messages[x] = e[x].Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the aggregated errors, matched by errors.Is and errors.As
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// Run starts the stream and blocks until one of the given signals is received,
+// or SIGINT and SIGTERM if none are given. The stream is then closed, waiting at
+// most for the <stream>.close.timeout configured duration. Errors from starting
+// and closing the stream are returned as Errors.
+func Run(stream *Stream, signals ...os.Signal) (err error) {
+	var errs Errors
+
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	sigch := make(chan os.Signal, 1)
+	signal.Notify(sigch, signals...)
+	defer signal.Stop(sigch)
+
+	if err = stream.Start(); err != nil {
+		return append(errs, err)
+	}
+
+	<-sigch
+
+	timeout := stream.config.Get(stream.name, "close", "timeout").Duration(DefaultCloseTimeout)
+	closech := make(chan error, 1)
+	go func() {
+		closech <- stream.Close()
+	}()
+
+	select {
+	case err = <-closech:
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case <-time.After(timeout):
+		errs = append(errs, ErrCloseTimeout)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
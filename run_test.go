@@ -0,0 +1,58 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"os"
+	"os/signal"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	// keep the test process from being interrupted
+	sigch := make(chan os.Signal, 16)
+	signal.Notify(sigch, os.Interrupt)
+	defer signal.Stop(sigch)
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(10)))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- Run(stream, os.Interrupt)
+	}()
+
+	process, err := os.FindProcess(os.Getpid())
+	assert.NoError(t, err)
+
+	for {
+		assert.NoError(t, process.Signal(os.Interrupt))
+		select {
+		case err = <-done:
+			assert.NoError(t, err)
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
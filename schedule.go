@@ -0,0 +1,213 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultScheduleInterval is the default interval of the scale schedule checks
+	DefaultScheduleInterval = time.Minute
+)
+
+var (
+	errInvalidSchedule = Errorf(CodeConfig, "invalid scale schedule")
+
+	weekdays = map[string]time.Weekday{
+		"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+		"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+	}
+)
+
+// scaleWindow is a time of day window with a scheduled number of tasks.
+// Windows ending before they start span midnight.
+type scaleWindow struct {
+	from  time.Duration
+	to    time.Duration
+	days  map[time.Weekday]bool
+	count int
+}
+
+// scaleSchedule is the time based scale schedule of a node
+type scaleSchedule struct {
+	windows  []scaleWindow
+	count    int
+	location *time.Location
+	applied  int64 // last applied scale, -1 until the node is started
+}
+
+// newScaleSchedule parses the windows of the <stream>.<node>.tasks.schedule
+// config, scaling the node to the given count outside of the windows.
+// It returns nil if the node has no schedule.
+func newScaleSchedule(config Config, count int, location *time.Location) (sc *scaleSchedule, err error) {
+	windows := config.Array()
+	if len(windows) == 0 {
+		return nil, nil
+	}
+
+	sc = &scaleSchedule{count: count, location: location, applied: -1}
+	for _, c := range windows {
+		var w scaleWindow
+		if w.from, err = timeOfDay(c.Get("from").String("00:00")); err != nil {
+			return nil, err
+		}
+		if w.to, err = timeOfDay(c.Get("to").String("24:00")); err != nil {
+			return nil, err
+		}
+
+		if w.count = c.Get("count").Int(-1); w.count < 0 || w.from == w.to {
+			return nil, errInvalidSchedule
+		}
+
+		for _, day := range c.Get("days").Array() {
+			weekday, ok := weekdays[strings.ToLower(day.String(""))]
+			if !ok {
+				return nil, Errorf(CodeConfig, "%w: unknown day %s", errInvalidSchedule, day.String(""))
+			}
+
+			if w.days == nil {
+				w.days = make(map[time.Weekday]bool)
+			}
+			w.days[weekday] = true
+		}
+
+		sc.windows = append(sc.windows, w)
+	}
+
+	return sc, nil
+}
+
+// timeOfDay parses a HH:MM time of day, allowing 24:00 as the end of the day
+func timeOfDay(value string) (tod time.Duration, err error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, Errorf(CodeConfig, "%w: %s", errInvalidSchedule, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// scale returns the count of the first window matching the given time,
+// or the count outside of the windows
+func (sc *scaleSchedule) scale(now time.Time) (count int) {
+	now = now.In(sc.location)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, sc.location)
+	tod := now.Sub(midnight)
+	yesterday := midnight.AddDate(0, 0, -1).Weekday()
+
+	for _, w := range sc.windows {
+		switch {
+		case w.from < w.to && tod >= w.from && tod < w.to && w.on(now.Weekday()):
+			return w.count
+		// windows spanning midnight start on their days
+		case w.from > w.to && tod >= w.from && w.on(now.Weekday()):
+			return w.count
+		case w.from > w.to && tod < w.to && w.on(yesterday):
+			return w.count
+		}
+	}
+
+	return sc.count
+}
+
+// on returns if the window applies on the given day
+func (w scaleWindow) on(day time.Weekday) (ok bool) {
+	return w.days == nil || w.days[day]
+}
+
+// initialScale returns the scheduled scale of the starting node, marking it
+// as started, or the given count if the node has no schedule
+func (sc *scaleSchedule) initialScale(now time.Time, count int) (scale int) {
+	if sc == nil {
+		return count
+	}
+
+	scale = sc.scale(now)
+	atomic.StoreInt64(&sc.applied, int64(scale))
+	return scale
+}
+
+// scaleScheduler periodically scales the nodes with scale schedules
+type scaleScheduler struct {
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+// startScaleScheduler checks the scale schedules of the started nodes every
+// <stream>.schedules.interval, scaling them on every scheduled scale change.
+// Nodes scaled with Stream.Scale keep their scale until the next change.
+// It returns nil if no node has a scale schedule.
+func startScaleScheduler(s *Stream) (m *scaleScheduler) {
+	var nodes []*Node
+	for _, node := range s.topology.nodes {
+		if node.schedule != nil {
+			nodes = append(nodes, node)
+		}
+	}
+
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	m = &scaleScheduler{done: make(chan struct{})}
+	interval := s.config.Get(s.name, "schedules", "interval").Duration(DefaultScheduleInterval)
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.done:
+				return
+			case now := <-ticker.C:
+				for _, node := range nodes {
+					applied := atomic.LoadInt64(&node.schedule.applied)
+					scale := node.schedule.scale(now)
+					if applied < 0 || int64(scale) == applied || s.stop.stopped() {
+						continue
+					}
+
+					if err := s.Scale(node.name, scale); err != nil {
+						atomic.AddInt64(&node.metrics.errors, 1)
+						s.handleError(Error{Node: node, Error: err, Category: CategoryOf(err)})
+						continue
+					}
+					atomic.StoreInt64(&node.schedule.applied, int64(scale))
+				}
+			}
+		}
+	}()
+
+	return m
+}
+
+// stop the scheduled scaling
+func (m *scaleScheduler) stop() {
+	close(m.done)
+	m.wg.Wait()
+}
@@ -0,0 +1,98 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaleSchedule(t *testing.T) {
+	config := NewConfig(nil)
+	config.Set([]interface{}{
+		map[string]interface{}{"days": []interface{}{"mon", "tue", "wed", "thu", "fri"},
+			"from": "08:00", "to": "18:00", "count": 8},
+		map[string]interface{}{"days": []interface{}{"fri"}, "from": "22:00", "to": "06:00", "count": 1},
+	}, "schedule")
+
+	sc, err := newScaleSchedule(config.Get("schedule"), 2, time.UTC)
+	assert.NoError(t, err)
+
+	at := func(day int, hour, min int) time.Time {
+		// 2018-01-01 is a monday
+		return time.Date(2018, 1, day, hour, min, 0, 0, time.UTC)
+	}
+
+	assert.Equal(t, 8, sc.scale(at(1, 8, 0)))
+	assert.Equal(t, 8, sc.scale(at(5, 17, 59)))
+	assert.Equal(t, 2, sc.scale(at(5, 18, 0)))
+	assert.Equal(t, 2, sc.scale(at(6, 12, 0)))
+	// windows spanning midnight start on their days
+	assert.Equal(t, 1, sc.scale(at(5, 23, 0)))
+	assert.Equal(t, 1, sc.scale(at(6, 5, 59)))
+	assert.Equal(t, 2, sc.scale(at(4, 23, 0)))
+	assert.Equal(t, 2, sc.scale(at(5, 5, 0)))
+
+	sc, err = newScaleSchedule(config.Get("none"), 2, time.UTC)
+	assert.NoError(t, err)
+	assert.Nil(t, sc)
+	assert.Equal(t, 3, sc.initialScale(time.Now(), 3))
+
+	for _, window := range []map[string]interface{}{
+		{"from": "8am", "count": 1},
+		{"from": "08:00", "to": "08:00", "count": 1},
+		{"days": []interface{}{"someday"}, "count": 1},
+		{"from": "08:00"},
+	} {
+		config.Set([]interface{}{window}, "invalid")
+		_, err = newScaleSchedule(config.Get("invalid"), 2, time.UTC)
+		assert.Error(t, err, window)
+	}
+}
+
+func TestStreamScaleSchedule(t *testing.T) {
+	config := NewConfig(nil)
+	config.Set("10ms", "stream.schedules.interval")
+	config.Set("UTC", "stream.schedules.timezone")
+	config.Set([]interface{}{map[string]interface{}{"count": 3}}, "stream.sink.tasks.schedule")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: cycledRecords(0, 1)}
+	}))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	defer stream.Close()
+
+	sink := stream.topology.getNode("sink")
+	assert.Equal(t, 3, stream.tasks[sink].scale())
+
+	// manual scales are kept until the next scheduled change
+	assert.NoError(t, stream.Scale("sink", 1))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, stream.tasks[sink].scale())
+
+	atomic.StoreInt64(&sink.schedule.applied, 2)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, stream.tasks[sink].scale())
+}
@@ -0,0 +1,236 @@
+package schemaregistry
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schema types
+const (
+	Avro       = "AVRO"
+	Protobuf   = "PROTOBUF"
+	JSONSchema = "JSON"
+)
+
+// DefaultTimeout for requests to the schema registry
+const DefaultTimeout = 10 * time.Second
+
+// contentType of the schema registry API
+const contentType = "application/vnd.schemaregistry.v1+json"
+
+// Reference to a schema registered under another subject
+type Reference struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+}
+
+// Schema registered in the schema registry.
+// An empty SchemaType is an Avro schema.
+type Schema struct {
+	ID         int         `json:"id,omitempty"`
+	Subject    string      `json:"subject,omitempty"`
+	Version    int         `json:"version,omitempty"`
+	SchemaType string      `json:"schemaType,omitempty"`
+	Schema     string      `json:"schema"`
+	References []Reference `json:"references,omitempty"`
+}
+
+// Error returned by the schema registry
+type Error struct {
+	Status  int    `json:"-"`
+	Code    int    `json:"error_code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("schema registry error %d: %s", e.Code, e.Message)
+}
+
+// Options for the schema registry client
+type Options struct {
+	Username string        // Basic authentication username
+	Password string        // Basic authentication password
+	Token    string        // Bearer token authentication, used instead of basic authentication if set
+	Timeout  time.Duration // Request timeout, defaults to DefaultTimeout
+	Client   *http.Client  // HTTP client, overrides Timeout if set
+}
+
+// Client is a Confluent compatible schema registry client.
+// Schemas by id and the ids of registered schemas are immutable
+// and cached by the client, which is safe for concurrent use.
+type Client struct {
+	url     string
+	options Options
+	client  *http.Client
+
+	mtx  sync.RWMutex
+	ids  map[int]Schema
+	subs map[string]int
+}
+
+// NewClient creates a schema registry client for the given registry url
+func NewClient(registry string, options Options) (c *Client) {
+	c = &Client{}
+	c.url = strings.TrimRight(registry, "/")
+	c.options = options
+	c.ids = make(map[int]Schema)
+	c.subs = make(map[string]int)
+
+	c.client = options.Client
+	if c.client == nil {
+		timeout := options.Timeout
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
+		c.client = &http.Client{Timeout: timeout}
+	}
+
+	return c
+}
+
+// SchemaByID returns the schema with the given id
+func (c *Client) SchemaByID(id int) (schema Schema, err error) {
+	c.mtx.RLock()
+	schema, ok := c.ids[id]
+	c.mtx.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	if err = c.do(http.MethodGet, "/schemas/ids/"+strconv.Itoa(id), nil, &schema); err != nil {
+		return Schema{}, err
+	}
+	schema.ID = id
+
+	c.mtx.Lock()
+	c.ids[id] = schema
+	c.mtx.Unlock()
+	return schema, nil
+}
+
+// Latest returns the latest schema version registered under the subject
+func (c *Client) Latest(subject string) (schema Schema, err error) {
+	return c.version(subject, "latest")
+}
+
+// Version returns the given schema version registered under the subject
+func (c *Client) Version(subject string, version int) (schema Schema, err error) {
+	return c.version(subject, strconv.Itoa(version))
+}
+
+func (c *Client) version(subject, version string) (schema Schema, err error) {
+	path := "/subjects/" + url.PathEscape(subject) + "/versions/" + version
+	if err = c.do(http.MethodGet, path, nil, &schema); err != nil {
+		return Schema{}, err
+	}
+
+	c.mtx.Lock()
+	c.ids[schema.ID] = schema
+	c.mtx.Unlock()
+	return schema, nil
+}
+
+// Subjects returns the registered subjects
+func (c *Client) Subjects() (subjects []string, err error) {
+	err = c.do(http.MethodGet, "/subjects", nil, &subjects)
+	return subjects, err
+}
+
+// Register the schema under the subject, returning its id.
+// Registering an already registered schema returns its existing id.
+func (c *Client) Register(subject string, schema Schema) (id int, err error) {
+	key := subject + "\x00" + schema.SchemaType + "\x00" + schema.Schema
+
+	c.mtx.RLock()
+	id, ok := c.subs[key]
+	c.mtx.RUnlock()
+	if ok {
+		return id, nil
+	}
+
+	request := Schema{SchemaType: schema.SchemaType, Schema: schema.Schema, References: schema.References}
+	var response struct {
+		ID int `json:"id"`
+	}
+
+	path := "/subjects/" + url.PathEscape(subject) + "/versions"
+	if err = c.do(http.MethodPost, path, request, &response); err != nil {
+		return 0, err
+	}
+
+	c.mtx.Lock()
+	c.subs[key] = response.ID
+	c.mtx.Unlock()
+	return response.ID, nil
+}
+
+// do the request to the registry decoding the response into result
+func (c *Client) do(method, path string, body, result interface{}) (err error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.url+path, reader)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", contentType)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	switch {
+	case c.options.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.options.Token)
+	case c.options.Username != "":
+		req.SetBasicAuth(c.options.Username, c.options.Password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		e := &Error{Status: resp.StatusCode}
+		if err = json.NewDecoder(resp.Body).Decode(e); err != nil || e.Message == "" {
+			e.Code = resp.StatusCode
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+		return e
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
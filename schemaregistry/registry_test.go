@@ -0,0 +1,86 @@
+package schemaregistry
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error_code":401,"message":"unauthorized"}`))
+			return
+		}
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /schemas/ids/1":
+			w.Write([]byte(`{"schema":"\"string\""}`))
+		case "GET /subjects/test-value/versions/latest":
+			w.Write([]byte(`{"id":2,"subject":"test-value","version":3,"schemaType":"JSON","schema":"{}"}`))
+		case "POST /subjects/test-value/versions":
+			var schema Schema
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&schema))
+			assert.Equal(t, JSONSchema, schema.SchemaType)
+			w.Write([]byte(`{"id":2}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code":40403,"message":"schema not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, Options{Username: "user", Password: "pass"})
+
+	schema, err := c.SchemaByID(1)
+	assert.NoError(t, err)
+	assert.Equal(t, Schema{ID: 1, Schema: `"string"`}, schema)
+
+	// schemas by id are cached
+	_, err = c.SchemaByID(1)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	schema, err = c.Latest("test-value")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, schema.ID)
+	assert.Equal(t, 3, schema.Version)
+
+	id, err := c.Register("test-value", Schema{SchemaType: JSONSchema, Schema: "{}"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, id)
+	_, err = c.Register("test-value", Schema{SchemaType: JSONSchema, Schema: "{}"})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	_, err = c.SchemaByID(5)
+	assert.Equal(t, &Error{Status: http.StatusNotFound, Code: 40403, Message: "schema not found"}, err)
+
+	_, err = NewClient(server.URL, Options{}).SchemaByID(1)
+	assert.Equal(t, http.StatusUnauthorized, err.(*Error).Status)
+}
@@ -0,0 +1,249 @@
+package schemaregistry
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/protoconv"
+	"github.com/brunotm/streams/serde"
+)
+
+// magicByte starts the values framed with their schema id
+const magicByte = 0
+
+var (
+	// ErrInvalidFraming is returned when decoding values not framed with the
+	// magic byte and schema id
+	ErrInvalidFraming = streams.Errorf(streams.CodeSerialization, "invalid schema registry framing")
+
+	errSchemaType = streams.Errorf(streams.CodeSerialization, "unexpected schema type")
+)
+
+// writer resolves the id of the schema values are encoded with, registering
+// the schema under the subject, or using the latest schema of the subject if
+// no schema is given. The id is resolved once, retrying after failures.
+type writer struct {
+	client  *Client
+	subject string
+	schema  Schema
+
+	mtx    sync.Mutex
+	id     int
+	parsed interface{}
+}
+
+// resolve the writer schema id and its parsed form
+func (w *writer) resolve(parse func(schema string) (interface{}, error)) (id int, parsed interface{}, err error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.parsed != nil {
+		return w.id, w.parsed, nil
+	}
+
+	schema := w.schema
+	switch {
+	case schema.Schema != "":
+		if schema.ID, err = w.client.Register(w.subject, schema); err != nil {
+			return 0, nil, err
+		}
+	default:
+		if schema, err = w.client.Latest(w.subject); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if parsed, err = parse(schema.Schema); err != nil {
+		return 0, nil, err
+	}
+
+	w.id, w.parsed = schema.ID, parsed
+	return w.id, w.parsed, nil
+}
+
+// frame prefixes the payload with the magic byte, the schema id and the given prefix
+func frame(id int, prefix, payload []byte) (data []byte) {
+	data = make([]byte, 5, 5+len(prefix)+len(payload))
+	data[0] = magicByte
+	binary.BigEndian.PutUint32(data[1:], uint32(id))
+	data = append(data, prefix...)
+	return append(data, payload...)
+}
+
+// unframe returns the schema id and the payload of the framed data
+func unframe(data []byte) (id int, payload []byte, err error) {
+	if len(data) < 5 || data[0] != magicByte {
+		return 0, nil, ErrInvalidFraming
+	}
+	return int(binary.BigEndian.Uint32(data[1:])), data[5:], nil
+}
+
+// avroCodec encodes avro values framed with their schema id
+type avroCodec struct {
+	client *Client
+	writer *writer
+
+	mtx    sync.RWMutex
+	codecs map[int]serde.Codec
+}
+
+// NewAvroCodec creates a serde.Codec for avro values framed with the magic
+// byte and the id of their schema, interoperable with Confluent serializers.
+// Values are encoded with the given schema, registered under the subject,
+// or with the latest schema of the subject if the schema is empty.
+// Values are decoded with the schema of their id, fetched from the registry.
+func NewAvroCodec(client *Client, subject, schema string) (codec serde.Codec) {
+	c := &avroCodec{client: client}
+	c.writer = &writer{client: client, subject: subject, schema: Schema{Schema: schema}}
+	c.codecs = make(map[int]serde.Codec)
+	return c
+}
+
+// Encode the value with the writer schema
+func (c *avroCodec) Encode(value interface{}) (data []byte, err error) {
+	id, parsed, err := c.writer.resolve(func(schema string) (interface{}, error) {
+		return serde.NewAvro(schema)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := parsed.(serde.Codec).Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return frame(id, nil, payload), nil
+}
+
+// Decode the value with the schema of its id
+func (c *avroCodec) Decode(data []byte) (value interface{}, err error) {
+	id, payload, err := unframe(data)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.RLock()
+	codec, ok := c.codecs[id]
+	c.mtx.RUnlock()
+
+	if !ok {
+		schema, err := c.client.SchemaByID(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if schema.SchemaType != "" && schema.SchemaType != Avro {
+			return nil, streams.Errorf(streams.CodeSerialization, "%w: %s", errSchemaType, schema.SchemaType)
+		}
+
+		if codec, err = serde.NewAvro(schema.Schema); err != nil {
+			return nil, err
+		}
+
+		c.mtx.Lock()
+		c.codecs[id] = codec
+		c.mtx.Unlock()
+	}
+
+	return codec.Decode(payload)
+}
+
+// protobufCodec encodes protobuf messages framed with their schema id and message indexes
+type protobufCodec struct {
+	client  *Client
+	writer  *writer
+	codec   serde.Codec
+	indexes []byte
+}
+
+// NewProtobufCodec creates a serde.Codec for protobuf messages framed with the
+// magic byte, the id of their schema and the indexes of the message within the
+// schema, interoperable with Confluent serializers. Messages are encoded and
+// decoded with the descriptor of the message in the protoconv registry.
+// The proto schema is registered under the subject, or the latest schema of
+// the subject is used if the schema is empty. The indexes locate the message
+// within the schema file, defaulting to the first message.
+func NewProtobufCodec(client *Client, subject, schema string,
+	registry *protoconv.Registry, message string, indexes ...int) (codec serde.Codec) {
+
+	c := &protobufCodec{client: client}
+	c.writer = &writer{client: client, subject: subject, schema: Schema{SchemaType: Protobuf, Schema: schema}}
+	c.codec = serde.NewProtobuf(registry, message)
+
+	if len(indexes) == 0 || (len(indexes) == 1 && indexes[0] == 0) {
+		c.indexes = []byte{0}
+		return c
+	}
+
+	var tmp [binary.MaxVarintLen64]byte
+	c.indexes = append(c.indexes, tmp[:binary.PutVarint(tmp[:], int64(len(indexes)))]...)
+	for _, index := range indexes {
+		c.indexes = append(c.indexes, tmp[:binary.PutVarint(tmp[:], int64(index))]...)
+	}
+	return c
+}
+
+// Encode the message with the writer schema id
+func (c *protobufCodec) Encode(value interface{}) (data []byte, err error) {
+	id, _, err := c.writer.resolve(func(schema string) (interface{}, error) {
+		return schema, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := c.codec.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return frame(id, c.indexes, payload), nil
+}
+
+// Decode the message, checking that its schema id is a registered protobuf schema
+func (c *protobufCodec) Decode(data []byte) (value interface{}, err error) {
+	id, payload, err := unframe(data)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := c.client.SchemaByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if schema.SchemaType != Protobuf {
+		return nil, streams.Errorf(streams.CodeSerialization, "%w: %s", errSchemaType, schema.SchemaType)
+	}
+
+	count, n := binary.Varint(payload)
+	if n <= 0 || count < 0 || count > int64(len(payload)) {
+		return nil, ErrInvalidFraming
+	}
+	payload = payload[n:]
+
+	for ; count > 0; count-- {
+		if _, n = binary.Varint(payload); n <= 0 {
+			return nil, ErrInvalidFraming
+		}
+		payload = payload[n:]
+	}
+
+	return c.codec.Decode(payload)
+}
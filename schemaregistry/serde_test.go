@@ -0,0 +1,100 @@
+package schemaregistry
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/brunotm/streams/protoconv"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAvro = `{"type":"record","name":"User","fields":[{"name":"name","type":"string"}]}`
+
+func TestCodecs(t *testing.T) {
+	var registered int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /subjects/users-value/versions":
+			atomic.AddInt32(&registered, 1)
+			w.Write([]byte(`{"id":7}`))
+		case "GET /subjects/events-value/versions/latest":
+			w.Write([]byte(`{"id":9,"subject":"events-value","version":1,"schemaType":"PROTOBUF","schema":"message Event {}"}`))
+		case "GET /schemas/ids/7":
+			data, _ := json.Marshal(Schema{Schema: testAvro})
+			w.Write(data)
+		case "GET /schemas/ids/9":
+			w.Write([]byte(`{"schemaType":"PROTOBUF","schema":"message Event {}"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code":40403,"message":"schema not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, Options{})
+
+	avro := NewAvroCodec(client, "users-value", testAvro)
+	data, err := avro.Encode(map[string]interface{}{"name": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 7, 0x02, 'a'}, data)
+
+	// the writer schema is registered once
+	_, err = avro.Encode(map[string]interface{}{"name": "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&registered))
+
+	value, err := NewAvroCodec(client, "users-value", "").Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "a"}, value)
+
+	_, err = avro.Decode([]byte{1, 0, 0, 0, 7})
+	assert.Equal(t, ErrInvalidFraming, err)
+
+	registry := protoconv.NewRegistry()
+	assert.NoError(t, registry.Register(protoconv.Descriptor{Name: "Event", Fields: []protoconv.Field{
+		{Number: 1, Name: "name", Type: protoconv.String},
+	}}))
+
+	proto := NewProtobufCodec(client, "events-value", "", registry, "Event")
+	data, err = proto.Encode(map[string]interface{}{"name": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 9, 0, 0x0a, 0x01, 'a'}, data)
+
+	value, err = proto.Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "a"}, value)
+
+	// nested messages are framed with their indexes
+	data, err = NewProtobufCodec(client, "events-value", "", registry, "Event", 1, 0).
+		Encode(map[string]interface{}{"name": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 9, 0x04, 0x02, 0x00, 0x0a, 0x01, 'a'}, data)
+
+	value, err = proto.Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "a"}, value)
+
+	// avro values are not decoded as protobuf messages
+	_, err = proto.Decode([]byte{0, 0, 0, 0, 7, 0, 0x0a, 0x01, 'a'})
+	assert.Error(t, err)
+}
@@ -0,0 +1,622 @@
+package serde
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/brunotm/streams"
+)
+
+var (
+	errInvalidSchema = streams.Errorf(streams.CodeSerialization, "invalid avro schema")
+	errMalformedAvro = streams.Errorf(streams.CodeSerialization, "malformed avro data")
+)
+
+// avroPrimitives are the avro primitive types
+var avroPrimitives = map[string]bool{"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true}
+
+// avroSchema is a parsed avro schema
+type avroSchema struct {
+	typ     string        // type name, or union
+	name    string        // full name of records, enums and fixed types
+	fields  []avroField   // record fields
+	symbols []string      // enum symbols
+	items   *avroSchema   // array items
+	values  *avroSchema   // map values
+	size    int           // fixed size
+	union   []*avroSchema // union branches
+}
+
+// avroField is a record field
+type avroField struct {
+	name       string
+	schema     *avroSchema
+	def        interface{}
+	hasDefault bool
+}
+
+// avroCodec encodes values with the avro binary encoding of a schema
+type avroCodec struct {
+	schema *avroSchema
+}
+
+// NewAvro creates a Codec for the avro binary encoding of the given json schema.
+// Records are encoded from and decoded to objects, enums from and to symbol
+// strings, and unions from and to the value of the first branch matching the
+// value type. Missing record fields are encoded with their default values.
+// Logical types are encoded as their underlying types.
+func NewAvro(schema string) (codec Codec, err error) {
+	var raw interface{}
+	if err = json.Unmarshal([]byte(schema), &raw); err != nil {
+		return nil, err
+	}
+
+	parsed, err := parseAvro(raw, "", make(map[string]*avroSchema))
+	if err != nil {
+		return nil, err
+	}
+	return &avroCodec{schema: parsed}, nil
+}
+
+// Encode the value with the avro binary encoding
+func (c *avroCodec) Encode(value interface{}) (data []byte, err error) {
+	if value, err = normalize(value); err != nil {
+		return nil, err
+	}
+	return c.schema.encode(nil, value)
+}
+
+// Decode the avro binary encoded data
+func (c *avroCodec) Decode(data []byte) (value interface{}, err error) {
+	value, rest, err := c.schema.decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) > 0 {
+		return nil, errMalformedAvro
+	}
+	return value, nil
+}
+
+// parseAvro parses the json schema within the namespace,
+// registering the named types
+func parseAvro(raw interface{}, namespace string, named map[string]*avroSchema) (s *avroSchema, err error) {
+	switch v := raw.(type) {
+	case string:
+		if avroPrimitives[v] {
+			return &avroSchema{typ: v}, nil
+		}
+
+		if s = named[v]; s == nil && namespace != "" {
+			s = named[namespace+"."+v]
+		}
+		if s == nil {
+			return nil, streams.Errorf(streams.CodeSerialization, "%w: unknown avro type %s", errInvalidSchema, v)
+		}
+		return s, nil
+
+	case []interface{}:
+		s = &avroSchema{typ: "union"}
+		for _, branch := range v {
+			b, err := parseAvro(branch, namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			s.union = append(s.union, b)
+		}
+		return s, nil
+
+	case map[string]interface{}:
+		typ, ok := v["type"].(string)
+		if !ok {
+			return parseAvro(v["type"], namespace, named)
+		}
+
+		switch typ {
+		case "record", "error", "enum", "fixed":
+			return parseAvroNamed(v, typ, namespace, named)
+
+		case "array":
+			s = &avroSchema{typ: typ}
+			s.items, err = parseAvro(v["items"], namespace, named)
+			return s, err
+
+		case "map":
+			s = &avroSchema{typ: typ}
+			s.values, err = parseAvro(v["values"], namespace, named)
+			return s, err
+		}
+
+		return parseAvro(typ, namespace, named)
+	}
+
+	return nil, errInvalidSchema
+}
+
+// parseAvroNamed parses the record, enum or fixed schema
+func parseAvroNamed(v map[string]interface{}, typ, namespace string, named map[string]*avroSchema) (s *avroSchema, err error) {
+	name, _ := v["name"].(string)
+	if name == "" {
+		return nil, errInvalidSchema
+	}
+
+	if ns, ok := v["namespace"].(string); ok {
+		namespace = ns
+	}
+
+	if !strings.Contains(name, ".") && namespace != "" {
+		name = namespace + "." + name
+	}
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		namespace = name[:idx]
+	}
+
+	s = &avroSchema{typ: typ, name: name}
+	if typ == "error" {
+		s.typ = "record"
+	}
+	// Registered before the fields for recursive records
+	named[name] = s
+
+	switch s.typ {
+	case "record":
+		fields, _ := v["fields"].([]interface{})
+		for _, f := range fields {
+			field, _ := f.(map[string]interface{})
+			fname, _ := field["name"].(string)
+			if fname == "" {
+				return nil, errInvalidSchema
+			}
+
+			schema, err := parseAvro(field["type"], namespace, named)
+			if err != nil {
+				return nil, err
+			}
+
+			def, hasDefault := field["default"]
+			s.fields = append(s.fields, avroField{name: fname, schema: schema, def: def, hasDefault: hasDefault})
+		}
+
+	case "enum":
+		symbols, _ := v["symbols"].([]interface{})
+		for _, symbol := range symbols {
+			name, ok := symbol.(string)
+			if !ok {
+				return nil, errInvalidSchema
+			}
+			s.symbols = append(s.symbols, name)
+		}
+
+	case "fixed":
+		size, ok := v["size"].(float64)
+		if !ok || size < 0 {
+			return nil, errInvalidSchema
+		}
+		s.size = int(size)
+	}
+
+	return s, nil
+}
+
+// encode appends the normalized value with the avro binary encoding
+func (s *avroSchema) encode(data []byte, value interface{}) (encoded []byte, err error) {
+	var tmp [binary.MaxVarintLen64]byte
+
+	switch s.typ {
+	case "null":
+		if value != nil {
+			return nil, errInvalidValue
+		}
+		return data, nil
+
+	case "boolean":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, errInvalidValue
+		}
+		if b {
+			return append(data, 1), nil
+		}
+		return append(data, 0), nil
+
+	case "int", "long":
+		i, err := toInt64(value)
+		if err != nil || (s.typ == "int" && (i < math.MinInt32 || i > math.MaxInt32)) {
+			return nil, errInvalidValue
+		}
+		return append(data, tmp[:binary.PutVarint(tmp[:], i)]...), nil
+
+	case "float":
+		f, err := toFloat64(value)
+		if err != nil {
+			return nil, errInvalidValue
+		}
+		binary.LittleEndian.PutUint32(tmp[:], math.Float32bits(float32(f)))
+		return append(data, tmp[:4]...), nil
+
+	case "double":
+		f, err := toFloat64(value)
+		if err != nil {
+			return nil, errInvalidValue
+		}
+		binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(f))
+		return append(data, tmp[:8]...), nil
+
+	case "bytes", "string":
+		var b []byte
+		switch v := value.(type) {
+		case string:
+			b = []byte(v)
+		case []byte:
+			b = v
+		default:
+			return nil, errInvalidValue
+		}
+		data = append(data, tmp[:binary.PutVarint(tmp[:], int64(len(b)))]...)
+		return append(data, b...), nil
+
+	case "fixed":
+		var b []byte
+		switch v := value.(type) {
+		case string:
+			b = []byte(v)
+		case []byte:
+			b = v
+		}
+		if len(b) != s.size {
+			return nil, errInvalidValue
+		}
+		return append(data, b...), nil
+
+	case "enum":
+		symbol, _ := value.(string)
+		for x := range s.symbols {
+			if s.symbols[x] == symbol {
+				return append(data, tmp[:binary.PutVarint(tmp[:], int64(x))]...), nil
+			}
+		}
+		return nil, errInvalidValue
+
+	case "record":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errInvalidValue
+		}
+
+		for _, field := range s.fields {
+			v, ok := object[field.name]
+			if !ok && field.hasDefault {
+				v = field.def
+			}
+
+			if data, err = field.schema.encode(data, v); err != nil {
+				return nil, fmt.Errorf("%s.%s: %s", s.name, field.name, err)
+			}
+		}
+		return data, nil
+
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, errInvalidValue
+		}
+
+		if len(items) > 0 {
+			data = append(data, tmp[:binary.PutVarint(tmp[:], int64(len(items)))]...)
+			for _, item := range items {
+				if data, err = s.items.encode(data, item); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return append(data, 0), nil
+
+	case "map":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errInvalidValue
+		}
+
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		if len(keys) > 0 {
+			data = append(data, tmp[:binary.PutVarint(tmp[:], int64(len(keys)))]...)
+			for _, key := range keys {
+				data = append(data, tmp[:binary.PutVarint(tmp[:], int64(len(key)))]...)
+				data = append(data, key...)
+				if data, err = s.values.encode(data, object[key]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return append(data, 0), nil
+
+	case "union":
+		for x, branch := range s.union {
+			if branch.matches(value) {
+				data = append(data, tmp[:binary.PutVarint(tmp[:], int64(x))]...)
+				return branch.encode(data, value)
+			}
+		}
+		return nil, errInvalidValue
+	}
+
+	return nil, errInvalidSchema
+}
+
+// matches returns if the normalized value can be encoded with the schema,
+// choosing the union branch of the value
+func (s *avroSchema) matches(value interface{}) (ok bool) {
+	switch v := value.(type) {
+	case nil:
+		return s.typ == "null"
+	case bool:
+		return s.typ == "boolean"
+	case string:
+		switch s.typ {
+		case "string", "bytes":
+			return true
+		case "fixed":
+			return len(v) == s.size
+		case "enum":
+			for _, symbol := range s.symbols {
+				if symbol == v {
+					return true
+				}
+			}
+		}
+		return false
+	case []byte:
+		return s.typ == "bytes" || s.typ == "string" || (s.typ == "fixed" && len(v) == s.size)
+	case []interface{}:
+		return s.typ == "array"
+	case map[string]interface{}:
+		return s.typ == "record" || s.typ == "map"
+	case float32, float64:
+		return s.typ == "float" || s.typ == "double"
+	case json.Number:
+		if _, err := v.Int64(); err != nil {
+			return s.typ == "float" || s.typ == "double"
+		}
+	}
+
+	// integers
+	switch s.typ {
+	case "int":
+		i, err := toInt64(value)
+		return err == nil && i >= math.MinInt32 && i <= math.MaxInt32
+	case "long", "float", "double":
+		return true
+	}
+	return false
+}
+
+// decode the avro binary encoded value, returning the remaining data
+func (s *avroSchema) decode(data []byte) (value interface{}, rest []byte, err error) {
+	switch s.typ {
+	case "null":
+		return nil, data, nil
+
+	case "boolean":
+		if len(data) < 1 {
+			return nil, nil, errMalformedAvro
+		}
+		return data[0] != 0, data[1:], nil
+
+	case "int", "long":
+		return readAvroLong(data)
+
+	case "float":
+		if len(data) < 4 {
+			return nil, nil, errMalformedAvro
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(data)), data[4:], nil
+
+	case "double":
+		if len(data) < 8 {
+			return nil, nil, errMalformedAvro
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), data[8:], nil
+
+	case "bytes", "string":
+		b, rest, err := readAvroBytes(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if s.typ == "string" {
+			return string(b), rest, nil
+		}
+		return append([]byte(nil), b...), rest, nil
+
+	case "fixed":
+		if len(data) < s.size {
+			return nil, nil, errMalformedAvro
+		}
+		return append([]byte(nil), data[:s.size]...), data[s.size:], nil
+
+	case "enum":
+		idx, rest, err := readAvroLong(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if idx < 0 || idx >= int64(len(s.symbols)) {
+			return nil, nil, errMalformedAvro
+		}
+		return s.symbols[idx], rest, nil
+
+	case "record":
+		object := make(map[string]interface{}, len(s.fields))
+		for _, field := range s.fields {
+			if object[field.name], data, err = field.schema.decode(data); err != nil {
+				return nil, nil, err
+			}
+		}
+		return object, data, nil
+
+	case "array":
+		items := []interface{}{}
+		data, err = readAvroBlocks(data, func(data []byte) (rest []byte, err error) {
+			var item interface{}
+			if item, rest, err = s.items.decode(data); err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			return rest, nil
+		})
+		return items, data, err
+
+	case "map":
+		object := make(map[string]interface{})
+		data, err = readAvroBlocks(data, func(data []byte) (rest []byte, err error) {
+			var key []byte
+			if key, rest, err = readAvroBytes(data); err != nil {
+				return nil, err
+			}
+			object[string(key)], rest, err = s.values.decode(rest)
+			return rest, err
+		})
+		return object, data, err
+
+	case "union":
+		idx, rest, err := readAvroLong(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if idx < 0 || idx >= int64(len(s.union)) {
+			return nil, nil, errMalformedAvro
+		}
+		return s.union[idx].decode(rest)
+	}
+
+	return nil, nil, errInvalidSchema
+}
+
+// readAvroLong reads a zigzag varint
+func readAvroLong(data []byte) (value int64, rest []byte, err error) {
+	value, n := binary.Varint(data)
+	if n <= 0 {
+		return 0, nil, errMalformedAvro
+	}
+	return value, data[n:], nil
+}
+
+// readAvroBytes reads long length prefixed bytes
+func readAvroBytes(data []byte) (b, rest []byte, err error) {
+	size, rest, err := readAvroLong(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if size < 0 || int64(len(rest)) < size {
+		return nil, nil, errMalformedAvro
+	}
+	return rest[:size], rest[size:], nil
+}
+
+// readAvroBlocks reads the blocks of array items or map entries until an
+// empty block. Blocks with a negative count are followed by their byte size.
+func readAvroBlocks(data []byte, item func(data []byte) (rest []byte, err error)) (rest []byte, err error) {
+	for {
+		var count int64
+		if count, data, err = readAvroLong(data); err != nil {
+			return nil, err
+		}
+
+		if count == 0 {
+			return data, nil
+		}
+
+		if count < 0 {
+			count = -count
+			if _, data, err = readAvroLong(data); err != nil {
+				return nil, err
+			}
+		}
+
+		if count > int64(len(data)) {
+			return nil, errMalformedAvro
+		}
+
+		for ; count > 0; count-- {
+			if data, err = item(data); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// toInt64 converts the normalized integer value
+func toInt64(value interface{}) (i int64, err error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, errInvalidValue
+		}
+		return int64(v), nil
+	case json.Number:
+		return v.Int64()
+	case float64:
+		if v == math.Trunc(v) {
+			return int64(v), nil
+		}
+	}
+	return 0, errInvalidValue
+}
+
+// toFloat64 converts the normalized numeric value
+func toFloat64(value interface{}) (f float64, err error) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case json.Number:
+		return v.Float64()
+	}
+
+	i, err := toInt64(value)
+	return float64(i), err
+}
@@ -0,0 +1,98 @@
+package serde
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSchema = `{
+	"type": "record", "name": "User", "namespace": "test",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "name", "type": "string"},
+		{"name": "score", "type": "double"},
+		{"name": "ratio", "type": "float"},
+		{"name": "active", "type": "boolean", "default": true},
+		{"name": "email", "type": ["null", "string"], "default": null},
+		{"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["A", "B"]}},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "attrs", "type": {"type": "map", "values": "int"}},
+		{"name": "hash", "type": {"type": "fixed", "name": "Hash", "size": 2}},
+		{"name": "next", "type": ["null", "test.User"], "default": null}
+	]
+}`
+
+func TestAvro(t *testing.T) {
+	codec, err := NewAvro(testSchema)
+	assert.NoError(t, err)
+
+	data, err := codec.Encode(map[string]interface{}{
+		"id":    -2,
+		"name":  "a",
+		"score": 1.5,
+		"ratio": 0.5,
+		"email": "a@b",
+		"kind":  "B",
+		"tags":  []interface{}{"x"},
+		"attrs": map[string]interface{}{"b": 2, "a": 1},
+		"hash":  []byte{1, 2},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x03,      // id
+		0x02, 'a', // name
+		0, 0, 0, 0, 0, 0, 0xf8, 0x3f, // score
+		0, 0, 0, 0x3f, // ratio
+		0x01,                      // active default
+		0x02, 0x06, 'a', '@', 'b', // email union branch 1
+		0x02,                  // kind
+		0x02, 0x02, 'x', 0x00, // tags
+		0x04, 0x02, 'a', 0x02, 0x02, 'b', 0x04, 0x00, // attrs in key order
+		0x01, 0x02, // hash
+		0x00, // next null
+	}, data)
+
+	decoded, err := codec.Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"id":     int64(-2),
+		"name":   "a",
+		"score":  1.5,
+		"ratio":  float32(0.5),
+		"active": true,
+		"email":  "a@b",
+		"kind":   "B",
+		"tags":   []interface{}{"x"},
+		"attrs":  map[string]interface{}{"a": int64(1), "b": int64(2)},
+		"hash":   []byte{1, 2},
+		"next":   nil,
+	}, decoded)
+
+	_, err = codec.Decode(data[:len(data)-1])
+	assert.Error(t, err)
+	_, err = codec.Decode(append(data, 0))
+	assert.Error(t, err)
+
+	_, err = codec.Encode(map[string]interface{}{"id": 1})
+	assert.Error(t, err)
+
+	_, err = NewAvro(`{"type": "record", "name": "R", "fields": [{"name": "a", "type": "Unknown"}]}`)
+	assert.Error(t, err)
+}
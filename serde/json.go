@@ -0,0 +1,43 @@
+package serde
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// JSON is the json Codec. Numbers are decoded as json.Number,
+// preserving the precision of large integers.
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+// Encode the value as json
+func (jsonCodec) Encode(value interface{}) (data []byte, err error) {
+	return json.Marshal(value)
+}
+
+// Decode the json data
+func (jsonCodec) Decode(data []byte) (value interface{}, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
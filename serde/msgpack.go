@@ -0,0 +1,332 @@
+package serde
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/brunotm/streams"
+)
+
+var (
+	errMalformedMsgpack = streams.Errorf(streams.CodeSerialization, "malformed msgpack data")
+)
+
+// msgpackSizes are the sizes of the fixed size values and length headers
+// following the msgpack format byte
+var msgpackSizes = map[byte]int{0xca: 4, 0xcb: 8, 0xcc: 1, 0xcd: 2, 0xce: 4, 0xcf: 8,
+	0xd0: 1, 0xd1: 2, 0xd2: 4, 0xd3: 8, 0xc4: 1, 0xc5: 2, 0xc6: 4, 0xd9: 1,
+	0xda: 2, 0xdb: 4, 0xdc: 2, 0xdd: 4, 0xde: 2, 0xdf: 4}
+
+// Msgpack is the MessagePack Codec. Integers are decoded as int64, or uint64
+// if greater than the maximum int64, and extension types are not supported.
+// Map keys are encoded in sorted order.
+var Msgpack Codec = msgpackCodec{}
+
+type msgpackCodec struct{}
+
+// Encode the value as msgpack
+func (msgpackCodec) Encode(value interface{}) (data []byte, err error) {
+	if value, err = normalize(value); err != nil {
+		return nil, err
+	}
+	return appendMsgpack(nil, value)
+}
+
+// Decode the msgpack data
+func (msgpackCodec) Decode(data []byte) (value interface{}, err error) {
+	value, rest, err := readMsgpack(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) > 0 {
+		return nil, errMalformedMsgpack
+	}
+	return value, nil
+}
+
+// appendMsgpack appends the normalized value encoded as msgpack
+func appendMsgpack(data []byte, value interface{}) (encoded []byte, err error) {
+	switch v := value.(type) {
+	case nil:
+		return append(data, 0xc0), nil
+
+	case bool:
+		if v {
+			return append(data, 0xc3), nil
+		}
+		return append(data, 0xc2), nil
+
+	case int:
+		return appendMsgpackInt(data, int64(v)), nil
+	case int8:
+		return appendMsgpackInt(data, int64(v)), nil
+	case int16:
+		return appendMsgpackInt(data, int64(v)), nil
+	case int32:
+		return appendMsgpackInt(data, int64(v)), nil
+	case int64:
+		return appendMsgpackInt(data, v), nil
+	case uint:
+		return appendMsgpackUint(data, uint64(v)), nil
+	case uint8:
+		return appendMsgpackUint(data, uint64(v)), nil
+	case uint16:
+		return appendMsgpackUint(data, uint64(v)), nil
+	case uint32:
+		return appendMsgpackUint(data, uint64(v)), nil
+	case uint64:
+		return appendMsgpackUint(data, v), nil
+
+	case float32:
+		data = append(data, 0xca)
+		return appendBigEndian(data, uint64(math.Float32bits(v)), 4), nil
+
+	case float64:
+		data = append(data, 0xcb)
+		return appendBigEndian(data, math.Float64bits(v), 8), nil
+
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendMsgpackInt(data, i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return appendMsgpack(data, f)
+
+	case string:
+		data = appendMsgpackLength(data, len(v), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		return append(data, v...), nil
+
+	case []byte:
+		data = appendMsgpackLength(data, len(v), 0, 0, 0xc4, 0xc5, 0xc6)
+		return append(data, v...), nil
+
+	case []interface{}:
+		data = appendMsgpackLength(data, len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			if data, err = appendMsgpack(data, item); err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		data = appendMsgpackLength(data, len(v), 0x80, 16, 0, 0xde, 0xdf)
+		for _, key := range keys {
+			if data, err = appendMsgpack(data, key); err != nil {
+				return nil, err
+			}
+			if data, err = appendMsgpack(data, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	}
+
+	return nil, errInvalidValue
+}
+
+// appendMsgpackInt appends the integer in its smallest msgpack format
+func appendMsgpackInt(data []byte, v int64) (encoded []byte) {
+	switch {
+	case v >= 0:
+		return appendMsgpackUint(data, uint64(v))
+	case v >= -32:
+		return append(data, byte(v))
+	case v >= math.MinInt8:
+		return append(data, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return appendBigEndian(append(data, 0xd1), uint64(uint16(v)), 2)
+	case v >= math.MinInt32:
+		return appendBigEndian(append(data, 0xd2), uint64(uint32(v)), 4)
+	}
+	return appendBigEndian(append(data, 0xd3), uint64(v), 8)
+}
+
+// appendMsgpackUint appends the unsigned integer in its smallest msgpack format
+func appendMsgpackUint(data []byte, v uint64) (encoded []byte) {
+	switch {
+	case v <= 127:
+		return append(data, byte(v))
+	case v <= math.MaxUint8:
+		return append(data, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return appendBigEndian(append(data, 0xcd), uint64(uint16(v)), 2)
+	case v <= math.MaxUint32:
+		return appendBigEndian(append(data, 0xce), uint64(uint32(v)), 4)
+	}
+	return appendBigEndian(append(data, 0xcf), v, 8)
+}
+
+// appendMsgpackLength appends the length header of a string, binary, array or
+// map with the fix, 8, 16 and 32 bit formats. A zero format is not available.
+func appendMsgpackLength(data []byte, n int, fix byte, fixMax int, f8, f16, f32 byte) (encoded []byte) {
+	switch {
+	case fix != 0 && n < fixMax:
+		return append(data, fix|byte(n))
+	case f8 != 0 && n <= math.MaxUint8:
+		return append(data, f8, byte(n))
+	case n <= math.MaxUint16:
+		return appendBigEndian(append(data, f16), uint64(uint16(n)), 2)
+	}
+	return appendBigEndian(append(data, f32), uint64(uint32(n)), 4)
+}
+
+// appendBigEndian appends the size lower bytes of v in big endian order
+func appendBigEndian(data []byte, v uint64, size int) (encoded []byte) {
+	for shift := uint(8 * (size - 1)); ; shift -= 8 {
+		data = append(data, byte(v>>shift))
+		if shift == 0 {
+			return data
+		}
+	}
+}
+
+// readMsgpack reads a msgpack value, returning the remaining data
+func readMsgpack(data []byte) (value interface{}, rest []byte, err error) {
+	if len(data) == 0 {
+		return nil, nil, errMalformedMsgpack
+	}
+
+	b, data := data[0], data[1:]
+	switch {
+	case b <= 0x7f:
+		return int64(b), data, nil
+	case b >= 0xe0:
+		return int64(int8(b)), data, nil
+	case b&0xe0 == 0xa0:
+		return readMsgpackString(data, int(b&0x1f))
+	case b&0xf0 == 0x90:
+		return readMsgpackArray(data, int(b&0x0f))
+	case b&0xf0 == 0x80:
+		return readMsgpackMap(data, int(b&0x0f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, data, nil
+	case 0xc2:
+		return false, data, nil
+	case 0xc3:
+		return true, data, nil
+	}
+
+	size, ok := msgpackSizes[b]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported msgpack format 0x%x", b)
+	}
+
+	if len(data) < size {
+		return nil, nil, errMalformedMsgpack
+	}
+
+	var u uint64
+	for _, c := range data[:size] {
+		u = u<<8 | uint64(c)
+	}
+	data = data[size:]
+
+	switch b {
+	case 0xca:
+		return math.Float32frombits(uint32(u)), data, nil
+	case 0xcb:
+		return math.Float64frombits(u), data, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		if u > math.MaxInt64 {
+			return u, data, nil
+		}
+		return int64(u), data, nil
+	case 0xd0:
+		return int64(int8(u)), data, nil
+	case 0xd1:
+		return int64(int16(u)), data, nil
+	case 0xd2:
+		return int64(int32(u)), data, nil
+	case 0xd3:
+		return int64(u), data, nil
+	case 0xc4, 0xc5, 0xc6:
+		if uint64(len(data)) < u {
+			return nil, nil, errMalformedMsgpack
+		}
+		return append([]byte(nil), data[:u]...), data[u:], nil
+	case 0xd9, 0xda, 0xdb:
+		return readMsgpackString(data, int(u))
+	case 0xdc, 0xdd:
+		return readMsgpackArray(data, int(u))
+	}
+	return readMsgpackMap(data, int(u))
+}
+
+func readMsgpackString(data []byte, n int) (value interface{}, rest []byte, err error) {
+	if n < 0 || len(data) < n {
+		return nil, nil, errMalformedMsgpack
+	}
+	return string(data[:n]), data[n:], nil
+}
+
+func readMsgpackArray(data []byte, n int) (value interface{}, rest []byte, err error) {
+	if n < 0 || len(data) < n {
+		return nil, nil, errMalformedMsgpack
+	}
+
+	items := make([]interface{}, n)
+	for x := range items {
+		if items[x], data, err = readMsgpack(data); err != nil {
+			return nil, nil, err
+		}
+	}
+	return items, data, nil
+}
+
+// readMsgpackMap reads a map, with keys of other types than strings
+// formatted as strings
+func readMsgpackMap(data []byte, n int) (value interface{}, rest []byte, err error) {
+	if n < 0 || len(data) < 2*n {
+		return nil, nil, errMalformedMsgpack
+	}
+
+	object := make(map[string]interface{}, n)
+	for x := 0; x < n; x++ {
+		var key, item interface{}
+		if key, data, err = readMsgpack(data); err != nil {
+			return nil, nil, err
+		}
+		if item, data, err = readMsgpack(data); err != nil {
+			return nil, nil, err
+		}
+
+		name, ok := key.(string)
+		if !ok {
+			name = fmt.Sprint(key)
+		}
+		object[name] = item
+	}
+	return object, data, nil
+}
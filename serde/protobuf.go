@@ -0,0 +1,55 @@
+package serde
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"github.com/brunotm/streams/protoconv"
+)
+
+// protobufCodec encodes objects as a message registered in a protoconv.Registry
+type protobufCodec struct {
+	registry *protoconv.Registry
+	message  string
+}
+
+// NewProtobuf creates a Codec for the protobuf encoding of the given message
+// registered in the registry. Values are encoded from and decoded to objects.
+func NewProtobuf(registry *protoconv.Registry, message string) (codec Codec) {
+	return &protobufCodec{registry: registry, message: message}
+}
+
+// Encode the object as the protobuf message
+func (c *protobufCodec) Encode(value interface{}) (data []byte, err error) {
+	if value, err = normalize(value); err != nil {
+		return nil, err
+	}
+
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errInvalidValue
+	}
+	return c.registry.Marshal(c.message, object)
+}
+
+// Decode the protobuf message as an object
+func (c *protobufCodec) Decode(data []byte) (value interface{}, err error) {
+	object, err := c.registry.Unmarshal(c.message, data)
+	if err != nil {
+		return nil, err
+	}
+	return object, nil
+}
@@ -0,0 +1,201 @@
+package serde
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/brunotm/streams"
+)
+
+// DefaultCodec is the codec of nodes without a configured codec
+const DefaultCodec = "json"
+
+var (
+	// ErrCodecNotFound is returned when the named codec is not registered
+	ErrCodecNotFound = streams.Errorf(streams.CodeConfig, "codec not found")
+
+	errEmptyName    = streams.Errorf(streams.CodeConfig, "codec name cannot be empty")
+	errInvalidValue = streams.Errorf(streams.CodeSerialization, "invalid value for codec")
+)
+
+// Codec encodes and decodes record keys and values. Decoded values are json
+// compatible: nil, bool, int64, uint64, float32, float64, json.Number, string,
+// []byte, []interface{} and map[string]interface{}. Codecs encode those and
+// any value that can be encoded as json. Codecs must be safe for concurrent use.
+type Codec interface {
+	Encode(value interface{}) (data []byte, err error)
+	Decode(data []byte) (value interface{}, err error)
+}
+
+// Registry of named codecs, safe for concurrent use
+type Registry struct {
+	mtx    sync.RWMutex
+	codecs map[string]Codec
+}
+
+// Default registry, with the built-in json and msgpack codecs
+var Default = NewRegistry()
+
+// NewRegistry creates a registry with the built-in json and msgpack codecs.
+// Protobuf and Avro codecs depend on their schemas and must be registered.
+func NewRegistry() (r *Registry) {
+	r = &Registry{}
+	r.codecs = map[string]Codec{
+		"json":    JSON,
+		"msgpack": Msgpack,
+	}
+	return r
+}
+
+// Register the codec with the given name, replacing any codec with the same name
+func (r *Registry) Register(name string, codec Codec) (err error) {
+	if name == "" {
+		return errEmptyName
+	}
+
+	r.mtx.Lock()
+	r.codecs[name] = codec
+	r.mtx.Unlock()
+	return nil
+}
+
+// Get the codec with the given name
+func (r *Registry) Get(name string) (codec Codec, err error) {
+	r.mtx.RLock()
+	codec, ok := r.codecs[name]
+	r.mtx.RUnlock()
+
+	if !ok {
+		return nil, ErrCodecNotFound
+	}
+	return codec, nil
+}
+
+// Configured returns the codec named by the <stream>.<node>.codec config of the
+// processor context, or by the given config path within the node config.
+// Nodes without a configured codec use the DefaultCodec.
+func (r *Registry) Configured(pc streams.ProcessorContext, path ...string) (codec Codec, err error) {
+	if len(path) == 0 {
+		path = []string{"codec"}
+	}
+
+	name := pc.Config().Get(append([]string{pc.StreamName(), pc.NodeName()}, path...)...).String(DefaultCodec)
+	return r.Get(name)
+}
+
+// Register the codec with the given name in the Default registry
+func Register(name string, codec Codec) (err error) {
+	return Default.Register(name, codec)
+}
+
+// Get the codec with the given name from the Default registry
+func Get(name string) (codec Codec, err error) {
+	return Default.Get(name)
+}
+
+// Value is a decoded record key or value, encoded with its codec
+// when the record is written, implementing the streams.Encoder interface.
+type Value struct {
+	codec Codec
+	value interface{}
+}
+
+// NewValue creates a Value encoded with the given codec
+func NewValue(codec Codec, value interface{}) (v *Value) {
+	return &Value{codec: codec, value: value}
+}
+
+// Encode the value with its codec
+func (v *Value) Encode() (data []byte, err error) {
+	return v.codec.Encode(v.value)
+}
+
+// Value returns the decoded value
+func (v *Value) Value() (value interface{}) {
+	return v.value
+}
+
+// DecodeKey decodes the record key with the codec.
+// Keys created with NewValue are returned without decoding.
+func DecodeKey(codec Codec, record streams.Record) (key interface{}, err error) {
+	if v, ok := record.Key.(*Value); ok {
+		return v.value, nil
+	}
+
+	data, err := record.EncodeKey()
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return codec.Decode(data)
+}
+
+// DecodeValue decodes the record value with the codec.
+// Values created with NewValue are returned without decoding.
+func DecodeValue(codec Codec, record streams.Record) (value interface{}, err error) {
+	if v, ok := record.Value.(*Value); ok {
+		return v.value, nil
+	}
+
+	data, err := record.EncodeValue()
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return codec.Decode(data)
+}
+
+// normalize converts the value into a json compatible value, encoding values
+// of other types as json and decoding them with numbers as json.Number
+func normalize(value interface{}) (normalized interface{}, err error) {
+	switch v := value.(type) {
+	case nil, bool, string, []byte, json.Number,
+		int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v, nil
+
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for x := range v {
+			if items[x], err = normalize(v[x]); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if object[key], err = normalize(item); err != nil {
+				return nil, err
+			}
+		}
+		return object, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(&normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
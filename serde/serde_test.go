@@ -0,0 +1,202 @@
+package serde
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/brunotm/streams/protoconv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	codec, err := r.Get("msgpack")
+	assert.NoError(t, err)
+	assert.Equal(t, Msgpack, codec)
+
+	_, err = r.Get("avro")
+	assert.Equal(t, ErrCodecNotFound, err)
+	assert.Error(t, r.Register("", JSON))
+	assert.NoError(t, r.Register("avro", JSON))
+
+	config := streams.NewConfig(nil)
+	config.Set("msgpack", "stream.node.codec")
+	pc := &mock.Context{Data: mock.ContextData{Active: true, StreamName: "stream", NodeName: "node", Config: config}}
+
+	codec, err = r.Configured(pc)
+	assert.NoError(t, err)
+	assert.Equal(t, Msgpack, codec)
+
+	codec, err = r.Configured(pc, "codec", "to")
+	assert.NoError(t, err)
+	assert.Equal(t, JSON, codec)
+}
+
+func TestCodecs(t *testing.T) {
+	value := map[string]interface{}{
+		"id":     int64(-300),
+		"count":  uint64(1 << 40),
+		"ratio":  1.5,
+		"name":   "streams",
+		"active": true,
+		"none":   nil,
+		"tags":   []interface{}{"a", int64(1)},
+		"data":   map[string]interface{}{"nested": int64(70000)},
+	}
+
+	for _, codec := range []Codec{JSON, Msgpack} {
+		data, err := codec.Encode(value)
+		assert.NoError(t, err)
+
+		decoded, err := codec.Decode(data)
+		assert.NoError(t, err)
+
+		again, err := codec.Encode(decoded)
+		assert.NoError(t, err)
+		assert.Equal(t, data, again)
+	}
+
+	// structs are encoded as their json representation
+	type user struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+
+	data, err := Msgpack.Encode(user{Name: "a", Age: 30})
+	assert.NoError(t, err)
+	decoded, err := Msgpack.Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "a", "age": int64(30)}, decoded)
+
+	decoded, err = JSON.Decode([]byte(`{"n":12345678901234567890}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"n": json.Number("12345678901234567890")}, decoded)
+
+	_, err = Msgpack.Decode([]byte{0xc1})
+	assert.Error(t, err)
+}
+
+func TestProtobuf(t *testing.T) {
+	registry := protoconv.NewRegistry()
+	assert.NoError(t, registry.Register(protoconv.Descriptor{Name: "test.User", Fields: []protoconv.Field{
+		{Number: 1, Name: "id", Type: protoconv.Int64},
+		{Number: 2, Name: "name", Type: protoconv.String},
+	}}))
+
+	codec := NewProtobuf(registry, "test.User")
+	data, err := codec.Encode(map[string]interface{}{"id": 7, "name": "a"})
+	assert.NoError(t, err)
+
+	decoded, err := codec.Decode(data)
+	assert.NoError(t, err)
+
+	again, err := codec.Encode(decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, data, again)
+
+	_, err = codec.Encode("a")
+	assert.Error(t, err)
+}
+
+func TestTranscoder(t *testing.T) {
+	config := streams.NewConfig(nil)
+	config.Set("json", "stream.node.codec.from")
+	config.Set("msgpack", "stream.node.codec.to")
+	pc := &mock.Context{Data: mock.ContextData{Active: true, StreamName: "stream", NodeName: "node", Config: config}}
+
+	p := TranscoderSupplier(nil)()
+	assert.NoError(t, p.(streams.Initializer).Init(pc))
+
+	p.Process(pc, streams.NewRecord("test", streams.StringEncoder(`"k"`),
+		streams.StringEncoder(`{"a":1}`), time.Now(), nil))
+	p.Process(pc, streams.NewRecord("test", nil, streams.StringEncoder(`{"a":`), time.Now(), nil))
+	assert.Equal(t, 1, pc.Data.ErrorCount)
+	assert.Len(t, pc.Data.Forwarded, 1)
+
+	record := pc.Data.Forwarded[0]
+	value, err := record.EncodeValue()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x81, 0xa1, 'a', 0x01}, value)
+
+	// decoded values are not decoded again
+	decoded, err := DecodeValue(Msgpack, record)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": json.Number("1")}, decoded)
+
+	key, err := DecodeKey(JSON, record)
+	assert.NoError(t, err)
+	assert.Equal(t, "k", key)
+}
+
+func TestTopics(t *testing.T) {
+	config := streams.NewConfig(map[string]interface{}{
+		"serdes": map[string]interface{}{
+			"orders.*": map[string]interface{}{"value": "msgpack"},
+			"users":    map[string]interface{}{"key": "json", "value": "msgpack"},
+		},
+	})
+	config.Set("msgpack", "serdes.orders.eu.key")
+
+	topics, err := NewRegistry().Topics(config)
+	assert.NoError(t, err)
+	assert.Equal(t, Msgpack, topics.Key("orders.eu"))
+	assert.Equal(t, JSON, topics.Value("orders.eu"))
+	assert.Equal(t, JSON, topics.Key("orders.us"))
+	assert.Equal(t, Msgpack, topics.Value("orders.us"))
+	assert.Equal(t, Msgpack, topics.Value("users"))
+	assert.True(t, topics.IsSet("orders.us"))
+	assert.False(t, topics.IsSet("events"))
+	assert.Equal(t, JSON, topics.Value("events"))
+
+	data, err := Msgpack.Encode(map[string]interface{}{"id": 1})
+	assert.NoError(t, err)
+
+	record, err := topics.NewRecord("users", []byte(`"a"`), data, time.Now(), nil)
+	assert.NoError(t, err)
+
+	key, value, err := topics.Decode(record)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", key)
+	assert.Equal(t, map[string]interface{}{"id": int64(1)}, value)
+
+	_, err = topics.NewRecord("users", []byte(`"a"`), []byte{0xc1}, time.Now(), nil)
+	assert.Equal(t, streams.CodeSerialization, streams.CodeOf(err))
+
+	mapped, err := topics.Mapper(func(key, value interface{}) (interface{}, interface{}, error) {
+		return "b", value, nil
+	})(record)
+	assert.NoError(t, err)
+	encoded, err := mapped.EncodeValue()
+	assert.NoError(t, err)
+	assert.Equal(t, data, encoded)
+	assert.True(t, topics.Predicate(func(key, value interface{}) bool { return key == "b" })(mapped))
+
+	mapped.Topic = "events"
+	encoded, err = topics.Encode(mapped).EncodeValue()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1}`, string(encoded))
+
+	config.Set("avro", "serdes.users.value")
+	_, err = NewRegistry().Topics(config)
+	assert.True(t, errors.Is(err, ErrCodecNotFound))
+}
@@ -0,0 +1,234 @@
+package serde
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+// topicCodecs are the key and value codecs of a topic
+type topicCodecs struct {
+	key   Codec
+	value Codec
+}
+
+// Topics maps topics to the key and value codecs named by the
+// serdes.<topic>.key and serdes.<topic>.value configs, so that sources, sinks
+// and pipelines agree on the format of each topic. Topics are matched by name
+// or by glob patterns as in path.Match, the longest pattern first.
+// Unmapped topics and unset keys or values use the DefaultCodec.
+type Topics struct {
+	topics   map[string]topicCodecs
+	patterns []string
+	def      Codec
+}
+
+// Topics returns the topic codecs configured in the serdes config,
+// with codecs from the registry
+func (r *Registry) Topics(config streams.Config) (t *Topics, err error) {
+	t = &Topics{topics: make(map[string]topicCodecs)}
+	if t.def, err = r.Get(DefaultCodec); err != nil {
+		return nil, err
+	}
+
+	if err = t.load(r, "", config.Get("serdes").Map()); err != nil {
+		return nil, err
+	}
+
+	for topic := range t.topics {
+		if strings.ContainsAny(topic, "*?[") {
+			t.patterns = append(t.patterns, topic)
+		}
+	}
+
+	sort.Slice(t.patterns, func(i, j int) bool {
+		if len(t.patterns[i]) != len(t.patterns[j]) {
+			return len(t.patterns[i]) > len(t.patterns[j])
+		}
+		return t.patterns[i] < t.patterns[j]
+	})
+
+	return t, nil
+}
+
+// load the topic codecs from the serdes config. Topic names with dots set
+// as config paths are nested, and are joined back until a key or value is found.
+func (t *Topics) load(r *Registry, prefix string, entries map[string]streams.Config) (err error) {
+	for name, entry := range entries {
+		topic := name
+		if prefix != "" {
+			topic = prefix + "." + name
+		}
+
+		keyName := entry.Get("key").String("")
+		valueName := entry.Get("value").String("")
+		if keyName == "" && valueName == "" {
+			if err = t.load(r, topic, entry.Map()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err = path.Match(topic, ""); err != nil {
+			return streams.Errorf(streams.CodeConfig, "serdes topic pattern %s: %w", topic, err)
+		}
+
+		codecs := topicCodecs{key: t.def, value: t.def}
+		if keyName != "" {
+			if codecs.key, err = r.Get(keyName); err != nil {
+				return fmt.Errorf("serdes topic %s key codec %s: %w", topic, keyName, err)
+			}
+		}
+		if valueName != "" {
+			if codecs.value, err = r.Get(valueName); err != nil {
+				return fmt.Errorf("serdes topic %s value codec %s: %w", topic, valueName, err)
+			}
+		}
+		t.topics[topic] = codecs
+	}
+	return nil
+}
+
+// codecs returns the codecs of the topic
+func (t *Topics) codecs(topic string) (codecs topicCodecs, ok bool) {
+	if codecs, ok = t.topics[topic]; ok {
+		return codecs, true
+	}
+
+	for _, pattern := range t.patterns {
+		if matched, _ := path.Match(pattern, topic); matched {
+			return t.topics[pattern], true
+		}
+	}
+	return topicCodecs{key: t.def, value: t.def}, false
+}
+
+// Key returns the key codec of the topic
+func (t *Topics) Key(topic string) (codec Codec) {
+	codecs, _ := t.codecs(topic)
+	return codecs.key
+}
+
+// Value returns the value codec of the topic
+func (t *Topics) Value(topic string) (codec Codec) {
+	codecs, _ := t.codecs(topic)
+	return codecs.value
+}
+
+// IsSet returns if the topic is mapped in the serdes config
+func (t *Topics) IsSet(topic string) (ok bool) {
+	_, ok = t.codecs(topic)
+	return ok
+}
+
+// NewRecord creates a record for the topic from the key and value data read
+// by a source, decoded with the topic codecs so that malformed data is rejected
+// as it enters the stream. Nil key or value data is kept nil.
+func (t *Topics) NewRecord(topic string, key, value []byte, ts time.Time, ack func() error) (record streams.Record, err error) {
+	codecs, _ := t.codecs(topic)
+
+	var k, v streams.Encoder
+	if key != nil {
+		decoded, err := codecs.key.Decode(key)
+		if err != nil {
+			return record, streams.Errorf(streams.CodeSerialization, "topic %s key: %w", topic, err)
+		}
+		k = NewValue(codecs.key, decoded)
+	}
+
+	if value != nil {
+		decoded, err := codecs.value.Decode(value)
+		if err != nil {
+			return record, streams.Errorf(streams.CodeSerialization, "topic %s value: %w", topic, err)
+		}
+		v = NewValue(codecs.value, decoded)
+	}
+
+	return streams.NewRecord(topic, k, v, ts, ack), nil
+}
+
+// Decode the record key and value with the codecs of the record topic
+func (t *Topics) Decode(record streams.Record) (key, value interface{}, err error) {
+	codecs, _ := t.codecs(record.Topic)
+	if key, err = DecodeKey(codecs.key, record); err != nil {
+		return nil, nil, err
+	}
+	if value, err = DecodeValue(codecs.value, record); err != nil {
+		return nil, nil, err
+	}
+	return key, value, nil
+}
+
+// Encode returns a copy of the record with its key and value encoded with
+// the codecs of the record topic, as a sink writing the record to its topic
+// expects. Keys and values not decoded by a codec are left untouched.
+func (t *Topics) Encode(record streams.Record) (encoded streams.Record) {
+	codecs, _ := t.codecs(record.Topic)
+	if v, ok := record.Key.(*Value); ok {
+		record = record.WithKey(NewValue(codecs.key, v.value))
+	}
+	if v, ok := record.Value.(*Value); ok {
+		record.Value = NewValue(codecs.value, v.value)
+	}
+	return record
+}
+
+// Predicate adapts a function of the decoded record key and value to a
+// streams.Predicate, decoding with the codecs of the record topic.
+// Records that fail to decode do not match.
+func (t *Topics) Predicate(fn func(key, value interface{}) bool) streams.Predicate {
+	return func(record streams.Record) (ok bool) {
+		key, value, err := t.Decode(record)
+		return err == nil && fn(key, value)
+	}
+}
+
+// Mapper adapts a function of the decoded record key and value to a
+// streams.Mapper, decoding with the codecs of the record topic and encoding
+// the resulting key and value with them. Records are rekeyed by the new key.
+func (t *Topics) Mapper(fn func(key, value interface{}) (k, v interface{}, err error)) streams.Mapper {
+	return func(record streams.Record) (result streams.Record, err error) {
+		key, value, err := t.Decode(record)
+		if err != nil {
+			return result, err
+		}
+
+		if key, value, err = fn(key, value); err != nil {
+			return result, err
+		}
+
+		codecs, _ := t.codecs(record.Topic)
+		result = record
+		if key != nil {
+			result = result.WithKey(NewValue(codecs.key, key))
+		} else if record.Key != nil {
+			result = result.WithKey(nil)
+		}
+
+		result.Value = nil
+		if value != nil {
+			result.Value = NewValue(codecs.value, value)
+		}
+		return result, nil
+	}
+}
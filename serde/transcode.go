@@ -0,0 +1,103 @@
+package serde
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"github.com/brunotm/streams"
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Transcoder)(nil)
+
+// Transcoder decodes record keys and values with the codec named by the
+// <stream>.<node>.codec.from config and forwards them to be encoded with the
+// codec named by the <stream>.<node>.codec.to config. Without a from codec,
+// records are decoded with the serdes codecs of their topic. Without a to codec,
+// records are encoded with the codecs of the topic named by the
+// <stream>.<node>.codec.topic config, or with the DefaultCodec.
+type Transcoder struct {
+	registry *Registry
+	topics   *Topics
+	from     Codec
+	to       Codec
+	toKey    Codec
+}
+
+// TranscoderSupplier for Transcoder processors with codecs from the given registry,
+// or the Default registry if nil
+func TranscoderSupplier(registry *Registry) streams.ProcessorSupplier {
+	if registry == nil {
+		registry = Default
+	}
+
+	return func() (processor streams.Processor) {
+		return &Transcoder{registry: registry}
+	}
+}
+
+// Init the transcoder
+func (t *Transcoder) Init(pc streams.ProcessorContext) (err error) {
+	if t.topics, err = t.registry.Topics(pc.Config()); err != nil {
+		return err
+	}
+
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName(), "codec")
+	if config.Get("from").String("") != "" {
+		if t.from, err = t.registry.Configured(pc, "codec", "from"); err != nil {
+			return err
+		}
+	}
+
+	if topic := config.Get("topic").String(""); topic != "" && config.Get("to").String("") == "" {
+		t.toKey, t.to = t.topics.Key(topic), t.topics.Value(topic)
+		return nil
+	}
+
+	if t.to, err = t.registry.Configured(pc, "codec", "to"); err != nil {
+		return err
+	}
+	t.toKey = t.to
+	return nil
+}
+
+// Process transcodes the record key and value and forwards the record
+func (t *Transcoder) Process(pc streams.ProcessorContext, record streams.Record) {
+	fromKey, fromValue := t.from, t.from
+	if t.from == nil {
+		fromKey, fromValue = t.topics.Key(record.Topic), t.topics.Value(record.Topic)
+	}
+
+	if record.Key != nil {
+		key, err := DecodeKey(fromKey, record)
+		if err != nil {
+			pc.Error(err, record)
+			return
+		}
+		record.Key = NewValue(t.toKey, key)
+	}
+
+	if record.Value != nil {
+		value, err := DecodeValue(fromValue, record)
+		if err != nil {
+			pc.Error(err, record)
+			return
+		}
+		record.Value = NewValue(t.to, value)
+	}
+
+	pc.Forward(record)
+}
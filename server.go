@@ -0,0 +1,308 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StreamInfo describes a managed stream
+type StreamInfo struct {
+	Name      string    `json:"name"`
+	Standby   bool      `json:"standby"`
+	Error     string    `json:"error,omitempty"`
+	Watermark time.Time `json:"watermark"`
+}
+
+// TopologyInfo describes the nodes and stores of a stream topology
+type TopologyInfo struct {
+	Nodes  []NodeInfo `json:"nodes"`
+	Stores []string   `json:"stores"`
+}
+
+// NodeInfo describes a topology node, with the names of its neighbours
+// and the processors fused into it, its current tasks and if it is paused.
+type NodeInfo struct {
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	Predecessors []string `json:"predecessors,omitempty"`
+	Successors   []string `json:"successors,omitempty"`
+	Fused        []string `json:"fused,omitempty"`
+	Tasks        int      `json:"tasks"`
+	Paused       bool     `json:"paused"`
+}
+
+// Names returns the names of the managed streams in order
+func (m *Streams) Names() (names []string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for name := range m.streams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Info describes the stream
+func (s *Stream) Info() (info StreamInfo) {
+	info.Name = s.name
+	info.Standby = s.Standby()
+	info.Watermark = s.Watermark()
+	if err := s.Err(); err != nil {
+		info.Error = err.Error()
+	}
+	return info
+}
+
+// Topology describes the stream topology nodes in topology order and its stores in name order
+func (s *Stream) Topology() (info TopologyInfo) {
+	names := func(nodes []*Node) (names []string) {
+		for _, node := range nodes {
+			names = append(names, node.name)
+		}
+		return names
+	}
+
+	for _, node := range s.topology.nodes {
+		n := NodeInfo{Name: node.name, Type: node.typ.String(), Fused: node.fused}
+		n.Predecessors = names(node.predecessors)
+		n.Successors = names(node.successors)
+		n.Paused = node.pause.isPaused()
+		if node.tasks != nil {
+			n.Tasks = node.tasks.scale()
+		}
+		info.Nodes = append(info.Nodes, n)
+	}
+
+	info.Stores = names(s.topology.storeNodes())
+	sort.Strings(info.Stores)
+	return info
+}
+
+// DotGraph returns the stream topology in the graphviz dot format
+func (s *Stream) DotGraph() (graph string) {
+	return s.topology.dotGraph()
+}
+
+// StreamsHandler returns a http handler for the management of the streams
+// in the manager, mounted at /streams:
+//
+//	GET  /streams:                                 managed streams as json
+//	GET  /streams/<name>:                          stream state as json
+//	GET  /streams/<name>/topology:                 topology as json, or dot if format=dot
+//	GET  /streams/<name>/metrics:                  metrics as json, of the node given by the node parameter if set
+//	POST /streams/<name>/nodes/<node>/scale?tasks=<n>: scales the node tasks
+//	POST /streams/<name>/nodes/<node>/pause:       pauses the node
+//	POST /streams/<name>/nodes/<node>/resume:      resumes the node
+//	POST /streams/<name>/close:                    closes the stream
+//	POST /streams/<name>/promote:                  promotes the standby stream
+//	GET  /streams/<name>/tap:                      tapped records as server-sent events, as in TapHandler
+//	GET  /streams/<name>/inflight:                 records in flight as json, as in InFlightHandler
+//	POST /streams/<name>/replay:                   replays dead letters, as in ReplayHandler
+//	GET  /streams/<name>/splits?parent=<name>:     split weights of the parent, as in SplitHandler
+//	PUT  /streams/<name>/splits?parent=<name>:     sets the split weights of the parent
+//	GET  /streams/<name>/stores/<store>/<key>:     raw value of the key in the store
+//	GET  /streams/<name>/stores/<store>?from=<key>&to=<key>&limit=<n>: store range as json
+//	GET  /streams/<name>/stores/<store>?prefix=<prefix>&limit=<n>:      store prefix range as json
+//
+// Stores are queried through their replicas, if implementing the Replicator interface.
+// Streams are resolved on each request, serving the streams added to the manager
+// after the handler was created.
+func StreamsHandler(m *Streams) (handler http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/streams"), "/")
+		if path == "" {
+			if !allowMethod(w, r, http.MethodGet) {
+				return
+			}
+
+			infos := []StreamInfo{}
+			for _, name := range m.Names() {
+				if stream := m.Get(name); stream != nil {
+					infos = append(infos, stream.Info())
+				}
+			}
+			writeJSON(w, infos)
+			return
+		}
+
+		parts := strings.SplitN(path, "/", 4)
+		stream := m.Get(parts[0])
+		if stream == nil {
+			http.Error(w, ErrStreamNotFound.Error(), http.StatusNotFound)
+			return
+		}
+
+		switch {
+		case len(parts) == 1:
+			if allowMethod(w, r, http.MethodGet) {
+				writeJSON(w, stream.Info())
+			}
+
+		case len(parts) == 2 && parts[1] == "topology":
+			if !allowMethod(w, r, http.MethodGet) {
+				return
+			}
+
+			if r.URL.Query().Get("format") == "dot" {
+				w.Header().Set("Content-Type", "text/vnd.graphviz")
+				w.Write([]byte(stream.DotGraph()))
+				return
+			}
+			writeJSON(w, stream.Topology())
+
+		case len(parts) == 2 && parts[1] == "metrics":
+			if allowMethod(w, r, http.MethodGet) {
+				streamMetrics(w, stream, r.URL.Query().Get("node"))
+			}
+
+		case len(parts) == 2 && parts[1] == "close":
+			if !allowMethod(w, r, http.MethodPost) {
+				return
+			}
+
+			if err := stream.Close(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case len(parts) == 2 && parts[1] == "promote":
+			if !allowMethod(w, r, http.MethodPost) {
+				return
+			}
+
+			if err := stream.Promote(); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case len(parts) == 2 && streamHandlers[parts[1]] != nil:
+			streamHandlers[parts[1]](stream).ServeHTTP(w, r)
+
+		case len(parts) >= 3 && parts[1] == "stores":
+			if !allowMethod(w, r, http.MethodGet) {
+				return
+			}
+
+			var key *string
+			if len(parts) == 4 {
+				key = &parts[3]
+			}
+			queryStore(w, r, stream, parts[2], key)
+
+		case len(parts) == 4 && parts[1] == "nodes":
+			if allowMethod(w, r, http.MethodPost) {
+				nodeAction(w, r, stream, parts[2], parts[3])
+			}
+
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// streamHandlers are the stream handlers served by StreamsHandler under /streams/<name>/
+var streamHandlers = map[string]func(s *Stream) http.Handler{
+	"tap":      TapHandler,
+	"inflight": InFlightHandler,
+	"replay":   ReplayHandler,
+	"splits":   SplitHandler,
+}
+
+// nodeAction scales, pauses or resumes the stream node
+func nodeAction(w http.ResponseWriter, r *http.Request, s *Stream, node, action string) {
+	var err error
+	switch action {
+	case "scale":
+		tasks, e := strconv.Atoi(r.URL.Query().Get("tasks"))
+		if e != nil || tasks < 1 {
+			http.Error(w, "invalid tasks", http.StatusBadRequest)
+			return
+		}
+		err = s.Scale(node, tasks)
+	case "pause":
+		err = s.Pause(node)
+	case "resume":
+		err = s.Resume(node)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, ErrNodeNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusConflict)
+	}
+}
+
+// metricJSON is the json representation of a metric
+type metricJSON struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// streamMetrics replies with the stream metrics, or the metrics of the given node
+func streamMetrics(w http.ResponseWriter, s *Stream, node string) {
+	if node != "" && s.topology.getNode(node) == nil {
+		http.Error(w, ErrNodeNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	metrics := []metricJSON{}
+	for _, metric := range s.Metrics() {
+		if node != "" && metric.Labels["node"] != node {
+			continue
+		}
+
+		typ := "counter"
+		if metric.Type == Gauge {
+			typ = "gauge"
+		}
+		metrics = append(metrics, metricJSON{Name: metric.Name, Type: typ, Labels: metric.Labels, Value: metric.Value})
+	}
+	writeJSON(w, metrics)
+}
+
+// allowMethod replies with method not allowed if the request method is not the given method
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) (ok bool) {
+	if r.Method != method {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// writeJSON replies with the value encoded as json
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
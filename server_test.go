@@ -0,0 +1,192 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamsHandler(t *testing.T) {
+	var acked sync.WaitGroup
+	acked.Add(3)
+
+	source := &testSource{}
+	for x := 0; x < 3; x++ {
+		source.records = append(source.records, NewRecord("", StringEncoder("k"), StringEncoder("v"), time.Now(),
+			func() error { acked.Done(); return nil }))
+	}
+
+	config := NewConfig(nil)
+	config.Set(1, "stream.sink.tasks.count")
+
+	var processed int64
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source { return source }))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		atomic.AddInt64(&processed, 1)
+	}, "source"))
+
+	m := NewStreams(nil)
+	stream, err := m.Add(b)
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(StreamsHandler(m))
+	defer server.Close()
+
+	request := func(method, path string) (code int, body string) {
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		assert.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	code, body := request(http.MethodGet, "/streams")
+	assert.Equal(t, http.StatusOK, code)
+	var infos []StreamInfo
+	assert.NoError(t, json.Unmarshal([]byte(body), &infos))
+	assert.Len(t, infos, 1)
+	assert.Equal(t, "stream", infos[0].Name)
+
+	code, _ = request(http.MethodGet, "/streams/none/topology")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = request(http.MethodPost, "/streams/stream/nodes/none/pause")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = request(http.MethodGet, "/streams/stream/nodes/sink/pause")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+
+	// the paused sink holds the records until resumed
+	code, _ = request(http.MethodPost, "/streams/stream/nodes/sink/pause")
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = request(http.MethodPost, "/streams/stream/nodes/sink/pause")
+	assert.Equal(t, http.StatusConflict, code)
+	assert.True(t, stream.Paused("sink"))
+
+	assert.NoError(t, stream.Start())
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int64(0), atomic.LoadInt64(&processed))
+
+	code, body = request(http.MethodGet, "/streams/stream/topology")
+	assert.Equal(t, http.StatusOK, code)
+	var topology TopologyInfo
+	assert.NoError(t, json.Unmarshal([]byte(body), &topology))
+	assert.Len(t, topology.Nodes, 2)
+	assert.Equal(t, NodeInfo{Name: "source", Type: "source", Successors: []string{"sink"}, Tasks: 1}, topology.Nodes[0])
+	assert.Equal(t, NodeInfo{Name: "sink", Type: "sink", Predecessors: []string{"source"}, Tasks: 1, Paused: true},
+		topology.Nodes[1])
+
+	code, body = request(http.MethodGet, "/streams/stream/topology?format=dot")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, strings.Contains(body, `"source" -> "sink"`))
+
+	code, _ = request(http.MethodPost, "/streams/stream/nodes/sink/scale?tasks=2")
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, _ = request(http.MethodPost, "/streams/stream/nodes/sink/resume")
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = request(http.MethodPost, "/streams/stream/nodes/sink/resume")
+	assert.Equal(t, http.StatusConflict, code)
+	acked.Wait()
+	assert.Equal(t, int64(3), atomic.LoadInt64(&processed))
+
+	code, _ = request(http.MethodPost, "/streams/stream/nodes/sink/scale?tasks=none")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = request(http.MethodPost, "/streams/stream/nodes/sink/scale?tasks=2")
+	assert.Equal(t, http.StatusNoContent, code)
+	assert.Equal(t, 2, stream.Topology().Nodes[1].Tasks)
+
+	code, body = request(http.MethodGet, "/streams/stream/metrics?node=sink")
+	assert.Equal(t, http.StatusOK, code)
+	var metrics []struct {
+		Name   string
+		Type   string
+		Labels map[string]string
+		Value  float64
+	}
+	assert.NoError(t, json.Unmarshal([]byte(body), &metrics))
+	for _, metric := range metrics {
+		assert.Equal(t, "sink", metric.Labels["node"])
+		if metric.Name == "streams_node_records_processed_total" {
+			assert.Equal(t, "counter", metric.Type)
+			assert.Equal(t, float64(3), metric.Value)
+		}
+	}
+
+	// stream handlers are served for streams added after the handler
+	late := NewBuilder("late", NewConfig(nil))
+	assert.NoError(t, late.AddSource("source", func() Source { return &testSource{} }))
+	assert.NoError(t, late.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {}, "source"))
+	_, err = m.Add(late)
+	assert.NoError(t, err)
+
+	code, body = request(http.MethodGet, "/streams/late/inflight")
+	assert.Equal(t, http.StatusOK, code)
+	var inflight InFlight
+	assert.NoError(t, json.Unmarshal([]byte(body), &inflight))
+	code, _ = request(http.MethodGet, "/streams/late/inflight?node=none")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = request(http.MethodGet, "/streams/late/tap?node=none")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = request(http.MethodGet, "/streams/late/splits?parent=none")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = request(http.MethodGet, "/streams/late/replay")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+	code, _ = request(http.MethodPost, "/streams/late/promote")
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = request(http.MethodGet, "/streams/none/inflight")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = request(http.MethodPost, "/streams/stream/close")
+	assert.Equal(t, http.StatusNoContent, code)
+}
+
+func TestPausedSourceClose(t *testing.T) {
+	source := &testSource{records: []Record{
+		NewRecord("", StringEncoder("k"), StringEncoder("v"), time.Now(), nil)}}
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() Source { return source }))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.Equal(t, ErrNodeNotFound, stream.Pause("none"))
+	assert.NoError(t, stream.Pause("source"))
+	assert.NoError(t, stream.Start())
+
+	// closing releases the blocked source
+	done := make(chan error)
+	go func() { done <- stream.Close() }()
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("paused source blocked close")
+	}
+}
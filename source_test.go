@@ -0,0 +1,134 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"strconv"
+	"time"
+)
+
+// testSource is the source of the stream tests. On Consume it waits for the
+// gate to be closed, if set, and forwards its records, or the records created
+// by next until it returns false or a forward fails. Records are forwarded
+// only to the to node if set, or broadcast if broadcast is set. Done is
+// closed, if set, once the source finishes.
+type testSource struct {
+	records   []Record
+	next      func(x int) (record Record, ok bool)
+	to        string
+	broadcast bool
+	gate      chan struct{}
+	done      chan struct{}
+}
+
+func (s *testSource) Process(pc ProcessorContext, record Record) {}
+
+func (s *testSource) Consume(pc ProcessorContext) {
+	if s.done != nil {
+		defer close(s.done)
+	}
+
+	if s.gate != nil {
+		<-s.gate
+	}
+
+	forward := pc.Forward
+	switch {
+	case s.to != "":
+		forward = func(record Record) error { return pc.ForwardTo(s.to, record) }
+	case s.broadcast:
+		forward = pc.Broadcast
+	}
+
+	for _, record := range s.records {
+		forward(record)
+	}
+
+	for x := 0; s.next != nil; x++ {
+		record, ok := s.next(x)
+		if !ok || forward(record) != nil {
+			return
+		}
+	}
+}
+
+// testSourceSupplier supplies sources forwarding count testRecords
+func testSourceSupplier(count int) SourceSupplier {
+	return func() Source {
+		return &testSource{next: testRecords(count)}
+	}
+}
+
+// nextRecords returns a testSource next function creating count records
+// with the given function as they are forwarded
+func nextRecords(count int, record func(x int) Record) func(x int) (Record, bool) {
+	return func(x int) (Record, bool) {
+		if x >= count {
+			return Record{}, false
+		}
+		return record(x), true
+	}
+}
+
+// testRecords creates count records of the test topic valued by their index
+func testRecords(count int) func(x int) (Record, bool) {
+	return nextRecords(count, func(x int) Record {
+		return NewRecord("test", nil, StringEncoder(strconv.Itoa(x)), time.Now(), nil)
+	})
+}
+
+// keyedRecords creates records of the test topic with the given keys,
+// valued by their index
+func keyedRecords(keys ...string) func(x int) (Record, bool) {
+	return nextRecords(len(keys), func(x int) Record {
+		return NewRecord("test", StringEncoder(keys[x]), StringEncoder(strconv.Itoa(x)), time.Now(), nil)
+	})
+}
+
+// cycledRecords creates count records of the test topic valued by their
+// index, with keys cycling over the given number of keys
+func cycledRecords(count, keys int) func(x int) (Record, bool) {
+	return nextRecords(count, func(x int) Record {
+		return NewRecord("test", StringEncoder(strconv.Itoa(x%keys)), StringEncoder(strconv.Itoa(x)), time.Now(), nil)
+	})
+}
+
+// topicRecords creates one record per topic, valued by its topic
+func topicRecords(topics ...string) func(x int) (Record, bool) {
+	return nextRecords(len(topics), func(x int) Record {
+		return NewRecord(topics[x], nil, StringEncoder(topics[x]), time.Now(), nil)
+	})
+}
+
+// redeliveredRecords creates records of the test topic with the given keys
+// and the same value, as redelivered by sources
+func redeliveredRecords(keys ...string) func(x int) (Record, bool) {
+	return nextRecords(len(keys), func(x int) Record {
+		return NewRecord("test", StringEncoder(keys[x]), StringEncoder("value"), time.Now(), nil)
+	})
+}
+
+// ackedRecords creates count testRecords sending their values to acks once acknowledged
+func ackedRecords(count int, acks chan<- string) func(x int) (Record, bool) {
+	return nextRecords(count, func(x int) Record {
+		value := strconv.Itoa(x)
+		return NewRecord("test", nil, StringEncoder(value), time.Now(), func() error {
+			acks <- value
+			return nil
+		})
+	})
+}
@@ -0,0 +1,214 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// DefaultSegmentSize is the default maximum size in bytes
+	// of an overflow segment file.
+	DefaultSegmentSize = 64 << 20
+)
+
+var (
+	errSpillCorrupt = Errorf(CodeSerialization, "corrupt overflow entry")
+)
+
+// overflow is a disk backed FIFO queue of records used to absorb task buffer
+// overflows. Records are appended to segment files within dir and indexed by
+// their offsets. Segments are removed once all of its records are read.
+// Record acks cannot be persisted and are kept in memory.
+type overflow struct {
+	mtx         sync.Mutex
+	dir         string
+	segmentSize int64
+	sequence    uint64
+	segments    []*segment
+	acks        map[uint64]*acker
+	written     uint64
+	read        uint64
+	notify      chan struct{}
+}
+
+// segment is a file of encoded records and its index of record offsets
+type segment struct {
+	path  string
+	file  *os.File
+	index []int64
+	size  int64
+	next  int
+}
+
+func newOverflow(dir string, segmentSize int64) (o *overflow, err error) {
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+
+	o = &overflow{}
+	o.dir = dir
+	o.segmentSize = segmentSize
+	o.acks = make(map[uint64]*acker)
+	o.notify = make(chan struct{}, 1)
+	return o, nil
+}
+
+// len returns the number of records in the overflow
+func (o *overflow) len() (n int) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	return int(o.written - o.read)
+}
+
+// push appends the record to the overflow
+func (o *overflow) push(record Record) (err error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	buf, err := MarshalRecord(record)
+	if err != nil {
+		return err
+	}
+
+	var seg *segment
+	if len(o.segments) > 0 {
+		seg = o.segments[len(o.segments)-1]
+	}
+
+	if seg == nil || seg.size >= o.segmentSize {
+		if seg, err = o.newSegment(); err != nil {
+			return err
+		}
+	}
+
+	if _, err = seg.file.Write(buf); err != nil {
+		return err
+	}
+
+	seg.index = append(seg.index, seg.size)
+	seg.size += int64(len(buf))
+
+	if record.ack != nil {
+		o.acks[o.written] = record.ack
+	}
+	o.written++
+
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// pop removes and returns the oldest record in the overflow.
+// It returns false if the overflow is empty. Entries that cannot be read are
+// skipped with their records rejected, returning a errSpillCorrupt error.
+func (o *overflow) pop() (record Record, ok bool, err error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	if o.written == o.read {
+		return record, false, nil
+	}
+
+	seg := o.segments[0]
+	start := seg.index[seg.next]
+	end := seg.size
+	if seg.next < len(seg.index)-1 {
+		end = seg.index[seg.next+1]
+	}
+
+	buf := make([]byte, end-start)
+	if _, err = seg.file.ReadAt(buf, start); err == nil {
+		record, err = UnmarshalRecord(buf, nil)
+	}
+
+	ack := o.acks[o.read]
+	delete(o.acks, o.read)
+	o.read++
+	seg.next++
+
+	if err != nil {
+		err = Errorf(CodeSerialization, "%w: %s at offset %d: %v", errSpillCorrupt, seg.path, start, err)
+		if ack != nil {
+			ack.reject(err)
+		}
+	} else {
+		record.ack = ack
+	}
+
+	// Remove fully read segments, still returning the record
+	// with its ack if their removal fails
+	if seg.next == len(seg.index) {
+		o.segments = o.segments[1:]
+		if e := seg.remove(); e != nil && err == nil {
+			return record, true, e
+		}
+	}
+
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	return record, true, nil
+}
+
+// close the overflow removing all of its segments
+func (o *overflow) close() (err error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	for _, seg := range o.segments {
+		if e := seg.remove(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	o.segments = nil
+	o.acks = make(map[uint64]*acker)
+	o.read = o.written
+	return err
+}
+
+func (o *overflow) newSegment() (seg *segment, err error) {
+	seg = &segment{}
+	seg.path = filepath.Join(o.dir, fmt.Sprintf("%020d.seg", o.sequence))
+	seg.file, err = os.OpenFile(seg.path, os.O_CREATE|os.O_TRUNC|os.O_RDWR|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	o.sequence++
+	o.segments = append(o.segments, seg)
+	return seg, nil
+}
+
+func (s *segment) remove() (err error) {
+	if err = s.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(s.path)
+}
@@ -0,0 +1,153 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overflow")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	of, err := newOverflow(dir, 64)
+	assert.NoError(t, err)
+
+	acked := 0
+	ack := func() error {
+		acked++
+		return nil
+	}
+
+	ts := time.Now()
+	for x := 0; x < 20; x++ {
+		record := NewRecord("topic", StringEncoder(strconv.Itoa(x)), nil, ts, ack)
+		assert.NoError(t, of.push(record))
+	}
+
+	assert.Equal(t, 20, of.len())
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.True(t, len(segments) > 1, "records spread over segments")
+
+	for x := 0; x < 20; x++ {
+		record, ok, err := of.pop()
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "topic", record.Topic)
+		assert.Nil(t, record.Value)
+		assert.True(t, ts.Equal(record.Time))
+
+		key, _ := record.EncodeKey()
+		assert.Equal(t, strconv.Itoa(x), string(key))
+		assert.NoError(t, record.Ack())
+	}
+
+	_, ok, err := of.pop()
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 20, acked)
+
+	segments, _ = filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.Len(t, segments, 0)
+	assert.NoError(t, of.close())
+}
+
+func TestOverflowCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overflow")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	of, err := newOverflow(dir, DefaultSegmentSize)
+	assert.NoError(t, err)
+
+	var nacked error
+	nack := func(err error) error {
+		nacked = err
+		return nil
+	}
+
+	ts := time.Now()
+	for x := 0; x < 3; x++ {
+		record := NewRecordNack("topic", StringEncoder(strconv.Itoa(x)), nil, ts, func() error { return nil }, nack)
+		assert.NoError(t, of.push(record))
+	}
+
+	// corrupt the wire format version of the second entry
+	seg := of.segments[0]
+	file, err := os.OpenFile(seg.path, os.O_WRONLY, 0640)
+	assert.NoError(t, err)
+	_, err = file.WriteAt([]byte{0xff}, seg.index[1])
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	record, ok, err := of.pop()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	key, _ := record.EncodeKey()
+	assert.Equal(t, "0", string(key))
+
+	// the corrupt entry is skipped and its record rejected
+	_, ok, err = of.pop()
+	assert.False(t, ok)
+	assert.True(t, errors.Is(err, errSpillCorrupt))
+	assert.True(t, errors.Is(nacked, errSpillCorrupt))
+
+	record, ok, err = of.pop()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	key, _ = record.EncodeKey()
+	assert.Equal(t, "2", string(key))
+
+	_, ok, err = of.pop()
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 0, of.len())
+	assert.NoError(t, of.close())
+}
+
+func TestOverflowRemoveError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overflow")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	of, err := newOverflow(dir, DefaultSegmentSize)
+	assert.NoError(t, err)
+
+	acked := 0
+	assert.NoError(t, of.push(NewRecord("topic", nil, nil, time.Now(), func() error {
+		acked++
+		return nil
+	})))
+
+	// records are returned with their acks when their segment removal fails
+	assert.NoError(t, os.Remove(of.segments[0].path))
+	record, ok, err := of.pop()
+	assert.Error(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, record.Ack())
+	assert.Equal(t, 1, acked)
+	assert.NoError(t, of.close())
+}
@@ -0,0 +1,269 @@
+package split
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/jsonpath"
+)
+
+// Payload formats
+const (
+	// JSON arrays of elements
+	JSON = "json"
+	// NDJSON newline delimited elements
+	NDJSON = "ndjson"
+)
+
+// DefaultSize is the default number of records collected in a batch
+const DefaultSize = 100
+
+var (
+	errInvalidFormat = streams.Errorf(streams.CodeConfig, "invalid format")
+	errKeyNotScalar  = streams.Errorf(streams.CodeRecord, "key is not a scalar value")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Split)(nil)
+var _ streams.Initializer = (*Collect)(nil)
+
+// Split forwards one record per element of record values holding a json array
+// or newline delimited json elements. Element records keep the record topic,
+// time and acknowledgment, and are keyed by the scalar value selected by the
+// JSONPath in the <stream>.<node>.key config, keeping the record key if unset
+// or not found. The <stream>.<node>.format config sets the payload format as
+// json or ndjson, detecting json arrays by default.
+type Split struct {
+	format string
+	key    *jsonpath.Path
+}
+
+// SplitSupplier for Split processors
+func SplitSupplier() (processor streams.Processor) {
+	return &Split{}
+}
+
+// Init the processor
+func (s *Split) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+
+	switch s.format = config.Get("format").String(""); s.format {
+	case "", JSON, NDJSON:
+	default:
+		return errInvalidFormat
+	}
+
+	if key := config.Get("key").String(""); key != "" {
+		s.key, err = jsonpath.Compile(key)
+	}
+	return err
+}
+
+// Process splits the record and forwards its elements
+func (s *Split) Process(pc streams.ProcessorContext, record streams.Record) {
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	elements, err := s.split(value)
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	for _, element := range elements {
+		child := record
+		child.Value = streams.ByteEncoder(element)
+
+		if s.key != nil {
+			if child, err = s.rekey(child, element); err != nil {
+				pc.Error(err, record)
+				continue
+			}
+		}
+
+		pc.Forward(child)
+	}
+}
+
+// split the value in its elements
+func (s *Split) split(value []byte) (elements [][]byte, err error) {
+	format := s.format
+	if format == "" {
+		format = NDJSON
+		if trimmed := bytes.TrimSpace(value); len(trimmed) > 0 && trimmed[0] == '[' {
+			format = JSON
+		}
+	}
+
+	if format == NDJSON {
+		for _, line := range bytes.Split(value, []byte("\n")) {
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				elements = append(elements, line)
+			}
+		}
+		return elements, nil
+	}
+
+	var array []json.RawMessage
+	if err = json.Unmarshal(value, &array); err != nil {
+		return nil, err
+	}
+
+	elements = make([][]byte, len(array))
+	for x := range array {
+		elements[x] = array[x]
+	}
+	return elements, nil
+}
+
+// rekey the element record with the key selected from the element
+func (s *Split) rekey(record streams.Record, element []byte) (rekeyed streams.Record, err error) {
+	value, ok, err := s.key.Lookup(element)
+	if err != nil || !ok {
+		return record, err
+	}
+
+	key, ok := jsonpath.Scalar(value)
+	if !ok {
+		return record, errKeyNotScalar
+	}
+	return record.WithKey(streams.StringEncoder(key)), nil
+}
+
+// Collect batches records into a single record holding a json array or newline
+// delimited json elements of their values, the reverse of Split.
+// Batches are forwarded once they reach the <stream>.<node>.size number of
+// records, or when a record arrives after the <stream>.<node>.interval from the
+// first batched record time, if set. With the <stream>.<node>.bykey config
+// records are batched by key. The <stream>.<node>.format config sets the batch
+// format as json (default) or ndjson.
+// Batches keep the topic and key of their last record and are acknowledged once
+// processed, acknowledging their records. Records still batched when the stream
+// is closed are not acknowledged.
+type Collect struct {
+	format   string
+	size     int
+	interval time.Duration
+	bykey    bool
+	batches  map[string][]streams.Record
+}
+
+// CollectSupplier for Collect processors
+func CollectSupplier() (processor streams.Processor) {
+	return &Collect{}
+}
+
+// Init the processor
+func (c *Collect) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	c.size = config.Get("size").Int(DefaultSize)
+	c.interval = config.Get("interval").Duration(0)
+	c.bykey = config.Get("bykey").Bool(false)
+	c.batches = make(map[string][]streams.Record)
+
+	switch c.format = config.Get("format").String(JSON); c.format {
+	case JSON, NDJSON:
+	default:
+		return errInvalidFormat
+	}
+
+	if c.size <= 0 {
+		c.size = DefaultSize
+	}
+	return nil
+}
+
+// Process batches the record and forwards the complete batches
+func (c *Collect) Process(pc streams.ProcessorContext, record streams.Record) {
+	var batch string
+	if c.bykey {
+		key, err := record.EncodeKey()
+		if err != nil {
+			pc.Error(err, record)
+			return
+		}
+		batch = string(key)
+	}
+
+	// Forward the expired batch before adding the record
+	if records := c.batches[batch]; c.interval > 0 && len(records) > 0 &&
+		record.Time.Sub(records[0].Time) >= c.interval {
+		c.forward(pc, batch)
+	}
+
+	record.Retain()
+	c.batches[batch] = append(c.batches[batch], record)
+
+	if len(c.batches[batch]) >= c.size {
+		c.forward(pc, batch)
+	}
+}
+
+// forward the batch as a single record
+func (c *Collect) forward(pc streams.ProcessorContext, batch string) {
+	records := c.batches[batch]
+	delete(c.batches, batch)
+
+	var buf bytes.Buffer
+	if c.format == JSON {
+		buf.WriteByte('[')
+	}
+
+	var written int
+	for _, record := range records {
+		value, err := record.EncodeValue()
+		if err != nil {
+			pc.Error(err, record)
+			continue
+		}
+
+		if written > 0 {
+			if c.format == JSON {
+				buf.WriteByte(',')
+			} else {
+				buf.WriteByte('\n')
+			}
+		}
+		buf.Write(value)
+		written++
+	}
+
+	if c.format == JSON {
+		buf.WriteByte(']')
+	}
+
+	last := records[len(records)-1]
+	collected := streams.NewRecord(last.Topic, last.Key, streams.ByteEncoder(buf.Bytes()), last.Time,
+		func() (err error) {
+			for _, record := range records {
+				if e := record.Ack(); e != nil && err == nil {
+					err = e
+				}
+			}
+			return err
+		})
+
+	pc.Forward(collected)
+	collected.Ack()
+}
@@ -0,0 +1,107 @@
+package split
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func context(config streams.Config) (pc *mock.Context) {
+	return &mock.Context{Data: mock.ContextData{
+		Active:     true,
+		StreamName: "stream",
+		NodeName:   "node",
+		Config:     config,
+	}}
+}
+
+func TestSplit(t *testing.T) {
+	config := streams.NewConfig(nil)
+	config.Set("$.id", "stream.node.key")
+	pc := context(config)
+
+	s := SplitSupplier()
+	assert.NoError(t, s.(streams.Initializer).Init(pc))
+
+	var acked int
+	record := streams.NewRecord("test", streams.StringEncoder("batch"),
+		streams.StringEncoder(`[{"id":1},{"id":"b"},{"x":0}]`), time.Now(), func() error {
+			acked++
+			return nil
+		})
+	s.Process(pc, record)
+
+	s.Process(pc, streams.NewRecord("test", nil,
+		streams.StringEncoder("{\"id\":3}\n\n{\"id\":4}\n"), time.Now(), nil))
+
+	var keys, values []string
+	for _, r := range pc.Data.Forwarded {
+		key, _ := r.EncodeKey()
+		value, _ := r.EncodeValue()
+		keys = append(keys, string(key))
+		values = append(values, string(value))
+		assert.Equal(t, "test", r.Topic)
+	}
+	assert.Equal(t, []string{"1", "b", "batch", "3", "4"}, keys)
+	assert.Equal(t, []string{`{"id":1}`, `{"id":"b"}`, `{"x":0}`, `{"id":3}`, `{"id":4}`}, values)
+
+	// elements share the record acknowledgment
+	assert.NoError(t, record.Ack())
+	assert.Equal(t, 1, acked)
+}
+
+func TestCollect(t *testing.T) {
+	config := streams.NewConfig(nil)
+	config.Set(2, "stream.node.size")
+	config.Set("1s", "stream.node.interval")
+	pc := context(config)
+
+	c := CollectSupplier()
+	assert.NoError(t, c.(streams.Initializer).Init(pc))
+
+	var acked int
+	now := time.Now()
+	for x, value := range []string{`1`, `{"a":2}`, `3`, `4`} {
+		ts := now
+		if x == 3 {
+			ts = now.Add(time.Second)
+		}
+
+		record := streams.NewRecord("test", nil, streams.StringEncoder(value), ts, func() error {
+			acked++
+			return nil
+		})
+		c.Process(pc, record)
+		record.Ack()
+	}
+
+	assert.Equal(t, 2, pc.Data.ForwardCount)
+	first, _ := pc.Data.Forwarded[0].EncodeValue()
+	assert.Equal(t, `[1,{"a":2}]`, string(first))
+
+	// the third record batch expired
+	second, _ := pc.Data.Forwarded[1].EncodeValue()
+	assert.Equal(t, `[3]`, string(second))
+
+	// batched records are acknowledged once their batch is processed
+	assert.Equal(t, 3, acked)
+}
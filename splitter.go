@@ -0,0 +1,267 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/brunotm/streams/types"
+)
+
+var (
+	errSplitChild   = Errorf(CodeTopology, "split child is not a successor of the parent")
+	errSplitWeight  = Errorf(CodeTopology, "split weights must not be negative")
+	errSplitRouted  = Errorf(CodeTopology, "split child is routed by the parent")
+	errSplitMissing = Errorf(CodeTopology, "no splitter for the parent")
+)
+
+// Split is a branch of the records forwarded by a parent to one of its children
+type Split struct {
+	// To is the child receiving the records of the branch
+	To string
+	// Weight of the branch among the records not matching any condition,
+	// relative to the weights of the other branches
+	Weight float64
+	// Condition, if set, forwards the matching records to the child
+	// regardless of the branch weights
+	Condition func(record Record) bool
+}
+
+// splitterName returns the name of the splitting node for the given parent
+func splitterName(parent string) (name string) {
+	return parent + "-splitter"
+}
+
+// validateSplit validates the split children of the given parent
+func (t *topology) validateSplit(parent string, splits []Split, routes map[string]string) (err error) {
+	node := t.getNode(parent)
+	if node == nil {
+		return ErrNodeNotFound
+	}
+
+	if t.getNode(splitterName(parent)) != nil {
+		return errInvalidTopology
+	}
+
+	routed := make(map[string]bool)
+	for _, child := range routes {
+		routed[child] = true
+	}
+
+	for _, split := range splits {
+		var found bool
+		for _, successor := range node.successors {
+			found = found || successor.name == split.To
+		}
+
+		switch {
+		case !found:
+			return Errorf(CodeTopology, "%s: %w", split.To, errSplitChild)
+		case routed[split.To]:
+			return Errorf(CodeTopology, "%s: %w", split.To, errSplitRouted)
+		case split.Weight < 0:
+			return Errorf(CodeTopology, "%s: %w", split.To, errSplitWeight)
+		}
+	}
+
+	return nil
+}
+
+// split inserts the splitting node between the parent and the split children
+func (t *topology) split(parent string, s *splitter) {
+	node := &Node{}
+	node.name = splitterName(parent)
+	node.typ = types.Processor
+	node.supplier = ProcessorSupplier(func() Processor {
+		return s
+	})
+
+	children := make(map[string]bool)
+	for _, split := range s.splits {
+		children[split.To] = true
+	}
+	t.insert(parent, node, children)
+}
+
+// splitter forwards records to the child of the first matching split condition,
+// or to a child chosen by the split weights. The weights are shared by all
+// tasks and can be changed while the stream is running.
+type splitter struct {
+	mtx     sync.Mutex
+	splits  []Split
+	weights atomic.Value // []float64
+}
+
+func newSplitter(splits []Split) (s *splitter) {
+	s = &splitter{}
+	s.splits = splits
+
+	weights := make([]float64, len(splits))
+	for x := range splits {
+		weights[x] = splits[x].Weight
+	}
+	s.weights.Store(weights)
+	return s
+}
+
+// Process forwards the record to the split child. Records with a key are
+// always forwarded to the same child while the weights are unchanged.
+// Records are dropped if no condition matches and all weights are zero.
+func (s *splitter) Process(pc ProcessorContext, record Record) {
+	for x := range s.splits {
+		if s.splits[x].Condition != nil && s.splits[x].Condition(record) {
+			if err := pc.ForwardTo(s.splits[x].To, record); err != nil {
+				pc.Error(err, record)
+			}
+			return
+		}
+	}
+
+	weights := s.weights.Load().([]float64)
+
+	var total float64
+	for _, weight := range weights {
+		total += weight
+	}
+
+	if total == 0 {
+		return
+	}
+
+	point := rand.Float64()
+	if key, err := record.EncodeKey(); err == nil && key != nil {
+		h := fnv.New64a()
+		h.Write(key)
+		point = float64(h.Sum64()>>11) / (1 << 53)
+	}
+
+	point *= total
+	for x, weight := range weights {
+		if point < weight || x == len(weights)-1 {
+			if err := pc.ForwardTo(s.splits[x].To, record); err != nil {
+				pc.Error(err, record)
+			}
+			return
+		}
+		point -= weight
+	}
+}
+
+// get the current weights by child
+func (s *splitter) get() (weights map[string]float64) {
+	current := s.weights.Load().([]float64)
+	weights = make(map[string]float64, len(current))
+	for x := range current {
+		weights[s.splits[x].To] = current[x]
+	}
+	return weights
+}
+
+// set the weights of the given children, keeping the weights of the others
+func (s *splitter) set(weights map[string]float64) (err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	current := s.weights.Load().([]float64)
+	updated := append([]float64(nil), current...)
+
+	for child, weight := range weights {
+		found := false
+		for x := range s.splits {
+			if s.splits[x].To == child {
+				updated[x] = weight
+				found = true
+			}
+		}
+
+		switch {
+		case !found:
+			return Errorf(CodeTopology, "%s: %w", child, errSplitChild)
+		case weight < 0:
+			return Errorf(CodeTopology, "%s: %w", child, errSplitWeight)
+		}
+	}
+
+	s.weights.Store(updated)
+	return nil
+}
+
+// SplitWeights returns the current weights of the split children of the parent
+func (s *Stream) SplitWeights(parent string) (weights map[string]float64, err error) {
+	sp, ok := s.splitters[parent]
+	if !ok {
+		return nil, errSplitMissing
+	}
+	return sp.get(), nil
+}
+
+// SetSplitWeights changes the weights of the given split children of the
+// parent while the stream is running, for the progressive rollout of new
+// branches. The weights of children not given are unchanged.
+func (s *Stream) SetSplitWeights(parent string, weights map[string]float64) (err error) {
+	sp, ok := s.splitters[parent]
+	if !ok {
+		return errSplitMissing
+	}
+	return sp.set(weights)
+}
+
+// SplitHandler returns a http.Handler for the split weights of the parent
+// named in the parent query parameter. GET requests reply with the weights by
+// child as a json object, and PUT requests set the weights of the children in
+// the json object body with Stream.SetSplitWeights, replying with the weights.
+func SplitHandler(s *Stream) (handler http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent := r.URL.Query().Get("parent")
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var weights map[string]float64
+			if err := json.NewDecoder(r.Body).Decode(&weights); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if err := s.SetSplitWeights(parent, weights); err != nil {
+				status := http.StatusBadRequest
+				if err == errSplitMissing {
+					status = http.StatusNotFound
+				}
+				http.Error(w, err.Error(), status)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		weights, err := s.SplitWeights(parent)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(weights)
+	})
+}
@@ -0,0 +1,92 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuilderSplit(t *testing.T) {
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	received := make(map[string][]string)
+	// 5 split records plus 5 records to the not split successor
+	wg.Add(10)
+
+	sink := func(name string) ProcessorFunc {
+		return func(pc ProcessorContext, record Record) {
+			mtx.Lock()
+			received[name] = append(received[name], record.Topic)
+			mtx.Unlock()
+			wg.Done()
+		}
+	}
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: topicRecords("a", "canary", "b", "c", "d")}
+	}))
+	assert.NoError(t, b.AddSinkFunc("stable", sink("stable"), "source"))
+	assert.NoError(t, b.AddSinkFunc("canary", sink("canary"), "source"))
+	assert.NoError(t, b.AddSinkFunc("all", sink("all"), "source"))
+
+	assert.Error(t, b.Split("source"))
+	assert.Error(t, b.Split("source", Split{To: "stable"}, Split{To: "stable"}))
+	assert.NoError(t, b.Split("source", Split{To: "missing", Weight: 1}))
+	_, err := b.Build()
+	assert.Error(t, err, "not a successor")
+
+	b.splits = make(map[string][]Split)
+	assert.NoError(t, b.Split("source",
+		Split{To: "canary", Condition: func(record Record) bool { return record.Topic == "canary" }},
+		Split{To: "stable", Weight: 1}))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NotNil(t, stream.topology.getNode("source-splitter"))
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+	assert.NoError(t, stream.Close())
+
+	assert.Equal(t, []string{"canary"}, received["canary"])
+	assert.Equal(t, []string{"a", "b", "c", "d"}, received["stable"])
+	assert.Len(t, received["all"], 5)
+
+	handler := SplitHandler(stream)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/?parent=source",
+		strings.NewReader(`{"canary":5,"stable":95}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"canary":5,"stable":95}`, w.Body.String())
+
+	weights, err := stream.SplitWeights("source")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"canary": 5, "stable": 95}, weights)
+
+	assert.Error(t, stream.SetSplitWeights("source", map[string]float64{"all": 1}))
+	assert.Error(t, stream.SetSplitWeights("source", map[string]float64{"canary": -1}))
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?parent=stable", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
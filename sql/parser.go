@@ -0,0 +1,391 @@
+package sql
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/jsonpath"
+)
+
+// Token kinds
+const (
+	tokenEOF = iota
+	tokenIdent
+	tokenNumber
+	tokenDuration
+	tokenString
+	tokenSymbol
+)
+
+var errUnterminatedString = streams.Errorf(streams.CodeConfig, "unterminated string")
+
+// aggregates are the supported aggregate functions
+var aggregates = map[string]bool{"COUNT": true, "SUM": true, "MIN": true, "MAX": true, "AVG": true}
+
+type token struct {
+	kind int
+	text string
+}
+
+// parser is a recursive descent parser for the query dialect
+type parser struct {
+	tokens []token
+	pos    int
+	last   string
+}
+
+// lex splits the query in tokens
+func lex(query string) (tokens []token, err error) {
+	runes := []rune(query)
+	for x := 0; x < len(runes); {
+		r := runes[x]
+		switch {
+		case unicode.IsSpace(r):
+			x++
+
+		case r == '\'':
+			var text strings.Builder
+			for x++; ; x++ {
+				if x == len(runes) {
+					return nil, errUnterminatedString
+				}
+				if runes[x] == '\'' {
+					// quotes are escaped by doubling them
+					if x+1 < len(runes) && runes[x+1] == '\'' {
+						text.WriteRune('\'')
+						x++
+						continue
+					}
+					x++
+					break
+				}
+				text.WriteRune(runes[x])
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text.String()})
+
+		case unicode.IsDigit(r) || (r == '-' && x+1 < len(runes) && unicode.IsDigit(runes[x+1])):
+			start := x
+			for x++; x < len(runes) && (unicode.IsDigit(runes[x]) || runes[x] == '.' || runes[x] == 'e' || runes[x] == 'E'); x++ {
+			}
+			kind := tokenNumber
+			for ; x < len(runes) && (unicode.IsLetter(runes[x]) || unicode.IsDigit(runes[x]) || runes[x] == '.'); x++ {
+				kind = tokenDuration
+			}
+			tokens = append(tokens, token{kind: kind, text: string(runes[start:x])})
+
+		case unicode.IsLetter(r) || r == '_':
+			start := x
+			for ; x < len(runes) && (unicode.IsLetter(runes[x]) || unicode.IsDigit(runes[x]) || runes[x] == '_' || runes[x] == '.'); x++ {
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[start:x])})
+
+		default:
+			text := string(r)
+			if x+1 < len(runes) {
+				switch pair := string(runes[x : x+2]); pair {
+				case "!=", "<>", "<=", ">=":
+					text = pair
+				}
+			}
+			if !strings.Contains("(),*=<>!", string(r)) {
+				return nil, streams.Errorf(streams.CodeConfig, "unexpected character %q", r)
+			}
+			x += len(text)
+			tokens = append(tokens, token{kind: tokenSymbol, text: text})
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// Parse the query
+func Parse(query string) (q *Query, err error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	q = &Query{}
+
+	if err = p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	if err = p.fields(q); err != nil {
+		return nil, err
+	}
+
+	if err = p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	if q.from, err = p.topic(); err != nil {
+		return nil, err
+	}
+
+	if p.keyword("JOIN") {
+		if q.join, err = p.topic(); err != nil {
+			return nil, err
+		}
+		if err = p.expect("WITHIN"); err != nil {
+			return nil, err
+		}
+		if q.within, err = p.duration(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.keyword("WHERE") {
+		if q.where, err = p.or(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.keyword("GROUP") {
+		if err = p.expect("BY"); err != nil {
+			return nil, err
+		}
+		if q.groupBy, err = p.path(); err != nil {
+			return nil, err
+		}
+		if err = p.expect("WINDOW"); err != nil {
+			return nil, err
+		}
+		if q.window, err = p.duration(); err != nil {
+			return nil, err
+		}
+	}
+
+	if t := p.next(); t.kind != tokenEOF {
+		return nil, streams.Errorf(streams.CodeConfig, "unexpected %q", t.text)
+	}
+
+	return q, q.validate()
+}
+
+// fields parses the select list
+func (p *parser) fields(q *Query) (err error) {
+	if p.symbol("*") {
+		q.star = true
+		return nil
+	}
+
+	for {
+		var f field
+		t := p.next()
+		if t.kind != tokenIdent {
+			return streams.Errorf(streams.CodeConfig, "expected field, found %q", t.text)
+		}
+
+		switch name := strings.ToUpper(t.text); {
+		case aggregates[name] && p.symbol("("):
+			f.fn = name
+			f.name = strings.ToLower(name)
+			if !(name == "COUNT" && p.symbol("*")) {
+				if f.path, err = p.path(); err != nil {
+					return err
+				}
+				f.name += "_" + strings.Replace(p.last, ".", "_", -1)
+			}
+			if !p.symbol(")") {
+				return streams.Errorf(streams.CodeConfig, "expected ) after %s", name)
+			}
+
+		default:
+			p.pos--
+			if f.path, err = p.path(); err != nil {
+				return err
+			}
+			f.name = p.last[strings.LastIndex(p.last, ".")+1:]
+		}
+
+		if p.keyword("AS") {
+			t := p.next()
+			if t.kind != tokenIdent {
+				return streams.Errorf(streams.CodeConfig, "expected alias, found %q", t.text)
+			}
+			f.name = t.text
+		}
+
+		q.fields = append(q.fields, f)
+		if !p.symbol(",") {
+			return nil
+		}
+	}
+}
+
+// or parses a disjunction of conjunctions
+func (p *parser) or() (e expr, err error) {
+	if e, err = p.and(); err != nil {
+		return nil, err
+	}
+
+	for p.keyword("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		e = orExpr{e, right}
+	}
+	return e, nil
+}
+
+// and parses a conjunction of conditions
+func (p *parser) and() (e expr, err error) {
+	if e, err = p.not(); err != nil {
+		return nil, err
+	}
+
+	for p.keyword("AND") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		e = andExpr{e, right}
+	}
+	return e, nil
+}
+
+// not parses a negated or parenthesized condition or a comparison
+func (p *parser) not() (e expr, err error) {
+	if p.keyword("NOT") {
+		if e, err = p.not(); err != nil {
+			return nil, err
+		}
+		return notExpr{e}, nil
+	}
+
+	if p.symbol("(") {
+		if e, err = p.or(); err != nil {
+			return nil, err
+		}
+		if !p.symbol(")") {
+			return nil, streams.Errorf(streams.CodeConfig, "expected )")
+		}
+		return e, nil
+	}
+
+	var c compareExpr
+	if c.path, err = p.path(); err != nil {
+		return nil, err
+	}
+
+	t := p.next()
+	switch t.text {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+		c.op = t.text
+	default:
+		return nil, streams.Errorf(streams.CodeConfig, "expected comparison operator, found %q", t.text)
+	}
+
+	if c.value, err = p.literal(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// literal parses a string, number, boolean or null literal
+func (p *parser) literal() (value interface{}, err error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return t.text, nil
+	case tokenNumber:
+		return json.Number(t.text), nil
+	case tokenIdent:
+		switch strings.ToUpper(t.text) {
+		case "TRUE":
+			return true, nil
+		case "FALSE":
+			return false, nil
+		case "NULL":
+			return nil, nil
+		}
+	}
+	return nil, streams.Errorf(streams.CodeConfig, "expected literal, found %q", t.text)
+}
+
+// path parses a dotted field path into a JSONPath
+func (p *parser) path() (path *jsonpath.Path, err error) {
+	t := p.next()
+	if t.kind != tokenIdent {
+		return nil, streams.Errorf(streams.CodeConfig, "expected field, found %q", t.text)
+	}
+	p.last = t.text
+	return jsonpath.Compile("$." + t.text)
+}
+
+// topic parses a topic name or *
+func (p *parser) topic() (topic string, err error) {
+	if p.symbol("*") {
+		return "*", nil
+	}
+
+	t := p.next()
+	if t.kind != tokenIdent && t.kind != tokenString {
+		return "", streams.Errorf(streams.CodeConfig, "expected topic, found %q", t.text)
+	}
+	return t.text, nil
+}
+
+// duration parses a duration literal
+func (p *parser) duration() (d time.Duration, err error) {
+	t := p.next()
+	if t.kind != tokenDuration {
+		return 0, streams.Errorf(streams.CodeConfig, "expected duration, found %q", t.text)
+	}
+	return time.ParseDuration(t.text)
+}
+
+// next returns the next token
+func (p *parser) next() (t token) {
+	t = p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword consumes the next token if it is the given keyword
+func (p *parser) keyword(keyword string) (ok bool) {
+	if t := p.tokens[p.pos]; t.kind == tokenIdent && strings.EqualFold(t.text, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// symbol consumes the next token if it is the given symbol
+func (p *parser) symbol(symbol string) (ok bool) {
+	if t := p.tokens[p.pos]; t.kind == tokenSymbol && t.text == symbol {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expect the next token to be the given keyword
+func (p *parser) expect(keyword string) (err error) {
+	if !p.keyword(keyword) {
+		return streams.Errorf(streams.CodeConfig, "expected %s, found %q", keyword, p.tokens[p.pos].text)
+	}
+	return nil
+}
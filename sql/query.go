@@ -0,0 +1,468 @@
+package sql
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/aggregate"
+	"github.com/brunotm/streams/jsonpath"
+)
+
+var (
+	errAggregateWithoutGroup = streams.Errorf(streams.CodeConfig, "aggregates require GROUP BY")
+	errStarWithGroup         = streams.Errorf(streams.CodeConfig, "SELECT * is not supported with GROUP BY")
+	errJoinAnyTopic          = streams.Errorf(streams.CodeConfig, "JOIN requires a FROM topic")
+	errNoGroupKey            = streams.Errorf(streams.CodeConfig, "GROUP BY field not found")
+)
+
+// Query is a parsed continuous query of the dialect:
+//
+//	SELECT * | field [AS name], ...
+//	FROM topic | *
+//	[JOIN topic WITHIN duration]
+//	[WHERE condition]
+//	[GROUP BY field WINDOW duration]
+//
+// Fields are dotted paths into the json record values, or COUNT(*), COUNT,
+// SUM, MIN, MAX and AVG of a field when grouping. Conditions compare fields
+// with string, number, boolean or null literals with =, !=, <>, <, <=, > and
+// >=, combined with AND, OR, NOT and parentheses.
+//
+// FROM selects the records by topic. JOIN joins the FROM records with the
+// latest record of the same key from the JOIN topic within the given duration,
+// into a json object with both values under their topic names, so the fields
+// of joined queries are qualified by topic. GROUP BY rekeys the records by the
+// field value and aggregates them within tumbling windows of stream time.
+type Query struct {
+	star    bool
+	fields  []field
+	from    string
+	join    string
+	within  time.Duration
+	where   expr
+	groupBy *jsonpath.Path
+	window  time.Duration
+}
+
+type field struct {
+	name string
+	fn   string
+	path *jsonpath.Path
+}
+
+// validate the query semantics
+func (q *Query) validate() (err error) {
+	for _, f := range q.fields {
+		if f.fn != "" && q.groupBy == nil {
+			return errAggregateWithoutGroup
+		}
+	}
+
+	if q.star && q.groupBy != nil {
+		return errStarWithGroup
+	}
+
+	if q.join != "" && q.from == "*" {
+		return errJoinAnyTopic
+	}
+	return nil
+}
+
+// Build adds the query processors to the builder after the given predecessors.
+// The query results are forwarded by the processor with the given name, with
+// the processors of intermediate stages named with the <name>-<stage> suffix.
+// The emission of grouped results can be configured as an aggregate.Windowed
+// processor under the <stream>.<name> config subtree.
+func (q *Query) Build(b *streams.Builder, name string, predecessors ...string) (err error) {
+	if q.join != "" {
+		supplier := func() (processor streams.Processor) {
+			return &joiner{query: q, windows: make(map[string]streams.Record)}
+		}
+		if err = b.AddProcessor(name+"-join", supplier, predecessors...); err != nil {
+			return err
+		}
+		predecessors = []string{name + "-join"}
+	}
+
+	if err = b.AddProcessorFunc(name+"-where", q.filter, predecessors...); err != nil {
+		return err
+	}
+
+	if q.groupBy == nil {
+		return b.AddProcessorFunc(name, q.project, name+"-where")
+	}
+
+	if err = b.AddProcessorFunc(name+"-group", q.rekey, name+"-where"); err != nil {
+		return err
+	}
+	return b.AddProcessor(name, aggregate.WindowedSupplier(q.window, aggregate.EmitOnUpdate, q.aggregate), name+"-group")
+}
+
+// filter forwards the records of the FROM topic matching the WHERE condition
+func (q *Query) filter(pc streams.ProcessorContext, record streams.Record) {
+	if q.join == "" && q.from != "*" && record.Topic != q.from {
+		return
+	}
+
+	if q.where == nil {
+		pc.Forward(record)
+		return
+	}
+
+	document, err := decode(record)
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	if q.where.eval(document) {
+		pc.Forward(record)
+	}
+}
+
+// project forwards the record with the selected fields
+func (q *Query) project(pc streams.ProcessorContext, record streams.Record) {
+	if q.star {
+		pc.Forward(record)
+		return
+	}
+
+	document, err := decode(record)
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	result := make(map[string]interface{}, len(q.fields))
+	for _, f := range q.fields {
+		result[f.name], _ = f.path.Get(document)
+	}
+
+	value, err := json.Marshal(result)
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	record.Value = streams.ByteEncoder(value)
+	pc.Forward(record)
+}
+
+// rekey forwards the record keyed by its GROUP BY field
+func (q *Query) rekey(pc streams.ProcessorContext, record streams.Record) {
+	document, err := decode(record)
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	value, ok := q.groupBy.Get(document)
+	if !ok {
+		pc.Error(errNoGroupKey, record)
+		return
+	}
+
+	key, ok := jsonpath.Scalar(value)
+	if !ok {
+		data, _ := json.Marshal(value)
+		key = string(data)
+	}
+	pc.Forward(record.WithKey(streams.StringEncoder(key)))
+}
+
+// aggregate adds the record value to the group aggregate. A new aggregate
+// is returned on every update, as forwarded aggregates must not change.
+func (q *Query) aggregate(current, value streams.Encoder) (result streams.Encoder) {
+	next := &group{query: q, values: make([]interface{}, len(q.fields)), counts: make([]float64, len(q.fields))}
+	if current != nil {
+		prev := current.(*group)
+		copy(next.values, prev.values)
+		copy(next.counts, prev.counts)
+	}
+
+	data, err := value.Encode()
+	if err != nil {
+		next.err = err
+		return next
+	}
+
+	document, err := jsonpath.Decode(data)
+	if err != nil {
+		next.err = err
+		return next
+	}
+
+	for x, f := range q.fields {
+		if f.path == nil {
+			next.counts[x]++
+			continue
+		}
+
+		v, ok := f.path.Get(document)
+		if !ok || v == nil {
+			continue
+		}
+
+		if f.fn == "" || f.fn == "COUNT" {
+			next.values[x] = v
+			next.counts[x]++
+			continue
+		}
+
+		n, ok := number(v)
+		if !ok {
+			continue
+		}
+
+		switch prev, exists := next.values[x].(float64); {
+		case !exists:
+			next.values[x] = n
+		case f.fn == "SUM" || f.fn == "AVG":
+			next.values[x] = prev + n
+		case f.fn == "MIN" && n < prev, f.fn == "MAX" && n > prev:
+			next.values[x] = n
+		}
+		next.counts[x]++
+	}
+
+	return next
+}
+
+// group is a GROUP BY aggregate encoded as a json object of the selected fields
+type group struct {
+	query  *Query
+	values []interface{}
+	counts []float64
+	err    error
+}
+
+// Encode the group aggregate
+func (g *group) Encode() (data []byte, err error) {
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	result := make(map[string]interface{}, len(g.values))
+	for x, f := range g.query.fields {
+		switch f.fn {
+		case "COUNT":
+			result[f.name] = g.counts[x]
+		case "AVG":
+			if g.counts[x] > 0 {
+				result[f.name] = g.values[x].(float64) / g.counts[x]
+			} else {
+				result[f.name] = nil
+			}
+		default:
+			result[f.name] = g.values[x]
+		}
+	}
+	return json.Marshal(result)
+}
+
+// joiner joins the FROM topic records with the latest JOIN topic record of
+// the same key within the join duration
+type joiner struct {
+	query   *Query
+	windows map[string]streams.Record
+	swept   time.Time
+}
+
+// Process joins or buffers the record
+func (j *joiner) Process(pc streams.ProcessorContext, record streams.Record) {
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	j.sweep(record.Time)
+
+	switch record.Topic {
+	case j.query.join:
+		j.windows[string(key)] = record
+
+	case j.query.from:
+		right, ok := j.windows[string(key)]
+		if !ok || !within(record.Time, right.Time, j.query.within) {
+			return
+		}
+
+		left, err := record.EncodeValue()
+		if err != nil {
+			pc.Error(err, record)
+			return
+		}
+
+		value, err := right.EncodeValue()
+		if err != nil {
+			pc.Error(err, record)
+			return
+		}
+
+		joined, err := json.Marshal(map[string]json.RawMessage{
+			j.query.from: left,
+			j.query.join: value,
+		})
+		if err != nil {
+			pc.Error(err, record)
+			return
+		}
+
+		record.Value = streams.ByteEncoder(joined)
+		pc.Forward(record)
+	}
+}
+
+// sweep evicts the buffered records outside the join duration once per duration
+func (j *joiner) sweep(now time.Time) {
+	if now.Sub(j.swept) < j.query.within {
+		return
+	}
+	j.swept = now
+
+	for key, record := range j.windows {
+		if record.Time.Before(now.Add(-j.query.within)) {
+			delete(j.windows, key)
+		}
+	}
+}
+
+// within returns if the times are at most d apart
+func within(a, b time.Time, d time.Duration) (ok bool) {
+	diff := a.Sub(b)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= d
+}
+
+// decode the record json value
+func decode(record streams.Record) (document interface{}, err error) {
+	value, err := record.EncodeValue()
+	if err != nil {
+		return nil, err
+	}
+	return jsonpath.Decode(value)
+}
+
+// number returns the float value of a json number
+func number(value interface{}) (n float64, ok bool) {
+	v, ok := value.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(string(v), 64)
+	return n, err == nil
+}
+
+// expr is a boolean expression over a json document
+type expr interface {
+	eval(document interface{}) (ok bool)
+}
+
+type andExpr struct{ left, right expr }
+type orExpr struct{ left, right expr }
+type notExpr struct{ e expr }
+
+type compareExpr struct {
+	path  *jsonpath.Path
+	op    string
+	value interface{}
+}
+
+func (e andExpr) eval(document interface{}) (ok bool) {
+	return e.left.eval(document) && e.right.eval(document)
+}
+
+func (e orExpr) eval(document interface{}) (ok bool) {
+	return e.left.eval(document) || e.right.eval(document)
+}
+
+func (e notExpr) eval(document interface{}) (ok bool) {
+	return !e.e.eval(document)
+}
+
+// eval compares the field with the literal. Missing fields are null, and
+// comparisons between different types only match with != and <>.
+func (e compareExpr) eval(document interface{}) (ok bool) {
+	value, _ := e.path.Get(document)
+
+	var cmp int
+	switch literal := e.value.(type) {
+	case nil:
+		if value != nil {
+			cmp = 1
+		}
+	case bool:
+		v, ok := value.(bool)
+		if !ok {
+			return e.op == "!=" || e.op == "<>"
+		}
+		if v != literal {
+			cmp = 1
+		}
+	case string:
+		v, ok := value.(string)
+		if !ok {
+			return e.op == "!=" || e.op == "<>"
+		}
+		cmp = compareStrings(v, literal)
+	case json.Number:
+		v, ok := number(value)
+		l, _ := number(literal)
+		if !ok {
+			return e.op == "!=" || e.op == "<>"
+		}
+		switch {
+		case v < l:
+			cmp = -1
+		case v > l:
+			cmp = 1
+		}
+	}
+
+	switch e.op {
+	case "=":
+		return cmp == 0
+	case "!=", "<>":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// compareStrings returns -1, 0 or 1 comparing a and b
+func compareStrings(a, b string) (cmp int) {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+package sql
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	q, err := Parse(`select user.id as uid, amount from orders
+		where (amount >= 10.5 or not status = 'it''s') and user.vip != false`)
+	assert.NoError(t, err)
+	assert.Equal(t, "orders", q.from)
+	assert.Equal(t, "uid", q.fields[0].name)
+	assert.Equal(t, "amount", q.fields[1].name)
+
+	q, err = Parse("SELECT region, COUNT(*), AVG(amount) FROM * GROUP BY region WINDOW 1m30s")
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Second, q.window)
+	assert.Equal(t, "count", q.fields[1].name)
+	assert.Equal(t, "avg_amount", q.fields[2].name)
+
+	for _, query := range []string{
+		"SELECT FROM orders",
+		"SELECT * FROM orders WHERE",
+		"SELECT * FROM orders WHERE amount ~ 1",
+		"SELECT * FROM orders WHERE name = 'unterminated",
+		"SELECT COUNT(*) FROM orders",
+		"SELECT * FROM orders GROUP BY region WINDOW 1m",
+		"SELECT * FROM orders GROUP BY region",
+		"SELECT * FROM * JOIN users WITHIN 1m",
+		"SELECT * FROM orders JOIN users",
+		"SELECT * FROM orders extra",
+	} {
+		_, err = Parse(query)
+		assert.Equal(t, streams.CodeConfig, streams.CodeOf(err), query)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	record := func(topic, key, value string, offset time.Duration) streams.Record {
+		return streams.NewRecord(topic, streams.StringEncoder(key), streams.StringEncoder(value), now.Add(offset), nil)
+	}
+
+	records := []streams.Record{
+		record("users", "u1", `{"name":"ann"}`, 0),
+		record("orders", "u1", `{"region":"eu","amount":10}`, time.Second),
+		record("orders", "u2", `{"region":"us","amount":5}`, 2*time.Second),
+		record("orders", "u1", `{"region":"eu","amount":30}`, 3*time.Second),
+		record("orders", "u1", `{"region":"eu","amount":1}`, time.Hour),
+	}
+
+	queries := map[string]string{
+		"big":     "SELECT amount AS total, region FROM orders WHERE amount > 5 AND region = 'eu'",
+		"joined":  "SELECT users.name, orders.amount FROM orders JOIN users WITHIN 1m",
+		"regions": "SELECT region, COUNT(*), SUM(amount), MAX(amount) FROM orders GROUP BY region WINDOW 1m",
+	}
+
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(8)
+	results := make(map[string][]string)
+
+	b := streams.NewBuilder("stream", streams.NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() streams.Source {
+		return &mock.Source{Records: records}
+	}))
+
+	for name, query := range queries {
+		q, err := Parse(query)
+		assert.NoError(t, err)
+		assert.NoError(t, q.Build(b, name, "source"))
+
+		name := name
+		assert.NoError(t, b.AddSinkFunc(name+"-sink", func(pc streams.ProcessorContext, record streams.Record) {
+			value, _ := record.EncodeValue()
+			mtx.Lock()
+			results[name] = append(results[name], string(value))
+			mtx.Unlock()
+			wg.Done()
+		}, name))
+	}
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+	assert.NoError(t, stream.Close())
+
+	assert.Equal(t, []string{`{"region":"eu","total":10}`, `{"region":"eu","total":30}`}, results["big"])
+	assert.Equal(t, []string{`{"amount":10,"name":"ann"}`, `{"amount":30,"name":"ann"}`}, results["joined"])
+	assert.Equal(t, []string{
+		`{"count":1,"max_amount":10,"region":"eu","sum_amount":10}`,
+		`{"count":1,"max_amount":5,"region":"us","sum_amount":5}`,
+		`{"count":2,"max_amount":30,"region":"eu","sum_amount":40}`,
+		`{"count":1,"max_amount":1,"region":"eu","sum_amount":1}`,
+	}, results["regions"])
+}
@@ -0,0 +1,121 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/http"
+	"sync"
+)
+
+var (
+	errNotStandby = Errorf(CodeState, "stream is not in standby")
+)
+
+// standby follows the changelogs of the stream stores implementing the
+// Follower interface while the stream is in standby
+type standby struct {
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+// follow the changelogs of the stream stores until the standby is stopped.
+// Follow errors are emitted by the store node.
+func (s *Stream) follow() (sb *standby) {
+	sb = &standby{}
+	sb.done = make(chan struct{})
+
+	s.topology.smtx.RLock()
+	defer s.topology.smtx.RUnlock()
+
+	for _, node := range s.topology.stores {
+		follower, ok := node.processor.(Follower)
+		if !ok {
+			continue
+		}
+
+		sb.wg.Add(1)
+		go func(node *Node) {
+			defer sb.wg.Done()
+			if err := follower.Follow(sb.done); err != nil {
+				node.pc.Error(err)
+			}
+		}(node)
+	}
+
+	return sb
+}
+
+// stop following the changelogs, waiting for the followers to return
+func (sb *standby) stop() {
+	close(sb.done)
+	sb.wg.Wait()
+}
+
+// Standby returns if the stream is in standby, restoring and following the
+// changelogs of its stores without consuming from its sources.
+// Streams start in standby with the <stream>.standby config.
+func (s *Stream) Standby() (standby bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.standby != nil
+}
+
+// Promote a standby stream to active, stopping following the changelogs
+// of its stores and starting consuming from its sources, for fast failover
+// from a active instance of the stream.
+func (s *Stream) Promote() (err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.standby == nil {
+		return errNotStandby
+	}
+
+	s.standby.stop()
+	s.standby = nil
+	_, err = s.startSources()
+	return err
+}
+
+// Promote the named standby stream to active
+func (m *Streams) Promote(name string) (err error) {
+	stream := m.Get(name)
+	if stream == nil {
+		return ErrStreamNotFound
+	}
+	return stream.Promote()
+}
+
+// PromoteHandler returns a http.Handler promoting the standby stream named in
+// the stream query parameter on POST requests.
+func PromoteHandler(m *Streams) (handler http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		switch err := m.Promote(r.URL.Query().Get("stream")); err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case ErrStreamNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// followerStore follows a changelog until done
+type followerStore struct {
+	nopStore
+	restored  int32
+	following chan struct{}
+	stopped   int32
+}
+
+func (f *followerStore) Restore(progress func(restored int64)) error {
+	atomic.AddInt32(&f.restored, 1)
+	return nil
+}
+
+func (f *followerStore) Follow(done <-chan struct{}) error {
+	close(f.following)
+	<-done
+	atomic.AddInt32(&f.stopped, 1)
+	return nil
+}
+
+func TestStreamStandby(t *testing.T) {
+	var wg sync.WaitGroup
+	var processed int32
+	store := &followerStore{following: make(chan struct{})}
+
+	config := NewConfig(nil)
+	config.Set(true, "stream.standby")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddStore("store", func() Store { return store }))
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(5)))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		atomic.AddInt32(&processed, 1)
+		wg.Done()
+	}, "source"))
+
+	m := NewStreams(nil)
+	stream, err := m.Add(b)
+	assert.NoError(t, err)
+	assert.NoError(t, m.Start())
+
+	// standby streams restore and follow their stores without consuming
+	<-store.following
+	assert.True(t, stream.Standby())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&store.restored))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&processed))
+
+	handler := PromoteHandler(m)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/promote?stream=other", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	wg.Add(5)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/promote?stream=stream", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	wg.Wait()
+
+	assert.False(t, stream.Standby())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&store.stopped))
+	assert.Equal(t, errNotStandby, stream.Promote())
+	assert.NoError(t, m.Close())
+
+	// standby streams can be closed without being promoted
+	store = &followerStore{following: make(chan struct{})}
+	b = NewBuilder("stream", config)
+	assert.NoError(t, b.AddStore("store", func() Store { return store }))
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(5)))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		atomic.AddInt32(&processed, 1)
+	}, "source"))
+
+	stream, err = b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	<-store.following
+	assert.NoError(t, stream.Close())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&store.stopped))
+	assert.Equal(t, int32(5), atomic.LoadInt32(&processed))
+}
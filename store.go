@@ -17,12 +17,15 @@ package streams
 */
 
 import (
-	"errors"
+	"time"
 )
 
 var (
 	// ErrKeyNotFound is returned when a key is not found on a get from the store.
-	ErrKeyNotFound = errors.New("key not found")
+	ErrKeyNotFound = Errorf(CodeStore, "key not found")
+	// ErrHistoryUnavailable is returned when opening a view of a store as of a
+	// time not covered by its history, or of a store without history.
+	ErrHistoryUnavailable = Errorf(CodeStore, "store history not available")
 )
 
 // Remover interface. Any Store that must clear its data
@@ -32,6 +35,82 @@ type Remover interface {
 	Remove() (err error)
 }
 
+// Restorer interface. Any Store that must restore its state from changelogs
+// or snapshots before the Stream starts processing records must implement
+// this interface. Restore must report the number of restored entries
+// through the given progress function as the restoration advances.
+type Restorer interface {
+	Restore(progress func(restored int64)) (err error)
+}
+
+// WindowedStore is a Store of values keyed by a key and window start time,
+// for processors aggregating records into time windows.
+type WindowedStore interface {
+	Store
+
+	// GetWindow returns the value of the key window starting at start.
+	GetWindow(key []byte, start time.Time) (value []byte, err error)
+	// SetWindow sets the value of the key window starting at start.
+	SetWindow(key []byte, start time.Time, value []byte) (err error)
+	// DeleteWindow deletes the key window starting at start.
+	DeleteWindow(key []byte, start time.Time) (err error)
+	// FetchWindows applies the callback for the key windows starting within
+	// from and to inclusive in start order. Returning a error causes the iteration to stop.
+	FetchWindows(key []byte, from, to time.Time, cb func(start time.Time, value []byte) error) (err error)
+	// RangeWindows applies the callback for all windows, in start order per key.
+	// Returning a error causes the iteration to stop.
+	RangeWindows(cb func(key []byte, start time.Time, value []byte) error) (err error)
+}
+
+// Follower interface. Any Restorer store that can keep applying its changelog
+// after the restoration must implement this interface, keeping the state of
+// standby streams warm until promoted. Follow must return once done is closed.
+type Follower interface {
+	Follow(done <-chan struct{}) (err error)
+}
+
+// Replicator interface. Any Store that can open a read only, point in time
+// view of its state must implement this interface, allowing heavy external
+// queries to run without contending with the processing path writes.
+// Replicas must be closed by the caller, releasing their resources.
+type Replicator interface {
+	Replica() (replica ROStore, err error)
+}
+
+// ReadOnlyOpener interface. Any persistent Store whose state can be opened by
+// another process while the stream owning it is running must implement this
+// interface. OpenReadOnly opens a read only view of the state the store would
+// open when initialized with the given context, without interfering with the
+// live store. Views must be closed by the caller if implementing the Closer interface.
+type ReadOnlyOpener interface {
+	OpenReadOnly(pc ProcessorContext) (view ROStore, err error)
+}
+
+// Historian interface. Any Store that keeps the history of its changes and can
+// open a read only view of its state as of a past time or named checkpoint must
+// implement this interface, allowing incorrect state to be debugged by inspecting
+// how it evolved. Views of points not covered by the history fail with
+// ErrHistoryUnavailable. Views must be closed by the caller if implementing
+// the Closer interface.
+type Historian interface {
+	// Checkpoint marks the current state of the store with the given name
+	Checkpoint(name string) (err error)
+	// AsOf opens a view of the store state as of the given time
+	AsOf(at time.Time) (view ROStore, err error)
+	// AsOfCheckpoint opens a view of the store state at the latest checkpoint
+	// with the given name
+	AsOfCheckpoint(name string) (view ROStore, err error)
+}
+
+// Layered interface. Any Store implemented over the Store methods of a
+// underlying store must implement this interface, allowing the stream to
+// interpose on the underlying store, as with the ExactlyOnce guarantee.
+// Layer returns a copy of the store over the given view of its underlying store.
+type Layered interface {
+	Underlying() (store Store)
+	Layer(store Store) (layered Store)
+}
+
 // StoreSupplier instantiates Stores used to create a Stream topology,
 // recreate them or clone a Stream.
 // If further configuration is needed, the store must implement the Initializer
@@ -72,10 +151,24 @@ type Store interface {
 	ROStore
 
 	// Set the value for the given key.
-	// If TTL is greater than 0 it will set an expiry time for the key.
+	// Stores with expiring keys implement TTLStore.
 	Set(key, value []byte) (err error)
-	// Set(key, value []byte, ttl time.Duration) (err error)
 
 	// Delete the given key and associated value
 	Delete(key []byte) (err error)
 }
+
+// TTLStore is a Store with expiring keys.
+// Expired keys are not visible to reads, and are deleted by Expire or
+// by the store itself.
+type TTLStore interface {
+	Store
+
+	// SetWithTTL sets the value for the given key expiring after the given ttl.
+	SetWithTTL(key, value []byte, ttl time.Duration) (err error)
+
+	// Expire applies the callback for the keys expired at the given time and
+	// their last value, deleting them afterwards. Returning a error causes the
+	// expiration to stop, keeping the current and remaining expired keys.
+	Expire(now time.Time, callback func(key, value []byte) error) (err error)
+}
@@ -0,0 +1,370 @@
+package badger
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/brunotm/streams"
+	bdb "github.com/dgraph-io/badger"
+)
+
+const (
+	// DefaultGCInterval is the default interval of the value log garbage collection
+	DefaultGCInterval = 5 * time.Minute
+
+	// gcDiscardRatio is the ratio of discardable data for rewriting a value log file
+	gcDiscardRatio = 0.5
+)
+
+var (
+	errPathInUse = streams.Errorf(streams.CodeStore, "state path already in use")
+
+	// paths in use by the stores within the process
+	pathsMtx sync.Mutex
+	paths    = make(map[string]bool)
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*DB)(nil)
+var _ streams.Closer = (*DB)(nil)
+var _ streams.Remover = (*DB)(nil)
+var _ streams.Store = (*DB)(nil)
+var _ streams.Instrumented = (*DB)(nil)
+var _ streams.Replicator = (*DB)(nil)
+var _ streams.StoreSupplier = Supplier
+
+// DB is a durable badger key value state store, suited for high churn state
+// as badger separates values from the LSM tree keys.
+// Keys can expire with SetWithTTL, and are dropped by badger once expired.
+// The badger options are set through the store config subtree
+// <stream>.<node> with the following keys:
+//
+//	ttl:        default ttl of the keys set in the store, 0 (default) never expires
+//	syncwrites: sync writes to disk before acknowledging them, false (default)
+//	gcinterval: interval of the value log garbage collection, 5m (default)
+type DB struct {
+	streams.StoreMetrics
+	pc   streams.ProcessorContext
+	db   *bdb.DB
+	path string
+	ttl  time.Duration
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Supplier for badger store
+func Supplier() (store streams.Store) {
+	return &DB{}
+}
+
+// Init store.
+// The store state is namespaced by stream name, node name and task id within
+// the <stream>.state.dir config directory, which defaults to the state
+// directory alongside the binary. Opening a path already in use by another
+// store within the process fails.
+func (d *DB) Init(pc streams.ProcessorContext) (err error) {
+	d.pc = pc
+
+	statePath, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		return err
+	}
+
+	statePath = d.pc.Config().
+		Get(d.pc.StreamName(), "state", "dir").
+		String(filepath.Join(statePath, "state"))
+
+	d.path = filepath.Join(statePath, d.pc.StreamName(), d.pc.NodeName(), strconv.Itoa(d.pc.TaskID()))
+	if err = acquire(d.path); err != nil {
+		return err
+	}
+
+	config := d.pc.Config().Get(d.pc.StreamName(), d.pc.NodeName())
+	d.ttl = config.Get("ttl").Duration(0)
+
+	options := bdb.DefaultOptions(d.path)
+	options.SyncWrites = config.Get("syncwrites").Bool(false)
+
+	if d.db, err = bdb.Open(options); err != nil {
+		release(d.path)
+		return err
+	}
+
+	d.done = make(chan struct{})
+	d.wg.Add(1)
+	go d.collect(config.Get("gcinterval").Duration(DefaultGCInterval))
+
+	return nil
+}
+
+// acquire the given path for a store, detecting stores sharing a path
+func acquire(path string) (err error) {
+	pathsMtx.Lock()
+	defer pathsMtx.Unlock()
+
+	if paths[path] {
+		return errPathInUse
+	}
+	paths[path] = true
+	return nil
+}
+
+// release the given path
+func release(path string) {
+	pathsMtx.Lock()
+	defer pathsMtx.Unlock()
+	delete(paths, path)
+}
+
+// collect the value log garbage at the given interval until the store is closed
+func (d *DB) collect(interval time.Duration) {
+	defer d.wg.Done()
+
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			// Rewrite value log files until there is nothing left to collect
+			for d.db.RunValueLogGC(gcDiscardRatio) == nil {
+			}
+		}
+	}
+}
+
+// Remove closes the store and erases its contents
+func (d *DB) Remove() (err error) {
+	if err = d.Close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(d.path)
+}
+
+// Close the store releasing its resources.
+func (d *DB) Close() (err error) {
+	close(d.done)
+	d.wg.Wait()
+
+	err = d.db.Close()
+	d.db = nil
+	release(d.path)
+	return err
+}
+
+// Name returns this store name.
+func (d *DB) Name() (name string) {
+	return d.pc.NodeName()
+}
+
+// Process store or deletes any forwarded record to the store.
+// Records with empty values deletes the given key from the store.
+func (d *DB) Process(pc streams.ProcessorContext, record streams.Record) {
+
+	if !record.IsValid() || record.Key == nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "invalid record to store"), record)
+		return
+	}
+
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record key: %w", err), record)
+		return
+	}
+
+	// Records with empty values deletes the given key from the store.
+	if record.Value == nil {
+		if err = d.Delete(key); err != nil {
+			pc.Error(err, record)
+		}
+		return
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record value: %w", err), record)
+		return
+	}
+
+	if err = d.Set(key, value); err != nil {
+		pc.Error(err, record)
+	}
+}
+
+// Get value for the given key.
+func (d *DB) Get(key []byte) (value []byte, err error) {
+	defer d.Observe(streams.StoreGet, time.Now(), &err)
+
+	err = d.db.View(func(txn *bdb.Txn) error {
+		value, err = get(txn, key)
+		return err
+	})
+	return value, err
+}
+
+// Set value for the given key, expiring after the store default ttl if set.
+func (d *DB) Set(key, value []byte) (err error) {
+	defer d.Observe(streams.StoreSet, time.Now(), &err)
+
+	return d.set(key, value, d.ttl)
+}
+
+// SetWithTTL sets the value for the given key expiring after the given ttl.
+// Expired keys are no longer visible and are dropped by badger on compactions.
+func (d *DB) SetWithTTL(key, value []byte, ttl time.Duration) (err error) {
+	defer d.Observe(streams.StoreSet, time.Now(), &err)
+
+	return d.set(key, value, ttl)
+}
+
+// set the value for the given key with the given ttl, if greater than 0
+func (d *DB) set(key, value []byte, ttl time.Duration) (err error) {
+	entry := bdb.NewEntry(key, value)
+	if ttl > 0 {
+		entry = entry.WithTTL(ttl)
+	}
+
+	return d.db.Update(func(txn *bdb.Txn) error {
+		return txn.SetEntry(entry)
+	})
+}
+
+// Delete value for the given key.
+func (d *DB) Delete(key []byte) (err error) {
+	defer d.Observe(streams.StoreDelete, time.Now(), &err)
+
+	return d.db.Update(func(txn *bdb.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+// Range iterates the store within the given key range applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+// A nil from or to sets the iterator to the begining or end of Store.
+// Setting both from and to as nil iterates the whole store
+func (d *DB) Range(from, to []byte, cb func(key, value []byte) error) (err error) {
+	defer d.Observe(streams.StoreRange, time.Now(), &err)
+
+	return d.db.View(func(txn *bdb.Txn) error {
+		return iterate(txn, from, to, nil, cb)
+	})
+}
+
+// RangePrefix iterates the store over a key prefix applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+func (d *DB) RangePrefix(prefix []byte, cb func(key, value []byte) error) (err error) {
+	defer d.Observe(streams.StoreRange, time.Now(), &err)
+
+	return d.db.View(func(txn *bdb.Txn) error {
+		return iterate(txn, prefix, nil, prefix, cb)
+	})
+}
+
+// get the value for the given key within the transaction
+func get(txn *bdb.Txn, key []byte) (value []byte, err error) {
+	item, err := txn.Get(key)
+	if err == bdb.ErrKeyNotFound {
+		return nil, streams.ErrKeyNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return item.ValueCopy(nil)
+}
+
+// iterate applies the callback for the key value pairs of the transaction
+// starting at from, up to the limit key to exclusive and within the prefix
+func iterate(txn *bdb.Txn, from, to, prefix []byte, cb func(key, value []byte) error) (err error) {
+	options := bdb.DefaultIteratorOptions
+	options.Prefix = prefix
+
+	iter := txn.NewIterator(options)
+	defer iter.Close()
+
+	for iter.Seek(from); iter.Valid(); iter.Next() {
+		item := iter.Item()
+		key := item.Key()
+
+		if to != nil && bytes.Compare(key, to) >= 0 {
+			return nil
+		}
+
+		err = item.Value(func(value []byte) error {
+			return cb(key, value)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Replica returns a read only view of the store at the current point in time,
+// backed by a badger read only transaction. The replica must be closed after use.
+func (d *DB) Replica() (replica streams.ROStore, err error) {
+	return &Replica{db: d, txn: d.db.NewTransaction(false)}, nil
+}
+
+// Replica is a read only point in time view of a badger store
+type Replica struct {
+	db  *DB
+	txn *bdb.Txn
+}
+
+// Name returns the replicated store name.
+func (r *Replica) Name() (name string) {
+	return r.db.Name()
+}
+
+// Get value for the given key.
+func (r *Replica) Get(key []byte) (value []byte, err error) {
+	return get(r.txn, key)
+}
+
+// Range iterates the replica within the given key range applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+func (r *Replica) Range(from, to []byte, cb func(key, value []byte) error) (err error) {
+	return iterate(r.txn, from, to, nil, cb)
+}
+
+// RangePrefix iterates the replica over a key prefix applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+func (r *Replica) RangePrefix(prefix []byte, cb func(key, value []byte) error) (err error) {
+	return iterate(r.txn, prefix, nil, prefix, cb)
+}
+
+// Close the replica discarding its transaction.
+func (r *Replica) Close() (err error) {
+	r.txn.Discard()
+	return nil
+}
@@ -0,0 +1,119 @@
+package badger
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/brunotm/streams/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func testContext(dir string, task int) (pc *mock.Context) {
+	config := streams.NewConfig(nil)
+	config.Set(dir, "stream", "state", "dir")
+
+	return &mock.Context{Data: mock.ContextData{
+		StreamName: "stream",
+		NodeName:   "store",
+		TaskID:     task,
+		Config:     config,
+	}}
+}
+
+func TestBadgerStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store.TestStore(t, Supplier, testContext(dir, 0))
+}
+
+func TestBadgerStorePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	first := Supplier().(*DB)
+	assert.NoError(t, first.Init(testContext(dir, 0)))
+	assert.Equal(t, filepath.Join(dir, "stream", "store", "0"), first.path)
+
+	// stores of the same stream, node and task never share a path
+	assert.Equal(t, errPathInUse, Supplier().(*DB).Init(testContext(dir, 0)))
+	assert.NoError(t, first.Remove())
+}
+
+func TestBadgerStoreTTL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db := Supplier().(*DB)
+	assert.NoError(t, db.Init(testContext(dir, 0)))
+	defer db.Remove()
+
+	assert.NoError(t, db.SetWithTTL([]byte("expiring"), []byte("1"), time.Second))
+	assert.NoError(t, db.Set([]byte("kept"), []byte("1")))
+
+	value, err := db.Get([]byte("expiring"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+
+	time.Sleep(2 * time.Second)
+
+	_, err = db.Get([]byte("expiring"))
+	assert.Equal(t, streams.ErrKeyNotFound, err)
+
+	var keys []string
+	assert.NoError(t, db.Range(nil, nil, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	}))
+	assert.Equal(t, []string{"kept"}, keys)
+}
+
+func TestBadgerStoreReplica(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db := Supplier().(*DB)
+	assert.NoError(t, db.Init(testContext(dir, 0)))
+	defer db.Remove()
+
+	assert.NoError(t, db.Set([]byte("a"), []byte("1")))
+	replica, err := db.Replica()
+	assert.NoError(t, err)
+
+	// writes after the replica is opened are not visible
+	assert.NoError(t, db.Set([]byte("a"), []byte("2")))
+	assert.NoError(t, db.Set([]byte("b"), []byte("2")))
+
+	value, err := replica.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+
+	_, err = replica.Get([]byte("b"))
+	assert.Equal(t, streams.ErrKeyNotFound, err)
+	assert.NoError(t, replica.(streams.Closer).Close())
+}
@@ -0,0 +1,285 @@
+package changelog
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+const (
+	// DefaultSegmentSize is the default size in bytes of the changelog segments
+	DefaultSegmentSize = 16 << 20
+	// DefaultInterval is the default interval between changelog compactions
+	DefaultInterval = 10 * time.Minute
+	// DefaultRetention is the default retention of deletes in compacted changelogs
+	DefaultRetention = 24 * time.Hour
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*DB)(nil)
+var _ streams.Closer = (*DB)(nil)
+var _ streams.Remover = (*DB)(nil)
+var _ streams.Restorer = (*DB)(nil)
+var _ streams.Historian = (*DB)(nil)
+var _ streams.Store = (*DB)(nil)
+
+// DB records the changes of a underlying store in a changelog, restoring the
+// store state from the changelog when the stream starts.
+// The changelog is kept in segments within the <stream>.state.dir directory,
+// namespaced by stream name, node name and task id. Segments no longer written
+// are periodically compacted into a single segment keeping only the latest value
+// per key, and deletes within the retention, bounding the changelog size and
+// restoration time. The state as of any time or checkpoint since the last
+// compaction can be inspected through AsOf and AsOfCheckpoint.
+// The changelog is configured within the <stream>.<node>.changelog
+// config subtree with the following keys:
+//
+//	segment:     segment size in bytes, 16MB (default)
+//	interval:    interval between compactions, 10m (default), 0 disables compactions
+//	retention:   retention of deletes in compacted segments, 24h (default)
+//	sync:        sync changes to disk before acknowledging them, false (default)
+//	compression: compression of the changelog entries, none (default), snappy,
+//	             gzip or any compression registered with streams.RegisterCompressor,
+//	             such as zstd from the github.com/brunotm/streams/zstd module.
+//	             Compacted segments, restored as the store state, are rewritten
+//	             with the configured compression, and entries written with
+//	             other compressions remain readable.
+type DB struct {
+	pc    streams.ProcessorContext
+	store streams.Store
+	log   *changelog
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// Supplier for a changelogged store over the stores created by the given supplier
+func Supplier(supplier streams.StoreSupplier) streams.StoreSupplier {
+	return func() (store streams.Store) {
+		return &DB{store: supplier()}
+	}
+}
+
+// Init store
+func (d *DB) Init(pc streams.ProcessorContext) (err error) {
+	d.pc = pc
+
+	statePath, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		return err
+	}
+
+	statePath = d.pc.Config().
+		Get(d.pc.StreamName(), "state", "dir").
+		String(filepath.Join(statePath, "state"))
+
+	config := d.pc.Config().Get(d.pc.StreamName(), d.pc.NodeName(), "changelog")
+	compression, err := streams.CompressionOf(config.Get("compression").String(""))
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(statePath, "changelog", d.pc.StreamName(), d.pc.NodeName(), strconv.Itoa(d.pc.TaskID()))
+
+	d.log, err = openChangelog(dir,
+		config.Get("segment").Int64(DefaultSegmentSize),
+		config.Get("retention").Duration(DefaultRetention),
+		config.Get("sync").Bool(false),
+		compression)
+	if err != nil {
+		return err
+	}
+
+	if initializer, ok := d.store.(streams.Initializer); ok {
+		if err = initializer.Init(pc); err != nil {
+			d.log.close()
+			return err
+		}
+	}
+
+	d.done = make(chan struct{})
+	d.wg.Add(1)
+	go d.compact(config.Get("interval").Duration(DefaultInterval))
+
+	return nil
+}
+
+// compact the changelog at the given interval until the store is closed
+func (d *DB) compact(interval time.Duration) {
+	defer d.wg.Done()
+
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			if err := d.Compact(); err != nil {
+				d.pc.Error(err)
+			}
+		}
+	}
+}
+
+// Compact the changelog segments no longer written into a single segment
+// keeping the latest value per key and the deletes within the retention.
+func (d *DB) Compact() (err error) {
+	return d.log.compact(time.Now())
+}
+
+// Restore the underlying store state from the changelog
+func (d *DB) Restore(progress func(restored int64)) (err error) {
+	var restored int64
+
+	err = d.log.replay(func(e entry) error {
+		switch e.op {
+		case opCheckpoint:
+			return nil
+		case opDelete:
+			err = d.store.Delete(e.key)
+		default:
+			err = d.store.Set(e.key, e.value)
+		}
+
+		restored++
+		progress(restored)
+		return err
+	})
+
+	return err
+}
+
+// Remove closes the store and erases its contents and changelog
+func (d *DB) Remove() (err error) {
+	d.stop()
+
+	if remover, ok := d.store.(streams.Remover); ok {
+		err = remover.Remove()
+	} else if closer, ok := d.store.(streams.Closer); ok {
+		err = closer.Close()
+	}
+
+	if e := d.log.remove(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// Close the store releasing its resources.
+func (d *DB) Close() (err error) {
+	d.stop()
+
+	if closer, ok := d.store.(streams.Closer); ok {
+		err = closer.Close()
+	}
+
+	if e := d.log.close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// stop the changelog compactions
+func (d *DB) stop() {
+	close(d.done)
+	d.wg.Wait()
+}
+
+// Name returns this store name.
+func (d *DB) Name() (name string) {
+	return d.pc.NodeName()
+}
+
+// Process store or deletes any forwarded record to the store.
+// Records with empty values deletes the given key from the store.
+func (d *DB) Process(pc streams.ProcessorContext, record streams.Record) {
+
+	if !record.IsValid() || record.Key == nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "invalid record to store"), record)
+		return
+	}
+
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record key: %w", err), record)
+		return
+	}
+
+	// Records with empty values deletes the given key from the store.
+	if record.Value == nil {
+		if err = d.Delete(key); err != nil {
+			pc.Error(err, record)
+		}
+		return
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record value: %w", err), record)
+		return
+	}
+
+	if err = d.Set(key, value); err != nil {
+		pc.Error(err, record)
+	}
+}
+
+// Get value for the given key.
+func (d *DB) Get(key []byte) (value []byte, err error) {
+	return d.store.Get(key)
+}
+
+// Set value for the given key, recording it in the changelog first.
+func (d *DB) Set(key, value []byte) (err error) {
+	if err = d.log.append(entry{op: opSet, time: time.Now(), key: key, value: value}); err != nil {
+		return err
+	}
+	return d.store.Set(key, value)
+}
+
+// Delete value for the given key, recording it in the changelog first.
+func (d *DB) Delete(key []byte) (err error) {
+	if err = d.log.append(entry{op: opDelete, time: time.Now(), key: key}); err != nil {
+		return err
+	}
+	return d.store.Delete(key)
+}
+
+// Range iterates the store within the given key range applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+// A nil from or to sets the iterator to the begining or end of Store.
+// Setting both from and to as nil iterates the whole store
+func (d *DB) Range(from, to []byte, cb func(key, value []byte) error) (err error) {
+	return d.store.Range(from, to, cb)
+}
+
+// RangePrefix iterates the store over a key prefix applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+func (d *DB) RangePrefix(prefix []byte, cb func(key, value []byte) error) (err error) {
+	return d.store.RangePrefix(prefix, cb)
+}
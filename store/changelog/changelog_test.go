@@ -0,0 +1,289 @@
+package changelog
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/brunotm/streams/store"
+	"github.com/brunotm/streams/store/sharded"
+	"github.com/stretchr/testify/assert"
+)
+
+func testContext(dir string) (pc *mock.Context) {
+	config := streams.NewConfig(nil)
+	config.Set(dir, "stream", "state", "dir")
+	config.Set(64, "stream", "store", "changelog", "segment")
+	config.Set(0, "stream", "store", "changelog", "interval")
+
+	return &mock.Context{Data: mock.ContextData{
+		StreamName: "stream",
+		NodeName:   "store",
+		Config:     config,
+	}}
+}
+
+func TestChangelogStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "changelog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store.TestStore(t, Supplier(sharded.Supplier), testContext(dir))
+}
+
+func TestChangelogRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "changelog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db := Supplier(sharded.Supplier)().(*DB)
+	assert.NoError(t, db.Init(testContext(dir)))
+	for x := 0; x < 10; x++ {
+		assert.NoError(t, db.Set([]byte(fmt.Sprintf("key%d", x)), []byte(fmt.Sprintf("value%d", x))))
+	}
+	assert.NoError(t, db.Delete([]byte("key0")))
+	assert.NoError(t, db.Close())
+
+	db = Supplier(sharded.Supplier)().(*DB)
+	assert.NoError(t, db.Init(testContext(dir)))
+
+	var restored int64
+	assert.NoError(t, db.Restore(func(n int64) { restored = n }))
+	assert.Equal(t, int64(11), restored)
+
+	_, err = db.Get([]byte("key0"))
+	assert.Equal(t, streams.ErrKeyNotFound, err)
+
+	value, err := db.Get([]byte("key9"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value9"), value)
+	assert.NoError(t, db.Remove())
+
+	_, err = os.Stat(db.log.dir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestChangelogCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "changelog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db := Supplier(sharded.Supplier)().(*DB)
+	assert.NoError(t, db.Init(testContext(dir)))
+
+	for x := 0; x < 100; x++ {
+		assert.NoError(t, db.Set([]byte(fmt.Sprintf("key%d", x%5)), []byte(fmt.Sprintf("value%d", x))))
+	}
+	assert.NoError(t, db.Delete([]byte("key0")))
+	assert.NoError(t, db.log.close())
+
+	before, err := db.log.size()
+	assert.NoError(t, err)
+	assert.True(t, len(db.log.segments()) > 1)
+
+	assert.NoError(t, db.Compact())
+	assert.Equal(t, 1, len(db.log.segments()))
+
+	after, err := db.log.size()
+	assert.NoError(t, err)
+	assert.True(t, after < before)
+
+	// the delete is within the retention
+	var keys []string
+	latest := make(map[string]string)
+	assert.NoError(t, db.log.replay(func(e entry) error {
+		keys = append(keys, string(e.key))
+		latest[string(e.key)] = string(e.value)
+		return nil
+	}))
+	assert.Equal(t, []string{"key0", "key1", "key2", "key3", "key4"}, keys)
+	assert.Equal(t, "value99", latest["key4"])
+	assert.Equal(t, "", latest["key0"])
+
+	// the delete is dropped after the retention
+	assert.NoError(t, db.log.compact(time.Now().Add(DefaultRetention+time.Hour)))
+	keys = nil
+	assert.NoError(t, db.log.replay(func(e entry) error {
+		keys = append(keys, string(e.key))
+		return nil
+	}))
+	assert.Equal(t, []string{"key1", "key2", "key3", "key4"}, keys)
+	assert.NoError(t, db.Close())
+}
+
+func TestChangelogHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "changelog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db := Supplier(sharded.Supplier)().(*DB)
+	assert.NoError(t, db.Init(testContext(dir)))
+
+	assert.NoError(t, db.Set([]byte("a"), []byte("1")))
+	past := time.Now()
+	time.Sleep(2 * time.Millisecond)
+
+	assert.NoError(t, db.Set([]byte("a"), []byte("2")))
+	assert.NoError(t, db.Set([]byte("b"), []byte("1")))
+	assert.NoError(t, db.Checkpoint("checkpoint"))
+	assert.NoError(t, db.Delete([]byte("a")))
+
+	values := func(view streams.ROStore) map[string]string {
+		values := make(map[string]string)
+		assert.NoError(t, view.Range(nil, nil, func(key, value []byte) error {
+			values[string(key)] = string(value)
+			return nil
+		}))
+		return values
+	}
+
+	view, err := db.AsOf(past)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1"}, values(view))
+
+	view, err = db.AsOfCheckpoint("checkpoint")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "2", "b": "1"}, values(view))
+
+	value, err := view.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("2"), value)
+
+	view, err = db.AsOf(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"b": "1"}, values(view))
+
+	_, err = db.AsOfCheckpoint("unknown")
+	assert.Equal(t, streams.ErrHistoryUnavailable, err)
+
+	// the history before the compacted changes is lost
+	assert.NoError(t, db.log.close())
+	assert.NoError(t, db.Compact())
+
+	_, err = db.AsOf(past)
+	assert.Equal(t, streams.ErrHistoryUnavailable, err)
+	_, err = db.AsOfCheckpoint("checkpoint")
+	assert.Equal(t, streams.ErrHistoryUnavailable, err)
+
+	view, err = db.AsOf(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"b": "1"}, values(view))
+	assert.NoError(t, db.Close())
+
+	// the horizon is kept across restarts
+	db = Supplier(sharded.Supplier)().(*DB)
+	assert.NoError(t, db.Init(testContext(dir)))
+	_, err = db.AsOf(past)
+	assert.Equal(t, streams.ErrHistoryUnavailable, err)
+	assert.NoError(t, db.Close())
+}
+
+func TestChangelogCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "changelog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pc := testContext(dir)
+	pc.Data.Config.Set("snappy", "stream", "store", "changelog", "compression")
+
+	db := Supplier(sharded.Supplier)().(*DB)
+	assert.NoError(t, db.Init(pc))
+	for x := 0; x < 10; x++ {
+		assert.NoError(t, db.Set([]byte(fmt.Sprintf("key%d", x)), []byte(fmt.Sprintf("value%d", x))))
+	}
+	assert.NoError(t, db.Close())
+
+	// compressed entries are restored and compacted without compression
+	db = Supplier(sharded.Supplier)().(*DB)
+	assert.NoError(t, db.Init(testContext(dir)))
+	assert.NoError(t, db.Compact())
+	assert.Equal(t, map[streams.Compression]int{streams.CompressionNone: 10}, compressions(t, db))
+	assert.NoError(t, db.Restore(func(int64) {}))
+
+	value, err := db.Get([]byte("key9"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value9"), value)
+	assert.NoError(t, db.Close())
+
+	// compacted segments, restored as the store state, are rewritten with the configured compression
+	pc.Data.Config.Set("gzip", "stream", "store", "changelog", "compression")
+	db = Supplier(sharded.Supplier)().(*DB)
+	assert.NoError(t, db.Init(pc))
+	assert.NoError(t, db.Compact())
+	assert.Equal(t, map[streams.Compression]int{streams.CompressionGzip: 10}, compressions(t, db))
+	assert.NoError(t, db.Close())
+
+	db = Supplier(sharded.Supplier)().(*DB)
+	assert.NoError(t, db.Init(testContext(dir)))
+	assert.NoError(t, db.Restore(func(int64) {}))
+	value, err = db.Get([]byte("key0"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value0"), value)
+	assert.NoError(t, db.Close())
+
+	pc.Data.Config.Set("zstd", "stream", "store", "changelog", "compression")
+	db = Supplier(sharded.Supplier)().(*DB)
+	assert.Equal(t, streams.ErrCompressionNotFound, db.Init(pc))
+}
+
+// compressions counts the entries of the sealed segments by compression
+func compressions(t *testing.T, db *DB) (found map[streams.Compression]int) {
+	db.log.mtx.Lock()
+	sealed := append([]uint64(nil), db.log.sealed...)
+	db.log.mtx.Unlock()
+
+	found = map[streams.Compression]int{}
+	for _, sequence := range sealed {
+		data, err := ioutil.ReadFile(db.log.path(sequence))
+		assert.NoError(t, err)
+
+		for len(data) >= 4 {
+			size := int(binary.BigEndian.Uint32(data))
+			compression := streams.CompressionNone
+			if data[4]&opCompressed != 0 {
+				compression = streams.Compression(data[5])
+			}
+			found[compression]++
+			data = data[4+size:]
+		}
+	}
+	return found
+}
+
+func TestChangelogEntryCompression(t *testing.T) {
+	e := entry{op: opSet, time: time.Unix(0, 42), key: []byte("key"), value: []byte("value")}
+
+	for _, c := range []streams.Compression{streams.CompressionNone, streams.CompressionSnappy, streams.CompressionGzip} {
+		buf, err := encodeEntry(e, c)
+		assert.NoError(t, err)
+		assert.Equal(t, c != streams.CompressionNone, buf[4]&opCompressed != 0)
+
+		decoded, err := decodeEntry(buf[4:])
+		assert.NoError(t, err)
+		assert.Equal(t, e.op, decoded.op)
+		assert.Equal(t, e.time.UnixNano(), decoded.time.UnixNano())
+		assert.Equal(t, e.key, decoded.key)
+		assert.Equal(t, e.value, decoded.value)
+	}
+}
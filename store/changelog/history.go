@@ -0,0 +1,176 @@
+package changelog
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+// make sure we implement the needed interfaces
+var _ streams.ROStore = (*View)(nil)
+
+// Checkpoint marks the current state of the store with the given name
+func (d *DB) Checkpoint(name string) (err error) {
+	return d.log.append(entry{op: opCheckpoint, time: time.Now(), key: []byte(name)})
+}
+
+// AsOf opens a view of the store state as of the given time, rebuilt from the
+// changelog. Times before the last compaction fail with ErrHistoryUnavailable.
+func (d *DB) AsOf(at time.Time) (view streams.ROStore, err error) {
+	if at.UnixNano() < d.log.horizonTime() {
+		return nil, streams.ErrHistoryUnavailable
+	}
+
+	v := newView(d.Name())
+	err = d.log.replay(func(e entry) error {
+		if !e.time.After(at) {
+			v.apply(e)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return v.sort(), nil
+}
+
+// AsOfCheckpoint opens a view of the store state at the latest checkpoint with
+// the given name, rebuilt from the changelog. Checkpoints are not kept across
+// compactions and unknown checkpoints fail with ErrHistoryUnavailable.
+func (d *DB) AsOfCheckpoint(name string) (view streams.ROStore, err error) {
+	var position, latest int
+
+	// find the position of the latest checkpoint in the changelog
+	err = d.log.replay(func(e entry) error {
+		position++
+		if e.op == opCheckpoint && string(e.key) == name {
+			latest = position
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if latest == 0 {
+		return nil, streams.ErrHistoryUnavailable
+	}
+
+	position = 0
+	v := newView(d.Name())
+	err = d.log.replay(func(e entry) error {
+		if position++; position < latest {
+			v.apply(e)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return v.sort(), nil
+}
+
+// View is a read only view of the state of a changelogged store
+// at a past point in time
+type View struct {
+	name   string
+	keys   []string
+	values map[string][]byte
+}
+
+// newView creates an empty view for the named store
+func newView(name string) (v *View) {
+	return &View{name: name, values: make(map[string][]byte)}
+}
+
+// apply the changelog entry to the view
+func (v *View) apply(e entry) {
+	switch e.op {
+	case opSet:
+		v.values[string(e.key)] = e.value
+	case opDelete:
+		delete(v.values, string(e.key))
+	}
+}
+
+// sort the view keys for ranging
+func (v *View) sort() (view *View) {
+	v.keys = make([]string, 0, len(v.values))
+	for key := range v.values {
+		v.keys = append(v.keys, key)
+	}
+	sort.Strings(v.keys)
+	return v
+}
+
+// Name returns the store name.
+func (v *View) Name() (name string) {
+	return v.name
+}
+
+// Get value for the given key.
+func (v *View) Get(key []byte) (value []byte, err error) {
+	value, ok := v.values[string(key)]
+	if !ok {
+		return nil, streams.ErrKeyNotFound
+	}
+	return value, nil
+}
+
+// Range iterates the view within the given key range applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+// A nil from or to sets the iterator to the begining or end of the view.
+func (v *View) Range(from, to []byte, cb func(key, value []byte) error) (err error) {
+	idx := sort.SearchStrings(v.keys, string(from))
+
+	for ; idx < len(v.keys); idx++ {
+		key := v.keys[idx]
+		if to != nil && key >= string(to) {
+			return nil
+		}
+
+		if err = cb([]byte(key), v.values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RangePrefix iterates the view over a key prefix applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+func (v *View) RangePrefix(prefix []byte, cb func(key, value []byte) error) (err error) {
+	idx := sort.SearchStrings(v.keys, string(prefix))
+
+	for ; idx < len(v.keys); idx++ {
+		key := v.keys[idx]
+		if !bytes.HasPrefix([]byte(key), prefix) {
+			return nil
+		}
+
+		if err = cb([]byte(key), v.values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
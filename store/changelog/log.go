@@ -0,0 +1,466 @@
+package changelog
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+// changelog operations
+const (
+	opSet        byte = 1
+	opDelete     byte = 2
+	opCheckpoint byte = 3
+
+	// opCompressed flags entries whose time, key and value are compressed
+	opCompressed byte = 1 << 7
+)
+
+// horizonFile keeps the time since which the changelog has the full history
+const horizonFile = "horizon"
+
+var (
+	errInvalidEntry = streams.Errorf(streams.CodeSerialization, "invalid changelog entry")
+)
+
+// entry is a change recorded in the changelog
+type entry struct {
+	op    byte
+	time  time.Time
+	key   []byte
+	value []byte
+}
+
+// changelog is a log of store changes kept in numbered segment files.
+// Changes are appended to the active segment, and segments are sealed once
+// they reach the segment size. Only sealed segments are compacted, and the
+// history of changes is complete since the horizon of the last compaction.
+type changelog struct {
+	mtx         sync.Mutex
+	compacting  sync.Mutex
+	dir         string
+	segmentSize int64
+	retention   time.Duration
+	sync        bool
+	compression streams.Compression
+	sequence    uint64
+	sealed      []uint64
+	active      *os.File
+	activeSeq   uint64
+	activeSize  int64
+	horizon     int64
+}
+
+// openChangelog opens the changelog in dir. Existing segments are sealed
+// and new changes are appended to a new segment with the given compression.
+func openChangelog(dir string, segmentSize int64, retention time.Duration, sync bool,
+	compression streams.Compression) (c *changelog, err error) {
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+
+	c = &changelog{dir: dir, segmentSize: segmentSize, retention: retention, sync: sync, compression: compression}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.horizon, err = readHorizon(dir); err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		// Remove the leftovers of interrupted compactions
+		if strings.HasSuffix(file.Name(), ".tmp") {
+			if err = os.Remove(filepath.Join(dir, file.Name())); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if !strings.HasSuffix(file.Name(), ".log") {
+			continue
+		}
+
+		var sequence uint64
+		if _, err = fmt.Sscanf(strings.TrimSuffix(file.Name(), ".log"), "%d", &sequence); err != nil {
+			return nil, err
+		}
+
+		c.sealed = append(c.sealed, sequence)
+		if sequence >= c.sequence {
+			c.sequence = sequence + 1
+		}
+	}
+
+	sort.Slice(c.sealed, func(i, j int) bool { return c.sealed[i] < c.sealed[j] })
+	return c, nil
+}
+
+// path returns the path of the segment with the given sequence
+func (c *changelog) path(sequence uint64) (path string) {
+	return filepath.Join(c.dir, fmt.Sprintf("%020d.log", sequence))
+}
+
+// append the entry to the active segment, sealing it if full
+func (c *changelog) append(e entry) (err error) {
+	buf, err := encodeEntry(e, c.compression)
+	if err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.active == nil || c.activeSize >= c.segmentSize {
+		if err = c.seal(); err != nil {
+			return err
+		}
+
+		c.activeSeq = c.sequence
+		if c.active, err = os.OpenFile(c.path(c.activeSeq),
+			os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0640); err != nil {
+			return err
+		}
+		c.sequence++
+		c.activeSize = 0
+	}
+
+	if _, err = c.active.Write(buf); err != nil {
+		return err
+	}
+
+	if c.sync {
+		if err = c.active.Sync(); err != nil {
+			return err
+		}
+	}
+
+	c.activeSize += int64(len(buf))
+	return nil
+}
+
+// seal the active segment if any, making it available for compaction
+func (c *changelog) seal() (err error) {
+	if c.active == nil {
+		return nil
+	}
+
+	err = c.active.Close()
+	c.sealed = append(c.sealed, c.activeSeq)
+	c.active = nil
+	return err
+}
+
+// segments returns the sequences of the sealed segments and the active one
+func (c *changelog) segments() (sequences []uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	sequences = append(sequences, c.sealed...)
+	if c.active != nil {
+		sequences = append(sequences, c.activeSeq)
+	}
+	return sequences
+}
+
+// replay the changelog entries in order
+func (c *changelog) replay(cb func(e entry) error) (err error) {
+	c.compacting.Lock()
+	defer c.compacting.Unlock()
+
+	for _, sequence := range c.segments() {
+		if err = c.read(sequence, cb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read the entries of the segment with the given sequence.
+// A partially written entry at the end of the segment is discarded.
+func (c *changelog) read(sequence uint64, cb func(e entry) error) (err error) {
+	data, err := ioutil.ReadFile(c.path(sequence))
+	if err != nil {
+		return err
+	}
+
+	for len(data) >= 4 {
+		size := int(binary.BigEndian.Uint32(data))
+		if len(data)-4 < size {
+			break
+		}
+
+		e, err := decodeEntry(data[4 : 4+size])
+		if err != nil {
+			return err
+		}
+
+		if err = cb(e); err != nil {
+			return err
+		}
+		data = data[4+size:]
+	}
+	return nil
+}
+
+// compact the sealed segments into a single segment keeping the latest entry
+// per key, and dropping deletes older than the retention at the given time.
+// Entries are rewritten with the current compression of the changelog.
+// The compacted segment replaces the newest sealed segment before the older
+// ones are removed, so an interrupted compaction never loses changes.
+func (c *changelog) compact(now time.Time) (err error) {
+	c.compacting.Lock()
+	defer c.compacting.Unlock()
+
+	c.mtx.Lock()
+	sealed := append([]uint64(nil), c.sealed...)
+	c.mtx.Unlock()
+
+	if len(sealed) == 0 {
+		return nil
+	}
+
+	var horizon int64
+	latest := make(map[string]entry)
+	for _, sequence := range sealed {
+		if err = c.read(sequence, func(e entry) error {
+			if t := e.time.UnixNano(); t > horizon {
+				horizon = t
+			}
+
+			// Checkpoints are not kept across compactions
+			if e.op != opCheckpoint {
+				latest[string(e.key)] = e
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(latest))
+	for key := range latest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	last := sealed[len(sealed)-1]
+	tmp := c.path(last) + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		e := latest[key]
+		if e.op == opDelete && now.Sub(e.time) > c.retention {
+			continue
+		}
+
+		buf, err := encodeEntry(e, c.compression)
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		if _, err = file.Write(buf); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	// The history before the newest compacted change is lost
+	if horizon > c.horizonTime() {
+		if err = writeHorizon(c.dir, horizon); err != nil {
+			return err
+		}
+	}
+
+	if err = os.Rename(tmp, c.path(last)); err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	c.sealed = c.sealed[len(sealed)-1:]
+	if horizon > c.horizon {
+		c.horizon = horizon
+	}
+	c.mtx.Unlock()
+
+	for _, sequence := range sealed[:len(sealed)-1] {
+		if err = os.Remove(c.path(sequence)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// horizonTime returns the time in unix nanoseconds since which the
+// changelog has the full history of changes
+func (c *changelog) horizonTime() (horizon int64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.horizon
+}
+
+// readHorizon reads the changelog horizon from dir, 0 if never compacted
+func readHorizon(dir string) (horizon int64, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, horizonFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(data) != 8 {
+		return 0, errInvalidEntry
+	}
+	return int64(binary.BigEndian.Uint64(data)), nil
+}
+
+// writeHorizon writes the changelog horizon to dir
+func writeHorizon(dir string, horizon int64) (err error) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(horizon))
+
+	path := filepath.Join(dir, horizonFile)
+	if err = ioutil.WriteFile(path+".tmp", buf[:], 0640); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// size returns the total size in bytes of the changelog segments
+func (c *changelog) size() (size int64, err error) {
+	for _, sequence := range c.segments() {
+		info, err := os.Stat(c.path(sequence))
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+// close the active segment
+func (c *changelog) close() (err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.seal()
+}
+
+// remove the changelog and its segments
+func (c *changelog) remove() (err error) {
+	if err = c.close(); err != nil {
+		return err
+	}
+	return os.RemoveAll(c.dir)
+}
+
+// encodeEntry encodes the entry as its length followed by the operation,
+// time in unix nanoseconds, key length, key and value. Entries with a
+// compression have the operation flagged as compressed, followed by the
+// compression and the compressed time, key length, key and value.
+func encodeEntry(e entry, compression streams.Compression) (buf []byte, err error) {
+	body := make([]byte, 8, 8+binary.MaxVarintLen64+len(e.key)+len(e.value))
+	binary.BigEndian.PutUint64(body, uint64(e.time.UnixNano()))
+
+	var n [binary.MaxVarintLen64]byte
+	body = append(body, n[:binary.PutUvarint(n[:], uint64(len(e.key)))]...)
+	body = append(body, e.key...)
+	body = append(body, e.value...)
+
+	if compression == streams.CompressionNone {
+		buf = make([]byte, 5, 5+len(body))
+		buf[4] = e.op
+		buf = append(buf, body...)
+	} else {
+		buf = make([]byte, 6, 6+len(body))
+		buf[4] = e.op | opCompressed
+		buf[5] = byte(compression)
+		if buf, err = compression.Compress(buf, body); err != nil {
+			return nil, err
+		}
+	}
+
+	binary.BigEndian.PutUint32(buf, uint32(len(buf)-4))
+	return buf, nil
+}
+
+// decodeEntry decodes the entry without its length prefix
+func decodeEntry(data []byte) (e entry, err error) {
+	if len(data) < 1 {
+		return e, errInvalidEntry
+	}
+
+	e.op = data[0] &^ opCompressed
+	if data[0]&opCompressed != 0 {
+		if len(data) < 2 {
+			return e, errInvalidEntry
+		}
+
+		if data, err = streams.Compression(data[1]).Decompress(nil, data[2:]); err != nil {
+			return e, err
+		}
+	} else {
+		data = data[1:]
+	}
+
+	if len(data) < 8 {
+		return e, errInvalidEntry
+	}
+
+	e.time = time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+
+	size, n := binary.Uvarint(data[8:])
+	if n <= 0 || uint64(len(data)-8-n) < size {
+		return e, errInvalidEntry
+	}
+
+	data = data[8+n:]
+	e.key = data[:size]
+	e.value = data[size:]
+	return e, nil
+}
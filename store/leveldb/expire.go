@@ -0,0 +1,275 @@
+package leveldb
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/brunotm/streams"
+	ldb "github.com/syndtr/goleveldb/leveldb"
+	ldbopt "github.com/syndtr/goleveldb/leveldb/opt"
+	ldbutil "github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// DefaultSweepInterval is the default interval for deleting expired keys
+	DefaultSweepInterval = time.Minute
+)
+
+var (
+	// ttlPrefix is the reserved key prefix of the key expiration index
+	// and of the store format marker.
+	// It sorts after most user keys and is hidden from reads.
+	ttlPrefix = []byte("\xff\xff\xffttl")
+	// expiresPrefix indexes the keys by expiration time: prefix + expiration + key
+	expiresPrefix = append(append([]byte(nil), ttlPrefix...), 0)
+	// expiryPrefix maps the keys to their expiration time: prefix + key
+	expiryPrefix = append(append([]byte(nil), ttlPrefix...), 1)
+	// formatKey marks stores with values prefixed by their compression
+	formatKey = append(append([]byte(nil), ttlPrefix...), 2)
+
+	errReservedKey   = streams.Errorf(streams.CodeStore, "reserved key prefix")
+	errInvalidExpiry = streams.Errorf(streams.CodeStore, "invalid key expiration")
+)
+
+// getter reads a key from the database or a snapshot
+type getter func(key []byte, ro *ldbopt.ReadOptions) (value []byte, err error)
+
+// initExpiry reads the ttl config, detects stored key expirations
+// and starts the sweeper of expired keys
+func (d *DB) initExpiry() (err error) {
+	config := d.pc.Config().Get(d.pc.StreamName(), d.pc.NodeName())
+	d.ttl = config.Get("ttl").Duration(0)
+
+	if err = d.detectExpiry(); err != nil {
+		return err
+	}
+
+	d.done = make(chan struct{})
+	d.wg.Add(1)
+	go d.sweep(config.Get("sweep").Duration(DefaultSweepInterval))
+	return nil
+}
+
+// sweep deletes the expired keys at the given interval until the store is closed
+func (d *DB) sweep(interval time.Duration) {
+	defer d.wg.Done()
+
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case now := <-ticker.C:
+			if err := d.Expire(now, nil); err != nil {
+				d.pc.Error(err)
+			}
+		}
+	}
+}
+
+// detectExpiry detects stored key expirations
+func (d *DB) detectExpiry() (err error) {
+	iter := d.db.NewIterator(ldbutil.BytesPrefix(expiryPrefix), ropt)
+	if iter.Next() {
+		atomic.StoreInt32(&d.expiring, 1)
+	}
+	iter.Release()
+	return iter.Error()
+}
+
+// stopSweeper stops the sweeper of expired keys
+func (d *DB) stopSweeper() {
+	if d.done != nil {
+		close(d.done)
+		d.wg.Wait()
+		d.done = nil
+	}
+}
+
+// SetWithTTL sets the value for the given key expiring after the given ttl.
+// A ttl of 0 or less sets the value without expiration.
+func (d *DB) SetWithTTL(key, value []byte, ttl time.Duration) (err error) {
+	defer d.Observe(streams.StoreSet, time.Now(), &err)
+
+	return d.set(key, value, ttl)
+}
+
+// set the value for the given key with the given ttl, if greater than 0,
+// replacing any previous expiration of the key
+func (d *DB) set(key, value []byte, ttl time.Duration) (err error) {
+	if bytes.HasPrefix(key, ttlPrefix) {
+		return errReservedKey
+	}
+
+	if value, err = d.compress(value); err != nil {
+		return err
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	batch := new(ldb.Batch)
+	if err = d.clearExpiry(batch, key); err != nil {
+		return err
+	}
+	batch.Put(key, value)
+
+	if ttl > 0 {
+		expiry := time.Now().Add(ttl).UnixNano()
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(expiry))
+
+		batch.Put(expiresKey(expiry, key), nil)
+		batch.Put(expiryKey(key), buf[:])
+		atomic.StoreInt32(&d.expiring, 1)
+	}
+
+	return d.db.Write(batch, wopt)
+}
+
+// Expire applies the callback for the keys expired at the given time and
+// their last value, deleting them afterwards. Returning a error causes the
+// expiration to stop, keeping the current and remaining expired keys.
+// A nil callback deletes the expired keys. Keys updated after being found
+// expired are kept.
+func (d *DB) Expire(now time.Time, cb func(key, value []byte) error) (err error) {
+	if atomic.LoadInt32(&d.expiring) == 0 {
+		return nil
+	}
+
+	iter := d.db.NewIterator(&ldbutil.Range{
+		Start: expiresPrefix,
+		Limit: expiresKey(now.UnixNano()+1, nil)}, ropt)
+	defer iter.Release()
+
+	for iter.Next() {
+		index := iter.Key()
+		if len(index) < len(expiresPrefix)+8 {
+			return errInvalidExpiry
+		}
+
+		expiry := int64(binary.BigEndian.Uint64(index[len(expiresPrefix):]))
+		key := append([]byte(nil), index[len(expiresPrefix)+8:]...)
+
+		if cb != nil {
+			value, err := d.db.Get(key, ropt)
+			if err != nil && err != ldb.ErrNotFound {
+				return err
+			}
+
+			if err == nil {
+				if value, err = d.decompress(value); err != nil {
+					return err
+				}
+				if err = cb(key, value); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err = d.expire(key, expiry); err != nil {
+			return err
+		}
+	}
+
+	return iter.Error()
+}
+
+// expire deletes the key and its expiration if it still expires at the given time
+func (d *DB) expire(key []byte, expiry int64) (err error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	current, err := d.expiry(d.db.Get, key)
+	if err != nil || current != expiry {
+		return err
+	}
+
+	batch := new(ldb.Batch)
+	batch.Delete(key)
+	batch.Delete(expiresKey(expiry, key))
+	batch.Delete(expiryKey(key))
+	return d.db.Write(batch, wopt)
+}
+
+// expired returns if the key is expired at the given time
+func (d *DB) expired(get getter, key []byte, now time.Time) (expired bool, err error) {
+	expiry, err := d.expiry(get, key)
+	if err != nil {
+		return false, err
+	}
+	return expiry > 0 && expiry <= now.UnixNano(), nil
+}
+
+// expiry returns the expiration time of the key in unix nanoseconds,
+// or 0 if the key does not expire
+func (d *DB) expiry(get getter, key []byte) (expiry int64, err error) {
+	if atomic.LoadInt32(&d.expiring) == 0 {
+		return 0, nil
+	}
+
+	data, err := get(expiryKey(key), ropt)
+	if err == ldb.ErrNotFound {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(data) != 8 {
+		return 0, errInvalidExpiry
+	}
+	return int64(binary.BigEndian.Uint64(data)), nil
+}
+
+// clearExpiry adds the removal of the key expiration, if any, to the batch
+func (d *DB) clearExpiry(batch *ldb.Batch, key []byte) (err error) {
+	expiry, err := d.expiry(d.db.Get, key)
+	if err != nil || expiry == 0 {
+		return err
+	}
+
+	batch.Delete(expiresKey(expiry, key))
+	batch.Delete(expiryKey(key))
+	return nil
+}
+
+// expiresKey returns the expiration index key for the key and expiration time
+func expiresKey(expiry int64, key []byte) (index []byte) {
+	index = make([]byte, len(expiresPrefix)+8, len(expiresPrefix)+8+len(key))
+	copy(index, expiresPrefix)
+	binary.BigEndian.PutUint64(index[len(expiresPrefix):], uint64(expiry))
+	return append(index, key...)
+}
+
+// expiryKey returns the expiration time key for the key
+func expiryKey(key []byte) (index []byte) {
+	index = make([]byte, 0, len(expiryPrefix)+len(key))
+	index = append(index, expiryPrefix...)
+	return append(index, key...)
+}
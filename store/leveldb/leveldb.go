@@ -17,20 +17,36 @@ package leveldb
 */
 
 import (
-	"errors"
+	"bytes"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/brunotm/streams"
 	ldb "github.com/syndtr/goleveldb/leveldb"
+	ldbfilter "github.com/syndtr/goleveldb/leveldb/filter"
+	ldbiter "github.com/syndtr/goleveldb/leveldb/iterator"
 	ldbopt "github.com/syndtr/goleveldb/leveldb/opt"
 	ldbutil "github.com/syndtr/goleveldb/leveldb/util"
 )
 
+// upgradeBatch is the number of values written per batch when upgrading a store
+const upgradeBatch = 1024
+
 var (
-	dopt *ldbopt.Options
 	wopt *ldbopt.WriteOptions
 	ropt *ldbopt.ReadOptions
+
+	errInvalidCompression = streams.Errorf(streams.CodeConfig, "invalid compression")
+	errInvalidValue       = streams.Errorf(streams.CodeStore, "invalid stored value")
+	errPathInUse          = streams.Errorf(streams.CodeStore, "state path already in use")
+	errLegacyPath         = streams.Errorf(streams.CodeStore, "state found in the legacy layout")
+
+	// paths in use by the stores within the process
+	pathsMtx sync.Mutex
+	paths    = make(map[string]bool)
 )
 
 // make sure we implement the needed interfaces
@@ -38,13 +54,44 @@ var _ streams.Initializer = (*DB)(nil)
 var _ streams.Closer = (*DB)(nil)
 var _ streams.Remover = (*DB)(nil)
 var _ streams.Store = (*DB)(nil)
+var _ streams.TTLStore = (*DB)(nil)
+var _ streams.Instrumented = (*DB)(nil)
+var _ streams.Replicator = (*DB)(nil)
+var _ streams.ReadOnlyOpener = (*DB)(nil)
 var _ streams.StoreSupplier = Supplier
 
-// DB is a durable leveldb key value state store
+// DB is a durable leveldb key value state store.
+// The leveldb options are set through the store config subtree
+// <stream>.<node> with the following keys:
+//
+//	compression:      block compression, snappy (default) or none
+//	blockcache:       block cache capacity in bytes
+//	writebuffer:      write buffer size in bytes
+//	bloombits:        bits per key of the bloom filter, 0 disables the filter
+//	valuecompression: value compression for large payloads, none (default), snappy, gzip
+//	                  or a compression registered with streams.RegisterCompressor
+//	ttl:              default ttl of the keys set in the store, 0 (default) never expires
+//	sweep:            interval for deleting expired keys, 1m (default), 0 disables the sweeper
+//
+// Values are stored prefixed with their compression identifier, so changing the
+// value compression keeps the existing values readable. Zstd is available
+// by importing the github.com/brunotm/streams/zstd module.
+// Stores written before values were prefixed are upgraded by Init.
+//
+// Keys can expire with SetWithTTL. Expired keys are not visible to reads and are
+// deleted by the sweeper or by Expire.
 type DB struct {
-	pc   streams.ProcessorContext
-	db   *ldb.DB
-	path string
+	streams.StoreMetrics
+	pc          streams.ProcessorContext
+	db          *ldb.DB
+	path        string
+	compression streams.Compression
+	marked      bool
+	ttl         time.Duration
+	expiring    int32
+	mtx         sync.Mutex
+	done        chan struct{}
+	wg          sync.WaitGroup
 }
 
 // Supplier for leveldb store
@@ -52,29 +99,195 @@ func Supplier() (store streams.Store) {
 	return &DB{}
 }
 
-// Init store
+// Init store.
+// The store state is namespaced by stream name, node name and task id within
+// the <stream>.state.dir config directory, which defaults to the state
+// directory alongside the binary. Opening a path already in use by another
+// store within the process fails, as does finding state in the legacy
+// <stream>.state.path/<node> layout, which must be moved to the task 0
+// directory of the node before starting the stream.
 func (d *DB) Init(pc streams.ProcessorContext) (err error) {
 	d.pc = pc
 
-	statePath, err := filepath.Abs(filepath.Dir(os.Args[0]))
-	if err != nil {
+	if d.path, err = statePath(pc); err != nil {
 		return err
 	}
 
-	statePath = statePath + "/state"
+	if err = acquire(d.path); err != nil {
+		return err
+	}
 
-	d.path = d.pc.Config().
-		Get(d.pc.StreamName(), "state", "path").
-		String(statePath) + "/" + d.pc.NodeName()
+	options, err := d.options()
+	if err != nil {
+		release(d.path)
+		return err
+	}
 
-	d.db, err = ldb.OpenFile(d.path, dopt)
+	d.db, err = ldb.OpenFile(d.path, options)
 	if err != nil {
+		release(d.path)
+		return err
+	}
+
+	if err = d.upgrade(); err != nil {
+		d.db.Close()
+		release(d.path)
+		return err
+	}
+
+	if err = d.initExpiry(); err != nil {
+		d.db.Close()
+		release(d.path)
 		return err
 	}
 
 	return err
 }
 
+// statePath returns the state path of the store of the given context
+func statePath(pc streams.ProcessorContext) (path string, err error) {
+	path, err = filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		return "", err
+	}
+
+	legacy := pc.Config().
+		Get(pc.StreamName(), "state", "path").
+		String(filepath.Join(path, "state"))
+
+	path = pc.Config().
+		Get(pc.StreamName(), "state", "dir").
+		String(legacy)
+
+	path = filepath.Join(path, pc.StreamName(), pc.NodeName())
+
+	// Refuse to start with empty state over the state of the legacy layout
+	legacy = filepath.Join(legacy, pc.NodeName())
+	if _, err = os.Stat(filepath.Join(legacy, "CURRENT")); err == nil {
+		return "", streams.Errorf(streams.CodeStore, "%w: move %s to %s",
+			errLegacyPath, legacy, filepath.Join(path, "0"))
+	}
+
+	return filepath.Join(path, strconv.Itoa(pc.TaskID())), nil
+}
+
+// acquire the given path for a store, detecting stores sharing a path
+func acquire(path string) (err error) {
+	pathsMtx.Lock()
+	defer pathsMtx.Unlock()
+
+	if paths[path] {
+		return errPathInUse
+	}
+	paths[path] = true
+	return nil
+}
+
+// release the given path
+func release(path string) {
+	pathsMtx.Lock()
+	defer pathsMtx.Unlock()
+	delete(paths, path)
+}
+
+// options reads the leveldb options and value compression from the store config
+func (d *DB) options() (options *ldbopt.Options, err error) {
+	config := d.pc.Config().Get(d.pc.StreamName(), d.pc.NodeName())
+	options = &ldbopt.Options{}
+
+	switch config.Get("compression").String("snappy") {
+	case "snappy":
+		options.Compression = ldbopt.SnappyCompression
+	case "none":
+		options.Compression = ldbopt.NoCompression
+	default:
+		return nil, errInvalidCompression
+	}
+
+	options.BlockCacheCapacity = config.Get("blockcache").Int(0)
+	options.WriteBuffer = config.Get("writebuffer").Int(0)
+
+	if bits := config.Get("bloombits").Int(0); bits > 0 {
+		options.Filter = ldbfilter.NewBloomFilter(bits)
+	}
+
+	if d.compression, err = streams.CompressionOf(config.Get("valuecompression").String("none")); err != nil {
+		return nil, errInvalidCompression
+	}
+
+	return options, nil
+}
+
+// compress the value with the configured value compression,
+// prefixed with the compression identifier
+func (d *DB) compress(value []byte) (data []byte, err error) {
+	return d.compression.Compress([]byte{byte(d.compression)}, value)
+}
+
+// decompress the value with the compression it was stored with,
+// or with the configured value compression for unmarked stores
+func (d *DB) decompress(data []byte) (value []byte, err error) {
+	compression := d.compression
+	if d.marked {
+		if len(data) == 0 {
+			return nil, errInvalidValue
+		}
+		compression, data = streams.Compression(data[0]), data[1:]
+	}
+
+	if compression == streams.CompressionNone {
+		return data, nil
+	}
+	return compression.Decompress(nil, data)
+}
+
+// upgrade prefixes the values of a store written before values were prefixed
+// with their compression identifier, and marks the store as upgraded
+func (d *DB) upgrade() (err error) {
+	if d.marked, err = d.db.Has(formatKey, ropt); err != nil || d.marked {
+		return err
+	}
+
+	iter := d.db.NewIterator(nil, ropt)
+	defer iter.Release()
+
+	batch := new(ldb.Batch)
+	for iter.Next() {
+		if bytes.HasPrefix(iter.Key(), ttlPrefix) {
+			continue
+		}
+
+		value, err := d.decompress(iter.Value())
+		if err != nil {
+			return err
+		}
+
+		if value, err = d.compress(value); err != nil {
+			return err
+		}
+		batch.Put(iter.Key(), value)
+
+		if batch.Len() >= upgradeBatch {
+			if err = d.db.Write(batch, wopt); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+
+	if err = iter.Error(); err != nil {
+		return err
+	}
+
+	batch.Put(formatKey, []byte{1})
+	if err = d.db.Write(batch, wopt); err != nil {
+		return err
+	}
+
+	d.marked = true
+	return nil
+}
+
 // Remove closes the store and erases its contents
 func (d *DB) Remove() (err error) {
 	if err = d.Close(); err != nil {
@@ -85,8 +298,10 @@ func (d *DB) Remove() (err error) {
 
 // Close the store releasing its resources.
 func (d *DB) Close() (err error) {
+	d.stopSweeper()
 	err = d.db.Close()
 	d.db = nil
+	release(d.path)
 	return err
 }
 
@@ -100,13 +315,13 @@ func (d *DB) Name() (name string) {
 func (d *DB) Process(pc streams.ProcessorContext, record streams.Record) {
 
 	if !record.IsValid() || record.Key == nil {
-		pc.Error(errors.New("invalid record to store"), record)
+		pc.Error(streams.Errorf(streams.CodeSerialization, "invalid record to store"), record)
 		return
 	}
 
-	key, err := record.Key.Encode()
+	key, err := record.EncodeKey()
 	if err != nil {
-		pc.Error(errors.New("error serializing record key"), record)
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record key: %w", err), record)
 		return
 	}
 
@@ -118,9 +333,9 @@ func (d *DB) Process(pc streams.ProcessorContext, record streams.Record) {
 		return
 	}
 
-	value, err := record.Value.Encode()
+	value, err := record.EncodeValue()
 	if err != nil {
-		pc.Error(errors.New("error serializing record value"), record)
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record value: %w", err), record)
 		return
 	}
 
@@ -131,23 +346,55 @@ func (d *DB) Process(pc streams.ProcessorContext, record streams.Record) {
 
 // Get value for the given key.
 func (d *DB) Get(key []byte) (value []byte, err error) {
-	value, err = d.db.Get(key, ropt)
+	defer d.Observe(streams.StoreGet, time.Now(), &err)
+
+	return d.get(d.db.Get, key, time.Now())
+}
+
+// get the value for the given key unless expired at the given time
+func (d *DB) get(get getter, key []byte, now time.Time) (value []byte, err error) {
+	value, err = get(key, ropt)
 
 	if err == ldb.ErrNotFound {
 		return nil, streams.ErrKeyNotFound
 	}
 
-	return value, err
+	if err != nil {
+		return nil, err
+	}
+
+	expired, err := d.expired(get, key, now)
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, streams.ErrKeyNotFound
+	}
+
+	return d.decompress(value)
 }
 
-// Set value for the given key.
+// Set value for the given key, expiring after the store default ttl if set.
 func (d *DB) Set(key, value []byte) (err error) {
-	return d.db.Put(key, value, wopt)
+	defer d.Observe(streams.StoreSet, time.Now(), &err)
+
+	return d.set(key, value, d.ttl)
 }
 
 // Delete value for the given key.
 func (d *DB) Delete(key []byte) (err error) {
-	return d.db.Delete(key, wopt)
+	defer d.Observe(streams.StoreDelete, time.Now(), &err)
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	batch := new(ldb.Batch)
+	if err = d.clearExpiry(batch, key); err != nil {
+		return err
+	}
+	batch.Delete(key)
+
+	return d.db.Write(batch, wopt)
 }
 
 // Range iterates the store within the given key range applying the callback
@@ -155,30 +402,91 @@ func (d *DB) Delete(key []byte) (err error) {
 // A nil from or to sets the iterator to the begining or end of Store.
 // Setting both from and to as nil iterates the whole store
 func (d *DB) Range(from, to []byte, cb func(key, value []byte) error) (err error) {
-	rng := &ldbutil.Range{Start: from, Limit: to}
-	iter := d.db.NewIterator(rng, ropt)
-	defer iter.Release()
-
-	for iter.Next() {
-		if err = cb(iter.Key(), iter.Value()); err != nil {
-			return err
-		}
-	}
+	defer d.Observe(streams.StoreRange, time.Now(), &err)
 
-	return iter.Error()
+	return d.iterate(d.db.NewIterator(&ldbutil.Range{Start: from, Limit: to}, ropt), d.db.Get, cb)
 }
 
 // RangePrefix iterates the store over a key prefix applying the callback
 // for the key value pairs. Returning a error causes the iteration to stop.
 func (d *DB) RangePrefix(prefix []byte, cb func(key, value []byte) error) (err error) {
-	iter := d.db.NewIterator(ldbutil.BytesPrefix(prefix), nil)
+	defer d.Observe(streams.StoreRange, time.Now(), &err)
+
+	return d.iterate(d.db.NewIterator(ldbutil.BytesPrefix(prefix), nil), d.db.Get, cb)
+}
+
+// iterate applies the callback for the decompressed key value pairs of the iterator,
+// skipping the expired keys and the key expiration index
+func (d *DB) iterate(iter ldbiter.Iterator, get getter, cb func(key, value []byte) error) (err error) {
 	defer iter.Release()
+	now := time.Now()
 
 	for iter.Next() {
-		if err = cb(iter.Key(), iter.Value()); err != nil {
+		if bytes.HasPrefix(iter.Key(), ttlPrefix) {
+			continue
+		}
+
+		expired, err := d.expired(get, iter.Key(), now)
+		if err != nil {
+			return err
+		}
+		if expired {
+			continue
+		}
+
+		value, err := d.decompress(iter.Value())
+		if err != nil {
+			return err
+		}
+
+		if err = cb(iter.Key(), value); err != nil {
 			return err
 		}
 	}
 
 	return iter.Error()
 }
+
+// Replica returns a read only view of the store at the current point in time,
+// backed by a leveldb snapshot. The replica must be closed after use.
+func (d *DB) Replica() (replica streams.ROStore, err error) {
+	snapshot, err := d.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &Replica{db: d, snapshot: snapshot}, nil
+}
+
+// Replica is a read only point in time view of a leveldb store
+type Replica struct {
+	db       *DB
+	snapshot *ldb.Snapshot
+}
+
+// Name returns the replicated store name.
+func (r *Replica) Name() (name string) {
+	return r.db.Name()
+}
+
+// Get value for the given key.
+func (r *Replica) Get(key []byte) (value []byte, err error) {
+	return r.db.get(r.snapshot.Get, key, time.Now())
+}
+
+// Range iterates the replica within the given key range applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+func (r *Replica) Range(from, to []byte, cb func(key, value []byte) error) (err error) {
+	return r.db.iterate(r.snapshot.NewIterator(&ldbutil.Range{Start: from, Limit: to}, ropt), r.snapshot.Get, cb)
+}
+
+// RangePrefix iterates the replica over a key prefix applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+func (r *Replica) RangePrefix(prefix []byte, cb func(key, value []byte) error) (err error) {
+	return r.db.iterate(r.snapshot.NewIterator(ldbutil.BytesPrefix(prefix), nil), r.snapshot.Get, cb)
+}
+
+// Close the replica releasing its snapshot.
+func (r *Replica) Close() (err error) {
+	r.snapshot.Release()
+	return nil
+}
@@ -17,12 +17,261 @@ package leveldb
 */
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/brunotm/streams"
 	"github.com/brunotm/streams/mock"
 	"github.com/brunotm/streams/store"
+	"github.com/stretchr/testify/assert"
+	ldb "github.com/syndtr/goleveldb/leveldb"
 )
 
 func TestLevelDBStore(t *testing.T) {
 	store.TestStore(t, Supplier, &mock.Context{})
 }
+
+func TestLevelDBStoreCompression(t *testing.T) {
+	for _, compression := range []string{"snappy", "gzip"} {
+		config := streams.NewConfig(nil)
+		config.Set(compression, "stream", compression, "valuecompression")
+		config.Set(10, "stream", compression, "bloombits")
+
+		pc := &mock.Context{Data: mock.ContextData{
+			StreamName: "stream",
+			NodeName:   compression,
+			Config:     config,
+		}}
+		store.TestStore(t, Supplier, pc)
+	}
+}
+
+func TestLevelDBStoreChangeCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := streams.NewConfig(nil)
+	config.Set(dir, "stream", "state", "dir")
+
+	pc := &mock.Context{Data: mock.ContextData{
+		StreamName: "stream",
+		NodeName:   "store",
+		Config:     config,
+	}}
+
+	// values stay readable when the value compression changes
+	for _, compression := range []string{"gzip", "snappy", "none"} {
+		config.Set(compression, "stream", "store", "valuecompression")
+
+		db := Supplier().(*DB)
+		assert.NoError(t, db.Init(pc))
+		assert.NoError(t, db.Set([]byte(compression), []byte(compression)))
+
+		for _, written := range []string{"gzip", "snappy", "none"} {
+			value, err := db.Get([]byte(written))
+			assert.NoError(t, err)
+			assert.Equal(t, []byte(written), value)
+
+			if written == compression {
+				break
+			}
+		}
+		assert.NoError(t, db.Close())
+	}
+}
+
+func TestLevelDBStoreUpgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := streams.NewConfig(nil)
+	config.Set(dir, "stream", "state", "dir")
+	config.Set("gzip", "stream", "store", "valuecompression")
+
+	pc := &mock.Context{Data: mock.ContextData{
+		StreamName: "stream",
+		NodeName:   "store",
+		Config:     config,
+	}}
+
+	// write values without the compression prefix, as older stores did
+	path, err := statePath(pc)
+	assert.NoError(t, err)
+	legacy, err := ldb.OpenFile(path, nil)
+	assert.NoError(t, err)
+	for _, key := range []string{"a", "b"} {
+		value, err := streams.CompressionGzip.Compress(nil, []byte("value-"+key))
+		assert.NoError(t, err)
+		assert.NoError(t, legacy.Put([]byte(key), value, nil))
+	}
+	assert.NoError(t, legacy.Close())
+
+	db := Supplier().(*DB)
+	assert.NoError(t, db.Init(pc))
+	assert.True(t, db.marked)
+	assert.NoError(t, db.Close())
+
+	// upgraded values are readable with another compression
+	config.Set("none", "stream", "store", "valuecompression")
+	db = Supplier().(*DB)
+	assert.NoError(t, db.Init(pc))
+	defer db.Remove()
+
+	for _, key := range []string{"a", "b"} {
+		value, err := db.Get([]byte(key))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value-"+key), value)
+	}
+}
+
+func TestLevelDBStorePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := streams.NewConfig(nil)
+	config.Set(dir, "stream", "state", "dir")
+
+	context := func(task int) *mock.Context {
+		return &mock.Context{Data: mock.ContextData{
+			StreamName: "stream",
+			NodeName:   "store",
+			TaskID:     task,
+			Config:     config,
+		}}
+	}
+
+	first := Supplier().(*DB)
+	assert.NoError(t, first.Init(context(0)))
+	assert.Equal(t, filepath.Join(dir, "stream", "store", "0"), first.path)
+
+	// stores of the same stream, node and task never share a path
+	assert.Equal(t, errPathInUse, Supplier().(*DB).Init(context(0)))
+
+	second := Supplier().(*DB)
+	assert.NoError(t, second.Init(context(1)))
+	assert.Equal(t, filepath.Join(dir, "stream", "store", "1"), second.path)
+
+	assert.NoError(t, first.Close())
+	assert.NoError(t, second.Close())
+
+	// closed stores release their path
+	first = Supplier().(*DB)
+	assert.NoError(t, first.Init(context(0)))
+	assert.NoError(t, first.Remove())
+
+	// state in the legacy layout is never silently replaced
+	legacy := filepath.Join(dir, "legacy", "store")
+	assert.NoError(t, os.MkdirAll(legacy, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(legacy, "CURRENT"), []byte("MANIFEST-000001\n"), 0644))
+	config.Set(filepath.Join(dir, "legacy"), "stream", "state", "path")
+
+	err = Supplier().(*DB).Init(context(1))
+	assert.True(t, errors.Is(err, errLegacyPath))
+	assert.True(t, strings.Contains(err.Error(), filepath.Join(dir, "stream", "store", "0")))
+}
+
+func TestLevelDBStoreReplica(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := streams.NewConfig(nil)
+	config.Set(dir, "stream", "state", "dir")
+	config.Set("snappy", "stream", "replica", "valuecompression")
+
+	db := Supplier().(*DB)
+	assert.NoError(t, db.Init(&mock.Context{Data: mock.ContextData{
+		StreamName: "stream",
+		NodeName:   "replica",
+		Config:     config,
+	}}))
+	defer db.Remove()
+
+	assert.NoError(t, db.Set([]byte("a"), []byte("1")))
+	replica, err := db.Replica()
+	assert.NoError(t, err)
+
+	// writes after the replica is opened are not visible
+	assert.NoError(t, db.Set([]byte("a"), []byte("2")))
+	assert.NoError(t, db.Set([]byte("b"), []byte("2")))
+
+	value, err := replica.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+
+	_, err = replica.Get([]byte("b"))
+	assert.Equal(t, streams.ErrKeyNotFound, err)
+
+	var keys []string
+	assert.NoError(t, replica.Range(nil, nil, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	}))
+	assert.Equal(t, []string{"a"}, keys)
+	assert.Equal(t, "replica", replica.Name())
+	assert.NoError(t, replica.(streams.Closer).Close())
+}
+
+func TestLevelDBStoreTTL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := streams.NewConfig(nil)
+	config.Set(dir, "stream", "state", "dir")
+	config.Set(0, "stream", "ttl", "sweep")
+	pc := &mock.Context{Data: mock.ContextData{
+		StreamName: "stream",
+		NodeName:   "ttl",
+		Config:     config,
+	}}
+
+	db := Supplier().(*DB)
+	assert.NoError(t, db.Init(pc))
+
+	assert.NoError(t, db.SetWithTTL([]byte("a"), []byte("1"), time.Millisecond))
+	assert.NoError(t, db.SetWithTTL([]byte("b"), []byte("2"), time.Hour))
+	assert.NoError(t, db.SetWithTTL([]byte("c"), []byte("3"), time.Millisecond))
+	assert.NoError(t, db.Set([]byte("c"), []byte("3")))
+	assert.Equal(t, errReservedKey, db.Set(expiryKey([]byte("a")), nil))
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = db.Get([]byte("a"))
+	assert.Equal(t, streams.ErrKeyNotFound, err)
+
+	var keys []string
+	assert.NoError(t, db.Range(nil, nil, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	}))
+	assert.Equal(t, []string{"b", "c"}, keys)
+	assert.NoError(t, db.Close())
+
+	// key expirations are kept across restarts
+	db = Supplier().(*DB)
+	assert.NoError(t, db.Init(pc))
+
+	expired := make(map[string]string)
+	assert.NoError(t, db.Expire(time.Now().Add(time.Minute), func(key, value []byte) error {
+		expired[string(key)] = string(value)
+		return nil
+	}))
+	assert.Equal(t, map[string]string{"a": "1"}, expired)
+
+	assert.NoError(t, db.Expire(time.Now().Add(2*time.Hour), nil))
+	keys = nil
+	assert.NoError(t, db.Range(nil, nil, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	}))
+	assert.Equal(t, []string{"c"}, keys)
+	assert.NoError(t, db.Remove())
+}
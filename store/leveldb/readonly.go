@@ -0,0 +1,193 @@
+package leveldb
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brunotm/streams"
+	ldb "github.com/syndtr/goleveldb/leveldb"
+)
+
+// copyAttempts is the number of attempts to copy a consistent state,
+// as compactions of the live store can remove the files being copied
+const copyAttempts = 5
+
+var errStateChanged = streams.Errorf(streams.CodeStore, "state changed while copying")
+
+// OpenReadOnly opens a read only view of the state of the store of the given
+// context, as opened by Init, while it is in use by another process.
+// As leveldb locks its state to a single process, the view is opened from a
+// point in time copy of the state within the <stream>.state.readonly config
+// directory, or the system temporary directory, with the immutable table files
+// hard linked when possible. The view must be closed after use, removing the copy.
+func (d *DB) OpenReadOnly(pc streams.ProcessorContext) (view streams.ROStore, err error) {
+	src, err := statePath(pc)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = os.Stat(filepath.Join(src, "CURRENT")); err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir(pc.Config().Get(pc.StreamName(), "state", "readonly").String(""),
+		pc.StreamName()+"-"+pc.NodeName()+"-")
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < copyAttempts; attempt++ {
+		if err = copyState(src, dir); err != errStateChanged {
+			break
+		}
+	}
+
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	db := &DB{pc: pc, path: dir}
+	options, err := db.options()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	if db.db, err = ldb.OpenFile(dir, options); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	if db.marked, err = db.db.Has(formatKey, nil); err != nil {
+		db.db.Close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	if err = db.detectExpiry(); err != nil {
+		db.db.Close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return &View{db: db}, nil
+}
+
+// copyState copies the leveldb files of the src directory to the dst directory,
+// failing with errStateChanged if the manifest named by the copied CURRENT
+// file was not copied.
+func copyState(src, dst string) (err error) {
+	current, err := ioutil.ReadFile(filepath.Join(src, "CURRENT"))
+	if err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || name == "LOCK" || name == "LOG" || name == "LOG.old" || name == "CURRENT" {
+			continue
+		}
+
+		switch err = copyFile(filepath.Join(src, name), filepath.Join(dst, name)); {
+		case os.IsNotExist(err):
+			// removed by a compaction
+		case err != nil:
+			return err
+		}
+	}
+
+	if _, err = os.Stat(filepath.Join(dst, strings.TrimSpace(string(current)))); err != nil {
+		return errStateChanged
+	}
+
+	return ioutil.WriteFile(filepath.Join(dst, "CURRENT"), current, 0644)
+}
+
+// copyFile hard links immutable table files, copying them if linking fails,
+// and copies the other files
+func copyFile(src, dst string) (err error) {
+	os.Remove(dst)
+	if ext := filepath.Ext(src); ext == ".ldb" || ext == ".sst" {
+		if err = os.Link(src, dst); err == nil {
+			return nil
+		}
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// View is a read only view of the state of a leveldb store in use by another process
+type View struct {
+	db *DB
+}
+
+// Name returns the viewed store name.
+func (v *View) Name() (name string) {
+	return v.db.Name()
+}
+
+// Get value for the given key.
+func (v *View) Get(key []byte) (value []byte, err error) {
+	return v.db.Get(key)
+}
+
+// Range iterates the view within the given key range applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+func (v *View) Range(from, to []byte, cb func(key, value []byte) error) (err error) {
+	return v.db.Range(from, to, cb)
+}
+
+// RangePrefix iterates the view over a key prefix applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+func (v *View) RangePrefix(prefix []byte, cb func(key, value []byte) error) (err error) {
+	return v.db.RangePrefix(prefix, cb)
+}
+
+// Close the view removing its state copy.
+func (v *View) Close() (err error) {
+	err = v.db.db.Close()
+	if rerr := os.RemoveAll(v.db.path); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package leveldb
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevelDBStoreReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := streams.NewConfig(nil)
+	config.Set(filepath.Join(dir, "state"), "stream", "state", "dir")
+	config.Set(dir, "stream", "state", "readonly")
+	config.Set("gzip", "stream", "counts", "valuecompression")
+
+	live := Supplier().(*DB)
+	assert.NoError(t, live.Init(&mock.Context{Data: mock.ContextData{
+		StreamName: "stream",
+		NodeName:   "counts",
+		Config:     config,
+	}}))
+	defer live.Remove()
+	assert.NoError(t, live.Set([]byte("a"), []byte("1")))
+
+	b := streams.NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() streams.Source { return nil }))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc streams.ProcessorContext, record streams.Record) {}, "source"))
+	assert.NoError(t, b.AddStore("counts", Supplier))
+
+	stream, err := streams.OpenReadOnly(b)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"counts"}, stream.Stores())
+
+	view, err := stream.Store("counts")
+	assert.NoError(t, err)
+
+	// writes of the live store after the view is opened are not visible
+	assert.NoError(t, live.Set([]byte("a"), []byte("2")))
+	value, err := view.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+
+	_, err = stream.Store("totals")
+	assert.Equal(t, streams.ErrStoreNotFound, err)
+
+	// closed views remove their state copy
+	assert.NoError(t, stream.Close())
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
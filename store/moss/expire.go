@@ -0,0 +1,140 @@
+package moss
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sort"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/golang/snappy"
+)
+
+const (
+	// DefaultSweepInterval is the default interval for deleting expired keys
+	DefaultSweepInterval = time.Minute
+)
+
+// sweep deletes the expired keys at the given interval until the store is closed
+func (d *DB) sweep(interval time.Duration) {
+	defer d.wg.Done()
+
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case now := <-ticker.C:
+			if err := d.Expire(now, nil); err != nil {
+				d.pc.Error(err)
+			}
+		}
+	}
+}
+
+// stopSweeper stops the sweeper of expired keys
+func (d *DB) stopSweeper() {
+	if d.done != nil {
+		close(d.done)
+		d.wg.Wait()
+		d.done = nil
+	}
+}
+
+// SetWithTTL sets the value for the given key expiring after the given ttl.
+// A ttl of 0 or less sets the value without expiration.
+func (d *DB) SetWithTTL(key, value []byte, ttl time.Duration) (err error) {
+	defer d.Observe(streams.StoreSet, time.Now(), &err)
+
+	return d.set(key, value, ttl)
+}
+
+// Expire applies the callback for the keys expired at the given time and
+// their last value, deleting them afterwards. Returning a error causes the
+// expiration to stop, keeping the current and remaining expired keys.
+// A nil callback deletes the expired keys. Keys updated after being found
+// expired are kept.
+func (d *DB) Expire(now time.Time, cb func(key, value []byte) error) (err error) {
+	type expired struct {
+		key    string
+		expiry time.Time
+	}
+
+	d.mtx.RLock()
+	var keys []expired
+	for key, expiry := range d.expiries {
+		if !expiry.After(now) {
+			keys = append(keys, expired{key: key, expiry: expiry})
+		}
+	}
+	d.mtx.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].expiry.Before(keys[j].expiry) })
+
+	for _, e := range keys {
+		if cb != nil {
+			value, err := d.db.Get([]byte(e.key), ropts)
+			if err != nil {
+				return err
+			}
+
+			if value != nil {
+				if d.compress {
+					if value, err = snappy.Decode(nil, value); err != nil {
+						return err
+					}
+				}
+
+				if err = cb([]byte(e.key), value); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err = d.expire([]byte(e.key), e.expiry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expire deletes the key if it still expires at the given time
+func (d *DB) expire(key []byte, expiry time.Time) (err error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if current, ok := d.expiries[string(key)]; !ok || !current.Equal(expiry) {
+		return nil
+	}
+	return d.delete(key)
+}
+
+// expired returns if the key is expired at the given time
+func (d *DB) expired(key []byte, now time.Time) (expired bool) {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	expiry, ok := d.expiries[string(key)]
+	return ok && !expiry.After(now)
+}
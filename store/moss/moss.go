@@ -18,7 +18,8 @@ package moss
 
 import (
 	"bytes"
-	"errors"
+	"sync"
+	"time"
 
 	"github.com/brunotm/streams"
 	"github.com/couchbase/moss"
@@ -36,13 +37,30 @@ var _ streams.Initializer = (*DB)(nil)
 var _ streams.Closer = (*DB)(nil)
 var _ streams.Remover = (*DB)(nil)
 var _ streams.Store = (*DB)(nil)
+var _ streams.TTLStore = (*DB)(nil)
+var _ streams.Instrumented = (*DB)(nil)
 var _ streams.StoreSupplier = Supplier
 
-// DB is a in-memory key value MOSS state store
+// DB is a in-memory key value MOSS state store.
+// The store is configured within the <stream>.<node> config subtree
+// with the following keys:
+//
+//	compress: snappy compression of values, true (default)
+//	ttl:      default ttl of the keys set in the store, 0 (default) never expires
+//	sweep:    interval for deleting expired keys, 1m (default), 0 disables the sweeper
+//
+// Keys can expire with SetWithTTL. Expired keys are not visible to reads and are
+// deleted by the sweeper or by Expire.
 type DB struct {
+	streams.StoreMetrics
 	pc       streams.ProcessorContext
 	db       moss.Collection
 	compress bool
+	ttl      time.Duration
+	mtx      sync.RWMutex
+	expiries map[string]time.Time
+	done     chan struct{}
+	wg       sync.WaitGroup
 }
 
 // Supplier for moss store
@@ -58,11 +76,19 @@ func (d *DB) Init(pc streams.ProcessorContext) (err error) {
 		return err
 	}
 
-	d.compress = d.pc.Config().
-		Get(d.pc.StreamName(), d.pc.NodeName(), "compress").
-		Bool(true)
+	config := d.pc.Config().Get(d.pc.StreamName(), d.pc.NodeName())
+	d.compress = config.Get("compress").Bool(true)
+	d.ttl = config.Get("ttl").Duration(0)
+	d.expiries = make(map[string]time.Time)
 
-	return d.db.Start()
+	if err = d.db.Start(); err != nil {
+		return err
+	}
+
+	d.done = make(chan struct{})
+	d.wg.Add(1)
+	go d.sweep(config.Get("sweep").Duration(DefaultSweepInterval))
+	return nil
 }
 
 // Remove closes the store and erases its contents
@@ -72,6 +98,7 @@ func (d *DB) Remove() (err error) {
 
 // Close the store releasing its resources.
 func (d *DB) Close() (err error) {
+	d.stopSweeper()
 	err = d.db.Close()
 	d.db = nil
 	return err
@@ -87,13 +114,13 @@ func (d *DB) Name() (name string) {
 func (d *DB) Process(pc streams.ProcessorContext, record streams.Record) {
 
 	if !record.IsValid() || record.Key == nil {
-		pc.Error(errors.New("invalid record to store"), record)
+		pc.Error(streams.Errorf(streams.CodeSerialization, "invalid record to store"), record)
 		return
 	}
 
-	key, err := record.Key.Encode()
+	key, err := record.EncodeKey()
 	if err != nil {
-		pc.Error(errors.New("error serializing record key"), record)
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record key: %w", err), record)
 		return
 	}
 
@@ -105,9 +132,9 @@ func (d *DB) Process(pc streams.ProcessorContext, record streams.Record) {
 		return
 	}
 
-	value, err := record.Value.Encode()
+	value, err := record.EncodeValue()
 	if err != nil {
-		pc.Error(errors.New("error serializing record value"), record)
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record value: %w", err), record)
 		return
 	}
 
@@ -118,6 +145,12 @@ func (d *DB) Process(pc streams.ProcessorContext, record streams.Record) {
 
 // Get value for the given key.
 func (d *DB) Get(key []byte) (value []byte, err error) {
+	defer d.Observe(streams.StoreGet, time.Now(), &err)
+
+	if d.expired(key, time.Now()) {
+		return nil, streams.ErrKeyNotFound
+	}
+
 	value, err = d.db.Get(key, ropts)
 
 	if value == nil && err == nil {
@@ -131,8 +164,18 @@ func (d *DB) Get(key []byte) (value []byte, err error) {
 	return value, err
 }
 
-// Set value for the given key.
+// Set value for the given key, expiring after the store default ttl if set.
 func (d *DB) Set(key, value []byte) (err error) {
+	defer d.Observe(streams.StoreSet, time.Now(), &err)
+
+	return d.set(key, value, d.ttl)
+}
+
+// set the value for the given key with the given ttl, if greater than 0,
+// replacing any previous expiration of the key
+func (d *DB) set(key, value []byte, ttl time.Duration) (err error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
 
 	batch, err := d.db.NewBatch(1, len(key)+len(value))
 	if err != nil {
@@ -149,12 +192,30 @@ func (d *DB) Set(key, value []byte) (err error) {
 		return err
 	}
 
-	return d.db.ExecuteBatch(batch, wopts)
+	if err = d.db.ExecuteBatch(batch, wopts); err != nil {
+		return err
+	}
+
+	if ttl > 0 {
+		d.expiries[string(key)] = time.Now().Add(ttl)
+	} else {
+		delete(d.expiries, string(key))
+	}
+	return nil
 }
 
 // Delete value for the given key.
 func (d *DB) Delete(key []byte) (err error) {
+	defer d.Observe(streams.StoreDelete, time.Now(), &err)
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return d.delete(key)
+}
 
+// delete the key and its expiration
+func (d *DB) delete(key []byte) (err error) {
 	batch, err := d.db.NewBatch(1, 0)
 	if err != nil {
 		return err
@@ -167,7 +228,12 @@ func (d *DB) Delete(key []byte) (err error) {
 		return err
 	}
 
-	return d.db.ExecuteBatch(batch, wopts)
+	if err = d.db.ExecuteBatch(batch, wopts); err != nil {
+		return err
+	}
+
+	delete(d.expiries, string(key))
+	return nil
 }
 
 // Range iterates the store within the given key range applying the callback
@@ -175,6 +241,7 @@ func (d *DB) Delete(key []byte) (err error) {
 // A nil from or to sets the iterator to the begining or end of Store.
 // Setting both from and to as nil iterates the whole store
 func (d *DB) Range(from, to []byte, cb func(key, value []byte) error) (err error) {
+	defer d.Observe(streams.StoreRange, time.Now(), &err)
 
 	ss, err := d.db.Snapshot()
 	if err != nil {
@@ -187,6 +254,7 @@ func (d *DB) Range(from, to []byte, cb func(key, value []byte) error) (err error
 	}
 	defer iter.Close()
 
+	now := time.Now()
 	for {
 		key, value, err := iter.Current()
 		if err != nil {
@@ -196,6 +264,11 @@ func (d *DB) Range(from, to []byte, cb func(key, value []byte) error) (err error
 			return err
 		}
 
+		if d.expired(key, now) {
+			iter.Next()
+			continue
+		}
+
 		if d.compress {
 			value, err = snappy.Decode(nil, value)
 			if err != nil {
@@ -18,11 +18,50 @@ package moss
 
 import (
 	"testing"
+	"time"
 
+	"github.com/brunotm/streams"
 	"github.com/brunotm/streams/mock"
 	"github.com/brunotm/streams/store"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestMossStore(t *testing.T) {
 	store.TestStore(t, Supplier, &mock.Context{})
 }
+
+func TestMossStoreTTL(t *testing.T) {
+	db := Supplier().(*DB)
+	assert.NoError(t, db.Init(&mock.Context{}))
+
+	assert.NoError(t, db.SetWithTTL([]byte("a"), []byte("1"), time.Millisecond))
+	assert.NoError(t, db.SetWithTTL([]byte("b"), []byte("2"), time.Hour))
+	assert.NoError(t, db.SetWithTTL([]byte("c"), []byte("3"), time.Millisecond))
+	assert.NoError(t, db.Set([]byte("c"), []byte("3")))
+	time.Sleep(5 * time.Millisecond)
+
+	_, err := db.Get([]byte("a"))
+	assert.Equal(t, streams.ErrKeyNotFound, err)
+
+	var keys []string
+	assert.NoError(t, db.Range(nil, nil, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	}))
+	assert.Equal(t, []string{"b", "c"}, keys)
+
+	expired := make(map[string]string)
+	assert.NoError(t, db.Expire(time.Now().Add(2*time.Hour), func(key, value []byte) error {
+		expired[string(key)] = string(value)
+		return nil
+	}))
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, expired)
+
+	keys = nil
+	assert.NoError(t, db.Range(nil, nil, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	}))
+	assert.Equal(t, []string{"c"}, keys)
+	assert.NoError(t, db.Close())
+}
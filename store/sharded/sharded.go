@@ -0,0 +1,294 @@
+package sharded
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/dgryski/go-jump"
+	"github.com/dgryski/go-wyhash"
+)
+
+const (
+	// DefaultShards is the default number of shards
+	DefaultShards = 32
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*DB)(nil)
+var _ streams.Closer = (*DB)(nil)
+var _ streams.Remover = (*DB)(nil)
+var _ streams.Store = (*DB)(nil)
+var _ streams.Instrumented = (*DB)(nil)
+var _ streams.StoreSupplier = Supplier
+
+// DB is a hash partitioned in-memory key value state store.
+// Keys are distributed among independently locked shards, removing the
+// contention of a single lock for high cardinality updates.
+// Range and RangePrefix take a consistent snapshot of all shards, locked
+// together, and merge the sorted shard snapshots in order to keep the
+// byte-wise lexicographical iteration order.
+type DB struct {
+	streams.StoreMetrics
+	pc     streams.ProcessorContext
+	shards []*shard
+}
+
+type shard struct {
+	sync.RWMutex
+	data map[string][]byte
+}
+
+// Supplier for sharded store
+func Supplier() (store streams.Store) {
+	return &DB{}
+}
+
+// Init store
+func (d *DB) Init(pc streams.ProcessorContext) (err error) {
+	d.pc = pc
+
+	count := d.pc.Config().
+		Get(d.pc.StreamName(), d.pc.NodeName(), "shards").
+		Int(DefaultShards)
+
+	if count < 1 {
+		count = 1
+	}
+
+	d.shards = make([]*shard, count)
+	for x := 0; x < count; x++ {
+		d.shards[x] = &shard{data: make(map[string][]byte)}
+	}
+
+	return nil
+}
+
+// Remove closes the store and erases its contents
+func (d *DB) Remove() (err error) {
+	return d.Close()
+}
+
+// Close the store releasing its resources.
+func (d *DB) Close() (err error) {
+	d.shards = nil
+	return nil
+}
+
+// Name returns this store name.
+func (d *DB) Name() (name string) {
+	return d.pc.NodeName()
+}
+
+// Process store or deletes any forwarded record to the store.
+// Records with empty values deletes the given key from the store.
+func (d *DB) Process(pc streams.ProcessorContext, record streams.Record) {
+
+	if !record.IsValid() || record.Key == nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "invalid record to store"), record)
+		return
+	}
+
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record key: %w", err), record)
+		return
+	}
+
+	// Records with empty values deletes the given key from the store.
+	if record.Value == nil {
+		if err = d.Delete(key); err != nil {
+			pc.Error(err, record)
+		}
+		return
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record value: %w", err), record)
+		return
+	}
+
+	if err = d.Set(key, value); err != nil {
+		pc.Error(err, record)
+	}
+}
+
+// Get value for the given key.
+func (d *DB) Get(key []byte) (value []byte, err error) {
+	defer d.Observe(streams.StoreGet, time.Now(), &err)
+
+	s := d.shard(key)
+	s.RLock()
+	defer s.RUnlock()
+
+	value, exists := s.data[string(key)]
+	if !exists {
+		return nil, streams.ErrKeyNotFound
+	}
+
+	return value, nil
+}
+
+// Set value for the given key.
+func (d *DB) Set(key, value []byte) (err error) {
+	defer d.Observe(streams.StoreSet, time.Now(), &err)
+
+	// Copy the value as callers are allowed to reuse it
+	v := make([]byte, len(value))
+	copy(v, value)
+
+	s := d.shard(key)
+	s.Lock()
+	s.data[string(key)] = v
+	s.Unlock()
+	return nil
+}
+
+// Delete value for the given key.
+func (d *DB) Delete(key []byte) (err error) {
+	defer d.Observe(streams.StoreDelete, time.Now(), &err)
+
+	s := d.shard(key)
+	s.Lock()
+	delete(s.data, string(key))
+	s.Unlock()
+	return nil
+}
+
+// Range iterates the store within the given key range applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+// A nil from or to sets the iterator to the begining or end of Store.
+// Setting both from and to as nil iterates the whole store
+func (d *DB) Range(from, to []byte, cb func(key, value []byte) error) (err error) {
+	defer d.Observe(streams.StoreRange, time.Now(), &err)
+
+	return d.iterate(func(key string) bool {
+		return (from == nil || key >= string(from)) && (to == nil || key < string(to))
+	}, cb)
+}
+
+// RangePrefix iterates the store over a key prefix applying the callback
+// for the key value pairs. Returning a error causes the iteration to stop.
+func (d *DB) RangePrefix(prefix []byte, cb func(key, value []byte) error) (err error) {
+	defer d.Observe(streams.StoreRange, time.Now(), &err)
+
+	return d.iterate(func(key string) bool {
+		return bytes.HasPrefix([]byte(key), prefix)
+	}, cb)
+}
+
+// iterate takes a sorted snapshot of the matching keys of every shard
+// and applies the callback over the merged snapshots in key order.
+// All shards are read locked, in order, before taking the snapshots,
+// so that concurrent writes are either seen entirely or not at all.
+func (d *DB) iterate(match func(key string) bool, cb func(key, value []byte) error) (err error) {
+	iter := make(mergeIterator, 0, len(d.shards))
+
+	for _, s := range d.shards {
+		s.RLock()
+	}
+
+	for _, s := range d.shards {
+		snap := s.snapshot(match)
+		if len(snap.keys) > 0 {
+			iter = append(iter, snap)
+		}
+	}
+
+	for _, s := range d.shards {
+		s.RUnlock()
+	}
+
+	heap.Init(&iter)
+	for iter.Len() > 0 {
+		snap := iter[0]
+		key := snap.keys[snap.pos]
+
+		if err = cb([]byte(key), snap.values[snap.pos]); err != nil {
+			return err
+		}
+
+		snap.pos++
+		if snap.pos == len(snap.keys) {
+			heap.Pop(&iter)
+			continue
+		}
+		heap.Fix(&iter, 0)
+	}
+
+	return nil
+}
+
+func (d *DB) shard(key []byte) (s *shard) {
+	return d.shards[jump.Hash(wyhash.Hash(key, 0), len(d.shards))]
+}
+
+// snapshot returns the sorted keys and values matching the given function.
+// The shard must be read locked.
+func (s *shard) snapshot(match func(key string) bool) (snap *snapshot) {
+	snap = &snapshot{}
+
+	for key := range s.data {
+		if match(key) {
+			snap.keys = append(snap.keys, key)
+		}
+	}
+
+	sort.Strings(snap.keys)
+	snap.values = make([][]byte, len(snap.keys))
+	for x := range snap.keys {
+		snap.values[x] = s.data[snap.keys[x]]
+	}
+
+	return snap
+}
+
+// snapshot is a sorted view of a shard and its iteration position
+type snapshot struct {
+	keys   []string
+	values [][]byte
+	pos    int
+}
+
+// mergeIterator is a min heap of shard snapshots ordered by their current key
+type mergeIterator []*snapshot
+
+func (m mergeIterator) Len() int { return len(m) }
+
+func (m mergeIterator) Less(i, j int) bool {
+	return m[i].keys[m[i].pos] < m[j].keys[m[j].pos]
+}
+
+func (m mergeIterator) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+
+func (m *mergeIterator) Push(x interface{}) {
+	*m = append(*m, x.(*snapshot))
+}
+
+func (m *mergeIterator) Pop() interface{} {
+	old := *m
+	n := len(old)
+	x := old[n-1]
+	*m = old[:n-1]
+	return x
+}
@@ -0,0 +1,77 @@
+package sharded
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/brunotm/streams/mock"
+	"github.com/brunotm/streams/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedStore(t *testing.T) {
+	store.TestStore(t, Supplier, &mock.Context{})
+}
+
+func TestShardedStoreConsistentRange(t *testing.T) {
+	db := Supplier().(*DB)
+	assert.NoError(t, db.Init(&mock.Context{}))
+	defer db.Close()
+
+	// first is written before second, and snapshotted before it by Range
+	var first, second []byte
+	for x := 0; second == nil; x++ {
+		key := []byte(strconv.Itoa(x))
+		switch {
+		case first == nil && db.shard(key) == db.shards[0]:
+			first = key
+		case first != nil && db.shard(key) != db.shards[0]:
+			second = key
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for x := 0; x < 10000; x++ {
+			value := []byte(strconv.Itoa(x))
+			db.Set(first, value)
+			db.Set(second, value)
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		values := map[string]int{}
+		assert.NoError(t, db.Range(nil, nil, func(key, value []byte) error {
+			values[string(key)], _ = strconv.Atoi(string(value))
+			return nil
+		}))
+
+		// a range never sees the second write without the first
+		if values[string(second)] > values[string(first)] {
+			t.Fatalf("inconsistent range: %v", values)
+		}
+	}
+}
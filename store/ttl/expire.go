@@ -0,0 +1,129 @@
+package ttl
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+const (
+	// DefaultInterval is the default interval for scanning expired keys
+	DefaultInterval = time.Second
+)
+
+var (
+	errNotTTLStore = streams.Errorf(streams.CodeTopology, "not a ttl store")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Expirer)(nil)
+var _ streams.Closer = (*Expirer)(nil)
+var _ streams.Source = (*Expirer)(nil)
+
+// Expirer is a source that scans a TTLStore on a interval and forwards
+// a record with the key and last value of each expired key before deleting it,
+// enabling "session ended" and "entity inactive" patterns downstream.
+// Expiration records have the store name as topic.
+// The interval can be set with the <stream>.<node>.interval config.
+type Expirer struct {
+	mtx      sync.Mutex
+	store    string
+	interval time.Duration
+	ttl      streams.TTLStore
+	done     chan struct{}
+}
+
+// ExpirerSupplier for a Expirer over the named store with the given scan interval
+func ExpirerSupplier(store string, interval time.Duration) streams.SourceSupplier {
+	return func() (source streams.Source) {
+		return &Expirer{store: store, interval: interval}
+	}
+}
+
+// Init the expirer
+func (e *Expirer) Init(pc streams.ProcessorContext) (err error) {
+	e.interval = pc.Config().
+		Get(pc.StreamName(), pc.NodeName(), "interval").
+		Duration(e.interval)
+
+	if e.interval <= 0 {
+		e.interval = DefaultInterval
+	}
+
+	store, err := pc.Store(e.store)
+	if err != nil {
+		return err
+	}
+
+	var ok bool
+	if e.ttl, ok = store.(streams.TTLStore); !ok {
+		return errNotTTLStore
+	}
+
+	e.done = make(chan struct{})
+	return nil
+}
+
+// Close stops the expirer after any in progress scan
+func (e *Expirer) Close() (err error) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	close(e.done)
+	return nil
+}
+
+// Process is a no-op for sources
+func (e *Expirer) Process(pc streams.ProcessorContext, record streams.Record) {}
+
+// Consume scans the store for expired keys on every interval until closed
+func (e *Expirer) Consume(pc streams.ProcessorContext) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.done:
+			return
+		case now := <-ticker.C:
+			e.expire(pc, now)
+		}
+	}
+}
+
+// expire forwards and deletes the expired keys unless the expirer is closed
+func (e *Expirer) expire(pc streams.ProcessorContext, now time.Time) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	select {
+	case <-e.done:
+		return
+	default:
+	}
+
+	err := e.ttl.Expire(now, func(key, value []byte) error {
+		return pc.Forward(streams.NewRecord(e.store,
+			streams.ByteEncoder(key), streams.ByteEncoder(value), now, nil))
+	})
+
+	if err != nil {
+		pc.Error(err)
+	}
+}
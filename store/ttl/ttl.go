@@ -0,0 +1,288 @@
+package ttl
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+const (
+	// DefaultTTL is the default expiration time for keys set in the store
+	DefaultTTL = time.Hour
+)
+
+var (
+	errInvalidValue = streams.Errorf(streams.CodeSerialization, "invalid ttl value")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*DB)(nil)
+var _ streams.Closer = (*DB)(nil)
+var _ streams.Remover = (*DB)(nil)
+var _ streams.TTLStore = (*DB)(nil)
+var _ streams.Layered = (*DB)(nil)
+
+// DB adds expiring keys to a underlying store.
+// Values are stored prefixed with their expiration time and expired keys
+// are not visible to Get, Range and RangePrefix until deleted by Expire.
+// The default ttl can be set with the <stream>.<store>.ttl config.
+type DB struct {
+	pc    streams.ProcessorContext
+	store streams.Store
+	ttl   time.Duration
+}
+
+// Supplier for a ttl store over the stores created by the given supplier
+// with the given default ttl.
+func Supplier(supplier streams.StoreSupplier, ttl time.Duration) streams.StoreSupplier {
+	return func() (store streams.Store) {
+		return &DB{store: supplier(), ttl: ttl}
+	}
+}
+
+// Init store
+func (d *DB) Init(pc streams.ProcessorContext) (err error) {
+	d.pc = pc
+	d.ttl = d.pc.Config().
+		Get(d.pc.StreamName(), d.pc.NodeName(), "ttl").
+		Duration(d.ttl)
+
+	if d.ttl <= 0 {
+		d.ttl = DefaultTTL
+	}
+
+	if initializer, ok := d.store.(streams.Initializer); ok {
+		return initializer.Init(pc)
+	}
+	return nil
+}
+
+// Underlying returns the underlying store
+func (d *DB) Underlying() (store streams.Store) {
+	return d.store
+}
+
+// Layer returns a copy of the store over the given view of the underlying store
+func (d *DB) Layer(store streams.Store) (layered streams.Store) {
+	return &DB{pc: d.pc, store: store, ttl: d.ttl}
+}
+
+// Remove closes the store and erases its contents
+func (d *DB) Remove() (err error) {
+	if remover, ok := d.store.(streams.Remover); ok {
+		return remover.Remove()
+	}
+	return d.Close()
+}
+
+// Close the store releasing its resources.
+func (d *DB) Close() (err error) {
+	if closer, ok := d.store.(streams.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Name returns this store name.
+func (d *DB) Name() (name string) {
+	return d.pc.NodeName()
+}
+
+// Process store or deletes any forwarded record to the store.
+// Records with empty values deletes the given key from the store.
+func (d *DB) Process(pc streams.ProcessorContext, record streams.Record) {
+
+	if !record.IsValid() || record.Key == nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "invalid record to store"), record)
+		return
+	}
+
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record key: %w", err), record)
+		return
+	}
+
+	// Records with empty values deletes the given key from the store.
+	if record.Value == nil {
+		if err = d.Delete(key); err != nil {
+			pc.Error(err, record)
+		}
+		return
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record value: %w", err), record)
+		return
+	}
+
+	if err = d.Set(key, value); err != nil {
+		pc.Error(err, record)
+	}
+}
+
+// Get value for the given key.
+func (d *DB) Get(key []byte) (value []byte, err error) {
+	value, err = d.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, value, err := decode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if expired(deadline, time.Now()) {
+		return nil, streams.ErrKeyNotFound
+	}
+
+	return value, nil
+}
+
+// Set value for the given key with the store default ttl.
+func (d *DB) Set(key, value []byte) (err error) {
+	return d.SetWithTTL(key, value, d.ttl)
+}
+
+// SetWithTTL sets the value for the given key expiring after the given ttl.
+func (d *DB) SetWithTTL(key, value []byte, ttl time.Duration) (err error) {
+	return d.store.Set(key, encode(time.Now().Add(ttl), value))
+}
+
+// Delete value for the given key.
+func (d *DB) Delete(key []byte) (err error) {
+	return d.store.Delete(key)
+}
+
+// Range iterates the store within the given key range applying the callback
+// for the non expired key value pairs. Returning a error causes the iteration to stop.
+// A nil from or to sets the iterator to the begining or end of Store.
+// Setting both from and to as nil iterates the whole store
+func (d *DB) Range(from, to []byte, cb func(key, value []byte) error) (err error) {
+	now := time.Now()
+	return d.store.Range(from, to, func(key, value []byte) error {
+		return live(now, key, value, cb)
+	})
+}
+
+// RangePrefix iterates the store over a key prefix applying the callback
+// for the non expired key value pairs. Returning a error causes the iteration to stop.
+func (d *DB) RangePrefix(prefix []byte, cb func(key, value []byte) error) (err error) {
+	now := time.Now()
+	return d.store.RangePrefix(prefix, func(key, value []byte) error {
+		return live(now, key, value, cb)
+	})
+}
+
+// Expire deletes the keys expired at the given time and applies the callback
+// for them with their last value. Keys updated after being found expired
+// are kept and not reported.
+func (d *DB) Expire(now time.Time, cb func(key, value []byte) error) (err error) {
+	type entry struct {
+		key   []byte
+		value []byte
+	}
+
+	// Collect expired keys first as stores may hold locks while iterating
+	var entries []entry
+	err = d.store.Range(nil, nil, func(key, value []byte) error {
+		deadline, _, err := decode(value)
+		if err != nil {
+			return err
+		}
+
+		if expired(deadline, now) {
+			entries = append(entries, entry{
+				key:   append([]byte(nil), key...),
+				value: append([]byte(nil), value...),
+			})
+		}
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		// Only delete and report keys not updated since found expired
+		current, err := d.store.Get(e.key)
+		if err == streams.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(current, e.value) {
+			continue
+		}
+
+		if err = d.store.Delete(e.key); err != nil {
+			return err
+		}
+
+		// Restore the key if the callback fails so it expires again
+		if err = cb(e.key, e.value[8:]); err != nil {
+			if serr := d.store.Set(e.key, e.value); serr != nil {
+				return serr
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// live applies the callback if the encoded value is not expired
+func live(now time.Time, key, value []byte, cb func(key, value []byte) error) (err error) {
+	deadline, value, err := decode(value)
+	if err != nil {
+		return err
+	}
+
+	if expired(deadline, now) {
+		return nil
+	}
+	return cb(key, value)
+}
+
+func expired(deadline int64, now time.Time) (ok bool) {
+	return deadline <= now.UnixNano()
+}
+
+// encode the value prefixed by its expiration time
+func encode(deadline time.Time, value []byte) (data []byte) {
+	data = make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(data, uint64(deadline.UnixNano()))
+	copy(data[8:], value)
+	return data
+}
+
+// decode the expiration time and value
+func decode(data []byte) (deadline int64, value []byte, err error) {
+	if len(data) < 8 {
+		return 0, nil, errInvalidValue
+	}
+	return int64(binary.BigEndian.Uint64(data)), data[8:], nil
+}
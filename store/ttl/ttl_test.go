@@ -0,0 +1,150 @@
+package ttl
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/brunotm/streams/store"
+	"github.com/brunotm/streams/store/sharded"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTTLStore(t *testing.T) {
+	store.TestStore(t, Supplier(sharded.Supplier, time.Hour), &mock.Context{})
+}
+
+func TestTTLStoreExpire(t *testing.T) {
+	db := Supplier(sharded.Supplier, time.Hour)().(*DB)
+	assert.NoError(t, db.Init(&mock.Context{}))
+
+	assert.NoError(t, db.SetWithTTL([]byte("a"), []byte("1"), time.Millisecond))
+	assert.NoError(t, db.SetWithTTL([]byte("b"), []byte("2"), -time.Millisecond))
+	assert.NoError(t, db.Set([]byte("c"), []byte("3")))
+
+	_, err := db.Get([]byte("b"))
+	assert.Equal(t, streams.ErrKeyNotFound, err)
+
+	expired := make(map[string]string)
+	assert.NoError(t, db.Expire(time.Now().Add(time.Second), func(key, value []byte) error {
+		expired[string(key)] = string(value)
+		return nil
+	}))
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, expired)
+
+	var keys []string
+	assert.NoError(t, db.Range(nil, nil, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	}))
+	assert.Equal(t, []string{"c"}, keys)
+
+	// keys updated after found expired are neither reported nor deleted
+	assert.NoError(t, db.SetWithTTL([]byte("a"), []byte("1"), -time.Millisecond))
+	assert.NoError(t, db.SetWithTTL([]byte("b"), []byte("2"), -time.Millisecond))
+
+	expired = make(map[string]string)
+	assert.NoError(t, db.Expire(time.Now(), func(key, value []byte) error {
+		expired[string(key)] = string(value)
+		return db.SetWithTTL([]byte("b"), []byte("4"), time.Hour)
+	}))
+	assert.Equal(t, map[string]string{"a": "1"}, expired)
+
+	value, err := db.Get([]byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, "4", string(value))
+
+	// keys are kept when the callback fails
+	assert.NoError(t, db.SetWithTTL([]byte("a"), []byte("1"), -time.Millisecond))
+	errCallback := errors.New("callback")
+	assert.Equal(t, errCallback, db.Expire(time.Now(), func(key, value []byte) error { return errCallback }))
+	expired = make(map[string]string)
+	assert.NoError(t, db.Expire(time.Now(), func(key, value []byte) error {
+		expired[string(key)] = string(value)
+		return nil
+	}))
+	assert.Equal(t, map[string]string{"a": "1"}, expired)
+	assert.NoError(t, db.Close())
+}
+
+func TestExpirer(t *testing.T) {
+	for _, guarantee := range []streams.ProcessingGuarantee{streams.AtLeastOnce, streams.ExactlyOnce} {
+		testExpirer(t, guarantee)
+	}
+}
+
+func testExpirer(t *testing.T, guarantee streams.ProcessingGuarantee) {
+	dir, err := ioutil.TempDir("", "ttl")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := streams.NewConfig(nil)
+	config.Set(dir, "stream", "state", "dir")
+	config.Set("10ms", "stream", "checkpoint", "interval")
+	config.Set("10ms", "stream", "expirer", "interval")
+	config.Set("1ms", "stream", "sessions", "ttl")
+
+	b := streams.NewBuilder("stream", config)
+	b.Guarantee(guarantee)
+	assert.NoError(t, b.AddStore("sessions", Supplier(sharded.Supplier, 0)))
+	assert.NoError(t, b.AddSource("expirer", ExpirerSupplier("sessions", 0)))
+
+	expired := make(chan streams.Record, 1)
+	assert.NoError(t, b.AddSinkFunc("ended", func(pc streams.ProcessorContext, record streams.Record) {
+		expired <- record
+	}, "expirer"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	st, err := stream.Store("sessions")
+	assert.NoError(t, err)
+	assert.NoError(t, st.(streams.Store).Set([]byte("session"), []byte("last")))
+
+	select {
+	case record := <-expired:
+		k, _ := record.EncodeKey()
+		v, _ := record.EncodeValue()
+		assert.Equal(t, "sessions", record.Topic)
+		assert.Equal(t, "session", string(k))
+		assert.Equal(t, "last", string(v))
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for expiration")
+	}
+
+	// expired keys are deleted after forwarded
+	deleted := false
+	for x := 0; x < 100 && !deleted; x++ {
+		var keys int
+		assert.NoError(t, st.(*DB).store.Range(nil, nil, func(key, value []byte) error {
+			keys++
+			return nil
+		}))
+		deleted = keys == 0
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, deleted)
+
+	assert.NoError(t, stream.Close())
+}
@@ -17,17 +17,25 @@ package streams
 */
 
 import (
+	"context"
+	"fmt"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/brunotm/streams/types"
 )
 
-// Error generated by the stream components
+// Error generated by the stream components.
+// The Category is taken from the error with CategoryOf, and the Tenant
+// from the first record with a tenant.
 type Error struct {
-	Node   *Node
-	Error  error
-	Record []Record
+	Node     *Node
+	Error    error
+	Record   []Record
+	Category Category
+	Tenant   string
+	Attempt  int // Number of retries of the records processing
 }
 
 // Stream represents an unbounded, continuously updating data set.
@@ -35,13 +43,39 @@ type Error struct {
 // A Stream can have multiple concurrent tasks over the same processor topology.
 // Stream Sources, Processors and Sinks must be safe for concurrent use.
 type Stream struct {
+	watermark int64 // highest stream watermark in unix nanoseconds
+	inflight  inflight
+
 	mtx      sync.Mutex
 	name     string
 	config   Config
 	tasks    nodeTasks
 	topology *topology
-	handler  func(Error)
+	handler  ErrorHandler
 	donech   chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	buffers  *bufferController
+	progress RestoreProgress
+	mws      middlewares
+	push     *metricsPush
+	standby  *standby
+
+	resources   resources
+	limits      *limits
+	tenants     *tenants
+	deadLetters deadLetters
+	durable     []durableEdge
+	versions    map[string]storeVersion
+	splitters   map[string]*splitter
+
+	dependencies map[string][]string
+	gating       *readiness
+	guarantee    ProcessingGuarantee
+	coordinator  *coordinator
+	staleness    *stalenessMonitor
+	scheduler    *scaleScheduler
+	stop         stopper
 }
 
 // Start initializes the stores, sources, processors and sinks within the
@@ -49,13 +83,47 @@ type Stream struct {
 func (s *Stream) Start() (err error) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
+	s.stop.reset()
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 
-	// Initialize tasks initializing stream componentes
-	s.initTasks()
+	// Components initialized by Start are closed if the stream fails to start
+	var initialized []*Node
+	defer func() {
+		if err != nil {
+			err = s.rollback(err, initialized)
+		}
+	}()
 
+	// Skip stores already initialized on their first access
+	var stores []*Node
+	s.topology.smtx.Lock()
 	for _, node := range s.topology.stores {
+		if node.processor != nil {
+			continue
+		}
+
 		pc := newContext(s)
 		if err = node.init(pc); err != nil {
+			node.processor = nil
+			s.topology.smtx.Unlock()
+			return err
+		}
+		stores = append(stores, node)
+		initialized = append(initialized, node)
+	}
+	s.topology.smtx.Unlock()
+
+	if err = s.restoreStores(stores); err != nil {
+		return err
+	}
+
+	if err = s.migrateStores(); err != nil {
+		return err
+	}
+
+	// Apply the mutations of a checkpoint interrupted by a failure
+	if s.guarantee == ExactlyOnce {
+		if s.coordinator, err = newCoordinator(s); err != nil {
 			return err
 		}
 	}
@@ -69,82 +137,296 @@ func (s *Stream) Start() (err error) {
 		if err = node.init(pc); err != nil {
 			return err
 		}
+		initialized = append(initialized, node)
+	}
+
+	// Initialize tasks for stream components
+	if err = s.initTasks(); err != nil {
+		return err
+	}
+
+	// Deliver the records left in the durable edges
+	if err = s.openDurable(); err != nil {
+		return err
+	}
+
+	if s.coordinator != nil {
+		s.coordinator.start()
+	}
+
+	pusher, err := newMetricsPusher(s)
+	if err != nil {
+		return err
+	}
+	if pusher != nil {
+		s.push = startMetricsPush(s, pusher)
+	}
+
+	if s.scheduler == nil {
+		s.scheduler = startScaleScheduler(s)
+	}
+
+	// Standby streams keep their stores warm without consuming until promoted
+	if s.config.Get(s.name, "standby").Bool(false) {
+		s.standby = s.follow()
+		return nil
 	}
 
+	sources, err := s.startSources()
+	initialized = append(initialized, sources...)
+	return err
+}
+
+// startSources initializes the sources and starts consuming, returning the
+// initialized sources. Sources with dependencies start once their dependencies are ready.
+func (s *Stream) startSources() (initialized []*Node, err error) {
 	for _, node := range s.topology.roots {
 		pc := newContext(s)
 		if err = node.init(pc); err != nil {
-			return err
+			return initialized, err
+		}
+		initialized = append(initialized, node)
+	}
+
+	for _, node := range s.topology.roots {
+		if dependencies := s.dependencies[node.name]; len(dependencies) > 0 {
+			s.gate(node, dependencies)
+			continue
 		}
 
-		// start streaming
-		node.pc.activate()
-		go node.processor.(Source).Consume(pc)
+		if err = s.startSource(node); err != nil {
+			return initialized, err
+		}
 	}
 
-	return nil
+	if s.staleness == nil {
+		s.staleness = startStalenessMonitor(s)
+	}
+
+	return initialized, nil
 }
 
-// Close the stream.
-// Closes all stream sources and its tasks in parallel, close all processors
-// sequentially if their context is deactivated, close all sink processors and
-// finally all stores.
-func (s *Stream) Close() (err error) {
-	// first close all sources
+// rollback stops the stream after a failed Start and closes the components
+// it initialized in reverse order. The start error is returned, aggregated
+// as Errors with the errors closing the components.
+func (s *Stream) rollback(cause error, initialized []*Node) (err error) {
+	errs := Errors{cause}
+
+	if s.scheduler != nil {
+		s.scheduler.stop()
+		s.scheduler = nil
+	}
+
+	if s.push != nil {
+		s.push.stop()
+		s.push = nil
+	}
+
+	if s.buffers != nil {
+		s.buffers.stop()
+		s.buffers = nil
+	}
+
+	s.stopGating()
+
+	// Mutations of records from started sources are not committed
+	if s.coordinator != nil {
+		s.coordinator.abort()
+		s.coordinator = nil
+	}
+
+	// Started sources are closed with their tasks
+	started := make(map[*Node]bool)
 	for _, node := range s.topology.roots {
-		if closer, ok := node.processor.(Closer); ok {
-			if err = closer.Close(); err != nil {
-				return err
+		if t := s.tasks[node]; t != nil && t.scale() > 0 {
+			started[node] = true
+			if err = s.tasks.setScale(node, 0); err != nil {
+				errs = append(errs, err)
 			}
 		}
-
-		// close all source tasks
-		s.tasks.setScale(node, 0, 0)
+		node.stopFanout()
 	}
 
-	// Close all processors
 	for _, node := range s.topology.nodes {
-		if node.typ != types.Processor {
+		if node.typ == types.Source || s.tasks[node] == nil {
 			continue
 		}
 
-		if closer, ok := node.processor.(Closer); ok {
-			for {
-				if node.pc.IsActive() {
-					runtime.Gosched()
-					continue
-				}
-				if err = closer.Close(); err != nil {
-					return err
-				}
+		if err = s.tasks.setScale(node, 0); err != nil {
+			errs = append(errs, err)
+		}
+		s.tasks[node].wait()
+		node.stopWorkers()
+		node.stopFanout()
+	}
+
+	if err = s.closeDurable(); err != nil {
+		errs = append(errs, err)
+	}
+
+	for x := len(initialized) - 1; x >= 0; x-- {
+		node := initialized[x]
+		if closer, ok := node.processor.(Closer); ok && !started[node] {
+			if err = closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("%s %s close: %w", node.typ, node.name, err))
 			}
 		}
 
-		// close all processor tasks
-		s.tasks.setScale(node, 0, 0)
+		if node.pc != nil {
+			node.pc.invalidate()
+		}
+
+		// Stores are initialized again on the next Start
+		if node.typ == types.Store {
+			s.topology.smtx.Lock()
+			node.processor = nil
+			s.topology.smtx.Unlock()
+		}
+	}
+
+	s.cancel()
+
+	if len(errs) == 1 {
+		return cause
+	}
+	return errs
+}
+
+// startSource starts consuming from the initialized source
+func (s *Stream) startSource(node *Node) (err error) {
+	if node.liveness != nil {
+		node.liveness.observe(time.Now())
+	}
+
+	// start streaming, the source node instance is its first task
+	if err = s.tasks[node].startSource(node.pc); err != nil {
+		return err
+	}
+
+	scale := node.schedule.initialScale(time.Now(), s.config.Get(s.name, node.name, "tasks", "count").Int(1))
+	return s.tasks.setScale(node, scale)
+}
+
+// Scale sets the number of concurrent tasks for the named node.
+// Each task of a processor or sink processes records with its own processor
+// instance and context created from the node supplier, with records of the
+// same id always processed by the same task. Records of ids reassigned
+// to other tasks by a rescale are held until their previous task processes
+// the records buffered before the rescale. A scale of 0 processes records
+// inline within the predecessor task.
+// Each task of a source is an independent consumer, and a scale of 0
+// stops consuming from the source. Tasks of a PartitionedSource consume
+// distinct partitions, which are rebalanced on every scale change.
+// Paused nodes must be resumed before scaling.
+func (s *Stream) Scale(name string, scale int) (err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	node := s.topology.getNode(name)
+	if node == nil {
+		return ErrNodeNotFound
 	}
 
-	// Close all sinks
+	if node.pause.isPaused() {
+		return errNodePaused
+	}
+
+	return s.tasks.setScale(node, scale)
+}
+
+// Close the stream.
+// Stops all stream sources, then closes processors and sinks in topology order
+// after their tasks finish processing buffered records and their context is
+// deactivated, and finally closes all stores.
+func (s *Stream) Close() (err error) {
+	s.mtx.Lock()
+	s.stop.signal(nil, true)
+	if s.standby != nil {
+		s.standby.stop()
+		s.standby = nil
+	}
+	s.stopGating()
+	s.mtx.Unlock()
+
+	if s.buffers != nil {
+		s.buffers.stop()
+		s.buffers = nil
+	}
+
+	if s.staleness != nil {
+		s.staleness.stop()
+		s.staleness = nil
+	}
+
+	if s.scheduler != nil {
+		s.scheduler.stop()
+		s.scheduler = nil
+	}
+
+	// Stop checkpointing while the nodes stop, the remaining
+	// mutations are committed once all records are processed
+	if s.coordinator != nil {
+		s.coordinator.abort()
+	}
+
+	// first stop all sources
+	for _, node := range s.topology.roots {
+		if err = s.tasks.setScale(node, 0); err != nil {
+			return err
+		}
+		node.stopFanout()
+	}
+
+	// Close all processors and sinks
 	for _, node := range s.topology.nodes {
-		if node.typ != types.Sink {
+		if node.typ == types.Source {
 			continue
 		}
 
+		// close all node tasks
+		if err = s.tasks.setScale(node, 0); err != nil {
+			return err
+		}
+		s.tasks[node].wait()
+		node.stopWorkers()
+
 		if closer, ok := node.processor.(Closer); ok {
-			for {
-				if node.pc.IsActive() {
-					runtime.Gosched()
-					continue
-				}
-				if err = closer.Close(); err != nil {
-					return err
-				}
+			for node.pc.IsActive() {
+				runtime.Gosched()
 			}
+			node.pc.stop()
+
+			if err = closer.Close(); err != nil {
+				return err
+			}
+		}
+
+		if node.pc != nil {
+			node.pc.invalidate()
+		}
+		node.stopFanout()
+	}
+
+	if err = s.closeDurable(); err != nil {
+		return err
+	}
+
+	// Push the final metrics
+	if s.push != nil {
+		s.push.stop()
+		s.push = nil
+	}
+
+	// Commit the remaining store mutations
+	if s.coordinator != nil {
+		if err = s.coordinator.close(); err != nil {
+			return err
 		}
+		s.coordinator = nil
 	}
 
 	// Close all stores
-	for _, node := range s.topology.stores {
+	for _, node := range s.topology.storeNodes() {
 		if closer, ok := node.processor.(Closer); ok {
 			if err = closer.Close(); err != nil {
 				return err
@@ -152,32 +434,263 @@ func (s *Stream) Close() (err error) {
 		}
 	}
 
-	return nil
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	// Release the shared resources
+	return s.resources.release()
+}
+
+// context returns the stream context, canceled once the stream is closed
+func (s *Stream) context() (ctx context.Context) {
+	if s == nil || s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
 }
 
 // Store returns the store with the given name
 func (s *Stream) Store(name string) (store ROStore, err error) {
-	st, exists := s.topology.stores[name]
+	node, err := s.store(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return node.processor.(ROStore), nil
+}
+
+// Replica returns a read only replica of the store with the given name for
+// interactive queries. Stores not implementing the Replicator interface are
+// returned as is. Replicas implementing the Closer interface must be closed
+// once the query is done.
+func (s *Stream) Replica(name string) (replica ROStore, err error) {
+	node, err := s.store(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if replicator, ok := node.processor.(Replicator); ok {
+		return replicator.Replica()
+	}
+
+	return node.processor.(ROStore), nil
+}
+
+// ReplicaAsOf returns a read only view of the store with the given name as of
+// the given time for interactive queries, allowing past state to be inspected.
+// Stores not implementing the Historian interface fail with ErrHistoryUnavailable.
+// Views implementing the Closer interface must be closed once the query is done.
+func (s *Stream) ReplicaAsOf(name string, at time.Time) (replica ROStore, err error) {
+	historian, err := s.historian(name)
+	if err != nil {
+		return nil, err
+	}
+	return historian.AsOf(at)
+}
+
+// ReplicaAsOfCheckpoint returns a read only view of the store with the given
+// name at the latest checkpoint with the given name for interactive queries.
+// Stores not implementing the Historian interface fail with ErrHistoryUnavailable.
+// Views implementing the Closer interface must be closed once the query is done.
+func (s *Stream) ReplicaAsOfCheckpoint(name, checkpoint string) (replica ROStore, err error) {
+	historian, err := s.historian(name)
+	if err != nil {
+		return nil, err
+	}
+	return historian.AsOfCheckpoint(checkpoint)
+}
+
+// Checkpoint marks the current state of all initialized stores implementing
+// the Historian interface with the given checkpoint name
+func (s *Stream) Checkpoint(name string) (err error) {
+	s.topology.smtx.RLock()
+	defer s.topology.smtx.RUnlock()
+
+	for _, node := range s.topology.stores {
+		if historian, ok := node.processor.(Historian); ok {
+			if err = historian.Checkpoint(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// historian returns the named store if it implements the Historian interface
+func (s *Stream) historian(name string) (historian Historian, err error) {
+	node, err := s.store(name)
+	if err != nil {
+		return nil, err
+	}
+
+	historian, ok := node.processor.(Historian)
+	if !ok {
+		return nil, ErrHistoryUnavailable
+	}
+	return historian, nil
+}
+
+// AddStore adds a store to the stream topology. Stores added to a running
+// stream are initialized and restored on their first access, making them
+// available to newly attached processors and interactive queries without
+// a restart.
+func (s *Stream) AddStore(name string, supplier StoreSupplier) (err error) {
+	s.topology.smtx.Lock()
+	defer s.topology.smtx.Unlock()
+
+	if s.topology.getNode(name) != nil {
+		return errInvalidTopology
+	}
+	return s.topology.addStore(name, supplier)
+}
+
+// store returns the initialized store node with the given name.
+// Stores added at runtime are initialized and restored on their first access.
+func (s *Stream) store(name string) (node *Node, err error) {
+	s.topology.smtx.RLock()
+	node, exists := s.topology.stores[name]
+	ready := exists && node.processor != nil
+	s.topology.smtx.RUnlock()
+
 	if !exists {
 		return nil, ErrStoreNotFound
 	}
 
-	return st.processor.(ROStore), nil
+	if ready {
+		return node, nil
+	}
+
+	s.topology.smtx.Lock()
+	defer s.topology.smtx.Unlock()
+
+	if node.processor != nil {
+		return node, nil
+	}
+
+	if err = node.init(newContext(s)); err != nil {
+		node.processor = nil
+		return nil, err
+	}
+
+	if restorer, ok := node.processor.(Restorer); ok {
+		if err = s.restoreStore(name, restorer); err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
 }
 
-// initTasks for all source and processors that have successors.
-// Sink nodes are ignored.
-func (s *Stream) initTasks() {
+// initTasks for all sources, processors and sinks.
+// Processors and sinks are scaled to their configured number of tasks.
+func (s *Stream) initTasks() (err error) {
 	s.tasks = make(nodeTasks)
 
+	if s.limits, err = newLimits(s.config.Get(s.name, "limits")); err != nil {
+		return err
+	}
+
+	location, err := time.LoadLocation(s.config.Get(s.name, "schedules", "timezone").String("Local"))
+	if err != nil {
+		return err
+	}
+
 	for _, node := range s.topology.nodes {
-		if len(node.successors) == 0 || node.typ == types.Sink {
-			continue
+		t := newTasks(s, node)
+		t.adaptive = s.config.Get(s.name, "buffers", "adaptive").Bool(false)
+		t.overflow.path = s.config.Get(s.name, node.name, "tasks", "overflow", "path").String("")
+		t.overflow.segmentSize = s.config.Get(s.name, node.name, "tasks", "overflow", "segment").Int64(DefaultSegmentSize)
+		t.buffer = s.config.Get(s.name, node.name, "tasks", "buffer").Int(0)
+		if t.adaptive && t.buffer == 0 {
+			t.buffer = DefaultBufferSize
 		}
-		t := &tasks{}
+		t.priority = s.config.Get(s.name, node.name, "tasks", "priority").Int(t.buffer)
 		s.tasks[node] = t
-		scale := s.config.Get(s.name, node.name, "tasks", "count").Int(0)
-		buffer := s.config.Get(s.name, node.name, "tasks", "buffer").Int(0)
-		s.tasks.setScale(node, scale, buffer)
+
+		if priorities := s.config.Get(s.name, node.name, "priority").Map(); priorities != nil {
+			node.priorities = make(map[string]int, len(priorities))
+			for topic, priority := range priorities {
+				node.priorities[topic] = priority.Int(0)
+			}
+		}
+
+		if weights := s.config.Get(s.name, node.name, "weights").Map(); weights != nil {
+			node.weights = make(map[string]int, len(weights))
+			for topic, weight := range weights {
+				node.weights[topic] = weight.Int(1)
+			}
+		}
+
+		if node.guard, err = newGuard(s.config.Get(s.name, node.name, "guard")); err != nil {
+			return err
+		}
+
+		if node.typ == types.Processor || node.typ == types.Sink {
+			node.supervisor = newSupervisor(s.config.Get(s.name, node.name, "supervision"))
+			node.deadline = s.config.Get(s.name, node.name, "deadline").Duration(0)
+		}
+
+		workers := s.config.Get(s.name, node.name, "fanout", "workers").Int(0)
+		buffer := s.config.Get(s.name, node.name, "fanout", "buffer").Int(0)
+		node.setFanout(workers, buffer)
+
+		count := 0
+		if node.typ == types.Source {
+			count = 1
+		}
+		config := s.config.Get(s.name, node.name, "tasks")
+		if node.schedule, err = newScaleSchedule(config.Get("schedule"), config.Get("count").Int(count), location); err != nil {
+			return err
+		}
+
+		if node.typ == types.Source {
+			if quotas := s.config.Get(s.name, node.name, "quota").Map(); quotas != nil {
+				rates := make(map[string]float64, len(quotas))
+				for topic, rate := range quotas {
+					rates[topic] = rate.Float64(0)
+				}
+				node.quotas = newTopicQuotas(rates)
+			}
+
+			if ttl := s.config.Get(s.name, node.name, "duplicates", "ttl").Duration(0); ttl > 0 {
+				size := s.config.Get(s.name, node.name, "duplicates", "size").Int(DefaultDuplicatesSize)
+				node.duplicates = newDuplicates(size, ttl)
+			}
+
+			if node.ackDeadline, err = newAckDeadline(s.config.Get(s.name, node.name, "ack")); err != nil {
+				return err
+			}
+
+			node.watermark = newWatermark(s.config.Get(s.name, node.name, "watermark"))
+			node.liveness = newLiveness(
+				s.config.Get(s.name, node.name, "staleness", "records").Duration(0),
+				s.config.Get(s.name, node.name, "staleness", "poll").Duration(0))
+			continue
+		}
+
+		if node.typ == types.Sink {
+			node.budget = s.config.Get(s.name, node.name, "latency", "budget").Duration(0)
+
+			var workers, queue int
+			if concurrent, ok := node.processor.(Concurrent); ok {
+				workers, queue = concurrent.Concurrency()
+			}
+			node.setWorkers(
+				s.config.Get(s.name, node.name, "concurrency", "workers").Int(workers),
+				s.config.Get(s.name, node.name, "concurrency", "queue").Int(queue))
+		}
+
+		scale := node.schedule.initialScale(time.Now(), s.config.Get(s.name, node.name, "tasks", "count").Int(0))
+		if err = s.tasks.setScale(node, scale); err != nil {
+			return err
+		}
+	}
+
+	if s.config.Get(s.name, "buffers", "adaptive").Bool(false) {
+		s.buffers = newBufferController(s)
+		s.buffers.start()
 	}
+
+	return nil
 }
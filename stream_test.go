@@ -0,0 +1,388 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// taskRecorder records the tasks that processed each key.
+// Each instance keeps its own unsynchronized state.
+type taskRecorder struct {
+	seen  map[string]int
+	mtx   *sync.Mutex
+	tasks map[string]map[int]bool
+	wg    *sync.WaitGroup
+}
+
+func (r *taskRecorder) Process(pc ProcessorContext, record Record) {
+	key, _ := record.EncodeKey()
+	r.seen[string(key)]++
+
+	r.mtx.Lock()
+	if r.tasks[string(key)] == nil {
+		r.tasks[string(key)] = make(map[int]bool)
+	}
+	r.tasks[string(key)][pc.TaskID()] = true
+	r.mtx.Unlock()
+	r.wg.Done()
+}
+
+func TestStreamScale(t *testing.T) {
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	tasks := make(map[string]map[int]bool)
+	wg.Add(100)
+
+	config := NewConfig(nil)
+	config.Set(4, "stream.sink.tasks.count")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: cycledRecords(100, 10)}
+	}))
+	assert.NoError(t, b.AddSink("sink", func() Processor {
+		return &taskRecorder{seen: make(map[string]int), mtx: &mtx, tasks: tasks, wg: &wg}
+	}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+
+	sink := stream.topology.getNode("sink")
+	assert.Equal(t, 4, stream.tasks[sink].scale())
+	assert.Len(t, tasks, 10)
+	for key, ids := range tasks {
+		assert.Len(t, ids, 1, key)
+	}
+
+	assert.NoError(t, stream.Scale("sink", 2))
+	assert.Equal(t, 2, stream.tasks[sink].scale())
+	assert.Equal(t, ErrNodeNotFound, stream.Scale("unknown", 2))
+
+	source := stream.topology.getNode("source")
+	assert.Equal(t, 1, stream.tasks[source].scale())
+	assert.NoError(t, stream.Close())
+	assert.Equal(t, 0, stream.tasks[source].scale())
+	assert.Equal(t, 0, stream.tasks[sink].scale())
+}
+
+func TestStreamBroadcast(t *testing.T) {
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	tasks := make(map[string]map[int]bool)
+	wg.Add(4 + 1)
+
+	config := NewConfig(nil)
+	config.Set(4, "stream.sink.tasks.count")
+
+	acked := make(chan struct{})
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: cycledRecords(1, 1)}
+	}))
+	assert.NoError(t, b.AddProcessorFunc("control", func(pc ProcessorContext, record Record) {
+		pc.Forward(record)
+		control := NewRecord("control", StringEncoder("control"), StringEncoder("reload"), time.Now(),
+			func() error {
+				close(acked)
+				return nil
+			})
+		pc.Broadcast(control)
+		control.Ack()
+	}, "source"))
+	assert.NoError(t, b.AddSink("sink", func() Processor {
+		return &taskRecorder{seen: make(map[string]int), mtx: &mtx, tasks: tasks, wg: &wg}
+	}, "control"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+
+	select {
+	case <-acked:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for broadcast ack")
+	}
+
+	assert.Len(t, tasks["control"], 4)
+	assert.Len(t, tasks["0"], 1)
+	assert.NoError(t, stream.Close())
+}
+
+// partitionedSource records its partition assignments
+type partitionedSource struct {
+	mtx         *sync.Mutex
+	assignments map[*partitionedSource][]string
+}
+
+func (s *partitionedSource) Process(pc ProcessorContext, record Record) {}
+func (s *partitionedSource) Consume(pc ProcessorContext)                {}
+
+func (s *partitionedSource) Partitions() ([]string, error) {
+	return []string{"p0", "p1", "p2", "p3", "p4"}, nil
+}
+
+func (s *partitionedSource) Assign(partitions []string) error {
+	s.mtx.Lock()
+	s.assignments[s] = partitions
+	s.mtx.Unlock()
+	return nil
+}
+
+func (s *partitionedSource) Close() error {
+	s.mtx.Lock()
+	delete(s.assignments, s)
+	s.mtx.Unlock()
+	return nil
+}
+
+func TestStreamScalePartitionedSource(t *testing.T) {
+	var mtx sync.Mutex
+	assignments := make(map[*partitionedSource][]string)
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &partitionedSource{mtx: &mtx, assignments: assignments}
+	}))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	assigned := func() (tasks int, partitions []string) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, p := range assignments {
+			partitions = append(partitions, p...)
+		}
+		return len(assignments), partitions
+	}
+
+	all := []string{"p0", "p1", "p2", "p3", "p4"}
+
+	tasks, partitions := assigned()
+	assert.Equal(t, 1, tasks)
+	assert.ElementsMatch(t, all, partitions)
+
+	assert.NoError(t, stream.Scale("source", 3))
+	tasks, partitions = assigned()
+	assert.Equal(t, 3, tasks)
+	assert.ElementsMatch(t, all, partitions)
+
+	// scale is limited to the number of partitions
+	assert.NoError(t, stream.Scale("source", 8))
+	tasks, partitions = assigned()
+	assert.Equal(t, 5, tasks)
+	assert.ElementsMatch(t, all, partitions)
+
+	assert.NoError(t, stream.Scale("source", 2))
+	tasks, partitions = assigned()
+	assert.Equal(t, 2, tasks)
+	assert.ElementsMatch(t, all, partitions)
+
+	assert.NoError(t, stream.Close())
+	tasks, _ = assigned()
+	assert.Equal(t, 0, tasks)
+}
+
+// checkedSink fails its check
+type checkedSink struct {
+	closed *bool
+}
+
+func (c *checkedSink) Process(pc ProcessorContext, record Record) {}
+func (c *checkedSink) Check() error                               { return errors.New("unreachable") }
+func (c *checkedSink) Close() error {
+	*c.closed = true
+	return nil
+}
+
+func TestStreamDryRun(t *testing.T) {
+	var closed bool
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(10)))
+	assert.NoError(t, b.AddSink("sink", func() Processor {
+		return &checkedSink{closed: &closed}
+	}, "source"))
+	assert.NoError(t, b.Validate())
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+
+	err = stream.DryRun()
+	assert.Error(t, err)
+	assert.Len(t, err, 1)
+	assert.Equal(t, "sink sink check: unreachable", err.Error())
+	assert.True(t, closed)
+}
+
+// closingNode records its close and fails its init or close if set
+type closingNode struct {
+	nopStore
+	name    string
+	closed  *[]string
+	initErr error
+	err     error
+}
+
+func (c *closingNode) Init(pc ProcessorContext) error { return c.initErr }
+func (c *closingNode) Close() error {
+	*c.closed = append(*c.closed, c.name)
+	return c.err
+}
+
+func TestStreamStartRollback(t *testing.T) {
+	errInit := errors.New("init failed")
+	errClose := errors.New("close failed")
+
+	for _, closeErr := range []error{nil, errClose} {
+		var closed []string
+		node := func(name string, initErr, err error) *closingNode {
+			return &closingNode{name: name, closed: &closed, initErr: initErr, err: err}
+		}
+
+		b := NewBuilder("stream", NewConfig(nil))
+		assert.NoError(t, b.AddStore("store", func() Store { return node("store", nil, nil) }))
+		assert.NoError(t, b.AddSource("source", testSourceSupplier(0)))
+		assert.NoError(t, b.AddProcessor("processor", func() Processor {
+			return node("processor", nil, closeErr)
+		}, "source"))
+		assert.NoError(t, b.AddSink("sink", func() Processor { return node("sink", errInit, nil) }, "processor"))
+
+		stream, err := b.Build()
+		assert.NoError(t, err)
+
+		// the initialized nodes and stores are closed in reverse order
+		err = stream.Start()
+		assert.True(t, errors.Is(err, errInit))
+		assert.Equal(t, []string{"processor", "store"}, closed)
+		assert.Nil(t, stream.topology.stores["store"].processor)
+
+		if closeErr == nil {
+			assert.Equal(t, errInit, err)
+			continue
+		}
+
+		assert.Len(t, err, 2)
+		assert.True(t, errors.Is(err, errClose))
+		assert.Equal(t, "init failed; processor processor close: close failed", err.Error())
+	}
+}
+
+func TestStreamAddStore(t *testing.T) {
+	var running, max int32
+	var restored int64
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(0)))
+	assert.NoError(t, b.AddSinkFunc("sink", passthrough, "source"))
+	assert.NoError(t, b.AddStore("initial", func() Store { return nopStore{} }))
+	b.RestoreProgress(func(store string, count int64, done bool) {
+		if store == "late" && done {
+			atomic.StoreInt64(&restored, count)
+		}
+	})
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	_, err = stream.Store("late")
+	assert.Equal(t, ErrStoreNotFound, err)
+
+	assert.NoError(t, stream.AddStore("late", func() Store {
+		return &restoringStore{running: &running, max: &max}
+	}))
+	assert.Error(t, stream.AddStore("late", func() Store { return nopStore{} }))
+	assert.Error(t, stream.AddStore("source", func() Store { return nopStore{} }))
+
+	// the builder topology remains untouched
+	assert.Nil(t, b.topology.stores["late"])
+
+	store, err := stream.Store("late")
+	assert.NoError(t, err)
+	assert.NotNil(t, store)
+	assert.Equal(t, int64(10), atomic.LoadInt64(&restored))
+	assert.NoError(t, stream.Close())
+}
+
+// historyStore is a store recording its checkpoints and opening itself as past views
+type historyStore struct {
+	nopStore
+	checkpoints *[]string
+}
+
+func (s historyStore) Checkpoint(name string) error {
+	*s.checkpoints = append(*s.checkpoints, name)
+	return nil
+}
+
+func (s historyStore) AsOf(at time.Time) (ROStore, error) { return s, nil }
+
+func (s historyStore) AsOfCheckpoint(name string) (ROStore, error) {
+	for _, checkpoint := range *s.checkpoints {
+		if checkpoint == name {
+			return s, nil
+		}
+	}
+	return nil, ErrHistoryUnavailable
+}
+
+func TestStreamReplicaAsOf(t *testing.T) {
+	var checkpoints []string
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(0)))
+	assert.NoError(t, b.AddSinkFunc("sink", passthrough, "source"))
+	assert.NoError(t, b.AddStore("history", func() Store { return historyStore{checkpoints: &checkpoints} }))
+	assert.NoError(t, b.AddStore("nop", func() Store { return nopStore{} }))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	_, err = stream.ReplicaAsOf("nop", time.Now())
+	assert.Equal(t, ErrHistoryUnavailable, err)
+	_, err = stream.ReplicaAsOf("missing", time.Now())
+	assert.Equal(t, ErrStoreNotFound, err)
+
+	view, err := stream.ReplicaAsOf("history", time.Now())
+	assert.NoError(t, err)
+	assert.NotNil(t, view)
+
+	_, err = stream.ReplicaAsOfCheckpoint("history", "checkpoint")
+	assert.Equal(t, ErrHistoryUnavailable, err)
+
+	assert.NoError(t, stream.Checkpoint("checkpoint"))
+	assert.Equal(t, []string{"checkpoint"}, checkpoints)
+
+	view, err = stream.ReplicaAsOfCheckpoint("history", "checkpoint")
+	assert.NoError(t, err)
+	assert.NotNil(t, view)
+	assert.NoError(t, stream.Close())
+}
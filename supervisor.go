@@ -0,0 +1,196 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultRestartWindow is the default period in which processor restarts are counted
+	DefaultRestartWindow = time.Minute
+	// DefaultRestartBackoff is the default delay before the first processor restart
+	DefaultRestartBackoff = 100 * time.Millisecond
+	// DefaultRestartMaxBackoff is the default maximum delay before a processor restart
+	DefaultRestartMaxBackoff = 10 * time.Second
+)
+
+// PanicError is emitted when a processor panics while processing a record
+type PanicError struct {
+	Value interface{} // Value recovered from the panic
+	Stack []byte      // Stack trace of the panicking goroutine
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", p.Value)
+}
+
+// RestartsExceeded is emitted when a processor panics more often than its
+// restart policy allows, after which the stream sources are stopped
+type RestartsExceeded struct {
+	Node     string        // Failed node
+	Restarts int           // Maximum number of restarts
+	Window   time.Duration // Period in which restarts are counted
+}
+
+func (r *RestartsExceeded) Error() string {
+	return fmt.Sprintf("node %s exceeded %d restarts within %s", r.Node, r.Restarts, r.Window)
+}
+
+// RestartPolicy defines how the panicking processors of a node are restarted
+type RestartPolicy struct {
+	// Restarts is the maximum number of restarts within the window,
+	// or 0 to keep processing with the panicking processor instance
+	Restarts int
+	// Window is the period in which restarts are counted
+	Window time.Duration
+	// Backoff is the delay before the first restart, doubled on each
+	// restart within the window
+	Backoff time.Duration
+	// MaxBackoff bounds the delay before a restart
+	MaxBackoff time.Duration
+}
+
+// supervisor restarts the processor instances of a node which panic,
+// recreating them from the node supplier
+type supervisor struct {
+	mtx      sync.Mutex
+	policy   RestartPolicy
+	restarts int
+	since    time.Time
+}
+
+// newSupervisor creates a supervisor with the restart policy configured in
+// <stream>.<node>.supervision.restarts, window, backoff and maxbackoff.
+// It returns nil if the node processors are not restarted.
+func newSupervisor(config Config) (s *supervisor) {
+	policy := RestartPolicy{
+		Restarts:   config.Get("restarts").Int(0),
+		Window:     config.Get("window").Duration(DefaultRestartWindow),
+		Backoff:    config.Get("backoff").Duration(DefaultRestartBackoff),
+		MaxBackoff: config.Get("maxbackoff").Duration(DefaultRestartMaxBackoff),
+	}
+
+	if policy.Restarts <= 0 {
+		return nil
+	}
+	return &supervisor{policy: policy}
+}
+
+// next returns the backoff before the next restart at the given time,
+// or false if the node exceeded its restarts within the window
+func (s *supervisor) next(now time.Time) (backoff time.Duration, ok bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.restarts == 0 || now.Sub(s.since) > s.policy.Window {
+		s.restarts = 0
+		s.since = now
+	}
+
+	if s.restarts >= s.policy.Restarts {
+		return 0, false
+	}
+
+	backoff = s.policy.Backoff
+	for x := 0; x < s.restarts && backoff < s.policy.MaxBackoff; x++ {
+		backoff *= 2
+	}
+	if backoff > s.policy.MaxBackoff {
+		backoff = s.policy.MaxBackoff
+	}
+
+	s.restarts++
+	return backoff, true
+}
+
+// recoverPanic emits the recovered panic as a PanicError for the record
+func recoverPanic(ctx ProcessorContext, record Record, r interface{}) (err error) {
+	err = &PanicError{Value: r, Stack: debug.Stack()}
+	ctx.Error(err, record)
+	return err
+}
+
+// supervise restarts the context processor after a panic with the node
+// restart policy. Nodes exceeding their restarts stop the stream sources.
+func (pc *processorContext) supervise() {
+	if pc.node.supervisor == nil {
+		return
+	}
+
+	backoff, ok := pc.node.supervisor.next(time.Now())
+	if !ok {
+		policy := pc.node.supervisor.policy
+		err := &RestartsExceeded{Node: pc.node.name, Restarts: policy.Restarts, Window: policy.Window}
+		atomic.AddInt64(&pc.node.metrics.errors, 1)
+		pc.stream.handleError(Error{Node: pc.node, Error: err, Category: Permanent})
+		pc.stream.halt(err)
+		return
+	}
+
+	timer := time.NewTimer(backoff)
+	select {
+	case <-timer.C:
+	case <-pc.stream.stop.stopping():
+		timer.Stop()
+		return
+	}
+
+	if err := pc.restart(); err != nil {
+		atomic.AddInt64(&pc.node.metrics.errors, 1)
+		pc.stream.handleError(Error{Node: pc.node, Error: err, Category: CategoryOf(err)})
+	}
+}
+
+// restart replaces the context processor with a new initialized instance
+// from the node supplier, once the calls in progress return, and closes the
+// replaced instance. The current instance is kept if the new one fails to
+// initialize. The punctuators scheduled by the replaced instance are canceled.
+func (pc *processorContext) restart() (err error) {
+	processor, err := pc.node.newProcessor()
+	if err != nil {
+		return err
+	}
+
+	scheduled := pc.punct.current()
+	if initializer, ok := processor.(Initializer); ok {
+		if err = initializer.Init(pc); err != nil {
+			pc.punct.cancel(pc.punct.since(scheduled))
+			return err
+		}
+	}
+	pc.punct.cancel(scheduled)
+
+	pc.restartMtx.Lock()
+	replaced := pc.processor
+	pc.processor = processor
+	pc.handler = pc.stream.mws.handler(pc.node, processor)
+	if pc.node.pc == pc {
+		pc.node.processor = processor
+	}
+	pc.restartMtx.Unlock()
+
+	atomic.AddInt64(&pc.node.metrics.restarts, 1)
+	if closer, ok := replaced.(Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
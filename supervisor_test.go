@@ -0,0 +1,141 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorNext(t *testing.T) {
+	s := newSupervisor(NewConfig(map[string]interface{}{
+		"restarts": 3, "window": "1m", "backoff": "10ms", "maxbackoff": "25ms"}))
+	now := time.Unix(1000, 0)
+
+	for _, expected := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 25 * time.Millisecond} {
+		backoff, ok := s.next(now)
+		assert.True(t, ok)
+		assert.Equal(t, expected, backoff)
+	}
+
+	_, ok := s.next(now.Add(time.Second))
+	assert.False(t, ok)
+
+	// restarts are counted within the window
+	backoff, ok := s.next(now.Add(2 * time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Millisecond, backoff)
+
+	assert.Nil(t, newSupervisor(NewConfig(nil)))
+}
+
+// panicSink panics on records with the boom key
+type panicSink struct {
+	mtx       *sync.Mutex
+	wg        *sync.WaitGroup
+	instances *int
+	processed *[]int
+	id        int
+	closed    bool
+}
+
+func (p *panicSink) Init(pc ProcessorContext) (err error) {
+	p.mtx.Lock()
+	*p.instances++
+	p.id = *p.instances
+	p.mtx.Unlock()
+	return nil
+}
+
+func (p *panicSink) Process(pc ProcessorContext, record Record) {
+	defer p.wg.Done()
+	if key, _ := record.EncodeKey(); string(key) == "boom" {
+		panic("boom")
+	}
+
+	p.mtx.Lock()
+	*p.processed = append(*p.processed, p.id)
+	p.mtx.Unlock()
+}
+
+func (p *panicSink) Close() (err error) {
+	p.closed = true
+	return nil
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	var instances int
+	var processed []int
+	var sinks []*panicSink
+	var errs []error
+	wg.Add(4)
+
+	config := NewConfig(nil)
+	config.Set(1, "stream.sink.supervision.restarts")
+	config.Set("1ms", "stream.sink.supervision.backoff")
+
+	now := time.Now()
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{records: []Record{
+			NewRecord("", StringEncoder("a"), StringEncoder("v"), now, nil),
+			NewRecord("", StringEncoder("boom"), StringEncoder("v"), now, nil),
+			NewRecord("", StringEncoder("b"), StringEncoder("v"), now, nil),
+			NewRecord("", StringEncoder("boom"), StringEncoder("v"), now, nil),
+		}}
+	}))
+	assert.NoError(t, b.AddSink("sink", func() Processor {
+		sink := &panicSink{mtx: &mtx, wg: &wg, instances: &instances, processed: &processed}
+		sinks = append(sinks, sink)
+		return sink
+	}, "source"))
+	b.ErrorHandler(ErrorHandlerFunc(func(e Error) {
+		mtx.Lock()
+		errs = append(errs, e.Error)
+		mtx.Unlock()
+	}))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+
+	// the second panic exceeds the restarts and stops the stream sources
+	for x := 0; x < 1000 && stream.Err() == nil; x++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.NoError(t, stream.Close())
+
+	// the restarted instance processes the records after the panic
+	assert.Equal(t, []int{1, 2}, processed)
+	assert.Len(t, sinks, 2)
+	assert.True(t, sinks[0].closed)
+	assert.True(t, sinks[1].closed)
+
+	assert.Len(t, errs, 3)
+	assert.EqualError(t, errs[0], "panic: boom")
+	_, ok := errs[0].(*PanicError)
+	assert.True(t, ok)
+	assert.EqualError(t, errs[2], "node sink exceeded 1 restarts within 1m0s")
+	_, ok = stream.Err().(*RestartsExceeded)
+	assert.True(t, ok)
+}
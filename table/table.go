@@ -0,0 +1,234 @@
+package table
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+// Materialization modes
+const (
+	// Last keeps the last value received per key
+	Last = "last"
+	// First keeps the first value received per key
+	First = "first"
+	// Max keeps the value with the latest record time per key
+	Max = "max"
+	// Min keeps the value with the earliest record time per key
+	Min = "min"
+)
+
+var (
+	errInvalidMode = streams.Errorf(streams.CodeConfig, "invalid materialization mode")
+	errInvalidKey  = streams.Errorf(streams.CodeRecord, "invalid record key")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Table)(nil)
+var _ streams.Processor = (*Table)(nil)
+
+// Table materializes record values per key into a named store according to
+// the mode set with <stream>.<node>.mode: last (default), first, max or min.
+// The max and min modes compare record times, kept in the store named by
+// <stream>.<node>.timestore if set, or in memory otherwise.
+// Records without a value delete the key. With the <stream>.<node>.changes
+// config every update forwards a change record with the store name as topic,
+// the record key and time and the new value, nil for deletes.
+type Table struct {
+	store     string
+	mode      string
+	changes   bool
+	db        streams.Store
+	timestore streams.Store
+	times     map[string]time.Time
+}
+
+// Supplier for a Table materializing into the named store with the given mode
+func Supplier(store, mode string) streams.ProcessorSupplier {
+	return func() (processor streams.Processor) {
+		return &Table{store: store, mode: mode}
+	}
+}
+
+// Init the table
+func (t *Table) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	t.changes = config.Get("changes").Bool(false)
+
+	if t.mode = config.Get("mode").String(t.mode); t.mode == "" {
+		t.mode = Last
+	}
+
+	switch t.mode {
+	case Last, First, Max, Min:
+	default:
+		return errInvalidMode
+	}
+
+	if t.db, err = pc.Store(t.store); err != nil {
+		return err
+	}
+
+	if name := config.Get("timestore").String(""); name != "" {
+		if t.timestore, err = pc.Store(name); err != nil {
+			return err
+		}
+	}
+
+	t.times = make(map[string]time.Time)
+	return nil
+}
+
+// Process updates the table with the record
+func (t *Table) Process(pc streams.ProcessorContext, record streams.Record) {
+	key, err := record.EncodeKey()
+	if err != nil || len(key) == 0 {
+		pc.Error(errInvalidKey, record)
+		return
+	}
+
+	if record.Value == nil {
+		if err = t.delete(key); err != nil {
+			pc.Error(err, record)
+			return
+		}
+		t.change(pc, record, nil)
+		return
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	current, err := t.db.Get(key)
+	exists := err == nil
+	if err != nil && err != streams.ErrKeyNotFound {
+		pc.Error(err, record)
+		return
+	}
+
+	update, err := t.update(key, exists, record.Time)
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	if !update {
+		return
+	}
+
+	changed := !exists || !bytes.Equal(current, value)
+	if changed {
+		if err = t.db.Set(key, value); err != nil {
+			pc.Error(err, record)
+			return
+		}
+	}
+
+	if t.mode == Max || t.mode == Min {
+		if err = t.setTime(key, record.Time); err != nil {
+			pc.Error(err, record)
+			return
+		}
+	}
+
+	if changed {
+		t.change(pc, record, streams.ByteEncoder(value))
+	}
+}
+
+// update returns if the key must be updated with a value of the given time
+func (t *Table) update(key []byte, exists bool, ts time.Time) (update bool, err error) {
+	if !exists {
+		return true, nil
+	}
+
+	switch t.mode {
+	case First:
+		return false, nil
+	case Last:
+		return true, nil
+	}
+
+	current, ok, err := t.time(key)
+	if err != nil || !ok {
+		return true, err
+	}
+
+	if t.mode == Max {
+		return ts.After(current), nil
+	}
+	return ts.Before(current), nil
+}
+
+// change forwards the change record if enabled
+func (t *Table) change(pc streams.ProcessorContext, record streams.Record, value streams.Encoder) {
+	if !t.changes {
+		return
+	}
+
+	change := record
+	change.Topic = t.store
+	change.Value = value
+	pc.Forward(change)
+}
+
+// delete the key and its time
+func (t *Table) delete(key []byte) (err error) {
+	delete(t.times, string(key))
+	if t.timestore != nil {
+		if err = t.timestore.Delete(key); err != nil {
+			return err
+		}
+	}
+	return t.db.Delete(key)
+}
+
+// time returns the record time of the key value
+func (t *Table) time(key []byte) (ts time.Time, ok bool, err error) {
+	if t.timestore == nil {
+		ts, ok = t.times[string(key)]
+		return ts, ok, nil
+	}
+
+	value, err := t.timestore.Get(key)
+	if err == streams.ErrKeyNotFound {
+		return ts, false, nil
+	}
+	if err != nil || len(value) != 8 {
+		return ts, false, err
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(value))), true, nil
+}
+
+// setTime sets the record time of the key value
+func (t *Table) setTime(key []byte, ts time.Time) (err error) {
+	if t.timestore == nil {
+		t.times[string(key)] = ts
+		return nil
+	}
+
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(ts.UnixNano()))
+	return t.timestore.Set(key, value)
+}
@@ -0,0 +1,81 @@
+package table
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/brunotm/streams/store/sharded"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTable(t *testing.T) {
+	now := time.Now()
+
+	for mode, expected := range map[string]string{
+		Last:  "3",
+		First: "1",
+		Max:   "2",
+		Min:   "3",
+	} {
+		db := sharded.Supplier()
+		assert.NoError(t, db.(streams.Initializer).Init(&mock.Context{}))
+
+		config := streams.NewConfig(nil)
+		config.Set(true, "stream.table.changes")
+		pc := &mock.Context{Data: mock.ContextData{
+			Active:     true,
+			StreamName: "stream",
+			NodeName:   "table",
+			Config:     config,
+			Store:      db,
+		}}
+
+		table := Supplier("table", mode)()
+		assert.NoError(t, table.(streams.Initializer).Init(pc))
+
+		for _, r := range []struct {
+			value string
+			ts    time.Time
+		}{
+			{"1", now},
+			{"2", now.Add(time.Second)},
+			{"3", now.Add(-time.Second)},
+		} {
+			table.Process(pc, streams.NewRecord("test", streams.StringEncoder("a"),
+				streams.StringEncoder(r.value), r.ts, nil))
+		}
+
+		value, err := db.Get([]byte("a"))
+		assert.NoError(t, err, mode)
+		assert.Equal(t, expected, string(value), mode)
+
+		last := pc.Data.Forwarded[len(pc.Data.Forwarded)-1]
+		changed, _ := last.EncodeValue()
+		assert.Equal(t, expected, string(changed), mode)
+		assert.Equal(t, "table", last.Topic)
+
+		// records without value delete the key
+		table.Process(pc, streams.NewRecord("test", streams.StringEncoder("a"), nil, now, nil))
+		_, err = db.Get([]byte("a"))
+		assert.Equal(t, streams.ErrKeyNotFound, err)
+		assert.Nil(t, pc.Data.Forwarded[len(pc.Data.Forwarded)-1].Value)
+	}
+}
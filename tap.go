@@ -0,0 +1,196 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errInvalidTapRate = Errorf(CodeConfig, "tap rate must be within (0, 1]")
+)
+
+// TapSink receives the records mirrored by a tap
+type TapSink interface {
+	Tap(node string, record Record)
+}
+
+// TapFunc is a function TapSink
+type TapFunc func(node string, record Record)
+
+// Tap the record
+func (f TapFunc) Tap(node string, record Record) {
+	f(node, record)
+}
+
+// tap mirrors a sample of the records processed by a node
+type tap struct {
+	rate  float64
+	sink  TapSink
+	count uint64
+}
+
+// sampled returns if the current record is within the tap sample rate.
+// The first record is always sampled.
+func (t *tap) sampled() (ok bool) {
+	c := float64(atomic.AddUint64(&t.count, 1))
+	return math.Ceil(c*t.rate) > math.Ceil((c-1)*t.rate)
+}
+
+// Tap mirrors a sampled copy of the records processed by the named node, or
+// forwarded by the named source, to the sink for live pipeline debugging.
+// A rate of 1 mirrors all records.
+// Taps can be added and removed at any time with the returned untap function.
+// The sink is called within the node processing and must not block.
+func (s *Stream) Tap(name string, rate float64, sink TapSink) (untap func(), err error) {
+	if rate <= 0 || rate > 1 {
+		return nil, errInvalidTapRate
+	}
+
+	node := s.topology.getNode(name)
+	if node == nil {
+		return nil, ErrNodeNotFound
+	}
+
+	t := &tap{rate: rate, sink: sink}
+	node.setTaps(func(taps []*tap) []*tap {
+		return append(taps, t)
+	})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			node.setTaps(func(taps []*tap) (updated []*tap) {
+				for _, current := range taps {
+					if current != t {
+						updated = append(updated, current)
+					}
+				}
+				return updated
+			})
+		})
+	}, nil
+}
+
+// setTaps replaces the node taps with the updated copy of the current taps
+func (n *Node) setTaps(update func([]*tap) []*tap) {
+	n.tapsMtx.Lock()
+	defer n.tapsMtx.Unlock()
+
+	current, _ := n.taps.Load().([]*tap)
+	n.taps.Store(update(append([]*tap(nil), current...)))
+}
+
+// tap mirrors the record to the node taps
+func (n *Node) tap(record Record) {
+	taps, _ := n.taps.Load().([]*tap)
+	for _, t := range taps {
+		if t.sampled() {
+			t.sink.Tap(n.name, record)
+		}
+	}
+}
+
+// tapRecord is the JSON representation of a tapped record
+type tapRecord struct {
+	Node  string    `json:"node"`
+	Topic string    `json:"topic"`
+	Key   string    `json:"key,omitempty"`
+	Value string    `json:"value,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+func newTapRecord(node string, record Record) (tr tapRecord) {
+	key, _ := record.EncodeKey()
+	value, _ := record.EncodeValue()
+	return tapRecord{Node: node, Topic: record.Topic, Key: string(key), Value: string(value), Time: record.Time}
+}
+
+// NewWriterTap creates a TapSink writing the tapped records as JSON lines
+// to the given writer, such as os.Stdout or a file.
+func NewWriterTap(w io.Writer) (sink TapSink) {
+	var mtx sync.Mutex
+	enc := json.NewEncoder(w)
+
+	return TapFunc(func(node string, record Record) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		enc.Encode(newTapRecord(node, record))
+	})
+}
+
+// TapHandler returns a http.Handler streaming the records tapped from the
+// node and rate given in the query parameters as server-sent events, until
+// the client disconnects. Records are dropped if the client can't keep up.
+func TapHandler(s *Stream) (handler http.Handler) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		rate := 1.0
+		if q := r.URL.Query().Get("rate"); q != "" {
+			var err error
+			if rate, err = strconv.ParseFloat(q, 64); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		records := make(chan tapRecord, 64)
+		untap, err := s.Tap(r.URL.Query().Get("node"), rate, TapFunc(func(node string, record Record) {
+			select {
+			case records <- newTapRecord(node, record):
+			default:
+			}
+		}))
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer untap()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case record := <-records:
+				data, _ := json.Marshal(record)
+				if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamTap(t *testing.T) {
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(10)
+
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: testRecords(10), gate: release}
+	}))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		wg.Done()
+	}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	_, err = stream.Tap("unknown", 1, nil)
+	assert.Equal(t, ErrNodeNotFound, err)
+	_, err = stream.Tap("sink", 0, nil)
+	assert.Error(t, err)
+
+	var buf bytes.Buffer
+	untapAll, err := stream.Tap("sink", 1, NewWriterTap(&buf))
+	assert.NoError(t, err)
+
+	var mtx sync.Mutex
+	var sampled []string
+	untapHalf, err := stream.Tap("source", 0.5, TapFunc(func(node string, record Record) {
+		v, _ := record.EncodeValue()
+		mtx.Lock()
+		sampled = append(sampled, node+":"+string(v))
+		mtx.Unlock()
+	}))
+	assert.NoError(t, err)
+
+	close(release)
+	wg.Wait()
+	untapAll()
+	untapAll()
+	untapHalf()
+	assert.NoError(t, stream.Close())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 10)
+
+	var tr tapRecord
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &tr))
+	assert.Equal(t, "sink", tr.Node)
+	assert.Equal(t, "0", tr.Value)
+	assert.Equal(t, []string{"source:0", "source:2", "source:4", "source:6", "source:8"}, sampled)
+}
+
+func TestTapHandler(t *testing.T) {
+	release := make(chan struct{})
+	b := NewBuilder("stream", NewConfig(nil))
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{next: testRecords(1), gate: release}
+	}))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {}, "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	server := httptest.NewServer(TapHandler(stream))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "?node=unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = server.Client().Get(server.URL + "?node=sink")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	close(release)
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, `data: {"node":"sink","topic":"test"`), line)
+	assert.NoError(t, stream.Close())
+}
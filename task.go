@@ -17,84 +17,568 @@ package streams
 */
 
 import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 
+	"github.com/brunotm/streams/types"
 	"github.com/dgryski/go-jump"
 )
 
-// tasks are dedicated concurrent tasks and buffers for each source or processor
-// node that has successors nodes which are the ones allowed to forward in the topology.
-// Each task consists of a goroutine and buffer pair to which the forward requests
-// from that node are routed to. Ordered processing of records per multiple goroutines
-// are guaranteed by using a consistent hash with the the record.id and number of tasks, for
-// assigning records with same id to the same task. record ids are generated by hashing the
+// tasks are dedicated concurrent tasks for a node. Each task of a processor
+// or sink consists of a goroutine and buffer pair to which the records delivered
+// to the node are routed to, and its own processor instance and context created
+// from the node supplier, making stateful per task processors safe.
+// Ordered processing of records per multiple goroutines are guaranteed by using
+// a consistent hash with the the record.id and number of tasks, for assigning
+// records with same id to the same task. record ids are generated by hashing the
 // encoded key or lately value of a record.
+// Each task of a source is a consumer with its own source instance and context.
 type nodeTasks map[*Node]*tasks
 
 type tasks struct {
 	sync.RWMutex
-	buffers []chan Record
+	resizing   sync.Mutex
+	wg         sync.WaitGroup
+	stream     *Stream
+	node       *Node
+	buffer     int
+	priority   int
+	buffers    []chan Record
+	swaps      []chan chan Record
+	overflows  []*overflow
+	queues     []*priorityQueue
+	fairs      []*fairQueue
+	contexts   []*processorContext
+	partitions []string
+	overflow   overflowConfig
+	adaptive   bool
+	recordSize int64
+	handoff    *handoff
 }
 
-// forwardFrom forwards the given record to the given node successors.
-// If the node has associated tasks, forward the record to the appropriate one.
-func (nt nodeTasks) forwardFrom(from *Node, record Record) {
-	st := nt[from]
+// overflowConfig enables spilling of records to disk when a task buffer is full.
+// Each task spills to its own directory within path.
+type overflowConfig struct {
+	path        string
+	segmentSize int64
+}
+
+func newTasks(s *Stream, node *Node) (t *tasks) {
+	t = &tasks{}
+	t.stream = s
+	t.node = node
+	node.tasks = t
+	return t
+}
 
-	// TODO: rework locking strategy for subtasks
-	st.RLock()
-	if buckets := len(st.buffers); buckets > 0 {
+// deliver the record to the node task responsible for the record id.
+// It returns false if the node has no tasks.
+func (t *tasks) deliver(record Record) (ok bool) {
+	t.RLock()
+	defer t.RUnlock()
+
+	if buckets := len(t.buffers); buckets > 0 {
 		// Ensure we always process records with same keys within the same task
-		st.buffers[jump.Hash(record.id, buckets)] <- record
-		st.RUnlock()
-		return
+		idx := jump.Hash(record.id, buckets)
+		if t.handoff != nil && t.handoff.hold(idx, record) {
+			return true
+		}
+
+		t.push(idx, record)
+		return true
+	}
+
+	return false
+}
+
+// broadcast the record to all node tasks, retaining a record reference
+// for each task. It returns false if the node has no tasks.
+func (t *tasks) broadcast(record Record) (ok bool) {
+	t.RLock()
+	defer t.RUnlock()
+
+	if len(t.buffers) == 0 {
+		return false
 	}
-	st.RUnlock()
 
-	// if node has no tasks
-	from.forward(record)
+	if record.ack != nil {
+		record.ack.retain(len(t.buffers))
+	}
+
+	for idx := range t.buffers {
+		t.push(int32(idx), record)
+	}
+	return true
 }
 
-func (nt nodeTasks) forwardTo(to string, record Record) (err error) {
-	// TODO: need a map[node.name]node
-	for node := range nt {
-		if node.name == to {
-			node.pc.activate()
-			node.processor.Process(node.pc, record)
-			node.pc.deactivate()
-			return nil
+// push the record to the task with the given index.
+// Records with a positive priority are pushed to the task priority queue,
+// and other records to the task fair queue if the node has topic weights.
+// If the task buffer is full and the task has an overflow, the record is
+// spilled to disk instead of blocking. Once a task has spilled records, newer
+// records are also spilled until the overflow is drained to preserve ordering.
+// Records held in memory count towards the stream memory budget.
+func (t *tasks) push(idx int32, record Record) {
+	t.stream.limits.hold(record)
+	atomic.AddInt64(&t.contexts[idx].pushed, 1)
+
+	if record.Priority > 0 {
+		t.queues[idx].push(record)
+		return
+	}
+
+	if fq := t.fairs[idx]; fq != nil {
+		fq.push(record)
+		return
+	}
+
+	if t.adaptive {
+		t.sampleSize(record)
+	}
+
+	of := t.overflows[idx]
+	if of == nil {
+		t.buffers[idx] <- record
+		return
+	}
+
+	if of.len() == 0 {
+		select {
+		case t.buffers[idx] <- record:
+			return
+		default:
 		}
 	}
 
-	return errNodeNotFound
+	if err := of.push(record); err != nil {
+		// Block on the task buffer if we cannot spill
+		t.buffers[idx] <- record
+		return
+	}
+
+	// Spilled records are not held in memory
+	t.stream.limits.release(record)
 }
 
-// setScale scales the number of tasks to the given scale
-func (nt nodeTasks) setScale(node *Node, scale, buffer int) {
-	st := nt[node]
+// setScale scales the number of tasks of the node to the given scale.
+// Tasks removed by a downscale are stopped after processing their
+// buffered records.
+func (nt nodeTasks) setScale(node *Node, scale int) (err error) {
+	st, exists := nt[node]
+	if !exists {
+		return nil
+	}
+
+	if scale < 0 {
+		scale = 0
+	}
+
 	st.Lock()
-	defer st.RUnlock()
+	defer st.Unlock()
+
+	if node.typ == types.Source {
+		return st.scaleSource(scale)
+	}
+
+	// Wait for the keys moved by a previous rescale to be handed off
+	for st.handoff != nil {
+		h := st.handoff
+		st.Unlock()
+		<-h.done
+		st.Lock()
+		if st.handoff == h {
+			st.handoff = nil
+		}
+	}
 
 	currScale := len(st.buffers)
 
-	// Increase the number of tasks for the given node.
-	// a scale of 1 only adds a buffer with no scale.
+	// Scale-ups beyond the stream task budget are refused
 	if scale > currScale {
-		for ; scale > currScale; currScale++ {
-			task := make(chan Record, buffer)
-			st.buffers = append(st.buffers, task)
-			go func() {
-				for record := range task {
-					node.forward(record)
-				}
-			}()
+		if err = st.stream.limits.acquireTasks(scale - currScale); err != nil {
+			return err
 		}
+	} else {
+		st.stream.limits.releaseTasks(currScale - scale)
+	}
+
+	// The keys assigned to each task change on rescale, once the
+	// first task is added or removed
+	prevScale := currScale
+	rescale := func() {
+		for _, pc := range st.contexts {
+			pc.invalidate()
+		}
+		st.handoff = newHandoff(st.contexts, scale)
+	}
+
+	// Increase the number of tasks for the given node. Failures keep the
+	// tasks already added, releasing the budget of the remaining ones.
+	for ; scale > currScale; currScale++ {
+		pc, err := node.instance(st.stream, currScale)
+		if err != nil {
+			st.stream.limits.releaseTasks(scale - currScale)
+			return err
+		}
+
+		var of *overflow
+		if st.overflow.path != "" {
+			dir := filepath.Join(st.overflow.path, node.name, strconv.Itoa(currScale))
+			if of, err = newOverflow(dir, st.overflow.segmentSize); err != nil {
+				st.stream.limits.releaseTasks(scale - currScale)
+				return err
+			}
+		}
+
+		if currScale == prevScale {
+			rescale()
+		}
+
+		task := make(chan Record, st.buffer)
+		swap := make(chan chan Record, 1)
+		pq := newPriorityQueue(st.priority)
+
+		var fq *fairQueue
+		if node.weights != nil {
+			fq = newFairQueue(st.buffer, node.weights)
+		}
+
+		st.buffers = append(st.buffers, task)
+		st.swaps = append(st.swaps, swap)
+		st.overflows = append(st.overflows, of)
+		st.queues = append(st.queues, pq)
+		st.fairs = append(st.fairs, fq)
+		st.contexts = append(st.contexts, pc)
+
+		st.wg.Add(1)
+		go func() {
+			defer st.wg.Done()
+			runTask(pc, task, swap, of, pq, fq)
+		}()
 	}
 
 	if scale < currScale {
-		for ; scale < currScale; currScale-- {
-			close(st.buffers[currScale-1])
-			st.buffers = st.buffers[:currScale-1]
+		rescale()
+	}
+
+	for ; scale < currScale; currScale-- {
+		close(st.buffers[currScale-1])
+		st.buffers = st.buffers[:currScale-1]
+		st.swaps = st.swaps[:currScale-1]
+		st.overflows = st.overflows[:currScale-1]
+		st.queues = st.queues[:currScale-1]
+		st.fairs = st.fairs[:currScale-1]
+		st.contexts = st.contexts[:currScale-1]
+	}
+
+	return nil
+}
+
+// startSource starts consuming from the source node instance as its first task
+func (t *tasks) startSource(pc *processorContext) (err error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if ps, ok := pc.processor.(PartitionedSource); ok {
+		if t.partitions, err = ps.Partitions(); err != nil {
+			return err
+		}
+		sort.Strings(t.partitions)
+	}
+
+	if err = t.stream.limits.acquireTasks(1); err != nil {
+		return err
+	}
+
+	t.contexts = append(t.contexts, pc)
+	return t.rebalance([]*processorContext{pc})
+}
+
+// scaleSource scales the number of source consumers. Each consumer runs with
+// its own source instance and context, and is stopped by closing its source.
+// Partitioned sources are not scaled beyond their number of partitions.
+func (t *tasks) scaleSource(scale int) (err error) {
+	if t.partitions != nil && scale > len(t.partitions) {
+		scale = len(t.partitions)
+	}
+
+	var started []*processorContext
+	currScale := len(t.contexts)
+
+	// Scale-ups beyond the stream task budget are refused
+	if scale > currScale {
+		if err = t.stream.limits.acquireTasks(scale - currScale); err != nil {
+			return err
+		}
+	} else {
+		t.stream.limits.releaseTasks(currScale - scale)
+	}
+
+	// Failures keep the consumers already added, releasing the budget
+	// of the remaining ones
+	for ; scale > currScale; currScale++ {
+		pc, err := t.node.instance(t.stream, currScale)
+		if err != nil {
+			t.stream.limits.releaseTasks(scale - currScale)
+			t.rebalance(started)
+			return err
+		}
+
+		t.contexts = append(t.contexts, pc)
+		started = append(started, pc)
+	}
+
+	for ; scale < currScale; currScale-- {
+		pc := t.contexts[currScale-1]
+		t.contexts = t.contexts[:currScale-1]
+		pc.deactivate()
+		pc.stop()
+
+		if closer, ok := pc.processor.(Closer); ok {
+			if err = closer.Close(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return t.rebalance(started)
+}
+
+// rebalance assigns the partitions of a partitioned source among all of
+// its tasks and starts consuming with the given source task contexts.
+func (t *tasks) rebalance(start []*processorContext) (err error) {
+	if t.partitions != nil && len(t.contexts) > 0 {
+		assignments := assignPartitions(t.partitions, len(t.contexts))
+		for x, pc := range t.contexts {
+			if err = pc.processor.(PartitionedSource).Assign(assignments[x]); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, pc := range start {
+		pc.activate()
+		go pc.processor.(Source).Consume(pc)
+	}
+
+	return nil
+}
+
+// assignPartitions distributes the partitions among the given number of tasks
+func assignPartitions(partitions []string, tasks int) (assignments [][]string) {
+	assignments = make([][]string, tasks)
+	for x, partition := range partitions {
+		assignments[x%tasks] = append(assignments[x%tasks], partition)
+	}
+	return assignments
+}
+
+// scale returns the current number of tasks
+func (t *tasks) scale() (scale int) {
+	t.RLock()
+	defer t.RUnlock()
+
+	if t.node.typ == types.Source {
+		return len(t.contexts)
+	}
+	return len(t.buffers)
+}
+
+// wait for all stopped tasks to finish processing their records
+func (t *tasks) wait() {
+	t.wg.Wait()
+}
+
+// sampleSize updates the moving average of the record size in bytes
+func (t *tasks) sampleSize(record Record) {
+	size := recordSize(record)
+
+	avg := atomic.LoadInt64(&t.recordSize)
+	if avg == 0 {
+		atomic.StoreInt64(&t.recordSize, size)
+		return
+	}
+	atomic.StoreInt64(&t.recordSize, avg+(size-avg)/8)
+}
+
+// avgRecordSize returns the average size in bytes of records sent to the tasks
+func (t *tasks) avgRecordSize() (size int64) {
+	return atomic.LoadInt64(&t.recordSize)
+}
+
+// resize the buffer of the task with the given index.
+// The new buffer is handed to the task before closing the current one,
+// so the task drains the current buffer before switching. Resizes are
+// serialized, and the swap is handed without holding the tasks lock,
+// as it waits for the task to take the swap of a previous resize.
+func (t *tasks) resize(idx, size int) {
+	t.resizing.Lock()
+	defer t.resizing.Unlock()
+
+	t.Lock()
+	if idx >= len(t.buffers) || cap(t.buffers[idx]) == size {
+		t.Unlock()
+		return
+	}
+
+	current := t.buffers[idx]
+	next := make(chan Record, size)
+	swap := t.swaps[idx]
+	t.buffers[idx] = next
+	t.Unlock()
+
+	swap <- next
+	close(current)
+}
+
+// runTask processes the records sent to the task priority queue, buffer and
+// overflow until the task buffer is closed. Records in the priority queue are
+// served first, followed by the records in the fair queue. Buffered records are always older than the ones in the
+// overflow, so the buffer is drained before reading the overflow.
+// A closed task buffer with a pending swap is replaced by the swapped buffer.
+// The task processor is closed once the task finishes.
+func runTask(pc *processorContext, task chan Record, swap chan chan Record, of *overflow, pq *priorityQueue, fq *fairQueue) {
+	var notify, fair chan struct{}
+	if of != nil {
+		notify = of.notify
+	}
+	if fq != nil {
+		fair = fq.notify
+	}
+
+	defer closeTask(pc, of, pq, fq)
+
+	for {
+		if record, ok := pq.pop(); ok {
+			processBuffered(pc, record)
+			continue
+		}
+
+		if fq != nil {
+			if record, ok := fq.pop(); ok {
+				processBuffered(pc, record)
+				continue
+			}
+		}
+
+		select {
+		case record, ok := <-task:
+			if !ok {
+				if task = swapBuffer(swap); task != nil {
+					continue
+				}
+				return
+			}
+			processBuffered(pc, record)
+			continue
+		default:
+		}
+
+		if of != nil {
+			record, ok, err := of.pop()
+			if err != nil {
+				pc.Error(err)
+			}
+			if ok {
+				processSpilled(pc, record)
+				continue
+			}
+			if errors.Is(err, errSpillCorrupt) {
+				pc.drained()
+				continue
+			}
+		}
+
+		select {
+		case record, ok := <-task:
+			if !ok {
+				if task = swapBuffer(swap); task != nil {
+					continue
+				}
+				return
+			}
+			processBuffered(pc, record)
+		case <-notify:
+		case <-pq.notify:
+		case <-fair:
+		}
+	}
+}
+
+// processBuffered processes a record taken from the task memory buffers,
+// releasing its bytes from the stream memory budget
+func processBuffered(pc *processorContext, record Record) {
+	pc.stream.limits.release(record)
+	pc.process(record)
+	pc.drained()
+}
+
+// processSpilled processes a record taken from the task overflow
+func processSpilled(pc *processorContext, record Record) {
+	pc.process(record)
+	pc.drained()
+}
+
+// swapBuffer returns the pending task buffer swap or nil if there is none
+func swapBuffer(swap chan chan Record) (task chan Record) {
+	select {
+	case task = <-swap:
+		return task
+	default:
+		return nil
+	}
+}
+
+// closeTask processes all remaining records in the task priority queue, fair
+// queue and overflow, closes the overflow and the task processor.
+func closeTask(pc *processorContext, of *overflow, pq *priorityQueue, fq *fairQueue) {
+	for {
+		record, ok := pq.pop()
+		if !ok {
+			break
+		}
+		processBuffered(pc, record)
+	}
+
+	for fq != nil {
+		record, ok := fq.pop()
+		if !ok {
+			break
+		}
+		processBuffered(pc, record)
+	}
+
+	if of != nil {
+		for {
+			record, ok, err := of.pop()
+			if err != nil {
+				pc.Error(err)
+			}
+			if errors.Is(err, errSpillCorrupt) {
+				pc.drained()
+				continue
+			}
+			if !ok {
+				break
+			}
+			processSpilled(pc, record)
+		}
+
+		if err := of.close(); err != nil {
+			pc.Error(err)
+		}
+	}
+
+	pc.invalidate()
+	pc.stop()
+
+	if closer, ok := pc.processor.(Closer); ok {
+		if err := closer.Close(); err != nil {
+			pc.Error(err)
 		}
 	}
 }
@@ -0,0 +1,286 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTasksResize(t *testing.T) {
+	received := make(chan int, 100)
+	supplier := ProcessorSupplier(func() Processor {
+		return ProcessorFunc(func(pc ProcessorContext, record Record) {
+			v, _ := record.EncodeValue()
+			n, _ := strconv.Atoi(string(v))
+			received <- n
+		})
+	})
+
+	sink := &Node{name: "sink", typ: types.Sink, supplier: supplier}
+	nt := nodeTasks{sink: newTasks(&Stream{}, sink)}
+	nt[sink].adaptive = true
+	nt[sink].buffer = 4
+	assert.NoError(t, nt.setScale(sink, 1))
+
+	for x := 0; x < 100; x++ {
+		if x%10 == 0 {
+			nt[sink].resize(0, x/10+1)
+		}
+		value := StringEncoder(strconv.Itoa(x))
+		sink.receive(NewRecord("topic", nil, value, time.Now(), nil))
+	}
+
+	for x := 0; x < 100; x++ {
+		select {
+		case n := <-received:
+			assert.Equal(t, x, n)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for records")
+		}
+	}
+
+	assert.Equal(t, 10, cap(nt[sink].buffers[0]))
+	assert.True(t, nt[sink].avgRecordSize() > 0)
+	assert.NoError(t, nt.setScale(sink, 0))
+	nt[sink].wait()
+}
+
+func TestTasksResizePending(t *testing.T) {
+	gate := make(chan struct{})
+	received := make(chan int, 10)
+	supplier := ProcessorSupplier(func() Processor {
+		return ProcessorFunc(func(pc ProcessorContext, record Record) {
+			<-gate
+			v, _ := record.EncodeValue()
+			n, _ := strconv.Atoi(string(v))
+			received <- n
+		})
+	})
+
+	sink := &Node{name: "sink", typ: types.Sink, supplier: supplier}
+	nt := nodeTasks{sink: newTasks(&Stream{}, sink)}
+	nt[sink].adaptive = true
+	nt[sink].buffer = 4
+	assert.NoError(t, nt.setScale(sink, 1))
+
+	send := func(x int) {
+		sink.receive(NewRecord("topic", nil, StringEncoder(strconv.Itoa(x)), time.Now(), nil))
+	}
+
+	// resizes while the task has not taken a previous swap
+	// do not block the delivery of records
+	send(0)
+	nt[sink].resize(0, 2)
+	resized := make(chan struct{})
+	go func() {
+		nt[sink].resize(0, 3)
+		close(resized)
+	}()
+
+	for x := 0; x < 1000; x++ {
+		nt[sink].RLock()
+		size := cap(nt[sink].buffers[0])
+		nt[sink].RUnlock()
+		if size == 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	delivered := make(chan struct{})
+	go func() {
+		for x := 1; x < 4; x++ {
+			send(x)
+		}
+		close(delivered)
+	}()
+
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("delivery blocked by a pending resize")
+	}
+
+	close(gate)
+	<-resized
+	for x := 0; x < 4; x++ {
+		select {
+		case n := <-received:
+			assert.Equal(t, x, n)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for records")
+		}
+	}
+
+	assert.Equal(t, 3, cap(nt[sink].buffers[0]))
+	assert.NoError(t, nt.setScale(sink, 0))
+	nt[sink].wait()
+}
+
+func TestTasksPriority(t *testing.T) {
+	gate := make(chan struct{})
+	blocked := make(chan struct{})
+	received := make(chan string, 10)
+	supplier := ProcessorSupplier(func() Processor {
+		return ProcessorFunc(func(pc ProcessorContext, record Record) {
+			v, _ := record.EncodeValue()
+			if string(v) == "block" {
+				close(blocked)
+				<-gate
+			}
+			received <- string(v)
+		})
+	})
+
+	sink := &Node{name: "sink", typ: types.Sink, supplier: supplier}
+	nt := nodeTasks{sink: newTasks(&Stream{}, sink)}
+	nt[sink].buffer = 10
+	assert.NoError(t, nt.setScale(sink, 1))
+
+	send := func(value string, priority int) {
+		record := NewRecord("topic", StringEncoder("key"), StringEncoder(value), time.Now(), nil)
+		record.Priority = priority
+		sink.receive(record)
+	}
+
+	// block the task while queueing bulk and priority records
+	send("block", 0)
+	<-blocked
+	send("bulk-0", 0)
+	send("bulk-1", 0)
+	send("low", 1)
+	send("high", 5)
+	close(gate)
+
+	expected := []string{"block", "high", "low", "bulk-0", "bulk-1"}
+	for _, value := range expected {
+		select {
+		case v := <-received:
+			assert.Equal(t, value, v)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for records")
+		}
+	}
+
+	assert.NoError(t, nt.setScale(sink, 0))
+	nt[sink].wait()
+}
+
+func TestPriorityQueueCapacity(t *testing.T) {
+	pq := newPriorityQueue(2)
+	for x := 0; x < 2; x++ {
+		record := NewRecord("topic", nil, StringEncoder(strconv.Itoa(x)), time.Now(), nil)
+		record.Priority = 1
+		pq.push(record)
+	}
+
+	// pushing to a full queue blocks until a record is served
+	pushed := make(chan struct{})
+	go func() {
+		record := NewRecord("topic", nil, StringEncoder("2"), time.Now(), nil)
+		record.Priority = 1
+		pq.push(record)
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("push to a full queue not blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	for x := 0; x < 3; x++ {
+		record, ok := pq.pop()
+		for !ok {
+			<-pq.notify
+			record, ok = pq.pop()
+		}
+		v, _ := record.EncodeValue()
+		assert.Equal(t, strconv.Itoa(x), string(v))
+	}
+	<-pushed
+	assert.Equal(t, 0, pq.len())
+}
+
+func TestTasksHandoff(t *testing.T) {
+	gate := make(chan struct{})
+	blocked := make(chan struct{})
+	received := make(chan string, 100)
+	supplier := ProcessorSupplier(func() Processor {
+		return ProcessorFunc(func(pc ProcessorContext, record Record) {
+			v, _ := record.EncodeValue()
+			if string(v) == "block" {
+				close(blocked)
+				<-gate
+				return
+			}
+			received <- string(v)
+		})
+	})
+
+	sink := &Node{name: "sink", typ: types.Sink, supplier: supplier}
+	nt := nodeTasks{sink: newTasks(&Stream{}, sink)}
+	nt[sink].buffer = 100
+	assert.NoError(t, nt.setScale(sink, 1))
+
+	send := func(key, value string) {
+		sink.receive(NewRecord("topic", StringEncoder(key), StringEncoder(value), time.Now(), nil))
+	}
+
+	// block the single task with records buffered for all keys
+	send("block", "block")
+	<-blocked
+	for x := 0; x < 20; x++ {
+		send(strconv.Itoa(x), strconv.Itoa(x)+"-0")
+	}
+
+	// records of keys moved to the new tasks are held until the first task drains
+	assert.NoError(t, nt.setScale(sink, 4))
+	for x := 0; x < 20; x++ {
+		send(strconv.Itoa(x), strconv.Itoa(x)+"-1")
+	}
+
+	select {
+	case v := <-received:
+		t.Fatalf("record %s processed before the handoff", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(gate)
+
+	seen := make(map[string]bool)
+	for x := 0; x < 40; x++ {
+		select {
+		case v := <-received:
+			key, seq := v[:len(v)-2], v[len(v)-1:]
+			if seq == "1" {
+				assert.True(t, seen[key], "key %s out of order", key)
+			}
+			seen[key] = true
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for records")
+		}
+	}
+
+	assert.NoError(t, nt.setScale(sink, 0))
+	nt[sink].wait()
+	assert.Nil(t, nt[sink].handoff)
+}
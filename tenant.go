@@ -0,0 +1,238 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTenantErrorWindow is the default window within which the errors
+	// of a tenant are counted against its error limit
+	DefaultTenantErrorWindow = time.Minute
+)
+
+// tenantSeparator separates the tenant namespace from the keys of a tenant store
+const tenantSeparator = 0
+
+// TenantExtractor returns the tenant of a record forwarded by a source.
+type TenantExtractor func(record Record) (tenant string)
+
+// tenants isolates the tenants of the records processed by a stream.
+// Records of each tenant are throttled by the tenant quota, and tenants
+// emitting more errors than the limit within the window are isolated,
+// having their records dropped by the sources until resumed.
+// It is configured in the <stream>.tenants config subtree with the keys
+// quota.<tenant>, errors.limit and errors.window.
+type tenants struct {
+	mtx      sync.Mutex
+	quotas   *topicQuotas
+	limit    int
+	window   time.Duration
+	failures map[string][]time.Time
+	isolated map[string]time.Time
+}
+
+// newTenants creates the tenants isolation from the config
+func newTenants(config Config) (t *tenants) {
+	t = &tenants{}
+	t.limit = config.Get("errors", "limit").Int(0)
+	t.window = config.Get("errors", "window").Duration(DefaultTenantErrorWindow)
+	t.failures = make(map[string][]time.Time)
+	t.isolated = make(map[string]time.Time)
+
+	if quotas := config.Get("quota").Map(); quotas != nil {
+		rates := make(map[string]float64, len(quotas))
+		for tenant, rate := range quotas {
+			rates[tenant] = rate.Float64(0)
+		}
+		t.quotas = newTopicQuotas(rates)
+	}
+
+	return t
+}
+
+// admit returns if the records of the tenant can be forwarded,
+// waiting until the tenant is within its quota
+func (t *tenants) admit(tenant string) (ok bool) {
+	if t.isIsolated(tenant) {
+		return false
+	}
+
+	if t.quotas != nil {
+		t.quotas.wait(tenant)
+	}
+	return true
+}
+
+// isIsolated returns if the tenant is isolated
+func (t *tenants) isIsolated(tenant string) (isolated bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	_, isolated = t.isolated[tenant]
+	return isolated
+}
+
+// fail records an error of the tenant at the given time, isolating the
+// tenant once its errors within the window exceed the limit
+func (t *tenants) fail(tenant string, now time.Time) {
+	if t.limit <= 0 || tenant == "" {
+		return
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if _, isolated := t.isolated[tenant]; isolated {
+		return
+	}
+
+	failures := t.failures[tenant]
+	for len(failures) > 0 && now.Sub(failures[0]) >= t.window {
+		failures = failures[1:]
+	}
+	failures = append(failures, now)
+
+	if len(failures) > t.limit {
+		t.isolated[tenant] = now
+		delete(t.failures, tenant)
+		return
+	}
+	t.failures[tenant] = failures
+}
+
+// resume the processing of the isolated tenant
+func (t *tenants) resume(tenant string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	delete(t.isolated, tenant)
+	delete(t.failures, tenant)
+}
+
+// list returns the isolated tenants in sorted order
+func (t *tenants) list() (isolated []string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for tenant := range t.isolated {
+		isolated = append(isolated, tenant)
+	}
+	sort.Strings(isolated)
+	return isolated
+}
+
+// tenantOf returns the tenant of the first record with a tenant
+func tenantOf(records []Record) (tenant string) {
+	for _, record := range records {
+		if record.Tenant != "" {
+			return record.Tenant
+		}
+	}
+	return ""
+}
+
+// IsolatedTenants returns the tenants isolated for exceeding their error limit.
+func (s *Stream) IsolatedTenants() (tenants []string) {
+	if s.tenants == nil {
+		return nil
+	}
+	return s.tenants.list()
+}
+
+// ResumeTenant resumes the processing of the records of an isolated tenant.
+func (s *Stream) ResumeTenant(tenant string) {
+	if s.tenants != nil {
+		s.tenants.resume(tenant)
+	}
+}
+
+// tenantStore namespaces the keys of a store within a tenant
+type tenantStore struct {
+	Store
+	prefix []byte
+}
+
+// NewTenantStore returns a view of the store with its keys namespaced
+// within the given tenant, isolating the state of each tenant sharing
+// a store. Keys are stored prefixed with the tenant and a zero byte,
+// and are returned without the prefix by Range and RangePrefix.
+func NewTenantStore(store Store, tenant string) (ts Store) {
+	prefix := make([]byte, 0, len(tenant)+1)
+	prefix = append(prefix, tenant...)
+	prefix = append(prefix, tenantSeparator)
+	return &tenantStore{Store: store, prefix: prefix}
+}
+
+// key returns the namespaced key
+func (t *tenantStore) key(key []byte) (nkey []byte) {
+	nkey = make([]byte, 0, len(t.prefix)+len(key))
+	nkey = append(nkey, t.prefix...)
+	return append(nkey, key...)
+}
+
+// Process the record with the underlying store with its key namespaced
+func (t *tenantStore) Process(pc ProcessorContext, record Record) {
+	if key, err := record.EncodeKey(); err == nil && record.Key != nil {
+		record = record.WithKey(ByteEncoder(t.key(key)))
+	}
+	t.Store.Process(pc, record)
+}
+
+// Get value for the given key.
+func (t *tenantStore) Get(key []byte) (value []byte, err error) {
+	return t.Store.Get(t.key(key))
+}
+
+// Set the value for the given key.
+func (t *tenantStore) Set(key, value []byte) (err error) {
+	return t.Store.Set(t.key(key), value)
+}
+
+// Delete the given key and associated value
+func (t *tenantStore) Delete(key []byte) (err error) {
+	return t.Store.Delete(t.key(key))
+}
+
+// Range iterates the tenant keys within the given key range.
+// A nil from or to sets the iterator to the begining or end of the tenant keys.
+func (t *tenantStore) Range(from, to []byte, cb func(key, value []byte) error) (err error) {
+	from = t.key(from)
+	if to != nil {
+		to = t.key(to)
+	} else {
+		// The first key past the tenant namespace
+		to = append(t.key(nil)[:len(t.prefix)-1], tenantSeparator+1)
+	}
+
+	return t.Store.Range(from, to, t.strip(cb))
+}
+
+// RangePrefix iterates the tenant keys over a key prefix.
+func (t *tenantStore) RangePrefix(prefix []byte, cb func(key, value []byte) error) (err error) {
+	return t.Store.RangePrefix(t.key(prefix), t.strip(cb))
+}
+
+// strip the tenant namespace from the keys passed to the callback
+func (t *tenantStore) strip(cb func(key, value []byte) error) (scb func(key, value []byte) error) {
+	return func(key, value []byte) error {
+		return cb(key[len(t.prefix):], value)
+	}
+}
@@ -0,0 +1,146 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	var records []Record
+	for _, value := range []string{"bad:1", "bad:2", "bad:3", "acme:1", "acme:2"} {
+		records = append(records, NewRecord("test", StringEncoder("key"), StringEncoder(value), time.Now(), nil))
+	}
+
+	config := NewConfig(nil)
+	config.Set(1, "stream.tenants.errors.limit")
+
+	var processed []string
+	var errs []Error
+	var wg sync.WaitGroup
+	wg.Add(4)
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", func() Source {
+		return &testSource{records: records}
+	}))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		defer wg.Done()
+		processed = append(processed, record.Tenant)
+		if record.Tenant == "bad" {
+			pc.Error(errors.New("failed"), record)
+		}
+	}, "source"))
+
+	b.Tenants("source", func(record Record) string {
+		value, _ := record.EncodeValue()
+		return strings.SplitN(string(value), ":", 2)[0]
+	})
+	b.ErrorHandler(ErrorHandlerFunc(func(e Error) { errs = append(errs, e) }))
+
+	b.Tenants("missing", nil)
+	assert.Equal(t, ErrNodeNotFound, b.Validate())
+	delete(b.tenants, "missing")
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+
+	assert.Equal(t, []string{"bad", "bad", "acme", "acme"}, processed)
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "bad", errs[0].Tenant)
+	assert.Equal(t, []string{"bad"}, stream.IsolatedTenants())
+
+	for _, metric := range stream.Metrics() {
+		if metric.Name == "streams_node_tenant_dropped_total" {
+			assert.Equal(t, float64(1), metric.Value)
+		}
+	}
+
+	stream.ResumeTenant("bad")
+	assert.Nil(t, stream.IsolatedTenants())
+	assert.NoError(t, stream.Close())
+}
+
+func TestTenantsErrorWindow(t *testing.T) {
+	config := NewConfig(nil)
+	config.Set(2, "errors.limit")
+	config.Set("1s", "errors.window")
+
+	ts := newTenants(config)
+	now := time.Now()
+
+	ts.fail("a", now)
+	ts.fail("a", now)
+	ts.fail("a", now.Add(time.Second))
+	ts.fail("a", now.Add(time.Second))
+	assert.False(t, ts.isIsolated("a"))
+
+	ts.fail("a", now.Add(time.Second))
+	assert.True(t, ts.isIsolated("a"))
+	assert.False(t, ts.admit("a"))
+	assert.True(t, ts.admit("b"))
+
+	ts.resume("a")
+	assert.True(t, ts.admit("a"))
+}
+
+func TestTenantRecordID(t *testing.T) {
+	record := NewRecord("test", StringEncoder("key"), nil, time.Now(), nil)
+	acme := record.WithTenant("acme")
+	other := record.WithTenant("other")
+
+	assert.Equal(t, "acme", acme.Tenant)
+	assert.NotEqual(t, record.id, acme.id)
+	assert.NotEqual(t, acme.id, other.id)
+	assert.Equal(t, acme.id, NewRecord("test", StringEncoder("key"), nil, time.Now(), nil).WithTenant("acme").id)
+}
+
+func TestTenantStore(t *testing.T) {
+	store := &memStore{data: make(map[string][]byte)}
+	acme := NewTenantStore(store, "acme")
+	other := NewTenantStore(store, "other")
+
+	assert.NoError(t, acme.Set([]byte("key"), []byte("1")))
+	assert.NoError(t, other.Set([]byte("key"), []byte("2")))
+
+	value, err := acme.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+
+	value, err = other.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("2"), value)
+
+	_, err = store.Get([]byte("key"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, []byte("1"), store.data["acme\x00key"])
+
+	assert.NoError(t, acme.Delete([]byte("key")))
+	_, err = acme.Get([]byte("key"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	_, err = other.Get([]byte("key"))
+	assert.NoError(t, err)
+}
@@ -17,9 +17,9 @@ package streams
 */
 
 import (
-	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/brunotm/streams/types"
 )
@@ -29,17 +29,20 @@ var (
 	// i.e. outisde a processor.Process() call, forwarding from a processor with
 	// no sucessors or from a sink processor. A source processor context is
 	// always active.
-	ErrInvalidForward = errors.New("invalid forward")
+	ErrInvalidForward = Errorf(CodeTopology, "invalid forward")
 
 	// ErrStoreNotFound is returned when the Store requested store
 	// doesn't exists in the Stream topology.
-	ErrStoreNotFound = errors.New("store not found")
+	ErrStoreNotFound = Errorf(CodeTopology, "store not found")
 
-	errPredecessorNotFound = errors.New("predecessor not found")
-	errInvalidTopology     = errors.New("invalid topology")
-	errEmptyName           = errors.New("name cannot be empty")
-	errNodeNotFound        = errors.New("node not found")
-	errInvalidNodeType     = errors.New("invalid node type")
+	// ErrNodeNotFound is returned when the requested node
+	// doesn't exists in the Stream topology.
+	ErrNodeNotFound = Errorf(CodeTopology, "node not found")
+
+	errPredecessorNotFound = Errorf(CodeTopology, "predecessor not found")
+	errInvalidTopology     = Errorf(CodeTopology, "invalid topology")
+	errEmptyName           = Errorf(CodeTopology, "name cannot be empty")
+	errInvalidNodeType     = Errorf(CodeTopology, "invalid node type")
 )
 
 // Topology is an acyclic graph of sources, processors, and sinks.
@@ -53,9 +56,17 @@ var (
 // and then passed into a new Streams instance that will then begin consuming,
 // processing, and producing records.
 type topology struct {
-	roots  []*Node
-	nodes  []*Node
-	stores map[string]*Node
+	roots       []*Node
+	nodes       []*Node
+	smtx        sync.RWMutex
+	stores      map[string]*Node
+	deadLetters *Node
+}
+
+func newTopology() (t *topology) {
+	t = &topology{}
+	t.stores = make(map[string]*Node)
+	return t
 }
 
 // AddSource adds a source processor to the topology
@@ -70,11 +81,17 @@ func (t *topology) addProcessor(name string, ps ProcessorSupplier, predecessors
 
 // AddProcessorFunc adds a stream processor function to the topology
 func (t *topology) addProcessorFunc(name string, pf ProcessorFunc, predecessors ...string) (err error) {
-	ps := func() Processor {
+	ps := ProcessorSupplier(func() Processor {
 		return pf
+	})
+
+	if err = t.addNode(name, types.Processor, ps, predecessors...); err != nil {
+		return err
 	}
 
-	return t.addNode(name, types.Processor, ps, predecessors...)
+	// Processor functions hold no state and can be fused
+	t.getNode(name).stateless = true
+	return nil
 }
 
 // AddSink adds a sink processor to the topology
@@ -82,16 +99,50 @@ func (t *topology) addSink(name string, ps ProcessorSupplier, predecessors ...st
 	return t.addNode(name, types.Sink, ps, predecessors...)
 }
 
+// addDeadLetterSink adds the sink receiving the records of emitted errors.
+// It has no predecessors as the records are delivered to it by the stream.
+func (t *topology) addDeadLetterSink(name string, ps ProcessorSupplier) (err error) {
+	if name == "" {
+		return errEmptyName
+	}
+
+	if t.deadLetters != nil || t.getNode(name) != nil || t.stores[name] != nil {
+		return errInvalidTopology
+	}
+
+	t.deadLetters = &Node{name: name, typ: types.Sink, supplier: ps}
+	t.nodes = append(t.nodes, t.deadLetters)
+	return nil
+}
+
 // AddStore adds a state store to the topology
 func (t *topology) addStore(name string, ps StoreSupplier) (err error) {
 	return t.addNode(name, types.Store, ps)
 }
 
+// getStore returns the store node with the given name or nil if not found
+func (t *topology) getStore(name string) (node *Node) {
+	t.smtx.RLock()
+	defer t.smtx.RUnlock()
+	return t.stores[name]
+}
+
+// storeNodes returns the current store nodes
+func (t *topology) storeNodes() (nodes []*Node) {
+	t.smtx.RLock()
+	defer t.smtx.RUnlock()
+
+	for _, node := range t.stores {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
 // AddSinkFunc adds a sink processor function to the topology
 func (t *topology) addSinkFunc(name string, pf ProcessorFunc, predecessors ...string) (err error) {
-	ps := func() Processor {
+	ps := ProcessorSupplier(func() Processor {
 		return pf
-	}
+	})
 
 	return t.addNode(name, types.Sink, ps, predecessors...)
 }
@@ -99,10 +150,15 @@ func (t *topology) addSinkFunc(name string, pf ProcessorFunc, predecessors ...st
 // Clone this topology. Existing stores are shared with the clone, sources, processors and sinks
 // will be instantiated with the respective suppliers.
 func (t *topology) clone() (top *topology, err error) {
-	top = &topology{}
-	top.stores = t.stores
+	top = newTopology()
+	for name, node := range t.stores {
+		top.stores[name] = node
+	}
 
 	for _, node := range t.nodes {
+		if node == t.deadLetters {
+			continue
+		}
 
 		var predecessors []string
 		for _, predecessor := range node.predecessors {
@@ -113,6 +169,15 @@ func (t *topology) clone() (top *topology, err error) {
 		if err != nil {
 			return nil, err
 		}
+		top.getNode(node.name).stateless = node.stateless
+	}
+
+	// The dead letter sink is closed last, receiving the errors of all other nodes
+	if t.deadLetters != nil {
+		err = top.addDeadLetterSink(t.deadLetters.name, t.deadLetters.supplier.(ProcessorSupplier))
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return top, nil
@@ -155,6 +220,84 @@ func (t *topology) walk(from *Node, inc bool, cb func(*Node) bool) (ok bool) {
 	return true
 }
 
+// fuse linear chains of stateless processors into a single node.
+// A processor is fused into its predecessor when it is the predecessor only
+// successor, it has no other predecessors and the skip callback does not exclude
+// any of them. The fused node keeps the name of the first node in the chain.
+func (t *topology) fuse(skip func(*Node) bool) {
+	for i := 0; i < len(t.nodes); i++ {
+		head := t.nodes[i]
+		if !head.stateless || head.typ != types.Processor || skip(head) {
+			continue
+		}
+
+		var chain []*Node
+		for tail := head; len(tail.successors) == 1; {
+			next := tail.successors[0]
+			if !next.stateless || next.typ != types.Processor ||
+				len(next.predecessors) != 1 || skip(next) {
+				break
+			}
+			chain = append(chain, next)
+			tail = next
+		}
+
+		if len(chain) == 0 {
+			continue
+		}
+
+		fused := &fusedProcessor{}
+		fused.names = append(fused.names, head.name)
+		suppliers := []ProcessorSupplier{head.supplier.(ProcessorSupplier)}
+		for _, node := range chain {
+			fused.names = append(fused.names, node.name)
+			suppliers = append(suppliers, node.supplier.(ProcessorSupplier))
+		}
+
+		// The fused node takes over the chain tail successors
+		tail := chain[len(chain)-1]
+		head.successors = tail.successors
+		for _, successor := range head.successors {
+			for x := range successor.predecessors {
+				if successor.predecessors[x] == tail {
+					successor.predecessors[x] = head
+				}
+			}
+		}
+
+		head.fused = fused.names
+		head.supplier = ProcessorSupplier(func() Processor {
+			p := &fusedProcessor{names: fused.names}
+			for _, supplier := range suppliers {
+				p.processors = append(p.processors, supplier())
+			}
+			return p
+		})
+
+		t.removeNodes(chain)
+	}
+}
+
+// removeNodes from the topology node list
+func (t *topology) removeNodes(nodes []*Node) {
+	var kept []*Node
+	for _, node := range t.nodes {
+		removed := false
+		for _, r := range nodes {
+			if node == r {
+				removed = true
+				break
+			}
+		}
+
+		if !removed {
+			kept = append(kept, node)
+		}
+	}
+
+	t.nodes = kept
+}
+
 func (t *topology) validate() (err error) {
 
 	// Ensure all added sources have sucessors in the graph
@@ -180,10 +323,16 @@ func (t *topology) addNode(name string, typ types.Type, ps interface{}, predeces
 	}
 
 	// Don't replace already added nodes with same name
-	if t.getNode(node.name) != nil {
+	if t.getNode(node.name) != nil || t.stores[node.name] != nil {
 		return errInvalidTopology
 	}
 
+	// Stores are not part of the graph, they are accessed by name
+	if node.typ == types.Store {
+		t.stores[node.name] = node
+		return nil
+	}
+
 	// Ensure processors and sinks always have predecessors
 	if (node.typ == types.Processor || node.typ == types.Sink) && len(predecessors) == 0 {
 		return errInvalidTopology
@@ -0,0 +1,73 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+var (
+	// ErrTransformerNotFound is returned when a node is configured
+	// with a transformer that was not added to the Builder.
+	ErrTransformerNotFound = Errorf(CodeTopology, "transformer not found")
+
+	errTransformerExists = Errorf(CodeTopology, "transformer already exists")
+)
+
+// Transformer transforms records inbound to or outbound from a node,
+// e.g. field masking, redaction or format conversion.
+// Returning an error drops the record and emits the error to the Stream.
+type Transformer func(record Record) (result Record, err error)
+
+// transforms resolves the transformers configured for each node in the
+// <stream>.<node>.transform.inbound and <stream>.<node>.transform.outbound
+// config arrays, which are applied in order.
+func (t *topology) transforms(stream string, config Config, transformers map[string]Transformer) (err error) {
+	for _, node := range t.nodes {
+		if node.inbound, err = resolveTransformers(
+			config.Get(stream, node.name, "transform", "inbound"), transformers); err != nil {
+			return Errorf(CodeTopology, "%s inbound: %w", node.name, err)
+		}
+
+		if node.outbound, err = resolveTransformers(
+			config.Get(stream, node.name, "transform", "outbound"), transformers); err != nil {
+			return Errorf(CodeTopology, "%s outbound: %w", node.name, err)
+		}
+	}
+
+	return nil
+}
+
+func resolveTransformers(config Config, transformers map[string]Transformer) (resolved []Transformer, err error) {
+	for _, name := range config.Array() {
+		transformer, exists := transformers[name.String("")]
+		if !exists {
+			return nil, ErrTransformerNotFound
+		}
+		resolved = append(resolved, transformer)
+	}
+	return resolved, nil
+}
+
+// transform applies the given transformers to the record.
+// It returns false if the record was dropped by a transformer.
+func (pc *processorContext) transform(transformers []Transformer, record Record) (result Record, ok bool) {
+	var err error
+	for _, transformer := range transformers {
+		if record, err = transformer(record); err != nil {
+			pc.Error(err, record)
+			return record, false
+		}
+	}
+	return record, true
+}
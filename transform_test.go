@@ -0,0 +1,83 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuilderTransformers(t *testing.T) {
+	config := NewConfig(nil)
+	config.Set([]interface{}{"drop"}, "stream", "source", "transform", "outbound")
+	config.Set([]interface{}{"mask", "upper"}, "stream", "sink", "transform", "inbound")
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("source", testSourceSupplier(4)))
+
+	received := make(chan Record, 4)
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		received <- record
+	}, "source"))
+
+	// unknown transformers are reported on Build
+	_, err := b.Build()
+	assert.True(t, errors.Is(err, ErrTransformerNotFound))
+	assert.Equal(t, CodeTopology, CodeOf(err))
+
+	errDropped := errors.New("dropped")
+	assert.NoError(t, b.AddTransformer("drop", func(record Record) (Record, error) {
+		if v, _ := record.EncodeValue(); string(v) == "2" {
+			return record, errDropped
+		}
+		return record, nil
+	}))
+	assert.NoError(t, b.AddTransformer("mask", func(record Record) (Record, error) {
+		v, _ := record.EncodeValue()
+		record.Value = StringEncoder("value-" + string(v))
+		return record, nil
+	}))
+	assert.NoError(t, b.AddTransformer("upper", func(record Record) (Record, error) {
+		v, _ := record.EncodeValue()
+		record.Value = StringEncoder(strings.ToUpper(string(v)))
+		return record, nil
+	}))
+	assert.Error(t, b.AddTransformer("upper", nil))
+
+	errs := make(chan error, 4)
+	b.ErrorHandler(ErrorHandlerFunc(func(e Error) { errs <- e.Error }))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+
+	for _, expected := range []string{"VALUE-0", "VALUE-1", "VALUE-3"} {
+		select {
+		case record := <-received:
+			v, _ := record.EncodeValue()
+			assert.Equal(t, expected, string(v))
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for records")
+		}
+	}
+	assert.Equal(t, errDropped, <-errs)
+	assert.NoError(t, stream.Close())
+}
@@ -0,0 +1,146 @@
+package udf
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/brunotm/streams"
+)
+
+// MaxFrameSize is the maximum size of a protocol frame
+const MaxFrameSize = 64 << 20
+
+var errFrameSize = streams.Errorf(streams.CodeSerialization, "frame too large")
+
+// replyError is an error replied by the subprocess
+type replyError string
+
+func (e replyError) Error() string {
+	return string(e)
+}
+
+// Serve runs the subprocess side of the protocol, calling fn for every request
+// read from in and writing its outputs or error to out until in is closed.
+func Serve(in io.Reader, out io.Writer, fn func(key, value []byte) (outputs []Output, err error)) (err error) {
+	reader := bufio.NewReader(in)
+	writer := bufio.NewWriter(out)
+
+	for {
+		key, err := readFrame(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		value, err := readFrame(reader)
+		if err != nil {
+			return err
+		}
+
+		outputs, ferr := fn(key, value)
+		if ferr != nil {
+			if err = writeReply(writer, -1); err == nil {
+				err = writeFrames(writer, []byte(ferr.Error()))
+			}
+		} else {
+			err = writeReply(writer, int32(len(outputs)))
+			for x := 0; err == nil && x < len(outputs); x++ {
+				err = writeFrames(writer, outputs[x].Key, outputs[x].Value)
+			}
+		}
+
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readReply reads the outputs or the error replied by the subprocess
+func readReply(reader *bufio.Reader) (outputs []Output, err error) {
+	var count [4]byte
+	if _, err = io.ReadFull(reader, count[:]); err != nil {
+		return nil, err
+	}
+
+	n := int32(binary.BigEndian.Uint32(count[:]))
+	if n < 0 {
+		message, err := readFrame(reader)
+		if err != nil {
+			return nil, err
+		}
+		return nil, replyError(message)
+	}
+
+	outputs = make([]Output, n)
+	for x := range outputs {
+		if outputs[x].Key, err = readFrame(reader); err != nil {
+			return nil, err
+		}
+		if outputs[x].Value, err = readFrame(reader); err != nil {
+			return nil, err
+		}
+	}
+	return outputs, nil
+}
+
+// writeReply writes the reply output count
+func writeReply(writer *bufio.Writer, count int32) (err error) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(count))
+	_, err = writer.Write(b[:])
+	return err
+}
+
+// readFrame reads a length prefixed frame
+func readFrame(reader *bufio.Reader) (frame []byte, err error) {
+	var size [4]byte
+	if _, err = io.ReadFull(reader, size[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n > MaxFrameSize {
+		return nil, errFrameSize
+	}
+
+	frame = make([]byte, n)
+	_, err = io.ReadFull(reader, frame)
+	return frame, err
+}
+
+// writeFrames writes the length prefixed frames
+func writeFrames(writer *bufio.Writer, frames ...[]byte) (err error) {
+	var size [4]byte
+	for _, frame := range frames {
+		binary.BigEndian.PutUint32(size[:], uint32(len(frame)))
+		if _, err = writer.Write(size[:]); err != nil {
+			return err
+		}
+		if _, err = writer.Write(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
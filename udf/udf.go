@@ -0,0 +1,225 @@
+package udf
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+var (
+	// ErrTimeout is emitted when the subprocess does not reply within the timeout
+	ErrTimeout = streams.Errorf(streams.CodeRecord, "subprocess reply timeout")
+
+	errInvalidConfig = streams.Errorf(streams.CodeConfig, "invalid udf config")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Subprocess)(nil)
+var _ streams.Processor = (*Subprocess)(nil)
+var _ streams.Closer = (*Subprocess)(nil)
+
+// Output is a record emitted by a user defined function
+type Output struct {
+	Key   []byte
+	Value []byte
+}
+
+// Subprocess transforms records with a long-lived subprocess per task, running
+// user defined functions written in any language. Records are sent to the
+// subprocess stdin and their outputs read from its stdout one at a time, so a
+// slow subprocess applies backpressure to the stream.
+//
+// Every frame is an uint32 big endian length followed by the frame bytes.
+// A request is a key frame followed by a value frame, and is replied with an
+// int32 big endian count of outputs followed by the key and value frames of
+// each output, or by a count of -1 followed by an error message frame.
+// Outputs keep the record topic, time and acknowledgment, and the record key
+// when their key is empty. The Serve function implements the subprocess side
+// of the protocol for Go programs.
+//
+// A subprocess that crashes or times out is killed, the record is dropped with
+// an error and the subprocess is restarted on the next record.
+// Its stderr is passed through to the stream process stderr.
+// It is configured through the <stream>.<node> config subtree with the keys:
+//
+//	command: path of the subprocess executable
+//	args:    array of subprocess arguments
+//	env:     array of additional subprocess environment variables as key=value
+//	timeout: maximum duration to wait for a reply, defaults to no timeout
+type Subprocess struct {
+	mtx     sync.Mutex
+	command string
+	args    []string
+	env     []string
+	timeout time.Duration
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	writer  *bufio.Writer
+	reader  *bufio.Reader
+}
+
+// Supplier for the subprocess processor
+func Supplier() (processor streams.Processor) {
+	return &Subprocess{}
+}
+
+// Init the processor and start the subprocess
+func (s *Subprocess) Init(pc streams.ProcessorContext) (err error) {
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	s.command = config.Get("command").String("")
+	s.timeout = config.Get("timeout").Duration(0)
+
+	for _, arg := range config.Get("args").Array() {
+		s.args = append(s.args, arg.String(""))
+	}
+
+	for _, env := range config.Get("env").Array() {
+		s.env = append(s.env, env.String(""))
+	}
+
+	if s.command == "" {
+		return errInvalidConfig
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.start()
+}
+
+// Process sends the record to the subprocess and forwards its outputs
+func (s *Subprocess) Process(pc streams.ProcessorContext, record streams.Record) {
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	outputs, err := s.call(key, value)
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	for _, output := range outputs {
+		child := record
+		child.Value = streams.ByteEncoder(output.Value)
+		if len(output.Key) > 0 {
+			child = child.WithKey(streams.ByteEncoder(output.Key))
+		}
+		pc.Forward(child)
+	}
+}
+
+// Close the subprocess
+func (s *Subprocess) Close() (err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.cmd == nil {
+		return nil
+	}
+
+	// The subprocess exits on the end of its input
+	s.stdin.Close()
+	err = s.cmd.Wait()
+	s.cmd = nil
+	return err
+}
+
+// call sends the request to the subprocess and reads its reply,
+// restarting the subprocess if needed
+func (s *Subprocess) call(key, value []byte) (outputs []Output, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.cmd == nil {
+		if err = s.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	var timer *time.Timer
+	if s.timeout > 0 {
+		process := s.cmd.Process
+		timer = time.AfterFunc(s.timeout, func() { process.Kill() })
+	}
+
+	if err = writeFrames(s.writer, key, value); err == nil {
+		if err = s.writer.Flush(); err == nil {
+			outputs, err = readReply(s.reader)
+		}
+	}
+
+	if timer != nil && !timer.Stop() {
+		err = ErrTimeout
+	}
+
+	// Errors replied by the subprocess leave it in a consistent state
+	if _, ok := err.(replyError); err != nil && !ok {
+		s.stop()
+	}
+
+	return outputs, err
+}
+
+// start the subprocess
+func (s *Subprocess) start() (err error) {
+	cmd := exec.Command(s.command, s.args...)
+	cmd.Env = append(os.Environ(), s.env...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	s.cmd = cmd
+	s.stdin = stdin
+	s.writer = bufio.NewWriter(stdin)
+	s.reader = bufio.NewReader(stdout)
+	return nil
+}
+
+// stop kills the subprocess
+func (s *Subprocess) stop() {
+	s.cmd.Process.Kill()
+	s.stdin.Close()
+	s.cmd.Wait()
+	s.cmd = nil
+}
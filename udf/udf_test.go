@@ -0,0 +1,102 @@
+package udf
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHelperProcess is the udf subprocess
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("UDF_HELPER_PROCESS") != "1" {
+		return
+	}
+
+	Serve(os.Stdin, os.Stdout, func(key, value []byte) (outputs []Output, err error) {
+		switch string(value) {
+		case "crash":
+			os.Exit(1)
+		case "sleep":
+			time.Sleep(time.Second)
+		case "error":
+			return nil, errors.New("udf error")
+		}
+
+		return []Output{
+			{Key: key, Value: bytes.ToUpper(value)},
+			{Key: []byte("other"), Value: value},
+		}, nil
+	})
+	os.Exit(0)
+}
+
+func TestSubprocess(t *testing.T) {
+	config := streams.NewConfig(nil)
+	config.Set(os.Args[0], "stream.udf.command")
+	config.Set([]interface{}{"-test.run=TestHelperProcess"}, "stream", "udf", "args")
+	config.Set([]interface{}{"UDF_HELPER_PROCESS=1"}, "stream", "udf", "env")
+	config.Set("200ms", "stream.udf.timeout")
+
+	pc := &mock.Context{Data: mock.ContextData{
+		Active:     true,
+		StreamName: "stream",
+		NodeName:   "udf",
+		Config:     config,
+	}}
+
+	s := Supplier()
+	assert.NoError(t, s.(streams.Initializer).Init(pc))
+
+	process := func(value string) {
+		s.Process(pc, streams.NewRecord("test", streams.StringEncoder("key"),
+			streams.StringEncoder(value), time.Now(), nil))
+	}
+
+	process("hello")
+	assert.Equal(t, 2, pc.Data.ForwardCount)
+	key, _ := pc.Data.Forwarded[0].EncodeKey()
+	value, _ := pc.Data.Forwarded[0].EncodeValue()
+	assert.Equal(t, "key", string(key))
+	assert.Equal(t, "HELLO", string(value))
+	key, _ = pc.Data.Forwarded[1].EncodeKey()
+	assert.Equal(t, "other", string(key))
+
+	// replied errors keep the subprocess running
+	process("error")
+	assert.Equal(t, 1, pc.Data.ErrorCount)
+
+	// crashed and timed out subprocesses are restarted
+	process("crash")
+	assert.Equal(t, 2, pc.Data.ErrorCount)
+	process("sleep")
+	assert.Equal(t, 3, pc.Data.ErrorCount)
+
+	process("world")
+	assert.Equal(t, 4, pc.Data.ForwardCount)
+	value, _ = pc.Data.Forwarded[2].EncodeValue()
+	assert.Equal(t, "WORLD", string(value))
+
+	assert.NoError(t, s.(streams.Closer).Close())
+}
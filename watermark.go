@@ -0,0 +1,140 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// watermark tracks the event time progress of a source. The watermark of a
+// source is the lowest of the highest record times forwarded by each of its
+// tasks, minus the allowed lag of out of order records set with
+// <stream>.<source>.watermark.lag. Tasks not forwarding timed records for the
+// <stream>.<source>.watermark.idle duration are excluded as idle, so they do
+// not hold back the stream watermark.
+type watermark struct {
+	lag  time.Duration
+	idle time.Duration
+}
+
+func newWatermark(config Config) (w *watermark) {
+	return &watermark{
+		lag:  config.Get("lag").Duration(0),
+		idle: config.Get("idle").Duration(0),
+	}
+}
+
+// source returns the watermark of the source node at the given time,
+// and false if none of its tasks forwarded timed records or all are idle
+func (w *watermark) source(node *Node, now time.Time) (ts time.Time, ok bool) {
+	node.tasks.RLock()
+	defer node.tasks.RUnlock()
+
+	var low int64
+	for _, pc := range node.tasks.contexts {
+		event := atomic.LoadInt64(&pc.eventTime)
+		if event == 0 {
+			continue
+		}
+
+		if w.idle > 0 && now.Sub(time.Unix(0, atomic.LoadInt64(&pc.eventSeen))) > w.idle {
+			continue
+		}
+
+		if low == 0 || event < low {
+			low = event
+		}
+	}
+
+	if low == 0 {
+		return ts, false
+	}
+	return time.Unix(0, low).Add(-w.lag), true
+}
+
+// observeTime advances the highest record time of the context to the given
+// record time, recording when it was observed for idle sources
+func (pc *processorContext) observeTime(ts time.Time, now time.Time) {
+	if ts.IsZero() {
+		return
+	}
+
+	event := ts.UnixNano()
+	for {
+		current := atomic.LoadInt64(&pc.eventTime)
+		if event <= current || atomic.CompareAndSwapInt64(&pc.eventTime, current, event) {
+			break
+		}
+	}
+
+	if !now.IsZero() {
+		atomic.StoreInt64(&pc.eventSeen, now.UnixNano())
+	}
+}
+
+// StreamTime returns the highest record time processed by the context,
+// or forwarded by a source context
+func (pc *processorContext) StreamTime() (ts time.Time) {
+	if event := atomic.LoadInt64(&pc.eventTime); event != 0 {
+		return time.Unix(0, event)
+	}
+	return ts
+}
+
+// Watermark returns the event time watermark of the stream
+func (pc *processorContext) Watermark() (ts time.Time) {
+	if pc.stream == nil {
+		return ts
+	}
+	return pc.stream.Watermark()
+}
+
+// Watermark returns the event time watermark of the stream, the time up to
+// which records are expected to have been received from all of its sources.
+// It is the lowest watermark of the sources forwarding timed records, as set
+// by their <stream>.<source>.watermark config, and never goes backwards.
+// Records older than the watermark are late. The watermark is zero until the
+// sources forward timed records.
+func (s *Stream) Watermark() (ts time.Time) {
+	now := time.Now()
+
+	var low time.Time
+	for _, node := range s.topology.roots {
+		if node.watermark == nil || node.tasks == nil {
+			continue
+		}
+
+		if source, ok := node.watermark.source(node, now); ok && (low.IsZero() || source.Before(low)) {
+			low = source
+		}
+	}
+
+	for {
+		current := atomic.LoadInt64(&s.watermark)
+		if low.IsZero() || low.UnixNano() <= current {
+			if current == 0 {
+				return ts
+			}
+			return time.Unix(0, current)
+		}
+
+		if atomic.CompareAndSwapInt64(&s.watermark, current, low.UnixNano()) {
+			return low
+		}
+	}
+}
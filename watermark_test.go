@@ -0,0 +1,105 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatermarkSource(t *testing.T) {
+	now := time.Now()
+	node := &Node{}
+	node.tasks = &tasks{node: node}
+
+	w := &watermark{lag: time.Second}
+	_, ok := w.source(node, now)
+	assert.False(t, ok)
+
+	idle, active, untimed := &processorContext{}, &processorContext{}, &processorContext{}
+	idle.observeTime(time.Unix(100, 0), now.Add(-time.Minute))
+	active.observeTime(time.Unix(200, 0), now)
+	active.observeTime(time.Unix(150, 0), now)
+	node.tasks.contexts = []*processorContext{idle, active, untimed}
+	assert.Equal(t, time.Unix(200, 0), active.StreamTime())
+
+	ts, ok := w.source(node, now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Unix(99, 0), ts)
+
+	// idle tasks do not hold back the source watermark
+	w.idle = 30 * time.Second
+	ts, ok = w.source(node, now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Unix(199, 0), ts)
+}
+
+func TestStreamWatermark(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	config := NewConfig(nil)
+	config.Set("5s", "stream.late.watermark.lag")
+
+	timed := func(seconds ...int64) *testSource {
+		source := &testSource{}
+		for _, s := range seconds {
+			source.records = append(source.records,
+				NewRecord("", StringEncoder("k"), StringEncoder("v"), time.Unix(s, 0), nil))
+		}
+		return source
+	}
+
+	var mtx sync.Mutex
+	var streamTime time.Time
+
+	b := NewBuilder("stream", config)
+	assert.NoError(t, b.AddSource("early", func() Source { return timed(100, 105) }))
+	assert.NoError(t, b.AddSource("late", func() Source { return timed(50) }))
+	assert.NoError(t, b.AddSinkFunc("sink", func(pc ProcessorContext, record Record) {
+		defer wg.Done()
+		mtx.Lock()
+		streamTime = pc.StreamTime()
+		mtx.Unlock()
+	}, "early", "late"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.True(t, stream.Watermark().IsZero())
+	assert.NoError(t, stream.Start())
+	wg.Wait()
+
+	mtx.Lock()
+	assert.Equal(t, time.Unix(105, 0), streamTime)
+	mtx.Unlock()
+
+	// the lowest source watermark is the stream watermark
+	assert.Equal(t, time.Unix(45, 0), stream.Watermark())
+
+	var found bool
+	for _, metric := range stream.Metrics() {
+		if metric.Name == "streams_stream_watermark_timestamp_seconds" {
+			found = true
+			assert.Equal(t, float64(45), metric.Value)
+		}
+	}
+	assert.True(t, found)
+	assert.NoError(t, stream.Close())
+}
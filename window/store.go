@@ -0,0 +1,217 @@
+package window
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+var (
+	errInvalidKey = streams.Errorf(streams.CodeSerialization, "invalid window key")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*DB)(nil)
+var _ streams.Closer = (*DB)(nil)
+var _ streams.Remover = (*DB)(nil)
+var _ streams.WindowedStore = (*DB)(nil)
+var _ streams.Layered = (*DB)(nil)
+
+// windowPrefix prefixes the window keys in the underlying store
+const windowPrefix = 'w'
+
+// DB stores time windows in a underlying store, with keys encoded as the
+// window key followed by the window start time so the windows of a key are
+// iterated in start order.
+type DB struct {
+	pc    streams.ProcessorContext
+	store streams.Store
+}
+
+// StoreSupplier for a windowed store over the stores created by the given supplier
+func StoreSupplier(supplier streams.StoreSupplier) streams.StoreSupplier {
+	return func() (store streams.Store) {
+		return &DB{store: supplier()}
+	}
+}
+
+// Init store
+func (d *DB) Init(pc streams.ProcessorContext) (err error) {
+	d.pc = pc
+	if initializer, ok := d.store.(streams.Initializer); ok {
+		return initializer.Init(pc)
+	}
+	return nil
+}
+
+// Remove closes the store and erases its contents
+func (d *DB) Remove() (err error) {
+	if remover, ok := d.store.(streams.Remover); ok {
+		return remover.Remove()
+	}
+	return d.Close()
+}
+
+// Close the store releasing its resources.
+func (d *DB) Close() (err error) {
+	if closer, ok := d.store.(streams.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Underlying returns the underlying store
+func (d *DB) Underlying() (store streams.Store) {
+	return d.store
+}
+
+// Layer returns a copy of the store over the given view of the underlying store
+func (d *DB) Layer(store streams.Store) (layered streams.Store) {
+	return &DB{pc: d.pc, store: store}
+}
+
+// Name returns this store name.
+func (d *DB) Name() (name string) {
+	return d.pc.NodeName()
+}
+
+// Process sets the value of the window of the record key starting at the record time.
+// Records with empty values deletes the window from the store.
+func (d *DB) Process(pc streams.ProcessorContext, record streams.Record) {
+	if !record.IsValid() || record.Key == nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "invalid record to store"), record)
+		return
+	}
+
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record key: %w", err), record)
+		return
+	}
+
+	if record.Value == nil {
+		if err = d.DeleteWindow(key, record.Time); err != nil {
+			pc.Error(err, record)
+		}
+		return
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		pc.Error(streams.Errorf(streams.CodeSerialization, "error serializing record value: %w", err), record)
+		return
+	}
+
+	if err = d.SetWindow(key, record.Time, value); err != nil {
+		pc.Error(err, record)
+	}
+}
+
+// Get value for the given key.
+func (d *DB) Get(key []byte) (value []byte, err error) {
+	return d.store.Get(key)
+}
+
+// Set value for the given key.
+func (d *DB) Set(key, value []byte) (err error) {
+	return d.store.Set(key, value)
+}
+
+// Delete value for the given key.
+func (d *DB) Delete(key []byte) (err error) {
+	return d.store.Delete(key)
+}
+
+// Range iterates the underlying store within the given key range applying
+// the callback for the key value pairs. Returning a error causes the iteration to stop.
+func (d *DB) Range(from, to []byte, cb func(key, value []byte) error) (err error) {
+	return d.store.Range(from, to, cb)
+}
+
+// RangePrefix iterates the underlying store over a key prefix applying the
+// callback for the key value pairs. Returning a error causes the iteration to stop.
+func (d *DB) RangePrefix(prefix []byte, cb func(key, value []byte) error) (err error) {
+	return d.store.RangePrefix(prefix, cb)
+}
+
+// GetWindow returns the value of the key window starting at start.
+func (d *DB) GetWindow(key []byte, start time.Time) (value []byte, err error) {
+	return d.store.Get(encodeKey(key, start))
+}
+
+// SetWindow sets the value of the key window starting at start.
+func (d *DB) SetWindow(key []byte, start time.Time, value []byte) (err error) {
+	return d.store.Set(encodeKey(key, start), value)
+}
+
+// DeleteWindow deletes the key window starting at start.
+func (d *DB) DeleteWindow(key []byte, start time.Time) (err error) {
+	return d.store.Delete(encodeKey(key, start))
+}
+
+// FetchWindows applies the callback for the key windows starting within
+// from and to inclusive in start order. Returning a error causes the iteration to stop.
+func (d *DB) FetchWindows(key []byte, from, to time.Time, cb func(start time.Time, value []byte) error) (err error) {
+	return d.store.Range(encodeKey(key, from), encodeKey(key, to.Add(time.Nanosecond)), func(k, value []byte) error {
+		_, start, err := decodeKey(k)
+		if err != nil {
+			return err
+		}
+		return cb(start, value)
+	})
+}
+
+// RangeWindows applies the callback for all windows, in start order per key.
+// Returning a error causes the iteration to stop.
+func (d *DB) RangeWindows(cb func(key []byte, start time.Time, value []byte) error) (err error) {
+	return d.store.RangePrefix([]byte{windowPrefix}, func(k, value []byte) error {
+		key, start, err := decodeKey(k)
+		if err != nil {
+			return err
+		}
+		return cb(key, start, value)
+	})
+}
+
+// encodeKey encodes the window key as the prefix, key length, key and
+// start time with the sign bit flipped to sort negative times first
+func encodeKey(key []byte, start time.Time) (wkey []byte) {
+	wkey = make([]byte, 1+4+len(key)+8)
+	wkey[0] = windowPrefix
+	binary.BigEndian.PutUint32(wkey[1:], uint32(len(key)))
+	copy(wkey[5:], key)
+	binary.BigEndian.PutUint64(wkey[5+len(key):], uint64(start.UnixNano())^(1<<63))
+	return wkey
+}
+
+// decodeKey decodes the key and start time of the window key
+func decodeKey(wkey []byte) (key []byte, start time.Time, err error) {
+	if len(wkey) < 13 || wkey[0] != windowPrefix {
+		return nil, start, errInvalidKey
+	}
+
+	size := int(binary.BigEndian.Uint32(wkey[1:]))
+	if len(wkey) != 13+size {
+		return nil, start, errInvalidKey
+	}
+
+	nanos := int64(binary.BigEndian.Uint64(wkey[5+size:]) ^ (1 << 63))
+	return wkey[5 : 5+size], time.Unix(0, nanos), nil
+}
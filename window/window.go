@@ -0,0 +1,494 @@
+package window
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/brunotm/streams"
+)
+
+const (
+	// DefaultInterval is the default interval of the watermark checks
+	DefaultInterval = time.Second
+)
+
+var (
+	errLateRecord       = streams.Errorf(streams.CodeRecord, "record for closed window")
+	errInvalidWindows   = streams.Errorf(streams.CodeConfig, "invalid window definition")
+	errMergerRequired   = streams.Errorf(streams.CodeConfig, "session windows require a merger")
+	errNotWindowedStore = streams.Errorf(streams.CodeTopology, "not a windowed store")
+	errInvalidValue     = streams.Errorf(streams.CodeSerialization, "invalid window value")
+	errInvalidTime      = streams.Errorf(streams.CodeConfig, "invalid window time, must be record or watermark")
+	errInvalidLate      = streams.Errorf(streams.CodeConfig, "invalid late record handling, must be error, drop or forward")
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Initializer = (*Aggregate)(nil)
+var _ streams.Processor = (*Aggregate)(nil)
+
+// Windows defines the assignment of records to time windows.
+type Windows struct {
+	// Size of fixed windows
+	Size time.Duration
+	// Advance between the start of consecutive fixed windows
+	Advance time.Duration
+	// Gap of inactivity closing session windows
+	Gap time.Duration
+}
+
+// Tumbling windows of the given size, each record belonging to a single window
+func Tumbling(size time.Duration) (windows Windows) {
+	return Windows{Size: size, Advance: size}
+}
+
+// Hopping windows of the given size starting every advance, each record
+// belonging to every window overlapping its time
+func Hopping(size, advance time.Duration) (windows Windows) {
+	return Windows{Size: size, Advance: advance}
+}
+
+// Session windows of activity per key, closed after the given gap of inactivity.
+// Sessions bridged by a record are merged.
+func Session(gap time.Duration) (windows Windows) {
+	return Windows{Gap: gap}
+}
+
+// session returns if the windows are session windows
+func (w Windows) session() (ok bool) {
+	return w.Gap > 0
+}
+
+// validate the window definition
+func (w Windows) validate() (err error) {
+	if w.session() {
+		if w.Size != 0 || w.Advance != 0 {
+			return errInvalidWindows
+		}
+		return nil
+	}
+
+	if w.Size <= 0 || w.Advance <= 0 || w.Advance > w.Size {
+		return errInvalidWindows
+	}
+	return nil
+}
+
+// assign returns the start of the fixed windows containing the time in start order
+func (w Windows) assign(t time.Time) (starts []time.Time) {
+	for start := t.Truncate(w.Advance); start.Add(w.Size).After(t); start = start.Add(-w.Advance) {
+		starts = append([]time.Time{start}, starts...)
+	}
+	return starts
+}
+
+// Aggregator adds the record to the window aggregate, which is nil for a new window
+type Aggregator func(aggregate []byte, record streams.Record) (result []byte, err error)
+
+// Merger merges the aggregates of two session windows in time order
+type Merger func(a, b []byte) (result []byte, err error)
+
+// Aggregate aggregates record values per key into time windows kept in a
+// WindowedStore. Windows close once the stream time, the highest record time
+// seen, passes their end plus the grace period, records for closed windows
+// being dropped with an error. Closed windows are forwarded with the store name
+// as topic, the record key, the window aggregate and the window start time,
+// and kept in the store for queries for the retention period after closing.
+// Session window values are stored prefixed with the session end time.
+// The stream time is kept in the store, so open windows are resumed across
+// restarts. The store must not be shared with other aggregations or tasks.
+// The grace period and retention can be set with the <stream>.<node>.grace
+// and <stream>.<node>.retention config.
+//
+// With the <stream>.<node>.time config set to watermark, the stream time is the
+// stream event time watermark instead, checked on every record and every
+// <stream>.<node>.interval, so windows close once all sources have moved past
+// them, regardless of out of order records. Late records are emitted as errors,
+// dropped or forwarded as they arrive, to the node named by <stream>.<node>.lateto
+// if set, with the <stream>.<node>.late config set to error, drop or forward.
+type Aggregate struct {
+	name       string
+	windows    Windows
+	aggregator Aggregator
+	merger     Merger
+	grace      time.Duration
+	retention  time.Duration
+	store      streams.WindowedStore
+	entries    map[string][]*entry
+	streamTime time.Time
+	timeKey    []byte
+	watermark  bool
+	late       string
+	lateTo     string
+}
+
+// entry tracks a window not yet deleted from the store
+type entry struct {
+	key    []byte
+	start  time.Time
+	end    time.Time
+	closed bool
+}
+
+// Supplier for a windowed aggregation over the named store. The merger is
+// only required for session windows.
+func Supplier(store string, windows Windows, aggregator Aggregator, merger Merger) streams.ProcessorSupplier {
+	return func() (processor streams.Processor) {
+		return &Aggregate{name: store, windows: windows, aggregator: aggregator, merger: merger}
+	}
+}
+
+// Init the aggregation and resume the windows in the store
+func (a *Aggregate) Init(pc streams.ProcessorContext) (err error) {
+	if err = a.windows.validate(); err != nil {
+		return err
+	}
+
+	if a.windows.session() && a.merger == nil {
+		return errMergerRequired
+	}
+
+	config := pc.Config().Get(pc.StreamName(), pc.NodeName())
+	a.grace = config.Get("grace").Duration(0)
+	a.retention = config.Get("retention").Duration(0)
+	a.late = config.Get("late").String("error")
+	a.lateTo = config.Get("lateto").String("")
+
+	switch config.Get("time").String("record") {
+	case "record":
+	case "watermark":
+		a.watermark = true
+	default:
+		return errInvalidTime
+	}
+
+	switch a.late {
+	case "error", "drop", "forward":
+	default:
+		return errInvalidLate
+	}
+
+	store, err := pc.Store(a.name)
+	if err != nil {
+		return err
+	}
+
+	var ok bool
+	if a.store, ok = store.(streams.WindowedStore); !ok {
+		return errNotWindowedStore
+	}
+
+	a.timeKey = []byte("\x00\x00window/" + pc.NodeName() + "/time")
+	value, err := a.store.Get(a.timeKey)
+	switch err {
+	case nil:
+		if len(value) != 8 {
+			return errInvalidValue
+		}
+		a.streamTime = time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+	case streams.ErrKeyNotFound:
+	default:
+		return err
+	}
+
+	a.entries = make(map[string][]*entry)
+	err = a.store.RangeWindows(func(key []byte, start time.Time, value []byte) error {
+		end := start.Add(a.windows.Size)
+		if a.windows.session() {
+			if len(value) < 8 {
+				return errInvalidValue
+			}
+			end = time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+		}
+
+		e := a.track(key, start, end)
+		e.closed = !a.closeAt(e).After(a.streamTime)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if a.watermark {
+		pc.Schedule(config.Get("interval").Duration(DefaultInterval), streams.WallClockTime, func(time.Time) {
+			a.advanceTo(pc, pc.Watermark())
+		})
+	}
+	return nil
+}
+
+// Process adds the record to its windows and closes the windows ended by the stream time
+func (a *Aggregate) Process(pc streams.ProcessorContext, record streams.Record) {
+	key, err := record.EncodeKey()
+	if err != nil {
+		pc.Error(err, record)
+		return
+	}
+
+	if a.watermark {
+		a.advanceTo(pc, pc.Watermark())
+	}
+
+	if a.windows.session() {
+		err = a.session(key, record)
+	} else {
+		err = a.fixed(key, record)
+	}
+
+	switch {
+	case err == errLateRecord:
+		a.lateRecord(pc, record)
+	case err != nil:
+		pc.Error(err, record)
+	}
+
+	if !a.watermark {
+		a.advanceTo(pc, record.Time)
+	}
+}
+
+// lateRecord handles a record for closed windows
+func (a *Aggregate) lateRecord(pc streams.ProcessorContext, record streams.Record) {
+	var err error
+	switch a.late {
+	case "drop":
+		return
+	case "forward":
+		if a.lateTo != "" {
+			err = pc.ForwardTo(a.lateTo, record)
+		} else {
+			err = pc.Forward(record)
+		}
+	default:
+		err = errLateRecord
+	}
+
+	if err != nil {
+		pc.Error(err, record)
+	}
+}
+
+// advanceTo advances the stream time if the given time is after it
+func (a *Aggregate) advanceTo(pc streams.ProcessorContext, ts time.Time) {
+	if ts.After(a.streamTime) {
+		a.streamTime = ts
+		a.advance(pc)
+	}
+}
+
+// fixed adds the record to the open tumbling or hopping windows containing it
+func (a *Aggregate) fixed(key []byte, record streams.Record) (err error) {
+	var accepted bool
+	for _, start := range a.windows.assign(record.Time) {
+		end := start.Add(a.windows.Size)
+		if !end.Add(a.grace).After(a.streamTime) {
+			continue
+		}
+		accepted = true
+
+		value, err := a.store.GetWindow(key, start)
+		if err != nil && err != streams.ErrKeyNotFound {
+			return err
+		}
+
+		if value, err = a.aggregator(value, record); err != nil {
+			return err
+		}
+
+		if err = a.store.SetWindow(key, start, value); err != nil {
+			return err
+		}
+		a.track(key, start, end)
+	}
+
+	if !accepted {
+		return errLateRecord
+	}
+	return nil
+}
+
+// session adds the record to a new session, merging the open sessions it joins
+func (a *Aggregate) session(key []byte, record streams.Record) (err error) {
+	start, end := record.Time, record.Time
+	var aggregate []byte
+	var merged []*entry
+
+	for _, e := range a.entries[string(key)] {
+		if e.closed || e.start.After(record.Time.Add(a.windows.Gap)) || e.end.Add(a.windows.Gap).Before(record.Time) {
+			continue
+		}
+
+		value, err := a.store.GetWindow(key, e.start)
+		if err != nil {
+			return err
+		}
+
+		if len(value) < 8 {
+			return errInvalidValue
+		}
+
+		if aggregate == nil {
+			aggregate = value[8:]
+		} else if aggregate, err = a.merger(aggregate, value[8:]); err != nil {
+			return err
+		}
+
+		if e.start.Before(start) {
+			start = e.start
+		}
+		if e.end.After(end) {
+			end = e.end
+		}
+		merged = append(merged, e)
+	}
+
+	// Records not joining open sessions are late if their own session is closed
+	if len(merged) == 0 && !record.Time.Add(a.windows.Gap+a.grace).After(a.streamTime) {
+		return errLateRecord
+	}
+
+	if aggregate, err = a.aggregator(aggregate, record); err != nil {
+		return err
+	}
+
+	for _, e := range merged {
+		if err = a.store.DeleteWindow(key, e.start); err != nil {
+			return err
+		}
+		a.untrack(e)
+	}
+
+	value := make([]byte, 8+len(aggregate))
+	binary.BigEndian.PutUint64(value, uint64(end.UnixNano()))
+	copy(value[8:], aggregate)
+
+	if err = a.store.SetWindow(key, start, value); err != nil {
+		return err
+	}
+	a.track(key, start, end)
+	return nil
+}
+
+// advance forwards the windows closed by the stream time and deletes
+// the closed windows past their retention
+func (a *Aggregate) advance(pc streams.ProcessorContext) {
+	var closed, expired []*entry
+
+	for _, entries := range a.entries {
+		for _, e := range entries {
+			closeAt := a.closeAt(e)
+			if !e.closed && !closeAt.After(a.streamTime) {
+				e.closed = true
+				closed = append(closed, e)
+			}
+
+			if e.closed && !closeAt.Add(a.retention).After(a.streamTime) {
+				expired = append(expired, e)
+			}
+		}
+	}
+
+	sort.Slice(closed, func(i, j int) bool {
+		if closed[i].start.Equal(closed[j].start) {
+			return string(closed[i].key) < string(closed[j].key)
+		}
+		return closed[i].start.Before(closed[j].start)
+	})
+
+	for _, e := range closed {
+		a.emit(pc, e)
+	}
+
+	for _, e := range expired {
+		if err := a.store.DeleteWindow(e.key, e.start); err != nil {
+			pc.Error(err)
+		}
+		a.untrack(e)
+	}
+
+	if len(closed) > 0 || len(expired) > 0 {
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, uint64(a.streamTime.UnixNano()))
+		if err := a.store.Set(a.timeKey, value); err != nil {
+			pc.Error(err)
+		}
+	}
+}
+
+// emit forwards the closed window aggregate
+func (a *Aggregate) emit(pc streams.ProcessorContext, e *entry) {
+	value, err := a.store.GetWindow(e.key, e.start)
+	if err != nil {
+		pc.Error(err)
+		return
+	}
+
+	if a.windows.session() {
+		if len(value) < 8 {
+			pc.Error(errInvalidValue)
+			return
+		}
+		value = value[8:]
+	}
+
+	record := streams.NewRecord(a.name, streams.ByteEncoder(e.key), streams.ByteEncoder(value), e.start, nil)
+	if err = pc.Forward(record); err != nil {
+		pc.Error(err, record)
+	}
+}
+
+// closeAt returns the stream time closing the window
+func (a *Aggregate) closeAt(e *entry) (t time.Time) {
+	return e.end.Add(a.windows.Gap + a.grace)
+}
+
+// track the window, updating its end if already tracked
+func (a *Aggregate) track(key []byte, start, end time.Time) (e *entry) {
+	entries := a.entries[string(key)]
+	idx := sort.Search(len(entries), func(i int) bool { return !entries[i].start.Before(start) })
+
+	if idx < len(entries) && entries[idx].start.Equal(start) {
+		entries[idx].end = end
+		return entries[idx]
+	}
+
+	e = &entry{key: append([]byte(nil), key...), start: start, end: end}
+	entries = append(entries, nil)
+	copy(entries[idx+1:], entries[idx:])
+	entries[idx] = e
+	a.entries[string(key)] = entries
+	return e
+}
+
+// untrack the window
+func (a *Aggregate) untrack(e *entry) {
+	entries := a.entries[string(e.key)]
+	for x := range entries {
+		if entries[x] == e {
+			entries = append(entries[:x], entries[x+1:]...)
+			break
+		}
+	}
+
+	if len(entries) == 0 {
+		delete(a.entries, string(e.key))
+		return
+	}
+	a.entries[string(e.key)] = entries
+}
@@ -0,0 +1,238 @@
+package window
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/brunotm/streams"
+	"github.com/brunotm/streams/mock"
+	"github.com/brunotm/streams/store/sharded"
+	"github.com/stretchr/testify/assert"
+)
+
+// count aggregates the number of records
+func count(aggregate []byte, record streams.Record) ([]byte, error) {
+	n, _ := strconv.Atoi(string(aggregate))
+	return []byte(strconv.Itoa(n + 1)), nil
+}
+
+// sum merges counts
+func sum(a, b []byte) ([]byte, error) {
+	x, _ := strconv.Atoi(string(a))
+	y, _ := strconv.Atoi(string(b))
+	return []byte(strconv.Itoa(x + y)), nil
+}
+
+func newContext(t *testing.T, config streams.Config) (pc *mock.Context) {
+	db := StoreSupplier(sharded.Supplier)()
+	assert.NoError(t, db.(streams.Initializer).Init(&mock.Context{}))
+	return &mock.Context{Data: mock.ContextData{
+		Active:     true,
+		StreamName: "stream",
+		NodeName:   "window",
+		Config:     config,
+		Store:      db,
+	}}
+}
+
+func process(pc *mock.Context, p streams.Processor, key string, t time.Time) {
+	p.Process(pc, streams.NewRecord("test", streams.StringEncoder(key), streams.StringEncoder("v"), t, nil))
+}
+
+func emitted(pc *mock.Context) (results []string) {
+	for _, record := range pc.Data.Forwarded {
+		key, _ := record.EncodeKey()
+		value, _ := record.EncodeValue()
+		results = append(results, record.Topic+":"+string(key)+":"+strconv.FormatInt(record.Time.Unix(), 10)+":"+string(value))
+	}
+	return results
+}
+
+func TestTumbling(t *testing.T) {
+	pc := newContext(t, streams.NewConfig(nil))
+	p := Supplier("counts", Tumbling(10*time.Second), count, nil)()
+	assert.NoError(t, p.(streams.Initializer).Init(pc))
+
+	base := time.Unix(1000, 0)
+	process(pc, p, "a", base.Add(time.Second))
+	process(pc, p, "b", base.Add(2*time.Second))
+	process(pc, p, "a", base.Add(5*time.Second))
+	assert.Len(t, pc.Data.Forwarded, 0)
+
+	process(pc, p, "a", base.Add(12*time.Second))
+	assert.Equal(t, []string{"counts:a:1000:2", "counts:b:1000:1"}, emitted(pc))
+
+	// records for closed windows are dropped
+	process(pc, p, "a", base.Add(3*time.Second))
+	assert.Equal(t, 1, pc.Data.ErrorCount)
+
+	// closed windows are deleted without retention
+	_, err := pc.Data.Store.(streams.WindowedStore).GetWindow([]byte("a"), base)
+	assert.Equal(t, streams.ErrKeyNotFound, err)
+}
+
+func TestHopping(t *testing.T) {
+	config := streams.NewConfig(nil)
+	config.Set("2s", "stream.window.grace")
+	pc := newContext(t, config)
+	p := Supplier("counts", Hopping(10*time.Second, 5*time.Second), count, nil)()
+	assert.NoError(t, p.(streams.Initializer).Init(pc))
+
+	base := time.Unix(1000, 0)
+	process(pc, p, "a", base.Add(7*time.Second))
+	process(pc, p, "a", base.Add(11*time.Second))
+
+	// records within the grace period are accepted
+	process(pc, p, "a", base.Add(3*time.Second))
+	process(pc, p, "a", base.Add(12*time.Second))
+	assert.Equal(t, []string{"counts:a:1000:2"}, emitted(pc))
+	assert.Equal(t, 0, pc.Data.ErrorCount)
+
+	process(pc, p, "a", base.Add(30*time.Second))
+	assert.Equal(t, []string{"counts:a:1000:2", "counts:a:1005:3", "counts:a:1010:2"}, emitted(pc))
+}
+
+func TestSession(t *testing.T) {
+	config := streams.NewConfig(nil)
+	config.Set("10s", "stream.window.grace")
+	config.Set("1m", "stream.window.retention")
+	pc := newContext(t, config)
+	p := Supplier("sessions", Session(5*time.Second), count, sum)()
+	assert.NoError(t, p.(streams.Initializer).Init(pc))
+
+	base := time.Unix(1000, 0)
+	process(pc, p, "a", base)
+	process(pc, p, "a", base.Add(8*time.Second))
+	process(pc, p, "b", base.Add(9*time.Second))
+
+	// a late record within the grace period bridging two sessions merges them
+	process(pc, p, "a", base.Add(4*time.Second))
+	store := pc.Data.Store.(streams.WindowedStore)
+	var starts []int64
+	assert.NoError(t, store.FetchWindows([]byte("a"), base, base.Add(time.Minute), func(start time.Time, value []byte) error {
+		starts = append(starts, start.Unix())
+		assert.Equal(t, "3", string(value[8:]))
+		return nil
+	}))
+	assert.Equal(t, []int64{1000}, starts)
+
+	process(pc, p, "c", base.Add(30*time.Second))
+	assert.Equal(t, []string{"sessions:a:1000:3", "sessions:b:1009:1"}, emitted(pc))
+
+	// closed sessions are kept for the retention and resumed on restart
+	_, err := store.GetWindow([]byte("a"), base)
+	assert.NoError(t, err)
+
+	p = Supplier("sessions", Session(5*time.Second), count, sum)()
+	assert.NoError(t, p.(streams.Initializer).Init(pc))
+	process(pc, p, "c", base.Add(90*time.Second))
+	assert.Equal(t, []string{"sessions:a:1000:3", "sessions:b:1009:1", "sessions:c:1030:1"}, emitted(pc))
+
+	_, err = store.GetWindow([]byte("a"), base)
+	assert.Equal(t, streams.ErrKeyNotFound, err)
+}
+
+func TestInvalidWindows(t *testing.T) {
+	pc := newContext(t, streams.NewConfig(nil))
+	assert.Equal(t, errInvalidWindows, Supplier("w", Hopping(time.Second, time.Minute), count, nil)().(streams.Initializer).Init(pc))
+	assert.Equal(t, errMergerRequired, Supplier("w", Session(time.Second), count, nil)().(streams.Initializer).Init(pc))
+}
+
+func TestWatermarkWindows(t *testing.T) {
+	config := streams.NewConfig(nil)
+	config.Set("watermark", "stream.window.time")
+	config.Set("forward", "stream.window.late")
+	config.Set("late", "stream.window.lateto")
+	pc := newContext(t, config)
+	p := Supplier("counts", Tumbling(10*time.Second), count, nil)()
+	assert.NoError(t, p.(streams.Initializer).Init(pc))
+	assert.Len(t, pc.Data.Punctuators, 1)
+
+	// out of order records within the watermark are aggregated
+	base := time.Unix(1000, 0)
+	pc.Data.Watermark = base.Add(time.Second)
+	process(pc, p, "a", base.Add(12*time.Second))
+	process(pc, p, "a", base.Add(3*time.Second))
+	assert.Len(t, pc.Data.Forwarded, 0)
+
+	// windows close as the watermark advances
+	pc.Data.Watermark = base.Add(10 * time.Second)
+	pc.Punctuate(streams.WallClockTime, time.Now())
+	assert.Equal(t, []string{"counts:a:1000:1"}, emitted(pc))
+
+	// late records are forwarded to the late node
+	process(pc, p, "a", base.Add(4*time.Second))
+	assert.Equal(t, []string{"late"}, pc.Data.ForwardedTo)
+	assert.Equal(t, 0, pc.Data.ErrorCount)
+
+	config.Set("drop", "stream.window.late")
+	pc = newContext(t, config)
+	p = Supplier("counts", Tumbling(10*time.Second), count, nil)()
+	assert.NoError(t, p.(streams.Initializer).Init(pc))
+	pc.Data.Watermark = base.Add(20 * time.Second)
+	process(pc, p, "a", base.Add(4*time.Second))
+	assert.Equal(t, 0, pc.Data.ErrorCount)
+	assert.Len(t, pc.Data.ForwardedTo, 0)
+
+	config.Set("event", "stream.window.time")
+	assert.Error(t, Supplier("counts", Tumbling(10*time.Second), count, nil)().(streams.Initializer).Init(newContext(t, config)))
+}
+
+func TestWindowExactlyOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "window")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := streams.NewConfig(nil)
+	config.Set(dir, "stream", "state", "dir")
+	config.Set("10ms", "stream", "checkpoint", "interval")
+
+	base := time.Unix(1000, 0)
+	var records []streams.Record
+	for _, key := range []string{"a", "b", "a"} {
+		records = append(records, streams.NewRecord("test", streams.StringEncoder(key), streams.StringEncoder("v"), base, nil))
+	}
+
+	b := streams.NewBuilder("stream", config)
+	b.Guarantee(streams.ExactlyOnce)
+	assert.NoError(t, b.AddStore("counts", StoreSupplier(sharded.Supplier)))
+	assert.NoError(t, b.AddSource("source", func() streams.Source {
+		return &mock.Source{Records: records}
+	}))
+	assert.NoError(t, b.AddSink("window", Supplier("counts", Tumbling(10*time.Second), count, nil), "source"))
+
+	stream, err := b.Build()
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Start())
+	defer stream.Close()
+
+	store, err := stream.Store("counts")
+	assert.NoError(t, err)
+
+	// window mutations are buffered by the stream and committed by checkpoints
+	var value []byte
+	for x := 0; x < 100 && string(value) != "2"; x++ {
+		time.Sleep(10 * time.Millisecond)
+		value, _ = store.(streams.WindowedStore).GetWindow([]byte("a"), base)
+	}
+	assert.Equal(t, "2", string(value))
+}
@@ -0,0 +1,194 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/binary"
+	"sort"
+	"time"
+)
+
+// wireVersion is the version of the record wire format
+const wireVersion byte = 1
+
+// wire format flags
+const (
+	wireTime byte = 1 << iota
+)
+
+var (
+	// ErrInvalidRecord is returned when unmarshalling malformed record data
+	// or data of an unknown wire format version.
+	ErrInvalidRecord = Errorf(CodeSerialization, "invalid record wire format")
+)
+
+// MarshalRecord encodes the record in the canonical wire format shared by
+// bridges, durable edges, overflow segments and dead letters:
+//
+//	version:  1 byte, currently 1
+//	flags:    1 byte, bit 0 set if the record has a time
+//	id:       8 bytes big endian
+//	priority: varint
+//	time:     varint unix nanoseconds, only if flagged
+//	topic, tenant, key and value: varint length prefixed bytes, -1 if nil
+//	headers:  uvarint count of name and value pairs in name order,
+//	          as varint length prefixed bytes
+//
+// Key and value are encoded with the record encoders.
+func MarshalRecord(record Record) (data []byte, err error) {
+	key, err := record.EncodeKey()
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := record.EncodeValue()
+	if err != nil {
+		return nil, err
+	}
+
+	size := 10 + len(record.Topic) + len(record.Tenant) + len(key) + len(value) + 7*binary.MaxVarintLen64
+	for name, header := range record.Headers {
+		size += len(name) + len(header) + 2*binary.MaxVarintLen64
+	}
+
+	data = make([]byte, 10, size)
+	data[0] = wireVersion
+	binary.BigEndian.PutUint64(data[2:], record.id)
+
+	var tmp [binary.MaxVarintLen64]byte
+	data = append(data, tmp[:binary.PutVarint(tmp[:], int64(record.Priority))]...)
+	if !record.Time.IsZero() {
+		data[1] |= wireTime
+		data = append(data, tmp[:binary.PutVarint(tmp[:], record.Time.UnixNano())]...)
+	}
+
+	data = appendWireField(data, []byte(record.Topic), false)
+	data = appendWireField(data, []byte(record.Tenant), false)
+	data = appendWireField(data, key, record.Key == nil)
+	data = appendWireField(data, value, record.Value == nil)
+
+	names := make([]string, 0, len(record.Headers))
+	for name := range record.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	data = append(data, tmp[:binary.PutUvarint(tmp[:], uint64(len(names)))]...)
+	for _, name := range names {
+		data = appendWireField(data, []byte(name), false)
+		data = appendWireField(data, []byte(record.Headers[name]), false)
+	}
+
+	return data, nil
+}
+
+// UnmarshalRecord decodes a record in the canonical wire format, with
+// byte encoders for its key and value. The optional ack function is called
+// once the record and all copies forwarded within the stream are processed,
+// as with NewRecord. The key and value reference the given data.
+func UnmarshalRecord(data []byte, ack func() error) (record Record, err error) {
+	if len(data) < 10 || data[0] != wireVersion {
+		return record, ErrInvalidRecord
+	}
+
+	flags := data[1]
+	record.id = binary.BigEndian.Uint64(data[2:])
+	data = data[10:]
+
+	priority, n := binary.Varint(data)
+	if n <= 0 {
+		return record, ErrInvalidRecord
+	}
+	record.Priority = int(priority)
+	data = data[n:]
+
+	if flags&wireTime != 0 {
+		ts, n := binary.Varint(data)
+		if n <= 0 {
+			return record, ErrInvalidRecord
+		}
+		record.Time = time.Unix(0, ts)
+		data = data[n:]
+	}
+
+	var fields [4][]byte
+	for x := range fields {
+		if fields[x], data, err = readWireField(data); err != nil {
+			return record, err
+		}
+	}
+
+	record.Topic = string(fields[0])
+	record.Tenant = string(fields[1])
+	if fields[2] != nil {
+		record.Key = ByteEncoder(fields[2])
+	}
+	if fields[3] != nil {
+		record.Value = ByteEncoder(fields[3])
+	}
+
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return record, ErrInvalidRecord
+	}
+	data = data[n:]
+
+	if count > 0 {
+		record.Headers = make(map[string]string, count)
+	}
+
+	for x := uint64(0); x < count; x++ {
+		var name, header []byte
+		if name, data, err = readWireField(data); err != nil {
+			return record, err
+		}
+		if header, data, err = readWireField(data); err != nil {
+			return record, err
+		}
+		record.Headers[string(name)] = string(header)
+	}
+
+	record.ack = newAcker(ack)
+	record.enc = &encoding{key: record.Key, value: record.Value}
+	return record, nil
+}
+
+// appendWireField appends the varint length prefixed field, -1 if null
+func appendWireField(data, field []byte, null bool) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	if null {
+		return append(data, tmp[:binary.PutVarint(tmp[:], -1)]...)
+	}
+
+	data = append(data, tmp[:binary.PutVarint(tmp[:], int64(len(field)))]...)
+	return append(data, field...)
+}
+
+// readWireField reads a varint length prefixed field, nil if null
+func readWireField(data []byte) (field, rest []byte, err error) {
+	size, n := binary.Varint(data)
+	if n <= 0 || size < -1 || int64(len(data)-n) < size {
+		return nil, nil, ErrInvalidRecord
+	}
+
+	data = data[n:]
+	if size == -1 {
+		return nil, data, nil
+	}
+
+	return data[:size:size], data[size:], nil
+}
@@ -0,0 +1,82 @@
+package streams
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordWireFormat(t *testing.T) {
+	acked := 0
+	ack := func() error {
+		acked++
+		return nil
+	}
+
+	ts := time.Unix(0, 1234567890)
+	record := NewRecord("topic", StringEncoder("key"), StringEncoder("value"), ts, nil)
+	record = record.WithTenant("tenant").WithHeader("b", "2").WithHeader("a", "1")
+	record.Priority = -3
+
+	data, err := MarshalRecord(record)
+	assert.NoError(t, err)
+
+	// headers are encoded in name order
+	other := record.WithHeader("a", "1")
+	encoded, err := MarshalRecord(other)
+	assert.NoError(t, err)
+	assert.Equal(t, data, encoded)
+
+	decoded, err := UnmarshalRecord(data, ack)
+	assert.NoError(t, err)
+	assert.Equal(t, record.id, decoded.id)
+	assert.Equal(t, "topic", decoded.Topic)
+	assert.Equal(t, "tenant", decoded.Tenant)
+	assert.Equal(t, -3, decoded.Priority)
+	assert.True(t, ts.Equal(decoded.Time))
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, decoded.Headers)
+
+	key, _ := decoded.EncodeKey()
+	value, _ := decoded.EncodeValue()
+	assert.Equal(t, "key", string(key))
+	assert.Equal(t, "value", string(value))
+
+	assert.NoError(t, decoded.Ack())
+	assert.Equal(t, 1, acked)
+
+	// nil keys, empty values and zero times are preserved
+	data, err = MarshalRecord(NewRecord("topic", nil, ByteEncoder{}, time.Time{}, nil))
+	assert.NoError(t, err)
+	decoded, err = UnmarshalRecord(data, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, decoded.Key)
+	assert.Equal(t, ByteEncoder{}, decoded.Value)
+	assert.True(t, decoded.Time.IsZero())
+	assert.Nil(t, decoded.Headers)
+
+	for x := range data {
+		_, err = UnmarshalRecord(data[:x], nil)
+		assert.Equal(t, ErrInvalidRecord, err)
+	}
+
+	data[0] = wireVersion + 1
+	_, err = UnmarshalRecord(data, nil)
+	assert.Equal(t, ErrInvalidRecord, err)
+}
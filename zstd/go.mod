@@ -0,0 +1,20 @@
+module github.com/brunotm/streams/zstd
+
+go 1.22
+
+require (
+	github.com/brunotm/streams v0.0.0-00010101000000-000000000000
+	github.com/klauspost/compress v1.18.0
+	github.com/stretchr/testify v1.3.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/dgryski/go-jump v0.0.0-20170409065014-e1f439676b57 // indirect
+	github.com/dgryski/go-wyhash v0.0.0-20190311210714-ffed7bd65e77 // indirect
+	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/spf13/cast v1.3.0 // indirect
+)
+
+replace github.com/brunotm/streams => ../
@@ -0,0 +1,66 @@
+package zstd
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"github.com/brunotm/streams"
+	"github.com/klauspost/compress/zstd"
+)
+
+// make sure we implement the needed interfaces
+var _ streams.Compressor = (*Compressor)(nil)
+
+// Compressor is a pure Go zstd compressor. Importing the package registers it
+// as streams.CompressionZstd with the zstd name, making it available to the
+// compression configs of changelogs, durable edges and leveldb values.
+// It is a separate module as its implementation requires newer Go versions.
+type Compressor struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func init() {
+	c, err := New()
+	if err == nil {
+		err = streams.RegisterCompressor(streams.CompressionZstd, "zstd", c)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// New creates a zstd compressor with the default compression level
+func New() (c *Compressor, err error) {
+	c = &Compressor{}
+	if c.encoder, err = zstd.NewWriter(nil); err != nil {
+		return nil, err
+	}
+	if c.decoder, err = zstd.NewReader(nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Compress appends the data compressed with zstd to dst
+func (c *Compressor) Compress(dst, src []byte) (data []byte, err error) {
+	return c.encoder.EncodeAll(src, dst), nil
+}
+
+// Decompress appends the zstd data decompressed to dst
+func (c *Compressor) Decompress(dst, src []byte) (data []byte, err error) {
+	return c.decoder.DecodeAll(src, dst)
+}
@@ -0,0 +1,45 @@
+package zstd
+
+/*
+   Copyright 2018 Bruno Moura <brunotm@gmail.com>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/brunotm/streams"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressor(t *testing.T) {
+	value := bytes.Repeat([]byte("value"), 100)
+
+	c, err := streams.CompressionOf("zstd")
+	assert.NoError(t, err)
+	assert.Equal(t, streams.CompressionZstd, c)
+
+	data, err := c.Compress([]byte("prefix"), value)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("prefix"), data[:6])
+	assert.True(t, len(data) < len(value))
+
+	data, err = c.Decompress([]byte("prefix"), data[6:])
+	assert.NoError(t, err)
+	assert.Equal(t, append([]byte("prefix"), value...), data)
+
+	_, err = c.Decompress(nil, value)
+	assert.Error(t, err)
+}